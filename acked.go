@@ -0,0 +1,394 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Two magic bytes followed by the broadcast id
+const ackedHeaderSize = wire.AckedHeaderSize
+
+// Number of times a broadcast is repeated to members which have not
+// acknowledged it yet, spread evenly over the timeout
+const ackedAttempts = 4
+
+var ErrAckedPayload = errors.New("Broadcast payload too large")
+
+// Outcome of BroadcastAcked: the members which confirmed the broadcast,
+// those which rejected it, those which did not answer before the deadline
+// and those CancelPending gave up on, each sorted by name. Reasons holds
+// the reason given by each member which rejected it and the reason passed
+// to CancelPending for each canceled one.
+type AckResult struct {
+	Confirmed []string
+	Rejected  []string
+	Missing   []string
+	Canceled  []string
+	Reasons   map[string]string
+}
+
+// Complete reports whether every targeted member confirmed.
+func (r AckResult) Complete() bool {
+	return len(r.Missing) == 0 && len(r.Rejected) == 0 && len(r.Canceled) == 0
+}
+
+// Sends broadcasts directly to each member and collects their
+// acknowledgements. Incoming broadcasts are handed to the delivery
+// callback and acknowledged, or negatively acknowledged if the callback
+// rejected them; repeated copies are answered again but delivered only
+// once.
+type Acker struct {
+	conn    *transport.Conn
+	clock   transport.Clock
+	deliver func(payload []byte, from *net.UDPAddr) error
+
+	// Broadcasts awaiting acknowledgements, including their retransmissions
+	inflight transport.Outstanding
+
+	mutex sync.Mutex
+	next  uint64
+	// broadcasts awaiting acknowledgements, by id
+	pending map[uint64]*ackedBroadcast
+	// outcome of the delivery of ids already received per origin, to
+	// drop repeated copies
+	seen *idCache
+}
+
+type ackedBroadcast struct {
+	// member names by address and addresses by name, snapshot taken at
+	// initiation and updated by MovePeer
+	targets  map[netip.AddrPort]string
+	addrs    map[string]*net.UDPAddr
+	acked    map[string]bool
+	rejected map[string]string
+	canceled map[string]string
+	done     chan bool
+
+	started  time.Time
+	attempts int
+}
+
+// Whether the member has answered or was given up on; must hold the mutex.
+func (b *ackedBroadcast) settled(name string) bool {
+	_, rejected := b.rejected[name]
+	_, canceled := b.canceled[name]
+	return b.acked[name] || rejected || canceled
+}
+
+// Signal the broadcast once every member settled; must hold the mutex.
+func (b *ackedBroadcast) check() {
+	if len(b.acked)+len(b.rejected)+len(b.canceled) == len(b.targets) {
+		close(b.done)
+	}
+}
+
+// Delivery of a received broadcast; the error is nil until it completed.
+type ackedDelivery struct {
+	done bool
+	err  error
+}
+
+// Register an acknowledging handler with conn. The deliver callback is
+// invoked once per broadcast received from another member.
+func NewAcker(conn *transport.Conn, deliver func(payload []byte, from *net.UDPAddr)) *Acker {
+	if deliver == nil {
+		return NewCheckedAcker(conn, nil)
+	}
+	return NewCheckedAcker(conn, func(payload []byte, from *net.UDPAddr) error {
+		deliver(payload, from)
+		return nil
+	})
+}
+
+// Register an acknowledging handler like NewAcker whose callback may
+// reject a broadcast. The origin then receives a negative acknowledgement
+// with the text of the error and lists the member under Rejected rather
+// than Missing. The handler is a checked one, so rejections are counted in
+// the handler stats of conn and published as HandlerErrorEvents if enabled.
+func NewCheckedAcker(conn *transport.Conn, deliver func(payload []byte, from *net.UDPAddr) error) *Acker {
+	acker := &Acker{
+		conn:    conn,
+		clock:   transport.RealClock,
+		deliver: deliver,
+		next:    conn.Rand().Uint64(),
+		pending: make(map[uint64]*ackedBroadcast),
+		seen:    newIDCache(DefaultCacheLimit),
+	}
+	conn.AddCheckedHandler("", acker.dispatch)
+	return acker
+}
+
+// Replace the source of time used for timeouts and retransmissions.
+func (acker *Acker) SetClock(clock transport.Clock) {
+	acker.clock = clock
+}
+
+// Limit the ids of delivered broadcasts remembered to drop repeated copies.
+func (acker *Acker) SetCacheLimit(n int) {
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	acker.seen.setLimit(n)
+}
+
+func (acker *Acker) CacheStats() CacheStats {
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	return acker.seen.stats()
+}
+
+// Send payload to every member and wait until all of them acknowledged it
+// or the timeout elapsed. The members map is copied before anything is
+// sent, so members which join while the broadcast is in flight are not
+// waited for.
+func (acker *Acker) BroadcastAcked(members map[string]*net.UDPAddr, payload []byte, timeout time.Duration) (AckResult, error) {
+	if len(payload)+ackedHeaderSize > acker.conn.MaxPayload() {
+		return AckResult{}, ErrAckedPayload
+	}
+	acker.inflight.Add(1)
+	defer acker.inflight.Done()
+
+	b := &ackedBroadcast{
+		targets:  make(map[netip.AddrPort]string, len(members)),
+		addrs:    make(map[string]*net.UDPAddr, len(members)),
+		acked:    make(map[string]bool, len(members)),
+		rejected: make(map[string]string),
+		canceled: make(map[string]string),
+		done:     make(chan bool),
+		started:  acker.clock.Now(),
+	}
+	for name, addr := range members {
+		b.targets[addrKey(addr)] = name
+		b.addrs[name] = addr
+	}
+
+	acker.mutex.Lock()
+	acker.next++
+	id := acker.next
+	acker.mutex.Unlock()
+
+	msg, err := wire.Acked{ID: id, Payload: payload}.Encode(wire.Version(acker.conn.EncodeVersion()))
+	if err != nil {
+		return AckResult{}, err
+	}
+	acker.mutex.Lock()
+	acker.pending[id] = b
+	if len(b.targets) == 0 {
+		close(b.done)
+	}
+	acker.mutex.Unlock()
+
+	deadline := acker.clock.After(timeout)
+	for attempt := 0; ; attempt++ {
+		if attempt < ackedAttempts {
+			acker.mutex.Lock()
+			var missing []*net.UDPAddr
+			for name, addr := range b.addrs {
+				if !b.settled(name) {
+					missing = append(missing, addr)
+				}
+			}
+			b.attempts++
+			acker.mutex.Unlock()
+			for _, addr := range missing {
+				if err := acker.conn.SendScheduled(msg, addr, transport.PacketMeta{Repeat: true}); err != nil {
+					acker.finish(id)
+					return acker.result(b), err
+				}
+			}
+		}
+
+		retry := acker.clock.After(timeout / ackedAttempts)
+		select {
+		case <-b.done:
+		case <-deadline:
+		case <-retry:
+			continue
+		}
+		break
+	}
+	acker.finish(id)
+	return acker.result(b), nil
+}
+
+// Channel which is closed once no broadcast is awaiting acknowledgements.
+func (acker *Acker) Quiesced() <-chan struct{} {
+	return acker.inflight.Quiesced()
+}
+
+// Broadcasts awaiting an acknowledgement from the peer, oldest first
+func (acker *Acker) PendingTo(peer *net.UDPAddr) []PendingSend {
+	now := acker.clock.Now()
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	var sends []PendingSend
+	for id, b := range acker.pending {
+		if name, ok := b.targets[addrKey(peer)]; ok && !b.settled(name) {
+			sends = append(sends, PendingSend{id, b.addrs[name], now.Sub(b.started), b.attempts})
+		}
+	}
+	sort.Slice(sends, func(i, j int) bool { return sends[i].Age > sends[j].Age })
+	return sends
+}
+
+// Stop waiting for the peer in every broadcast it has not answered yet:
+// it is listed under Canceled with the reason, and a broadcast returns
+// once no other member is outstanding. Returns the number of broadcasts
+// affected. An acknowledgement which arrived first still wins.
+func (acker *Acker) CancelPending(peer *net.UDPAddr, reason string) int {
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	n := 0
+	for _, b := range acker.pending {
+		name, ok := b.targets[addrKey(peer)]
+		if !ok || b.settled(name) {
+			continue
+		}
+		b.canceled[name] = reason
+		b.check()
+		n++
+	}
+	return n
+}
+
+// Follow a member which moved to another address: the retransmissions of
+// broadcasts it has not acknowledged go to the new address, where its
+// acknowledgements are expected, and the broadcasts it sent before are
+// still delivered only once.
+func (acker *Acker) MovePeer(from, to *net.UDPAddr) {
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	for _, b := range acker.pending {
+		name, ok := b.targets[addrKey(from)]
+		if !ok {
+			continue
+		}
+		delete(b.targets, addrKey(from))
+		b.targets[addrKey(to)] = name
+		b.addrs[name] = to
+	}
+	acker.seen.rekey(addrKey(from), addrKey(to))
+}
+
+func (acker *Acker) finish(id uint64) {
+	acker.mutex.Lock()
+	delete(acker.pending, id)
+	acker.mutex.Unlock()
+}
+
+func (acker *Acker) result(b *ackedBroadcast) AckResult {
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+
+	var r AckResult
+	reason := func(name, reason string) {
+		if r.Reasons == nil {
+			r.Reasons = make(map[string]string)
+		}
+		r.Reasons[name] = reason
+	}
+	for _, name := range b.targets {
+		if why, ok := b.rejected[name]; ok {
+			r.Rejected = append(r.Rejected, name)
+			reason(name, why)
+		} else if why, ok := b.canceled[name]; ok {
+			r.Canceled = append(r.Canceled, name)
+			reason(name, why)
+		} else if b.acked[name] {
+			r.Confirmed = append(r.Confirmed, name)
+		} else {
+			r.Missing = append(r.Missing, name)
+		}
+	}
+	sort.Strings(r.Confirmed)
+	sort.Strings(r.Rejected)
+	sort.Strings(r.Missing)
+	sort.Strings(r.Canceled)
+	return r
+}
+
+func (acker *Acker) dispatch(conn *transport.Conn, p *transport.Packet) error {
+	if m, _, err := wire.DecodeAcked(p.Msg); err == nil {
+		key := cacheKey{addrKey(p.Addr), m.ID}
+		acker.mutex.Lock()
+		d, duplicate := acker.seen.get(key)
+		if !duplicate {
+			d = new(ackedDelivery)
+			acker.seen.add(key, d)
+		}
+		acker.mutex.Unlock()
+
+		delivery := d.(*ackedDelivery)
+		if !duplicate {
+			var err error
+			if acker.deliver != nil {
+				err = acker.deliver(m.Payload, p.Addr)
+			}
+			acker.mutex.Lock()
+			delivery.done, delivery.err = true, err
+			acker.mutex.Unlock()
+			acker.answer(conn, p, m.ID, err)
+			return err
+		}
+
+		// a copy of a broadcast still being delivered is answered later
+		acker.mutex.Lock()
+		done, err := delivery.done, delivery.err
+		acker.mutex.Unlock()
+		if done {
+			acker.answer(conn, p, m.ID, err)
+		}
+		return nil
+	}
+
+	id, reason, nack := uint64(0), "", false
+	if ack, _, err := wire.DecodeAck(p.Msg); err == nil {
+		id = ack.ID
+	} else if n, _, err := wire.DecodeNack(p.Msg); err == nil {
+		id, reason, nack = n.ID, n.Reason, true
+	} else {
+		return nil
+	}
+	acker.mutex.Lock()
+	defer acker.mutex.Unlock()
+	b, ok := acker.pending[id]
+	if !ok {
+		return nil
+	}
+	name, ok := b.targets[addrKey(p.Addr)]
+	if !ok || b.settled(name) {
+		return nil
+	}
+	if nack {
+		b.rejected[name] = reason
+	} else {
+		b.acked[name] = true
+	}
+	b.check()
+	return nil
+}
+
+// Acknowledge the broadcast, negatively with the text of err, as much of
+// it as fits, if delivery rejected it.
+func (acker *Acker) answer(conn *transport.Conn, p *transport.Packet, id uint64, err error) {
+	v := wire.Version(conn.EncodeVersion())
+	var msg []byte
+	if err == nil {
+		msg, err = wire.Ack{ID: id}.Encode(v)
+	} else {
+		reason := err.Error()
+		if limit := conn.MaxPayloadTo(p.Addr) - ackedHeaderSize; len(reason) > limit {
+			reason = reason[:max(limit, 0)]
+		}
+		msg, err = wire.Nack{ID: id, Reason: reason}.Encode(v)
+	}
+	if err == nil {
+		conn.Reply(p, msg)
+	}
+}
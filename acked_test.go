@@ -0,0 +1,181 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func startAcker(t *testing.T, deliver func([]byte, *net.UDPAddr)) (*Acker, *net.UDPAddr) {
+	conn := transport.NewConn()
+	acker := NewAcker(conn, deliver)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	port := (<-conn.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	return acker, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+func TestBroadcastAcked(t *testing.T) {
+	var mutex sync.Mutex
+	delivered := make(map[string]int)
+	members := make(map[string]*net.UDPAddr)
+
+	origin, _ := startAcker(t, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		_, addr := startAcker(t, func(payload []byte, from *net.UDPAddr) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			delivered[name]++
+			if string(payload) != "config" {
+				t.Errorf("TestBroadcastAcked expected %q got %q.", "config", payload)
+			}
+		})
+		members[name] = addr
+	}
+
+	// partitioned member receives the broadcast but never answers
+	partitioned, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer partitioned.Close()
+	members["d"] = partitioned.LocalAddr().(*net.UDPAddr)
+
+	result, err := origin.BroadcastAcked(members, []byte("config"), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AckResult{Confirmed: []string{"a", "b", "c"}, Missing: []string{"d"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("TestBroadcastAcked expected %+v got %+v.", expected, result)
+	}
+	if result.Complete() {
+		t.Fatalf("TestBroadcastAcked expected an incomplete broadcast.")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, name := range expected.Confirmed {
+		if delivered[name] != 1 {
+			t.Errorf("TestBroadcastAcked expected one delivery to %s got %d.", name, delivered[name])
+		}
+	}
+}
+
+func TestBroadcastAckedSnapshot(t *testing.T) {
+	origin, _ := startAcker(t, nil)
+	_, addr := startAcker(t, nil)
+
+	members := map[string]*net.UDPAddr{"a": addr}
+	if result, _ := origin.BroadcastAcked(nil, nil, time.Second); !result.Complete() {
+		t.Fatalf("TestBroadcastAckedSnapshot expected an empty broadcast to complete got %+v.", result)
+	}
+	if _, err := origin.BroadcastAcked(members, make([]byte, transport.MessageSize), time.Second); err != ErrAckedPayload {
+		t.Fatalf("TestBroadcastAckedSnapshot expected %q got %v.", ErrAckedPayload, err)
+	}
+
+	// returns as soon as every member confirmed rather than at the deadline
+	start := time.Now()
+	result, err := origin.BroadcastAcked(members, []byte("config"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (AckResult{Confirmed: []string{"a"}}); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("TestBroadcastAckedSnapshot expected %+v got %+v.", expected, result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("TestBroadcastAckedSnapshot expected to return once all confirmed got %v.", elapsed)
+	}
+}
+
+func TestBroadcastNacked(t *testing.T) {
+	origin, _ := startAcker(t, nil)
+	_, accepting := startAcker(t, nil)
+
+	conn := transport.NewConn()
+	var mutex sync.Mutex
+	calls := 0
+	rejecting := NewCheckedAcker(conn, func(payload []byte, from *net.UDPAddr) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return errors.New("stale config")
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	stale := conn.LocalAddr()
+
+	members := map[string]*net.UDPAddr{"a": accepting, "b": {IP: net.IPv4(127, 0, 0, 1), Port: stale.Port}}
+	start := time.Now()
+	result, err := origin.BroadcastAcked(members, []byte("config"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AckResult{Confirmed: []string{"a"}, Rejected: []string{"b"}, Reasons: map[string]string{"b": "stale config"}}
+	if !reflect.DeepEqual(result, expected) || result.Complete() {
+		t.Fatalf("TestBroadcastNacked expected %+v got %+v.", expected, result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("TestBroadcastNacked expected the rejection to end the broadcast got %v.", elapsed)
+	}
+
+	// the handler is accounted for once it returned, after the reply
+	for i := 0; rejecting.conn.Stats().Handlers[0].Errors != 1; i++ {
+		if i == 100 {
+			t.Fatalf("TestBroadcastNacked expected one counted rejection got %+v.", rejecting.conn.Stats().Handlers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls != 1 {
+		t.Fatalf("TestBroadcastNacked expected one delivery got %d.", calls)
+	}
+}
+
+func TestBroadcastAckedDedup(t *testing.T) {
+	origin, _ := startAcker(t, nil)
+	member, addr := startAcker(t, nil)
+	for _, a := range []*Acker{origin, member} {
+		if err := a.conn.SetOutboundDedup(transport.MaxDedupHorizon, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first copy and the first acknowledgement are lost, so that both
+	// retransmissions fall within the horizon of the previous ones
+	var dropped atomic.Int32
+	lose := func(p *transport.Packet) (*transport.Packet, error) {
+		if dropped.Add(1) == 1 {
+			return nil, nil
+		}
+		return p, nil
+	}
+	member.conn.Use(lose)
+	var acks atomic.Int32
+	origin.conn.Use(func(p *transport.Packet) (*transport.Packet, error) {
+		if acks.Add(1) == 1 {
+			return nil, nil
+		}
+		return p, nil
+	})
+
+	result, err := origin.BroadcastAcked(map[string]*net.UDPAddr{"a": addr}, []byte("config"), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Complete() {
+		t.Fatalf("TestBroadcastAckedDedup expected retransmissions to pass the dedup got %+v.", result)
+	}
+}
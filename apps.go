@@ -0,0 +1,88 @@
+package gossip
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	ErrUnknownMember = errors.New("Member is not listed or has no address")
+	ErrNoAppHandler  = errors.New("No handler for the application frame type")
+)
+
+// Receives the payload of an application frame and the name of the member
+// which sent it. The payload is only valid during the call.
+type AppHandler func(from string, payload []byte)
+
+// Application traffic sharing a connection with the protocol. Frames carry
+// a type chosen by the application and the name of the sender, and are
+// addressed by member name, resolved through a MemberTable at the time of
+// sending, so they follow members to new addresses. Protocol messages use
+// magic bytes of their own, so they never reach the handlers registered
+// here; handlers registered on the Conn directly still see everything.
+type Apps struct {
+	conn    *transport.Conn
+	name    string
+	members *MemberTable
+
+	mutex    sync.Mutex
+	handlers map[uint16]AppHandler
+}
+
+// Register the dispatcher of the application frames with conn; name is
+// the one of the local member which the receivers see as the sender.
+func NewApps(conn *transport.Conn, name string, members *MemberTable) *Apps {
+	apps := &Apps{conn: conn, name: name, members: members, handlers: make(map[uint16]AppHandler)}
+	conn.AddCheckedHandler("apps", apps.dispatch)
+	return apps
+}
+
+// Set the handler of the frames of the given type, replacing any before;
+// nil removes it. Frames of types without a handler are rejected with
+// ErrNoAppHandler.
+func (apps *Apps) Handle(typ uint16, h AppHandler) {
+	apps.mutex.Lock()
+	defer apps.mutex.Unlock()
+	if h == nil {
+		delete(apps.handlers, typ)
+		return
+	}
+	apps.handlers[typ] = h
+}
+
+// Send a frame of the given type to the named member at its current
+// address. Returns ErrUnknownMember if the member is not listed, has
+// no address or is gone.
+func (apps *Apps) Send(member string, typ uint16, payload []byte) error {
+	m, ok := apps.members.Get(member)
+	if !ok || m.Addr == nil || m.State.gone() {
+		return ErrUnknownMember
+	}
+	msg, err := wire.App{Type: typ, From: apps.name, Payload: payload}.Encode(wire.Version(apps.conn.EncodeVersion()))
+	if err != nil {
+		return err
+	}
+	return apps.conn.SendTo(msg, m.Addr)
+}
+
+func (apps *Apps) dispatch(conn *transport.Conn, p *transport.Packet) error {
+	frame, _, err := wire.DecodeApp(p.Msg)
+	switch err {
+	case nil:
+	case wire.ErrKind:
+		return nil
+	default:
+		return err
+	}
+	apps.mutex.Lock()
+	h := apps.handlers[frame.Type]
+	apps.mutex.Unlock()
+	if h == nil {
+		return ErrNoAppHandler
+	}
+	h(frame.From, frame.Payload)
+	return nil
+}
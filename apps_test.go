@@ -0,0 +1,76 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+)
+
+type appFrame struct {
+	from, payload string
+}
+
+func TestApps(t *testing.T) {
+	g := gossiptest.NewGroup(t, 3)
+	table := newTestTable(t)
+	table.Update(Member{Name: "b", Addr: g.Addrs[1], Incarnation: 1})
+	sender := NewApps(g.Conns[0], "a", table)
+	receiver := NewApps(g.Conns[1], "b", newTestTable(t))
+	moved := NewApps(g.Conns[2], "b", newTestTable(t))
+
+	frames := make(chan appFrame, 8)
+	for _, apps := range []*Apps{receiver, moved} {
+		apps.Handle(7, func(from string, payload []byte) { frames <- appFrame{from, string(payload)} })
+	}
+	// the protocol shares the connections
+	NewAcker(g.Conns[0], nil)
+	NewAcker(g.Conns[1], nil)
+	NewRequester(g.Conns[1], func(req []byte, from *net.UDPAddr) []byte { return req })
+
+	if result, err := NewAcker(g.Conns[2], nil).BroadcastAcked(map[string]*net.UDPAddr{"b": g.Addrs[1]}, []byte("rumor"), time.Second); err != nil || !result.Complete() {
+		t.Fatalf("TestApps expected the broadcast to be acknowledged got %+v (%v).", result, err)
+	}
+	if _, err := NewRequester(g.Conns[0], nil).Request([]byte("query"), g.Addrs[1], time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send("b", 7, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-frames:
+		if f != (appFrame{"a", "hello"}) {
+			t.Fatalf("TestApps expected hello from a got %+v.", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestApps expected the frame to arrive.")
+	}
+
+	// the member moves and the next frame follows it
+	table.Update(Member{Name: "b", Addr: g.Addrs[2], Incarnation: 2})
+	if err := sender.Send("b", 7, []byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-frames:
+		if f != (appFrame{"a", "moved"}) {
+			t.Fatalf("TestApps expected the frame at the new address got %+v.", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestApps expected the frame to follow the member.")
+	}
+	select {
+	case f := <-frames:
+		t.Fatalf("TestApps expected protocol traffic to stay away from the handlers got %+v.", f)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := sender.Send("c", 7, nil); err != ErrUnknownMember {
+		t.Fatalf("TestApps expected %v got %v.", ErrUnknownMember, err)
+	}
+	table.Update(Member{Name: "b", Addr: g.Addrs[2], Incarnation: 2, State: MemberDead})
+	if err := sender.Send("b", 7, nil); err != ErrUnknownMember {
+		t.Fatalf("TestApps expected %v for a dead member got %v.", ErrUnknownMember, err)
+	}
+}
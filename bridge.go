@@ -0,0 +1,164 @@
+package gossip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+var ErrNotForwarded = errors.New("Message has not been forwarded by a bridge")
+
+// Message carried over from another cluster with its origin: the name of
+// the cluster and the address of the node the bridge received it from.
+type Forwarded struct {
+	Cluster string
+	From    *net.UDPAddr
+	Msg     transport.Message
+}
+
+// Magic, cluster name length and name, IPv4 address and port, message
+func EncodeForwarded(f Forwarded) (transport.Message, error) {
+	return encodeForwarded(f, wire.Current)
+}
+
+func encodeForwarded(f Forwarded, v wire.Version) (transport.Message, error) {
+	ip := f.From.IP.To4()
+	if ip == nil {
+		return nil, transport.ErrAddressFamilyMismatch
+	}
+	m := wire.Forwarded{Cluster: f.Cluster, Port: uint16(f.From.Port), Msg: f.Msg}
+	copy(m.IP[:], ip)
+	msg, err := m.Encode(v)
+	if err == wire.ErrTooLong {
+		return nil, ErrNotForwarded
+	}
+	return msg, err
+}
+
+func DecodeForwarded(msg transport.Message) (Forwarded, error) {
+	m, _, err := wire.DecodeForwarded(msg)
+	if err != nil {
+		return Forwarded{}, ErrNotForwarded
+	}
+	return Forwarded{
+		Cluster: m.Cluster,
+		From:    &net.UDPAddr{IP: net.IPv4(m.IP[0], m.IP[1], m.IP[2], m.IP[3]).To4(), Port: int(m.Port)},
+		Msg:     m.Msg,
+	}, nil
+}
+
+// Accepts segments whose data starts with one of the prefixes, e.g. the
+// topics of user broadcasts or the key prefixes of KV updates.
+func PrefixFilter(prefixes ...string) func(Segment) bool {
+	return func(s Segment) bool {
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix(s.Data, []byte(prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// One cluster joined by a Bridge: the connection the bridge uses in it,
+// where forwarded messages go, and which segments may leave it.
+type BridgeSide struct {
+	Cluster string
+	Conn    *transport.Conn
+	Peers   func() []*net.UDPAddr
+
+	// Selects the segments forwarded out of this cluster; nil forwards all
+	Filter func(Segment) bool
+}
+
+// Counters of one direction of a Bridge
+type BridgeStats struct {
+	Forwarded uint64
+	// Messages dropped because they came from the destination cluster
+	Looped uint64
+	// Messages with nothing to forward after filtering
+	Filtered uint64
+}
+
+// Forwards user broadcasts and KV updates between two clusters without
+// merging their membership. Only segments of SubsystemBroadcast and
+// SubsystemKV cross, so probes, joins and member lists stay in their
+// cluster. Every forwarded message is tagged with its origin cluster and
+// sender, and messages are never forwarded back into the cluster they
+// originated in.
+type Bridge struct {
+	a, b BridgeSide
+
+	mutex      sync.Mutex
+	aToB, bToA BridgeStats
+}
+
+// Register the bridge's handlers with the connections of both sides.
+func NewBridge(a, b BridgeSide) *Bridge {
+	bridge := &Bridge{a: a, b: b}
+	a.Conn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		bridge.forward(p, &bridge.a, &bridge.b, &bridge.aToB)
+	})
+	b.Conn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		bridge.forward(p, &bridge.b, &bridge.a, &bridge.bToA)
+	})
+	return bridge
+}
+
+// Counters of the direction from the first to the second side and back.
+func (bridge *Bridge) Stats() (aToB, bToA BridgeStats) {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	return bridge.aToB, bridge.bToA
+}
+
+func (bridge *Bridge) forward(p *transport.Packet, from, to *BridgeSide, stats *BridgeStats) {
+	f, err := DecodeForwarded(p.Msg)
+	if err != nil {
+		f = Forwarded{Cluster: from.Cluster, From: p.Addr, Msg: p.Msg}
+	}
+	if f.Cluster == to.Cluster {
+		bridge.count(&stats.Looped)
+		return
+	}
+
+	segments, err := DecodeSegments(f.Msg)
+	if err != nil {
+		bridge.count(&stats.Filtered)
+		return
+	}
+	var kept []Segment
+	for _, s := range segments {
+		if s.Subsystem != SubsystemBroadcast && s.Subsystem != SubsystemKV {
+			continue
+		}
+		if from.Filter == nil || from.Filter(s) {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == 0 {
+		bridge.count(&stats.Filtered)
+		return
+	}
+
+	f.Msg = EncodeSegments(kept...)
+	msg, err := encodeForwarded(f, wire.Version(to.Conn.EncodeVersion()))
+	if err != nil {
+		bridge.count(&stats.Filtered)
+		return
+	}
+	for _, addr := range to.Peers() {
+		to.Conn.SendTo(msg, addr)
+	}
+	bridge.count(&stats.Forwarded)
+}
+
+func (bridge *Bridge) count(counter *uint64) {
+	bridge.mutex.Lock()
+	*counter++
+	bridge.mutex.Unlock()
+}
@@ -0,0 +1,114 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestForwardedEncoding(t *testing.T) {
+	f := Forwarded{Cluster: "east", From: peerAddr(7), Msg: transport.Message("update")}
+	msg, err := EncodeForwarded(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeForwarded(msg)
+	if err != nil || decoded.Cluster != "east" || decoded.From.String() != peerAddr(7).String() || string(decoded.Msg) != "update" {
+		t.Fatalf("TestForwardedEncoding expected %+v got %+v (%v).", f, decoded, err)
+	}
+	if _, err := DecodeForwarded(transport.Message("update")); err != ErrNotForwarded {
+		t.Fatalf("TestForwardedEncoding expected %q got %v.", ErrNotForwarded, err)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	// one node in each site and the bridge's connection into both
+	eastNode, eastAddr := gossiptest.Listen(t, nil)
+	westNode, westAddr := gossiptest.Listen(t, nil)
+	eastGateway, eastGatewayAddr := gossiptest.Listen(t, nil)
+	westGateway, westGatewayAddr := gossiptest.Listen(t, nil)
+
+	roster := NewRoster(eastGateway)
+	bridge := NewBridge(
+		BridgeSide{
+			Cluster: "east",
+			Conn:    eastGateway,
+			Peers:   func() []*net.UDPAddr { return []*net.UDPAddr{eastAddr} },
+			Filter:  PrefixFilter("shared/"),
+		},
+		BridgeSide{
+			Cluster: "west",
+			Conn:    westGateway,
+			Peers:   func() []*net.UDPAddr { return []*net.UDPAddr{westAddr} },
+		},
+	)
+
+	forwarded := func(p *transport.Packet) bool {
+		_, err := DecodeForwarded(p.Msg)
+		return err == nil
+	}
+	west := gossiptest.NewPacketRecorder(forwarded)
+	westNode.AddHandler(west.Handle)
+	east := gossiptest.NewPacketRecorder(forwarded)
+	eastNode.AddHandler(east.Handle)
+
+	// a join, a probe, a local and a shared KV update from the east
+	eastNode.SendTo(EncodeJoin(JoinRequest{Name: "east-1"}), eastGatewayAddr)
+	eastNode.SendTo(EncodeSegments(Segment{SubsystemProbe, []byte("ping")}), eastGatewayAddr)
+	eastNode.SendTo(EncodeSegments(Segment{SubsystemKV, []byte("local/x=1")}), eastGatewayAddr)
+	eastNode.SendTo(EncodeSegments(
+		Segment{SubsystemProbe, []byte("ping")},
+		Segment{SubsystemKV, []byte("shared/y=2")},
+	), eastGatewayAddr)
+
+	p, err := west.WaitFor(nil, time.Second)
+	if err != nil {
+		t.Fatalf("TestBridge expected the shared update in the west.")
+	}
+	f, _ := DecodeForwarded(p.Msg)
+	segments, _ := DecodeSegments(f.Msg)
+	if f.Cluster != "east" || f.From.String() != eastAddr.String() || len(segments) != 1 || string(segments[0].Data) != "shared/y=2" {
+		t.Fatalf("TestBridge expected shared/y=2 from %s in east got %+v %v.", eastAddr, f, segments)
+	}
+
+	// the west re-gossips the update and publishes one of its own
+	msg, err := EncodeForwarded(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	westNode.SendTo(msg, westGatewayAddr)
+	westNode.SendTo(EncodeSegments(Segment{SubsystemBroadcast, []byte("hello")}), westGatewayAddr)
+	if p, err = east.WaitFor(nil, time.Second); err != nil {
+		t.Fatalf("TestBridge expected the broadcast in the east.")
+	}
+	f, _ = DecodeForwarded(p.Msg)
+	if f.Cluster != "west" || f.From.String() != westAddr.String() {
+		t.Fatalf("TestBridge expected a broadcast from %s in west got %+v.", westAddr, f)
+	}
+
+	// handlers run concurrently, so the counters may lag behind
+	expectedEast, expectedWest := BridgeStats{Forwarded: 1, Filtered: 3}, BridgeStats{Forwarded: 1, Looped: 1}
+	deadline := time.Now().Add(time.Second)
+	eastToWest, westToEast := bridge.Stats()
+	for (eastToWest != expectedEast || westToEast != expectedWest) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		eastToWest, westToEast = bridge.Stats()
+	}
+	if eastToWest != expectedEast {
+		t.Fatalf("TestBridge expected one update forwarded to the west got %+v.", eastToWest)
+	}
+	if westToEast != expectedWest {
+		t.Fatalf("TestBridge expected one broadcast forwarded and one loop got %+v.", westToEast)
+	}
+
+	// membership stays within its site
+	if members := roster.Members(); len(members) != 1 || members["east-1"] == nil {
+		t.Fatalf("TestBridge expected only east-1 in the east got %v.", members)
+	}
+	if n := west.Len(); n != 1 {
+		t.Fatalf("TestBridge expected nothing else in the west got %d updates.", n)
+	}
+}
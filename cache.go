@@ -0,0 +1,162 @@
+package gossip
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Message ids remembered by each cache unless configured otherwise
+const DefaultCacheLimit = 4096
+
+// Occupancy of a bounded cache; Evictions counts the entries dropped to
+// stay within the limit.
+type CacheStats struct {
+	Entries   int
+	Limit     int
+	Evictions uint64
+}
+
+// Cache of message ids whose number of entries is capped. Once an entry
+// has been evicted, the message it identifies is treated as new again:
+// a duplicate may be delivered twice or a rumor relayed once more. Limits
+// should therefore cover the ids seen during the longest time a message
+// keeps circulating.
+type Bounded interface {
+	SetCacheLimit(n int)
+	CacheStats() CacheStats
+}
+
+// Divide an overall budget of entries evenly among the caches.
+func SplitCacheBudget(budget int, caches ...Bounded) {
+	if len(caches) == 0 {
+		return
+	}
+	share := budget / len(caches)
+	for _, c := range caches {
+		c.SetCacheLimit(share)
+	}
+}
+
+// Key of an idCache entry: the id of a message and, if ids are only unique
+// per sender, its origin
+type cacheKey struct {
+	origin netip.AddrPort
+	id     uint64
+}
+
+// Compact, comparable origin of a cacheKey; both spellings of an IPv4
+// address map to the same one.
+func addrKey(addr *net.UDPAddr) netip.AddrPort {
+	key := addr.AddrPort()
+	return netip.AddrPortFrom(key.Addr().Unmap(), key.Port())
+}
+
+// Entry of an idCache and its link in the order of use
+type cacheEntry struct {
+	key        cacheKey
+	value      interface{}
+	prev, next *cacheEntry
+}
+
+// Least recently used set of message ids with an optional value each, not
+// safe for concurrent use. Entries are linked in the order of use, most
+// recent first, from a sentinel, so that an id costs a single allocation.
+type idCache struct {
+	limit     int
+	entries   map[cacheKey]*cacheEntry
+	lru       cacheEntry
+	evictions uint64
+}
+
+func newIDCache(limit int) *idCache {
+	c := &idCache{entries: make(map[cacheKey]*cacheEntry)}
+	c.lru.prev, c.lru.next = &c.lru, &c.lru
+	c.setLimit(limit)
+	return c
+}
+
+// Lower the limit to at least one entry, evicting the oldest at once.
+func (c *idCache) setLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.limit = n
+	for len(c.entries) > c.limit {
+		c.evict()
+	}
+}
+
+func (c *idCache) get(key cacheKey) (interface{}, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.touch(e)
+	return e.value, true
+}
+
+// Insert or refresh the entry; returns whether it was already present.
+func (c *idCache) add(key cacheKey, value interface{}) bool {
+	if e, ok := c.entries[key]; ok {
+		e.value = value
+		c.touch(e)
+		return true
+	}
+	e := &cacheEntry{key: key, value: value}
+	c.entries[key] = e
+	c.link(e)
+	if len(c.entries) > c.limit {
+		c.evict()
+	}
+	return false
+}
+
+func (c *idCache) remove(key cacheKey) {
+	if e, ok := c.entries[key]; ok {
+		c.unlink(e)
+		delete(c.entries, key)
+	}
+}
+
+// Move the entries of one origin to another, keeping their order.
+func (c *idCache) rekey(from, to netip.AddrPort) {
+	for e := c.lru.next; e != &c.lru; e = e.next {
+		if e.key.origin != from {
+			continue
+		}
+		moved := cacheKey{to, e.key.id}
+		if _, ok := c.entries[moved]; ok {
+			continue
+		}
+		delete(c.entries, e.key)
+		e.key = moved
+		c.entries[moved] = e
+	}
+}
+
+func (c *idCache) evict() {
+	e := c.lru.prev
+	c.unlink(e)
+	delete(c.entries, e.key)
+	c.evictions++
+}
+
+// Put the entry first in the order of use.
+func (c *idCache) link(e *cacheEntry) {
+	e.prev, e.next = &c.lru, c.lru.next
+	e.prev.next, e.next.prev = e, e
+}
+
+func (c *idCache) unlink(e *cacheEntry) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+}
+
+func (c *idCache) touch(e *cacheEntry) {
+	c.unlink(e)
+	c.link(e)
+}
+
+func (c *idCache) stats() CacheStats {
+	return CacheStats{Entries: len(c.entries), Limit: c.limit, Evictions: c.evictions}
+}
@@ -0,0 +1,132 @@
+package gossip
+
+import (
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestIDCache(t *testing.T) {
+	c := newIDCache(3)
+	for id := uint64(1); id <= 3; id++ {
+		if c.add(cacheKey{id: id}, nil) {
+			t.Fatalf("TestIDCache expected %d to be new.", id)
+		}
+	}
+	// refresh 1 so that 2 is the least recently used
+	if !c.add(cacheKey{id: 1}, nil) {
+		t.Fatalf("TestIDCache expected 1 to be known.")
+	}
+	c.add(cacheKey{id: 4}, nil)
+	if _, ok := c.get(cacheKey{id: 2}); ok {
+		t.Fatalf("TestIDCache expected 2 to be evicted.")
+	}
+	if _, ok := c.get(cacheKey{origin: netip.MustParseAddrPort("127.0.0.1:1"), id: 1}); ok {
+		t.Fatalf("TestIDCache expected ids to be distinct per origin.")
+	}
+
+	c.setLimit(0)
+	expected := CacheStats{Entries: 1, Limit: 1, Evictions: 3}
+	if stats := c.stats(); stats != expected {
+		t.Fatalf("TestIDCache expected %+v got %+v.", expected, stats)
+	}
+	if _, ok := c.get(cacheKey{id: 4}); !ok {
+		t.Fatalf("TestIDCache expected the most recent id to survive.")
+	}
+	c.remove(cacheKey{id: 4})
+	if stats := c.stats(); stats.Entries != 0 {
+		t.Fatalf("TestIDCache expected no entries got %+v.", stats)
+	}
+}
+
+func TestIDCacheRekey(t *testing.T) {
+	a, b, d := netip.MustParseAddrPort("127.0.0.1:1"), netip.MustParseAddrPort("127.0.0.1:2"), netip.MustParseAddrPort("127.0.0.1:4")
+	c := newIDCache(3)
+	c.add(cacheKey{a, 1}, "first")
+	c.add(cacheKey{b, 1}, nil)
+	c.add(cacheKey{a, 2}, "second")
+	// the IPv4-mapped spelling is the same origin
+	moved := addrKey(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 3})
+	c.rekey(a, moved)
+	if v, ok := c.get(cacheKey{netip.MustParseAddrPort("127.0.0.3:3"), 1}); !ok || v != "first" {
+		t.Fatalf("TestIDCacheRekey expected the entry to move got %v.", v)
+	}
+	if _, ok := c.get(cacheKey{a, 2}); ok {
+		t.Fatalf("TestIDCacheRekey expected nothing left of the old origin.")
+	}
+
+	// the order is kept, so the moved entries are evicted as before
+	c.add(cacheKey{d, 1}, nil)
+	if _, ok := c.get(cacheKey{b, 1}); ok {
+		t.Fatalf("TestIDCacheRekey expected the oldest entry to be evicted.")
+	}
+}
+
+// An evicted rumor is no longer recognised, so its next copy counts as
+// new and would be relayed again.
+func TestRumorsEviction(t *testing.T) {
+	r := NewRumors()
+	r.SetCacheLimit(2)
+	from := peerAddr(1)
+	for id := uint64(0); id < 3; id++ {
+		r.Receive(id, from, from)
+	}
+	if !r.Receive(0, from, from) {
+		t.Fatalf("TestRumorsEviction expected the evicted rumor to be new again.")
+	}
+	if r.Receive(2, from, from) {
+		t.Fatalf("TestRumorsEviction expected a recent rumor to be a duplicate.")
+	}
+	if stats := r.CacheStats(); stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("TestRumorsEviction expected two entries after two evictions got %+v.", stats)
+	}
+}
+
+func TestSplitCacheBudget(t *testing.T) {
+	conn := transport.NewConn()
+	acker := NewAcker(conn, nil)
+	tracer := NewTracer(conn, "a", nil)
+	rumors := NewRumors()
+
+	SplitCacheBudget(3000, acker, tracer, rumors)
+	for _, c := range []Bounded{acker, tracer, rumors} {
+		if limit := c.CacheStats().Limit; limit != 1000 {
+			t.Fatalf("TestSplitCacheBudget expected a limit of 1000 got %d.", limit)
+		}
+	}
+}
+
+// Memory stays flat however many rumors pass through.
+func TestRumorsSoak(t *testing.T) {
+	r := NewRumors()
+	r.SetCacheLimit(1000)
+	from := peerAddr(1)
+
+	var baseline, after runtime.MemStats
+	for round := uint64(0); round < 50000; round++ {
+		// every rumor arrives twice, the second time a few rounds later
+		r.Receive(round, from, from)
+		if round >= 5 {
+			r.Receive(round-5, from, from)
+		}
+		if round == 5000 {
+			runtime.GC()
+			runtime.ReadMemStats(&baseline)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	if stats := r.CacheStats(); stats.Entries != 1000 {
+		t.Fatalf("TestRumorsSoak expected 1000 entries got %+v.", stats)
+	}
+	if growth := int64(after.HeapAlloc) - int64(baseline.HeapAlloc); growth > 1<<20 {
+		t.Fatalf("TestRumorsSoak expected flat memory got %d bytes more.", growth)
+	}
+	if stats := r.Stats(); stats.Duplicates != 50000-5 {
+		t.Fatalf("TestRumorsSoak expected every late copy to be a duplicate got %+v.", stats)
+	}
+}
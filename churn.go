@@ -0,0 +1,153 @@
+package gossip
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Consecutive calm windows after which a stretched interval is shortened
+const churnCalmWindows = 3
+
+// Base interval of NewChurnBackoff if none is given
+const DefaultChurnBase = time.Second
+
+// Stretches the probe and gossip intervals while the membership churns,
+// e.g. during a mass restart, so that suspicion traffic does not flood
+// the network. Every window with more membership events than the
+// threshold doubles the interval up to max. It only halves again after
+// several windows with at most half the threshold, so churn hovering
+// around the threshold does not make it oscillate.
+type ChurnBackoff struct {
+	base, max time.Duration
+	threshold int
+	clock     transport.Clock
+
+	// Called after every change of the effective interval, outside the lock
+	OnAdjust func(from, to time.Duration)
+
+	mutex    sync.Mutex
+	interval time.Duration
+	events   int
+	calm     int
+	stats    ChurnStats
+}
+
+// Snapshot of the backoff for metrics
+type ChurnStats struct {
+	// Effective probe interval
+	Interval time.Duration
+	// Membership events in the last completed window
+	Events int
+	// Number of times the interval was stretched and shortened
+	Stretched, Shortened uint64
+}
+
+// Start at the base interval and stretch up to max when more than
+// threshold membership events happen per window. A base which is not
+// positive could never be stretched and selects DefaultChurnBase.
+func NewChurnBackoff(base, max time.Duration, threshold int) *ChurnBackoff {
+	if base <= 0 {
+		base = DefaultChurnBase
+	}
+	if max < base {
+		max = base
+	}
+	return &ChurnBackoff{
+		base:      base,
+		max:       max,
+		threshold: threshold,
+		clock:     transport.RealClock,
+		interval:  base,
+	}
+}
+
+// Replace the source of time used by Run.
+func (b *ChurnBackoff) SetClock(clock transport.Clock) {
+	b.clock = clock
+}
+
+// Count a join, leave, failure or other change of the membership.
+func (b *ChurnBackoff) MemberEvent() {
+	b.mutex.Lock()
+	b.events++
+	b.mutex.Unlock()
+}
+
+// Effective probe interval.
+func (b *ChurnBackoff) Interval() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.interval
+}
+
+// Stretch another interval, e.g. the gossip interval, by the same factor
+// as the probe interval.
+func (b *ChurnBackoff) Scale(d time.Duration) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return time.Duration(int64(d) * int64(b.interval) / int64(b.base))
+}
+
+func (b *ChurnBackoff) Stats() ChurnStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stats := b.stats
+	stats.Interval = b.interval
+	return stats
+}
+
+// Close the current window and adjust the interval to the churn seen in it.
+func (b *ChurnBackoff) Window() {
+	b.mutex.Lock()
+	from := b.interval
+	events := b.events
+	b.events = 0
+	b.stats.Events = events
+
+	switch {
+	case events > b.threshold:
+		b.calm = 0
+		if b.interval < b.max {
+			b.interval *= 2
+			if b.interval > b.max {
+				b.interval = b.max
+			}
+			b.stats.Stretched++
+		}
+	case events <= b.threshold/2:
+		if b.calm++; b.calm >= churnCalmWindows && b.interval > b.base {
+			b.calm = 0
+			b.interval /= 2
+			if b.interval < b.base {
+				b.interval = b.base
+			}
+			b.stats.Shortened++
+		}
+	default:
+		b.calm = 0
+	}
+	to := b.interval
+	onAdjust := b.OnAdjust
+	b.mutex.Unlock()
+
+	if from != to && onAdjust != nil {
+		onAdjust(from, to)
+	}
+}
+
+// Close a window every period until done is closed.
+func (b *ChurnBackoff) Run(window time.Duration, done <-chan bool) {
+	ticker := b.clock.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			b.Window()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Restart half of a 40 node cluster: each restart is a leave followed by
+// a join, spread over a few windows, then the membership settles.
+func TestChurnBackoffRestart(t *testing.T) {
+	b := NewChurnBackoff(time.Second, 8*time.Second, 5)
+	var adjustments []time.Duration
+	b.OnAdjust = func(from, to time.Duration) {
+		adjustments = append(adjustments, to)
+	}
+
+	churn := []int{0, 1, 0, 12, 14, 14, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	var intervals []time.Duration
+	for _, events := range churn {
+		for i := 0; i < events; i++ {
+			b.MemberEvent()
+		}
+		b.Window()
+		intervals = append(intervals, b.Interval())
+	}
+
+	if intervals[2] != time.Second {
+		t.Fatalf("TestChurnBackoffRestart expected the base interval before the restart got %v.", intervals[2])
+	}
+	if intervals[5] != 8*time.Second {
+		t.Fatalf("TestChurnBackoffRestart expected the interval to back off to 8s got %v.", intervals)
+	}
+	if last := intervals[len(intervals)-1]; last != time.Second {
+		t.Fatalf("TestChurnBackoffRestart expected the interval to recover got %v.", intervals)
+	}
+	expected := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 4 * time.Second, 2 * time.Second, time.Second}
+	if len(adjustments) != len(expected) {
+		t.Fatalf("TestChurnBackoffRestart expected adjustments %v got %v.", expected, adjustments)
+	}
+	for i := range expected {
+		if adjustments[i] != expected[i] {
+			t.Fatalf("TestChurnBackoffRestart expected adjustments %v got %v.", expected, adjustments)
+		}
+	}
+	if stats := b.Stats(); stats.Stretched != 3 || stats.Shortened != 3 || stats.Interval != time.Second {
+		t.Fatalf("TestChurnBackoffRestart expected three steps each way got %+v.", stats)
+	}
+}
+
+func TestChurnBackoffHysteresis(t *testing.T) {
+	b := NewChurnBackoff(time.Second, 8*time.Second, 4)
+	adjusted := 0
+	b.OnAdjust = func(from, to time.Duration) {
+		adjusted++
+	}
+
+	// one burst then churn just below the threshold keeps the interval
+	for i := 0; i < 5; i++ {
+		b.MemberEvent()
+	}
+	b.Window()
+	for w := 0; w < 20; w++ {
+		for i := 0; i < 3+w%2; i++ {
+			b.MemberEvent()
+		}
+		b.Window()
+	}
+	if adjusted != 1 || b.Interval() != 2*time.Second {
+		t.Fatalf("TestChurnBackoffHysteresis expected a single adjustment to 2s got %d to %v.", adjusted, b.Interval())
+	}
+	if d := b.Scale(500 * time.Millisecond); d != time.Second {
+		t.Fatalf("TestChurnBackoffHysteresis expected the gossip interval scaled to 1s got %v.", d)
+	}
+}
+
+func TestChurnBackoffRun(t *testing.T) {
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	b := NewChurnBackoff(time.Second, 4*time.Second, 0)
+	b.SetClock(clock)
+	adjusted := make(chan time.Duration, 1)
+	b.OnAdjust = func(from, to time.Duration) {
+		adjusted <- to
+	}
+
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		b.Run(10*time.Second, done)
+		close(stopped)
+	}()
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.MemberEvent()
+	clock.Advance(10 * time.Second)
+	if to := <-adjusted; to != 2*time.Second {
+		t.Fatalf("TestChurnBackoffRun expected 2s got %v.", to)
+	}
+	close(done)
+	<-stopped
+}
+
+func TestChurnBackoffZeroBase(t *testing.T) {
+	b := NewChurnBackoff(0, 4*time.Second, 0)
+	b.MemberEvent()
+	b.Window()
+	if b.Interval() != 2*DefaultChurnBase || b.Scale(time.Second) != 2*time.Second {
+		t.Fatalf("TestChurnBackoffZeroBase expected a stretched default base got %v.", b.Interval())
+	}
+}
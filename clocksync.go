@@ -0,0 +1,120 @@
+package gossip
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of recent exchanges kept per peer
+const clockSyncWindow = 8
+
+// Estimates the clock offset of every peer from request/response exchanges
+// using NTP's four timestamps. Each estimate is taken from the recent
+// exchange with the smallest round-trip delay, which rejects samples
+// distorted by queueing; the spread of the other samples' offsets around
+// it is reported as the dispersion.
+type ClockSync struct {
+	mutex sync.Mutex
+	peers map[string]*clockSamples
+}
+
+// One exchange reduced to its offset and round-trip delay
+type clockSample struct {
+	offset, delay time.Duration
+}
+
+// Ring buffer of the most recent samples of one peer
+type clockSamples struct {
+	samples [clockSyncWindow]clockSample
+	n, next int
+}
+
+// Create an estimator without any samples.
+func NewClockSync() *ClockSync {
+	return &ClockSync{peers: make(map[string]*clockSamples)}
+}
+
+// Record one exchange with the peer: t1 is the local send time of the
+// request, t2 and t3 the remote receive and send times, and t4 the local
+// receive time of the response. Exchanges with a negative round-trip
+// delay are impossible and therefore discarded.
+func (c *ClockSync) Sample(peer string, t1, t2, t3, t4 time.Time) {
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	if delay < 0 {
+		return
+	}
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.peers[peer]
+	if !ok {
+		s = new(clockSamples)
+		c.peers[peer] = s
+	}
+	s.samples[s.next] = clockSample{offset, delay}
+	s.next = (s.next + 1) % clockSyncWindow
+	if s.n < clockSyncWindow {
+		s.n++
+	}
+}
+
+// Returns by how much the peer's clock is ahead of the local clock and the
+// dispersion of that estimate; ok is false if there are no samples yet.
+func (c *ClockSync) Offset(peer string) (offset, dispersion time.Duration, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.peers[peer]
+	if !ok {
+		return 0, 0, false
+	}
+	offset, dispersion = s.estimate()
+	return offset, dispersion, true
+}
+
+// Largest difference between the estimated offsets of any two peers,
+// including the local node at offset zero.
+func (c *ClockSync) MaxSkew() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var min, max time.Duration
+	for _, s := range c.peers {
+		offset, _ := s.estimate()
+		if offset < min {
+			min = offset
+		}
+		if offset > max {
+			max = offset
+		}
+	}
+	return max - min
+}
+
+// Forget all samples of the peer, e.g. because it left.
+func (c *ClockSync) Remove(peer string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.peers, peer)
+}
+
+func (s *clockSamples) estimate() (offset, dispersion time.Duration) {
+	samples := make([]clockSample, s.n)
+	copy(samples, s.samples[:s.n])
+	sort.Slice(samples, func(i, j int) bool { return samples[i].delay < samples[j].delay })
+
+	best := samples[0]
+	for _, sample := range samples[1:] {
+		d := sample.offset - best.offset
+		if d < 0 {
+			d = -d
+		}
+		if d > dispersion {
+			dispersion = d
+		}
+	}
+	return best.offset, dispersion + best.delay/2
+}
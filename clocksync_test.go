@@ -0,0 +1,59 @@
+package gossip
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestClockSyncOffsets(t *testing.T) {
+	rnd := rand.New(rand.NewSource(401))
+	offsets := map[string]time.Duration{
+		"fast": 250 * time.Millisecond,
+		"slow": -1200 * time.Millisecond,
+		"near": 3 * time.Millisecond,
+	}
+
+	clocks := NewClockSync()
+	local := time.Date(2011, time.June, 1, 0, 0, 0, 0, time.UTC)
+	// fixed order of peers, so that every run draws the same delays
+	peers := []string{"fast", "slow", "near"}
+	for i := 0; i < 50; i++ {
+		for _, peer := range peers {
+			offset := offsets[peer]
+			// one-way delays of 1ms plus up to 40ms of queueing each
+			t1 := local
+			t2 := t1.Add(offset + time.Millisecond + time.Duration(rnd.Intn(40))*time.Millisecond)
+			t3 := t2.Add(100 * time.Microsecond)
+			t4 := t3.Add(-offset + time.Millisecond + time.Duration(rnd.Intn(40))*time.Millisecond)
+			clocks.Sample(peer, t1, t2, t3, t4)
+			local = t4.Add(time.Second)
+		}
+	}
+
+	const tolerance = 10 * time.Millisecond
+	for peer, expected := range offsets {
+		offset, _, ok := clocks.Offset(peer)
+		if !ok {
+			t.Fatalf("TestClockSyncOffsets no estimate for %s", peer)
+		}
+		if d := offset - expected; d > tolerance || d < -tolerance {
+			t.Fatalf("TestClockSyncOffsets expected offset %s for %s got %s.", expected, peer, offset)
+		}
+	}
+
+	if skew := clocks.MaxSkew(); skew < 1440*time.Millisecond || skew > 1460*time.Millisecond {
+		t.Fatalf("TestClockSyncOffsets expected skew of about %s got %s.", 1450*time.Millisecond, skew)
+	}
+}
+
+func TestClockSyncRejectsImpossibleSamples(t *testing.T) {
+	clocks := NewClockSync()
+	now := time.Now()
+
+	// the remote end claims to have spent longer than the whole round trip
+	clocks.Sample("peer", now, now, now.Add(time.Second), now.Add(time.Millisecond))
+	if _, _, ok := clocks.Offset("peer"); ok {
+		t.Fatalf("TestClockSyncRejectsImpossibleSamples expected no estimate")
+	}
+}
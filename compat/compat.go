@@ -0,0 +1,176 @@
+// Connection API of the first releases, before the transport subpackage
+// took over, for code which has not moved yet. Everything is implemented
+// on top of transport.Conn.
+//
+// Deprecated: use the transport subpackage directly.
+package compat
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Deprecated: use transport.Message.
+type Message = transport.Message
+
+// Deprecated: use transport.Packet.
+type Packet = transport.Packet
+
+// Invoked on incoming packets, like transport.EventHandler.
+//
+// Deprecated: use transport.EventHandler.
+type EventHandler func(conn *Conn, p *Packet)
+
+// UDP connection with the API of the first releases: sends report their
+// failures on Err rather than returning them.
+//
+// Deprecated: use transport.Conn.
+type Conn struct {
+	// Failures of the connection, fed from the errors of the underlying
+	// transport.Conn, e.g. a *transport.SendError, and from messages
+	// Unicast could not queue. The channel is closed on Disconnect and
+	// replaced for the next socket.
+	Err chan error
+
+	conn *transport.Conn
+	sock socket
+
+	mutex sync.Mutex
+	// closed by Disconnect to stop the forwarding of errors, which it
+	// waits for before it closes Err
+	quit      chan bool
+	forwarded sync.WaitGroup
+}
+
+// Stands in for the *net.UDPConn which the first releases kept in sock,
+// so that code of this package written against them still finds the
+// local end-point there
+type socket struct {
+	conn *transport.Conn
+}
+
+func (s socket) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Deprecated: use transport.NewConn.
+func NewConn() *Conn {
+	conn := transport.NewConn()
+	return &Conn{Err: make(chan error, 4), conn: conn, sock: socket{conn}}
+}
+
+// Underlying connection, e.g. to migrate a caller piece by piece.
+func (c *Conn) Transport() *transport.Conn {
+	return c.conn
+}
+
+// Listen for incoming packets on the specified localhost port.
+//
+// Deprecated: use transport.Conn.Listen.
+func (c *Conn) Listen(port uint) error {
+	return c.open(func() error { return c.conn.Listen(port) })
+}
+
+// Connect to the remote end-point, waiting as long as it takes.
+//
+// Deprecated: use transport.Conn.Dial, which takes a deadline.
+func (c *Conn) Dial(remoteAddr string) error {
+	return c.open(func() error { return c.conn.Dial(remoteAddr, time.Time{}) })
+}
+
+// Open the socket and forward the errors of the transport to Err.
+func (c *Conn) open(open func() error) error {
+	if err := open(); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.quit = make(chan bool)
+	c.forwarded.Add(1)
+	go c.forward(c.conn.Err, c.Err, c.quit)
+	return nil
+}
+
+func (c *Conn) forward(from <-chan error, to chan<- error, quit <-chan bool) {
+	defer c.forwarded.Done()
+	for err := range from {
+		select {
+		case to <- err:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// Deprecated: use transport.Conn.IsConnected.
+func (c *Conn) IsConnected() bool {
+	return c.conn.IsConnected()
+}
+
+// Release the socket and close Err.
+//
+// Deprecated: use transport.Conn.Disconnect.
+func (c *Conn) Disconnect() {
+	c.mutex.Lock()
+	quit := c.quit
+	c.quit = nil
+	c.mutex.Unlock()
+	if quit == nil {
+		return
+	}
+	close(quit)
+	c.conn.Disconnect()
+	c.forwarded.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	close(c.Err)
+	c.Err = make(chan error, 4)
+}
+
+// Send the message to the dialed remote end-point.
+//
+// Deprecated: use transport.Conn.Send, which returns the error.
+func (c *Conn) Unicast(msg Message) {
+	c.report(c.conn.Send(msg))
+}
+
+// Send the message to the address.
+//
+// Deprecated: use transport.Conn.SendTo, which returns the error.
+func (c *Conn) UnicastTo(msg Message, addr *net.UDPAddr) {
+	c.report(c.conn.SendTo(msg, addr))
+}
+
+// Hand an error to Err unless the connection is disconnecting.
+func (c *Conn) report(err error) {
+	if err == nil {
+		return
+	}
+	c.mutex.Lock()
+	errs, quit := c.Err, c.quit
+	if quit == nil {
+		c.mutex.Unlock()
+		return
+	}
+	// Disconnect closes Err only once this is done
+	c.forwarded.Add(1)
+	c.mutex.Unlock()
+	defer c.forwarded.Done()
+	select {
+	case errs <- err:
+	case <-quit:
+	}
+}
+
+// Register an event handler which is invoked on incoming packets.
+//
+// Deprecated: use transport.Conn.AddHandler.
+func (c *Conn) AddHandler(f EventHandler) {
+	c.conn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		f(c, p)
+	})
+}
@@ -1,12 +1,15 @@
-package gossip
+package compat
 
 import (
-	"testing"
-	"os"
 	"fmt"
 	"net"
+	"testing"
 )
 
+// The scenarios of the first releases as they were, but for the Go 1
+// changes transport/udp_test.go went through as well and ports of their
+// own so that the packages can be tested in parallel.
+
 const expectedRequest = "Hi, I am client!"
 const expectedReply = "Nice to meet you. I am server!"
 
@@ -16,7 +19,7 @@ func TestClientServer(t *testing.T) {
 	reply = make(chan Message, 1)
 	defer close(reply)
 
-	const port uint = 9999
+	const port uint = 9899
 
 	server := startServer(t, port)
 	defer server.Disconnect()
@@ -35,16 +38,16 @@ func TestPeer(t *testing.T) {
 	reply = make(chan Message, 1)
 	defer close(reply)
 
-	peer0 := startPeer(t, 9999)
+	peer0 := startPeer(t, 9899)
 	defer peer0.Disconnect()
 	peer0.AddHandler(receiveReply)
 
-	peer1 := startPeer(t, 9988)
+	peer1 := startPeer(t, 9888)
 	defer peer1.Disconnect()
 	peer1.AddHandler(sendReply)
 
 	msg := []byte(expectedRequest)
-	peer1Addr, err := net.ResolveUDPAddr(peer1.sock.LocalAddr().String())
+	peer1Addr, err := net.ResolveUDPAddr("udp", peer1.sock.LocalAddr().String())
 	if err != nil {
 		t.Fatalf("TestPeer could not resolve peer address: %s.", err)
 	}
@@ -57,6 +60,22 @@ func TestPeer(t *testing.T) {
 	}
 }
 
+func TestErrChannel(t *testing.T) {
+	conn := NewConn()
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	errs := conn.Err
+	conn.Unicast([]byte(expectedRequest))
+	if err := <-errs; err == nil {
+		t.Fatalf("TestErrChannel expected the failed Unicast on Err.")
+	}
+	conn.Disconnect()
+	if _, ok := <-errs; ok {
+		t.Fatalf("TestErrChannel expected Err to be closed on Disconnect.")
+	}
+}
+
 // Starts and returns a connector which listens to the specified port on localhost
 func startPeer(t *testing.T, port uint) *Conn {
 	conn := NewConn()
@@ -102,9 +121,9 @@ func startClient(t *testing.T, port uint) *Conn {
 
 // Fail all tests if connector encounters error.
 // Since testing.T is thread-safe, this function can be run as a goroutine.
-func monitor(errors <-chan os.Error, t *testing.T) {
+func monitor(errors <-chan error, t *testing.T) {
 	for err := range errors {
-		t.Fatalf("Encountered unexpected error %q", err)
+		t.Errorf("Encountered unexpected error %q", err)
 	}
 }
 
@@ -0,0 +1,215 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Prefix of the member tag advertising the applied configuration version,
+// as in "config:7".
+const ConfigTagPrefix = "config:"
+
+var (
+	ErrCorruptConfig = errors.New("Configuration document is corrupt")
+	ErrNoRollback    = errors.New("No last known good configuration to roll back to")
+)
+
+// Member tag advertising the configuration version
+func ConfigTag(version uint64) string {
+	return ConfigTagPrefix + strconv.FormatUint(version, 10)
+}
+
+// Parse a tag of the form config:version.
+func ParseConfigTag(tag string) (uint64, bool) {
+	if !strings.HasPrefix(tag, ConfigTagPrefix) {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(tag[len(ConfigTagPrefix):], 10, 64)
+	return version, err == nil
+}
+
+// Part of a node which takes on a configuration. A document is applied
+// only once every applier validated it, so Apply must not fail for a
+// document its Validate accepted.
+type ConfigApplier interface {
+	Validate(version uint64, data []byte) error
+	Apply(version uint64, data []byte)
+}
+
+// Version of a configuration document with its contents
+type ConfigVersion struct {
+	Version uint64
+	Data    []byte
+}
+
+// Document an applier vetoed on this node
+type ConfigFailure struct {
+	Version uint64
+	From    *net.UDPAddr
+	Err     error
+}
+
+func (f *ConfigFailure) Error() string {
+	return fmt.Sprintf("configuration %d rejected: %s", f.Version, f.Err)
+}
+
+func (f *ConfigFailure) Unwrap() error {
+	return f.Err
+}
+
+// Distributes versioned, checksummed configuration documents to every
+// member with acknowledged broadcasts. Each node applies a document
+// atomically: all appliers validate it before any applies it, and a veto
+// leaves the node on its previous version and is returned to the origin
+// as a rejection. Documents at or below the applied version are
+// acknowledged without being applied again. The version applied before
+// the current one is kept as the last known good one for Rollback.
+type ConfigSync struct {
+	acker *Acker
+
+	// Serializes validating and applying
+	apply sync.Mutex
+
+	mutex    sync.Mutex
+	appliers []ConfigApplier
+	current  ConfigVersion
+	good     ConfigVersion
+	failure  *ConfigFailure
+
+	// Highest version seen, applied or not, so pushes supersede it
+	latest uint64
+}
+
+// Register the component with conn; documents are accepted from every
+// member.
+func NewConfigSync(conn *transport.Conn) *ConfigSync {
+	cs := &ConfigSync{}
+	cs.acker = NewCheckedAcker(conn, cs.receive)
+	return cs
+}
+
+// Add an applier; documents are validated and applied in the order the
+// appliers were registered.
+func (cs *ConfigSync) Register(a ConfigApplier) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.appliers = append(cs.appliers, a)
+}
+
+// Apply data locally as the next version and push it to the members,
+// waiting up to timeout for their verdicts. A local veto is returned as a
+// *ConfigFailure and nothing is sent. Members which vetoed the document
+// are listed as Rejected with the reason of their applier.
+func (cs *ConfigSync) Push(members map[string]*net.UDPAddr, data []byte, timeout time.Duration) (uint64, AckResult, error) {
+	cs.mutex.Lock()
+	version := cs.latest + 1
+	cs.mutex.Unlock()
+
+	if err := cs.applyLocal(ConfigVersion{version, data}, nil); err != nil {
+		return version, AckResult{}, err
+	}
+	msg, err := wire.Config{Version: version, Data: data}.Encode(wire.Version(cs.acker.conn.EncodeVersion()))
+	if err != nil {
+		return version, AckResult{}, err
+	}
+	result, err := cs.acker.BroadcastAcked(members, msg, timeout)
+	return version, result, err
+}
+
+// Push the last known good document of this node again under a new
+// version, e.g. after a push which some members rejected. Returns
+// ErrNoRollback if there is none.
+func (cs *ConfigSync) Rollback(members map[string]*net.UDPAddr, timeout time.Duration) (uint64, AckResult, error) {
+	good := cs.LastKnownGood()
+	if good.Version == 0 {
+		return 0, AckResult{}, ErrNoRollback
+	}
+	return cs.Push(members, good.Data, timeout)
+}
+
+// Document applied on this node; version zero before the first one.
+func (cs *ConfigSync) Applied() ConfigVersion {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.current
+}
+
+// Document applied before the current one; version zero if there is none.
+func (cs *ConfigSync) LastKnownGood() ConfigVersion {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.good
+}
+
+// Last document vetoed on this node, nil if none has been since the last
+// one was applied.
+func (cs *ConfigSync) Failure() *ConfigFailure {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.failure
+}
+
+// Member tag advertising the applied version; see ConfigTag.
+func (cs *ConfigSync) Tag() string {
+	return ConfigTag(cs.Applied().Version)
+}
+
+func (cs *ConfigSync) receive(payload []byte, from *net.UDPAddr) error {
+	doc, _, err := wire.DecodeConfig(payload)
+	switch err {
+	case nil:
+	case wire.ErrKind:
+		return nil
+	default:
+		return ErrCorruptConfig
+	}
+	// the payload is reused once the handler returns
+	return cs.applyLocal(ConfigVersion{doc.Version, append([]byte(nil), doc.Data...)}, from)
+}
+
+// Validate the document with every applier and apply it unless one of
+// them vetoed it or a version at least as high is applied already.
+func (cs *ConfigSync) applyLocal(doc ConfigVersion, from *net.UDPAddr) error {
+	cs.apply.Lock()
+	defer cs.apply.Unlock()
+
+	cs.mutex.Lock()
+	if doc.Version > cs.latest {
+		cs.latest = doc.Version
+	}
+	stale := doc.Version <= cs.current.Version
+	appliers := cs.appliers
+	cs.mutex.Unlock()
+	if stale {
+		return nil
+	}
+
+	for _, a := range appliers {
+		if err := a.Validate(doc.Version, doc.Data); err != nil {
+			failure := &ConfigFailure{doc.Version, from, err}
+			cs.mutex.Lock()
+			cs.failure = failure
+			cs.mutex.Unlock()
+			return failure
+		}
+	}
+	for _, a := range appliers {
+		a.Apply(doc.Version, doc.Data)
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.current.Version != 0 {
+		cs.good = cs.current
+	}
+	cs.current, cs.failure = doc, nil
+	return nil
+}
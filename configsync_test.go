@@ -0,0 +1,126 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Applier which keeps the applied document and vetoes those containing veto
+type recordingApplier struct {
+	veto string
+
+	mutex   sync.Mutex
+	applied []string
+}
+
+func (a *recordingApplier) Validate(version uint64, data []byte) error {
+	if a.veto != "" && strings.Contains(string(data), a.veto) {
+		return errors.New("unsupported setting " + a.veto)
+	}
+	return nil
+}
+
+func (a *recordingApplier) Apply(version uint64, data []byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.applied = append(a.applied, fmt.Sprintf("%d:%s", version, data))
+}
+
+func (a *recordingApplier) last() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.applied) == 0 {
+		return ""
+	}
+	return a.applied[len(a.applied)-1]
+}
+
+func TestConfigSync(t *testing.T) {
+	g := gossiptest.NewGroup(t, 3)
+	syncs := make([]*ConfigSync, 3)
+	appliers := make([]*recordingApplier, 3)
+	members := make(map[string]*net.UDPAddr)
+	for i := range syncs {
+		syncs[i] = NewConfigSync(g.Conns[i])
+		appliers[i] = &recordingApplier{}
+		syncs[i].Register(appliers[i])
+		if i > 0 {
+			members[fmt.Sprintf("node%d", i)] = g.Addrs[i]
+		}
+	}
+	// the second applier of node2 vetoes, so its first one must not apply
+	vetoing := &recordingApplier{veto: "compression=zstd"}
+	syncs[2].Register(vetoing)
+
+	version, result, err := syncs[0].Push(members, []byte("interval=1s"), time.Second)
+	if err != nil || version != 1 || !result.Complete() {
+		t.Fatalf("TestConfigSync expected version 1 to be confirmed got %d, %+v (%v).", version, result, err)
+	}
+
+	version, result, err = syncs[0].Push(members, []byte("interval=1s compression=zstd"), time.Second)
+	if err != nil || version != 2 || len(result.Confirmed) != 1 || len(result.Rejected) != 1 || result.Rejected[0] != "node2" {
+		t.Fatalf("TestConfigSync expected node2 to reject version 2 got %+v (%v).", result, err)
+	}
+	if reason := result.Reasons["node2"]; !strings.Contains(reason, "unsupported setting compression=zstd") {
+		t.Fatalf("TestConfigSync expected the veto to be reported got %q.", reason)
+	}
+	if applied := syncs[2].Applied(); applied.Version != 1 || appliers[2].last() != "1:interval=1s" {
+		t.Fatalf("TestConfigSync expected node2 to stay on version 1 got %d and %q.", applied.Version, appliers[2].last())
+	}
+	if f := syncs[2].Failure(); f == nil || f.Version != 2 || f.From.Port != g.Addrs[0].Port {
+		t.Fatalf("TestConfigSync expected node2 to record the failure got %v.", f)
+	}
+	if syncs[1].Tag() != ConfigTag(2) || syncs[2].Tag() != ConfigTag(1) {
+		t.Fatalf("TestConfigSync expected tags %s and %s got %s and %s.", ConfigTag(2), ConfigTag(1), syncs[1].Tag(), syncs[2].Tag())
+	}
+
+	// the rollback re-issues version 1 as version 3 everywhere
+	version, result, err = syncs[0].Rollback(members, time.Second)
+	if err != nil || version != 3 || !result.Complete() {
+		t.Fatalf("TestConfigSync expected the rollback to be confirmed got %d, %+v (%v).", version, result, err)
+	}
+	for i, cs := range syncs {
+		if applied := cs.Applied(); applied.Version != 3 || string(applied.Data) != "interval=1s" || appliers[i].last() != "3:interval=1s" {
+			t.Fatalf("TestConfigSync expected node%d to apply the rollback got %d %q.", i, applied.Version, applied.Data)
+		}
+	}
+	if syncs[2].Failure() != nil || syncs[2].LastKnownGood().Version != 1 {
+		t.Fatalf("TestConfigSync expected node2 to clear its failure and keep version 1 as last known good.")
+	}
+	if version, ok := ParseConfigTag(syncs[2].Tag()); !ok || version != 3 {
+		t.Fatalf("TestConfigSync expected the tag of version 3 got %s.", syncs[2].Tag())
+	}
+}
+
+func TestConfigSyncLocalVeto(t *testing.T) {
+	g := gossiptest.NewPair(t)
+	origin, member := NewConfigSync(g.Conns[0]), NewConfigSync(g.Conns[1])
+	origin.Register(&recordingApplier{veto: "bad"})
+	members := map[string]*net.UDPAddr{"node1": g.Addrs[1]}
+
+	if _, _, err := origin.Rollback(members, time.Second); err != ErrNoRollback {
+		t.Fatalf("TestConfigSyncLocalVeto expected %v got %v.", ErrNoRollback, err)
+	}
+	var failure *ConfigFailure
+	if _, _, err := origin.Push(members, []byte("bad"), 100*time.Millisecond); !errors.As(err, &failure) || failure.From != nil {
+		t.Fatalf("TestConfigSyncLocalVeto expected a local failure got %v.", err)
+	}
+	if member.Applied().Version != 0 {
+		t.Fatalf("TestConfigSyncLocalVeto expected nothing to be sent.")
+	}
+
+	// a damaged document is rejected
+	msg, _ := wire.Config{Version: 5, Data: []byte("good")}.Encode(wire.Current)
+	msg[len(msg)-1] ^= 1
+	if err := member.receive(msg, g.Addrs[0]); err != ErrCorruptConfig || member.Applied().Version != 0 {
+		t.Fatalf("TestConfigSyncLocalVeto expected %v got %v.", ErrCorruptConfig, err)
+	}
+}
@@ -0,0 +1,3 @@
+// Gossip protocols built on top of the connectionless transport in the
+// transport subpackage, which provides Conn, Packet and Message.
+package gossip
@@ -0,0 +1,213 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Period at which Epochs.Run writes the state back if none is given
+const DefaultEpochWriteback = time.Minute
+
+var ErrCorruptEpochState = errors.New("Epoch state is corrupt")
+
+// Counters which must keep growing across restarts: the epoch stamped on
+// every sequenced message (see SequenceGuard) and the incarnation with
+// which a node refutes rumors about itself.
+type EpochState struct {
+	Epoch       uint64
+	Incarnation uint64
+}
+
+// Persistent storage of the EpochState.
+type EpochStore interface {
+	// Returns the state last stored, or the zero state if nothing has
+	// been stored yet. A state which cannot be trusted is reported as an
+	// error wrapping ErrCorruptEpochState.
+	Load() (EpochState, error)
+
+	Store(state EpochState) error
+}
+
+// Store the state in the file at the path, replacing it atomically like a
+// FilePeerStore. The file carries a checksum so that damage is detected.
+type FileEpochStore string
+
+// On-disk representation of the state
+type epochFile struct {
+	Epoch       uint64 `json:"epoch"`
+	Incarnation uint64 `json:"incarnation"`
+	Sum         uint32 `json:"sum"`
+}
+
+func (s epochFile) checksum() uint32 {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:], s.Epoch)
+	binary.BigEndian.PutUint64(b[8:], s.Incarnation)
+	return crc32.ChecksumIEEE(b[:])
+}
+
+func (path FileEpochStore) Load() (EpochState, error) {
+	data, err := os.ReadFile(string(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return EpochState{}, nil
+	}
+	if err != nil {
+		return EpochState{}, err
+	}
+	var file epochFile
+	if json.Unmarshal(data, &file) != nil || file.Sum != file.checksum() {
+		return EpochState{}, ErrCorruptEpochState
+	}
+	return EpochState{file.Epoch, file.Incarnation}, nil
+}
+
+func (path FileEpochStore) Store(state EpochState) error {
+	file := epochFile{Epoch: state.Epoch, Incarnation: state.Incarnation}
+	file.Sum = file.checksum()
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return FilePeerStore(path).Save(data)
+}
+
+// Epoch and incarnation of a node, continued from the store when the
+// process starts. Every start begins a new epoch, which is stored right
+// away. Incarnations raised by Refute are written back by Run and Close;
+// a crash loses those raised since the last write-back.
+type Epochs struct {
+	store EpochStore
+	clock transport.Clock
+
+	mutex     sync.Mutex
+	state     EpochState
+	stored    EpochState
+	recovered error
+}
+
+func NewEpochs(store EpochStore) *Epochs {
+	return &Epochs{store: store, clock: transport.RealClock}
+}
+
+// Replace the source of time used by Open and Run.
+func (e *Epochs) SetClock(clock transport.Clock) {
+	e.clock = clock
+}
+
+// Load the stored state and begin the next epoch, which is never below
+// the current Unix time in seconds so that a lost store does not rewind
+// it. A state which cannot be loaded is not fatal: both counters jump to
+// the Unix time in nanoseconds, past every value issued under the rule
+// above, and the error is kept for Recovered. Returns the error of
+// storing the new epoch. Must be called once before the counters are used.
+func (e *Epochs) Open() error {
+	state, err := e.store.Load()
+	now := e.clock.Now()
+	floor := uint64(now.Unix())
+	if err != nil {
+		floor = uint64(now.UnixNano())
+		state = EpochState{Incarnation: floor}
+	}
+	state.Epoch = max(state.Epoch+1, floor)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.state, e.recovered = state, err
+	if err := e.store.Store(state); err != nil {
+		return err
+	}
+	e.stored = state
+	return nil
+}
+
+// Error of loading the state which Open recovered from, nil if the state
+// was intact.
+func (e *Epochs) Recovered() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.recovered
+}
+
+func (e *Epochs) Epoch() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.state.Epoch
+}
+
+func (e *Epochs) Incarnation() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.state.Incarnation
+}
+
+// Raise the incarnation above one observed in a rumor about this node and
+// return it; smaller observations leave it unchanged.
+func (e *Epochs) Refute(observed uint64) uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if observed >= e.state.Incarnation {
+		e.state.Incarnation = observed + 1
+	}
+	return e.state.Incarnation
+}
+
+// Write the state back if it changed since it was last stored.
+func (e *Epochs) Flush() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.state == e.stored {
+		return nil
+	}
+	if err := e.store.Store(e.state); err != nil {
+		return err
+	}
+	e.stored = e.state
+	return nil
+}
+
+// Flush every interval, DefaultEpochWriteback if not positive, until done
+// is closed. Errors of the store are passed to report, which may be nil.
+func (e *Epochs) Run(interval time.Duration, report func(error), done <-chan bool) {
+	if interval <= 0 {
+		interval = DefaultEpochWriteback
+	}
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := e.Flush(); err != nil && report != nil {
+				report(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Flush on a clean shutdown, e.g. as the Stop of a shutdown Stage.
+func (e *Epochs) Close() error {
+	return e.Flush()
+}
+
+// Re-advertise the node whenever the connection moves to a new port (see
+// transport.Conn.Relisten): the incarnation is raised and written back so
+// that the new address supersedes rumors about the old one, then passed
+// to advertise with the address. A failed write-back is retried by Run.
+func (e *Epochs) FollowRelisten(conn *transport.Conn, advertise func(addr *net.UDPAddr, incarnation uint64)) {
+	conn.NotifyRelisten(func(from, to *net.UDPAddr) {
+		incarnation := e.Refute(e.Incarnation())
+		e.Flush()
+		advertise(to, incarnation)
+	})
+}
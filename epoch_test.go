@@ -0,0 +1,146 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func openEpochs(t *testing.T, store EpochStore, clock transport.Clock) *Epochs {
+	e := NewEpochs(store)
+	e.SetClock(clock)
+	if err := e.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEpochsRestart(t *testing.T) {
+	start := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := transport.NewManualClock(start)
+	store := FileEpochStore(filepath.Join(t.TempDir(), "epoch.json"))
+
+	first := openEpochs(t, store, clock)
+	if first.Epoch() != uint64(start.Unix()) || first.Incarnation() != 0 || first.Recovered() != nil {
+		t.Fatalf("TestEpochsRestart expected epoch %d for a fresh node got %d.", start.Unix(), first.Epoch())
+	}
+	if n := first.Refute(4); n != 5 || first.Refute(2) != 5 {
+		t.Fatalf("TestEpochsRestart expected incarnation 5 got %d.", n)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restarted within the same second
+	second := openEpochs(t, store, clock)
+	if second.Epoch() != first.Epoch()+1 || second.Incarnation() != 5 {
+		t.Fatalf("TestEpochsRestart expected epoch %d and incarnation 5 got %d and %d.", first.Epoch()+1, second.Epoch(), second.Incarnation())
+	}
+
+	// a lost store does not rewind the epoch past the clock
+	clock.Advance(time.Hour)
+	third := openEpochs(t, FileEpochStore(filepath.Join(t.TempDir(), "epoch.json")), clock)
+	if third.Epoch() != uint64(start.Add(time.Hour).Unix()) {
+		t.Fatalf("TestEpochsRestart expected the epoch to follow the clock got %d.", third.Epoch())
+	}
+}
+
+func TestEpochsCorrupt(t *testing.T) {
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "epoch.json")
+	store := FileEpochStore(path)
+	before := openEpochs(t, store, clock)
+	before.Refute(1 << 40)
+	before.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, damaged := range [][]byte{data[:len(data)/2], []byte(`{"epoch":1,"incarnation":1,"sum":0}`)} {
+		if err := os.WriteFile(path, damaged, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Load(); err != ErrCorruptEpochState {
+			t.Fatalf("TestEpochsCorrupt expected ErrCorruptEpochState for %q got %v.", damaged, err)
+		}
+		after := openEpochs(t, store, clock)
+		if !errors.Is(after.Recovered(), ErrCorruptEpochState) {
+			t.Fatalf("TestEpochsCorrupt expected the damage to be reported got %v.", after.Recovered())
+		}
+		if after.Epoch() <= before.Epoch() || after.Incarnation() <= before.Incarnation() {
+			t.Fatalf("TestEpochsCorrupt expected to jump past %d and %d got %d and %d.", before.Epoch(), before.Incarnation(), after.Epoch(), after.Incarnation())
+		}
+		if state, err := store.Load(); err != nil || state.Epoch != after.Epoch() {
+			t.Fatalf("TestEpochsCorrupt expected the new epoch to be stored got %+v (%v).", state, err)
+		}
+	}
+}
+
+func TestEpochsRun(t *testing.T) {
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := FileEpochStore(filepath.Join(t.TempDir(), "epoch.json"))
+	e := openEpochs(t, store, clock)
+
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		e.Run(time.Minute, func(err error) {
+			t.Errorf("TestEpochsRun unexpected error %v", err)
+		}, done)
+		close(stopped)
+	}()
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	e.Refute(7)
+	clock.Advance(time.Minute)
+	for i := 0; ; i++ {
+		if state, _ := store.Load(); state.Incarnation == 8 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("TestEpochsRun expected the incarnation to be written back.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	<-stopped
+}
+
+func TestEpochsFollowRelisten(t *testing.T) {
+	store := FileEpochStore(filepath.Join(t.TempDir(), "epoch.json"))
+	e := openEpochs(t, store, transport.RealClock)
+	e.Refute(2)
+
+	conn := transport.NewConn()
+	type advertisement struct {
+		port        int
+		incarnation uint64
+	}
+	advertised := make(chan advertisement, 1)
+	e.FollowRelisten(conn, func(addr *net.UDPAddr, incarnation uint64) {
+		advertised <- advertisement{addr.Port, incarnation}
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	<-conn.Events()
+
+	if err := conn.Relisten(0); err != nil {
+		t.Fatal(err)
+	}
+	a := <-advertised
+	if a.port != conn.LocalAddr().Port || a.incarnation != 4 {
+		t.Fatalf("TestEpochsFollowRelisten expected port %d with incarnation 4 got %+v.", conn.LocalAddr().Port, a)
+	}
+	if state, _ := store.Load(); state.Incarnation != 4 {
+		t.Fatalf("TestEpochsFollowRelisten expected the incarnation to be stored got %+v.", state)
+	}
+}
@@ -0,0 +1,251 @@
+// Monitor a cluster: join it through seeds, exchange acknowledged
+// heartbeats with every member and print membership and service changes
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahorn/gossip"
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	port      *uint          = flag.Uint("p", 7946, "listening port")
+	name      *string        = flag.String("name", "", "node name, the host name and port if empty")
+	advertise *string        = flag.String("addr", "127.0.0.1", "address other members reach this node at")
+	tags      *string        = flag.String("tags", "", "comma separated member tags, e.g. service:web=8080")
+	service   *string        = flag.String("service", "web", "service whose providers are printed")
+	interval  *time.Duration = flag.Duration("interval", time.Second, "heartbeat interval")
+	dump      *time.Duration = flag.Duration("dump", 10*time.Second, "interval of the member list")
+)
+
+// When members which stopped answering, or left, are forgotten
+var policy = gossip.ReapPolicy{
+	Horizon:          30 * time.Second,
+	TombstoneHorizon: time.Minute,
+	AntiEntropy:      10 * time.Second,
+}
+
+func usage() {
+	report("usage: cluster [flags] [seed ...]")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	ip := net.ParseIP(*advertise)
+	if ip == nil {
+		report(fmt.Sprintf("Cannot parse address %q", *advertise))
+		os.Exit(2)
+	}
+	if *name == "" {
+		host, _ := os.Hostname()
+		*name = fmt.Sprintf("%s:%d", host, *port)
+	}
+
+	conn := transport.NewConn()
+	go monitor(conn.Err)
+	node := newNode(conn)
+	if err := conn.Listen(*port); err != nil {
+		report(fmt.Sprintf("Cannot listen on port %d because %s", *port, err))
+		os.Exit(3)
+	}
+	defer conn.Disconnect()
+	node.self(&net.UDPAddr{IP: ip, Port: int(*port)})
+
+	if flag.NArg() > 0 {
+		seed, err := gossip.Join(flag.Args(), nil, 0, node.join)
+		if err != nil {
+			report(fmt.Sprintf("Cannot join any of %v because %s", flag.Args(), err))
+			os.Exit(3)
+		}
+		fmt.Printf("joined through %s\n", seed)
+	}
+
+	done := make(chan bool)
+	defer close(done)
+	go node.table.Run(policy.Horizon/2, done)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	beat, list := time.NewTicker(*interval), time.NewTicker(*dump)
+	defer beat.Stop()
+	defer list.Stop()
+	for {
+		select {
+		case <-beat.C:
+			node.heartbeat(gossip.MemberAlive)
+		case <-list.C:
+			node.print()
+		case <-interrupt:
+			node.heartbeat(gossip.MemberLeft)
+			fmt.Println("left")
+			return
+		}
+	}
+}
+
+// Membership as seen by this node. Heartbeats carry the name, incarnation,
+// state and tags of their sender; a member which acknowledges none of the
+// retransmissions of a heartbeat is declared dead.
+type node struct {
+	conn        *transport.Conn
+	roster      *gossip.Roster
+	table       *gossip.MemberTable
+	services    *gossip.Services
+	acker       *gossip.Acker
+	incarnation uint64
+}
+
+func newNode(conn *transport.Conn) *node {
+	table, err := gossip.NewMemberTable(policy)
+	if err != nil {
+		report(err)
+		os.Exit(2)
+	}
+	n := &node{
+		conn:        conn,
+		roster:      gossip.NewRoster(conn),
+		table:       table,
+		services:    gossip.NewServices(),
+		incarnation: uint64(time.Now().UnixNano()),
+	}
+	n.acker = gossip.NewAcker(conn, n.deliver)
+
+	n.roster.OnJoin = func(j gossip.JoinRequest, addr *net.UDPAddr) {
+		fmt.Printf("%s joined from %s\n", j.Name, addr)
+	}
+	table.OnGone = func(m gossip.Member) {
+		n.roster.Remove(m.Name)
+		n.services.Remove(m.Name)
+	}
+	table.OnReap = func(m gossip.Member) {
+		fmt.Printf("%s forgotten\n", m.Name)
+	}
+	n.services.Subscribe(*service, func(providers []net.UDPAddr) {
+		fmt.Printf("%s provided by %v\n", *service, providers)
+	})
+	return n
+}
+
+// List this node, so that nodes joining through it learn its address.
+func (n *node) self(addr *net.UDPAddr) {
+	m := gossip.Member{Name: *name, Addr: addr, State: gossip.MemberAlive, Incarnation: n.incarnation}
+	if *tags != "" {
+		m.Tags = strings.Split(*tags, ",")
+	}
+	n.roster.Add(m.Name, addr)
+	n.update(m)
+	n.services.Update(m.Name, addr.IP, m.Tags)
+}
+
+// Ask the seed for its members and send them heartbeats from now on.
+func (n *node) join(seed string) error {
+	addr, err := net.ResolveUDPAddr("udp", seed)
+	if err != nil {
+		return err
+	}
+	members, err := gossip.JoinRoster(n.conn, addr, gossip.JoinRequest{Name: *name}, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	for member, addr := range members {
+		if member != *name {
+			n.roster.Add(member, addr)
+		}
+	}
+	return nil
+}
+
+// Send a heartbeat in the state to every other member and wait for the
+// acknowledgements, which takes up to one interval.
+func (n *node) heartbeat(state gossip.MemberState) {
+	members := n.roster.Members()
+	delete(members, *name)
+	payload := fmt.Sprintf("%s %d %d %s", *name, n.incarnation, state, *tags)
+	result, err := n.acker.BroadcastAcked(members, []byte(payload), *interval)
+	if err != nil {
+		report(err)
+		return
+	}
+	for _, member := range result.Missing {
+		m, ok := n.table.Get(member)
+		if !ok {
+			// learned from a seed but never heard of
+			n.roster.Remove(member)
+			continue
+		}
+		m.State = gossip.MemberDead
+		n.update(m)
+	}
+}
+
+// Delivery callback of the acker
+func (n *node) deliver(payload []byte, from *net.UDPAddr) {
+	fields := strings.Fields(string(payload))
+	if len(fields) < 3 {
+		return
+	}
+	incarnation, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return
+	}
+	state, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return
+	}
+	m := gossip.Member{
+		Name:        fields[0],
+		Addr:        from,
+		State:       gossip.MemberState(state),
+		Incarnation: incarnation,
+	}
+	if len(fields) > 3 {
+		m.Tags = strings.Split(fields[3], ",")
+	}
+	if n.update(m) && m.State == gossip.MemberAlive {
+		n.roster.Add(m.Name, from)
+		n.services.Update(m.Name, from.IP, m.Tags)
+	}
+}
+
+// Merge the member into the table and print it if it changed state.
+func (n *node) update(m gossip.Member) bool {
+	old, known := n.table.Get(m.Name)
+	if !n.table.Update(m) {
+		return false
+	}
+	if !known || old.State != m.State {
+		fmt.Printf("%s is %s at %s\n", m.Name, m.State, m.Addr)
+	}
+	return true
+}
+
+// Print the members with their state, address and tags.
+func (n *node) print() {
+	members := n.table.Members()
+	fmt.Printf("%d members\n", len(members))
+	for _, m := range members {
+		fmt.Printf("  %-20s %-8s %-21s %s\n", m.Name, m.State, m.Addr, strings.Join(m.Tags, ","))
+	}
+}
+
+// Report socket errors
+func monitor(errors <-chan error) {
+	for err := range errors {
+		report(err)
+	}
+}
+
+// Print an error
+func report(err interface{}) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every example sends from its listening socket, so that peers which only
+// accept the port they reach an example at see its traffic. Each runs
+// against a capture socket which records the source port of the first
+// datagram it receives.
+func TestExamplesSendFromListeningPort(t *testing.T) {
+	if testing.Short() {
+		t.Skip("TestExamplesSendFromListeningPort builds the examples")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("TestExamplesSendFromListeningPort needs the go tool")
+	}
+	dir := t.TempDir()
+
+	examples := []struct {
+		name, pkg string
+		args      func(target string) []string
+		stdin     string
+	}{
+		{"talk", ".", func(target string) []string { return []string{target} }, "hello\n"},
+		{"cluster", "./cluster", func(target string) []string { return []string{"-addr", "127.0.0.1", target} }, ""},
+		{"sendfile", "./sendfile", func(target string) []string { return []string{"-timeout", "2s", target, "file"} }, ""},
+		{"udpbench", "./udpbench", func(target string) []string { return []string{"-d", "2s", target} }, ""},
+	}
+	for _, e := range examples {
+		bin := filepath.Join(dir, e.name)
+		if out, err := exec.Command(gotool, "build", "-o", bin, e.pkg).CombinedOutput(); err != nil {
+			t.Fatalf("TestExamplesSendFromListeningPort cannot build %s: %s\n%s", e.name, err, out)
+		}
+
+		capture, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := freePort(t)
+		args := append([]string{"-p", strconv.Itoa(port)}, e.args(capture.LocalAddr().String())...)
+		cmd := exec.Command(bin, args...)
+		cmd.Stdin = strings.NewReader(e.stdin)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1<<16)
+		capture.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, from, err := capture.ReadFromUDP(buf)
+		cmd.Process.Kill()
+		cmd.Wait()
+		capture.Close()
+		switch {
+		case err != nil:
+			t.Fatalf("TestExamplesSendFromListeningPort expected a datagram from %s got %s.", e.name, err)
+		case from.Port != port:
+			t.Fatalf("TestExamplesSendFromListeningPort expected %s to send from port %d got %d.", e.name, port, from.Port)
+		}
+	}
+}
+
+// Return a port on which nothing listens at the moment.
+func freePort(t *testing.T) int {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	return sock.LocalAddr().(*net.UDPAddr).Port
+}
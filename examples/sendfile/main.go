@@ -0,0 +1,312 @@
+// Transfer files in chunks over retried requests and confirm each complete
+// transfer with an acknowledged notice; -loss drops incoming packets to
+// show the retries at work
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahorn/gossip"
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	port    *uint          = flag.Uint("p", 9999, "listening port")
+	serve   *string        = flag.String("serve", "", "directory whose files are offered")
+	out     *string        = flag.String("o", ".", "directory the requested files are written to")
+	loss    *float64       = flag.Float64("loss", 0, "probability to drop each incoming packet")
+	timeout *time.Duration = flag.Duration("timeout", 10*time.Second, "deadline of each request")
+)
+
+// Bytes of a response besides the chunk: the header of a traced response
+// and the status byte, with room to spare
+const responseOverhead = 64
+
+// First byte of every response
+const (
+	statusOK byte = iota
+	statusError
+)
+
+var ErrChecksum = errors.New("Checksum of the received file does not match")
+
+func usage() {
+	report("usage: sendfile [flags] -serve dir")
+	report("       sendfile [flags] addr name ...")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *serve == "" && flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	conn := transport.NewConn()
+	go monitor(conn.Err)
+	if *loss > 0 {
+		conn.UseShaper(transport.NewShaper(transport.Shaping{Loss: *loss}))
+	}
+
+	if *serve != "" {
+		s := &server{dir: *serve, conn: conn}
+		gossip.NewRequester(conn, s.handle)
+		gossip.NewAcker(conn, s.confirmed)
+		listen(conn)
+		defer conn.Disconnect()
+		select {}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", flag.Arg(0))
+	if err != nil {
+		report(fmt.Sprintf("Cannot resolve %q because %s", flag.Arg(0), err))
+		os.Exit(3)
+	}
+	c := &client{
+		addr:      addr,
+		requester: gossip.NewRequester(conn, nil),
+		acker:     gossip.NewAcker(conn, nil),
+		progress:  make(map[string]*transfer),
+	}
+	listen(conn)
+	defer conn.Disconnect()
+
+	done := make(chan bool)
+	go c.show(done)
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for _, name := range flag.Args()[1:] {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := c.fetch(name); err != nil {
+				report(fmt.Sprintf("Cannot fetch %q because %s", name, err))
+				failed.Store(true)
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(done)
+	c.print()
+	fmt.Printf("\n%d requests retransmitted\n", c.retries.Load())
+	if failed.Load() {
+		os.Exit(1)
+	}
+}
+
+func listen(conn *transport.Conn) {
+	if err := conn.Listen(*port); err != nil {
+		report(fmt.Sprintf("Cannot listen on port %d because %s", *port, err))
+		os.Exit(3)
+	}
+}
+
+// Offers the files of a directory. Requests are "stat name", answered
+// with the size and SHA-256 of the file, and "chunk name offset",
+// answered with as many bytes from the offset as fit into a datagram.
+type server struct {
+	dir  string
+	conn *transport.Conn
+}
+
+func (s *server) handle(req []byte, from *net.UDPAddr) []byte {
+	fields := strings.Fields(string(req))
+	if len(fields) < 2 || filepath.Base(fields[1]) != fields[1] {
+		return failure(errors.New("Malformed request"))
+	}
+	f, err := os.Open(filepath.Join(s.dir, fields[1]))
+	if err != nil {
+		return failure(err)
+	}
+	defer f.Close()
+
+	switch {
+	case fields[0] == "stat" && len(fields) == 2:
+		hash := sha256.New()
+		size, err := io.Copy(hash, f)
+		if err != nil {
+			return failure(err)
+		}
+		return append([]byte{statusOK}, fmt.Sprintf("%d %x", size, hash.Sum(nil))...)
+	case fields[0] == "chunk" && len(fields) == 3:
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return failure(err)
+		}
+		b := make([]byte, s.conn.MaxPayloadTo(from)-responseOverhead)
+		n, err := f.ReadAt(b[1:], offset)
+		if err != nil && err != io.EOF {
+			return failure(err)
+		}
+		return b[:1+n]
+	}
+	return failure(errors.New("Unknown request"))
+}
+
+// Delivery callback of the acker, which receives the notices of complete
+// transfers
+func (s *server) confirmed(payload []byte, from *net.UDPAddr) {
+	fmt.Printf("%s received %s\n", from, payload)
+}
+
+func failure(err error) []byte {
+	return append([]byte{statusError}, err.Error()...)
+}
+
+// Fetches files from a server, each chunk by a request which is retried
+// until answered or the deadline passed.
+type client struct {
+	addr      *net.UDPAddr
+	requester *gossip.Requester
+	acker     *gossip.Acker
+
+	// attempts after the first of any request
+	retries atomic.Uint64
+
+	mutex    sync.Mutex
+	progress map[string]*transfer
+	names    []string
+}
+
+type transfer struct {
+	received, size int64
+}
+
+func (c *client) fetch(name string) error {
+	stat, err := c.request("stat " + name)
+	if err != nil {
+		return err
+	}
+	var size int64
+	var sum string
+	if _, err := fmt.Sscanf(string(stat), "%d %s", &size, &sum); err != nil {
+		return err
+	}
+	t := c.start(name, size)
+
+	f, err := os.Create(filepath.Join(*out, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	for offset := int64(0); offset < size; {
+		chunk, err := c.request(fmt.Sprintf("chunk %s %d", name, offset))
+		if err != nil {
+			return err
+		}
+		if len(chunk) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		offset += int64(len(chunk))
+		atomic.StoreInt64(&t.received, offset)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return ErrChecksum
+	}
+
+	// tell the server, which may have given up on us
+	notice := fmt.Sprintf("%s %s", name, sum)
+	result, err := c.acker.BroadcastAcked(map[string]*net.UDPAddr{"server": c.addr}, []byte(notice), *timeout)
+	if err == nil && !result.Complete() {
+		report(fmt.Sprintf("Server did not confirm %q", name))
+	}
+	return err
+}
+
+// Send the request until it is answered and return the response without
+// its status.
+func (c *client) request(msg string) ([]byte, error) {
+	response, err := c.requester.RequestWithRetry([]byte(msg), c.addr, gossip.RetryOptions{
+		Deadline: time.Now().Add(*timeout),
+		Backoff:  c.backoff,
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case len(response) == 0:
+		return nil, errors.New("Empty response")
+	case response[0] != statusOK:
+		return nil, errors.New(string(response[1:]))
+	}
+	return response[1:], nil
+}
+
+var backoff = gossip.ExponentialBackoff(50*time.Millisecond, time.Second)
+
+// Backoff of the requests which counts the retries
+func (c *client) backoff(attempt int) time.Duration {
+	if attempt > 0 {
+		c.retries.Add(1)
+	}
+	return backoff(attempt)
+}
+
+func (c *client) start(name string, size int64) *transfer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &transfer{size: size}
+	c.progress[name] = t
+	c.names = append(c.names, name)
+	return t
+}
+
+// Print the progress of every transfer until done is closed.
+func (c *client) show(done <-chan bool) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.print()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *client) print() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var line strings.Builder
+	for _, name := range c.names {
+		t := c.progress[name]
+		percent := int64(100)
+		if t.size > 0 {
+			percent = atomic.LoadInt64(&t.received) * 100 / t.size
+		}
+		fmt.Fprintf(&line, "%s %3d%%  ", name, percent)
+	}
+	fmt.Printf("\r%s", line.String())
+}
+
+// Report socket errors
+func monitor(errors <-chan error) {
+	for err := range errors {
+		report(err)
+	}
+}
+
+// Print an error
+func report(err interface{}) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
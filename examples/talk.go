@@ -2,12 +2,14 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
-	"os"
-	"bufio"
+	"io"
 	"net"
-	"github.com/ahorn/gossip"
+	"os"
+
+	"github.com/ahorn/gossip/transport"
 )
 
 var port *uint = flag.Uint("p", 9999, "listening port")
@@ -24,19 +26,19 @@ func main() {
 		os.Exit(2)
 	}
 
-	conn := gossip.NewConn()
+	conn := transport.NewConn()
 	go monitor(conn.Err)
 
 	conn.AddHandler(echo)
 	if err := conn.Listen(*port); err != nil {
-		report(fmt.Sprintf("Cannot listen on port %s because %s", *port, err))
+		report(fmt.Sprintf("Cannot listen on port %d because %s", *port, err))
 		os.Exit(3)
 	}
 	defer conn.Disconnect()
 
 	// parse the destination address of the form host:port
 	addr := flag.Arg(0)
-	udpAddr, err := net.ResolveUDPAddr(addr)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		report(fmt.Sprintf("Cannot resolve %q because %s", addr, err))
 		os.Exit(3)
@@ -45,9 +47,10 @@ func main() {
 	lines := make(chan string, 8)
 	go read(lines)
 
-	// loop until EOF
+	// loop until EOF, sending from the listening socket so that the
+	// partner sees the port it replies to
 	for line := range lines {
-		conn.UnicastTo([]byte(line), udpAddr)
+		conn.SendTo([]byte(line), udpAddr)
 	}
 }
 
@@ -57,10 +60,10 @@ func read(lines chan<- string) {
 	reader := bufio.NewReader(os.Stdin)
 
 	var line string
-	var err os.Error
+	var err error
 	for {
 		line, err = reader.ReadString('\n')
-		if err == os.EOF {
+		if err == io.EOF {
 			break
 		}
 		lines <- line
@@ -70,13 +73,13 @@ func read(lines chan<- string) {
 }
 
 // Event handler to print the response of the conversation partner
-func echo(conn *gossip.Conn, p *gossip.Packet) {
+func echo(conn *transport.Conn, p *transport.Packet) {
 	line := string([]byte(p.Msg))
 	fmt.Print(">", line)
 }
 
 // Report socket errors
-func monitor(errors <-chan os.Error) {
+func monitor(errors <-chan error) {
 	for err := range errors {
 		report(err)
 	}
@@ -0,0 +1,219 @@
+// Measure throughput, loss and round-trip latency of the transport
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	port      *uint          = flag.Uint("p", 9999, "listening port")
+	reflector *bool          = flag.Bool("reflect", false, "echo every packet back to its sender")
+	size      *int           = flag.Int("size", 64, "message size in bytes")
+	rate      *int           = flag.Int("rate", 1000, "messages per second")
+	duration  *time.Duration = flag.Duration("d", 5*time.Second, "duration of the run")
+	handlers  *int           = flag.Int("handlers", 0, "max concurrent handlers, 0 for unlimited")
+	csv       *bool          = flag.Bool("csv", false, "print results as CSV")
+)
+
+// Every message starts with its sequence number and the time it was sent
+const headerSize = 16
+
+func usage() {
+	report("usage: udpbench [flags] -reflect")
+	report("       udpbench [flags] addr")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if !*reflector && flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	if *rate < 1 {
+		report("Rate must be at least one message per second")
+		os.Exit(2)
+	}
+	if *size < headerSize || *size > transport.MessageSize {
+		report(fmt.Sprintf("Message size must be between %d and %d", headerSize, transport.MessageSize))
+		os.Exit(2)
+	}
+
+	conn := transport.NewConn()
+	conn.SetHandlerLimit(*handlers, transport.WaitWhenSaturated)
+	go monitor(conn.Err)
+
+	if *reflector {
+		conn.AddHandler(echo)
+	}
+	results := newResults()
+	if !*reflector {
+		conn.AddHandler(results.record)
+	}
+
+	if err := conn.Listen(*port); err != nil {
+		report(fmt.Sprintf("Cannot listen on port %d because %s", *port, err))
+		os.Exit(3)
+	}
+	defer conn.Disconnect()
+
+	if *reflector {
+		select {}
+	}
+
+	addr := flag.Arg(0)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		report(fmt.Sprintf("Cannot resolve %q because %s", addr, err))
+		os.Exit(3)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	sent, elapsed := blast(conn, udpAddr)
+
+	// give late replies a chance to arrive
+	time.Sleep(time.Second)
+	runtime.ReadMemStats(&after)
+
+	results.print(sent, elapsed, after.Mallocs-before.Mallocs)
+}
+
+// Send messages at the configured rate until the duration has elapsed.
+func blast(conn *transport.Conn, addr *net.UDPAddr) (sent uint64, elapsed time.Duration) {
+	interval := time.Second / time.Duration(*rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	deadline := time.After(*duration)
+	for {
+		select {
+		case now := <-ticker.C:
+			msg := make(transport.Message, *size)
+			binary.BigEndian.PutUint64(msg, sent)
+			binary.BigEndian.PutUint64(msg[8:], uint64(now.UnixNano()))
+			if err := conn.SendTo(msg, addr); err != nil {
+				report(err)
+				return sent, time.Since(start)
+			}
+			sent++
+		case <-deadline:
+			return sent, time.Since(start)
+		}
+	}
+}
+
+// Event handler of the reflector
+func echo(conn *transport.Conn, p *transport.Packet) {
+	conn.SendTo(p.Msg, p.Addr)
+}
+
+// Round-trip times of the replies, indexed by sequence number
+type results struct {
+	mutex sync.Mutex
+	rtts  map[uint64]time.Duration
+}
+
+func newResults() *results {
+	return &results{rtts: make(map[uint64]time.Duration)}
+}
+
+// Event handler of the sender
+func (r *results) record(conn *transport.Conn, p *transport.Packet) {
+	if len(p.Msg) < headerSize {
+		return
+	}
+	seq := binary.BigEndian.Uint64(p.Msg)
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(p.Msg[8:])))
+
+	r.mutex.Lock()
+	r.rtts[seq] = time.Since(sentAt)
+	r.mutex.Unlock()
+}
+
+func (r *results) print(sent uint64, elapsed time.Duration, mallocs uint64) {
+	r.mutex.Lock()
+	rtts := make([]time.Duration, 0, len(r.rtts))
+	for _, rtt := range r.rtts {
+		rtts = append(rtts, rtt)
+	}
+	r.mutex.Unlock()
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+
+	received := uint64(len(rtts))
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-received) / float64(sent)
+	}
+
+	rows := [][2]string{
+		{"size", fmt.Sprint(*size)},
+		{"handlers", fmt.Sprint(*handlers)},
+		{"sent", fmt.Sprint(sent)},
+		{"received", fmt.Sprint(received)},
+		{"loss_pct", fmt.Sprintf("%.2f", loss)},
+		{"pps", fmt.Sprintf("%.0f", float64(sent)/elapsed.Seconds())},
+		{"rtt_p50", percentile(rtts, 50).String()},
+		{"rtt_p90", percentile(rtts, 90).String()},
+		{"rtt_p99", percentile(rtts, 99).String()},
+		{"rtt_max", percentile(rtts, 100).String()},
+		{"allocs_per_msg", fmt.Sprintf("%.1f", float64(mallocs)/float64(sent+received))},
+	}
+
+	if *csv {
+		for i, row := range rows {
+			if i > 0 {
+				fmt.Print(",")
+			}
+			fmt.Print(row[0])
+		}
+		fmt.Println()
+		for i, row := range rows {
+			if i > 0 {
+				fmt.Print(",")
+			}
+			fmt.Print(row[1])
+		}
+		fmt.Println()
+		return
+	}
+	for _, row := range rows {
+		fmt.Printf("%-16s %s\n", row[0], row[1])
+	}
+}
+
+// Returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
+
+// Report socket errors
+func monitor(errors <-chan error) {
+	for err := range errors {
+		report(err)
+	}
+}
+
+// Print an error
+func report(err interface{}) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
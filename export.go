@@ -0,0 +1,117 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+)
+
+// Version of the encoding written by ExportState
+const StateVersion = 1
+
+var (
+	ErrStateVersion = errors.New("State version is not supported")
+	ErrCorruptState = errors.New("State is corrupt")
+)
+
+// Exported representation of the table. The checksum covers the members
+// exactly as they were written.
+type stateFile struct {
+	Version int             `json:"version"`
+	Members json.RawMessage `json:"members"`
+	Sum     uint32          `json:"sum"`
+}
+
+type stateMember struct {
+	Name        string   `json:"name"`
+	Addr        string   `json:"addr,omitempty"`
+	Addrs       []string `json:"addrs,omitempty"`
+	State       string   `json:"state"`
+	Incarnation uint64   `json:"incarnation"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func parseMemberState(s string) (MemberState, bool) {
+	for state := MemberAlive; state <= MemberLeft; state++ {
+		if state.String() == s {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+// Write the members listed right now, for analysis tools or to seed
+// another node with ImportState. The encoding is JSON, versioned by
+// StateVersion and checksummed.
+func (t *MemberTable) ExportState(w io.Writer) error {
+	members := t.Members()
+	entries := make([]stateMember, len(members))
+	for i, m := range members {
+		entries[i] = stateMember{Name: m.Name, State: m.State.String(), Incarnation: m.Incarnation, Tags: m.Tags}
+		if m.Addr != nil {
+			entries[i].Addr = m.Addr.String()
+		}
+		for _, addr := range m.Addrs {
+			entries[i].Addrs = append(entries[i].Addrs, addr.String())
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(stateFile{StateVersion, data, crc32.ChecksumIEEE(data)})
+}
+
+// Merge the members written by ExportState and return how many changed
+// the table. The entries are only hints: each goes through Update, so
+// fresher gossip and tombstones win over them. Nothing is merged unless
+// the whole state verifies.
+func (t *MemberTable) ImportState(r io.Reader) (int, error) {
+	var file stateFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return 0, ErrCorruptState
+	}
+	if file.Version != StateVersion {
+		return 0, ErrStateVersion
+	}
+	if file.Sum != crc32.ChecksumIEEE(file.Members) {
+		return 0, ErrCorruptState
+	}
+	var entries []stateMember
+	if err := json.Unmarshal(file.Members, &entries); err != nil {
+		return 0, ErrCorruptState
+	}
+	members := make([]Member, len(entries))
+	for i, entry := range entries {
+		state, ok := parseMemberState(entry.State)
+		if !ok || entry.Name == "" {
+			return 0, ErrCorruptState
+		}
+		members[i] = Member{Name: entry.Name, State: state, Incarnation: entry.Incarnation, Tags: entry.Tags}
+		if entry.Addr != "" {
+			addr, err := netip.ParseAddrPort(entry.Addr)
+			if err != nil {
+				return 0, ErrCorruptState
+			}
+			members[i].Addr = net.UDPAddrFromAddrPort(addr)
+		}
+		for _, s := range entry.Addrs {
+			addr, err := netip.ParseAddrPort(s)
+			if err != nil {
+				return 0, ErrCorruptState
+			}
+			members[i].Addrs = append(members[i].Addrs, net.UDPAddrFromAddrPort(addr))
+		}
+	}
+
+	changed := 0
+	for _, m := range members {
+		if t.Update(m) {
+			changed++
+		}
+	}
+	return changed, nil
+}
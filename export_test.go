@@ -0,0 +1,99 @@
+package gossip
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestTable(t *testing.T) *MemberTable {
+	table, err := NewMemberTable(ReapPolicy{Horizon: time.Minute, TombstoneHorizon: time.Minute, AntiEntropy: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestExportStateRoundTrip(t *testing.T) {
+	table := newTestTable(t)
+	table.Update(Member{Name: "a", Addr: peerAddr(1), Incarnation: 3, Tags: []string{"service:web=8080"}})
+	table.Update(Member{Name: "b", Addr: peerAddr(2), State: MemberSuspect, Incarnation: 1})
+	table.Update(Member{Name: "c", State: MemberLeft, Incarnation: 7})
+
+	var buf bytes.Buffer
+	if err := table.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	other := newTestTable(t)
+	if n, err := other.ImportState(bytes.NewReader(buf.Bytes())); n != 3 || err != nil {
+		t.Fatalf("TestExportStateRoundTrip expected 3 members imported got %d, %v.", n, err)
+	}
+	want, got := table.Members(), other.Members()
+	for i := range want {
+		want[i].Changed, got[i].Changed = time.Time{}, time.Time{}
+	}
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		t.Fatalf("TestExportStateRoundTrip expected %+v got %+v.", want, got)
+	}
+
+	// importing the same state again changes nothing
+	if n, err := other.ImportState(bytes.NewReader(buf.Bytes())); n != 0 || err != nil {
+		t.Fatalf("TestExportStateRoundTrip expected no changes got %d, %v.", n, err)
+	}
+}
+
+func TestImportStateStale(t *testing.T) {
+	old := newTestTable(t)
+	old.Update(Member{Name: "a", Addr: peerAddr(1), Incarnation: 1})
+	old.Update(Member{Name: "b", Addr: peerAddr(2), State: MemberDead, Incarnation: 2})
+	old.Update(Member{Name: "c", Addr: peerAddr(3), Incarnation: 1})
+	var buf bytes.Buffer
+	if err := old.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	live := newTestTable(t)
+	live.Update(Member{Name: "a", Addr: peerAddr(4), Incarnation: 2})
+	live.Update(Member{Name: "b", Addr: peerAddr(2), Incarnation: 3})
+	if n, err := live.ImportState(&buf); n != 1 || err != nil {
+		t.Fatalf("TestImportStateStale expected only c to be imported got %d, %v.", n, err)
+	}
+	if m, _ := live.Get("a"); m.Incarnation != 2 || m.Addr.Port != peerAddr(4).Port {
+		t.Fatalf("TestImportStateStale expected a to keep its live address got %+v.", m)
+	}
+	if m, _ := live.Get("b"); m.State != MemberAlive || m.Incarnation != 3 {
+		t.Fatalf("TestImportStateStale expected b to stay alive got %+v.", m)
+	}
+	if _, ok := live.Get("c"); !ok {
+		t.Fatalf("TestImportStateStale expected c to be seeded.")
+	}
+}
+
+func TestImportStateCorrupt(t *testing.T) {
+	table := newTestTable(t)
+	table.Update(Member{Name: "a", Addr: peerAddr(1), Incarnation: 1})
+	var buf bytes.Buffer
+	if err := table.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{bytes.Replace(data, []byte(`"incarnation":1`), []byte(`"incarnation":9`), 1), ErrCorruptState},
+		{bytes.Replace(data, []byte(`"version":1`), []byte(`"version":2`), 1), ErrStateVersion},
+		{data[:len(data)/2], ErrCorruptState},
+	}
+	for _, test := range tests {
+		other := newTestTable(t)
+		if n, err := other.ImportState(bytes.NewReader(test.data)); n != 0 || err != test.err {
+			t.Fatalf("TestImportStateCorrupt expected %v for %s got %d, %v.", test.err, test.data, n, err)
+		}
+		if len(other.Members()) != 0 {
+			t.Fatalf("TestImportStateCorrupt expected nothing to be merged.")
+		}
+	}
+}
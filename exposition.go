@@ -0,0 +1,185 @@
+package gossip
+
+import (
+	"io"
+
+	"github.com/ahorn/gossip/internal/promtext"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Components of a node whose counters WriteMetrics renders; nil ones are
+// left out.
+type Metrics struct {
+	Conn       *transport.Conn
+	Wire       *WireMetrics
+	Members    *MemberTable
+	Rumors     *Rumors
+	Retransmit *Retransmit
+	Sequence   *SequenceGuard
+	Churn      *ChurnBackoff
+	Warmup     *Warmup
+	Syncer     *Syncer
+	Inspector  *Inspector
+	Requester  *Requester
+	Acker      *Acker
+	Tracer     *Tracer
+	Bridge     *Bridge
+}
+
+// Render the counters of every component in the Prometheus text exposition
+// format, those of the Conn first (see transport.Conn.WriteMetrics). The
+// names are stable:
+//
+//	gossip_subsystem_bytes_total            counter, subsystem, direction: in, out
+//	gossip_subsystem_packets_total          counter, subsystem, direction
+//	gossip_subsystem_piggybacked_total      counter, subsystem, direction
+//	gossip_members                          gauge
+//	gossip_member_tombstones                gauge
+//	gossip_members_reaped_total             counter
+//	gossip_member_resurrections_total       counter
+//	gossip_rumors_received_total            counter
+//	gossip_rumors_duplicate_total           counter
+//	gossip_retransmit_count                 gauge
+//	gossip_retransmit_loss_ratio            gauge
+//	gossip_sequence_packets_total           counter, result: accepted, replayed, unsequenced
+//	gossip_churn_probe_interval_seconds     gauge
+//	gossip_churn_events                     gauge
+//	gossip_churn_adjustments_total          counter, direction: stretched, shortened
+//	gossip_warmup_active                    gauge
+//	gossip_warmup_suppressed_total          counter
+//	gossip_sync_rounds_total                counter, trigger: periodic, on_demand
+//	gossip_inspector_packets_total          counter, result: seen, inspected, dropped
+//	gossip_cache_entries                    gauge, cache: rumors, sequence, requests, acks, traces
+//	gossip_cache_limit                      gauge, cache
+//	gossip_cache_evictions_total            counter, cache
+//	gossip_bridge_messages_total            counter, direction: a_to_b, b_to_a, result: forwarded, looped, filtered
+func (m *Metrics) WriteMetrics(w io.Writer) error {
+	if m.Conn != nil {
+		if err := m.Conn.WriteMetrics(w); err != nil {
+			return err
+		}
+	}
+	p := promtext.NewWriter(w)
+
+	if m.Wire != nil {
+		traffic := m.Wire.Metrics()
+		for _, f := range []struct {
+			name, help string
+			value      func(Traffic) uint64
+		}{
+			{"gossip_subsystem_bytes_total", "Bytes of the segments of the subsystem, headers included.", func(t Traffic) uint64 { return t.Bytes }},
+			{"gossip_subsystem_packets_total", "Messages the subsystem carried.", func(t Traffic) uint64 { return t.Packets }},
+			{"gossip_subsystem_piggybacked_total", "Messages the subsystem rode along on.", func(t Traffic) uint64 { return t.Piggybacked }},
+		} {
+			p.Family(f.name, promtext.Counter, f.help)
+			for s := Subsystem(0); s < subsystemCount; s++ {
+				p.Sample(f.name, float64(f.value(traffic[s].In)), "subsystem", s.String(), "direction", "in")
+				p.Sample(f.name, float64(f.value(traffic[s].Out)), "subsystem", s.String(), "direction", "out")
+			}
+		}
+	}
+	if m.Members != nil {
+		s := m.Members.Stats()
+		p.Gauge("gossip_members", "Members listed.", float64(s.Members))
+		p.Gauge("gossip_member_tombstones", "Tombstones of reaped members.", float64(s.Tombstones))
+		p.Counter("gossip_members_reaped_total", "Members reaped.", float64(s.Reaped))
+		p.Counter("gossip_member_resurrections_total", "Updates rejected by a tombstone.", float64(s.Resurrections))
+	}
+	if m.Rumors != nil {
+		s := m.Rumors.Stats()
+		p.Counter("gossip_rumors_received_total", "Rumors received for the first time.", float64(s.Received))
+		p.Counter("gossip_rumors_duplicate_total", "Rumors received again.", float64(s.Duplicates))
+	}
+	if m.Retransmit != nil {
+		s := m.Retransmit.Stats()
+		p.Gauge("gossip_retransmit_count", "Transmissions per message currently used.", float64(s.Count))
+		p.Gauge("gossip_retransmit_loss_ratio", "Mean estimated loss over all known peers.", s.Loss)
+	}
+	if m.Sequence != nil {
+		s := m.Sequence.Stats()
+		p.Family("gossip_sequence_packets_total", promtext.Counter, "Packets checked by the replay guard.")
+		p.Sample("gossip_sequence_packets_total", float64(s.Accepted), "result", "accepted")
+		p.Sample("gossip_sequence_packets_total", float64(s.Replayed), "result", "replayed")
+		p.Sample("gossip_sequence_packets_total", float64(s.Unsequenced), "result", "unsequenced")
+	}
+	if m.Churn != nil {
+		s := m.Churn.Stats()
+		p.Gauge("gossip_churn_probe_interval_seconds", "Effective probe interval.", s.Interval.Seconds())
+		p.Gauge("gossip_churn_events", "Membership events in the last completed window.", float64(s.Events))
+		p.Family("gossip_churn_adjustments_total", promtext.Counter, "Times the probe interval was adjusted.")
+		p.Sample("gossip_churn_adjustments_total", float64(s.Stretched), "direction", "stretched")
+		p.Sample("gossip_churn_adjustments_total", float64(s.Shortened), "direction", "shortened")
+	}
+	if m.Warmup != nil {
+		s := m.Warmup.Stats()
+		active := 0.0
+		if s.Active {
+			active = 1
+		}
+		p.Gauge("gossip_warmup_active", "Whether the node is warming up.", active)
+		p.Counter("gossip_warmup_suppressed_total", "Suspicions suppressed while warming up.", float64(s.Suppressed))
+	}
+	if m.Syncer != nil {
+		s := m.Syncer.Stats()
+		p.Family("gossip_sync_rounds_total", promtext.Counter, "Synchronization rounds run.")
+		p.Sample("gossip_sync_rounds_total", float64(s.Periodic), "trigger", "periodic")
+		p.Sample("gossip_sync_rounds_total", float64(s.OnDemand), "trigger", "on_demand")
+	}
+	if m.Inspector != nil {
+		s := m.Inspector.Stats()
+		p.Family("gossip_inspector_packets_total", promtext.Counter, "Packets considered by the inspector.")
+		p.Sample("gossip_inspector_packets_total", float64(s.Seen), "result", "seen")
+		p.Sample("gossip_inspector_packets_total", float64(s.Inspected), "result", "inspected")
+		p.Sample("gossip_inspector_packets_total", float64(s.Dropped), "result", "dropped")
+	}
+
+	var caches []string
+	var stats []CacheStats
+	cache := func(name string, s CacheStats) {
+		caches, stats = append(caches, name), append(stats, s)
+	}
+	if m.Rumors != nil {
+		cache("rumors", m.Rumors.CacheStats())
+	}
+	if m.Sequence != nil {
+		cache("sequence", m.Sequence.CacheStats())
+	}
+	if m.Requester != nil {
+		cache("requests", m.Requester.CacheStats())
+	}
+	if m.Acker != nil {
+		cache("acks", m.Acker.CacheStats())
+	}
+	if m.Tracer != nil {
+		cache("traces", m.Tracer.CacheStats())
+	}
+	if len(caches) > 0 {
+		for _, f := range []struct {
+			name, typ, help string
+			value           func(CacheStats) float64
+		}{
+			{"gossip_cache_entries", promtext.Gauge, "Entries of the cache.", func(s CacheStats) float64 { return float64(s.Entries) }},
+			{"gossip_cache_limit", promtext.Gauge, "Bound of the entries of the cache.", func(s CacheStats) float64 { return float64(s.Limit) }},
+			{"gossip_cache_evictions_total", promtext.Counter, "Entries evicted at the bound.", func(s CacheStats) float64 { return float64(s.Evictions) }},
+		} {
+			p.Family(f.name, f.typ, f.help)
+			for i, name := range caches {
+				p.Sample(f.name, f.value(stats[i]), "cache", name)
+			}
+		}
+	}
+
+	if m.Bridge != nil {
+		aToB, bToA := m.Bridge.Stats()
+		p.Family("gossip_bridge_messages_total", promtext.Counter, "Messages handled by the bridge.")
+		for _, d := range []struct {
+			direction string
+			s         BridgeStats
+		}{{"a_to_b", aToB}, {"b_to_a", bToA}} {
+			p.Sample("gossip_bridge_messages_total", float64(d.s.Forwarded), "direction", d.direction, "result", "forwarded")
+			p.Sample("gossip_bridge_messages_total", float64(d.s.Looped), "direction", d.direction, "result", "looped")
+			p.Sample("gossip_bridge_messages_total", float64(d.s.Filtered), "direction", d.direction, "result", "filtered")
+		}
+	}
+	return p.Err()
+}
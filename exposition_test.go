@@ -0,0 +1,85 @@
+package gossip
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var (
+	volatilePort   = regexp.MustCompile(`peer="127\.0\.0\.1:\d+"`)
+	volatileSample = regexp.MustCompile(`(?m)^(gossip_transport_(handlers_high_water|handler_\w*seconds\w*|peer_bytes)(\{[^}]*\})?) .*$`)
+)
+
+func TestWriteMetrics(t *testing.T) {
+	g := gossiptest.NewPair(t)
+	conn := g.Conns[0]
+	wire := NewWireMetrics()
+	wire.Attach(conn)
+	members := newTestTable(t)
+	members.Update(Member{Name: "b", Addr: g.Addrs[1], Incarnation: 1})
+	m := &Metrics{
+		Conn:       conn,
+		Wire:       wire,
+		Members:    members,
+		Rumors:     NewRumors(),
+		Retransmit: NewRetransmit(1, 4),
+		Sequence:   NewSequenceGuard(nil),
+		Churn:      NewChurnBackoff(time.Second, 10*time.Second, 5),
+		Warmup:     NewWarmup(0),
+		Syncer:     NewSyncer(func() {}),
+		Requester:  NewRequester(conn, nil),
+		Acker:      NewAcker(conn, nil),
+	}
+	conn.AddNamedHandler("app", func(conn *transport.Conn, p *transport.Packet) {})
+
+	if err := g.Conns[1].SendTo(EncodeSegments(Segment{SubsystemProbe, []byte("ping")}), g.Addrs[0]); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !handled(conn.Stats().Handlers) {
+		if time.Now().After(deadline) {
+			t.Fatalf("TestWriteMetrics expected every handler to be called got %+v.", conn.Stats().Handlers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var b bytes.Buffer
+	if err := m.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := volatilePort.ReplaceAll(b.Bytes(), []byte(`peer="127.0.0.1:PORT"`))
+	out = volatileSample.ReplaceAll(out, []byte("$1 VALUE"))
+
+	path := filepath.Join("testdata", "metrics.prom")
+	if *update {
+		if err := os.WriteFile(path, out, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, golden) {
+		t.Fatalf("TestWriteMetrics expected\n%s\ngot\n%s", golden, out)
+	}
+}
+
+func handled(handlers []transport.HandlerStats) bool {
+	for _, h := range handlers {
+		if h.Calls == 0 {
+			return false
+		}
+	}
+	return len(handlers) > 0
+}
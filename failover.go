@@ -0,0 +1,231 @@
+package gossip
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Defaults of NewAddrFailover
+const (
+	// Consecutive lost probes of the address in use after which the next
+	// address of the member is tried
+	DefaultFailoverThreshold = 3
+
+	// Period for which an address is known to be reachable or not
+	DefaultReachabilityTTL = time.Minute
+)
+
+// Picks the address through which this node reaches each member among
+// those it advertises in Member.Addrs. The first contact tries them in
+// order of preference; afterwards the failure detector probes the address
+// in use and reports every outcome, and after repeated losses the next
+// address takes over before the member is suspected. Reachability is
+// remembered per address, so an address which failed for one member is
+// skipped for the others within the TTL.
+type AddrFailover struct {
+	threshold int
+	ttl       time.Duration
+	clock     transport.Clock
+
+	// Called outside the lock whenever a member fails over to another
+	// address, along with the address it had; see FollowMoves
+	OnMove func(m Member, from *net.UDPAddr)
+
+	mutex   sync.Mutex
+	members map[string]*memberPath
+	reach   map[netip.AddrPort]reachability
+}
+
+// Address in use for a member and the probes lost on it in a row
+type memberPath struct {
+	addrs   []*net.UDPAddr
+	current int
+	losses  int
+}
+
+type reachability struct {
+	ok      bool
+	checked time.Time
+}
+
+// Create a failover which moves on after threshold lost probes and keeps
+// reachability for ttl; zero values select the defaults.
+func NewAddrFailover(threshold int, ttl time.Duration) *AddrFailover {
+	if threshold <= 0 {
+		threshold = DefaultFailoverThreshold
+	}
+	if ttl <= 0 {
+		ttl = DefaultReachabilityTTL
+	}
+	return &AddrFailover{
+		threshold: threshold,
+		ttl:       ttl,
+		clock:     transport.RealClock,
+		members:   make(map[string]*memberPath),
+		reach:     make(map[netip.AddrPort]reachability),
+	}
+}
+
+// Replace the source of time of the reachability TTL.
+func (f *AddrFailover) SetClock(clock transport.Clock) {
+	f.clock = clock
+}
+
+// Addresses of the member in order of preference, Addr alone if it
+// advertises no list.
+func memberAddrs(m Member) []*net.UDPAddr {
+	if len(m.Addrs) > 0 {
+		return m.Addrs
+	}
+	if m.Addr != nil {
+		return []*net.UDPAddr{m.Addr}
+	}
+	return nil
+}
+
+// Path of the member, restarted if its address list changed; must hold
+// the mutex.
+func (f *AddrFailover) path(m Member) *memberPath {
+	addrs := memberAddrs(m)
+	p, ok := f.members[m.Name]
+	if ok && len(p.addrs) == len(addrs) {
+		same := true
+		for i := range addrs {
+			same = same && sameAddr(p.addrs[i], addrs[i])
+		}
+		if same {
+			return p
+		}
+	}
+	p = &memberPath{addrs: addrs}
+	f.members[m.Name] = p
+	return p
+}
+
+// Whether the address was found unreachable within the TTL; must hold the
+// mutex.
+func (f *AddrFailover) unreachable(addr *net.UDPAddr, now time.Time) bool {
+	r, ok := f.reach[addrKey(addr)]
+	return ok && !r.ok && now.Sub(r.checked) < f.ttl
+}
+
+// Reach the member for the first time: its addresses are tried in order,
+// skipping those known to be unreachable, until the check passes, e.g. a
+// ping through a Requester. The outcome of every check is remembered.
+// Returns nil if no address passed.
+func (f *AddrFailover) Contact(m Member, reachable func(addr *net.UDPAddr) bool) *net.UDPAddr {
+	now := f.clock.Now()
+	f.mutex.Lock()
+	p := f.path(m)
+	addrs := p.addrs
+	var candidates []*net.UDPAddr
+	for _, addr := range addrs {
+		if !f.unreachable(addr, now) {
+			candidates = append(candidates, addr)
+		}
+	}
+	f.mutex.Unlock()
+
+	for _, addr := range candidates {
+		ok := reachable(addr)
+		f.mutex.Lock()
+		f.reach[addrKey(addr)] = reachability{ok, f.clock.Now()}
+		if ok {
+			p = f.path(m)
+			for i := range p.addrs {
+				if sameAddr(p.addrs[i], addr) {
+					p.current, p.losses = i, 0
+				}
+			}
+		}
+		f.mutex.Unlock()
+		if ok {
+			return addr
+		}
+	}
+	return nil
+}
+
+// Address the failure detector should probe the member at, nil if it
+// has none.
+func (f *AddrFailover) Current(m Member) *net.UDPAddr {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	p := f.path(m)
+	if len(p.addrs) == 0 {
+		return nil
+	}
+	return p.addrs[p.current]
+}
+
+// Record an answered probe of the address in use.
+func (f *AddrFailover) Answered(m Member) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	p := f.path(m)
+	if len(p.addrs) == 0 {
+		return
+	}
+	p.losses = 0
+	f.reach[addrKey(p.addrs[p.current])] = reachability{true, f.clock.Now()}
+}
+
+// Record a lost probe of the address in use. After the threshold of
+// losses in a row, the address is marked unreachable and the next one not
+// known to be unreachable is put in use, wrapping around the list. Returns
+// the address to probe next, and false once every address has failed, in
+// which case the member should be suspected.
+func (f *AddrFailover) Lost(m Member) (*net.UDPAddr, bool) {
+	now := f.clock.Now()
+	f.mutex.Lock()
+	p := f.path(m)
+	if len(p.addrs) == 0 {
+		f.mutex.Unlock()
+		return nil, false
+	}
+	from := p.addrs[p.current]
+	if p.losses++; p.losses < f.threshold {
+		f.mutex.Unlock()
+		return from, true
+	}
+	f.reach[addrKey(from)] = reachability{false, now}
+	next := -1
+	for i := 1; i < len(p.addrs); i++ {
+		if j := (p.current + i) % len(p.addrs); !f.unreachable(p.addrs[j], now) {
+			next = j
+			break
+		}
+	}
+	if next < 0 {
+		p.losses = 0
+		f.mutex.Unlock()
+		return from, false
+	}
+	p.current, p.losses = next, 0
+	to := p.addrs[next]
+	onMove := f.OnMove
+	f.mutex.Unlock()
+
+	if onMove != nil {
+		m.Addr = to
+		onMove(m, from)
+	}
+	return to, true
+}
+
+// Forget the member and the reachability of its addresses, e.g. from
+// MemberTable.OnReap.
+func (f *AddrFailover) Forget(m Member) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if p, ok := f.members[m.Name]; ok {
+		for _, addr := range p.addrs {
+			delete(f.reach, addrKey(addr))
+		}
+	}
+	delete(f.members, m.Name)
+}
@@ -0,0 +1,80 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+)
+
+func TestAddrFailover(t *testing.T) {
+	serverConn, server := gossiptest.Listen(t, nil)
+	NewRequester(serverConn, func(req []byte, from *net.UDPAddr) []byte { return req })
+	_, silent := gossiptest.Listen(t, nil)
+	conn, _ := gossiptest.Listen(t, nil)
+	client := NewRequester(conn, nil)
+	pings := 0
+	ping := func(addr *net.UDPAddr) bool {
+		pings++
+		_, err := client.Request([]byte("ping"), addr, 30*time.Millisecond)
+		return err == nil
+	}
+
+	// the private address comes first but only the public one is
+	// reachable from here
+	table := newTestTable(t)
+	table.Update(Member{Name: "a", Addr: silent, Addrs: []*net.UDPAddr{silent, server}, Incarnation: 1})
+	f := NewAddrFailover(2, time.Minute)
+	var moves []*net.UDPAddr
+	f.OnMove = func(m Member, from *net.UDPAddr) {
+		moves = append(moves, from, m.Addr)
+	}
+
+	for round := 0; round < 6; round++ {
+		m, _ := table.Get("a")
+		if ping(f.Current(m)) {
+			f.Answered(m)
+		} else if _, alive := f.Lost(m); !alive {
+			t.Fatalf("TestAddrFailover expected a to stay alive in round %d.", round)
+		}
+	}
+	m, _ := table.Get("a")
+	if !sameAddr(f.Current(m), server) || len(moves) != 2 || !sameAddr(moves[0], silent) || !sameAddr(moves[1], server) {
+		t.Fatalf("TestAddrFailover expected a to fail over to %v once got %v.", server, moves)
+	}
+	if m.State != MemberAlive || len(m.Addrs) != 2 {
+		t.Fatalf("TestAddrFailover expected a alive with both addresses got %+v.", m)
+	}
+
+	// the failed address is skipped when another member is contacted
+	pings = 0
+	b := Member{Name: "b", Addrs: []*net.UDPAddr{silent, server}}
+	if addr := f.Contact(b, ping); !sameAddr(addr, server) || pings != 1 {
+		t.Fatalf("TestAddrFailover expected to contact b at %v with 1 ping got %v after %d.", server, addr, pings)
+	}
+
+	// a peer which reaches nothing suspects after trying every address
+	other := NewAddrFailover(1, time.Minute)
+	c := Member{Name: "c", Addrs: []*net.UDPAddr{silent, peerAddr(9)}}
+	if _, alive := other.Lost(c); !alive {
+		t.Fatalf("TestAddrFailover expected c to fail over first.")
+	}
+	if _, alive := other.Lost(c); alive {
+		t.Fatalf("TestAddrFailover expected c to be suspected once every address failed.")
+	}
+}
+
+func TestMemberAddrsIncarnation(t *testing.T) {
+	table := newTestTable(t)
+	first := []*net.UDPAddr{peerAddr(1), peerAddr(2)}
+	table.Update(Member{Name: "a", Addr: peerAddr(1), Addrs: first, Incarnation: 1})
+	table.Update(Member{Name: "a", Addr: peerAddr(1), Addrs: []*net.UDPAddr{peerAddr(3)}, State: MemberSuspect, Incarnation: 1})
+	if m, _ := table.Get("a"); len(m.Addrs) != 2 || m.State != MemberSuspect {
+		t.Fatalf("TestMemberAddrsIncarnation expected the addresses to stay at the same incarnation got %+v.", m)
+	}
+	table.Update(Member{Name: "a", Addr: peerAddr(3), Addrs: []*net.UDPAddr{peerAddr(3)}, Incarnation: 2})
+	if m, _ := table.Get("a"); len(m.Addrs) != 1 || !sameAddr(m.Addrs[0], peerAddr(3)) {
+		t.Fatalf("TestMemberAddrsIncarnation expected the addresses of the higher incarnation got %+v.", m)
+	}
+}
@@ -0,0 +1,105 @@
+package gossip
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Round-trip time at which a peer's weight is halved
+const ReferenceRTT = 50 * time.Millisecond
+
+// Chooses gossip targets among the live peers, biased toward peers which
+// answer reliably and quickly. Only a share of 1-Floor of the selection
+// probability follows the weights; the rest is spread evenly, so every
+// peer is picked with probability at least Floor/N per draw and nobody
+// is starved of updates. A Floor of one selects uniformly.
+type Fanout struct {
+	Floor float64
+
+	// Optional round-trip time estimate of a peer, e.g. from ClockSync
+	RTT func(addr *net.UDPAddr) (time.Duration, bool)
+
+	// Whether Relay also skips the origin of a rumor
+	ExcludeOrigin bool
+
+	rnd *rand.Rand
+}
+
+// Create a selector with the floor clamped to [0, 1] which draws from
+// rnd, e.g. Conn.Rand.
+func NewFanout(floor float64, rnd *rand.Rand) *Fanout {
+	return &Fanout{Floor: math.Max(0, math.Min(1, floor)), rnd: rnd}
+}
+
+// Responsiveness of a peer in (0, 1] derived from its traffic counters:
+// the share of packets sent to it which were answered, reduced by failed
+// writes and by its round-trip time. Peers without history weigh one.
+func (f *Fanout) Weight(s transport.PeerStats) float64 {
+	w := math.Min(1, float64(s.PacketsIn+1)/float64(s.PacketsOut+1))
+	w *= float64(s.PacketsOut+1) / float64(s.PacketsOut+s.Errors+1)
+	if f.RTT != nil && s.Addr != nil {
+		if rtt, ok := f.RTT(s.Addr); ok && rtt > 0 {
+			w /= 1 + float64(rtt)/float64(ReferenceRTT)
+		}
+	}
+	return w
+}
+
+// Pick up to n distinct peers. Each draw takes a peer with probability
+// Floor/N + (1-Floor) * weight/total among the peers not yet chosen.
+func (f *Fanout) Select(peers []transport.PeerStats, n int) []transport.PeerStats {
+	if n > len(peers) {
+		n = len(peers)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(peers))
+	total := 0.0
+	for i, s := range peers {
+		weights[i] = f.Weight(s)
+		total += weights[i]
+	}
+
+	// weighted sampling without replacement (Efraimidis and Spirakis)
+	type candidate struct {
+		index int
+		key   float64
+	}
+	candidates := make([]candidate, len(peers))
+	for i := range peers {
+		p := f.Floor/float64(len(peers)) + (1-f.Floor)*weights[i]/total
+		candidates[i] = candidate{i, math.Pow(f.rnd.Float64(), 1/p)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key > candidates[j].key })
+
+	selected := make([]transport.PeerStats, n)
+	for i := range selected {
+		selected[i] = peers[candidates[i].index]
+	}
+	return selected
+}
+
+// Pick up to n peers to pass a rumor on to, never the peer it was received
+// from (split horizon) and, if ExcludeOrigin is set, not its origin
+// either. Either address may be nil for rumors which originate locally.
+func (f *Fanout) Relay(peers []transport.PeerStats, n int, from, origin *net.UDPAddr) []transport.PeerStats {
+	candidates := make([]transport.PeerStats, 0, len(peers))
+	for _, s := range peers {
+		if sameAddr(s.Addr, from) || (f.ExcludeOrigin && sameAddr(s.Addr, origin)) {
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+	return f.Select(candidates, n)
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.Port == b.Port && a.IP.Equal(b.IP)
+}
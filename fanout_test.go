@@ -0,0 +1,125 @@
+package gossip
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func peerAddr(i int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 7946}
+}
+
+func TestFanoutWeight(t *testing.T) {
+	fanout := NewFanout(0.2, rand.New(rand.NewSource(417)))
+	fanout.RTT = func(addr *net.UDPAddr) (time.Duration, bool) {
+		return ReferenceRTT, addr.IP[15] == 3
+	}
+
+	tests := []struct {
+		stats    transport.PeerStats
+		expected float64
+	}{
+		{transport.PeerStats{Addr: peerAddr(1)}, 1},
+		{transport.PeerStats{Addr: peerAddr(1), PacketsOut: 99, PacketsIn: 99}, 1},
+		{transport.PeerStats{Addr: peerAddr(1), PacketsOut: 99, PacketsIn: 49}, 0.5},
+		{transport.PeerStats{Addr: peerAddr(1), PacketsOut: 99, PacketsIn: 499}, 1},
+		{transport.PeerStats{Addr: peerAddr(1), PacketsOut: 99, PacketsIn: 99, Errors: 100}, 0.5},
+		{transport.PeerStats{Addr: peerAddr(3), PacketsOut: 9, PacketsIn: 9}, 0.5},
+	}
+	for _, test := range tests {
+		if w := fanout.Weight(test.stats); w < test.expected-1e-9 || w > test.expected+1e-9 {
+			t.Errorf("TestFanoutWeight expected %.2f for %+v got %.2f.", test.expected, test.stats, w)
+		}
+	}
+}
+
+func TestFanoutFloor(t *testing.T) {
+	const rounds = 20000
+	peers := make([]transport.PeerStats, 10)
+	for i := range peers {
+		peers[i] = transport.PeerStats{Addr: peerAddr(i), PacketsOut: 1000, PacketsIn: 1000}
+	}
+	peers[0].PacketsIn = 0 // never answers
+
+	fanout := NewFanout(0.2, rand.New(rand.NewSource(417)))
+	counts := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		for _, s := range fanout.Select(peers, 1) {
+			counts[s.Addr.String()]++
+		}
+	}
+	// the floor alone gives the dead peer 2% of the draws
+	share := float64(counts[peers[0].Addr.String()]) / rounds
+	if share < 0.015 || share > 0.03 {
+		t.Fatalf("TestFanoutFloor expected the unresponsive peer at about 2%% got %.3f.", share)
+	}
+
+	if selected := fanout.Select(peers, 20); len(selected) != len(peers) {
+		t.Fatalf("TestFanoutFloor expected all %d peers got %d.", len(peers), len(selected))
+	}
+}
+
+// Push gossip among simulated nodes where one peer loses most packets
+// sent to it, feeding the observed traffic back into the weights.
+func TestFanoutLossyPeer(t *testing.T) {
+	const nodes = 30
+	const lossy = 7
+	const fanoutSize = 3
+	rnd := rand.New(rand.NewSource(417))
+	fanout := NewFanout(0.3, rnd)
+
+	// traffic counters as seen by every node for every other node
+	stats := make([][]transport.PeerStats, nodes)
+	for i := range stats {
+		stats[i] = make([]transport.PeerStats, nodes)
+		for j := range stats[i] {
+			stats[i][j].Addr = peerAddr(j)
+		}
+	}
+	peersOf := func(i int) []transport.PeerStats {
+		peers := make([]transport.PeerStats, 0, nodes-1)
+		for j := range stats[i] {
+			if j != i {
+				peers = append(peers, stats[i][j])
+			}
+		}
+		return peers
+	}
+
+	copies := make([]int, nodes)
+	for update := 0; update < 200; update++ {
+		informed := map[int]bool{update % nodes: true}
+		for round := 0; round < 20 && len(informed) < nodes; round++ {
+			for i := range informed {
+				for _, s := range fanout.Select(peersOf(i), fanoutSize) {
+					j := int(s.Addr.IP[15])
+					stats[i][j].PacketsOut++
+					copies[j]++
+					if j == lossy && rnd.Float64() < 0.7 {
+						continue
+					}
+					stats[i][j].PacketsIn++ // the ack
+					informed[j] = true
+				}
+			}
+		}
+		if len(informed) != nodes {
+			t.Fatalf("TestFanoutLossyPeer update %d reached only %d of %d nodes", update, len(informed), nodes)
+		}
+	}
+
+	total := 0
+	for j, n := range copies {
+		if j != lossy {
+			total += n
+		}
+	}
+	mean := float64(total) / (nodes - 1)
+	if share := float64(copies[lossy]) / mean; share > 0.7 {
+		t.Fatalf("TestFanoutLossyPeer expected the lossy peer to be sent fewer copies got %.2f of the mean.", share)
+	}
+}
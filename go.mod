@@ -0,0 +1,3 @@
+module github.com/ahorn/gossip
+
+go 1.21
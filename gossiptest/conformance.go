@@ -0,0 +1,245 @@
+package gossiptest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Datagram transport as the gossip layer relies on it: a net.PacketConn
+// which reports the largest message it accepts.
+type Transport interface {
+	net.PacketConn
+	MaxPayload() int
+}
+
+// Open a transport on an address of its own which others created by the
+// factory can reach; it is released when the test ends.
+type TransportFactory func(t testing.TB) Transport
+
+// Adapter of a listening Conn to a Transport, see ConnTransport
+type connTransport struct {
+	*transport.PacketConn
+	conn *transport.Conn
+}
+
+func (c connTransport) MaxPayload() int {
+	return c.conn.MaxPayload()
+}
+
+// Listen on loopback like Listen and receive every packet through
+// Conn.PacketConn; configure, which may be nil, is called on the
+// connection before it listens.
+func ConnTransport(configure func(*transport.Conn)) TransportFactory {
+	return func(t testing.TB) Transport {
+		conn := transport.NewConn()
+		if configure != nil {
+			configure(conn)
+		}
+		if err := conn.Listen(0); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(conn.Disconnect)
+		<-conn.Events()
+		pc := conn.PacketConn(transport.DeliverAll)
+		t.Cleanup(func() { pc.Close() })
+		return connTransport{pc, conn}
+	}
+}
+
+// Behaviour which every transport must show
+var conformance = []struct {
+	name  string
+	check func(t testing.TB, newTransport TransportFactory)
+}{
+	{"Boundaries", checkBoundaries},
+	{"AddressRoundTrip", checkAddressRoundTrip},
+	{"Concurrent", checkConcurrent},
+	{"Oversized", checkOversized},
+	{"Close", checkClose},
+}
+
+// Run the conformance suite against the transports of the factory, one
+// subtest per property. A new transport passes if the gossip layer can
+// rely on it like on a Conn.
+func Conformance(t *testing.T, newTransport TransportFactory) {
+	for _, c := range conformance {
+		check := c.check
+		t.Run(c.name, func(t *testing.T) {
+			check(t, newTransport)
+		})
+	}
+}
+
+// Address at which a transport is reached by the others, i.e. its local
+// address with the unspecified IP replaced by loopback.
+func reachable(tr Transport) net.Addr {
+	if addr, ok := tr.LocalAddr().(*net.UDPAddr); ok && addr.IP.IsUnspecified() {
+		return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port}
+	}
+	return tr.LocalAddr()
+}
+
+// Read one datagram within the timeout.
+func receive(t testing.TB, tr Transport) ([]byte, net.Addr) {
+	t.Helper()
+	buf := make([]byte, transport.MaxDatagramSize)
+	tr.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	n, from, err := tr.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("conformance: expected a datagram got %v", err)
+	}
+	return buf[:n], from
+}
+
+func payload(size int, seed byte) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = seed + byte(i)
+	}
+	return b
+}
+
+// Every datagram arrives whole and on its own, up to MaxPayload.
+func checkBoundaries(t testing.TB, newTransport TransportFactory) {
+	a, b := newTransport(t), newTransport(t)
+	sizes := []int{1, 2, 100, a.MaxPayload() - 1, a.MaxPayload()}
+	for i, size := range sizes {
+		if _, err := a.WriteTo(payload(size, byte(i)), reachable(b)); err != nil {
+			t.Fatalf("conformance: expected a datagram of %d bytes to be sent got %v", size, err)
+		}
+	}
+	for i, size := range sizes {
+		msg, _ := receive(t, b)
+		if string(msg) != string(payload(size, byte(i))) {
+			t.Fatalf("conformance: expected datagram %d with %d bytes got %d bytes", i, size, len(msg))
+		}
+	}
+}
+
+// The address a datagram is reported from leads back to its sender.
+func checkAddressRoundTrip(t testing.TB, newTransport TransportFactory) {
+	a, b := newTransport(t), newTransport(t)
+	if _, err := a.WriteTo([]byte("ping"), reachable(b)); err != nil {
+		t.Fatal(err)
+	}
+	_, from := receive(t, b)
+	if _, err := b.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatalf("conformance: expected to reply to %s got %v", from, err)
+	}
+	if msg, _ := receive(t, a); string(msg) != "pong" {
+		t.Fatalf("conformance: expected the reply to reach the sender got %q", msg)
+	}
+}
+
+// Several goroutines send and receive at once without losing or mixing up
+// datagrams; they wait for each echo, so nothing overflows a buffer.
+func checkConcurrent(t testing.TB, newTransport TransportFactory) {
+	const senders, rounds = 4, 25
+	a, b := newTransport(t), newTransport(t)
+	go func() {
+		buf := make([]byte, transport.MaxDatagramSize)
+		for {
+			n, from, err := b.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			b.WriteTo(buf[:n], from)
+		}
+	}()
+	t.Cleanup(func() { b.Close() })
+
+	var mutex sync.Mutex
+	echoes := make(map[string]chan bool)
+	for i := 0; i < senders; i++ {
+		for j := 0; j < rounds; j++ {
+			echoes[fmt.Sprintf("%d/%d", i, j)] = make(chan bool, 1)
+		}
+	}
+	go func() {
+		buf := make([]byte, transport.MaxDatagramSize)
+		for {
+			n, _, err := a.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			if c, ok := echoes[string(buf[:n])]; ok {
+				c <- true
+			}
+			mutex.Unlock()
+		}
+	}()
+	t.Cleanup(func() { a.Close() })
+
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			for j := 0; j < rounds; j++ {
+				id := fmt.Sprintf("%d/%d", i, j)
+				if _, err := a.WriteTo([]byte(id), reachable(b)); err != nil {
+					errs <- err
+					return
+				}
+				mutex.Lock()
+				c := echoes[id]
+				mutex.Unlock()
+				select {
+				case <-c:
+				case <-time.After(DefaultTimeout):
+					errs <- fmt.Errorf("no echo of %s", id)
+					return
+				}
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < senders; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("conformance: expected every datagram to be echoed: %v", err)
+		}
+	}
+}
+
+// A message above MaxPayload is rejected rather than sent truncated.
+func checkOversized(t testing.TB, newTransport TransportFactory) {
+	a, b := newTransport(t), newTransport(t)
+	if _, err := a.WriteTo(payload(a.MaxPayload()+1, 0), reachable(b)); err == nil {
+		t.Fatalf("conformance: expected a message of %d bytes to be rejected", a.MaxPayload()+1)
+	}
+	a.WriteTo([]byte("next"), reachable(b))
+	if msg, _ := receive(t, b); string(msg) != "next" {
+		t.Fatalf("conformance: expected nothing of the oversized message to arrive got %d bytes", len(msg))
+	}
+}
+
+// Close releases a blocked reader, and reads and writes fail afterwards
+// with net.ErrClosed.
+func checkClose(t testing.TB, newTransport TransportFactory) {
+	a, b := newTransport(t), newTransport(t)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := a.ReadFrom(make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("conformance: expected the blocked read to fail with net.ErrClosed got %v", err)
+		}
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("conformance: expected Close to release the blocked read")
+	}
+	if _, err := a.WriteTo([]byte("late"), reachable(b)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("conformance: expected writes after Close to fail with net.ErrClosed got %v", err)
+	}
+}
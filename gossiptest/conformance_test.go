@@ -0,0 +1,78 @@
+package gossiptest
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestConformance(t *testing.T) {
+	transports := map[string]TransportFactory{
+		"udp":       ConnTransport(nil),
+		"broadcast": ConnTransport(func(conn *transport.Conn) { conn.SetBroadcast(true) }),
+		"shaped":    ConnTransport(func(conn *transport.Conn) { conn.UseShaper(transport.NewShaper(transport.Shaping{})) }),
+	}
+	for name, factory := range transports {
+		t.Run(name, func(t *testing.T) {
+			Conformance(t, factory)
+		})
+	}
+}
+
+// Transport with a seeded bug: it cuts long messages short instead of
+// rejecting them.
+type truncatingTransport struct {
+	Transport
+}
+
+func (tr truncatingTransport) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > 256 {
+		b = b[:256]
+	}
+	return tr.Transport.WriteTo(b, addr)
+}
+
+// Records failures of a check instead of failing the test.
+type failureRecorder struct {
+	testing.TB
+
+	mutex  sync.Mutex
+	failed bool
+}
+
+func (r *failureRecorder) fail() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failed = true
+}
+
+func (r *failureRecorder) Helper()                       {}
+func (r *failureRecorder) Error(args ...interface{})     { r.fail() }
+func (r *failureRecorder) Errorf(string, ...interface{}) { r.fail() }
+func (r *failureRecorder) Fatal(args ...interface{})     { r.fail(); runtime.Goexit() }
+func (r *failureRecorder) Fatalf(string, ...interface{}) { r.fail(); runtime.Goexit() }
+func (r *failureRecorder) FailNow()                      { r.fail(); runtime.Goexit() }
+func (r *failureRecorder) Failed() bool                  { r.mutex.Lock(); defer r.mutex.Unlock(); return r.failed }
+
+func TestConformanceCatchesTruncation(t *testing.T) {
+	broken := func(t testing.TB) Transport {
+		return truncatingTransport{ConnTransport(nil)(t)}
+	}
+	caught := map[string]bool{}
+	for _, c := range conformance {
+		r := &failureRecorder{TB: t}
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			c.check(r, broken)
+		}()
+		<-done
+		caught[c.name] = r.Failed()
+	}
+	if !caught["Boundaries"] || !caught["Oversized"] || caught["AddressRoundTrip"] || caught["Close"] {
+		t.Fatalf("TestConformanceCatchesTruncation expected the boundary and size checks to fail got %v.", caught)
+	}
+}
@@ -0,0 +1,90 @@
+package gossiptest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Drains the events of a connection into a log which can be inspected and
+// waited on, so a test need not select on Conn.Events itself.
+type EventCollector struct {
+	mutex   sync.Mutex
+	events  []transport.Event
+	changed chan bool
+}
+
+// Collect the events of the connection until the test ends. Nothing else
+// should read Conn.Events meanwhile.
+func CollectEvents(t testing.TB, conn *transport.Conn) *EventCollector {
+	c := &EventCollector{changed: make(chan bool)}
+	done := make(chan bool)
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case e := <-conn.Events():
+				c.add(e)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *EventCollector) add(e transport.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, e)
+	close(c.changed)
+	c.changed = make(chan bool)
+}
+
+// Events collected so far in the order they were published.
+func (c *EventCollector) Events() []transport.Event {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]transport.Event(nil), c.events...)
+}
+
+// Wait until a collected event, including those collected before the
+// call, satisfies pred, and return the first one.
+func (c *EventCollector) WaitFor(pred func(transport.Event) bool, timeout time.Duration) (transport.Event, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	expired := time.After(timeout)
+	for {
+		c.mutex.Lock()
+		events, changed := c.events, c.changed
+		c.mutex.Unlock()
+		for _, e := range events {
+			if pred(e) {
+				return e, nil
+			}
+		}
+		select {
+		case <-changed:
+		case <-expired:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// Wait for the first event of type E, e.g.
+//
+//	e, err := gossiptest.WaitForEvent[*transport.ShutdownEvent](c, time.Second)
+func WaitForEvent[E transport.Event](c *EventCollector, timeout time.Duration) (E, error) {
+	e, err := c.WaitFor(func(e transport.Event) bool {
+		_, ok := e.(E)
+		return ok
+	}, timeout)
+	if err != nil {
+		var zero E
+		return zero, err
+	}
+	return e.(E), nil
+}
@@ -0,0 +1,122 @@
+// Helpers for tests against the gossip packages: listening connections on
+// loopback which share a manual clock, a handler which records packets
+// and a collector of connection events, each of which can be waited on.
+//
+// The tree has no in-memory transport, so connections talk over real
+// sockets on 127.0.0.1; only their clock is simulated. A Topology shapes
+// the links between the connections of a group, e.g. to script partitions
+// and slow or flapping links.
+package gossiptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Wait used by the helpers if none is given
+const DefaultTimeout = time.Second
+
+// Interval at which Eventually polls
+const pollInterval = 10 * time.Millisecond
+
+// Time at which the clock of a Group starts
+var Epoch = time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// Connections which listen on loopback and share a manual clock.
+type Group struct {
+	Conns []*transport.Conn
+
+	// Address at which each connection can be reached
+	Addrs []*net.UDPAddr
+
+	Clock *transport.ManualClock
+}
+
+// Listen on a free port of the loopback interface with the clock, which
+// may be nil for the real one, and disconnect when the test ends. The
+// OpenEvent has been consumed when it returns.
+func Listen(t testing.TB, clock transport.Clock) (*transport.Conn, *net.UDPAddr) {
+	t.Helper()
+	return ListenWith(t, clock, nil)
+}
+
+// Listen after passing the connection to setup, if given, e.g. to install
+// a scheduler or middleware which must be in place before the socket opens.
+func ListenWith(t testing.TB, clock transport.Clock, setup func(conn *transport.Conn)) (*transport.Conn, *net.UDPAddr) {
+	t.Helper()
+	conn := transport.NewConn()
+	if clock != nil {
+		conn.SetClock(clock)
+	}
+	if setup != nil {
+		setup(conn)
+	}
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	port := (<-conn.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+// Poll cond until it holds or the timeout, DefaultTimeout if zero, has
+// passed, for state which no event or packet announces.
+func Eventually(cond func() bool, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(pollInterval)
+	}
+	return nil
+}
+
+// Start n connections whose clock begins at Epoch.
+func NewGroup(t testing.TB, n int) *Group {
+	t.Helper()
+	g := &Group{Clock: transport.NewManualClock(Epoch)}
+	for i := 0; i < n; i++ {
+		conn, addr := Listen(t, g.Clock)
+		g.Conns = append(g.Conns, conn)
+		g.Addrs = append(g.Addrs, addr)
+	}
+	return g
+}
+
+// Start a Group of two connections.
+func NewPair(t testing.TB) *Group {
+	t.Helper()
+	return NewGroup(t, 2)
+}
+
+// Addresses of every connection but the i-th, e.g. its peers.
+func (g *Group) Peers(i int) []*net.UDPAddr {
+	peers := make([]*net.UDPAddr, 0, len(g.Addrs)-1)
+	for j, addr := range g.Addrs {
+		if j != i {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
+
+// Move the shared clock forward once a timer is pending, so that a
+// goroutine which is about to wait on the clock is not skipped.
+func (g *Group) Advance(t testing.TB, d time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(DefaultTimeout)
+	for g.Clock.Pending() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("gossiptest: no timer to advance by %s", d)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	g.Clock.Advance(d)
+}
@@ -0,0 +1,70 @@
+package gossiptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestPacketRecorder(t *testing.T) {
+	g := NewPair(t)
+	a, b := g.Conns[0], g.Conns[1]
+	r := NewPacketRecorder(func(p *transport.Packet) bool {
+		return string(p.Msg) != "noise"
+	})
+	b.AddHandler(r.Handle)
+
+	for _, msg := range []string{"noise", "ping", "pong"} {
+		if err := a.SendTo(transport.Message(msg), g.Addrs[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := r.WaitFor(MessageIs("pong"), time.Second)
+	if err != nil || !From(g.Addrs[0])(p) {
+		t.Fatalf("TestPacketRecorder expected pong from %s got %v (%v).", g.Addrs[0], p, err)
+	}
+	if packets, err := r.WaitN(2, time.Second); err != nil || len(packets) != 2 {
+		t.Fatalf("TestPacketRecorder expected two recorded packets got %d (%v).", len(packets), err)
+	}
+	if _, err := r.WaitFor(MessageIs("noise"), 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("TestPacketRecorder expected filtered packets to time out got %v.", err)
+	}
+}
+
+func TestEventCollector(t *testing.T) {
+	g := NewGroup(t, 3)
+	if peers := g.Peers(1); len(peers) != 2 || peers[0] != g.Addrs[0] || peers[1] != g.Addrs[2] {
+		t.Fatalf("TestEventCollector expected the peers of the second connection got %v.", peers)
+	}
+
+	c := CollectEvents(t, g.Conns[0])
+	g.Conns[0].Disconnect()
+	e, err := WaitForEvent[*transport.ShutdownEvent](c, time.Second)
+	if err != nil || e.Err != nil {
+		t.Fatalf("TestEventCollector expected a clean shutdown got %v (%v).", e, err)
+	}
+	if _, err := WaitForEvent[*transport.RetiredEvent](c, 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("TestEventCollector expected %v got %v.", ErrTimeout, err)
+	}
+}
+
+func TestEventually(t *testing.T) {
+	start := time.Now()
+	if err := Eventually(func() bool { return time.Since(start) > 30*time.Millisecond }, time.Second); err != nil {
+		t.Fatalf("TestEventually expected the condition to hold got %v.", err)
+	}
+	if err := Eventually(func() bool { return false }, 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("TestEventually expected %v got %v.", ErrTimeout, err)
+	}
+}
+
+func TestGroupAdvance(t *testing.T) {
+	g := NewPair(t)
+	fired := make(chan time.Time, 1)
+	go func() { fired <- <-g.Clock.After(time.Minute) }()
+	g.Advance(t, time.Minute)
+	if now := <-fired; !now.Equal(Epoch.Add(time.Minute)) {
+		t.Fatalf("TestGroupAdvance expected the timer to fire at %s got %s.", Epoch.Add(time.Minute), now)
+	}
+}
@@ -0,0 +1,114 @@
+package gossiptest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+var ErrTimeout = errors.New("Timed out waiting for a match")
+
+// Handler which records the packets a connection receives, e.g.
+//
+//	r := gossiptest.NewPacketRecorder(nil)
+//	conn.AddHandler(r.Handle)
+//	p, err := r.WaitFor(gossiptest.MessageIs("pong"), time.Second)
+type PacketRecorder struct {
+	filter func(*transport.Packet) bool
+
+	mutex   sync.Mutex
+	packets []*transport.Packet
+	changed chan bool
+}
+
+// Record the packets for which filter returns true, or all if it is nil.
+func NewPacketRecorder(filter func(*transport.Packet) bool) *PacketRecorder {
+	return &PacketRecorder{filter: filter, changed: make(chan bool)}
+}
+
+// Matches a packet whose message equals msg.
+func MessageIs(msg string) func(*transport.Packet) bool {
+	return func(p *transport.Packet) bool {
+		return string(p.Msg) == msg
+	}
+}
+
+// Matches a packet which was sent by the address.
+func From(addr *net.UDPAddr) func(*transport.Packet) bool {
+	return func(p *transport.Packet) bool {
+		return p.Addr.String() == addr.String()
+	}
+}
+
+// EventHandler to register with the connection.
+func (r *PacketRecorder) Handle(conn *transport.Conn, p *transport.Packet) {
+	if r.filter != nil && !r.filter(p) {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.packets = append(r.packets, p)
+	close(r.changed)
+	r.changed = make(chan bool)
+}
+
+// Packets recorded so far in the order they were handled.
+func (r *PacketRecorder) Packets() []*transport.Packet {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*transport.Packet(nil), r.packets...)
+}
+
+func (r *PacketRecorder) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.packets)
+}
+
+// Wait until a recorded packet, including those recorded before the call,
+// satisfies pred, returning the first one; nil matches any packet.
+func (r *PacketRecorder) WaitFor(pred func(*transport.Packet) bool, timeout time.Duration) (*transport.Packet, error) {
+	var match *transport.Packet
+	err := r.wait(func(packets []*transport.Packet) bool {
+		for _, p := range packets {
+			if pred == nil || pred(p) {
+				match = p
+				return true
+			}
+		}
+		return false
+	}, timeout)
+	return match, err
+}
+
+// Wait until at least n packets have been recorded and return them.
+func (r *PacketRecorder) WaitN(n int, timeout time.Duration) ([]*transport.Packet, error) {
+	err := r.wait(func(packets []*transport.Packet) bool {
+		return len(packets) >= n
+	}, timeout)
+	return r.Packets(), err
+}
+
+// Evaluate done on the recorded packets whenever they change.
+func (r *PacketRecorder) wait(done func([]*transport.Packet) bool, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	expired := time.After(timeout)
+	for {
+		r.mutex.Lock()
+		finished, changed := done(r.packets), r.changed
+		r.mutex.Unlock()
+		if finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-expired:
+			return ErrTimeout
+		}
+	}
+}
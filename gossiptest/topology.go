@@ -0,0 +1,298 @@
+package gossiptest
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	// Reasons of the DropEvent for packets discarded by a Topology
+	ErrLinkDown = errors.New("gossiptest: link is down")
+	ErrLinkLoss = errors.New("gossiptest: packet lost on the link")
+)
+
+// Properties of the one-way link between two connections of a Topology.
+// The zero value is a perfect link.
+type Link struct {
+	// Added to every packet, plus a uniformly distributed jitter in
+	// [0, Jitter) which may reorder packets
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Probability to lose a packet
+	Loss float64
+
+	// Cap of bytes per second; zero is unlimited. Packets over the cap
+	// queue up behind each other before the delay applies.
+	Bandwidth int
+
+	// Drops every packet, including those already in flight
+	Down bool
+}
+
+// Group whose connections are joined by links with their own delay,
+// jitter, loss, bandwidth and state, each of which may be changed at any
+// time to script partitions, slow links and flaps. Links are one-way and
+// addressed by the indexes of the connections in the group; those which
+// have not been set follow the default, see SetDefault. Traffic to and
+// from addresses outside the group is not shaped.
+//
+// Delay, jitter and bandwidth are applied by the scheduler of the sender
+// and take effect for packets queued from then on; loss and state are
+// applied by the receiver before its other ingress middleware.
+type Topology struct {
+	*Group
+
+	mutex sync.Mutex
+	base  Link
+	links map[link]Link
+	ports map[int]int
+	rnd   *rand.Rand
+}
+
+type link struct {
+	from, to int
+}
+
+// Start n connections on loopback joined by perfect links. The clock may
+// be nil for the real one; a ManualClock also becomes the Clock of the
+// Group. Jitter and loss are drawn from a source seeded with 1, see
+// SetRand.
+func NewTopology(t testing.TB, n int, clock transport.Clock) *Topology {
+	t.Helper()
+	topo := &Topology{
+		Group: &Group{},
+		links: make(map[link]Link),
+		ports: make(map[int]int),
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+	topo.Clock, _ = clock.(*transport.ManualClock)
+	for i := 0; i < n; i++ {
+		conn, addr := ListenWith(t, clock, func(conn *transport.Conn) {
+			conn.SetScheduler(topo.scheduler(i))
+			conn.Use(topo.ingress(i))
+		})
+		topo.mutex.Lock()
+		topo.ports[addr.Port] = i
+		topo.mutex.Unlock()
+		topo.Conns = append(topo.Conns, conn)
+		topo.Addrs = append(topo.Addrs, addr)
+	}
+	return topo
+}
+
+// Replace the source of randomness for jitter and loss.
+func (topo *Topology) SetRand(rnd *rand.Rand) {
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	topo.rnd = rnd
+}
+
+// Properties of the links which have not been set.
+func (topo *Topology) SetDefault(l Link) {
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	topo.base = l
+}
+
+// Properties of the link from one connection to another
+func (topo *Topology) Link(from, to int) Link {
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	return topo.link(from, to)
+}
+
+func (topo *Topology) link(from, to int) Link {
+	if l, ok := topo.links[link{from, to}]; ok {
+		return l
+	}
+	return topo.base
+}
+
+// Replace the link from one connection to another.
+func (topo *Topology) SetLink(from, to int, l Link) {
+	topo.SetLinks([]int{from}, []int{to}, l)
+}
+
+// Replace every link from a connection in from to one in to, e.g. the
+// uplink of a rack. Call it again with the groups swapped for the links
+// in the other direction.
+func (topo *Topology) SetLinks(from, to []int, l Link) {
+	topo.update(from, to, func(*Link) Link { return l })
+}
+
+// Take every link from a connection in from to one in to down, or bring
+// them back up, keeping their other properties. Only one direction is
+// affected, so this scripts asymmetric partitions; see Partition for both.
+func (topo *Topology) SetDown(from, to []int, down bool) {
+	topo.update(from, to, func(l *Link) Link {
+		l.Down = down
+		return *l
+	})
+}
+
+// Cut the links between the two groups in both directions.
+func (topo *Topology) Partition(a, b []int) {
+	topo.SetDown(a, b, true)
+	topo.SetDown(b, a, true)
+}
+
+// Bring the links between the two groups back up in both directions.
+func (topo *Topology) Heal(a, b []int) {
+	topo.SetDown(a, b, false)
+	topo.SetDown(b, a, false)
+}
+
+func (topo *Topology) update(from, to []int, change func(l *Link) Link) {
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	for _, i := range from {
+		for _, j := range to {
+			if i != j {
+				l := topo.link(i, j)
+				topo.links[link{i, j}] = change(&l)
+			}
+		}
+	}
+}
+
+// Index of the connection at the address, false if it is outside the group
+func (topo *Topology) index(addr *net.UDPAddr) (int, bool) {
+	if addr == nil {
+		return 0, false
+	}
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	i, ok := topo.ports[addr.Port]
+	return i, ok
+}
+
+// Ingress middleware of the i-th connection which drops the packets of
+// links which are down or lose them.
+func (topo *Topology) ingress(i int) transport.Middleware {
+	return func(p *transport.Packet) (*transport.Packet, error) {
+		from, ok := topo.index(p.Addr)
+		if !ok {
+			return p, nil
+		}
+		topo.mutex.Lock()
+		defer topo.mutex.Unlock()
+		l := topo.link(from, i)
+		switch {
+		case l.Down:
+			return nil, ErrLinkDown
+		case l.Loss > 0 && topo.rnd.Float64() < l.Loss:
+			return nil, ErrLinkLoss
+		}
+		return p, nil
+	}
+}
+
+// Release time of a packet of the given size queued now on the link from
+// the i-th connection, given the time at which the link is free again.
+// Returns when it becomes free after this packet.
+func (topo *Topology) release(i, to, size int, now, free time.Time) (release, freed time.Time) {
+	topo.mutex.Lock()
+	defer topo.mutex.Unlock()
+	l := topo.link(i, to)
+	depart := now
+	if l.Bandwidth > 0 {
+		if free.After(now) {
+			depart = free
+		}
+		depart = depart.Add(time.Duration(size) * time.Second / time.Duration(l.Bandwidth))
+	}
+	delay := l.Delay
+	if l.Jitter > 0 {
+		delay += time.Duration(topo.rnd.Int63n(int64(l.Jitter)))
+	}
+	return depart.Add(delay), depart
+}
+
+// Constructor of the scheduler of the i-th connection which holds back the
+// packets to other connections of the group until their link delivers them.
+func (topo *Topology) scheduler(i int) func() transport.Scheduler {
+	return func() transport.Scheduler {
+		return &linkScheduler{topo: topo, from: i, inner: transport.NewFIFOScheduler(), free: make(map[int]time.Time)}
+	}
+}
+
+type inFlight struct {
+	p       *transport.Packet
+	release time.Time
+	seq     uint64
+}
+
+type flightQueue []inFlight
+
+func (q flightQueue) Len() int { return len(q) }
+
+func (q flightQueue) Less(i, j int) bool {
+	if !q[i].release.Equal(q[j].release) {
+		return q[i].release.Before(q[j].release)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q flightQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *flightQueue) Push(x interface{}) { *q = append(*q, x.(inFlight)) }
+
+func (q *flightQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	old[len(old)-1] = inFlight{}
+	*q = old[:len(old)-1]
+	return x
+}
+
+// Packets of a connection on their way over the links
+type linkScheduler struct {
+	topo  *Topology
+	from  int
+	inner transport.Scheduler
+	line  flightQueue
+	seq   uint64
+
+	// Time at which each link has sent the packets queued on it
+	free map[int]time.Time
+}
+
+func (s *linkScheduler) Enqueue(p *transport.Packet, meta transport.PacketMeta) {
+	s.inner.Enqueue(p, meta)
+}
+
+func (s *linkScheduler) Next(now time.Time) (*transport.Packet, time.Duration) {
+	var wait time.Duration
+	for {
+		p, innerWait := s.inner.Next(now)
+		if p == nil {
+			wait = innerWait
+			break
+		}
+		release := now
+		if to, ok := s.topo.index(p.Addr); ok {
+			release, s.free[to] = s.topo.release(s.from, to, len(p.Msg), now, s.free[to])
+		}
+		s.seq++
+		heap.Push(&s.line, inFlight{p, release, s.seq})
+	}
+
+	if len(s.line) == 0 {
+		return nil, wait
+	}
+	if next := s.line[0].release; next.After(now) {
+		if d := next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+		return nil, wait
+	}
+	return heap.Pop(&s.line).(inFlight).p, 0
+}
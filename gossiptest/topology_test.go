@@ -0,0 +1,68 @@
+package gossiptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestTopologyDelay(t *testing.T) {
+	topo := NewTopology(t, 2, nil)
+	topo.SetLink(0, 1, Link{Delay: 150 * time.Millisecond})
+	at := NewPacketRecorder(nil)
+	topo.Conns[0].AddHandler(at.Handle)
+	bt := NewPacketRecorder(nil)
+	topo.Conns[1].AddHandler(bt.Handle)
+
+	start := time.Now()
+	topo.Conns[0].SendTo(transport.Message("slow"), topo.Addrs[1])
+	topo.Conns[1].SendTo(transport.Message("fast"), topo.Addrs[0])
+	if _, err := at.WaitFor(MessageIs("fast"), time.Second); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("TestTopologyDelay expected the reverse link to be fast got %s (%v).", time.Since(start), err)
+	}
+	if _, err := bt.WaitFor(MessageIs("slow"), time.Second); err != nil || time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("TestTopologyDelay expected the link to delay by 150ms got %s (%v).", time.Since(start), err)
+	}
+}
+
+func TestTopologyBandwidth(t *testing.T) {
+	topo := NewTopology(t, 2, nil)
+	topo.SetDefault(Link{Bandwidth: 10000})
+	r := NewPacketRecorder(nil)
+	topo.Conns[1].AddHandler(r.Handle)
+
+	// ten packets of 500 bytes take half a second at 10000 bytes per second
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		topo.Conns[0].SendTo(make(transport.Message, 500), topo.Addrs[1])
+	}
+	if _, err := r.WaitN(10, 2*time.Second); err != nil || time.Since(start) < 450*time.Millisecond {
+		t.Fatalf("TestTopologyBandwidth expected the packets to be paced got %s (%v).", time.Since(start), err)
+	}
+}
+
+func TestTopologyPartition(t *testing.T) {
+	topo := NewTopology(t, 3, nil)
+	events := CollectEvents(t, topo.Conns[2])
+	r := NewPacketRecorder(nil)
+	topo.Conns[2].AddHandler(r.Handle)
+
+	topo.Partition([]int{0, 1}, []int{2})
+	if l := topo.Link(2, 0); !l.Down || topo.Link(0, 1).Down {
+		t.Fatalf("TestTopologyPartition expected only the links across the partition to be down.")
+	}
+	topo.Conns[0].SendTo(transport.Message("lost"), topo.Addrs[2])
+	if e, err := WaitForEvent[*transport.DropEvent](events, time.Second); err != nil || e.Reason != ErrLinkDown {
+		t.Fatalf("TestTopologyPartition expected a drop for %v got %v (%v).", ErrLinkDown, e, err)
+	}
+
+	topo.Heal([]int{0, 1}, []int{2})
+	topo.Conns[1].SendTo(transport.Message("healed"), topo.Addrs[2])
+	if _, err := r.WaitFor(MessageIs("healed"), time.Second); err != nil {
+		t.Fatalf("TestTopologyPartition expected the healed link to deliver got %v.", err)
+	}
+	if _, err := r.WaitFor(MessageIs("lost"), 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("TestTopologyPartition expected the packet sent during the partition to be lost got %v.", err)
+	}
+}
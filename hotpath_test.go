@@ -0,0 +1,53 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+)
+
+// Allocations per packet of a ping and its response between two
+// Requesters, both sides included, asserted by TestProbeRoundAllocs. A
+// round is four packets, the ping and the response each sent and
+// received, so the target of 2 per packet leaves 8 per round for the
+// received packets (see transport/hotpath_test.go), the encoded messages,
+// the copy of the response, the cached response with its entry and the
+// list of handlers without the once handler of the request. Routes with
+// their once handler and timers, and send envelopes are reused.
+const (
+	probeRoundPackets = 4
+	probePacketAllocs = 2
+)
+
+// Requester pinging the responder of the other connection of a pair
+func startProbing(tb testing.TB) (*Requester, *net.UDPAddr) {
+	g := gossiptest.NewPair(tb)
+	NewRequester(g.Conns[1], func(req []byte, from *net.UDPAddr) []byte { return req })
+	return NewRequester(g.Conns[0], nil), g.Addrs[1]
+}
+
+func TestProbeRoundAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("TestProbeRoundAllocs does not count allocations under the race detector")
+	}
+	r, addr := startProbing(t)
+	ping := []byte("ping")
+	n := testing.AllocsPerRun(1000, func() { r.Request(ping, addr, time.Second) }) / probeRoundPackets
+	if n > probePacketAllocs {
+		t.Fatalf("TestProbeRoundAllocs expected at most %d allocations per packet got %.2f.", probePacketAllocs, n)
+	}
+}
+
+func BenchmarkClusterProbeRound(b *testing.B) {
+	r, addr := startProbing(b)
+	ping := []byte("ping")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Request(ping, addr, time.Second); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
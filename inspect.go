@@ -0,0 +1,194 @@
+package gossip
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Samples held for a slow inspector before further ones are dropped,
+// unless set by InspectorOptions.Buffer
+const DefaultInspectorBuffer = 64
+
+// Copy of a sampled packet handed to the callback of an Inspector
+type Inspection struct {
+	// Whether the packet was sent rather than received
+	Out bool
+
+	// Destination of a sent packet, source of a received one; nil for
+	// packets sent on a dialed connection
+	Peer *net.UDPAddr
+
+	// Payload as the middleware around the inspector leaves it, and its
+	// segments; a message which is not framed as segments is a single
+	// segment of SubsystemOther. The segments alias Payload.
+	Payload  []byte
+	Segments []Segment
+}
+
+// Options of NewInspector
+type InspectorOptions struct {
+	// Inspect every Rate-th packet which passes the filter; zero or one
+	// inspects all of them
+	Rate int
+
+	// Only packets with a segment of one of these subsystems are
+	// sampled; nil passes all
+	Subsystems []Subsystem
+
+	// Sample each packet with probability 1/Rate drawn from Conn.Rand
+	// instead of counting, so that periodic traffic cannot dodge the
+	// sampler
+	Random bool
+
+	// Samples held while the callback is busy; zero selects
+	// DefaultInspectorBuffer
+	Buffer int
+}
+
+// Counters of an Inspector
+type InspectorStats struct {
+	// Packets which passed the filter, whether sampled or not
+	Seen uint64
+
+	Inspected uint64
+
+	// Samples which found the buffer full
+	Dropped uint64
+}
+
+// Hands a sample of the traffic of the connections it is attached to, in
+// both directions, to a callback, e.g. to audit the payloads for data which
+// must not be gossiped. The decision to sample costs a counter, and the
+// copy and the callback happen only for the samples; the callback runs in
+// a goroutine of its own behind a bounded buffer, so a slow one loses
+// samples rather than holding up traffic.
+type Inspector struct {
+	inspect func(Inspection)
+	rate    uint64
+	random  bool
+	filter  [subsystemCount]bool
+	all     bool
+	buff    chan Inspection
+
+	seen, inspected, dropped atomic.Uint64
+
+	closed chan bool
+	once   sync.Once
+}
+
+// Create an inspector which calls inspect with every sample until Close.
+func NewInspector(inspect func(Inspection), opts InspectorOptions) *Inspector {
+	if opts.Rate < 1 {
+		opts.Rate = 1
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultInspectorBuffer
+	}
+	i := &Inspector{
+		inspect: inspect,
+		rate:    uint64(opts.Rate),
+		random:  opts.Random,
+		all:     opts.Subsystems == nil,
+		buff:    make(chan Inspection, opts.Buffer),
+		closed:  make(chan bool),
+	}
+	for _, s := range opts.Subsystems {
+		if s < subsystemCount {
+			i.filter[s] = true
+		}
+	}
+	go i.run()
+	return i
+}
+
+// Sample the packets conn sends or receives. To see payloads in the clear,
+// attach the inspector after the ingress middleware which decrypts them
+// and before the egress layers which encrypt them.
+func (i *Inspector) Attach(conn *transport.Conn) {
+	conn.Use(func(p *transport.Packet) (*transport.Packet, error) {
+		i.sample(conn, p, false)
+		return p, nil
+	})
+	conn.UseEgress(func(p *transport.Packet) (*transport.Packet, error) {
+		i.sample(conn, p, true)
+		return p, nil
+	})
+}
+
+func (i *Inspector) sample(conn *transport.Conn, p *transport.Packet, out bool) {
+	if i.isClosed() {
+		return
+	}
+	if !i.all && !i.passes(p.Msg) {
+		return
+	}
+	n := i.seen.Add(1)
+	switch {
+	case i.rate == 1:
+	case i.random:
+		if conn.Rand().Int63n(int64(i.rate)) != 0 {
+			return
+		}
+	case n%i.rate != 0:
+		return
+	}
+
+	payload := append([]byte(nil), p.Msg...)
+	segments, err := DecodeSegments(payload)
+	if err != nil {
+		segments = []Segment{{SubsystemOther, payload}}
+	}
+	select {
+	case i.buff <- Inspection{out, p.Addr, payload, segments}:
+	default:
+		i.dropped.Add(1)
+	}
+}
+
+// Whether a segment of the message belongs to a filtered subsystem
+func (i *Inspector) passes(msg transport.Message) bool {
+	segments, err := DecodeSegments(msg)
+	if err != nil {
+		return i.filter[SubsystemOther]
+	}
+	for _, s := range segments {
+		if i.filter[s.Subsystem] {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Inspector) run() {
+	for {
+		select {
+		case sample := <-i.buff:
+			i.inspect(sample)
+			i.inspected.Add(1)
+		case <-i.closed:
+			return
+		}
+	}
+}
+
+func (i *Inspector) isClosed() bool {
+	select {
+	case <-i.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (i *Inspector) Stats() InspectorStats {
+	return InspectorStats{Seen: i.seen.Load(), Inspected: i.inspected.Load(), Dropped: i.dropped.Load()}
+}
+
+// Stop sampling; samples still buffered are discarded. The middleware
+// stays registered on the connections but passes packets untouched.
+func (i *Inspector) Close() {
+	i.once.Do(func() { close(i.closed) })
+}
@@ -0,0 +1,82 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestInspectorRate(t *testing.T) {
+	g := gossiptest.NewPair(t)
+	received := gossiptest.NewPacketRecorder(nil)
+	g.Conns[1].AddHandler(received.Handle)
+
+	samples := make(chan Inspection, 100)
+	counted := NewInspector(func(i Inspection) { samples <- i }, InspectorOptions{Rate: 10, Subsystems: []Subsystem{SubsystemBroadcast}})
+	defer counted.Close()
+	counted.Attach(g.Conns[0])
+	random := NewInspector(func(Inspection) {}, InspectorOptions{Rate: 10, Random: true})
+	defer random.Close()
+	random.Attach(g.Conns[1])
+
+	const packets = 1000
+	for i := 0; i < packets; i++ {
+		msg := EncodeSegments(Segment{SubsystemBroadcast, []byte("update")})
+		if i%2 == 1 {
+			msg = EncodeSegments(Segment{SubsystemProbe, []byte("ping")})
+		}
+		if err := g.Conns[0].SendTo(msg, g.Addrs[1]); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 99 {
+			// keep the receive buffer from overflowing
+			if _, err := received.WaitN(i+1, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := received.WaitN(packets, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// every tenth broadcast is sampled by counting
+	if s := counted.Stats(); s.Seen != packets/2 || s.Inspected+s.Dropped != packets/20 {
+		t.Fatalf("TestInspectorRate expected %d of %d broadcasts to be sampled got %+v.", packets/20, packets/2, s)
+	}
+	sample := <-samples
+	if !sample.Out || sample.Peer.Port != g.Addrs[1].Port || len(sample.Segments) != 1 || sample.Segments[0].Subsystem != SubsystemBroadcast || string(sample.Segments[0].Data) != "update" {
+		t.Fatalf("TestInspectorRate unexpected sample %+v.", sample)
+	}
+
+	// and about every tenth packet of all subsystems at random
+	if s := random.Stats(); s.Seen != packets || s.Inspected+s.Dropped < packets/20 || s.Inspected+s.Dropped > packets/5 {
+		t.Fatalf("TestInspectorRate expected about %d of %d packets to be sampled got %+v.", packets/10, packets, s)
+	}
+}
+
+func TestInspectorSlow(t *testing.T) {
+	g := gossiptest.NewPair(t)
+	received := gossiptest.NewPacketRecorder(nil)
+	g.Conns[1].AddHandler(received.Handle)
+
+	release := make(chan bool)
+	inspector := NewInspector(func(Inspection) { <-release }, InspectorOptions{Buffer: 4})
+	defer inspector.Close()
+	defer close(release)
+	inspector.Attach(g.Conns[1])
+
+	const packets = 50
+	for i := 0; i < packets; i++ {
+		if err := g.Conns[0].SendTo(transport.Message("payload"), g.Addrs[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := received.WaitN(packets, time.Second); err != nil {
+		t.Fatalf("TestInspectorSlow expected a stuck inspector not to hold up dispatch got %v.", err)
+	}
+	if s := inspector.Stats(); s.Seen != packets || s.Dropped < packets-5 {
+		t.Fatalf("TestInspectorSlow expected the samples beyond the buffer to be dropped got %+v.", s)
+	}
+}
@@ -0,0 +1,108 @@
+// Rendering of metrics in the Prometheus text exposition format, shared
+// by the WriteMetrics methods of the gossip packages so that an
+// application can serve them from whatever HTTP handler it has.
+package promtext
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Writes metric families one after another. The first error of the
+// underlying writer is kept and everything after it is skipped.
+type Writer struct {
+	w   io.Writer
+	b   []byte
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Start a family with its HELP and TYPE lines; its samples follow.
+func (w *Writer) Family(name, typ, help string) {
+	w.b = append(w.b[:0], "# HELP "...)
+	w.b = append(w.b, name...)
+	w.b = append(w.b, ' ')
+	w.b = append(w.b, helpEscaper.Replace(help)...)
+	w.b = append(w.b, "\n# TYPE "...)
+	w.b = append(w.b, name...)
+	w.b = append(w.b, ' ')
+	w.b = append(w.b, typ...)
+	w.b = append(w.b, '\n')
+	w.flush()
+}
+
+// Write a sample; labels are pairs of names and values.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.b = append(w.b[:0], name...)
+	if len(labels) > 0 {
+		w.b = append(w.b, '{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b = append(w.b, ',')
+			}
+			w.b = append(w.b, labels[i]...)
+			w.b = append(w.b, '=')
+			w.b = append(w.b, '"')
+			w.b = append(w.b, labelEscaper.Replace(labels[i+1])...)
+			w.b = append(w.b, '"')
+		}
+		w.b = append(w.b, '}')
+	}
+	w.b = append(w.b, ' ')
+	w.b = strconv.AppendFloat(w.b, value, 'g', -1, 64)
+	w.b = append(w.b, '\n')
+	w.flush()
+}
+
+// Write a family of a single unlabeled gauge.
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Family(name, Gauge, help)
+	w.Sample(name, value)
+}
+
+// Write a family of a single unlabeled counter.
+func (w *Writer) Counter(name, help string, value float64) {
+	w.Family(name, Counter, help)
+	w.Sample(name, value)
+}
+
+// Write the samples of a histogram from the counts per bucket, which are
+// not cumulative; bounds are the inclusive upper bounds of the buckets.
+func (w *Writer) Histogram(name string, bounds []float64, counts []uint64, sum float64, labels ...string) {
+	var total uint64
+	bucket := append(labels[:len(labels):len(labels)], "le", "")
+	for i, n := range counts {
+		total += n
+		bucket[len(bucket)-1] = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		w.Sample(name+"_bucket", float64(total), bucket...)
+	}
+	bucket[len(bucket)-1] = "+Inf"
+	w.Sample(name+"_bucket", float64(total), bucket...)
+	w.Sample(name+"_sum", sum, labels...)
+	w.Sample(name+"_count", float64(total), labels...)
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) flush() {
+	if w.err == nil {
+		_, w.err = w.w.Write(w.b)
+	}
+}
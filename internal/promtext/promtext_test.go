@@ -0,0 +1,50 @@
+package promtext
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.Family("requests_total", Counter, "Requests\nserved.")
+	w.Sample("requests_total", 3, "peer", `a"b\c`)
+	w.Family("size_bytes", Histogram, "Size.")
+	w.Histogram("size_bytes", []float64{8, 16}, []uint64{1, 2}, 30, "direction", "in")
+
+	expected := `# HELP requests_total Requests\nserved.
+# TYPE requests_total counter
+requests_total{peer="a\"b\\c"} 3
+# HELP size_bytes Size.
+# TYPE size_bytes histogram
+size_bytes_bucket{direction="in",le="8"} 1
+size_bytes_bucket{direction="in",le="16"} 3
+size_bytes_bucket{direction="in",le="+Inf"} 3
+size_bytes_sum{direction="in"} 30
+size_bytes_count{direction="in"} 3
+`
+	if b.String() != expected || w.Err() != nil {
+		t.Fatalf("TestWriter expected\n%s\ngot\n%s(%v)", expected, b.String(), w.Err())
+	}
+}
+
+type failingWriter struct{ n int }
+
+var errFull = errors.New("full")
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	f.n++
+	return 0, errFull
+}
+
+func TestWriterError(t *testing.T) {
+	f := &failingWriter{}
+	w := NewWriter(f)
+	w.Gauge("up", "Up.", 1)
+	w.Counter("requests_total", "Requests.", 1)
+	if w.Err() != errFull || f.n != 1 {
+		t.Fatalf("TestWriterError expected the first error to stop the writes got %v after %d writes.", w.Err(), f.n)
+	}
+}
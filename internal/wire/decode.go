@@ -0,0 +1,153 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncated      = errors.New("Datagram is truncated")
+	ErrLengthExceeded = errors.New("Length field exceeds datagram")
+)
+
+// Describes why a datagram could not be decoded. Decoders never panic on
+// malformed input; they return a *DecodeError and only the offending
+// packet is discarded.
+type DecodeError struct {
+	// Name of the wire format which was being decoded
+	Format string
+
+	// Byte offset of the field which could not be read
+	Offset int
+
+	// Either ErrTruncated, ErrLengthExceeded or a format-specific error
+	Reason error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: offset %d: %s", e.Format, e.Offset, e.Reason)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Reason
+}
+
+// Bounds-checked cursor over a received datagram. Once a read fails all
+// subsequent reads return zero values and Err reports the first failure,
+// so decoders can read a whole header and check for errors once.
+//
+// Variable-length fields are returned as sub-slices of the datagram, so a
+// hostile length field can never cause an allocation larger than the
+// datagram itself.
+type Reader struct {
+	format string
+	buf    []byte
+	off    int
+	err    error
+}
+
+func NewReader(format string, b []byte) Reader {
+	return Reader{format: format, buf: b}
+}
+
+// Record the first failure at the current offset.
+func (r *Reader) Fail(reason error) {
+	r.failAt(r.off, reason)
+}
+
+// Record the first failure at the offset of a field already read.
+func (r *Reader) failAt(off int, reason error) {
+	if r.err == nil {
+		r.err = &DecodeError{r.format, off, reason}
+	}
+}
+
+// Returns the next n bytes without copying them, or nil on failure.
+func (r *Reader) Next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf)-r.off {
+		r.Fail(ErrTruncated)
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *Reader) Uint8() uint8 {
+	if b := r.Next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *Reader) Uint16() uint16 {
+	if b := r.Next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *Reader) Uint32() uint32 {
+	if b := r.Next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *Reader) Uint64() uint64 {
+	if b := r.Next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// Reads a field prefixed by its 8-bit length.
+func (r *Reader) Bytes8() []byte {
+	n := int(r.Uint8())
+	if r.err == nil && n > r.Remaining() {
+		r.failAt(r.off-1, ErrLengthExceeded)
+		return nil
+	}
+	return r.Next(n)
+}
+
+// Reads a field prefixed by its 16-bit length.
+func (r *Reader) Bytes16() []byte {
+	n := int(r.Uint16())
+	if r.err == nil && n > r.Remaining() {
+		r.failAt(r.off-2, ErrLengthExceeded)
+		return nil
+	}
+	return r.Next(n)
+}
+
+// Returns everything which has not been read yet.
+func (r *Reader) Rest() []byte {
+	return r.Next(r.Remaining())
+}
+
+// Fails with ErrMalformed unless everything has been read.
+func (r *Reader) End() {
+	if r.Remaining() != 0 {
+		r.Fail(ErrMalformed)
+	}
+}
+
+// Number of unread bytes.
+func (r *Reader) Remaining() int {
+	return len(r.buf) - r.off
+}
+
+// Offset of the next read from the start of the datagram.
+func (r *Reader) Offset() int {
+	return r.off
+}
+
+// First error encountered, if any.
+func (r *Reader) Err() error {
+	return r.err
+}
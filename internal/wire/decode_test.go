@@ -0,0 +1,206 @@
+package wire
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReaderFields(t *testing.T) {
+	b := []byte{0x01, 0x02, 0x03, 0x02, 'o', 'k', 0x00, 0x02, 'h', 'i', 0xff}
+	r := NewReader("test", b)
+
+	if v := r.Uint8(); v != 0x01 {
+		t.Fatalf("TestReaderFields expected uint8 %d got %d.", 0x01, v)
+	}
+	if v := r.Uint16(); v != 0x0203 {
+		t.Fatalf("TestReaderFields expected uint16 %d got %d.", 0x0203, v)
+	}
+	if v := string(r.Bytes8()); v != "ok" {
+		t.Fatalf("TestReaderFields expected field %q got %q.", "ok", v)
+	}
+	if v := string(r.Bytes16()); v != "hi" {
+		t.Fatalf("TestReaderFields expected field %q got %q.", "hi", v)
+	}
+	if v := r.Rest(); len(v) != 1 || v[0] != 0xff {
+		t.Fatalf("TestReaderFields expected rest %v got %v.", []byte{0xff}, v)
+	}
+	if r.End(); r.Err() != nil {
+		t.Fatalf("TestReaderFields unexpected error %s", r.Err())
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	r := NewReader("test", []byte{0x01, 0x02, 0x03})
+	r.Uint16()
+	r.Uint32()
+	r.Uint8()
+
+	err, ok := r.Err().(*DecodeError)
+	if !ok {
+		t.Fatalf("TestReaderTruncated expected *DecodeError got %v.", r.Err())
+	}
+	if err.Reason != ErrTruncated || err.Offset != 2 {
+		t.Fatalf("TestReaderTruncated expected %s at offset 2 got %s.", ErrTruncated, err)
+	}
+}
+
+func TestReaderLengthExceeded(t *testing.T) {
+	r := NewReader("test", []byte{'x', 0xff, 0xff, 'x'})
+	r.Uint8()
+	if b := r.Bytes16(); b != nil {
+		t.Fatalf("TestReaderLengthExceeded expected nil field got %v.", b)
+	}
+
+	err, ok := r.Err().(*DecodeError)
+	if !ok || err.Reason != ErrLengthExceeded || err.Offset != 1 {
+		t.Fatalf("TestReaderLengthExceeded expected %s at offset 1 got %v.", ErrLengthExceeded, r.Err())
+	}
+}
+
+// Every decoder of a golden vector, and the reader itself, so that all of
+// them are covered by TestFuzzDecoders, TestDecodeAllocBound and FuzzDecode
+func fuzzDecoders() map[string]func([]byte) error {
+	decoders := map[string]func([]byte) error{"reader": fuzzReader}
+	for _, vec := range vectors() {
+		decode := vec.decode
+		decoders[vec.name] = func(b []byte) error {
+			_, _, err := decode(b)
+			return err
+		}
+	}
+	return decoders
+}
+
+// Exercise every reader method in an input-dependent order.
+func fuzzReader(b []byte) error {
+	r := NewReader("fuzz", b)
+	for r.Err() == nil && r.Remaining() > 0 {
+		switch r.Uint8() % 8 {
+		case 0:
+			r.Uint16()
+		case 1:
+			r.Uint32()
+		case 2:
+			r.Uint64()
+		case 3:
+			r.Bytes8()
+		case 4:
+			r.Bytes16()
+		case 5:
+			r.Next(int(int8(r.Uint8())))
+		case 6:
+			r.End()
+		case 7:
+			r.Rest()
+		}
+	}
+	return r.Err()
+}
+
+// Golden vectors of the current version, which are mutated to reach deeper
+// into the decoders
+func fuzzCorpus(tb testing.TB) [][]byte {
+	corpus := [][]byte{{}}
+	for _, vec := range vectors() {
+		b, err := readGolden(filepath.Join("testdata", fmt.Sprintf("v%d", Current), vec.name+".hex"))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		corpus = append(corpus, b)
+	}
+	return corpus
+}
+
+func TestFuzzDecoders(t *testing.T) {
+	rnd := rand.New(rand.NewSource(439))
+	corpus := fuzzCorpus(t)
+	for name, decode := range fuzzDecoders() {
+		for i := 0; i < 10000; i++ {
+			var b []byte
+			if i%2 == 0 {
+				b = make([]byte, rnd.Intn(1500))
+				for j := range b {
+					b[j] = byte(rnd.Intn(256))
+				}
+			} else {
+				b = mutate(rnd, corpus[rnd.Intn(len(corpus))])
+			}
+			err, msg := decodeSafely(decode, b)
+			if msg != "" {
+				t.Fatalf("TestFuzzDecoders %s panicked on %x: %s", name, b, msg)
+			}
+			if _, ok := err.(*DecodeError); err != nil && err != ErrKind && !ok {
+				t.Fatalf("TestFuzzDecoders expected %s to fail with a *DecodeError on %x, got %v", name, b, err)
+			}
+		}
+	}
+}
+
+// Bytes a decoder may allocate for a datagram of n bytes: a few words per
+// byte for the decoded fields, never what a length field claims.
+func allocBound(n int) uint64 {
+	return uint64(64*n + 1024)
+}
+
+func TestDecodeAllocBound(t *testing.T) {
+	rnd := rand.New(rand.NewSource(4390))
+	corpus := fuzzCorpus(t)
+	var before, after runtime.MemStats
+	for name, decode := range fuzzDecoders() {
+		for i := 0; i < 200; i++ {
+			b := mutate(rnd, corpus[rnd.Intn(len(corpus))])
+			runtime.ReadMemStats(&before)
+			decode(b)
+			runtime.ReadMemStats(&after)
+			if n := after.TotalAlloc - before.TotalAlloc; n > allocBound(len(b)) {
+				t.Fatalf("TestDecodeAllocBound expected %s to allocate at most %d bytes for %x, got %d", name, allocBound(len(b)), b, n)
+			}
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, b := range fuzzCorpus(f) {
+		f.Add(b)
+	}
+	decoders := fuzzDecoders()
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for name, decode := range decoders {
+			err := decode(b)
+			if _, ok := err.(*DecodeError); err != nil && err != ErrKind && !ok {
+				t.Fatalf("FuzzDecode expected %s to fail with a *DecodeError, got %v", name, err)
+			}
+		}
+	})
+}
+
+// Returns the error of decode, or the panic message if it panicked.
+func decodeSafely(decode func([]byte) error, b []byte) (err error, msg string) {
+	defer func() {
+		if x := recover(); x != nil {
+			msg = fmt.Sprint(x)
+		}
+	}()
+	return decode(b), ""
+}
+
+// Copy b with a few random bytes flipped, inserted or removed.
+func mutate(rnd *rand.Rand, b []byte) []byte {
+	m := make([]byte, len(b), len(b)+4)
+	copy(m, b)
+	for n := rnd.Intn(4); n >= 0; n-- {
+		switch {
+		case len(m) > 0 && rnd.Intn(3) == 0:
+			i := rnd.Intn(len(m))
+			m[i] ^= byte(1 << uint(rnd.Intn(8)))
+		case len(m) > 0 && rnd.Intn(2) == 0:
+			m = m[:rnd.Intn(len(m))]
+		default:
+			m = append(m, byte(rnd.Intn(256)))
+		}
+	}
+	return m
+}
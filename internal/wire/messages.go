@@ -0,0 +1,846 @@
+package wire
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Magic bytes and id preceding the payload of Acked and Broadcast and the
+// reason of Nack, and making up all of Ack
+const (
+	AckedHeaderSize     = 2 + 8
+	BroadcastHeaderSize = 2 + 8
+)
+
+// Broadcast which every recipient acknowledges
+type Acked struct {
+	ID      uint64
+	Payload []byte
+}
+
+func (m Acked) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, AckedHeaderSize, AckedHeaderSize+len(m.Payload))
+	copy(b, ackedMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeAcked(b []byte) (Acked, Version, error) {
+	r, ok := open("acked", b, ackedMagic)
+	if !ok {
+		return Acked{}, 0, ErrKind
+	}
+	m := Acked{ID: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return Acked{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Acknowledgement of an Acked broadcast
+type Ack struct {
+	ID uint64
+}
+
+func (m Ack) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, AckedHeaderSize)
+	copy(b, ackMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return b, nil
+}
+
+func DecodeAck(b []byte) (Ack, Version, error) {
+	r, ok := open("ack", b, ackMagic)
+	if !ok {
+		return Ack{}, 0, ErrKind
+	}
+	m := Ack{r.Uint64()}
+	if r.Err() != nil {
+		return Ack{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Negative acknowledgement of an Acked broadcast which the recipient
+// received but rejected, with the reason as text
+type Nack struct {
+	ID     uint64
+	Reason string
+}
+
+func (m Nack) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, AckedHeaderSize, AckedHeaderSize+len(m.Reason))
+	copy(b, nackMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return append(b, m.Reason...), nil
+}
+
+func DecodeNack(b []byte) (Nack, Version, error) {
+	r, ok := open("nack", b, nackMagic)
+	if !ok {
+		return Nack{}, 0, ErrKind
+	}
+	id := r.Uint64()
+	reason := r.Rest()
+	if r.Err() != nil {
+		return Nack{}, 0, r.Err()
+	}
+	return Nack{id, string(reason)}, V1, nil
+}
+
+// Message carried over from another cluster by a bridge: magic, cluster
+// name length and name, IPv4 address and port of the sender, message
+type Forwarded struct {
+	Cluster string
+	IP      [4]byte
+	Port    uint16
+	Msg     []byte
+}
+
+func (m Forwarded) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 2+1+len(m.Cluster)+6+len(m.Msg))
+	b = append(b, forwardedMagic[:]...)
+	b, err := appendString8(b, m.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	b = appendAddr(b, m.IP, m.Port)
+	return append(b, m.Msg...), nil
+}
+
+// The message aliases b.
+func DecodeForwarded(b []byte) (Forwarded, Version, error) {
+	r, ok := open("forwarded", b, forwardedMagic)
+	if !ok {
+		return Forwarded{}, 0, ErrKind
+	}
+	cluster := r.Bytes8()
+	ip, port := readAddr(&r)
+	msg := r.Rest()
+	if r.Err() != nil {
+		return Forwarded{}, 0, r.Err()
+	}
+	return Forwarded{string(cluster), ip, port, msg}, V1, nil
+}
+
+// Subsystem byte and big-endian data length preceding every segment
+const SegmentHeaderSize = 3
+
+// Part of a message owned by one subsystem; subsystem zero is reserved
+// for messages which are not framed as segments.
+type Segment struct {
+	Subsystem uint8
+	Data      []byte
+}
+
+// Frame the segments into a single message. Segments carry no magic; the
+// framing is the same at every version so far.
+func EncodeSegments(v Version, segments ...Segment) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, s := range segments {
+		if len(s.Data) > 0xffff {
+			return nil, ErrTooLong
+		}
+		n += SegmentHeaderSize + len(s.Data)
+	}
+	b := make([]byte, 0, n)
+	for _, s := range segments {
+		b = append(b, s.Subsystem)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s.Data)))
+		b = append(b, s.Data...)
+	}
+	return b, nil
+}
+
+// Split a message produced by EncodeSegments. The segment data aliases b.
+func DecodeSegments(b []byte) ([]Segment, Version, error) {
+	r := NewReader("segments", b)
+	var segments []Segment
+	for r.Err() == nil && (r.Remaining() > 0 || len(segments) == 0) {
+		s := r.Uint8()
+		if s == 0 {
+			r.failAt(r.off-1, ErrMalformed)
+		}
+		data := r.Bytes16()
+		if r.Err() == nil {
+			segments = append(segments, Segment{s, data})
+		}
+	}
+	if r.Err() != nil {
+		return nil, 0, r.Err()
+	}
+	return segments, V1, nil
+}
+
+// Handshake of a joining node: magic, flags, name up to the end
+type Join struct {
+	Name  string
+	Flags uint8
+}
+
+func (m Join) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 3+len(m.Name))
+	b = append(b, joinMagic[:]...)
+	b = append(b, m.Flags)
+	return append(b, m.Name...), nil
+}
+
+func DecodeJoin(b []byte) (Join, Version, error) {
+	r, ok := open("join", b, joinMagic)
+	if !ok {
+		return Join{}, 0, ErrKind
+	}
+	flags := r.Uint8()
+	name := r.Rest()
+	if r.Err() != nil {
+		return Join{}, 0, r.Err()
+	}
+	return Join{Name: string(name), Flags: flags}, V1, nil
+}
+
+// Entry of a member list
+type Member struct {
+	Name string
+	IP   [4]byte
+	Port uint16
+}
+
+// Bytes the member takes up in a Members message
+func (m Member) Size() int {
+	return 1 + len(m.Name) + 6
+}
+
+// Member list sent in reply to a Join: magic, then name length, name,
+// IPv4 address and port per member
+type Members struct {
+	Members []Member
+}
+
+func (m Members) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), membersMagic[:]...)
+	for _, member := range m.Members {
+		var err error
+		if b, err = appendString8(b, member.Name); err != nil {
+			return nil, err
+		}
+		b = appendAddr(b, member.IP, member.Port)
+	}
+	return b, nil
+}
+
+func DecodeMembers(b []byte) (Members, Version, error) {
+	r, ok := open("members", b, membersMagic)
+	if !ok {
+		return Members{}, 0, ErrKind
+	}
+	var m Members
+	for r.Err() == nil && r.Remaining() > 0 {
+		name := r.Bytes8()
+		ip, port := readAddr(&r)
+		if r.Err() == nil {
+			m.Members = append(m.Members, Member{string(name), ip, port})
+		}
+	}
+	if r.Err() != nil {
+		return Members{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Trailer footer: length of the hops, hop count, dropped hop count,
+// origin port and IPv4 address, trace id and magic
+const TraceFooterSize = 2 + 1 + 1 + 2 + 4 + 8 + 2
+
+// Length prefix of the node id and the hop time in Unix nanoseconds
+const HopOverhead = 1 + 8
+
+// Relay of a traced message
+type Hop struct {
+	Node string
+	At   int64
+}
+
+// Trailer appended to a traced message: the hops oldest first, then the
+// footer. At most 255 hops fit.
+type Trace struct {
+	ID        uint64
+	IP        [4]byte
+	Port      uint16
+	Hops      []Hop
+	Truncated uint8
+}
+
+// Bytes the trailer adds to a message
+func (t Trace) Size() int {
+	n := TraceFooterSize
+	for _, h := range t.Hops {
+		n += HopOverhead + len(h.Node)
+	}
+	return n
+}
+
+func AppendTrace(payload []byte, t Trace, v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	if len(t.Hops) > 255 || t.Size()-TraceFooterSize > 0xffff {
+		return nil, ErrTooLong
+	}
+	b := make([]byte, 0, len(payload)+t.Size())
+	b = append(b, payload...)
+	start := len(b)
+	for _, h := range t.Hops {
+		var err error
+		if b, err = appendString8(b, h.Node); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint64(b, uint64(h.At))
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(b)-start))
+	b = append(b, byte(len(t.Hops)), t.Truncated)
+	b = binary.BigEndian.AppendUint16(b, t.Port)
+	b = append(b, t.IP[:]...)
+	b = binary.BigEndian.AppendUint64(b, t.ID)
+	return append(b, traceMagic[:]...), nil
+}
+
+// Separate the payload, which aliases b, from the trailer.
+func SplitTrace(b []byte) ([]byte, Trace, Version, error) {
+	n := len(b)
+	if n < TraceFooterSize || b[n-2] != traceMagic[0] || b[n-1] != traceMagic[1] {
+		return nil, Trace{}, 0, ErrKind
+	}
+	footer := NewReader("trace", b)
+	footer.off = n - TraceFooterSize
+	hopsLen := int(footer.Uint16())
+	count := int(footer.Uint8())
+	t := Trace{Truncated: footer.Uint8(), Port: footer.Uint16()}
+	copy(t.IP[:], footer.Next(4))
+	t.ID = footer.Uint64()
+	if hopsLen > n-TraceFooterSize {
+		footer.failAt(n-TraceFooterSize, ErrLengthExceeded)
+		return nil, Trace{}, 0, footer.Err()
+	}
+
+	payloadLen := n - TraceFooterSize - hopsLen
+	hops := NewReader("trace", b[:n-TraceFooterSize])
+	hops.off = payloadLen
+	t.Hops = make([]Hop, 0, count)
+	for i := 0; i < count && hops.Err() == nil; i++ {
+		node := hops.Bytes8()
+		at := int64(hops.Uint64())
+		if hops.Err() == nil {
+			t.Hops = append(t.Hops, Hop{string(node), at})
+		}
+	}
+	hops.End()
+	if hops.Err() != nil {
+		return nil, Trace{}, 0, hops.Err()
+	}
+	return b[:payloadLen], t, V1, nil
+}
+
+// Path of a traced message reported to its origin: magic, trace id, and
+// the path as a trailer without payload
+type Report struct {
+	ID      uint64
+	Trailer []byte
+}
+
+// Bytes preceding the trailer of a Report
+const ReportHeaderSize = 2 + 8
+
+func (m Report) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, ReportHeaderSize, ReportHeaderSize+len(m.Trailer))
+	copy(b, reportMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return append(b, m.Trailer...), nil
+}
+
+// The trailer aliases b.
+func DecodeReport(b []byte) (Report, Version, error) {
+	r, ok := open("report", b, reportMagic)
+	if !ok {
+		return Report{}, 0, ErrKind
+	}
+	m := Report{ID: r.Uint64(), Trailer: r.Rest()}
+	if r.Err() != nil {
+		return Report{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Broadcast or multicast datagram tagged with the instance id of its
+// sender, to suppress loops
+type Broadcast struct {
+	Sender  uint64
+	Payload []byte
+}
+
+func (m Broadcast) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, BroadcastHeaderSize, BroadcastHeaderSize+len(m.Payload))
+	copy(b, broadcastMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.Sender)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeBroadcast(b []byte) (Broadcast, Version, error) {
+	r, ok := open("broadcast", b, broadcastMagic)
+	if !ok {
+		return Broadcast{}, 0, ErrKind
+	}
+	m := Broadcast{Sender: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return Broadcast{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, correlation id and idempotency key preceding the payload of
+// a Request; a Response carries only the correlation id
+const (
+	RequestHeaderSize  = 2 + 8 + 8
+	ResponseHeaderSize = 2 + 8
+)
+
+// Request expecting a Response with the same ID. Every attempt of a
+// retried request has its own ID but shares the Key, under which the
+// server remembers the response.
+type Request struct {
+	ID      uint64
+	Key     uint64
+	Payload []byte
+}
+
+func (m Request) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, RequestHeaderSize, RequestHeaderSize+len(m.Payload))
+	copy(b, requestMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	binary.BigEndian.PutUint64(b[10:], m.Key)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeRequest(b []byte) (Request, Version, error) {
+	r, ok := open("request", b, requestMagic)
+	if !ok {
+		return Request{}, 0, ErrKind
+	}
+	m := Request{ID: r.Uint64(), Key: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return Request{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+type Response struct {
+	ID      uint64
+	Payload []byte
+}
+
+func (m Response) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, ResponseHeaderSize, ResponseHeaderSize+len(m.Payload))
+	copy(b, responseMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeResponse(b []byte) (Response, Version, error) {
+	r, ok := open("response", b, responseMagic)
+	if !ok {
+		return Response{}, 0, ErrKind
+	}
+	m := Response{ID: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return Response{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes and trace id preceding the message of a TraceContext
+const TraceContextSize = 2 + 8
+
+// Envelope which carries the trace id of the request or broadcast that
+// caused the enclosed message.
+type TraceContext struct {
+	ID      uint64
+	Payload []byte
+}
+
+func (m TraceContext) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, TraceContextSize, TraceContextSize+len(m.Payload))
+	copy(b, contextMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.ID)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeTraceContext(b []byte) (TraceContext, Version, error) {
+	r, ok := open("tracecontext", b, contextMagic)
+	if !ok {
+		return TraceContext{}, 0, ErrKind
+	}
+	m := TraceContext{ID: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return TraceContext{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, flags and the advertised size
+const SizeHintSize = 2 + 1 + 2
+
+// Largest datagram the sender accepts. A hint which is not a Reply asks
+// the recipient to answer with its own.
+type SizeHint struct {
+	Size  uint16
+	Reply bool
+}
+
+func (m SizeHint) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), sizeHintMagic[:]...)
+	var flags byte
+	if m.Reply {
+		flags = 1
+	}
+	b = append(b, flags)
+	return binary.BigEndian.AppendUint16(b, m.Size), nil
+}
+
+func DecodeSizeHint(b []byte) (SizeHint, Version, error) {
+	r, ok := open("sizehint", b, sizeHintMagic)
+	if !ok {
+		return SizeHint{}, 0, ErrKind
+	}
+	flags := r.Uint8()
+	m := SizeHint{Size: r.Uint16(), Reply: flags&1 != 0}
+	r.End()
+	if r.Err() != nil {
+		return SizeHint{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, flags, probe id and size
+const PathProbeSize = 2 + 1 + 4 + 2
+
+// Probe of the path MTU. A probe is padded with zeros to Size bytes; the
+// Reply carries the ID and the number of bytes which arrived as Size.
+// Decoding accepts the truncated probes which ICMP errors quote.
+type PathProbe struct {
+	ID    uint32
+	Size  uint16
+	Reply bool
+}
+
+func (m PathProbe) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	n := PathProbeSize
+	if !m.Reply && int(m.Size) > n {
+		n = int(m.Size)
+	}
+	b := make([]byte, n)
+	copy(b, pathProbeMagic[:])
+	if m.Reply {
+		b[2] = 1
+	}
+	binary.BigEndian.PutUint32(b[3:], m.ID)
+	binary.BigEndian.PutUint16(b[7:], m.Size)
+	return b, nil
+}
+
+func DecodePathProbe(b []byte) (PathProbe, Version, error) {
+	r, ok := open("pathprobe", b, pathProbeMagic)
+	if !ok {
+		return PathProbe{}, 0, ErrKind
+	}
+	flags := r.Uint8()
+	m := PathProbe{ID: r.Uint32(), Size: r.Uint16(), Reply: flags&1 != 0}
+	if r.Err() != nil {
+		return PathProbe{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, flags, capability bits and incarnation
+const CapabilitiesSize = 2 + 1 + 4 + 8
+
+// Optional encodings the sender decodes. Receivers keep the hint with the
+// highest Incarnation, so a change replaces what was advertised before. A
+// hint which is not a Reply asks the recipient to answer with its own.
+type Capabilities struct {
+	Bits        uint32
+	Incarnation uint64
+	Reply       bool
+}
+
+func (m Capabilities) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), capsMagic[:]...)
+	var flags byte
+	if m.Reply {
+		flags = 1
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, m.Bits)
+	return binary.BigEndian.AppendUint64(b, m.Incarnation), nil
+}
+
+func DecodeCapabilities(b []byte) (Capabilities, Version, error) {
+	r, ok := open("capabilities", b, capsMagic)
+	if !ok {
+		return Capabilities{}, 0, ErrKind
+	}
+	flags := r.Uint8()
+	m := Capabilities{Bits: r.Uint32(), Incarnation: r.Uint64(), Reply: flags&1 != 0}
+	r.End()
+	if r.Err() != nil {
+		return Capabilities{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes and nonce
+const SelfTestSize = 2 + 8
+
+// Probe a node sends to a broadcast or multicast address to learn whether
+// such datagrams reach it at all. Nodes consume every self-test without
+// answering, so probes of other nodes are harmless.
+type SelfTest struct {
+	Nonce uint64
+}
+
+func (m SelfTest) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), selfTestMagic[:]...)
+	return binary.BigEndian.AppendUint64(b, m.Nonce), nil
+}
+
+func DecodeSelfTest(b []byte) (SelfTest, Version, error) {
+	r, ok := open("selftest", b, selfTestMagic)
+	if !ok {
+		return SelfTest{}, 0, ErrKind
+	}
+	m := SelfTest{r.Uint64()}
+	r.End()
+	if r.Err() != nil {
+		return SelfTest{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, flags, id, receive time and observed address
+const EchoHeaderSize = 2 + 1 + 8 + 8 + 16 + 2
+
+// Diagnostic echo. The Reply carries the ID and Payload of the request
+// along with the time the responder received it, in nanoseconds since
+// the Unix epoch, and the source address it saw, IPv4 addresses mapped
+// into IPv6; a request leaves them zero.
+type Echo struct {
+	ID       uint64
+	Received int64
+	IP       [16]byte
+	Port     uint16
+	Reply    bool
+	Payload  []byte
+}
+
+func (m Echo) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, EchoHeaderSize, EchoHeaderSize+len(m.Payload))
+	copy(b, echoMagic[:])
+	if m.Reply {
+		b[2] = 1
+	}
+	binary.BigEndian.PutUint64(b[3:], m.ID)
+	binary.BigEndian.PutUint64(b[11:], uint64(m.Received))
+	copy(b[19:], m.IP[:])
+	binary.BigEndian.PutUint16(b[35:], m.Port)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeEcho(b []byte) (Echo, Version, error) {
+	r, ok := open("echo", b, echoMagic)
+	if !ok {
+		return Echo{}, 0, ErrKind
+	}
+	flags := r.Uint8()
+	m := Echo{ID: r.Uint64(), Received: int64(r.Uint64()), Reply: flags&1 != 0}
+	copy(m.IP[:], r.Next(16))
+	m.Port = r.Uint16()
+	m.Payload = r.Rest()
+	if r.Err() != nil {
+		return Echo{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, epoch and sequence number
+const SequencedHeaderSize = 2 + 8 + 8
+
+// Payload stamped with the epoch of its sender and a sequence number which
+// grows within the epoch, so that receivers can reject replays.
+type Sequenced struct {
+	Epoch   uint64
+	Seq     uint64
+	Payload []byte
+}
+
+func (m Sequenced) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, SequencedHeaderSize, SequencedHeaderSize+len(m.Payload))
+	copy(b, sequencedMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.Epoch)
+	binary.BigEndian.PutUint64(b[10:], m.Seq)
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeSequenced(b []byte) (Sequenced, Version, error) {
+	r, ok := open("sequenced", b, sequencedMagic)
+	if !ok {
+		return Sequenced{}, 0, ErrKind
+	}
+	m := Sequenced{Epoch: r.Uint64(), Seq: r.Uint64(), Payload: r.Rest()}
+	if r.Err() != nil {
+		return Sequenced{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Magic bytes, version and CRC-32 of the version and the data
+const ConfigHeaderSize = 2 + 8 + 4
+
+// Versioned configuration document distributed to every member. The
+// checksum is computed by Encode and verified by DecodeConfig, which
+// reports a damaged document as a DecodeError for ErrMalformed.
+type Config struct {
+	Version uint64
+	Data    []byte
+}
+
+func (m Config) checksum() uint32 {
+	var version [8]byte
+	binary.BigEndian.PutUint64(version[:], m.Version)
+	return crc32.Update(crc32.ChecksumIEEE(version[:]), crc32.IEEETable, m.Data)
+}
+
+func (m Config) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, ConfigHeaderSize, ConfigHeaderSize+len(m.Data))
+	copy(b, configMagic[:])
+	binary.BigEndian.PutUint64(b[2:], m.Version)
+	binary.BigEndian.PutUint32(b[10:], m.checksum())
+	return append(b, m.Data...), nil
+}
+
+// The data aliases b.
+func DecodeConfig(b []byte) (Config, Version, error) {
+	r, ok := open("config", b, configMagic)
+	if !ok {
+		return Config{}, 0, ErrKind
+	}
+	m := Config{Version: r.Uint64()}
+	sum := r.Uint32()
+	m.Data = r.Rest()
+	if r.Err() == nil && sum != m.checksum() {
+		r.failAt(10, ErrMalformed)
+	}
+	if r.Err() != nil {
+		return Config{}, 0, r.Err()
+	}
+	return m, V1, nil
+}
+
+// Application frame of the given type from the named member. Protocol
+// messages never take this form, so application handlers only see what
+// other applications sent.
+type App struct {
+	Type    uint16
+	From    string
+	Payload []byte
+}
+
+func (m App) Encode(v Version) ([]byte, error) {
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 2+2+1+len(m.From)+len(m.Payload))
+	b = append(b, appMagic[:]...)
+	b = binary.BigEndian.AppendUint16(b, m.Type)
+	b, err := appendString8(b, m.From)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, m.Payload...), nil
+}
+
+// The payload aliases b.
+func DecodeApp(b []byte) (App, Version, error) {
+	r, ok := open("app", b, appMagic)
+	if !ok {
+		return App{}, 0, ErrKind
+	}
+	typ := r.Uint16()
+	from := r.Bytes8()
+	payload := r.Rest()
+	if r.Err() != nil {
+		return App{}, 0, r.Err()
+	}
+	return App{typ, string(from), payload}, V1, nil
+}
@@ -0,0 +1,183 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden vectors in testdata")
+
+// Message of every kind with the decoder which must give it back
+type vector struct {
+	name   string
+	encode func(Version) ([]byte, error)
+	decode func([]byte) (interface{}, Version, error)
+	want   interface{}
+}
+
+func vectors() []vector {
+	acked := Acked{ID: 0x0102030405060708, Payload: []byte("hello")}
+	ack := Ack{ID: 0x0102030405060708}
+	forwarded := Forwarded{Cluster: "east", IP: [4]byte{10, 0, 0, 1}, Port: 7946, Msg: []byte("msg")}
+	segments := []Segment{{1, []byte("probe")}, {3, []byte("rumor")}}
+	join := Join{Name: "node-1", Flags: 1}
+	members := Members{[]Member{
+		{"a", [4]byte{10, 0, 0, 1}, 7946},
+		{"b", [4]byte{10, 0, 0, 2}, 7947},
+	}}
+	trace := Trace{
+		ID:        42,
+		IP:        [4]byte{192, 168, 1, 1},
+		Port:      9000,
+		Hops:      []Hop{{"a", 1000}, {"b", 2000}},
+		Truncated: 3,
+	}
+	report := Report{ID: 42, Trailer: []byte{0x7a, 0xce}}
+	broadcast := Broadcast{Sender: 0xdeadbeef, Payload: []byte("anyone")}
+	request := Request{ID: 7, Key: 0x1122334455667788, Payload: []byte("query")}
+	response := Response{ID: 7, Payload: []byte("answer")}
+	sizeHint := SizeHint{Size: 1400, Reply: true}
+	pathProbe := PathProbe{ID: 3, Size: 16}
+	nack := Nack{ID: 12, Reason: "invalid"}
+	sequenced := Sequenced{Epoch: 1463400000, Seq: 9, Payload: []byte("fresh")}
+	capabilities := Capabilities{Bits: 0x5, Incarnation: 1463400000000000000}
+	traceContext := TraceContext{ID: 0x0123456789abcdef, Payload: []byte("query")}
+	selfTest := SelfTest{Nonce: 0x8899aabbccddeeff}
+	config := Config{Version: 3, Data: []byte("interval=1s")}
+	app := App{Type: 0x0102, From: "node-1", Payload: []byte("hello")}
+	echo := Echo{ID: 5, Received: 1463400000000000000, IP: [16]byte{10: 0xff, 11: 0xff, 12: 10, 15: 1}, Port: 7946, Reply: true, Payload: []byte("ping")}
+
+	type traced struct {
+		Payload []byte
+		Trace   Trace
+	}
+
+	return []vector{
+		{"acked", acked.Encode, func(b []byte) (interface{}, Version, error) { return DecodeAcked(b) }, acked},
+		{"ack", ack.Encode, func(b []byte) (interface{}, Version, error) { return DecodeAck(b) }, ack},
+		{"nack", nack.Encode, func(b []byte) (interface{}, Version, error) { return DecodeNack(b) }, nack},
+		{"forwarded", forwarded.Encode, func(b []byte) (interface{}, Version, error) { return DecodeForwarded(b) }, forwarded},
+		{"segments", func(v Version) ([]byte, error) { return EncodeSegments(v, segments...) },
+			func(b []byte) (interface{}, Version, error) { return DecodeSegments(b) }, segments},
+		{"join", join.Encode, func(b []byte) (interface{}, Version, error) { return DecodeJoin(b) }, join},
+		{"members", members.Encode, func(b []byte) (interface{}, Version, error) { return DecodeMembers(b) }, members},
+		{"trace", func(v Version) ([]byte, error) { return AppendTrace([]byte("payload"), trace, v) },
+			func(b []byte) (interface{}, Version, error) {
+				payload, t, v, err := SplitTrace(b)
+				return traced{payload, t}, v, err
+			}, traced{[]byte("payload"), trace}},
+		{"report", report.Encode, func(b []byte) (interface{}, Version, error) { return DecodeReport(b) }, report},
+		{"broadcast", broadcast.Encode, func(b []byte) (interface{}, Version, error) { return DecodeBroadcast(b) }, broadcast},
+		{"request", request.Encode, func(b []byte) (interface{}, Version, error) { return DecodeRequest(b) }, request},
+		{"response", response.Encode, func(b []byte) (interface{}, Version, error) { return DecodeResponse(b) }, response},
+		{"sizehint", sizeHint.Encode, func(b []byte) (interface{}, Version, error) { return DecodeSizeHint(b) }, sizeHint},
+		{"pathprobe", pathProbe.Encode, func(b []byte) (interface{}, Version, error) { return DecodePathProbe(b) }, pathProbe},
+		{"sequenced", sequenced.Encode, func(b []byte) (interface{}, Version, error) { return DecodeSequenced(b) }, sequenced},
+		{"capabilities", capabilities.Encode, func(b []byte) (interface{}, Version, error) { return DecodeCapabilities(b) }, capabilities},
+		{"tracecontext", traceContext.Encode, func(b []byte) (interface{}, Version, error) { return DecodeTraceContext(b) }, traceContext},
+		{"selftest", selfTest.Encode, func(b []byte) (interface{}, Version, error) { return DecodeSelfTest(b) }, selfTest},
+		{"config", config.Encode, func(b []byte) (interface{}, Version, error) { return DecodeConfig(b) }, config},
+		{"app", app.Encode, func(b []byte) (interface{}, Version, error) { return DecodeApp(b) }, app},
+		{"echo", echo.Encode, func(b []byte) (interface{}, Version, error) { return DecodeEcho(b) }, echo},
+	}
+}
+
+// Hex dump with one line per 16 bytes; lines starting with # are comments.
+func readGolden(path string) ([]byte, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var digits strings.Builder
+	for _, line := range strings.Split(string(text), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		digits.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	return hex.DecodeString(digits.String())
+}
+
+func writeGolden(path, name string, v Version, b []byte) error {
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "# %s at wire version %d\n", name, v)
+	for len(b) > 0 {
+		n := 16
+		if len(b) < n {
+			n = len(b)
+		}
+		fmt.Fprintln(&text, hex.EncodeToString(b[:n]))
+		b = b[n:]
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, text.Bytes(), 0644)
+}
+
+func TestGoldenVectors(t *testing.T) {
+	for _, v := range []Version{V1} {
+		for _, vec := range vectors() {
+			path := filepath.Join("testdata", fmt.Sprintf("v%d", v), vec.name+".hex")
+			b, err := vec.encode(v)
+			if err != nil {
+				t.Fatalf("TestGoldenVectors expected %s to encode at version %d, got %v", vec.name, v, err)
+			}
+			if *update {
+				if err := writeGolden(path, vec.name, v, b); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := readGolden(path)
+			if err != nil {
+				t.Fatalf("TestGoldenVectors expected vector %s, got %v", path, err)
+			}
+			if !bytes.Equal(b, golden) {
+				t.Fatalf("TestGoldenVectors expected %s to encode as %x, got %x", vec.name, golden, b)
+			}
+
+			decoded, version, err := vec.decode(golden)
+			if err != nil {
+				t.Fatalf("TestGoldenVectors expected %s to decode, got %v", path, err)
+			}
+			if version != v {
+				t.Fatalf("TestGoldenVectors expected %s to decode at version %d, got %d", path, v, version)
+			}
+			if !reflect.DeepEqual(decoded, vec.want) {
+				t.Fatalf("TestGoldenVectors expected %s to decode to %+v, got %+v", path, vec.want, decoded)
+			}
+		}
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	for _, v := range []Version{0, Current + 1} {
+		if v.Supported() {
+			t.Fatalf("TestUnsupportedVersion expected version %d to be unsupported", v)
+		}
+		for _, vec := range vectors() {
+			if _, err := vec.encode(v); err != ErrVersion {
+				t.Fatalf("TestUnsupportedVersion expected %s at version %d to fail with %v, got %v", vec.name, v, ErrVersion, err)
+			}
+		}
+	}
+}
+
+func TestDecodeOtherKind(t *testing.T) {
+	ack, _ := Ack{ID: 1}.Encode(Current)
+	if _, _, err := DecodeAcked(ack); err != ErrKind {
+		t.Fatalf("TestDecodeOtherKind expected %v, got %v", ErrKind, err)
+	}
+	_, _, err := DecodeMembers(append(append([]byte(nil), membersMagic[:]...), 5, 'a'))
+	if e, ok := err.(*DecodeError); !ok || e.Reason != ErrLengthExceeded || e.Offset != 2 {
+		t.Fatalf("TestDecodeOtherKind expected %v at offset 2, got %v", ErrLengthExceeded, err)
+	}
+}
@@ -0,0 +1,483 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Service type advertised and queried unless configured otherwise
+const DefaultMDNSService = "_gossip._udp.local."
+
+// Time Seeds waits for answers unless MDNS.Timeout is set
+const DefaultMDNSTimeout = time.Second
+
+// Multicast group and port of mDNS (RFC 6762)
+var MDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+var ErrMalformedDNS = errors.New("Malformed DNS message")
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN = 1
+
+	// Top bit of the class: unicast response wanted in questions, cache
+	// flush in records
+	dnsClassUnique = 0x8000
+
+	dnsFlagResponse = 0x8400
+
+	// TTL of the records in answers
+	mdnsTTL = 120
+)
+
+// Question or resource record of a DNS message; the fields after Class
+// are those of the record types mDNS discovery uses.
+type dnsRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+
+	// PTR and SRV target
+	Target string
+	// SRV
+	Priority, Weight, Port uint16
+	// TXT strings
+	Text []string
+	// A
+	IP net.IP
+}
+
+// DNS message with only the parts discovery needs. Decoding merges the
+// answer, authority and additional sections into Answers.
+type dnsMessage struct {
+	ID        uint16
+	Flags     uint16
+	Questions []dnsRecord
+	Answers   []dnsRecord
+}
+
+func (m *dnsMessage) encode() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b, m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, r := range m.Answers {
+		if b, err = appendName(b, r.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, r.Type)
+		b = binary.BigEndian.AppendUint16(b, r.Class)
+		b = binary.BigEndian.AppendUint32(b, r.TTL)
+
+		// length of the data is filled in once it is known
+		start := len(b)
+		b = append(b, 0, 0)
+		switch r.Type {
+		case dnsTypePTR:
+			b, err = appendName(b, r.Target)
+		case dnsTypeSRV:
+			b = binary.BigEndian.AppendUint16(b, r.Priority)
+			b = binary.BigEndian.AppendUint16(b, r.Weight)
+			b = binary.BigEndian.AppendUint16(b, r.Port)
+			b, err = appendName(b, r.Target)
+		case dnsTypeTXT:
+			for _, s := range r.Text {
+				if len(s) > 255 {
+					return nil, ErrMalformedDNS
+				}
+				b = append(b, byte(len(s)))
+				b = append(b, s...)
+			}
+		case dnsTypeA:
+			b = append(b, r.IP.To4()...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(b[start:], uint16(len(b)-start-2))
+	}
+	return b, nil
+}
+
+// Append the name as uncompressed labels.
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, ErrMalformedDNS
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func decodeDNS(b []byte) (*dnsMessage, error) {
+	r := wire.NewReader("dns", b)
+	m := &dnsMessage{ID: r.Uint16(), Flags: r.Uint16()}
+	questions := int(r.Uint16())
+	records := int(r.Uint16()) + int(r.Uint16()) + int(r.Uint16())
+
+	for i := 0; i < questions && r.Err() == nil; i++ {
+		q := dnsRecord{Name: nextName(&r, b)}
+		q.Type, q.Class = r.Uint16(), r.Uint16()
+		m.Questions = append(m.Questions, q)
+	}
+	for i := 0; i < records && r.Err() == nil; i++ {
+		rec := dnsRecord{Name: nextName(&r, b)}
+		rec.Type, rec.Class, rec.TTL = r.Uint16(), r.Uint16(), r.Uint32()
+		data := r.Bytes16()
+		if r.Err() != nil {
+			break
+		}
+
+		// names in the data may point anywhere in b, so the data is read
+		// at its offset in b
+		d := wire.NewReader("dns", b[:r.Offset()])
+		d.Next(r.Offset() - len(data))
+		rec.decodeData(&d, b)
+		if d.Err() != nil {
+			return nil, d.Err()
+		}
+		m.Answers = append(m.Answers, rec)
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return m, nil
+}
+
+func (r *dnsRecord) decodeData(d *wire.Reader, b []byte) {
+	switch r.Type {
+	case dnsTypePTR:
+		r.Target = nextName(d, b)
+	case dnsTypeSRV:
+		r.Priority, r.Weight, r.Port = d.Uint16(), d.Uint16(), d.Uint16()
+		r.Target = nextName(d, b)
+	case dnsTypeTXT:
+		for d.Err() == nil && d.Remaining() > 0 {
+			r.Text = append(r.Text, string(d.Bytes8()))
+		}
+	case dnsTypeA:
+		if d.Remaining() != 4 {
+			d.Fail(ErrMalformedDNS)
+		}
+		r.IP = net.IP(append([]byte(nil), d.Next(4)...))
+	}
+}
+
+// Read the possibly compressed name at the offset of r.
+func nextName(r *wire.Reader, b []byte) string {
+	name, end, err := readName(b, r.Offset())
+	if err != nil {
+		r.Fail(err)
+		return ""
+	}
+	r.Next(end - r.Offset())
+	return name
+}
+
+// Read a possibly compressed name at off; returns the name with a
+// trailing dot and the offset following it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, ErrMalformedDNS
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, ErrMalformedDNS
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(b) {
+				return "", 0, ErrMalformedDNS
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// Minimal mDNS responder and querier for zero configuration discovery on
+// the local network. The responder advertises this node as an instance of
+// Service with its port and cluster name; Seeds queries for instances and
+// keeps those of the same cluster, so MDNS feeds JoinFrom as a
+// SeedProvider.
+//
+// The responder joins the group with SO_REUSEADDR, sharing port 5353
+// with other mDNS users such as Avahi on Linux. Where the system responder
+// holds the port exclusively, Start fails; Seeds uses its own ephemeral
+// socket and asks for unicast answers, so querying works regardless.
+type MDNS struct {
+	Instance string
+	Cluster  string
+	Port     int
+
+	Service string
+	// Where queries go and the responder listens; a unicast address
+	// restricts both to a single host, e.g. loopback
+	Group     *net.UDPAddr
+	Interface *net.Interface
+	// Address advertised in the A record; the sender's address is used
+	// by the querier if it is missing
+	IP      net.IP
+	Timeout time.Duration
+
+	mutex sync.Mutex
+	sock  *net.UDPConn
+	next  uint16
+}
+
+// Advertise instance of cluster listening on port with the defaults.
+func NewMDNS(instance, cluster string, port int) *MDNS {
+	return &MDNS{
+		Instance: instance,
+		Cluster:  cluster,
+		Port:     port,
+		Service:  DefaultMDNSService,
+		Group:    MDNSGroup,
+		Timeout:  DefaultMDNSTimeout,
+	}
+}
+
+// Advertise instance of cluster on the port conn is bound to, e.g. the one
+// ListenRange ended up with. Returns transport.ErrNotConnected unless conn
+// is open.
+func NewMDNSForConn(instance, cluster string, conn *transport.Conn) (*MDNS, error) {
+	addr := conn.LocalAddr()
+	if addr == nil {
+		return nil, transport.ErrNotConnected
+	}
+	return NewMDNS(instance, cluster, addr.Port), nil
+}
+
+func (m *MDNS) instanceName() string {
+	return m.Instance + "." + m.Service
+}
+
+func (m *MDNS) hostName() string {
+	return m.Instance + ".local."
+}
+
+// Start answering queries for the service.
+func (m *MDNS) Start() error {
+	var sock *net.UDPConn
+	var err error
+	if m.Group.IP.IsMulticast() {
+		sock, err = net.ListenMulticastUDP("udp4", m.Interface, m.Group)
+	} else {
+		sock, err = net.ListenUDP("udp4", m.Group)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.sock = sock
+	m.mutex.Unlock()
+	go m.respond(sock)
+	return nil
+}
+
+// Stop the responder.
+func (m *MDNS) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sock == nil {
+		return nil
+	}
+	err := m.sock.Close()
+	m.sock = nil
+	return err
+}
+
+func (m *MDNS) respond(sock *net.UDPConn) {
+	buff := make([]byte, 9000)
+	for {
+		n, from, err := sock.ReadFromUDP(buff)
+		if err != nil {
+			return
+		}
+		query, err := decodeDNS(buff[:n])
+		if err != nil || query.Flags&0x8000 != 0 {
+			continue
+		}
+		if reply := m.answer(query); reply != nil {
+			if b, err := reply.encode(); err == nil {
+				// answer directly; queriers off port 5353 expect it
+				sock.WriteToUDP(b, from)
+			}
+		}
+	}
+}
+
+// Records of this instance matching the query, nil if none.
+func (m *MDNS) answer(query *dnsMessage) *dnsMessage {
+	service, instance, host := strings.ToLower(m.Service), strings.ToLower(m.instanceName()), strings.ToLower(m.hostName())
+	matched := false
+	for _, q := range query.Questions {
+		name := strings.ToLower(q.Name)
+		if name == service && (q.Type == dnsTypePTR || q.Type == dnsTypeANY) ||
+			name == instance && (q.Type == dnsTypeSRV || q.Type == dnsTypeTXT || q.Type == dnsTypeANY) ||
+			name == host && (q.Type == dnsTypeA || q.Type == dnsTypeANY) {
+			matched = true
+		}
+	}
+	if !matched {
+		return nil
+	}
+
+	reply := &dnsMessage{ID: query.ID, Flags: dnsFlagResponse, Questions: query.Questions}
+	reply.Answers = []dnsRecord{
+		{Name: m.Service, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Target: m.instanceName()},
+		{Name: m.instanceName(), Type: dnsTypeSRV, Class: dnsClassIN | dnsClassUnique, TTL: mdnsTTL, Port: uint16(m.Port), Target: m.hostName()},
+		{Name: m.instanceName(), Type: dnsTypeTXT, Class: dnsClassIN | dnsClassUnique, TTL: mdnsTTL, Text: []string{"cluster=" + m.Cluster}},
+	}
+	if ip := m.IP.To4(); ip != nil {
+		reply.Answers = append(reply.Answers, dnsRecord{Name: m.hostName(), Type: dnsTypeA, Class: dnsClassIN | dnsClassUnique, TTL: mdnsTTL, IP: ip})
+	}
+	return reply
+}
+
+// Check with ProbeMulticast whether queries to the group can work on this
+// host, within the timeout of the querier.
+func (m *MDNS) Probe() (transport.BroadcastProbe, error) {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultMDNSTimeout
+	}
+	return ProbeMulticast(m.Group, m.Interface, timeout)
+}
+
+// Query for instances of the service and return the addresses of those
+// in the same cluster, other than this one, which answered within the
+// timeout.
+func (m *MDNS) Seeds() ([]string, error) {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	m.mutex.Lock()
+	m.next++
+	id := m.next
+	m.mutex.Unlock()
+	query := &dnsMessage{ID: id, Questions: []dnsRecord{{Name: m.Service, Type: dnsTypePTR, Class: dnsClassIN | dnsClassUnique}}}
+	b, err := query.encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sock.WriteToUDP(b, m.Group); err != nil {
+		return nil, err
+	}
+
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultMDNSTimeout
+	}
+	sock.SetReadDeadline(time.Now().Add(timeout))
+	var seeds []string
+	seen := make(map[string]bool)
+	buff := make([]byte, 9000)
+	for {
+		n, from, err := sock.ReadFromUDP(buff)
+		if err != nil {
+			// the deadline ends the collection
+			return seeds, nil
+		}
+		reply, err := decodeDNS(buff[:n])
+		if err != nil || reply.Flags&0x8000 == 0 {
+			continue
+		}
+		for _, seed := range m.instances(reply, from.IP) {
+			if !seen[seed] {
+				seen[seed] = true
+				seeds = append(seeds, seed)
+			}
+		}
+	}
+}
+
+// Addresses of the instances of the own cluster in a response.
+func (m *MDNS) instances(reply *dnsMessage, from net.IP) []string {
+	srv := make(map[string]dnsRecord)
+	txt := make(map[string][]string)
+	ips := make(map[string]net.IP)
+	var names []string
+	for _, r := range reply.Answers {
+		name := strings.ToLower(r.Name)
+		switch r.Type {
+		case dnsTypePTR:
+			if name == strings.ToLower(m.Service) {
+				names = append(names, strings.ToLower(r.Target))
+			}
+		case dnsTypeSRV:
+			srv[name] = r
+		case dnsTypeTXT:
+			txt[name] = r.Text
+		case dnsTypeA:
+			ips[name] = r.IP
+		}
+	}
+
+	var seeds []string
+	for _, name := range names {
+		s, ok := srv[name]
+		if !ok || name == strings.ToLower(m.instanceName()) || !hasText(txt[name], "cluster="+m.Cluster) {
+			continue
+		}
+		ip := ips[strings.ToLower(s.Target)]
+		if ip == nil {
+			ip = from
+		}
+		seeds = append(seeds, net.JoinHostPort(ip.String(), strconv.Itoa(int(s.Port))))
+	}
+	return seeds
+}
+
+func hasText(text []string, s string) bool {
+	for _, t := range text {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestDNSEncoding(t *testing.T) {
+	m := &dnsMessage{
+		ID:        7,
+		Flags:     dnsFlagResponse,
+		Questions: []dnsRecord{{Name: "_gossip._udp.local.", Type: dnsTypePTR, Class: dnsClassIN}},
+		Answers: []dnsRecord{
+			{Name: "_gossip._udp.local.", Type: dnsTypePTR, Class: dnsClassIN, TTL: 120, Target: "a._gossip._udp.local."},
+			{Name: "a._gossip._udp.local.", Type: dnsTypeSRV, Class: dnsClassIN | dnsClassUnique, TTL: 120, Priority: 1, Weight: 2, Port: 7946, Target: "a.local."},
+			{Name: "a._gossip._udp.local.", Type: dnsTypeTXT, Class: dnsClassIN, TTL: 120, Text: []string{"cluster=dev", "v=1"}},
+			{Name: "a.local.", Type: dnsTypeA, Class: dnsClassIN, TTL: 120, IP: net.IPv4(192, 168, 1, 5).To4()},
+		},
+	}
+	b, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeDNS(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Fatalf("TestDNSEncoding expected %+v got %+v.", m, decoded)
+	}
+
+	for i := 0; i < len(b); i++ {
+		// truncated messages fail without panicking
+		decodeDNS(b[:i])
+	}
+	if _, err := (&dnsMessage{Questions: []dnsRecord{{Name: "a..local."}}}).encode(); err != ErrMalformedDNS {
+		t.Fatalf("TestDNSEncoding expected %q got %v.", ErrMalformedDNS, err)
+	}
+}
+
+func TestDNSCompression(t *testing.T) {
+	// a PTR answer whose target points back at the question's name
+	b := []byte{0, 1, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0}
+	b, _ = appendName(b, "_gossip._udp.local.")
+	b = append(b, 0, dnsTypePTR, 0, dnsClassIN)
+	b = append(b, 0xc0, 12, 0, dnsTypePTR, 0, dnsClassIN, 0, 0, 0, 120, 0, 4, 1, 'a', 0xc0, 12)
+
+	m, err := decodeDNS(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].Name != "_gossip._udp.local." || m.Answers[0].Target != "a._gossip._udp.local." {
+		t.Fatalf("TestDNSCompression expected a._gossip._udp.local. got %+v.", m.Answers)
+	}
+
+	// a pointer loop is rejected
+	loop := append([]byte(nil), b[:12]...)
+	loop[5] = 1
+	loop[7] = 0
+	loop = append(loop, 0xc0, 12, 0, 1, 0, 1)
+	if _, err := decodeDNS(loop); !errors.Is(err, ErrMalformedDNS) {
+		t.Fatalf("TestDNSCompression expected %q got %v.", ErrMalformedDNS, err)
+	}
+}
+
+func TestMDNSFilter(t *testing.T) {
+	dev := NewMDNS("a", "dev", 7946)
+	other := NewMDNS("b", "other", 7947)
+	self := NewMDNS("c", "dev", 7948)
+
+	reply := &dnsMessage{Flags: dnsFlagResponse}
+	for _, m := range []*MDNS{dev, other, self} {
+		reply.Answers = append(reply.Answers, m.answer(&dnsMessage{Questions: []dnsRecord{{Name: m.Service, Type: dnsTypePTR}}}).Answers...)
+	}
+	seeds := self.instances(reply, net.IPv4(10, 0, 0, 9))
+	if !reflect.DeepEqual(seeds, []string{"10.0.0.9:7946"}) {
+		t.Fatalf("TestMDNSFilter expected only the other dev instance got %v.", seeds)
+	}
+	if dev.answer(&dnsMessage{Questions: []dnsRecord{{Name: "_http._tcp.local.", Type: dnsTypePTR}}}) != nil {
+		t.Fatalf("TestMDNSFilter expected no answer for another service.")
+	}
+}
+
+// Two instances find each other through a responder on loopback.
+func TestMDNSDiscovery(t *testing.T) {
+	responder := NewMDNS("a", "dev", 7946)
+	responder.Group = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	responder.IP = net.IPv4(127, 0, 0, 1)
+	if err := responder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+
+	querier := NewMDNS("b", "dev", 7947)
+	querier.Group = responder.sock.LocalAddr().(*net.UDPAddr)
+	querier.Timeout = 200 * time.Millisecond
+	seeds, err := querier.Seeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seeds, []string{"127.0.0.1:7946"}) {
+		t.Fatalf("TestMDNSDiscovery expected 127.0.0.1:7946 got %v.", seeds)
+	}
+
+	joined, err := JoinFrom(querier, nil, 0, func(addr string) error { return nil })
+	if err != nil || joined != "127.0.0.1:7946" {
+		t.Fatalf("TestMDNSDiscovery expected to join 127.0.0.1:7946 got %q (%v).", joined, err)
+	}
+}
+
+func TestMDNSForConn(t *testing.T) {
+	conn := transport.NewConn()
+	if _, err := NewMDNSForConn("a", "test", conn); err != transport.ErrNotConnected {
+		t.Fatalf("TestMDNSForConn expected %v got %v.", transport.ErrNotConnected, err)
+	}
+
+	// the preferred port is taken, so the connection lands on another one
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	preferred := uint(taken.LocalAddr().(*net.UDPAddr).Port)
+	addr, err := conn.ListenRange(preferred, preferred+5)
+	if err != nil {
+		t.Skipf("no port free after %d: %v", preferred, err)
+	}
+	defer conn.Disconnect()
+
+	m, err := NewMDNSForConn("a", "test", conn)
+	if err != nil || m.Port != addr.Port || m.Port == int(preferred) {
+		t.Fatalf("TestMDNSForConn expected to advertise port %d got %+v (%v).", addr.Port, m, err)
+	}
+}
@@ -0,0 +1,280 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	ErrReapHorizon      = errors.New("Reap horizon is shorter than the anti-entropy interval")
+	ErrTombstoneHorizon = errors.New("Tombstone horizon is shorter than two anti-entropy intervals")
+)
+
+// Liveness of a member as gossiped; later states override earlier ones at
+// the same incarnation.
+type MemberState int
+
+const (
+	MemberAlive MemberState = iota
+	MemberSuspect
+	MemberDead
+	MemberLeft
+)
+
+func (s MemberState) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	case MemberLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Whether the member is gone and will be reaped
+func (s MemberState) gone() bool {
+	return s == MemberDead || s == MemberLeft
+}
+
+type Member struct {
+	Name        string
+	Addr        *net.UDPAddr
+	State       MemberState
+	Incarnation uint64
+
+	// Every address the member advertises in order of preference, e.g. a
+	// private one before a public one, of which Addr is the first; see
+	// AddrFailover. Only an update at a higher incarnation changes them.
+	Addrs []*net.UDPAddr
+
+	// Advertised along with the member, e.g. service tags; see Services
+	Tags []string
+
+	// When the state was last changed locally
+	Changed time.Time
+}
+
+// When dead and left members are forgotten. A gone member stays listed,
+// and is gossiped, for Horizon after it died or left, then it is reaped
+// and only a tombstone with its incarnation remains for TombstoneHorizon
+// so that stale gossip cannot resurrect it. Gossip about it at a higher
+// incarnation, i.e. a genuine rejoin, is accepted at any time.
+type ReapPolicy struct {
+	Horizon          time.Duration
+	TombstoneHorizon time.Duration
+
+	// Interval of the full state exchange between peers. A peer learns
+	// of a death no later than one interval after it happened, so it must
+	// still be listed then, and a peer which synced just before it may
+	// spread its old state for up to two intervals.
+	AntiEntropy time.Duration
+}
+
+// Check that the horizons cover the anti-entropy interval.
+func (p ReapPolicy) Validate() error {
+	if p.Horizon < p.AntiEntropy {
+		return ErrReapHorizon
+	}
+	if p.TombstoneHorizon < 2*p.AntiEntropy {
+		return ErrTombstoneHorizon
+	}
+	return nil
+}
+
+// Sizes of a MemberTable for metrics
+type MemberTableStats struct {
+	Members    int
+	Tombstones int
+
+	// Members reaped so far and updates rejected by a tombstone
+	Reaped, Resurrections uint64
+}
+
+type tombstone struct {
+	incarnation uint64
+	reaped      time.Time
+}
+
+// Component whose sends in flight follow a peer to its new address, such as
+// a transport.Conn, a Requester or an Acker
+type PeerMover interface {
+	MovePeer(from, to *net.UDPAddr)
+}
+
+// Callback for MemberTable.OnMove which moves the member in each of the
+// components, e.g. the connection first and the requesters on top of it.
+func FollowMoves(movers ...PeerMover) func(m Member, from *net.UDPAddr) {
+	return func(m Member, from *net.UDPAddr) {
+		for _, mover := range movers {
+			mover.MovePeer(from, m.Addr)
+		}
+	}
+}
+
+// Local view of the members which merges gossiped updates and reaps those
+// which died or left according to a ReapPolicy.
+type MemberTable struct {
+	policy ReapPolicy
+	clock  transport.Clock
+
+	// Called with every reaped member, outside the lock
+	OnReap func(m Member)
+
+	// Called outside the lock with every update which changed the address
+	// of a listed member, along with the address it had; see FollowMoves
+	OnMove func(m Member, from *net.UDPAddr)
+
+	// Called outside the lock with every update which declared a member
+	// dead or left; see CancelOnGone
+	OnGone func(m Member)
+
+	mutex      sync.Mutex
+	members    map[string]*Member
+	tombstones map[string]tombstone
+	stats      MemberTableStats
+}
+
+// Create an empty table; fails if the policy does not validate.
+func NewMemberTable(policy ReapPolicy) (*MemberTable, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &MemberTable{
+		policy:     policy,
+		clock:      transport.RealClock,
+		members:    make(map[string]*Member),
+		tombstones: make(map[string]tombstone),
+	}, nil
+}
+
+// Replace the source of time used by Update, Reap and Run.
+func (t *MemberTable) SetClock(clock transport.Clock) {
+	t.clock = clock
+}
+
+// Merge gossip about a member and return true if it changed the table. An
+// update wins with a higher incarnation, or at the same incarnation with
+// a later state; a tombstone rejects every update up to its incarnation.
+func (t *MemberTable) Update(m Member) bool {
+	t.mutex.Lock()
+	if stone, ok := t.tombstones[m.Name]; ok {
+		if m.Incarnation <= stone.incarnation {
+			t.stats.Resurrections++
+			t.mutex.Unlock()
+			return false
+		}
+		delete(t.tombstones, m.Name)
+	}
+	var from *net.UDPAddr
+	gone := m.State.gone()
+	if cur, ok := t.members[m.Name]; ok {
+		if m.Incarnation < cur.Incarnation || m.Incarnation == cur.Incarnation && m.State <= cur.State {
+			t.mutex.Unlock()
+			return false
+		}
+		if cur.Addr != nil && m.Addr != nil && !sameAddr(cur.Addr, m.Addr) {
+			from = cur.Addr
+		}
+		if m.Incarnation == cur.Incarnation {
+			m.Addrs = cur.Addrs
+		}
+		gone = gone && !cur.State.gone()
+	}
+	m.Changed = t.clock.Now()
+	t.members[m.Name] = &m
+	onMove, onGone := t.OnMove, t.OnGone
+	t.mutex.Unlock()
+
+	if from != nil && onMove != nil {
+		onMove(m, from)
+	}
+	if gone && onGone != nil {
+		onGone(m)
+	}
+	return true
+}
+
+// Members listed right now, including the gone ones within the horizon,
+// ordered by name.
+func (t *MemberTable) Members() []Member {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	members := make([]Member, 0, len(t.members))
+	for _, m := range t.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+func (t *MemberTable) Get(name string) (Member, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	m, ok := t.members[name]
+	if !ok {
+		return Member{}, false
+	}
+	return *m, true
+}
+
+func (t *MemberTable) Stats() MemberTableStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := t.stats
+	stats.Members, stats.Tombstones = len(t.members), len(t.tombstones)
+	return stats
+}
+
+// Replace the members which have been gone for the horizon by tombstones
+// and drop the tombstones which have expired.
+func (t *MemberTable) Reap() {
+	now := t.clock.Now()
+	var reaped []Member
+
+	t.mutex.Lock()
+	for name, m := range t.members {
+		if m.State.gone() && !now.Before(m.Changed.Add(t.policy.Horizon)) {
+			delete(t.members, name)
+			t.tombstones[name] = tombstone{m.Incarnation, now}
+			reaped = append(reaped, *m)
+		}
+	}
+	for name, stone := range t.tombstones {
+		if !now.Before(stone.reaped.Add(t.policy.TombstoneHorizon)) {
+			delete(t.tombstones, name)
+		}
+	}
+	t.stats.Reaped += uint64(len(reaped))
+	onReap := t.OnReap
+	t.mutex.Unlock()
+
+	if onReap != nil {
+		for _, m := range reaped {
+			onReap(m)
+		}
+	}
+}
+
+// Reap every period until done is closed.
+func (t *MemberTable) Run(period time.Duration, done <-chan bool) {
+	ticker := t.clock.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			t.Reap()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package gossip
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestReapPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy ReapPolicy
+		err    error
+	}{
+		{ReapPolicy{Horizon: time.Minute, TombstoneHorizon: time.Minute, AntiEntropy: 30 * time.Second}, nil},
+		{ReapPolicy{Horizon: 10 * time.Second, TombstoneHorizon: time.Minute, AntiEntropy: 30 * time.Second}, ErrReapHorizon},
+		{ReapPolicy{Horizon: time.Minute, TombstoneHorizon: 45 * time.Second, AntiEntropy: 30 * time.Second}, ErrTombstoneHorizon},
+	}
+	for _, test := range tests {
+		if _, err := NewMemberTable(test.policy); err != test.err {
+			t.Fatalf("TestReapPolicyValidate expected %v for %+v got %v.", test.err, test.policy, err)
+		}
+	}
+}
+
+func TestMemberTableReap(t *testing.T) {
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	table, err := NewMemberTable(ReapPolicy{Horizon: time.Minute, TombstoneHorizon: time.Minute, AntiEntropy: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.SetClock(clock)
+	var reaped []string
+	table.OnReap = func(m Member) { reaped = append(reaped, m.Name) }
+
+	table.Update(Member{Name: "a", Addr: peerAddr(1), Incarnation: 3})
+	table.Update(Member{Name: "b", Addr: peerAddr(2)})
+	if !table.Update(Member{Name: "a", Incarnation: 3, State: MemberDead}) || table.Update(Member{Name: "a", Incarnation: 3, State: MemberSuspect}) {
+		t.Fatalf("TestMemberTableReap expected the death to override older states.")
+	}
+
+	// listed within the horizon, reaped after it
+	clock.Advance(59 * time.Second)
+	table.Reap()
+	if m, ok := table.Get("a"); !ok || m.State != MemberDead {
+		t.Fatalf("TestMemberTableReap expected a to be listed as dead got %+v.", m)
+	}
+	clock.Advance(time.Second)
+	table.Reap()
+	if len(reaped) != 1 || reaped[0] != "a" || len(table.Members()) != 1 {
+		t.Fatalf("TestMemberTableReap expected a to be reaped got %v with %v.", reaped, table.Members())
+	}
+
+	// the tombstone rejects stale gossip but not a rejoin
+	if table.Update(Member{Name: "a", Incarnation: 3}) {
+		t.Fatalf("TestMemberTableReap expected stale gossip to be rejected.")
+	}
+	if !table.Update(Member{Name: "a", Incarnation: 4}) {
+		t.Fatalf("TestMemberTableReap expected the rejoin to be accepted.")
+	}
+	if s := table.Stats(); s != (MemberTableStats{Members: 2, Reaped: 1, Resurrections: 1}) {
+		t.Fatalf("TestMemberTableReap unexpected stats %+v.", s)
+	}
+}
+
+// Continuous churn: every second a member joins, another dies or leaves,
+// and peers which have not yet synced spread stale states of the dead.
+func TestMemberTableSoak(t *testing.T) {
+	const alive, steps = 50, 3000
+	policy := ReapPolicy{Horizon: 30 * time.Second, TombstoneHorizon: 20 * time.Second, AntiEntropy: 10 * time.Second}
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	table, err := NewMemberTable(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.SetClock(clock)
+	rnd := rand.New(rand.NewSource(1))
+
+	type death struct {
+		m    Member
+		when time.Time
+	}
+	var living []Member
+	var deaths []death
+	maxMembers, maxTombstones := 0, 0
+	for i := 0; i < steps; i++ {
+		m := Member{Name: fmt.Sprintf("node-%d", i), Addr: peerAddr(i), Incarnation: uint64(rnd.Intn(3))}
+		table.Update(m)
+		living = append(living, m)
+		if len(living) > alive {
+			j := rnd.Intn(len(living))
+			gone := living[j]
+			living = append(living[:j], living[j+1:]...)
+			gone.State = MemberDead + MemberState(rnd.Intn(2))
+			table.Update(gone)
+			deaths = append(deaths, death{gone, clock.Now()})
+		}
+
+		// stale gossip only exists for two anti-entropy intervals
+		for len(deaths) > 0 && clock.Now().Sub(deaths[0].when) > 2*policy.AntiEntropy {
+			deaths = deaths[1:]
+		}
+		if len(deaths) > 0 {
+			stale := deaths[rnd.Intn(len(deaths))].m
+			stale.State = MemberAlive
+			if table.Update(stale) {
+				t.Fatalf("TestMemberTableSoak expected %s to stay dead.", stale.Name)
+			}
+		}
+
+		clock.Advance(time.Second)
+		table.Reap()
+		s := table.Stats()
+		maxMembers, maxTombstones = max(maxMembers, s.Members), max(maxTombstones, s.Tombstones)
+	}
+
+	// the living plus a horizon of deaths, and a tombstone horizon of them
+	if limit := alive + 1 + int(policy.Horizon/time.Second); maxMembers > limit {
+		t.Fatalf("TestMemberTableSoak expected at most %d members got %d.", limit, maxMembers)
+	}
+	if limit := int(policy.TombstoneHorizon / time.Second); maxTombstones > limit {
+		t.Fatalf("TestMemberTableSoak expected at most %d tombstones got %d.", limit, maxTombstones)
+	}
+	if s := table.Stats(); int(s.Reaped) < steps-alive-int(policy.Horizon/time.Second)-1 {
+		t.Fatalf("TestMemberTableSoak expected the dead to be reaped got %+v.", s)
+	}
+}
+
+// Silent address which reports the first datagram sent to it
+func listenVoid(t *testing.T) (*net.UDPAddr, <-chan bool) {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	hit := make(chan bool, 1)
+	go func() {
+		if _, _, err := sock.ReadFromUDP(make([]byte, transport.MaxDatagramSize)); err == nil {
+			hit <- true
+		}
+	}()
+	return sock.LocalAddr().(*net.UDPAddr), hit
+}
+
+func TestMemberTableMoveRequest(t *testing.T) {
+	g := gossiptest.NewGroup(t, 2)
+	var attempts int32
+	NewRequester(g.Conns[1], func(req []byte, from *net.UDPAddr) []byte {
+		atomic.AddInt32(&attempts, 1)
+		return req
+	})
+	client := NewRequester(g.Conns[0], nil)
+	table, _ := NewMemberTable(ReapPolicy{})
+	table.OnMove = FollowMoves(g.Conns[0], client)
+	old, hit := listenVoid(t)
+	table.Update(Member{Name: "server", Addr: old, Incarnation: 1})
+
+	// the server moves while the first attempt is lost
+	result := make(chan error, 1)
+	go func() {
+		response, err := client.RequestWithRetry([]byte("transfer"), old, RetryOptions{
+			Deadline:    time.Now().Add(5 * time.Second),
+			MaxAttempts: 4,
+			Backoff:     func(int) time.Duration { return 100 * time.Millisecond },
+		})
+		if err == nil && string(response) != "transfer" {
+			err = fmt.Errorf("unexpected response %q", response)
+		}
+		result <- err
+	}()
+	select {
+	case <-hit:
+	case <-time.After(time.Second):
+		t.Fatalf("TestMemberTableMoveRequest expected an attempt at the old address.")
+	}
+	table.Update(Member{Name: "server", Addr: g.Addrs[1], Incarnation: 2})
+
+	if err := <-result; err != nil {
+		t.Fatalf("TestMemberTableMoveRequest expected the request to complete got %v.", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("TestMemberTableMoveRequest expected the request to be handled once got %d.", n)
+	}
+}
+
+func TestMemberTableMoveAcked(t *testing.T) {
+	origin, _ := startAcker(t, nil)
+	_, addr := startAcker(t, nil)
+	table, _ := NewMemberTable(ReapPolicy{})
+	table.OnMove = FollowMoves(origin.conn, origin)
+	old, hit := listenVoid(t)
+	table.Update(Member{Name: "b", Addr: old, Incarnation: 1})
+
+	results := make(chan AckResult, 1)
+	go func() {
+		r, err := origin.BroadcastAcked(map[string]*net.UDPAddr{"b": old}, []byte("config"), 2*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- r
+	}()
+	select {
+	case <-hit:
+	case <-time.After(time.Second):
+		t.Fatalf("TestMemberTableMoveAcked expected a copy at the old address.")
+	}
+	table.Update(Member{Name: "b", Addr: addr, Incarnation: 2})
+
+	if r := <-results; !r.Complete() || len(r.Confirmed) != 1 {
+		t.Fatalf("TestMemberTableMoveAcked expected the moved member to confirm got %+v.", r)
+	}
+
+	// updates which keep the address move nothing
+	moved := false
+	table.OnMove = func(Member, *net.UDPAddr) { moved = true }
+	table.Update(Member{Name: "b", Addr: addr, Incarnation: 3})
+	if moved {
+		t.Fatalf("TestMemberTableMoveAcked expected no move for the same address.")
+	}
+}
@@ -0,0 +1,168 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Protocol component which produced a part of a message
+type Subsystem uint8
+
+const (
+	// Messages which are not framed as segments
+	SubsystemOther Subsystem = iota
+	SubsystemProbe
+	SubsystemAntiEntropy
+	SubsystemBroadcast
+	SubsystemKV
+	subsystemCount
+)
+
+func (s Subsystem) String() string {
+	switch s {
+	case SubsystemOther:
+		return "other"
+	case SubsystemProbe:
+		return "probe"
+	case SubsystemAntiEntropy:
+		return "anti-entropy"
+	case SubsystemBroadcast:
+		return "broadcast"
+	case SubsystemKV:
+		return "kv"
+	}
+	return fmt.Sprintf("subsystem(%d)", uint8(s))
+}
+
+// Subsystem byte and big-endian data length preceding every segment
+const segmentHeaderSize = wire.SegmentHeaderSize
+
+var ErrMalformedSegment = errors.New("Malformed segment")
+
+// Part of a message owned by one subsystem. The first segment of a message
+// is its carrier; any further segments are piggybacked on it.
+type Segment struct {
+	Subsystem Subsystem
+	Data      []byte
+}
+
+// Frame the segments into a single message; nil if the data of a segment
+// exceeds what its length prefix can describe.
+func EncodeSegments(segments ...Segment) transport.Message {
+	framed := make([]wire.Segment, len(segments))
+	for i, s := range segments {
+		framed[i] = wire.Segment{Subsystem: uint8(s.Subsystem), Data: s.Data}
+	}
+	msg, err := wire.EncodeSegments(wire.Current, framed...)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// Split a message produced by EncodeSegments. The segment data aliases msg.
+func DecodeSegments(msg transport.Message) ([]Segment, error) {
+	framed, _, err := wire.DecodeSegments(msg)
+	if err != nil {
+		return nil, ErrMalformedSegment
+	}
+	segments := make([]Segment, len(framed))
+	for i, s := range framed {
+		if Subsystem(s.Subsystem) >= subsystemCount {
+			return nil, ErrMalformedSegment
+		}
+		segments[i] = Segment{Subsystem(s.Subsystem), s.Data}
+	}
+	return segments, nil
+}
+
+// Traffic of one subsystem in one direction. Bytes include the segment
+// headers; Packets counts the messages the subsystem carried while
+// Piggybacked counts those it rode along on.
+type Traffic struct {
+	Bytes       uint64
+	Packets     uint64
+	Piggybacked uint64
+}
+
+// Traffic of one subsystem in both directions
+type SubsystemMetrics struct {
+	In, Out Traffic
+}
+
+// Counts the wire traffic of each subsystem on the connections it is
+// attached to. Messages which do not decode as segments are attributed
+// to SubsystemOther.
+type WireMetrics struct {
+	mutex   sync.Mutex
+	metrics [subsystemCount]SubsystemMetrics
+}
+
+func NewWireMetrics() *WireMetrics {
+	return &WireMetrics{}
+}
+
+// Count every packet conn sends or receives. The egress counter is
+// registered last, so it should be attached after all other egress
+// middleware that changes the payload.
+func (m *WireMetrics) Attach(conn *transport.Conn) {
+	conn.Use(func(p *transport.Packet) (*transport.Packet, error) {
+		m.count(p.Msg, false)
+		return p, nil
+	})
+	conn.UseEgress(func(p *transport.Packet) (*transport.Packet, error) {
+		m.count(p.Msg, true)
+		return p, nil
+	})
+}
+
+func (m *WireMetrics) count(msg transport.Message, out bool) {
+	segments, err := DecodeSegments(msg)
+	if err != nil {
+		segments = []Segment{{SubsystemOther, msg}}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, s := range segments {
+		t := &m.metrics[s.Subsystem].In
+		if out {
+			t = &m.metrics[s.Subsystem].Out
+		}
+		if s.Subsystem == SubsystemOther {
+			t.Bytes += uint64(len(s.Data))
+		} else {
+			t.Bytes += uint64(segmentHeaderSize + len(s.Data))
+		}
+		if i == 0 {
+			t.Packets++
+		} else {
+			t.Piggybacked++
+		}
+	}
+}
+
+// Counters of every subsystem which has seen any traffic.
+func (m *WireMetrics) Metrics() map[Subsystem]SubsystemMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics := make(map[Subsystem]SubsystemMetrics)
+	for s, sm := range m.metrics {
+		if sm != (SubsystemMetrics{}) {
+			metrics[Subsystem(s)] = sm
+		}
+	}
+	return metrics
+}
+
+// Zero all counters.
+func (m *WireMetrics) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.metrics = [subsystemCount]SubsystemMetrics{}
+}
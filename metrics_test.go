@@ -0,0 +1,115 @@
+package gossip
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestSegments(t *testing.T) {
+	segments := []Segment{
+		{SubsystemProbe, []byte("ping")},
+		{SubsystemBroadcast, []byte("update")},
+		{SubsystemKV, []byte{}},
+	}
+	msg := EncodeSegments(segments...)
+	if len(msg) != 3*segmentHeaderSize+10 {
+		t.Fatalf("TestSegments expected %d bytes got %d.", 3*segmentHeaderSize+10, len(msg))
+	}
+	decoded, err := DecodeSegments(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(segments) {
+		t.Fatalf("TestSegments expected %d segments got %d.", len(segments), len(decoded))
+	}
+	for i, s := range decoded {
+		if s.Subsystem != segments[i].Subsystem || !bytes.Equal(s.Data, segments[i].Data) {
+			t.Fatalf("TestSegments expected %v got %v.", segments[i], s)
+		}
+	}
+
+	for _, msg := range []transport.Message{
+		{},
+		{byte(SubsystemProbe), 0},
+		{byte(SubsystemProbe), 0, 5, 'p'},
+		{byte(SubsystemOther), 0, 0},
+		{byte(subsystemCount), 0, 0},
+	} {
+		if _, err := DecodeSegments(msg); err != ErrMalformedSegment {
+			t.Errorf("TestSegments expected %q for %v got %v.", ErrMalformedSegment, msg, err)
+		}
+	}
+}
+
+func TestWireMetrics(t *testing.T) {
+	sent, received := NewWireMetrics(), NewWireMetrics()
+
+	done := make(chan bool, 8)
+	receiver := transport.NewConn()
+	received.Attach(receiver)
+	receiver.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		done <- true
+	})
+	if err := receiver.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Disconnect()
+	port := (<-receiver.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	sender := transport.NewConn()
+	sent.Attach(sender)
+	if err := sender.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Disconnect()
+
+	probe := make([]byte, 20)
+	update := make([]byte, 100)
+	msgs := []transport.Message{
+		EncodeSegments(Segment{SubsystemProbe, probe}),
+		// broadcast piggybacked on a probe
+		EncodeSegments(Segment{SubsystemProbe, probe}, Segment{SubsystemBroadcast, update}),
+		EncodeSegments(Segment{SubsystemAntiEntropy, make([]byte, 300)}),
+		EncodeSegments(Segment{SubsystemKV, make([]byte, 50)}, Segment{SubsystemBroadcast, update}),
+		transport.Message("raw"),
+	}
+	for _, msg := range msgs {
+		if err := sender.SendTo(msg, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range msgs {
+		<-done
+	}
+
+	h := uint64(segmentHeaderSize)
+	expected := map[Subsystem]Traffic{
+		SubsystemOther:       {Bytes: 3, Packets: 1},
+		SubsystemProbe:       {Bytes: 2 * (h + 20), Packets: 2},
+		SubsystemBroadcast:   {Bytes: 2 * (h + 100), Piggybacked: 2},
+		SubsystemAntiEntropy: {Bytes: h + 300, Packets: 1},
+		SubsystemKV:          {Bytes: h + 50, Packets: 1},
+	}
+	out, in := sent.Metrics(), received.Metrics()
+	for s, traffic := range expected {
+		if out[s].Out != traffic || out[s].In != (Traffic{}) {
+			t.Errorf("TestWireMetrics expected %v to send %+v got %+v.", s, traffic, out[s])
+		}
+		if in[s].In != traffic || in[s].Out != (Traffic{}) {
+			t.Errorf("TestWireMetrics expected %v to receive %+v got %+v.", s, traffic, in[s])
+		}
+	}
+	if len(out) != len(expected) {
+		t.Errorf("TestWireMetrics expected %d subsystems got %d.", len(expected), len(out))
+	}
+
+	sent.Reset()
+	if metrics := sent.Metrics(); !reflect.DeepEqual(metrics, map[Subsystem]SubsystemMetrics{}) {
+		t.Fatalf("TestWireMetrics expected no traffic after Reset got %v.", metrics)
+	}
+}
@@ -0,0 +1,64 @@
+package gossip
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+var ErrMulticastUnavailable = errors.New("Multicast probe did not come back")
+
+// Opens the socket which joins the group of a probe
+var listenMulticast = net.ListenMulticastUDP
+
+// Check that multicast works on this host before discovery relies on it:
+// join the group on the interface, nil for the system's choice, send a
+// self-test probe to it from an ephemeral socket and wait up to timeout
+// for the probe to arrive. Connections consume self-tests before their
+// handlers, so members listening on the group are not confused by it.
+// Returns ErrMulticastUnavailable if the probe did not arrive, in which
+// case discovery should warn and fall back to static seeds.
+func ProbeMulticast(group *net.UDPAddr, ifi *net.Interface, timeout time.Duration) (transport.BroadcastProbe, error) {
+	listener, err := listenMulticast("udp4", ifi, group)
+	if err != nil {
+		return transport.BroadcastProbe{}, err
+	}
+	defer listener.Close()
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return transport.BroadcastProbe{}, err
+	}
+	defer sock.Close()
+
+	var b [8]byte
+	rand.Read(b[:])
+	nonce := binary.BigEndian.Uint64(b[:])
+	msg, err := wire.SelfTest{Nonce: nonce}.Encode(wire.Current)
+	if err != nil {
+		return transport.BroadcastProbe{}, err
+	}
+	sent := time.Now()
+	if _, err := sock.WriteToUDP(msg, group); err != nil {
+		return transport.BroadcastProbe{}, err
+	}
+
+	listener.SetReadDeadline(sent.Add(timeout))
+	buff := make([]byte, transport.MaxDatagramSize)
+	for {
+		n, from, err := listener.ReadFromUDP(buff)
+		if err != nil {
+			// the deadline ends the wait
+			return transport.BroadcastProbe{}, ErrMulticastUnavailable
+		}
+		probe, _, err := wire.DecodeSelfTest(buff[:n])
+		if err != nil || probe.Nonce != nonce {
+			continue
+		}
+		return transport.BroadcastProbe{Dst: group, From: from, Interface: transport.InterfaceOf(from.IP), RTT: time.Since(sent)}, nil
+	}
+}
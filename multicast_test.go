@@ -0,0 +1,34 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+var probeGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 77, 2), Port: 47946}
+
+func TestProbeMulticast(t *testing.T) {
+	probe, err := ProbeMulticast(probeGroup, nil, time.Second)
+	if err == ErrMulticastUnavailable {
+		t.Skipf("TestProbeMulticast needs a host which loops multicast back: %v", err)
+	}
+	if err != nil || probe.Dst != probeGroup || probe.From == nil || probe.Interface == nil {
+		t.Fatalf("TestProbeMulticast expected the probe to arrive got %+v (%v).", probe, err)
+	}
+}
+
+func TestProbeMulticastUnjoined(t *testing.T) {
+	listenMulticast = func(network string, ifi *net.Interface, group *net.UDPAddr) (*net.UDPConn, error) {
+		return net.ListenUDP(network, group)
+	}
+	defer func() { listenMulticast = net.ListenMulticastUDP }()
+
+	start := time.Now()
+	if _, err := ProbeMulticast(probeGroup, nil, 100*time.Millisecond); err != ErrMulticastUnavailable {
+		t.Fatalf("TestProbeMulticastUnjoined expected %v got %v.", ErrMulticastUnavailable, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("TestProbeMulticastUnjoined expected to wait for the timeout got %s.", elapsed)
+	}
+}
@@ -0,0 +1,216 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+var (
+	ErrNotJoin     = errors.New("Message is not a join handshake")
+	ErrJoinTimeout = errors.New("No reply to join request")
+)
+
+// Options of a join handshake
+type JoinFlags uint8
+
+const (
+	// Receive state and gossip without becoming a member: observers are
+	// never listed in Members, never probed and never asked to relay.
+	JoinObserver JoinFlags = 1 << iota
+)
+
+// Handshake of a node joining through a Roster
+type JoinRequest struct {
+	Name  string
+	Flags JoinFlags
+}
+
+func EncodeJoin(j JoinRequest) transport.Message {
+	msg, _ := wire.Join{Name: j.Name, Flags: uint8(j.Flags)}.Encode(wire.Current)
+	return msg
+}
+
+func DecodeJoin(msg transport.Message) (JoinRequest, error) {
+	m, _, err := wire.DecodeJoin(msg)
+	if err != nil {
+		return JoinRequest{}, ErrNotJoin
+	}
+	return JoinRequest{Name: m.Name, Flags: JoinFlags(m.Flags)}, nil
+}
+
+// Nodes which joined through this one. Members are answered with the
+// member list and appear in Members; observers get the same list and a
+// copy of everything passed to Mirror, but are kept apart.
+type Roster struct {
+	conn *transport.Conn
+
+	// Called for every accepted join, outside the lock
+	OnJoin func(j JoinRequest, addr *net.UDPAddr)
+
+	mutex     sync.Mutex
+	members   map[string]*net.UDPAddr
+	observers map[string]*net.UDPAddr
+}
+
+// Register a roster with conn which answers join handshakes.
+func NewRoster(conn *transport.Conn) *Roster {
+	r := &Roster{
+		conn:      conn,
+		members:   make(map[string]*net.UDPAddr),
+		observers: make(map[string]*net.UDPAddr),
+	}
+	conn.AddHandler(r.dispatch)
+	return r
+}
+
+// Add a member learned by other means, e.g. the local node.
+func (r *Roster) Add(name string, addr *net.UDPAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.members[name] = addr
+}
+
+func (r *Roster) Remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.members, name)
+	delete(r.observers, name)
+}
+
+// Copy of the members by name, never including observers.
+func (r *Roster) Members() map[string]*net.UDPAddr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return copyMembers(r.members)
+}
+
+func (r *Roster) Observers() map[string]*net.UDPAddr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return copyMembers(r.observers)
+}
+
+// Forward a message to every observer, e.g. each gossip message this
+// node receives or originates.
+func (r *Roster) Mirror(msg transport.Message) error {
+	for _, addr := range r.Observers() {
+		if err := r.conn.SendTo(msg, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Roster) dispatch(conn *transport.Conn, p *transport.Packet) {
+	j, err := DecodeJoin(p.Msg)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	if j.Flags&JoinObserver != 0 {
+		delete(r.members, j.Name)
+		r.observers[j.Name] = p.Addr
+	} else {
+		delete(r.observers, j.Name)
+		r.members[j.Name] = p.Addr
+	}
+	reply := encodeMembers(r.members, conn.MaxPayloadTo(p.Addr), wire.Version(conn.EncodeVersion()))
+	onJoin := r.OnJoin
+	r.mutex.Unlock()
+
+	conn.Reply(p, reply)
+	if onJoin != nil {
+		onJoin(j, p.Addr)
+	}
+}
+
+// Send a join handshake to addr and wait for the member list in reply;
+// member lists from other addresses are ignored. The handler waiting for
+// the reply is removed once it has arrived or the timeout, measured by the
+// clock of the connection, has passed.
+func JoinRoster(conn *transport.Conn, addr *net.UDPAddr, j JoinRequest, timeout time.Duration) (map[string]*net.UDPAddr, error) {
+	replies := make(chan map[string]*net.UDPAddr, 1)
+	remove := conn.AddOnceHandler(func(p *transport.Packet) bool {
+		if !sameAddr(p.Addr, addr) {
+			return false
+		}
+		_, err := decodeMembers(p.Msg)
+		return err == nil
+	}, func(conn *transport.Conn, p *transport.Packet) {
+		members, _ := decodeMembers(p.Msg)
+		replies <- members
+	})
+	defer remove()
+	// the hints precede the join so the member list can fill our datagrams
+	// and use the encodings we decode
+	if err := conn.AdvertiseDatagramSize(addr); err != nil {
+		return nil, err
+	}
+	if err := conn.AdvertiseCapabilities(addr); err != nil {
+		return nil, err
+	}
+	if err := conn.SendTo(EncodeJoin(j), addr); err != nil {
+		return nil, err
+	}
+	select {
+	case members := <-replies:
+		return members, nil
+	case <-conn.Clock().After(timeout):
+		return nil, ErrJoinTimeout
+	}
+}
+
+// Member list as name length, name, IPv4 address and port per member, as
+// many as fit into limit bytes in the order of their names
+func encodeMembers(members map[string]*net.UDPAddr, limit int, v wire.Version) transport.Message {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var m wire.Members
+	size := 2
+	for _, name := range names {
+		entry := wire.Member{Name: name, Port: uint16(members[name].Port)}
+		ip := members[name].IP.To4()
+		if ip == nil || len(name) > 255 || size+entry.Size() > limit {
+			continue
+		}
+		copy(entry.IP[:], ip)
+		m.Members = append(m.Members, entry)
+		size += entry.Size()
+	}
+	msg, _ := m.Encode(v)
+	return msg
+}
+
+func decodeMembers(msg transport.Message) (map[string]*net.UDPAddr, error) {
+	m, _, err := wire.DecodeMembers(msg)
+	if err != nil {
+		return nil, ErrNotJoin
+	}
+	members := make(map[string]*net.UDPAddr, len(m.Members))
+	for _, entry := range m.Members {
+		members[entry.Name] = &net.UDPAddr{
+			IP:   net.IPv4(entry.IP[0], entry.IP[1], entry.IP[2], entry.IP[3]).To4(),
+			Port: int(entry.Port),
+		}
+	}
+	return members, nil
+}
+
+func copyMembers(members map[string]*net.UDPAddr) map[string]*net.UDPAddr {
+	c := make(map[string]*net.UDPAddr, len(members))
+	for name, addr := range members {
+		c[name] = addr
+	}
+	return c
+}
@@ -0,0 +1,120 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestJoinEncoding(t *testing.T) {
+	j := JoinRequest{Name: "dashboard", Flags: JoinObserver}
+	decoded, err := DecodeJoin(EncodeJoin(j))
+	if err != nil || decoded != j {
+		t.Fatalf("TestJoinEncoding expected %+v got %+v (%v).", j, decoded, err)
+	}
+	if _, err := DecodeJoin(transport.Message("hello")); err != ErrNotJoin {
+		t.Fatalf("TestJoinEncoding expected %q got %v.", ErrNotJoin, err)
+	}
+
+	members := map[string]*net.UDPAddr{"a": peerAddr(1), "b": peerAddr(2)}
+	decodedMembers, err := decodeMembers(encodeMembers(members, transport.MessageSize, wire.Current))
+	if err != nil || len(decodedMembers) != 2 || decodedMembers["b"].String() != peerAddr(2).String() {
+		t.Fatalf("TestJoinEncoding expected %v got %v (%v).", members, decodedMembers, err)
+	}
+	if truncated := encodeMembers(members, 2+1+1+6, wire.Current); len(truncated) != 2+1+1+6 {
+		t.Fatalf("TestJoinEncoding expected a single member to fit got %d bytes.", len(truncated))
+	}
+}
+
+func TestObserver(t *testing.T) {
+	seedConn, seedAddr := gossiptest.Listen(t, nil)
+	seed := NewRoster(seedConn)
+	seed.Add("seed", seedAddr)
+
+	memberConn, _ := gossiptest.Listen(t, nil)
+	if _, err := JoinRoster(memberConn, seedAddr, JoinRequest{Name: "member"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	mirrored := gossiptest.NewPacketRecorder(func(p *transport.Packet) bool {
+		_, err := decodeMembers(p.Msg)
+		return err != nil
+	})
+	observerConn, _ := gossiptest.Listen(t, nil)
+	observerConn.AddHandler(mirrored.Handle)
+	view, err := JoinRoster(observerConn, seedAddr, JoinRequest{Name: "dashboard", Flags: JoinObserver}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the observer's view converges on the members
+	if len(view) != 2 || view["seed"] == nil || view["member"] == nil {
+		t.Fatalf("TestObserver expected the observer to see seed and member got %v.", view)
+	}
+	if err := seed.Mirror(transport.Message("gossip")); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := mirrored.WaitFor(nil, time.Second); err != nil || string(p.Msg) != "gossip" {
+		t.Fatalf("TestObserver expected %q got %v (%v).", "gossip", p, err)
+	}
+
+	// while the members never list the observer
+	if members := seed.Members(); len(members) != 2 || members["dashboard"] != nil {
+		t.Fatalf("TestObserver expected only seed and member got %v.", members)
+	}
+	lateConn, _ := gossiptest.Listen(t, nil)
+	view, err = JoinRoster(lateConn, seedAddr, JoinRequest{Name: "late"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view) != 3 || view["dashboard"] != nil {
+		t.Fatalf("TestObserver expected a later member to see three members got %v.", view)
+	}
+	if observers := seed.Observers(); len(observers) != 1 || observers["dashboard"] == nil {
+		t.Fatalf("TestObserver expected the dashboard observer got %v.", observers)
+	}
+}
+
+func TestJoinRosterOtherSender(t *testing.T) {
+	strayConn, _ := gossiptest.Listen(t, nil)
+	seedConn, seedAddr := gossiptest.Listen(t, nil)
+	seedConn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		if _, err := DecodeJoin(p.Msg); err != nil {
+			return
+		}
+		// a member list from another peer arrives first
+		stray := map[string]*net.UDPAddr{"stray": peerAddr(1)}
+		strayConn.SendTo(encodeMembers(stray, transport.MessageSize, wire.Current), p.Addr)
+		time.Sleep(10 * time.Millisecond)
+		conn.Reply(p, encodeMembers(map[string]*net.UDPAddr{"seed": seedAddr}, transport.MessageSize, wire.Current))
+	})
+
+	conn, _ := gossiptest.Listen(t, nil)
+	view, err := JoinRoster(conn, seedAddr, JoinRequest{Name: "member"}, time.Second)
+	if err != nil || len(view) != 1 || view["seed"] == nil {
+		t.Fatalf("TestJoinRosterOtherSender expected the members of the seed got %v (%v).", view, err)
+	}
+}
+
+func TestJoinRosterTimeout(t *testing.T) {
+	_, silentAddr := gossiptest.Listen(t, nil)
+	clock := transport.NewManualClock(gossiptest.Epoch)
+	conn, _ := gossiptest.Listen(t, clock)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := JoinRoster(conn, silentAddr, JoinRequest{Name: "member"}, time.Hour)
+		result <- err
+	}()
+	if err := gossiptest.Eventually(func() bool { return clock.Pending() > 0 }, time.Second); err != nil {
+		t.Fatalf("TestJoinRosterTimeout expected the join to wait on the clock (%v).", err)
+	}
+	clock.Advance(time.Hour)
+	if err := <-result; err != ErrJoinTimeout {
+		t.Fatalf("TestJoinRosterTimeout expected %q got %v.", ErrJoinTimeout, err)
+	}
+}
@@ -0,0 +1,161 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Returned by Join if neither a seed nor a cached peer could be reached
+var ErrUnreachable = errors.New("No seed or cached peer is reachable")
+
+// Persistent storage of the peer cache.
+type PeerStore interface {
+	// Returns the previously saved contents; a store which has never
+	// been saved to may return an error or no data.
+	Load() ([]byte, error)
+
+	// Replace the contents.
+	Save(data []byte) error
+}
+
+// Store the peer cache in the file at the path. Saving writes a temporary
+// file next to it and renames it, so a crash never leaves a partial file.
+type FilePeerStore string
+
+func (path FilePeerStore) Load() ([]byte, error) {
+	return os.ReadFile(string(path))
+}
+
+func (path FilePeerStore) Save(data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(string(path)), filepath.Base(string(path))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), string(path))
+}
+
+// On-disk representation of the cache
+type peerCacheFile struct {
+	Saved time.Time `json:"saved"`
+	Peers []string  `json:"peers"`
+}
+
+// Remembers the addresses of live members across restarts, so that a node
+// can rejoin through them when all of its seeds are down.
+type PeerCache struct {
+	store  PeerStore
+	maxAge time.Duration
+	clock  transport.Clock
+	rnd    *rand.Rand
+}
+
+// Create a cache on top of the store whose contents are ignored once they
+// are older than maxAge; a non-positive maxAge never expires them. Join
+// draws the order and pauses from rnd, e.g. Conn.Rand.
+func NewPeerCache(store PeerStore, maxAge time.Duration, rnd *rand.Rand) *PeerCache {
+	return &PeerCache{store: store, maxAge: maxAge, clock: transport.RealClock, rnd: rnd}
+}
+
+// Replace the source of time used for timestamps, expiry and Run.
+func (c *PeerCache) SetClock(clock transport.Clock) {
+	c.clock = clock
+}
+
+// Write the addresses of the currently live peers.
+func (c *PeerCache) Save(peers []string) error {
+	data, err := json.Marshal(peerCacheFile{Saved: c.clock.Now(), Peers: peers})
+	if err != nil {
+		return err
+	}
+	return c.store.Save(data)
+}
+
+// Returns the cached peers. A missing, corrupt or stale cache is treated
+// as an empty one since the seeds remain available as a fallback.
+func (c *PeerCache) Load() []string {
+	data, err := c.store.Load()
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var file peerCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil
+	}
+	if c.maxAge > 0 && c.clock.Now().Sub(file.Saved) > c.maxAge {
+		return nil
+	}
+
+	peers := make([]string, 0, len(file.Peers))
+	for _, peer := range file.Peers {
+		if peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Save the result of peers every interval until done is closed. Errors of
+// the store are passed to report, which may be nil.
+func (c *PeerCache) Run(interval time.Duration, peers func() []string, report func(error), done <-chan bool) {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := c.Save(peers()); err != nil && report != nil {
+				report(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Try the seeds in order followed by the cached peers in random order
+// until try succeeds, and return the address which was reached. Attempts
+// on cached peers are separated by a random pause of up to jitter so that
+// many nodes restarting at once do not hit the same member together.
+func Join(seeds []string, cache *PeerCache, jitter time.Duration, try func(addr string) error) (string, error) {
+	tried := make(map[string]bool)
+	for _, seed := range seeds {
+		tried[seed] = true
+		if try(seed) == nil {
+			return seed, nil
+		}
+	}
+	if cache == nil {
+		return "", ErrUnreachable
+	}
+
+	peers := cache.Load()
+	cache.rnd.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, peer := range peers {
+		if tried[peer] {
+			continue
+		}
+		tried[peer] = true
+		if jitter > 0 {
+			<-cache.clock.After(time.Duration(cache.rnd.Int63n(int64(jitter))))
+		}
+		if try(peer) == nil {
+			return peer, nil
+		}
+	}
+	return "", ErrUnreachable
+}
@@ -0,0 +1,85 @@
+package gossip
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Simulated cluster in which only some addresses answer
+type reachable map[string]bool
+
+func (r reachable) try(addr string) error {
+	if r[addr] {
+		return nil
+	}
+	return errors.New("timeout")
+}
+
+func TestPeerCacheRejoin(t *testing.T) {
+	path := FilePeerStore(filepath.Join(t.TempDir(), "peers.json"))
+	seeds := []string{"10.0.0.1:7946", "10.0.0.2:7946"}
+
+	// first run: the node learns about live members and caches them
+	cache := NewPeerCache(path, time.Hour, transport.NewRand(1))
+	done := make(chan bool)
+	saved := make(chan bool, 1)
+	go cache.Run(time.Millisecond, func() []string {
+		select {
+		case saved <- true:
+		default:
+		}
+		return []string{"10.0.1.1:7946", "10.0.1.2:7946", "10.0.1.3:7946"}
+	}, func(err error) { t.Errorf("TestPeerCacheRejoin %v", err) }, done)
+	<-saved
+	<-saved
+	close(done)
+
+	// restart with all seeds down
+	cache = NewPeerCache(path, time.Hour, transport.NewRand(1))
+	cluster := reachable{"10.0.1.3:7946": true}
+	addr, err := Join(seeds, cache, time.Millisecond, cluster.try)
+	if err != nil || addr != "10.0.1.3:7946" {
+		t.Fatalf("TestPeerCacheRejoin expected rejoin via cache got %q %v.", addr, err)
+	}
+
+	cluster = reachable{}
+	if _, err := Join(seeds, cache, 0, cluster.try); err != ErrUnreachable {
+		t.Fatalf("TestPeerCacheRejoin expected %q got %v.", ErrUnreachable, err)
+	}
+}
+
+func TestPeerCacheTolerant(t *testing.T) {
+	dir := t.TempDir()
+	missing := NewPeerCache(FilePeerStore(filepath.Join(dir, "missing.json")), 0, transport.NewRand(1))
+	if peers := missing.Load(); len(peers) != 0 {
+		t.Fatalf("TestPeerCacheTolerant expected no peers from missing file got %v.", peers)
+	}
+
+	path := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(path, []byte("{\"peers\": [\"10.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := NewPeerCache(FilePeerStore(path), 0, transport.NewRand(1))
+	if peers := corrupt.Load(); len(peers) != 0 {
+		t.Fatalf("TestPeerCacheTolerant expected no peers from corrupt file got %v.", peers)
+	}
+
+	clock := transport.NewManualClock(time.Date(2011, time.June, 1, 0, 0, 0, 0, time.UTC))
+	stale := NewPeerCache(FilePeerStore(filepath.Join(dir, "stale.json")), time.Hour, transport.NewRand(1))
+	stale.SetClock(clock)
+	if err := stale.Save([]string{"10.0.1.1:7946"}); err != nil {
+		t.Fatal(err)
+	}
+	if peers := stale.Load(); len(peers) != 1 {
+		t.Fatalf("TestPeerCacheTolerant expected fresh peers got %v.", peers)
+	}
+	clock.Advance(2 * time.Hour)
+	if peers := stale.Load(); len(peers) != 0 {
+		t.Fatalf("TestPeerCacheTolerant expected stale peers to be ignored got %v.", peers)
+	}
+}
@@ -0,0 +1,190 @@
+package gossip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Malformed entry in a peers file
+type PeerLineError struct {
+	Path string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *PeerLineError) Error() string {
+	return fmt.Sprintf("%s:%d: %q: %s", e.Path, e.Line, e.Text, e.Err)
+}
+
+func (e *PeerLineError) Unwrap() error {
+	return e.Err
+}
+
+// Parse one host:port entry per line; everything after a # is a comment.
+// Malformed lines are skipped and returned as *PeerLineError.
+func ParsePeers(path string, data []byte) ([]string, []error) {
+	var peers []string
+	var errs []error
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(text)
+		if err == nil && host == "" {
+			err = transport.ErrMalformedAddr
+		}
+		if err == nil {
+			if n, perr := strconv.ParseUint(port, 10, 16); perr != nil || n == 0 {
+				err = transport.ErrInvalidPort
+			}
+		}
+		if err != nil {
+			errs = append(errs, &PeerLineError{path, line, text, err})
+			continue
+		}
+		if !seen[text] {
+			seen[text] = true
+			peers = append(peers, text)
+		}
+	}
+	return peers, errs
+}
+
+// Peer list maintained as a file, e.g. by configuration management, and
+// reloaded when its modification time changes. Entries which disappear
+// from the file can be excluded administratively so that reconnect logic
+// stops retrying them.
+type PeersFile struct {
+	path  string
+	clock transport.Clock
+
+	// Whether peers removed from the file are reported by Excluded
+	ExcludeRemoved bool
+
+	mutex    sync.Mutex
+	peers    []string
+	excluded map[string]bool
+	modified time.Time
+	size     int64
+}
+
+func NewPeersFile(path string) *PeersFile {
+	return &PeersFile{path: path, clock: transport.RealClock, excluded: make(map[string]bool)}
+}
+
+// Replace the source of time used by Run.
+func (f *PeersFile) SetClock(clock transport.Clock) {
+	f.clock = clock
+}
+
+// Current entries, reading the file if it has not been loaded yet.
+func (f *PeersFile) Seeds() ([]string, error) {
+	f.mutex.Lock()
+	loaded := !f.modified.IsZero()
+	f.mutex.Unlock()
+	if !loaded {
+		if _, _, errs := f.Reload(); len(errs) > 0 {
+			if _, ok := errs[0].(*PeerLineError); !ok {
+				return nil, errs[0]
+			}
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.peers...), nil
+}
+
+// Whether the peer was removed from the file while ExcludeRemoved is set;
+// adding it back lifts the exclusion.
+func (f *PeersFile) Excluded(peer string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.excluded[peer]
+}
+
+// Read the file if it changed since the last reload and return the
+// entries which appeared and disappeared. The errors are those of the
+// file system and the malformed lines; neither discards the entries
+// loaded before.
+func (f *PeersFile) Reload() (added, removed []string, errs []error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return nil, nil, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	peers, errs := ParsePeers(f.path, data)
+	f.modified, f.size = info.ModTime(), info.Size()
+
+	current := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		current[peer] = true
+	}
+	previous := make(map[string]bool, len(f.peers))
+	for _, peer := range f.peers {
+		previous[peer] = true
+		if !current[peer] {
+			removed = append(removed, peer)
+			if f.ExcludeRemoved {
+				f.excluded[peer] = true
+			}
+		}
+	}
+	for _, peer := range peers {
+		if !previous[peer] {
+			added = append(added, peer)
+			delete(f.excluded, peer)
+		}
+	}
+	f.peers = peers
+	return added, removed, errs
+}
+
+// Check the file every interval until done is closed, passing changes to
+// changed and errors to report; either may be nil.
+func (f *PeersFile) Run(interval time.Duration, changed func(added, removed []string), report func(error), done <-chan bool) {
+	ticker := f.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			added, removed, errs := f.Reload()
+			if report != nil {
+				for _, err := range errs {
+					report(err)
+				}
+			}
+			if changed != nil && (len(added) > 0 || len(removed) > 0) {
+				changed(added, removed)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package gossip
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestParsePeers(t *testing.T) {
+	data := []byte(`# seeds of the east site
+10.0.0.1:7946
+  10.0.0.2:7946   # rack 2
+node-3.example.com:7946
+[fd00::4]:7946
+10.0.0.1:7946
+
+10.0.0.5
+:7946
+10.0.0.6:http
+10.0.0.7:0
+`)
+	peers, errs := ParsePeers("peers", data)
+	expected := []string{"10.0.0.1:7946", "10.0.0.2:7946", "node-3.example.com:7946", "[fd00::4]:7946"}
+	if !reflect.DeepEqual(peers, expected) {
+		t.Fatalf("TestParsePeers expected %v got %v.", expected, peers)
+	}
+	lines := []int{8, 9, 10, 11}
+	if len(errs) != len(lines) {
+		t.Fatalf("TestParsePeers expected %d errors got %v.", len(lines), errs)
+	}
+	for i, err := range errs {
+		var lineErr *PeerLineError
+		if !errors.As(err, &lineErr) || lineErr.Line != lines[i] {
+			t.Errorf("TestParsePeers expected an error on line %d got %v.", lines[i], err)
+		}
+	}
+}
+
+func TestPeersFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	write := func(content string, age time.Duration) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+	}
+	write("10.0.0.1:7946\n10.0.0.2:7946\n", time.Hour)
+
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	file := NewPeersFile(path)
+	file.SetClock(clock)
+	file.ExcludeRemoved = true
+
+	// reconnect logic retrying every peer of the file which is not excluded
+	var mutex sync.Mutex
+	contacted := make(map[string]int)
+	retry := func() {
+		seeds, err := file.Seeds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, peer := range seeds {
+			if !file.Excluded(peer) {
+				contacted[peer]++
+			}
+		}
+	}
+	retry()
+
+	changes := make(chan [2][]string, 1)
+	reported := make(chan error, 4)
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		file.Run(time.Second, func(added, removed []string) {
+			changes <- [2][]string{added, removed}
+		}, func(err error) {
+			reported <- err
+		}, done)
+		close(stopped)
+	}()
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// configuration management replaces 10.0.0.2 by 10.0.0.3
+	write("10.0.0.1:7946\n10.0.0.3:7946\nbogus\n", 0)
+	clock.Advance(time.Second)
+	change := <-changes
+	if !reflect.DeepEqual(change, [2][]string{{"10.0.0.3:7946"}, {"10.0.0.2:7946"}}) {
+		t.Fatalf("TestPeersFileReload expected 10.0.0.3 added and 10.0.0.2 removed got %v.", change)
+	}
+	var lineErr *PeerLineError
+	if err := <-reported; !errors.As(err, &lineErr) || lineErr.Line != 3 {
+		t.Fatalf("TestPeersFileReload expected the malformed line 3 to be reported got %v.", err)
+	}
+
+	contacted = make(map[string]int)
+	retry()
+	if !reflect.DeepEqual(contacted, map[string]int{"10.0.0.1:7946": 1, "10.0.0.3:7946": 1}) {
+		t.Fatalf("TestPeersFileReload expected the new peer to be contacted and the removed one not got %v.", contacted)
+	}
+	if !file.Excluded("10.0.0.2:7946") {
+		t.Fatalf("TestPeersFileReload expected 10.0.0.2 to be excluded.")
+	}
+
+	// an unchanged file is not reported again
+	clock.Advance(time.Second)
+	select {
+	case change := <-changes:
+		t.Fatalf("TestPeersFileReload expected no change got %v.", change)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(done)
+	<-stopped
+
+	if _, err := NewPeersFile(filepath.Join(t.TempDir(), "missing")).Seeds(); !os.IsNotExist(err) {
+		t.Fatalf("TestPeersFileReload expected a missing file error got %v.", err)
+	}
+}
@@ -0,0 +1,54 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrCanceled = errors.New("Pending send canceled")
+
+// Reliable send or request still awaiting its answer from a peer
+type PendingSend struct {
+	// Idempotency key of a request or id of an acked broadcast
+	ID       uint64
+	Peer     *net.UDPAddr
+	Age      time.Duration
+	Attempts int
+}
+
+// Outcome of a send aborted by CancelPending; it wraps ErrCanceled.
+type CancelError struct {
+	Peer   *net.UDPAddr
+	Reason string
+}
+
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("send to %s canceled: %s", e.Peer, e.Reason)
+}
+
+func (e *CancelError) Unwrap() error {
+	return ErrCanceled
+}
+
+// Component with sends in flight which can be aborted per peer, such as a
+// Requester or an Acker
+type PendingCanceler interface {
+	PendingTo(peer *net.UDPAddr) []PendingSend
+	CancelPending(peer *net.UDPAddr, reason string) int
+}
+
+// Callback for MemberTable.OnGone which aborts the sends to the member in
+// each of the components rather than letting them retry until their
+// deadlines.
+func CancelOnGone(cancelers ...PendingCanceler) func(m Member) {
+	return func(m Member) {
+		if m.Addr == nil {
+			return
+		}
+		for _, c := range cancelers {
+			c.CancelPending(m.Addr, "member "+m.State.String())
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+)
+
+// Wait until the component lists a send to the peer.
+func awaitPending(t *testing.T, c PendingCanceler, peer *net.UDPAddr) PendingSend {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if sends := c.PendingTo(peer); len(sends) > 0 {
+			return sends[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("awaitPending expected a pending send to %v.", peer)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCancelPending(t *testing.T) {
+	conn, _ := gossiptest.Listen(t, nil)
+	_, silent := gossiptest.Listen(t, nil)
+	client := NewRequester(conn, nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.RequestWithRetry([]byte("anyone?"), silent, RetryOptions{
+			Deadline: time.Now().Add(5 * time.Second),
+			Key:      7,
+			Backoff:  func(int) time.Duration { return 5 * time.Millisecond },
+		})
+		errs <- err
+	}()
+	awaitPending(t, client, silent)
+	time.Sleep(20 * time.Millisecond)
+	if s := client.PendingTo(silent)[0]; s.ID != 7 || s.Attempts < 2 || s.Age <= 0 {
+		t.Fatalf("TestCancelPending expected a retried request with key 7 got %+v.", s)
+	}
+	if n := client.CancelPending(silent, "gave up"); n != 1 {
+		t.Fatalf("TestCancelPending expected 1 canceled request got %d.", n)
+	}
+	var cancel *CancelError
+	if err := <-errs; !errors.As(err, &cancel) || !errors.Is(err, ErrCanceled) || cancel.Reason != "gave up" {
+		t.Fatalf("TestCancelPending expected a *CancelError got %v.", err)
+	}
+	if sends := client.PendingTo(silent); len(sends) != 0 {
+		t.Fatalf("TestCancelPending expected nothing pending got %+v.", sends)
+	}
+
+	origin, _ := startAcker(t, nil)
+	_, addr := startAcker(t, nil)
+	results := make(chan AckResult, 1)
+	go func() {
+		result, _ := origin.BroadcastAcked(map[string]*net.UDPAddr{"a": addr, "d": silent}, []byte("config"), 5*time.Second)
+		results <- result
+	}()
+	awaitPending(t, origin, silent)
+	origin.CancelPending(silent, "gave up")
+	expected := AckResult{Confirmed: []string{"a"}, Canceled: []string{"d"}, Reasons: map[string]string{"d": "gave up"}}
+	select {
+	case result := <-results:
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("TestCancelPending expected %+v got %+v.", expected, result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestCancelPending expected the broadcast to return once canceled.")
+	}
+}
+
+func TestCancelOnGone(t *testing.T) {
+	conn, _ := gossiptest.Listen(t, nil)
+	_, silent := gossiptest.Listen(t, nil)
+	client := NewRequester(conn, nil)
+	table := newTestTable(t)
+	table.OnGone = CancelOnGone(client)
+	table.Update(Member{Name: "d", Addr: silent, Incarnation: 1})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Request([]byte("anyone?"), silent, 5*time.Second)
+		errs <- err
+	}()
+	awaitPending(t, client, silent)
+	table.Update(Member{Name: "d", Addr: silent, State: MemberDead, Incarnation: 1})
+
+	var cancel *CancelError
+	if err := <-errs; !errors.As(err, &cancel) || cancel.Reason != "member dead" {
+		t.Fatalf("TestCancelOnGone expected the request to be canceled by the death got %v.", err)
+	}
+}
+
+func TestCancelPendingRace(t *testing.T) {
+	serverConn, server := gossiptest.Listen(t, nil)
+	release := make(chan bool)
+	NewRequester(serverConn, func(req []byte, from *net.UDPAddr) []byte {
+		<-release
+		return req
+	})
+	conn, _ := gossiptest.Listen(t, nil)
+	client := NewRequester(conn, nil)
+	origin, _ := startAcker(t, nil)
+	_, member := startAcker(t, nil)
+
+	for i := 0; i < 50; i++ {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := client.Request([]byte("race"), server, 5*time.Second)
+			errs <- err
+		}()
+		awaitPending(t, client, server)
+		go func() { release <- true }()
+		canceled := client.CancelPending(server, "race")
+		if err := <-errs; (err != nil) != (canceled == 1) {
+			t.Fatalf("TestCancelPendingRace expected one outcome of the request got %v with %d canceled.", err, canceled)
+		}
+
+		results := make(chan AckResult, 1)
+		go func() {
+			result, _ := origin.BroadcastAcked(map[string]*net.UDPAddr{"a": member}, []byte("race"), 5*time.Second)
+			results <- result
+		}()
+		time.Sleep(time.Duration(i%3) * 100 * time.Microsecond)
+		canceled = origin.CancelPending(member, "race")
+		result := <-results
+		if len(result.Confirmed)+len(result.Canceled) != 1 || len(result.Canceled) != canceled {
+			t.Fatalf("TestCancelPendingRace expected one outcome of the broadcast got %+v with %d canceled.", result, canceled)
+		}
+	}
+}
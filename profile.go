@@ -0,0 +1,157 @@
+package gossip
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Tunables of the periodic and per-message work of a node in one value.
+// DefaultProfile and LowPowerProfile are presets to start from; every
+// field can be adjusted afterwards.
+type Profile struct {
+	// Base and max interval of the ChurnBackoff driving the failure
+	// detector, and its window
+	ProbeInterval    time.Duration
+	MaxProbeInterval time.Duration
+	ChurnWindow      time.Duration
+
+	// Interval between gossip rounds and the peers each rumor is relayed
+	// to, see Fanout.Relay
+	GossipInterval time.Duration
+	Fanout         int
+
+	// Interval of the anti-entropy rounds of a Syncer; zero only
+	// synchronizes on demand, see Syncer.SyncNow
+	SyncInterval time.Duration
+
+	// Bounds of NewRetransmit
+	MinRetransmit, MaxRetransmit int
+
+	// SRVSeeds.TTL, and the intervals of PeerCache.Run and PeersFile.Run
+	SeedTTL           time.Duration
+	PeerCacheInterval time.Duration
+	PeersFileInterval time.Duration
+
+	// Template of the connection, see transport.NewConnFromConfig
+	Transport transport.Config
+}
+
+// Settings for nodes on a data center network, where messages are cheap
+// and fast convergence matters.
+func DefaultProfile() Profile {
+	return Profile{
+		ProbeInterval:     time.Second,
+		MaxProbeInterval:  8 * time.Second,
+		ChurnWindow:       10 * time.Second,
+		GossipInterval:    200 * time.Millisecond,
+		Fanout:            3,
+		SyncInterval:      30 * time.Second,
+		MinRetransmit:     DefaultMinRetransmit,
+		MaxRetransmit:     DefaultMaxRetransmit,
+		SeedTTL:           DefaultSeedTTL,
+		PeerCacheInterval: time.Minute,
+		PeersFileInterval: 10 * time.Second,
+	}
+}
+
+// Settings for battery-powered devices, where every packet and every
+// wake-up costs energy. Intervals are stretched by an order of magnitude
+// or more, rumors reach only one peer per round, anti-entropy only runs
+// when asked to (e.g. right after the device woke up) and the connection
+// hands all packets to a single dispatch goroutine. The transport has no
+// periodic work of its own: its goroutines block in reads and channel
+// operations until traffic arrives. Failures are detected and rumors
+// spread correspondingly slower.
+func LowPowerProfile() Profile {
+	return Profile{
+		ProbeInterval:     30 * time.Second,
+		MaxProbeInterval:  5 * time.Minute,
+		ChurnWindow:       5 * time.Minute,
+		GossipInterval:    10 * time.Second,
+		Fanout:            1,
+		SyncInterval:      0,
+		MinRetransmit:     1,
+		MaxRetransmit:     2,
+		SeedTTL:           time.Hour,
+		PeerCacheInterval: time.Hour,
+		PeersFileInterval: 10 * time.Minute,
+		Transport: transport.Config{
+			DispatchShards: 1,
+			SlowHandler:    -1,
+		},
+	}
+}
+
+// Runs anti-entropy rounds periodically and on demand. Requests made by
+// SyncNow while a round is running are coalesced into a single one after
+// it.
+type Syncer struct {
+	sync  func()
+	clock transport.Clock
+	wake  chan bool
+
+	mutex sync.Mutex
+	stats SyncStats
+}
+
+// Rounds run so far, by their trigger
+type SyncStats struct {
+	Periodic uint64
+	OnDemand uint64
+}
+
+// Create a syncer which runs sync for every round, e.g. a full state
+// exchange with a random peer.
+func NewSyncer(sync func()) *Syncer {
+	return &Syncer{sync: sync, clock: transport.RealClock, wake: make(chan bool, 1)}
+}
+
+// Replace the source of time used by Run.
+func (s *Syncer) SetClock(clock transport.Clock) {
+	s.clock = clock
+}
+
+// Ask for a round as soon as possible, without waiting for it.
+func (s *Syncer) SyncNow() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// Run rounds every interval, or only on demand if interval is zero, and
+// whenever SyncNow is called until done is closed.
+func (s *Syncer) Run(interval time.Duration, done <-chan bool) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := s.clock.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-tick:
+			s.round(&s.stats.Periodic)
+		case <-s.wake:
+			s.round(&s.stats.OnDemand)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *Syncer) round(counter *uint64) {
+	s.sync()
+	s.mutex.Lock()
+	*counter++
+	s.mutex.Unlock()
+}
+
+func (s *Syncer) Stats() SyncStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
@@ -0,0 +1,129 @@
+package gossip
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Messages one node sends in an hour of simulated time under the profile:
+// a probe and its ack per probe interval, a rumor to each of the fanout
+// peers per gossip round and a state exchange per periodic sync.
+func simulateHour(p Profile) (messages, syncs int) {
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	probe := clock.NewTicker(p.ProbeInterval)
+	gossip := clock.NewTicker(p.GossipInterval)
+	var sync <-chan time.Time
+	if p.SyncInterval > 0 {
+		sync = clock.NewTicker(p.SyncInterval).C()
+	}
+
+	const step = 100 * time.Millisecond
+	for elapsed := time.Duration(0); elapsed < time.Hour; elapsed += step {
+		clock.Advance(step)
+		select {
+		case <-probe.C():
+			messages += 2
+		default:
+		}
+		select {
+		case <-gossip.C():
+			messages += p.Fanout
+		default:
+		}
+		select {
+		case <-sync:
+			messages += 2
+			syncs++
+		default:
+		}
+	}
+	return messages, syncs
+}
+
+func TestProfileMessagesPerHour(t *testing.T) {
+	normal, normalSyncs := simulateHour(DefaultProfile())
+	low, lowSyncs := simulateHour(LowPowerProfile())
+	t.Logf("messages per hour: default %d, low power %d", normal, low)
+
+	if normal != 3600*2+18000*3+120*2 || normalSyncs != 120 {
+		t.Fatalf("TestProfileMessagesPerHour unexpected default count %d with %d syncs.", normal, normalSyncs)
+	}
+	if low*50 > normal || lowSyncs != 0 {
+		t.Fatalf("TestProfileMessagesPerHour expected the low power profile to send under 2%% of %d messages without syncs got %d with %d syncs.", normal, low, lowSyncs)
+	}
+}
+
+func TestSyncer(t *testing.T) {
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	var rounds int32
+	syncer := NewSyncer(func() { atomic.AddInt32(&rounds, 1) })
+	syncer.SetClock(clock)
+	done := make(chan bool)
+	defer close(done)
+	go syncer.Run(0, done)
+
+	wait := func(s *Syncer, expected SyncStats) {
+		for i := 0; s.Stats() != expected; i++ {
+			if i == 100 {
+				t.Fatalf("TestSyncer expected %+v got %+v.", expected, s.Stats())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	clock.Advance(time.Hour)
+	if clock.Pending() != 0 || atomic.LoadInt32(&rounds) != 0 {
+		t.Fatalf("TestSyncer expected no periodic rounds without an interval.")
+	}
+	syncer.SyncNow()
+	wait(syncer, SyncStats{OnDemand: 1})
+
+	periodic := NewSyncer(func() {})
+	periodic.SetClock(clock)
+	go periodic.Run(time.Minute, done)
+	for i := 0; clock.Pending() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("TestSyncer expected a ticker.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	wait(periodic, SyncStats{Periodic: 1})
+}
+
+func TestLowPowerTransport(t *testing.T) {
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	cfg := LowPowerProfile().Transport
+	cfg.Clock = clock
+	conn, err := transport.NewConnFromConfig(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan bool, 1)
+	conn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		received <- true
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	port := (<-conn.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+
+	// nothing wakes up the idle connection
+	if n := clock.Pending(); n != 0 {
+		t.Fatalf("TestLowPowerTransport expected no timers got %d.", n)
+	}
+
+	if err := conn.SendTo(transport.Message("wake up"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("TestLowPowerTransport expected the packet to be handled.")
+	}
+}
@@ -0,0 +1,39 @@
+package gossip
+
+// Component which tells when it has nothing in flight, such as a
+// transport.Conn, a Requester or an Acker
+type Quiescer interface {
+	Quiesced() <-chan struct{}
+}
+
+// Channel which is closed once all sources are quiesced at once, e.g.
+// before a snapshot or a shutdown of the node they make up. The sources
+// are awaited one after another and checked again together, since work
+// may move from one to another, such as a response from a Requester to
+// its connection; the goroutine doing so runs until they are.
+func Quiesced(sources ...Quiescer) <-chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		defer close(c)
+		for {
+			for _, s := range sources {
+				<-s.Quiesced()
+			}
+			if allQuiesced(sources) {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func allQuiesced(sources []Quiescer) bool {
+	for _, s := range sources {
+		select {
+		case <-s.Quiesced():
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuiesced(t *testing.T) {
+	const requests = 20
+	serverConn, serverAddr := startLossy(t, 3, 0.3)
+	clientConn, _ := startLossy(t, 4, 0.3)
+
+	var mutex sync.Mutex
+	handled := make(map[string]bool)
+	server := NewRequester(serverConn, func(req []byte, from *net.UDPAddr) []byte {
+		mutex.Lock()
+		handled[string(req)] = true
+		mutex.Unlock()
+		return req
+	})
+	client := NewRequester(clientConn, nil)
+
+	responses := make(map[string]bool)
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func(req string) {
+			defer wg.Done()
+			response, err := client.RequestWithRetry([]byte(req), serverAddr, RetryOptions{
+				Deadline: time.Now().Add(5 * time.Second),
+				Backoff:  func(int) time.Duration { return 20 * time.Millisecond },
+			})
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil && string(response) == req {
+				responses[req] = true
+			}
+		}(fmt.Sprintf("put %d", i))
+	}
+
+	// every request is under way once the server has seen it
+	for i := 0; ; i++ {
+		mutex.Lock()
+		n := len(handled)
+		mutex.Unlock()
+		if n == requests {
+			break
+		}
+		if i == 500 {
+			t.Fatalf("TestQuiesced expected %d requests at the server got %d.", requests, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-Quiesced(client, clientConn, server, serverConn):
+	case <-time.After(10 * time.Second):
+		t.Fatalf("TestQuiesced expected the nodes to quiesce.")
+	}
+	if pending := client.Pending(); pending > 0 || serverConn.Unsent() > 0 || clientConn.Unsent() > 0 {
+		t.Fatalf("TestQuiesced expected nothing in flight got %d requests and %d, %d packets.", pending, serverConn.Unsent(), clientConn.Unsent())
+	}
+
+	// the requests have returned and only report their outcome
+	wg.Wait()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(responses) != requests {
+		t.Fatalf("TestQuiesced expected every response to have arrived got %d of %d.", len(responses), requests)
+	}
+}
@@ -0,0 +1,119 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Thirty nodes join through SRV seeds and a shared peer cache while a
+// quarter of them is down, then spread rumors with a weighted fanout.
+// Every random choice is drawn from one generator seeded with seed.
+func replayScenario(t *testing.T, seed int64) []string {
+	const nodes = 30
+	rnd := transport.NewRand(seed)
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	store := FilePeerStore(filepath.Join(t.TempDir(), "peers.json"))
+	all := make([]string, nodes)
+	live := make(reachable)
+	for i := range all {
+		all[i] = peerAddr(i).String()
+		live[all[i]] = i%4 != 0
+	}
+	if err := NewPeerCache(store, 0, rnd).Save(all); err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeSRV{}
+	srv.set([]*net.SRV{
+		{Target: "10.0.0.0.", Port: 7946, Priority: 1, Weight: 10},
+		{Target: "10.0.0.4.", Port: 7946, Priority: 1, Weight: 10},
+		{Target: "10.0.0.8.", Port: 7946, Priority: 1, Weight: 5},
+		{Target: "10.0.0.12.", Port: 7946, Priority: 2, Weight: 1},
+	}, nil)
+
+	var trace []string
+	for i := 0; i < nodes; i++ {
+		seeds, err := NewSRVSeeds("_gossip._udp.example.com", srv, rnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds.SetClock(clock)
+		cache := NewPeerCache(store, 0, rnd)
+		cache.SetClock(clock)
+
+		// every seed is down, so joins fall back to the shuffled cache
+		JoinFrom(seeds, cache, 0, func(addr string) error {
+			trace = append(trace, fmt.Sprintf("join %d try %s", i, addr))
+			return live.try(addr)
+		})
+		clock.Advance(time.Second)
+	}
+
+	fanout := NewFanout(0.3, rnd)
+	peers := make([][]transport.PeerStats, nodes)
+	for i := range peers {
+		for j := 0; j < nodes; j++ {
+			if j != i {
+				peers[i] = append(peers[i], transport.PeerStats{Addr: peerAddr(j), PacketsIn: uint64(j % 5), PacketsOut: uint64(i % 3)})
+			}
+		}
+	}
+	for id := uint64(0); id < 5; id++ {
+		rumors := make([]*Rumors, nodes)
+		for i := range rumors {
+			rumors[i] = NewRumors()
+		}
+		origin := rnd.Intn(nodes)
+		rumors[origin].Receive(id, nil, peerAddr(origin))
+		queue := []rumorCopy{}
+		for _, s := range fanout.Relay(peers[origin], 3, nil, peerAddr(origin)) {
+			queue = append(queue, rumorCopy{int(s.Addr.IP[15]), origin, id})
+		}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			trace = append(trace, fmt.Sprintf("rumor %d %d->%d", c.id, c.from, c.to))
+			if !rumors[c.to].Receive(c.id, peerAddr(c.from), peerAddr(origin)) {
+				continue
+			}
+			for _, s := range fanout.Relay(peers[c.to], 3, peerAddr(c.from), peerAddr(origin)) {
+				queue = append(queue, rumorCopy{int(s.Addr.IP[15]), c.to, c.id})
+			}
+		}
+	}
+	return trace
+}
+
+func TestReplayFromSeed(t *testing.T) {
+	first := replayScenario(t, 42)
+	second := replayScenario(t, 42)
+	if len(first) == 0 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("TestReplayFromSeed expected identical traces for one seed, got %d and %d events.", len(first), len(second))
+	}
+	if other := replayScenario(t, 43); reflect.DeepEqual(first, other) {
+		t.Fatalf("TestReplayFromSeed expected the traces of different seeds to diverge.")
+	}
+}
+
+// First ids of the protocols on a connection seeded with seed
+func replayIDs(seed int64) [3]uint64 {
+	conn := transport.NewConn()
+	conn.SetSeed(seed)
+	return [3]uint64{NewRequester(conn, nil).next, NewAcker(conn, nil).next, NewTracer(conn, "node", nil).next}
+}
+
+func TestReplayIDs(t *testing.T) {
+	first := replayIDs(42)
+	if second := replayIDs(42); first != second {
+		t.Fatalf("TestReplayIDs expected the ids %v for one seed got %v.", first, second)
+	}
+	if other := replayIDs(43); first == other {
+		t.Fatalf("TestReplayIDs expected the ids of different seeds to diverge.")
+	}
+}
@@ -0,0 +1,553 @@
+package gossip
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+const (
+	// Time a response is kept to answer retried copies of its request
+	// unless changed by SetResponseTTL
+	DefaultResponseTTL = 30 * time.Second
+
+	// Deadline of a request given none
+	DefaultRequestTimeout = 5 * time.Second
+
+	// Wait for the response to the first attempt of a retried request,
+	// doubled for every further one
+	DefaultRequestBackoff = 50 * time.Millisecond
+)
+
+var (
+	ErrRequestTimeout  = errors.New("No response before the request deadline")
+	ErrRequestPayload  = errors.New("Request payload too large")
+	ErrTooManyRequests = errors.New("Limit of pending requests reached")
+)
+
+// Answers a request; the returned response is sent back to the requester.
+type RequestHandler func(req []byte, from *net.UDPAddr) []byte
+
+// Answers a request like a RequestHandler and learns its trace id, zero if
+// untraced, e.g. to pass it on in RetryOptions when the handler relays the
+// request.
+type TracedRequestHandler func(req []byte, from *net.UDPAddr, id TraceID) []byte
+
+// Retry policy of RequestWithRetry
+type RetryOptions struct {
+	// Time by which a response must have arrived; zero allows
+	// DefaultRequestTimeout from now
+	Deadline time.Time
+
+	// Attempts at most, zero for as many as fit before the deadline
+	MaxAttempts int
+
+	// Wait for the response to the given attempt, counted from zero,
+	// before the next one is sent; nil doubles DefaultRequestBackoff
+	Backoff func(attempt int) time.Duration
+
+	// Identifies the request to the server across calls, e.g. to retry at
+	// a higher level after ErrRequestTimeout; zero picks a new key
+	Key uint64
+
+	// Trace id carried by every attempt and copied into the response;
+	// zero picks a new one if the requester traces, see SetTracing, and
+	// sends none otherwise
+	Trace TraceID
+}
+
+// Backoff which starts at initial and doubles per attempt up to max.
+func ExponentialBackoff(initial, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		wait := initial
+		for i := 0; i < attempt && wait < max; i++ {
+			wait *= 2
+		}
+		if wait > max {
+			wait = max
+		}
+		return wait
+	}
+}
+
+// Request and response exchange over a connection. Requests carry an
+// idempotency key besides the correlation id which the response echoes,
+// and the server side remembers the response under the key of the
+// requester for a TTL, so that the handler runs once however often a
+// request is retried or duplicated on the wire. Each request awaits its
+// response with a once handler on the connection.
+type Requester struct {
+	conn    *transport.Conn
+	clock   transport.Clock
+	handler TracedRequestHandler
+
+	// Called with the trace id of every traced request and response this
+	// requester sends or receives
+	OnTrace TraceHook
+
+	// Requests awaiting a response and requests being handled
+	inflight transport.Outstanding
+
+	mutex   sync.Mutex
+	tracing bool
+	// requests awaiting a response and their limit, zero if unlimited
+	waiting    int
+	maxWaiting int
+	// destinations of the requests awaiting a response; see MovePeer
+	routes map[*requestRoute]bool
+	next   uint64
+	// routes of requests which returned, kept to be reused with their
+	// channel and timers, and the times the clock was replaced, which
+	// outdates the routes created before
+	idle   []*requestRoute
+	clocks int
+	// responses by requester and key
+	responses *idCache
+	ttl       time.Duration
+}
+
+// Destination of a request which follows the peer when it moves
+type requestRoute struct {
+	requester *Requester
+	addr      *net.UDPAddr
+	key       uint64
+	started   time.Time
+	attempts  int
+
+	// correlation id carried by every attempt, zero once the request
+	// returned, and the once handler which takes the response to it
+	id       atomic.Uint64
+	response *transport.OnceHandler
+
+	// takes the first of the response and a cancellation
+	outcome chan requestOutcome
+
+	// deadline of the request and wait for the response to an attempt,
+	// created by clock, which was set after the clock of the requester
+	// had been replaced as many times
+	clock          transport.Clock
+	clocks         int
+	expired, retry transport.Timer
+}
+
+type requestOutcome struct {
+	response []byte
+	err      error
+}
+
+// Response remembered for a key; ready once the handler returned
+type cachedResponse struct {
+	ready    bool
+	response []byte
+	expires  time.Time
+}
+
+// Backoff of RetryOptions given none
+var defaultBackoff = ExponentialBackoff(DefaultRequestBackoff, DefaultRequestTimeout)
+
+// Backoff which waits for the deadline after every attempt
+func untilDeadline(attempt int) time.Duration {
+	return math.MaxInt64
+}
+
+// Register a requester with conn. Incoming requests are answered by
+// handler; a nil handler ignores them so that this node only sends
+// requests.
+func NewRequester(conn *transport.Conn, handler RequestHandler) *Requester {
+	if handler == nil {
+		return NewTracedRequester(conn, nil)
+	}
+	return NewTracedRequester(conn, func(req []byte, from *net.UDPAddr, id TraceID) []byte {
+		return handler(req, from)
+	})
+}
+
+// Register a requester like NewRequester whose handler learns the trace
+// id of each request.
+func NewTracedRequester(conn *transport.Conn, handler TracedRequestHandler) *Requester {
+	r := &Requester{
+		conn:      conn,
+		clock:     transport.RealClock,
+		handler:   handler,
+		next:      conn.Rand().Uint64(),
+		routes:    make(map[*requestRoute]bool),
+		responses: newIDCache(DefaultCacheLimit),
+		ttl:       DefaultResponseTTL,
+	}
+	conn.AddHandler(r.dispatch)
+	return r
+}
+
+// Replace the source of time used for deadlines, backoff and the TTL.
+func (r *Requester) SetClock(clock transport.Clock) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.clock = clock
+	r.idle = nil
+	r.clocks++
+}
+
+// Keep responses for ttl to answer retries; it should exceed the longest
+// deadline requesters use.
+func (r *Requester) SetResponseTTL(ttl time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ttl = ttl
+}
+
+// Limit the responses remembered to answer retries.
+func (r *Requester) SetCacheLimit(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.responses.setLimit(n)
+}
+
+// Limit the requests awaiting a response at once, zero for unlimited (the
+// default); requests beyond it fail with ErrTooManyRequests.
+func (r *Requester) SetPendingLimit(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxWaiting = n
+}
+
+// Requests awaiting a response right now
+func (r *Requester) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.waiting
+}
+
+// Follow a peer which moved to another address: the remaining attempts of
+// requests to it go to the new address, and the responses it was sent
+// before still answer its retries.
+func (r *Requester) MovePeer(from, to *net.UDPAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for route := range r.routes {
+		if sameAddr(route.addr, from) {
+			route.addr = to
+		}
+	}
+	r.responses.rekey(addrKey(from), addrKey(to))
+}
+
+// Requests awaiting a response from the peer, oldest first
+func (r *Requester) PendingTo(peer *net.UDPAddr) []PendingSend {
+	now := r.clock.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var sends []PendingSend
+	for route := range r.routes {
+		// a canceled or answered request is about to return
+		if sameAddr(route.addr, peer) && len(route.outcome) == 0 {
+			sends = append(sends, PendingSend{route.key, route.addr, now.Sub(route.started), route.attempts})
+		}
+	}
+	sort.Slice(sends, func(i, j int) bool { return sends[i].Age > sends[j].Age })
+	return sends
+}
+
+// Abort the requests awaiting a response from the peer, which then fail
+// with a *CancelError carrying the reason, and return how many there
+// were. A response which arrived first still wins.
+func (r *Requester) CancelPending(peer *net.UDPAddr, reason string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for route := range r.routes {
+		if !sameAddr(route.addr, peer) {
+			continue
+		}
+		select {
+		case route.outcome <- requestOutcome{err: &CancelError{route.addr, reason}}:
+			n++
+		default:
+		}
+	}
+	return n
+}
+
+// Give every request sent without a trace id a new one.
+func (r *Requester) SetTracing(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tracing = enabled
+}
+
+func (r *Requester) isTracing() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.tracing
+}
+
+func (r *Requester) CacheStats() CacheStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.responses.stats()
+}
+
+// Send msg to addr once and wait for the response until the timeout.
+func (r *Requester) Request(msg []byte, addr *net.UDPAddr, timeout time.Duration) ([]byte, error) {
+	return r.RequestWithRetry(msg, addr, RetryOptions{
+		Deadline:    r.clock.Now().Add(timeout),
+		MaxAttempts: 1,
+		Backoff:     untilDeadline,
+	})
+}
+
+// Send msg to addr until a response arrives, waiting according to the
+// backoff between attempts, and give up with ErrRequestTimeout at the
+// deadline or once the last attempt went unanswered, or with a
+// *CancelError if CancelPending aborted it. Only idempotent
+// requests should be retried by a requester whose server keeps no cache,
+// but a Requester on the other end runs its handler once per key.
+func (r *Requester) RequestWithRetry(msg []byte, addr *net.UDPAddr, opts RetryOptions) ([]byte, error) {
+	trace := opts.Trace
+	overhead := wire.RequestHeaderSize
+	if trace != 0 || r.isTracing() {
+		overhead += TraceIDOverhead
+	}
+	if len(msg)+overhead > r.conn.MaxPayload() {
+		return nil, ErrRequestPayload
+	}
+
+	key := opts.Key
+	for key == 0 {
+		key = r.conn.Rand().Uint64()
+	}
+
+	r.mutex.Lock()
+	if r.maxWaiting > 0 && r.waiting >= r.maxWaiting {
+		r.mutex.Unlock()
+		return nil, ErrTooManyRequests
+	}
+	r.waiting++
+	route := r.route()
+	route.addr, route.key, route.started = addr, key, r.clock.Now()
+	r.next++
+	id := r.next
+	route.id.Store(id)
+	r.routes[route] = true
+	if trace == 0 && r.tracing {
+		trace = NewTraceID(r.conn.Rand())
+	}
+	r.mutex.Unlock()
+	r.inflight.Add(1)
+	defer r.inflight.Done()
+	deadline := opts.Deadline
+	if deadline.IsZero() {
+		deadline = r.clock.Now().Add(DefaultRequestTimeout)
+	}
+	backoff := opts.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+	// every attempt carries the id, so the first response to any of them
+	// completes the request
+	route.response.Arm()
+	defer func() {
+		route.response.Disarm()
+		r.mutex.Lock()
+		r.waiting--
+		delete(r.routes, route)
+		r.release(route)
+		r.mutex.Unlock()
+	}()
+
+	expired := route.start(&route.expired, deadline.Sub(r.clock.Now()))
+	for attempt := 0; opts.MaxAttempts <= 0 || attempt < opts.MaxAttempts; attempt++ {
+		r.mutex.Lock()
+		route.attempts++
+		addr := route.addr
+		r.mutex.Unlock()
+
+		v := wire.Version(r.conn.EncodeVersion())
+		req, err := wire.Request{ID: id, Key: key, Payload: msg}.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		if req, err = withTraceID(req, trace, v); err != nil {
+			return nil, err
+		}
+		if err := r.conn.SendTo(req, addr); err != nil {
+			return nil, err
+		}
+		r.trace(trace, TraceRequestSent, addr)
+
+		// no timer for a wait which outlasts the deadline
+		var retry <-chan time.Time
+		if wait := backoff(attempt); wait < deadline.Sub(r.clock.Now()) {
+			retry = route.start(&route.retry, wait)
+		}
+		select {
+		case o := <-route.outcome:
+			return o.response, o.err
+		case <-expired:
+			return nil, ErrRequestTimeout
+		case <-retry:
+		}
+	}
+	return nil, ErrRequestTimeout
+}
+
+// Route for a new request, reused from one which returned if possible;
+// must hold the mutex.
+func (r *Requester) route() *requestRoute {
+	if n := len(r.idle); n > 0 {
+		route := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		return route
+	}
+	route := &requestRoute{requester: r, outcome: make(chan requestOutcome, 1), clock: r.clock, clocks: r.clocks}
+	route.response = r.conn.NewOnceHandler(route.isResponse, route.deliver)
+	return route
+}
+
+// Keep the route of a request which returned for the next one; must hold
+// the mutex, so that no response or cancellation is delivered to it
+// anymore.
+func (r *Requester) release(route *requestRoute) {
+	route.stop()
+	select {
+	case <-route.outcome:
+	default:
+	}
+	route.id.Store(0)
+	route.addr, route.key, route.attempts = nil, 0, 0
+	if route.clocks == r.clocks {
+		r.idle = append(r.idle, route)
+	}
+}
+
+// Start the timer of the route, creating it on first use, to fire once d
+// has elapsed.
+func (route *requestRoute) start(t *transport.Timer, d time.Duration) <-chan time.Time {
+	if *t == nil {
+		*t = route.clock.NewTimer(d)
+	} else {
+		(*t).Reset(d)
+	}
+	return (*t).C()
+}
+
+// Whether the packet is the response to the request of the route.
+func (route *requestRoute) isResponse(p *transport.Packet) bool {
+	msg, _ := SplitTraceID(p.Msg)
+	m, _, err := wire.DecodeResponse(msg)
+	return err == nil && m.ID == route.id.Load()
+}
+
+// Hand the response to the request unless it returned in the meantime.
+func (route *requestRoute) deliver(conn *transport.Conn, p *transport.Packet) {
+	msg, trace := SplitTraceID(p.Msg)
+	m, _, _ := wire.DecodeResponse(msg)
+	r := route.requester
+	r.mutex.Lock()
+	current := m.ID == route.id.Load()
+	if current {
+		select {
+		case route.outcome <- requestOutcome{response: append([]byte(nil), m.Payload...)}:
+		default:
+			// a cancellation came first
+		}
+	}
+	r.mutex.Unlock()
+	if current {
+		r.trace(trace, TraceResponseReceived, p.Addr)
+	}
+}
+
+func (route *requestRoute) stop() {
+	if route.expired != nil {
+		route.expired.Stop()
+	}
+	if route.retry != nil {
+		route.retry.Stop()
+	}
+}
+
+// Answer an incoming request; responses are taken by the once handler of
+// their request.
+func (r *Requester) dispatch(conn *transport.Conn, p *transport.Packet) {
+	msg, trace := SplitTraceID(p.Msg)
+	m, _, err := wire.DecodeRequest(msg)
+	if err != nil || r.handler == nil {
+		return
+	}
+	// done once the response has been queued on conn
+	r.inflight.Add(1)
+	defer r.inflight.Done()
+	r.trace(trace, TraceRequestReceived, p.Addr)
+	key := cacheKey{addrKey(p.Addr), m.Key}
+	now := r.clock.Now()
+
+	r.mutex.Lock()
+	cached, ok := r.lookup(key, now)
+	if !ok {
+		cached = new(cachedResponse)
+		r.responses.add(key, cached)
+	}
+	ready, response := cached.ready, cached.response
+	r.mutex.Unlock()
+
+	if ok {
+		// unless still being handled; the requester retries
+		if ready {
+			r.respond(conn, p, m.ID, trace, response)
+		}
+		return
+	}
+
+	response = r.handler(m.Payload, p.Addr, trace)
+	r.mutex.Lock()
+	cached.ready, cached.response, cached.expires = true, response, r.clock.Now().Add(r.ttl)
+	r.mutex.Unlock()
+	r.respond(conn, p, m.ID, trace, response)
+}
+
+// Channel which is closed once no request is awaiting a response and no
+// handler is running; see Quiesced to wait for the connection as well.
+func (r *Requester) Quiesced() <-chan struct{} {
+	return r.inflight.Quiesced()
+}
+
+// Cached response of the key unless it expired; must hold the mutex.
+func (r *Requester) lookup(key cacheKey, now time.Time) (*cachedResponse, bool) {
+	v, ok := r.responses.get(key)
+	if !ok {
+		return nil, false
+	}
+	cached := v.(*cachedResponse)
+	if cached.ready && now.After(cached.expires) {
+		r.responses.remove(key)
+		return nil, false
+	}
+	return cached, true
+}
+
+func (r *Requester) respond(conn *transport.Conn, p *transport.Packet, id uint64, trace TraceID, response []byte) {
+	v := wire.Version(conn.EncodeVersion())
+	msg, err := (wire.Response{ID: id, Payload: response}).Encode(v)
+	if err != nil {
+		return
+	}
+	if msg, err = withTraceID(msg, trace, v); err != nil {
+		return
+	}
+	if conn.Reply(p, msg) == nil {
+		r.trace(trace, TraceResponseSent, p.Addr)
+	}
+}
+
+func (r *Requester) trace(id TraceID, event TraceEvent, peer *net.UDPAddr) {
+	if id != 0 && r.OnTrace != nil {
+		r.OnTrace(id, event, peer)
+	}
+}
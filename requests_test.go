@@ -0,0 +1,193 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Connection which drops the given fraction of incoming packets
+func startLossy(t *testing.T, seed int64, loss float64) (*transport.Conn, *net.UDPAddr) {
+	conn := transport.NewConn()
+	conn.SetSeed(seed)
+	conn.UseShaper(transport.NewShaper(transport.Shaping{Loss: loss}))
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	port := (<-conn.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+func TestRequestWithRetry(t *testing.T) {
+	serverConn, serverAddr := startLossy(t, 1, 0.3)
+	clientConn, _ := startLossy(t, 2, 0.3)
+
+	var mutex sync.Mutex
+	executions := make(map[string]int)
+	NewRequester(serverConn, func(req []byte, from *net.UDPAddr) []byte {
+		mutex.Lock()
+		executions[string(req)]++
+		mutex.Unlock()
+		return append([]byte("re: "), req...)
+	})
+	var received int32
+	serverConn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		if _, _, err := wire.DecodeRequest(p.Msg); err == nil {
+			atomic.AddInt32(&received, 1)
+		}
+	})
+	client := NewRequester(clientConn, nil)
+
+	const requests = 40
+	for i := 0; i < requests; i++ {
+		req := fmt.Sprintf("query %d", i)
+		response, err := client.RequestWithRetry([]byte(req), serverAddr, RetryOptions{
+			Deadline: time.Now().Add(5 * time.Second),
+			Backoff:  func(int) time.Duration { return 20 * time.Millisecond },
+		})
+		if err != nil || string(response) != "re: "+req {
+			t.Fatalf("TestRequestWithRetry expected a response to %q got %q (%v).", req, response, err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i := 0; i < requests; i++ {
+		if n := executions[fmt.Sprintf("query %d", i)]; n != 1 {
+			t.Fatalf("TestRequestWithRetry expected request %d to be handled once got %d.", i, n)
+		}
+	}
+	if n := atomic.LoadInt32(&received); n <= requests {
+		t.Fatalf("TestRequestWithRetry expected retried requests to reach the server got %d.", n)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	conn, _ := gossiptest.Listen(t, nil)
+	_, silent := gossiptest.Listen(t, nil)
+	client := NewRequester(conn, nil)
+
+	start := time.Now()
+	_, err := client.RequestWithRetry([]byte("anyone?"), silent, RetryOptions{
+		Deadline:    time.Now().Add(time.Second),
+		MaxAttempts: 3,
+		Backoff:     ExponentialBackoff(10*time.Millisecond, time.Second),
+	})
+	if err != ErrRequestTimeout || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("TestRequestTimeout expected to give up after 3 attempts got %v after %s.", err, time.Since(start))
+	}
+
+	if _, err := client.Request([]byte("anyone?"), silent, 20*time.Millisecond); err != ErrRequestTimeout {
+		t.Fatalf("TestRequestTimeout expected %v got %v.", ErrRequestTimeout, err)
+	}
+}
+
+func TestResponseTTL(t *testing.T) {
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	serverConn := transport.NewConn()
+	var executions int32
+	server := NewRequester(serverConn, func(req []byte, from *net.UDPAddr) []byte {
+		return []byte(fmt.Sprint(atomic.AddInt32(&executions, 1)))
+	})
+	server.SetClock(clock)
+	server.SetResponseTTL(time.Minute)
+	if err := serverConn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Disconnect()
+	port := (<-serverConn.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	clientConn, _ := gossiptest.Listen(t, nil)
+	client := NewRequester(clientConn, nil)
+
+	opts := RetryOptions{Key: 42, MaxAttempts: 1, Deadline: time.Now().Add(time.Second), Backoff: func(int) time.Duration { return time.Second }}
+	for _, expected := range []string{"1", "1"} {
+		if response, err := client.RequestWithRetry(nil, serverAddr, opts); err != nil || string(response) != expected {
+			t.Fatalf("TestResponseTTL expected response %s got %q (%v).", expected, response, err)
+		}
+	}
+	clock.Advance(2 * time.Minute)
+	if response, err := client.RequestWithRetry(nil, serverAddr, opts); err != nil || string(response) != "2" {
+		t.Fatalf("TestResponseTTL expected the handler to run again after the TTL got %q (%v).", response, err)
+	}
+}
+
+func TestRequestPendingLimit(t *testing.T) {
+	g := gossiptest.NewGroup(t, 2)
+	release := make(chan bool)
+	NewRequester(g.Conns[1], func(req []byte, from *net.UDPAddr) []byte {
+		if string(req) == "slow" {
+			<-release
+		}
+		return req
+	})
+	client := NewRequester(g.Conns[0], nil)
+	client.SetPendingLimit(2)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.Request([]byte("slow"), g.Addrs[1], 5*time.Second)
+			errs <- err
+		}()
+	}
+	for i := 0; client.Pending() < 2; i++ {
+		if i == 100 {
+			t.Fatalf("TestRequestPendingLimit expected 2 pending requests got %d.", client.Pending())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.Request([]byte("fast"), g.Addrs[1], time.Second); err != ErrTooManyRequests {
+		t.Fatalf("TestRequestPendingLimit expected ErrTooManyRequests got %v.", err)
+	}
+
+	// the pending requests are unaffected and make room once answered
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("TestRequestPendingLimit expected the pending requests to complete got %v.", err)
+		}
+	}
+	if response, err := client.Request([]byte("fast"), g.Addrs[1], time.Second); err != nil || string(response) != "fast" || client.Pending() != 0 {
+		t.Fatalf("TestRequestPendingLimit expected room for a new request got %q (%v) with %d pending.", response, err, client.Pending())
+	}
+}
+
+// The route of a request which timed out is reused by the next one, whose
+// once handler must not take the late response to the first.
+func TestRequestLateResponse(t *testing.T) {
+	g := gossiptest.NewGroup(t, 2)
+	answered := make(chan bool)
+	NewRequester(g.Conns[1], func(req []byte, from *net.UDPAddr) []byte {
+		switch string(req) {
+		case "slow":
+			time.Sleep(100 * time.Millisecond)
+			close(answered)
+		case "next":
+			// answered after the late response arrived
+			<-answered
+			time.Sleep(20 * time.Millisecond)
+		}
+		return req
+	})
+	client := NewRequester(g.Conns[0], nil)
+
+	if _, err := client.Request([]byte("slow"), g.Addrs[1], 20*time.Millisecond); err != ErrRequestTimeout {
+		t.Fatalf("TestRequestLateResponse expected %v got %v.", ErrRequestTimeout, err)
+	}
+	if response, err := client.Request([]byte("next"), g.Addrs[1], time.Second); err != nil || string(response) != "next" {
+		t.Fatalf("TestRequestLateResponse expected %q got %q (%v).", "next", response, err)
+	}
+	if n := len(g.Conns[0].Stats().Handlers); n != 1 {
+		t.Fatalf("TestRequestLateResponse expected only the requester's handler left got %d.", n)
+	}
+}
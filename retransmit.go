@@ -0,0 +1,137 @@
+package gossip
+
+import (
+	"math"
+	"sync"
+)
+
+// Defaults of NewRetransmit
+const (
+	DefaultMinRetransmit = 1
+	DefaultMaxRetransmit = 5
+
+	// Probability that a message is lost on every one of its transmissions
+	// which the adapted count aims for
+	DefaultResidualLoss = 1e-3
+
+	// Weight of a single delivery outcome in the per-peer loss estimate
+	retransmitSmoothing = 0.01
+
+	// Outcomes between two adjustments of the effective count
+	retransmitInterval = 50
+)
+
+// Adapts how often a user broadcast is transmitted to the loss observed
+// on the way to each peer. The loss estimates are moving averages over
+// hundreds of outcomes and the effective count only moves one step per
+// interval, so it settles instead of oscillating.
+type Retransmit struct {
+	min, max int
+	residual float64
+
+	mutex    sync.Mutex
+	count    int
+	loss     map[string]float64
+	observed int
+}
+
+// Snapshot of the adaptation for metrics
+type RetransmitStats struct {
+	// Transmissions per message currently used
+	Count int
+	// Mean estimated loss over all known peers
+	Loss float64
+}
+
+// Create a controller bounded by [min, max] transmissions per message.
+// It starts at max until enough outcomes have been observed.
+func NewRetransmit(min, max int) *Retransmit {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &Retransmit{
+		min:      min,
+		max:      max,
+		residual: DefaultResidualLoss,
+		count:    max,
+		loss:     make(map[string]float64),
+	}
+}
+
+// Record whether a transmission to peer was acknowledged.
+func (r *Retransmit) Observe(peer string, delivered bool) {
+	outcome := 0.0
+	if !delivered {
+		outcome = 1
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	loss, ok := r.loss[peer]
+	if !ok {
+		loss = outcome
+	}
+	r.loss[peer] = loss + retransmitSmoothing*(outcome-loss)
+
+	if r.observed++; r.observed >= retransmitInterval {
+		r.observed = 0
+		if target := r.target(); target > r.count {
+			r.count++
+		} else if target < r.count {
+			r.count--
+		}
+	}
+}
+
+// Forget the loss estimate of a peer which left.
+func (r *Retransmit) Remove(peer string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.loss, peer)
+}
+
+// Transmissions to use for the next message.
+func (r *Retransmit) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.count
+}
+
+func (r *Retransmit) Stats() RetransmitStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return RetransmitStats{Count: r.count, Loss: r.meanLoss()}
+}
+
+// Transmissions needed so that loss^n stays below the residual loss
+func (r *Retransmit) target() int {
+	loss := r.meanLoss()
+	if loss <= 0 {
+		return r.min
+	}
+	if loss >= 1 {
+		return r.max
+	}
+	n := int(math.Ceil(math.Log(r.residual) / math.Log(loss)))
+	if n < r.min {
+		return r.min
+	}
+	if n > r.max {
+		return r.max
+	}
+	return n
+}
+
+func (r *Retransmit) meanLoss() float64 {
+	if len(r.loss) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, loss := range r.loss {
+		sum += loss
+	}
+	return sum / float64(len(r.loss))
+}
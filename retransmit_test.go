@@ -0,0 +1,86 @@
+package gossip
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Broadcast messages to simulated peers over links with the given loss,
+// feeding every transmission outcome back into the controller. Returns
+// the counts used for the last half of the messages and the share of
+// messages a peer never received directly, which relaying peers and
+// anti-entropy have to make up for.
+func simulateRetransmit(r *Retransmit, rnd *rand.Rand, loss float64) (counts map[int]int, lost float64) {
+	const peers = 10
+	const messages = 2000
+	counts = make(map[int]int)
+	for m := 0; m < messages; m++ {
+		n := r.Count()
+		if m >= messages/2 {
+			counts[n]++
+		}
+		for p := 0; p < peers; p++ {
+			peer := fmt.Sprintf("peer-%d", p)
+			received := false
+			for i := 0; i < n; i++ {
+				delivered := rnd.Float64() >= loss
+				r.Observe(peer, delivered)
+				received = received || delivered
+			}
+			if !received {
+				lost++
+			}
+		}
+	}
+	return counts, lost / (peers * messages)
+}
+
+func TestRetransmitAdapts(t *testing.T) {
+	tests := []struct {
+		loss     float64
+		expected int
+	}{
+		{0.01, 2},
+		{0.25, DefaultMaxRetransmit},
+	}
+	for _, test := range tests {
+		r := NewRetransmit(DefaultMinRetransmit, DefaultMaxRetransmit)
+		counts, lost := simulateRetransmit(r, rand.New(rand.NewSource(420)), test.loss)
+		if len(counts) != 1 || counts[test.expected] == 0 {
+			t.Errorf("TestRetransmitAdapts expected a steady count of %d at %.0f%% loss got %v.", test.expected, 100*test.loss, counts)
+		}
+		if lost > 2*DefaultResidualLoss {
+			t.Errorf("TestRetransmitAdapts expected a residual loss below %g at %.0f%% loss got %g.", 2*DefaultResidualLoss, 100*test.loss, lost)
+		}
+		if stats := r.Stats(); stats.Count != test.expected || stats.Loss < test.loss/2 || stats.Loss > 2*test.loss {
+			t.Errorf("TestRetransmitAdapts expected count %d and loss near %.2f got %+v.", test.expected, test.loss, stats)
+		}
+	}
+}
+
+func TestRetransmitBounds(t *testing.T) {
+	r := NewRetransmit(0, -1)
+	if r.Count() != 1 {
+		t.Fatalf("TestRetransmitBounds expected 1 got %d.", r.Count())
+	}
+
+	r = NewRetransmit(2, 4)
+	for i := 0; i < 1000; i++ {
+		r.Observe("a", true)
+	}
+	if r.Count() != 2 {
+		t.Fatalf("TestRetransmitBounds expected the lower bound 2 got %d.", r.Count())
+	}
+	for i := 0; i < 1000; i++ {
+		r.Observe("a", false)
+	}
+	if r.Count() != 4 {
+		t.Fatalf("TestRetransmitBounds expected the upper bound 4 got %d.", r.Count())
+	}
+
+	r.Remove("a")
+	if stats := r.Stats(); stats.Loss != 0 {
+		t.Fatalf("TestRetransmitBounds expected no loss without peers got %+v.", stats)
+	}
+}
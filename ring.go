@@ -0,0 +1,336 @@
+package gossip
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Default number of virtual nodes per member
+const DefaultVirtualNodes = 128
+
+// Maps keys and virtual nodes to positions on the ring.
+type HashFunc func(data []byte) uint64
+
+// Half-open arc (Start, End] of the ring; it wraps around zero if End < Start,
+// and covers the whole ring if both are equal.
+type KeyRange struct {
+	Start, End uint64
+}
+
+// Contains determines if the hash value falls into the arc.
+func (r KeyRange) Contains(h uint64) bool {
+	switch {
+	case r.Start < r.End:
+		return r.Start < h && h <= r.End
+	case r.Start > r.End:
+		return r.Start < h || h <= r.End
+	}
+	return true
+}
+
+// Consistent hash ring over the live members of a cluster. Every member
+// occupies a number of virtual nodes, so that removing one member moves
+// only the keys it owned, spread evenly over the others. The ring is
+// fed by calling Add and Remove as members join, fail or leave.
+type Ring struct {
+	mutex    sync.RWMutex
+	hash     HashFunc
+	vnodes   int
+	local    string
+	points   []ringPoint
+	members  map[string]bool
+	drained  map[string]bool
+	onChange func(gained, lost []KeyRange)
+
+	// Taken before mutex is released, so that the callback sees the
+	// changes one at a time and in order
+	notify sync.Mutex
+}
+
+// Position of a virtual node
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// Create an empty ring on which local is the name of this node. A
+// non-positive vnodes selects DefaultVirtualNodes and a nil hash the
+// default 64-bit hash function.
+func NewRing(local string, vnodes int, hash HashFunc) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	if hash == nil {
+		hash = defaultHash
+	}
+	return &Ring{
+		hash:    hash,
+		vnodes:  vnodes,
+		local:   local,
+		members: make(map[string]bool),
+		drained: make(map[string]bool),
+	}
+}
+
+// FNV-1a followed by the splitmix64 finalizer, so that similar inputs
+// such as "node#1" and "node#2" end up far apart on the ring.
+func defaultHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Register a callback which is invoked after every change of membership
+// with the arcs of the ring the local node gained and lost ownership of.
+// It is not invoked for changes which leave the local ownership intact.
+// Calls are made one at a time in the order of the changes, so applying
+// the arcs keeps track of the ownership; the callback must not change the
+// ring itself.
+func (r *Ring) OnOwnershipChange(f func(gained, lost []KeyRange)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onChange = f
+}
+
+// Insert the member's virtual nodes; adding a member twice has no effect.
+func (r *Ring) Add(node string) {
+	r.update(func() bool {
+		if r.members[node] {
+			return false
+		}
+		r.members[node] = true
+		if !r.drained[node] {
+			r.insert(node)
+		}
+		return true
+	})
+}
+
+// Remove the member's virtual nodes.
+func (r *Ring) Remove(node string) {
+	r.update(func() bool {
+		if !r.members[node] {
+			return false
+		}
+		delete(r.members, node)
+		delete(r.drained, node)
+		r.delete(node)
+		return true
+	})
+}
+
+// Exclude a member from ownership while it stays on the ring, such as
+// a node drained for maintenance, or restore it. Keys of a drained
+// member move to the others as if it had left.
+func (r *Ring) Drain(node string, drained bool) {
+	r.update(func() bool {
+		if r.drained[node] == drained {
+			return false
+		}
+		if drained {
+			r.drained[node] = true
+		} else {
+			delete(r.drained, node)
+		}
+		if !r.members[node] {
+			return false
+		}
+		if drained {
+			r.delete(node)
+		} else {
+			r.insert(node)
+		}
+		return true
+	})
+}
+
+// Determines if the member is drained.
+func (r *Ring) Drained(node string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.drained[node]
+}
+
+// Replace the points with a sorted copy including the node's virtual
+// nodes; the previous slice must stay intact for ownershipDiff.
+func (r *Ring) insert(node string) {
+	points := make([]ringPoint, len(r.points), len(r.points)+r.vnodes)
+	copy(points, r.points)
+	for i := 0; i < r.vnodes; i++ {
+		h := r.hash([]byte(node + "#" + strconv.Itoa(i)))
+		points = append(points, ringPoint{h, node})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].less(points[j]) })
+	r.points = points
+}
+
+func (r *Ring) delete(node string) {
+	points := make([]ringPoint, 0, len(r.points))
+	for _, p := range r.points {
+		if p.node != node {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// Apply the change and notify the callback about the local node's ownership.
+func (r *Ring) update(change func() bool) {
+	r.mutex.Lock()
+	before := r.points
+	if !change() {
+		r.mutex.Unlock()
+		return
+	}
+	after, local, onChange := r.points, r.local, r.onChange
+	r.notify.Lock()
+	defer r.notify.Unlock()
+	r.mutex.Unlock()
+
+	if onChange == nil {
+		return
+	}
+	gained, lost := ownershipDiff(before, after, local)
+	if len(gained) > 0 || len(lost) > 0 {
+		onChange(gained, lost)
+	}
+}
+
+// Returns the member owning the key, or false if the ring is empty.
+func (r *Ring) Owner(key string) (string, bool) {
+	owners := r.Owners(key, 1)
+	if len(owners) == 0 {
+		return "", false
+	}
+	return owners[0], true
+}
+
+// Returns up to n distinct members responsible for the key, in order of
+// preference: the owner first, followed by the next members clockwise.
+// Drained members are never returned.
+func (r *Ring) Owners(key string, n int) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if active := len(r.members) - r.drainedMembers(); n > active {
+		n = active
+	}
+	owners := make([]string, 0, n)
+	if n <= 0 {
+		return owners
+	}
+
+	i := search(r.points, r.hash([]byte(key)))
+	for j := 0; len(owners) < n && j < len(r.points); j++ {
+		node := r.points[(i+j)%len(r.points)].node
+		if !contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
+
+func (r *Ring) drainedMembers() int {
+	n := 0
+	for node := range r.drained {
+		if r.members[node] {
+			n++
+		}
+	}
+	return n
+}
+
+// Names of the members on the ring in no particular order, including
+// drained ones.
+func (r *Ring) Members() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	members := make([]string, 0, len(r.members))
+	for node := range r.members {
+		members = append(members, node)
+	}
+	return members
+}
+
+// Hash collisions between virtual nodes are broken by name so that the
+// order does not depend on the order of insertion.
+func (p ringPoint) less(q ringPoint) bool {
+	return p.hash < q.hash || p.hash == q.hash && p.node < q.node
+}
+
+// Index of the first virtual node at or after h, wrapping around.
+func search(points []ringPoint, h uint64) int {
+	i := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+	if i == len(points) {
+		return 0
+	}
+	return i
+}
+
+func owner(points []ringPoint, h uint64) string {
+	if len(points) == 0 {
+		return ""
+	}
+	return points[search(points, h)].node
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare the arcs owned by local on two rings. The union of both rings'
+// virtual nodes splits the ring into elementary arcs, each of which has a
+// single owner on either ring; adjacent arcs with the same outcome merge.
+func ownershipDiff(before, after []ringPoint, local string) (gained, lost []KeyRange) {
+	bounds := make([]uint64, 0, len(before)+len(after))
+	for _, p := range before {
+		bounds = append(bounds, p.hash)
+	}
+	for _, p := range after {
+		bounds = append(bounds, p.hash)
+	}
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	n := len(bounds)
+	for i := 0; i < n; i++ {
+		arc := KeyRange{bounds[(i+n-1)%n], bounds[i]}
+		if n > 1 && arc.Start == arc.End {
+			continue
+		}
+		was := owner(before, arc.End) == local
+		is := owner(after, arc.End) == local
+		switch {
+		case is && !was:
+			gained = appendRange(gained, arc)
+		case was && !is:
+			lost = appendRange(lost, arc)
+		}
+	}
+	return gained, lost
+}
+
+// Append the arc, merging it with the previous one if they are adjacent.
+func appendRange(ranges []KeyRange, r KeyRange) []KeyRange {
+	if k := len(ranges); k > 0 && ranges[k-1].End == r.Start {
+		ranges[k-1].End = r.End
+		return ranges
+	}
+	return append(ranges, r)
+}
@@ -0,0 +1,245 @@
+package gossip
+
+import (
+	"fmt"
+	"hash/crc64"
+	"sync"
+	"testing"
+)
+
+func TestRingDistribution(t *testing.T) {
+	table := crc64.MakeTable(crc64.ECMA)
+	tests := []struct {
+		name   string
+		nodes  int
+		vnodes int
+		hash   HashFunc
+		spread float64
+	}{
+		{"default", 10, 0, nil, 0.25},
+		{"few", 3, 64, nil, 0.25},
+		{"many", 50, 256, nil, 0.3},
+		{"crc64", 10, 256, func(data []byte) uint64 { return crc64.Checksum(data, table) }, 0.6},
+	}
+
+	const keys = 100000
+	for _, test := range tests {
+		ring := NewRing("node-0", test.vnodes, test.hash)
+		for i := 0; i < test.nodes; i++ {
+			ring.Add(fmt.Sprintf("node-%d", i))
+		}
+
+		counts := make(map[string]int)
+		for k := 0; k < keys; k++ {
+			node, ok := ring.Owner(fmt.Sprintf("key-%d", k))
+			if !ok {
+				t.Fatalf("TestRingDistribution %s: no owner for key-%d", test.name, k)
+			}
+			counts[node]++
+		}
+		if len(counts) != test.nodes {
+			t.Fatalf("TestRingDistribution %s: expected %d owners got %d.", test.name, test.nodes, len(counts))
+		}
+
+		mean := float64(keys) / float64(test.nodes)
+		for node, count := range counts {
+			if d := (float64(count) - mean) / mean; d > test.spread || d < -test.spread {
+				t.Errorf("TestRingDistribution %s: %s owns %d keys, expected %.0f ± %.0f%%.", test.name, node, count, mean, 100*test.spread)
+			}
+		}
+	}
+}
+
+func TestRingOwners(t *testing.T) {
+	ring := NewRing("a", 16, nil)
+	if _, ok := ring.Owner("key"); ok {
+		t.Fatalf("TestRingOwners expected no owner on an empty ring")
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		ring.Add(node)
+	}
+	ring.Add("b")
+
+	owners := ring.Owners("key", 5)
+	if len(owners) != 3 {
+		t.Fatalf("TestRingOwners expected 3 owners got %v.", owners)
+	}
+	seen := make(map[string]bool)
+	for _, node := range owners {
+		if seen[node] {
+			t.Fatalf("TestRingOwners expected distinct owners got %v.", owners)
+		}
+		seen[node] = true
+	}
+	if owner, _ := ring.Owner("key"); owner != owners[0] {
+		t.Fatalf("TestRingOwners expected %q first got %v.", owner, owners)
+	}
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	ring := NewRing("node-0", 0, nil)
+	for i := 0; i < 10; i++ {
+		ring.Add(fmt.Sprintf("node-%d", i))
+	}
+
+	const keys = 20000
+	before := make([]string, keys)
+	for k := range before {
+		before[k], _ = ring.Owner(fmt.Sprintf("key-%d", k))
+	}
+
+	ring.Remove("node-7")
+
+	moved := 0
+	for k, prev := range before {
+		node, _ := ring.Owner(fmt.Sprintf("key-%d", k))
+		if node == "node-7" {
+			t.Fatalf("TestRingMinimalMovement key-%d still owned by removed node", k)
+		}
+		if node != prev {
+			if prev != "node-7" {
+				t.Fatalf("TestRingMinimalMovement key-%d moved from %s to %s.", k, prev, node)
+			}
+			moved++
+		}
+	}
+	// roughly a tenth of the keys belonged to the removed node
+	if moved < keys/20 || moved > keys/5 {
+		t.Fatalf("TestRingMinimalMovement expected about %d keys to move got %d.", keys/10, moved)
+	}
+}
+
+func TestRingOwnershipChange(t *testing.T) {
+	ring := NewRing("a", 32, nil)
+	var gained, lost []KeyRange
+	ring.OnOwnershipChange(func(g, l []KeyRange) {
+		gained, lost = g, l
+	})
+
+	ring.Add("a")
+	if len(gained) != 1 || gained[0].Start != gained[0].End || len(lost) != 0 {
+		t.Fatalf("TestRingOwnershipChange expected the whole ring got %v, lost %v.", gained, lost)
+	}
+
+	gained, lost = nil, nil
+	ring.Add("b")
+	if len(gained) != 0 || len(lost) == 0 {
+		t.Fatalf("TestRingOwnershipChange expected only losses got %v, %v.", gained, lost)
+	}
+	for k := 0; k < 1000; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		h := defaultHash([]byte(key))
+		owner, _ := ring.Owner(key)
+		if inRanges(lost, h) != (owner == "b") {
+			t.Fatalf("TestRingOwnershipChange %s owned by %s disagrees with lost arcs", key, owner)
+		}
+	}
+	removed := lost
+
+	gained, lost = nil, nil
+	ring.Add("c")
+	ring.Remove("c")
+	gained, lost = nil, nil
+	ring.Remove("b")
+	if len(lost) != 0 || len(gained) != len(removed) {
+		t.Fatalf("TestRingOwnershipChange expected %v back got %v, lost %v.", removed, gained, lost)
+	}
+
+	gained = nil
+	ring.Remove("b")
+	if gained != nil {
+		t.Fatalf("TestRingOwnershipChange expected no callback for an unknown member")
+	}
+}
+
+func inRanges(ranges []KeyRange, h uint64) bool {
+	for _, r := range ranges {
+		if r.Contains(h) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRingDrain(t *testing.T) {
+	ring := NewRing("node-0", 0, nil)
+	for i := 0; i < 4; i++ {
+		ring.Add(fmt.Sprintf("node-%d", i))
+	}
+	var lost []KeyRange
+	ring.OnOwnershipChange(func(g, l []KeyRange) {
+		lost = l
+	})
+
+	const keys = 2000
+	before := make([]string, keys)
+	for k := range before {
+		before[k], _ = ring.Owner(fmt.Sprintf("key-%d", k))
+	}
+
+	ring.Drain("node-0", true)
+	if !ring.Drained("node-0") || len(ring.Members()) != 4 {
+		t.Fatalf("TestRingDrain expected drained node to stay a member")
+	}
+	if len(lost) == 0 {
+		t.Fatalf("TestRingDrain expected the local node to lose its arcs")
+	}
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		for _, node := range ring.Owners(key, 4) {
+			if node == "node-0" {
+				t.Fatalf("TestRingDrain %s still assigned to drained node", key)
+			}
+		}
+	}
+	if owners := ring.Owners("key", 4); len(owners) != 3 {
+		t.Fatalf("TestRingDrain expected 3 owners got %v.", owners)
+	}
+
+	ring.Drain("node-0", false)
+	for k, prev := range before {
+		if node, _ := ring.Owner(fmt.Sprintf("key-%d", k)); node != prev {
+			t.Fatalf("TestRingDrain expected key-%d back on %s got %s.", k, prev, node)
+		}
+	}
+}
+
+func TestRingOwnershipChangeOrder(t *testing.T) {
+	ring := NewRing("a", 16, nil)
+	owned := make(map[string]bool)
+	ring.OnOwnershipChange(func(gained, lost []KeyRange) {
+		for k := 0; k < 500; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			h := defaultHash([]byte(key))
+			if inRanges(gained, h) {
+				owned[key] = true
+			}
+			if inRanges(lost, h) {
+				owned[key] = false
+			}
+		}
+	})
+	ring.Add("a")
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				node := fmt.Sprintf("node-%d-%d", w, i%3)
+				ring.Add(node)
+				ring.Drain(node, i%2 == 0)
+				ring.Remove(node)
+				ring.Add(node)
+			}
+		}(w)
+	}
+	wg.Wait()
+	for k := 0; k < 500; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		if owner, _ := ring.Owner(key); owned[key] != (owner == "a") {
+			t.Fatalf("TestRingOwnershipChangeOrder expected the applied arcs to agree on %s owned by %s.", key, owner)
+		}
+	}
+}
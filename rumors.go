@@ -0,0 +1,88 @@
+package gossip
+
+import (
+	"net"
+	"sync"
+)
+
+// Received rumors with the peer each was first heard from, so that relays
+// can exclude it (see Fanout.Relay), and how many copies were redundant.
+// Only the most recent rumors are remembered (see Bounded).
+type Rumors struct {
+	mutex   sync.Mutex
+	sources *idCache
+	stats   RumorStats
+}
+
+type rumorSource struct {
+	from, origin *net.UDPAddr
+}
+
+// Copies of rumors received in total and those which were already known
+type RumorStats struct {
+	Received   uint64
+	Duplicates uint64
+}
+
+// Share of received copies which were redundant.
+func (s RumorStats) RedundantRatio() float64 {
+	if s.Received == 0 {
+		return 0
+	}
+	return float64(s.Duplicates) / float64(s.Received)
+}
+
+func NewRumors() *Rumors {
+	return &Rumors{sources: newIDCache(DefaultCacheLimit)}
+}
+
+// Record a copy of the rumor received from a peer; returns true for the
+// first copy, which should be delivered and relayed.
+func (r *Rumors) Receive(id uint64, from, origin *net.UDPAddr) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Received++
+	if _, ok := r.sources.get(cacheKey{id: id}); ok {
+		r.stats.Duplicates++
+		return false
+	}
+	r.sources.add(cacheKey{id: id}, rumorSource{from, origin})
+	return true
+}
+
+// Peer the rumor was first received from and where it originated.
+func (r *Rumors) Source(id uint64) (from, origin *net.UDPAddr, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	v, ok := r.sources.get(cacheKey{id: id})
+	if !ok {
+		return nil, nil, false
+	}
+	s := v.(rumorSource)
+	return s.from, s.origin, true
+}
+
+// Drop a rumor which is no longer disseminated.
+func (r *Rumors) Forget(id uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sources.remove(cacheKey{id: id})
+}
+
+func (r *Rumors) Stats() RumorStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+func (r *Rumors) SetCacheLimit(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sources.setLimit(n)
+}
+
+func (r *Rumors) CacheStats() CacheStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.sources.stats()
+}
@@ -0,0 +1,129 @@
+package gossip
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+type rumorCopy struct {
+	to, from int
+	id       uint64
+}
+
+// Disseminate rumors among nodes connected by the given links, relaying
+// each rumor once to up to n peers, with or without split horizon.
+// Returns the sends per (from, to) link and the stats of every node.
+func simulateRumors(links [][]int, fanout *Fanout, n int, rumors int, horizon bool) (map[[2]int]int, []RumorStats) {
+	nodes := make([]*Rumors, len(links))
+	for i := range nodes {
+		nodes[i] = NewRumors()
+	}
+	sends := make(map[[2]int]int)
+	peersOf := func(i int) []transport.PeerStats {
+		var peers []transport.PeerStats
+		for _, j := range links[i] {
+			peers = append(peers, transport.PeerStats{Addr: peerAddr(j)})
+		}
+		return peers
+	}
+
+	for id := uint64(0); id < uint64(rumors); id++ {
+		origin := int(id) % len(nodes)
+		nodes[origin].Receive(id, nil, peerAddr(origin))
+		var queue []rumorCopy
+		for _, s := range fanout.Relay(peersOf(origin), n, nil, peerAddr(origin)) {
+			queue = append(queue, rumorCopy{int(s.Addr.IP[15]), origin, id})
+		}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			sends[[2]int{c.from, c.to}]++
+			if !nodes[c.to].Receive(c.id, peerAddr(c.from), peerAddr(origin)) {
+				continue
+			}
+			from, source, _ := nodes[c.to].Source(c.id)
+			if !horizon {
+				from, source = nil, nil
+			}
+			for _, s := range fanout.Relay(peersOf(c.to), n, from, source) {
+				queue = append(queue, rumorCopy{int(s.Addr.IP[15]), c.to, c.id})
+			}
+		}
+	}
+
+	stats := make([]RumorStats, len(nodes))
+	for i, node := range nodes {
+		stats[i] = node.Stats()
+	}
+	return sends, stats
+}
+
+func TestSplitHorizonLine(t *testing.T) {
+	line := [][]int{{1}, {0, 2}, {1}}
+	sends, stats := simulateRumors(line, NewFanout(1, rand.New(rand.NewSource(422))), 2, 30, true)
+
+	// the middle node forwards its own ten rumors both ways and the ten of
+	// each end only to the other end, never back where they came from
+	if sends[[2]int{1, 0}] != 20 || sends[[2]int{1, 2}] != 20 {
+		t.Fatalf("TestSplitHorizonLine expected the middle node to send only onward got %v.", sends)
+	}
+	for i, s := range stats {
+		if s.Duplicates != 0 {
+			t.Fatalf("TestSplitHorizonLine expected no duplicates at node %d got %+v.", i, s)
+		}
+	}
+}
+
+func TestSplitHorizonRedundancy(t *testing.T) {
+	// every node linked to every other
+	const nodes = 8
+	mesh := make([][]int, nodes)
+	for i := range mesh {
+		for j := 0; j < nodes; j++ {
+			if j != i {
+				mesh[i] = append(mesh[i], j)
+			}
+		}
+	}
+	ratio := func(stats []RumorStats) float64 {
+		var total RumorStats
+		for _, s := range stats {
+			total.Received += s.Received
+			total.Duplicates += s.Duplicates
+		}
+		return total.RedundantRatio()
+	}
+
+	// a fanout selecting the sender or the origin again wastes a send
+	fanout := NewFanout(1, rand.New(rand.NewSource(422)))
+	fanout.ExcludeOrigin = true
+	_, naiveStats := simulateRumors(mesh, fanout, 3, 200, false)
+	_, horizonStats := simulateRumors(mesh, fanout, 3, 200, true)
+
+	if r, n := ratio(horizonStats), ratio(naiveStats); r >= n {
+		t.Fatalf("TestSplitHorizonRedundancy expected fewer redundant copies than %.3f got %.3f.", n, r)
+	}
+
+	if _, _, ok := NewRumors().Source(1); ok {
+		t.Fatalf("TestSplitHorizonRedundancy expected no source for an unknown rumor.")
+	}
+}
+
+func TestFanoutRelay(t *testing.T) {
+	peers := []transport.PeerStats{{Addr: peerAddr(1)}, {Addr: peerAddr(2)}, {Addr: peerAddr(3)}}
+	fanout := NewFanout(1, rand.New(rand.NewSource(422)))
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7946}
+	origin := peerAddr(2)
+
+	if selected := fanout.Relay(peers, 3, from, origin); len(selected) != 2 {
+		t.Fatalf("TestFanoutRelay expected two peers got %v.", selected)
+	}
+	fanout.ExcludeOrigin = true
+	selected := fanout.Relay(peers, 3, from, origin)
+	if len(selected) != 1 || !sameAddr(selected[0].Addr, peerAddr(3)) {
+		t.Fatalf("TestFanoutRelay expected only %v got %v.", peerAddr(3), selected)
+	}
+}
@@ -0,0 +1,116 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+)
+
+// Acknowledgements of one member are lost while the broadcasts still reach
+// it: the member delivers but is reported missing.
+func TestScenarioAsymmetricPartition(t *testing.T) {
+	topo := gossiptest.NewTopology(t, 3, nil)
+	topo.SetDown([]int{2}, []int{0}, true)
+
+	origin := NewAcker(topo.Conns[0], nil)
+	delivered := make(chan string, 4)
+	for i := 1; i < 3; i++ {
+		name := fmt.Sprintf("node%d", i)
+		NewAcker(topo.Conns[i], func(payload []byte, from *net.UDPAddr) { delivered <- name })
+	}
+	members := map[string]*net.UDPAddr{"node1": topo.Addrs[1], "node2": topo.Addrs[2]}
+
+	result, err := origin.BroadcastAcked(members, []byte("config v2"), 300*time.Millisecond)
+	if err != nil || len(result.Confirmed) != 1 || result.Confirmed[0] != "node1" || len(result.Missing) != 1 || result.Missing[0] != "node2" {
+		t.Fatalf("TestScenarioAsymmetricPartition expected node2 to be missing got %+v (%v).", result, err)
+	}
+	if len(delivered) != 2 {
+		t.Fatalf("TestScenarioAsymmetricPartition expected both members to deliver got %d.", len(delivered))
+	}
+
+	topo.SetDown([]int{2}, []int{0}, false)
+	if result, err := origin.BroadcastAcked(members, []byte("config v3"), time.Second); err != nil || !result.Complete() {
+		t.Fatalf("TestScenarioAsymmetricPartition expected the healed broadcast to complete got %+v (%v).", result, err)
+	}
+}
+
+// A member behind a slow link answers, but only to requests which allow
+// for its round trip.
+func TestScenarioSlowLink(t *testing.T) {
+	topo := gossiptest.NewTopology(t, 3, nil)
+	slow := gossiptest.Link{Delay: 200 * time.Millisecond}
+	topo.SetLink(0, 2, slow)
+	topo.SetLink(2, 0, slow)
+
+	for i := 1; i < 3; i++ {
+		NewRequester(topo.Conns[i], func(req []byte, from *net.UDPAddr) []byte { return req })
+	}
+	client := NewRequester(topo.Conns[0], nil)
+
+	if _, err := client.Request([]byte("near"), topo.Addrs[1], 150*time.Millisecond); err != nil {
+		t.Fatalf("TestScenarioSlowLink expected the near member to answer got %v.", err)
+	}
+	if _, err := client.Request([]byte("far"), topo.Addrs[2], 150*time.Millisecond); err != ErrRequestTimeout {
+		t.Fatalf("TestScenarioSlowLink expected %v from the far member got %v.", ErrRequestTimeout, err)
+	}
+	start := time.Now()
+	if _, err := client.Request([]byte("far"), topo.Addrs[2], time.Second); err != nil || time.Since(start) < 400*time.Millisecond {
+		t.Fatalf("TestScenarioSlowLink expected an answer after the round trip got %s (%v).", time.Since(start), err)
+	}
+}
+
+// Retried requests get through a link which keeps going down and up, and
+// the server executes each of them once.
+func TestScenarioFlappingLink(t *testing.T) {
+	topo := gossiptest.NewTopology(t, 2, nil)
+
+	var mutex sync.Mutex
+	executions := make(map[string]int)
+	NewRequester(topo.Conns[1], func(req []byte, from *net.UDPAddr) []byte {
+		mutex.Lock()
+		executions[string(req)]++
+		mutex.Unlock()
+		return req
+	})
+	client := NewRequester(topo.Conns[0], nil)
+
+	stop := make(chan bool)
+	flapped := make(chan bool)
+	go func() {
+		defer close(flapped)
+		for down := true; ; down = !down {
+			topo.SetDown([]int{0, 1}, []int{0, 1}, down)
+			select {
+			case <-time.After(30 * time.Millisecond):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-flapped
+	}()
+
+	for i := 0; i < 20; i++ {
+		req := fmt.Sprintf("update %d", i)
+		response, err := client.RequestWithRetry([]byte(req), topo.Addrs[1], RetryOptions{
+			Deadline: time.Now().Add(5 * time.Second),
+			Backoff:  func(int) time.Duration { return 20 * time.Millisecond },
+		})
+		if err != nil || string(response) != req {
+			t.Fatalf("TestScenarioFlappingLink expected a response to %q got %q (%v).", req, response, err)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for req, n := range executions {
+		if n != 1 {
+			t.Fatalf("TestScenarioFlappingLink expected %q to be executed once got %d.", req, n)
+		}
+	}
+}
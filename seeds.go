@@ -0,0 +1,197 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Time after which SRVSeeds queries the records again unless configured
+const DefaultSeedTTL = 30 * time.Second
+
+var ErrServiceName = errors.New("Service name is not of the form _service._proto.domain")
+
+// Source of the addresses a node tries first when it joins.
+type SeedProvider interface {
+	Seeds() ([]string, error)
+}
+
+// Fixed list of seeds
+type StaticSeeds []string
+
+func (s StaticSeeds) Seeds() ([]string, error) {
+	return s, nil
+}
+
+// Join with the seeds of the provider; see Join. An error of the provider
+// leaves only the cached peers to try.
+func JoinFrom(provider SeedProvider, cache *PeerCache, jitter time.Duration, try func(addr string) error) (string, error) {
+	seeds, err := provider.Seeds()
+	if err != nil {
+		seeds = nil
+	}
+	return Join(seeds, cache, jitter, try)
+}
+
+// Looks up SRV records; *net.Resolver implements it.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Split a name like _gossip._udp.example.com into its parts.
+func ParseServiceName(s string) (service, proto, domain string, err error) {
+	parts := strings.SplitN(strings.TrimSuffix(s, "."), ".", 3)
+	if len(parts) != 3 || len(parts[0]) < 2 || len(parts[1]) < 2 || parts[0][0] != '_' || parts[1][0] != '_' || parts[2] == "" {
+		return "", "", "", ErrServiceName
+	}
+	return parts[0][1:], parts[1][1:], parts[2], nil
+}
+
+// Seeds published as SRV records. Targets are ordered as RFC 2782 asks
+// of clients: by ascending priority, and within a priority in a random
+// order in which records with a larger weight tend to come first. The
+// records are cached for TTL and queried again afterwards.
+type SRVSeeds struct {
+	service, proto, domain string
+	resolver               SRVResolver
+	clock                  transport.Clock
+	rnd                    *rand.Rand
+
+	TTL time.Duration
+
+	mutex   sync.Mutex
+	records []*net.SRV
+	fetched time.Time
+}
+
+// Create a provider for a name like _gossip._udp.example.com which orders
+// records of equal priority with rnd, e.g. Conn.Rand. A nil resolver uses
+// net.DefaultResolver.
+func NewSRVSeeds(name string, resolver SRVResolver, rnd *rand.Rand) (*SRVSeeds, error) {
+	service, proto, domain, err := ParseServiceName(name)
+	if err != nil {
+		return nil, err
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &SRVSeeds{
+		service:  service,
+		proto:    proto,
+		domain:   domain,
+		resolver: resolver,
+		clock:    transport.RealClock,
+		rnd:      rnd,
+		TTL:      DefaultSeedTTL,
+	}, nil
+}
+
+// Replace the source of time used for the TTL and Run.
+func (s *SRVSeeds) SetClock(clock transport.Clock) {
+	s.clock = clock
+}
+
+// Addresses of the targets in join order, querying the records if the
+// cached ones are older than TTL. If the query fails, the previous
+// records are used as long as there are any.
+func (s *SRVSeeds) Seeds() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.records == nil || s.clock.Now().Sub(s.fetched) >= s.TTL {
+		if err := s.refresh(); err != nil && s.records == nil {
+			return nil, err
+		}
+	}
+	return s.order(), nil
+}
+
+// Query the records again every TTL until done is closed, so that the
+// seeds stay current for reconnecting. Errors are passed to report, which
+// may be nil.
+func (s *SRVSeeds) Run(report func(error), done <-chan bool) {
+	ticker := s.clock.NewTicker(s.TTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.mutex.Lock()
+			err := s.refresh()
+			s.mutex.Unlock()
+			if err != nil && report != nil {
+				report(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Assumes the caller holds the mutex.
+func (s *SRVSeeds) refresh() error {
+	_, records, err := s.resolver.LookupSRV(context.Background(), s.service, s.proto, s.domain)
+	if err != nil {
+		return err
+	}
+	s.records = records
+	s.fetched = s.clock.Now()
+	return nil
+}
+
+// Assumes the caller holds the mutex.
+func (s *SRVSeeds) order() []string {
+	byPriority := make(map[uint16][]*net.SRV)
+	var priorities []int
+	for _, r := range s.records {
+		if r.Target == "." {
+			// the service is decidedly not available at this domain
+			continue
+		}
+		if byPriority[r.Priority] == nil {
+			priorities = append(priorities, int(r.Priority))
+		}
+		byPriority[r.Priority] = append(byPriority[r.Priority], r)
+	}
+	sort.Ints(priorities)
+
+	seeds := make([]string, 0, len(s.records))
+	for _, priority := range priorities {
+		for _, r := range weightedOrder(byPriority[uint16(priority)], s.rnd) {
+			host := strings.TrimSuffix(r.Target, ".")
+			seeds = append(seeds, net.JoinHostPort(host, strconv.Itoa(int(r.Port))))
+		}
+	}
+	return seeds
+}
+
+// Order records of equal priority by repeatedly picking one with a
+// probability proportional to its weight, zero weights counting as a
+// small chance, as in RFC 2782.
+func weightedOrder(records []*net.SRV, rnd *rand.Rand) []*net.SRV {
+	remaining := append([]*net.SRV(nil), records...)
+	ordered := make([]*net.SRV, 0, len(records))
+	for len(remaining) > 0 {
+		total := 0
+		for _, r := range remaining {
+			total += int(r.Weight) + 1
+		}
+		pick := rnd.Intn(total)
+		for i, r := range remaining {
+			if pick -= int(r.Weight) + 1; pick < 0 {
+				ordered = append(ordered, r)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,155 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+type fakeSRV struct {
+	mutex   sync.Mutex
+	records []*net.SRV
+	err     error
+	queries int
+	query   string
+}
+
+func (r *fakeSRV) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queries++
+	r.query = "_" + service + "._" + proto + "." + name
+	return r.query, r.records, r.err
+}
+
+func (r *fakeSRV) set(records []*net.SRV, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.records, r.err = records, err
+}
+
+func TestParseServiceName(t *testing.T) {
+	tests := []struct {
+		name                   string
+		service, proto, domain string
+		err                    error
+	}{
+		{"_gossip._udp.example.com", "gossip", "udp", "example.com", nil},
+		{"_gossip._udp.svc.cluster.local.", "gossip", "udp", "svc.cluster.local", nil},
+		{"gossip._udp.example.com", "", "", "", ErrServiceName},
+		{"_gossip.udp.example.com", "", "", "", ErrServiceName},
+		{"_gossip._udp", "", "", "", ErrServiceName},
+		{"_._udp.example.com", "", "", "", ErrServiceName},
+	}
+	for _, test := range tests {
+		service, proto, domain, err := ParseServiceName(test.name)
+		if service != test.service || proto != test.proto || domain != test.domain || err != test.err {
+			t.Errorf("TestParseServiceName expected %q %q %q %v for %q got %q %q %q %v.",
+				test.service, test.proto, test.domain, test.err, test.name, service, proto, domain, err)
+		}
+	}
+}
+
+func TestSRVSeedsOrder(t *testing.T) {
+	resolver := &fakeSRV{records: []*net.SRV{
+		{Target: "backup.example.com.", Port: 7946, Priority: 20, Weight: 0},
+		{Target: "heavy.example.com.", Port: 7946, Priority: 10, Weight: 90},
+		{Target: "light.example.com.", Port: 7947, Priority: 10, Weight: 10},
+		{Target: ".", Port: 0, Priority: 5},
+	}}
+	seeds, err := NewSRVSeeds("_gossip._udp.example.com", resolver, rand.New(rand.NewSource(429)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heavyFirst := 0
+	for i := 0; i < 1000; i++ {
+		order, err := seeds.Seeds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(order) != 3 || order[2] != "backup.example.com:7946" {
+			t.Fatalf("TestSRVSeedsOrder expected the backup last got %v.", order)
+		}
+		if order[0] == "heavy.example.com:7946" {
+			heavyFirst++
+		}
+	}
+	// weights 90 and 10 count as 91 and 11
+	if heavyFirst < 850 || heavyFirst > 930 {
+		t.Fatalf("TestSRVSeedsOrder expected the heavy target first about 89%% of the time got %d of 1000.", heavyFirst)
+	}
+	if resolver.query != "_gossip._udp.example.com" || resolver.queries != 1 {
+		t.Fatalf("TestSRVSeedsOrder expected a single query for the name got %d for %q.", resolver.queries, resolver.query)
+	}
+
+	if _, err := NewSRVSeeds("example.com", resolver, transport.NewRand(1)); err != ErrServiceName {
+		t.Fatalf("TestSRVSeedsOrder expected %q got %v.", ErrServiceName, err)
+	}
+}
+
+func TestSRVSeedsRefresh(t *testing.T) {
+	clock := transport.NewManualClock(time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC))
+	resolver := &fakeSRV{err: errors.New("no such host")}
+	seeds, _ := NewSRVSeeds("_gossip._udp.example.com", resolver, transport.NewRand(1))
+	seeds.SetClock(clock)
+	seeds.TTL = time.Minute
+
+	if _, err := seeds.Seeds(); err == nil {
+		t.Fatalf("TestSRVSeedsRefresh expected the lookup error.")
+	}
+	resolver.set([]*net.SRV{{Target: "a.example.com.", Port: 7946}}, nil)
+	if order, _ := seeds.Seeds(); !reflect.DeepEqual(order, []string{"a.example.com:7946"}) {
+		t.Fatalf("TestSRVSeedsRefresh expected a.example.com got %v.", order)
+	}
+
+	// cached within the TTL, then stale records survive a failed query
+	resolver.set([]*net.SRV{{Target: "b.example.com.", Port: 7946}}, nil)
+	clock.Advance(30 * time.Second)
+	if order, _ := seeds.Seeds(); order[0] != "a.example.com:7946" {
+		t.Fatalf("TestSRVSeedsRefresh expected the cached records got %v.", order)
+	}
+	resolver.set(nil, errors.New("timeout"))
+	clock.Advance(time.Minute)
+	if order, err := seeds.Seeds(); err != nil || order[0] != "a.example.com:7946" {
+		t.Fatalf("TestSRVSeedsRefresh expected the previous records got %v (%v).", order, err)
+	}
+
+	// Run keeps the records current in the background
+	resolver.set([]*net.SRV{{Target: "c.example.com.", Port: 7946}}, nil)
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		seeds.Run(nil, done)
+		close(stopped)
+	}()
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	for i := 0; ; i++ {
+		seeds.mutex.Lock()
+		fetched := seeds.records[0].Target
+		seeds.mutex.Unlock()
+		if fetched == "c.example.com." {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("TestSRVSeedsRefresh expected Run to query the records again.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	<-stopped
+
+	joined, err := JoinFrom(seeds, nil, 0, func(addr string) error { return nil })
+	if err != nil || joined != "c.example.com:7946" {
+		t.Fatalf("TestSRVSeedsRefresh expected to join c.example.com got %q (%v).", joined, err)
+	}
+}
@@ -0,0 +1,161 @@
+package gossip
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Sequence numbers behind the highest one of a peer which are still
+// accepted once, for datagrams reordered on the way
+const ReplayWindow = 64
+
+var (
+	ErrReplayed    = errors.New("Message has been received before or belongs to an earlier epoch")
+	ErrUnsequenced = errors.New("Message carries no sequence number")
+)
+
+// Counters of a SequenceGuard
+type SequenceStats struct {
+	Accepted    uint64
+	Replayed    uint64
+	Unsequenced uint64
+}
+
+// Protection against replayed datagrams: every message sent is stamped
+// with the epoch of the node and a sequence number, and a message is
+// accepted only if its epoch is the latest one seen from the sender and
+// its sequence number was not seen within the ReplayWindow. Since every
+// start of a node begins a new epoch (see Epochs.Open), its traffic is
+// accepted right after a restart while whatever was captured before is
+// rejected. Senders are identified by their address; one whose window has
+// been evicted from the cache is trusted again on its next message.
+type SequenceGuard struct {
+	epochs *Epochs
+
+	mutex   sync.Mutex
+	seq     uint64
+	windows *idCache
+	stats   SequenceStats
+}
+
+// Highest sequence number of a peer within its epoch and, in bit i, which
+// of the ReplayWindow numbers before it have been seen
+type replayWindow struct {
+	epoch, highest uint64
+	seen           uint64
+}
+
+func NewSequenceGuard(epochs *Epochs) *SequenceGuard {
+	return &SequenceGuard{epochs: epochs, windows: newIDCache(DefaultCacheLimit)}
+}
+
+// Stamp the messages conn sends and drop those it receives which are
+// replays or not stamped, reporting them as DropEvents. The stamp is a
+// layer, so it reduces MaxPayload. The check is the ingress stage
+// "sequence" of kind StageInspect, so it runs after the decoding stages
+// whether they are registered before or after it. A guard is attached to
+// a connection once.
+func (g *SequenceGuard) Attach(conn *transport.Conn) {
+	conn.UseLayer(&sequenceLayer{g, conn})
+	conn.UseStage("sequence", g.check, transport.StageOptions{Kind: transport.StageInspect})
+}
+
+// Bound the peers whose window is remembered.
+func (g *SequenceGuard) SetCacheLimit(n int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.windows.setLimit(n)
+}
+
+func (g *SequenceGuard) CacheStats() CacheStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.windows.stats()
+}
+
+func (g *SequenceGuard) Stats() SequenceStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.stats
+}
+
+func (g *SequenceGuard) check(p *transport.Packet) (*transport.Packet, error) {
+	m, _, err := wire.DecodeSequenced(p.Msg)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if err != nil {
+		g.stats.Unsequenced++
+		return nil, ErrUnsequenced
+	}
+	key := cacheKey{origin: addrKey(p.Addr)}
+	w, ok := g.windows.get(key)
+	if !ok {
+		w = &replayWindow{epoch: m.Epoch}
+		g.windows.add(key, w)
+	}
+	if !w.(*replayWindow).accept(m.Epoch, m.Seq) {
+		g.stats.Replayed++
+		return nil, ErrReplayed
+	}
+	g.stats.Accepted++
+	q := *p
+	q.Msg = m.Payload
+	return &q, nil
+}
+
+func (g *SequenceGuard) next() (epoch, seq uint64) {
+	epoch = g.epochs.Epoch()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.seq++
+	return epoch, g.seq
+}
+
+// Record the sequence number unless it is a replay.
+func (w *replayWindow) accept(epoch, seq uint64) bool {
+	switch {
+	case epoch < w.epoch:
+		return false
+	case epoch > w.epoch:
+		*w = replayWindow{epoch: epoch, highest: seq, seen: 1}
+		return true
+	case seq > w.highest || w.seen == 0:
+		if shift := seq - w.highest; w.seen == 0 || shift >= ReplayWindow {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<shift | 1
+		}
+		w.highest = seq
+		return true
+	}
+	offset := w.highest - seq
+	if offset >= ReplayWindow || w.seen&(1<<offset) != 0 {
+		return false
+	}
+	w.seen |= 1 << offset
+	return true
+}
+
+// Egress side of a SequenceGuard on one connection
+type sequenceLayer struct {
+	guard *SequenceGuard
+	conn  *transport.Conn
+}
+
+func (l *sequenceLayer) Overhead() int {
+	return wire.SequencedHeaderSize
+}
+
+func (l *sequenceLayer) Egress(p *transport.Packet) (*transport.Packet, error) {
+	epoch, seq := l.guard.next()
+	msg, err := wire.Sequenced{Epoch: epoch, Seq: seq, Payload: p.Msg}.Encode(wire.Version(l.conn.EncodeVersion()))
+	if err != nil {
+		return nil, err
+	}
+	q := *p
+	q.Msg = msg
+	return &q, nil
+}
@@ -0,0 +1,123 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Connection on the port, zero for any, whose traffic is stamped
+// with the epoch continued from the store
+func startSequenced(t *testing.T, store EpochStore, port uint) (*transport.Conn, *SequenceGuard) {
+	guard := NewSequenceGuard(openEpochs(t, store, transport.RealClock))
+	conn := transport.NewConn()
+	guard.Attach(conn)
+	if err := conn.Listen(port); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	<-conn.Events()
+	return conn, guard
+}
+
+func expectMessage(t *testing.T, received *gossiptest.PacketRecorder, expected string) {
+	if _, err := received.WaitFor(gossiptest.MessageIs(expected), time.Second); err != nil {
+		t.Fatalf("TestSequenceGuardRestart expected %q to be delivered.", expected)
+	}
+}
+
+func TestSequenceGuardRestart(t *testing.T) {
+	dir := t.TempDir()
+	peer, peerGuard := startSequenced(t, FileEpochStore(filepath.Join(dir, "peer.json")), 0)
+	peerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: peer.LocalAddr().Port}
+	received := gossiptest.NewPacketRecorder(nil)
+	peer.AddHandler(received.Handle)
+	captured := make(chan *transport.Packet, 8)
+	peer.Mirror(captured, transport.MirrorOptions{Raw: true})
+
+	store := FileEpochStore(filepath.Join(dir, "node.json"))
+	node, _ := startSequenced(t, store, 0)
+	port := uint(node.LocalAddr().Port)
+	for _, msg := range []string{"alive", "suspect"} {
+		if err := node.SendTo(transport.Message(msg), peerAddr); err != nil {
+			t.Fatal(err)
+		}
+		expectMessage(t, received, msg)
+	}
+	node.Disconnect()
+
+	// the restarted node is heard at once
+	restarted, _ := startSequenced(t, store, port)
+	if err := restarted.SendTo(transport.Message("rejoined"), peerAddr); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, received, "rejoined")
+	restarted.Disconnect()
+
+	// while what was captured before the restart is not, even if sent
+	// from the same address
+	replayer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	for i := 0; i < 3; i++ {
+		p := <-captured
+		if _, err := replayer.WriteToUDP(p.Msg, peerAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replayer.WriteToUDP([]byte("unstamped"), peerAddr)
+
+	for i := 0; peerGuard.Stats().Replayed+peerGuard.Stats().Unsequenced < 4; i++ {
+		if i == 100 {
+			t.Fatalf("TestSequenceGuardRestart expected the replays to be rejected got %+v.", peerGuard.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := peerGuard.Stats(); s != (SequenceStats{Accepted: 3, Replayed: 3, Unsequenced: 1}) || received.Len() != 3 {
+		t.Fatalf("TestSequenceGuardRestart unexpected stats %+v with %d deliveries.", s, received.Len())
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := &replayWindow{epoch: 2}
+	tests := []struct {
+		epoch, seq uint64
+		accepted   bool
+	}{
+		{2, 10, true},
+		{2, 10, false},
+		{2, 8, true},
+		{2, 12, true},
+		{2, 8, false},
+		{2, 11, true},
+		{2, 12 + ReplayWindow, true},
+		{2, 12, false},
+		{2, 13, true},
+		{1, 100, false},
+		{3, 1, true},
+		{2, 200, false},
+		{3, 1, false},
+	}
+	for i, test := range tests {
+		if w.accept(test.epoch, test.seq) != test.accepted {
+			t.Fatalf("TestReplayWindow expected %d (epoch %d seq %d) to be accepted %v.", i, test.epoch, test.seq, test.accepted)
+		}
+	}
+}
+
+func TestSequenceGuardConformance(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	gossiptest.Conformance(t, gossiptest.ConnTransport(func(conn *transport.Conn) {
+		n++
+		store := FileEpochStore(filepath.Join(dir, fmt.Sprintf("%d.json", n)))
+		NewSequenceGuard(openEpochs(t, store, transport.RealClock)).Attach(conn)
+	}))
+}
@@ -0,0 +1,180 @@
+package gossip
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix of member tags advertising an application port, as in
+// "service:web=8080".
+const ServiceTagPrefix = "service:"
+
+// Tag of members drained for maintenance; they stay in the cluster
+// but must not be chosen for new work.
+const DrainTag = "drain"
+
+// Parse a tag of the form service:name=port.
+func ParseServiceTag(tag string) (name string, port int, ok bool) {
+	if !strings.HasPrefix(tag, ServiceTagPrefix) {
+		return "", 0, false
+	}
+	name, value, found := strings.Cut(tag[len(ServiceTagPrefix):], "=")
+	if !found || name == "" {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, false
+	}
+	return name, port, true
+}
+
+// Directory of the services advertised by live members through their
+// tags. It is fed by calling Update whenever a member joins or changes its
+// tags and Remove as soon as it is marked dead or leaves, so lookups never
+// return stale entries.
+type Services struct {
+	mutex       sync.Mutex
+	members     map[string]map[string]net.UDPAddr
+	next        map[string]int
+	subscribers map[string][]func([]net.UDPAddr)
+
+	// Taken before mutex is released, so that subscribers see the
+	// snapshots one at a time and in order
+	notify sync.Mutex
+}
+
+// Create an empty directory.
+func NewServices() *Services {
+	return &Services{
+		members:     make(map[string]map[string]net.UDPAddr),
+		next:        make(map[string]int),
+		subscribers: make(map[string][]func([]net.UDPAddr)),
+	}
+}
+
+// Replace the services advertised by the member at the address with
+// those named in its tags; tags not following the convention are ignored.
+// A member carrying DrainTag provides no services until it is undrained.
+func (s *Services) Update(member string, addr net.IP, tags []string) {
+	services := make(map[string]net.UDPAddr)
+	for _, tag := range tags {
+		if tag == DrainTag {
+			s.change(member, nil)
+			return
+		}
+		if name, port, ok := ParseServiceTag(tag); ok {
+			services[name] = net.UDPAddr{IP: addr, Port: port}
+		}
+	}
+	s.change(member, services)
+}
+
+// Forget all services of a member which is dead or has left.
+func (s *Services) Remove(member string) {
+	s.change(member, nil)
+}
+
+// Swap the member's services and notify the subscribers of every service
+// whose set of addresses changed.
+func (s *Services) change(member string, services map[string]net.UDPAddr) {
+	s.mutex.Lock()
+	old := s.members[member]
+	if len(services) == 0 {
+		delete(s.members, member)
+	} else {
+		s.members[member] = services
+	}
+
+	type notification struct {
+		subscribers []func([]net.UDPAddr)
+		addrs       []net.UDPAddr
+	}
+	var notifications []notification
+	for name := range union(old, services) {
+		before, had := old[name]
+		after, has := services[name]
+		if had == has && (!has || equalAddr(before, after)) {
+			continue
+		}
+		if subscribers := s.subscribers[name]; len(subscribers) > 0 {
+			notifications = append(notifications, notification{subscribers, s.lookup(name)})
+		}
+	}
+	s.notify.Lock()
+	defer s.notify.Unlock()
+	s.mutex.Unlock()
+
+	for _, n := range notifications {
+		for _, f := range n.subscribers {
+			f(n.addrs)
+		}
+	}
+}
+
+// Returns the addresses of all live members providing the service,
+// ordered by member name.
+func (s *Services) Lookup(service string) []net.UDPAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lookup(service)
+}
+
+func (s *Services) lookup(service string) []net.UDPAddr {
+	names := make([]string, 0, len(s.members))
+	for member, services := range s.members {
+		if _, ok := services[service]; ok {
+			names = append(names, member)
+		}
+	}
+	sort.Strings(names)
+
+	addrs := make([]net.UDPAddr, len(names))
+	for i, member := range names {
+		addrs[i] = s.members[member][service]
+	}
+	return addrs
+}
+
+// Select the providers of the service in round-robin order. It returns
+// false if no live member provides the service.
+func (s *Services) Pick(service string) (net.UDPAddr, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	addrs := s.lookup(service)
+	if len(addrs) == 0 {
+		return net.UDPAddr{}, false
+	}
+	i := s.next[service] % len(addrs)
+	s.next[service] = i + 1
+	return addrs[i], true
+}
+
+// Register a callback which receives the current providers of the
+// service whenever they change. Calls are made one at a time in the order
+// of the changes, so the last one is always current; the callback must not
+// update the directory itself.
+func (s *Services) Subscribe(service string, f func([]net.UDPAddr)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers[service] = append(s.subscribers[service], f)
+}
+
+func union(a, b map[string]net.UDPAddr) map[string]bool {
+	names := make(map[string]bool, len(a)+len(b))
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	return names
+}
+
+func equalAddr(a, b net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port && a.Zone == b.Zone
+}
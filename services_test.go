@@ -0,0 +1,124 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseServiceTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		name string
+		port int
+		ok   bool
+	}{
+		{"service:web=8080", "web", 8080, true},
+		{"service:dns=53", "dns", 53, true},
+		{"service:web", "", 0, false},
+		{"service:=80", "", 0, false},
+		{"service:web=0", "", 0, false},
+		{"service:web=65536", "", 0, false},
+		{"service:web=http", "", 0, false},
+		{"zone=eu", "", 0, false},
+	}
+	for _, test := range tests {
+		name, port, ok := ParseServiceTag(test.tag)
+		if name != test.name || port != test.port || ok != test.ok {
+			t.Errorf("TestParseServiceTag %q expected %q %d %v got %q %d %v.", test.tag, test.name, test.port, test.ok, name, port, ok)
+		}
+	}
+}
+
+func TestServicesLookup(t *testing.T) {
+	services := NewServices()
+	var updates [][]net.UDPAddr
+	services.Subscribe("db", func(addrs []net.UDPAddr) {
+		updates = append(updates, addrs)
+	})
+
+	services.Update("a", net.IPv4(10, 0, 0, 1), []string{"service:web=8080", "service:db=5432"})
+	services.Update("b", net.IPv4(10, 0, 0, 2), []string{"service:web=8081", "zone=eu"})
+	services.Update("c", net.IPv4(10, 0, 0, 3), []string{"service:web=8080", "service:db=5433"})
+
+	web := services.Lookup("web")
+	if len(web) != 3 || web[1].Port != 8081 || !web[1].IP.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("TestServicesLookup expected three web providers got %v.", web)
+	}
+	if db := services.Lookup("db"); len(db) != 2 || db[1].Port != 5433 {
+		t.Fatalf("TestServicesLookup expected two db providers got %v.", db)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("TestServicesLookup expected 2 db updates got %d.", len(updates))
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		addr, ok := services.Pick("web")
+		if !ok {
+			t.Fatalf("TestServicesLookup expected a web provider")
+		}
+		seen[addr.String()]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("TestServicesLookup expected round-robin over three providers got %v.", seen)
+	}
+
+	services.Remove("c")
+	if web := services.Lookup("web"); len(web) != 2 {
+		t.Fatalf("TestServicesLookup expected dead member to drop out got %v.", web)
+	}
+	if db := services.Lookup("db"); len(db) != 1 || db[0].Port != 5432 {
+		t.Fatalf("TestServicesLookup expected one db provider got %v.", db)
+	}
+	if last := updates[len(updates)-1]; len(updates) != 3 || len(last) != 1 {
+		t.Fatalf("TestServicesLookup expected subscriber to see the removal got %v.", updates)
+	}
+
+	if _, ok := services.Pick("cache"); ok {
+		t.Fatalf("TestServicesLookup expected no cache provider")
+	}
+}
+
+func TestServicesDrain(t *testing.T) {
+	services := NewServices()
+	services.Update("a", net.IPv4(10, 0, 0, 1), []string{"service:web=8080"})
+	services.Update("b", net.IPv4(10, 0, 0, 2), []string{"service:web=8080"})
+
+	services.Update("b", net.IPv4(10, 0, 0, 2), []string{"service:web=8080", DrainTag})
+	if web := services.Lookup("web"); len(web) != 1 || !web[0].IP.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("TestServicesDrain expected drained member to drop out got %v.", web)
+	}
+
+	services.Update("b", net.IPv4(10, 0, 0, 2), []string{"service:web=8080"})
+	if web := services.Lookup("web"); len(web) != 2 {
+		t.Fatalf("TestServicesDrain expected undrained member back got %v.", web)
+	}
+}
+
+func TestServicesSubscribeOrder(t *testing.T) {
+	services := NewServices()
+	var last []net.UDPAddr
+	services.Subscribe("web", func(addrs []net.UDPAddr) { last = addrs })
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			member := fmt.Sprintf("m%d", w)
+			for i := 0; i < 200; i++ {
+				services.Update(member, net.IPv4(10, 0, 0, byte(w)), []string{fmt.Sprintf("service:web=%d", 8000+i)})
+				if i%3 == 0 {
+					services.Remove(member)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if current := services.Lookup("web"); !reflect.DeepEqual(last, current) {
+		t.Fatalf("TestServicesSubscribeOrder expected the last snapshot %v to be current got %v.", last, current)
+	}
+}
@@ -0,0 +1,73 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Returned, wrapped in a *StageError, for stages which outlast the deadline
+var ErrStageTimeout = errors.New("Shutdown stage did not complete in time")
+
+// One step of an ordered shutdown, such as stopping discovery, announcing
+// the departure, flushing broadcasts or closing the transport.
+type Stage struct {
+	Name string
+	Stop func() error
+}
+
+// Failure of a single stage; Err is ErrStageTimeout or the error of Stop.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("shutdown stage %q: %s", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Run the stages one after another, each starting once the previous one
+// has returned, within an overall deadline. A stage still running at the
+// deadline is abandoned and reported; the stages after it are invoked
+// nonetheless so that later components release their resources, but are
+// not awaited and reported as timed out as well. The result joins the
+// *StageError of every stage which failed or timed out, or is nil.
+func Shutdown(timeout time.Duration, stages ...Stage) error {
+	return ShutdownWithClock(transport.RealClock, timeout, stages...)
+}
+
+// Same as Shutdown, with the deadline measured by the clock, e.g. that
+// of the Conn being shut down.
+func ShutdownWithClock(clock transport.Clock, timeout time.Duration, stages ...Stage) error {
+	deadline := clock.After(timeout)
+
+	var errs []error
+	expired := false
+	for _, stage := range stages {
+		result := make(chan error, 1)
+		go func(stop func() error) {
+			result <- stop()
+		}(stage.Stop)
+
+		if expired {
+			errs = append(errs, &StageError{stage.Name, ErrStageTimeout})
+			continue
+		}
+		select {
+		case err := <-result:
+			if err != nil {
+				errs = append(errs, &StageError{stage.Name, err})
+			}
+		case <-deadline:
+			expired = true
+			errs = append(errs, &StageError{stage.Name, ErrStageTimeout})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,153 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestShutdownStack(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	left := make(chan string, 1)
+	peer := transport.NewConn()
+	peer.AddHandler(func(conn *transport.Conn, p *transport.Packet) {
+		left <- string(p.Msg)
+	})
+	if err := peer.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	port := (<-peer.Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+
+	node := transport.NewConn()
+	if err := node.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	pool := transport.NewDialPool(node, 0, 0)
+	member, err := pool.Dial(fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewPeerCache(FilePeerStore(filepath.Join(t.TempDir(), "peers.json")), 0, transport.NewRand(1))
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		cache.Run(time.Millisecond, func() []string { return []string{member.Addr().String()} }, nil, done)
+		close(stopped)
+	}()
+
+	var order []string
+	stage := func(name string, stop func() error) Stage {
+		return Stage{name, func() error {
+			order = append(order, name)
+			return stop()
+		}}
+	}
+	err = Shutdown(time.Second,
+		stage("discovery", func() error {
+			close(done)
+			<-stopped
+			return nil
+		}),
+		stage("leave", func() error {
+			// wait until the announcement has been written
+			sent := make(chan error, 1)
+			node.SendToAsync([]byte("leave"), member.Addr(), func(err error) { sent <- err })
+			return <-sent
+		}),
+		stage("pool", func() error {
+			pool.Close()
+			return nil
+		}),
+		stage("transport", node.Close),
+	)
+	if err != nil {
+		t.Fatalf("TestShutdownStack unexpected error %v", err)
+	}
+	if len(order) != 4 || order[0] != "discovery" || order[3] != "transport" {
+		t.Fatalf("TestShutdownStack unexpected order %v", order)
+	}
+
+	select {
+	case msg := <-left:
+		if msg != "leave" {
+			t.Fatalf("TestShutdownStack expected leave got %q.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestShutdownStack peer did not observe the leave")
+	}
+	peer.Close()
+
+	// goroutines may take a moment to unwind after their channels closed
+	for i := 0; runtime.NumGoroutine() > baseline; i++ {
+		if i == 100 {
+			t.Fatalf("TestShutdownStack leaked %d goroutines", runtime.NumGoroutine()-baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+	failed := errors.New("flush failed")
+
+	closed := make(chan bool, 1)
+	err := Shutdown(50*time.Millisecond,
+		Stage{"broadcasts", func() error { return failed }},
+		Stage{"detectors", func() error { <-release; return nil }},
+		Stage{"transport", func() error { closed <- true; return nil }},
+	)
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "broadcasts" || !errors.Is(err, failed) {
+		t.Fatalf("TestShutdownTimeout expected failed broadcasts stage got %v.", err)
+	}
+	if !errors.Is(err, ErrStageTimeout) {
+		t.Fatalf("TestShutdownTimeout expected a timeout got %v.", err)
+	}
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		if errors.As(e, &stageErr) && stageErr.Err == ErrStageTimeout && stageErr.Stage != "detectors" && stageErr.Stage != "transport" {
+			t.Fatalf("TestShutdownTimeout unexpected timeout of %s", stageErr.Stage)
+		}
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("TestShutdownTimeout expected later stages to run after the deadline")
+	}
+}
+
+func TestShutdownClock(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+
+	conn := transport.NewConn()
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	conn.SetClock(clock)
+	result := make(chan error, 1)
+	go func() {
+		result <- ShutdownWithClock(conn.Clock(), time.Minute,
+			Stage{"detectors", func() error { <-release; return nil }},
+		)
+	}()
+
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-result:
+		t.Fatalf("TestShutdownClock expected the stage to be awaited got %v.", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	if err := <-result; !errors.Is(err, ErrStageTimeout) {
+		t.Fatalf("TestShutdownClock expected a timeout once the clock passed the deadline got %v.", err)
+	}
+}
@@ -0,0 +1,263 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Trailer footer: length of the hops, hop count, dropped hop count,
+// origin port and IPv4 address, trace id and magic
+const traceFooterSize = wire.TraceFooterSize
+
+// Length prefix of the node id and the hop time in Unix nanoseconds
+const hopOverhead = wire.HopOverhead
+
+var (
+	ErrNotTraced    = errors.New("Message is not traced")
+	ErrTraceTooLong = errors.New("Trace does not fit into the datagram")
+)
+
+// Relay of a traced message
+type Hop struct {
+	Node string
+	At   time.Time
+}
+
+// Path of a traced message, oldest hop first. Truncated is the number of
+// hops which were dropped to keep the message within the datagram size.
+type Trace struct {
+	ID        uint64
+	Origin    *net.UDPAddr
+	Hops      []Hop
+	Truncated int
+}
+
+// Append the trace as a trailer to payload, dropping the oldest hops
+// until the result fits into limit bytes.
+func AppendTrace(payload []byte, trace Trace, limit int) (transport.Message, error) {
+	return appendTrace(payload, trace, limit, wire.Current)
+}
+
+func appendTrace(payload []byte, trace Trace, limit int, v wire.Version) (transport.Message, error) {
+	origin := trace.Origin.IP.To4()
+	if origin == nil {
+		return nil, transport.ErrAddressFamilyMismatch
+	}
+	hops := trace.Hops
+	if len(hops) > 255 {
+		hops = hops[len(hops)-255:]
+	}
+	for i := range hops {
+		if len(hops[i].Node) > 255 {
+			return nil, ErrTraceTooLong
+		}
+	}
+	size := func(hops []Hop) int {
+		n := len(payload) + traceFooterSize
+		for _, h := range hops {
+			n += hopOverhead + len(h.Node)
+		}
+		return n
+	}
+	for len(hops) > 0 && size(hops) > limit {
+		hops = hops[1:]
+	}
+	if size(hops) > limit {
+		return nil, ErrTraceTooLong
+	}
+
+	truncated := trace.Truncated + len(trace.Hops) - len(hops)
+	if truncated > 255 {
+		truncated = 255
+	}
+	t := wire.Trace{
+		ID:        trace.ID,
+		Port:      uint16(trace.Origin.Port),
+		Hops:      make([]wire.Hop, len(hops)),
+		Truncated: uint8(truncated),
+	}
+	copy(t.IP[:], origin)
+	for i, h := range hops {
+		t.Hops[i] = wire.Hop{Node: h.Node, At: h.At.UnixNano()}
+	}
+	return wire.AppendTrace(payload, t, v)
+}
+
+// Separate the payload from the trailer added by AppendTrace.
+func SplitTrace(msg transport.Message) ([]byte, Trace, error) {
+	payload, t, _, err := wire.SplitTrace(msg)
+	if err != nil {
+		return nil, Trace{}, ErrNotTraced
+	}
+	trace := Trace{
+		ID:        t.ID,
+		Origin:    &net.UDPAddr{IP: net.IPv4(t.IP[0], t.IP[1], t.IP[2], t.IP[3]).To4(), Port: int(t.Port)},
+		Hops:      make([]Hop, len(t.Hops)),
+		Truncated: int(t.Truncated),
+	}
+	for i, h := range t.Hops {
+		trace.Hops[i] = Hop{Node: h.Node, At: time.Unix(0, h.At)}
+	}
+	return payload, trace, nil
+}
+
+// Relays traced messages to the peers of this node and reports the path
+// each one took back to its origin.
+type Tracer struct {
+	conn  *transport.Conn
+	node  string
+	peers func() []*net.UDPAddr
+	clock transport.Clock
+
+	// Called with the payload and path of every traced message this node
+	// receives for the first time, before relaying it
+	Inspect func(payload []byte, trace Trace)
+
+	mutex   sync.Mutex
+	next    uint64
+	seen    *idCache
+	reports map[uint64]chan Trace
+}
+
+// Register a tracer with conn. The node id is recorded in the hops and
+// peers returns the addresses traced messages are relayed to.
+func NewTracer(conn *transport.Conn, node string, peers func() []*net.UDPAddr) *Tracer {
+	tracer := &Tracer{
+		conn:    conn,
+		node:    node,
+		peers:   peers,
+		clock:   transport.RealClock,
+		next:    conn.Rand().Uint64(),
+		seen:    newIDCache(DefaultCacheLimit),
+		reports: make(map[uint64]chan Trace),
+	}
+	conn.AddHandler(tracer.dispatch)
+	return tracer
+}
+
+// Replace the source of time used for hop timestamps and timeouts.
+func (tracer *Tracer) SetClock(clock transport.Clock) {
+	tracer.clock = clock
+}
+
+// Limit the ids of relayed traces remembered to relay each only once.
+func (tracer *Tracer) SetCacheLimit(n int) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.seen.setLimit(n)
+}
+
+func (tracer *Tracer) CacheStats() CacheStats {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	return tracer.seen.stats()
+}
+
+// Send a traced no-op message to the peers and collect the paths reported
+// by each node which received it before the timeout, keyed by node id.
+// Reports are sent to origin, the IPv4 address of this node as seen by
+// the other members.
+func (tracer *Tracer) TraceBroadcast(origin *net.UDPAddr, timeout time.Duration) (map[string]Trace, error) {
+	reports := make(chan Trace, 64)
+
+	tracer.mutex.Lock()
+	tracer.next++
+	id := tracer.next
+	tracer.seen.add(cacheKey{id: id}, nil)
+	tracer.reports[id] = reports
+	tracer.mutex.Unlock()
+	defer func() {
+		tracer.mutex.Lock()
+		delete(tracer.reports, id)
+		tracer.mutex.Unlock()
+	}()
+
+	trace := Trace{ID: id, Origin: origin, Hops: []Hop{{tracer.node, tracer.clock.Now()}}}
+	if err := tracer.relay(nil, trace, nil); err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]Trace)
+	deadline := tracer.clock.After(timeout)
+	for {
+		select {
+		case trace := <-reports:
+			if last := len(trace.Hops) - 1; last >= 0 {
+				paths[trace.Hops[last].Node] = trace
+			}
+		case <-deadline:
+			return paths, nil
+		}
+	}
+}
+
+func (tracer *Tracer) relay(payload []byte, trace Trace, from *net.UDPAddr) error {
+	msg, err := appendTrace(payload, trace, tracer.conn.MaxPayload(), wire.Version(tracer.conn.EncodeVersion()))
+	if err != nil {
+		return err
+	}
+	for _, addr := range tracer.peers() {
+		if sameAddr(addr, from) {
+			continue
+		}
+		if err := tracer.conn.SendTo(msg, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tracer *Tracer) dispatch(conn *transport.Conn, p *transport.Packet) {
+	if report, _, err := wire.DecodeReport(p.Msg); err == nil {
+		tracer.report(report)
+		return
+	}
+
+	payload, trace, err := SplitTrace(p.Msg)
+	if err != nil {
+		return
+	}
+	tracer.mutex.Lock()
+	seen := tracer.seen.add(cacheKey{id: trace.ID}, nil)
+	tracer.mutex.Unlock()
+	if seen {
+		return
+	}
+
+	trace.Hops = append(trace.Hops, Hop{tracer.node, tracer.clock.Now()})
+	if tracer.Inspect != nil {
+		tracer.Inspect(payload, trace)
+	}
+
+	// report the path, truncating it like a relayed trailer
+	v := wire.Version(conn.EncodeVersion())
+	if trailer, err := appendTrace(nil, trace, conn.MaxPayload()-wire.ReportHeaderSize, v); err == nil {
+		if report, err := (wire.Report{ID: trace.ID, Trailer: trailer}).Encode(v); err == nil {
+			conn.SendTo(report, trace.Origin)
+		}
+	}
+	tracer.relay(payload, trace, p.Addr)
+}
+
+func (tracer *Tracer) report(report wire.Report) {
+	id := report.ID
+	_, trace, err := SplitTrace(report.Trailer)
+	if err != nil || trace.ID != id {
+		return
+	}
+
+	tracer.mutex.Lock()
+	reports, ok := tracer.reports[id]
+	tracer.mutex.Unlock()
+	if ok {
+		select {
+		case reports <- trace:
+		default:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gossip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestTraceTrailer(t *testing.T) {
+	at := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	trace := Trace{
+		ID:     42,
+		Origin: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7946},
+	}
+	for i := 0; i < 10; i++ {
+		trace.Hops = append(trace.Hops, Hop{fmt.Sprintf("node-%d", i), at.Add(time.Duration(i) * time.Millisecond)})
+	}
+
+	msg, err := AppendTrace([]byte("payload"), trace, transport.MessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, decoded, err := SplitTrace(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "payload" || decoded.ID != 42 || decoded.Origin.String() != "10.0.0.1:7946" || len(decoded.Hops) != 10 || decoded.Truncated != 0 {
+		t.Fatalf("TestTraceTrailer expected the trace intact got %q %+v.", payload, decoded)
+	}
+	if !decoded.Hops[3].At.Equal(trace.Hops[3].At) {
+		t.Fatalf("TestTraceTrailer expected %v got %v.", trace.Hops[3].At, decoded.Hops[3].At)
+	}
+
+	// room for the payload and four hops of 15 bytes each
+	limit := len("payload") + traceFooterSize + 4*(hopOverhead+6)
+	if msg, err = AppendTrace([]byte("payload"), trace, limit); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg) > limit {
+		t.Fatalf("TestTraceTrailer expected at most %d bytes got %d.", limit, len(msg))
+	}
+	if _, decoded, _ = SplitTrace(msg); len(decoded.Hops) != 4 || decoded.Hops[0].Node != "node-6" || decoded.Truncated != 6 {
+		t.Fatalf("TestTraceTrailer expected the four newest hops got %+v.", decoded)
+	}
+
+	if _, err := AppendTrace(make([]byte, limit), trace, limit); err != ErrTraceTooLong {
+		t.Fatalf("TestTraceTrailer expected %q got %v.", ErrTraceTooLong, err)
+	}
+	if _, _, err := SplitTrace(transport.Message("payload")); err != ErrNotTraced {
+		t.Fatalf("TestTraceTrailer expected %q got %v.", ErrNotTraced, err)
+	}
+}
+
+// Relay a trace along a line of nodes, each knowing only its neighbours.
+func TestTraceBroadcast(t *testing.T) {
+	const nodes = 8
+	conns := make([]*transport.Conn, nodes)
+	addrs := make([]*net.UDPAddr, nodes)
+	tracers := make([]*Tracer, nodes)
+
+	var mutex sync.Mutex
+	inspected := make(map[string]int)
+	for i := range conns {
+		i := i
+		conns[i] = transport.NewConn()
+		// room for the report and four hops of 15 bytes each
+		conns[i].SetDatagramSize(10 + traceFooterSize + 4*(hopOverhead+6))
+		tracers[i] = NewTracer(conns[i], fmt.Sprintf("node-%d", i), func() []*net.UDPAddr {
+			mutex.Lock()
+			defer mutex.Unlock()
+			var peers []*net.UDPAddr
+			if i > 0 {
+				peers = append(peers, addrs[i-1])
+			}
+			if i < nodes-1 {
+				peers = append(peers, addrs[i+1])
+			}
+			return peers
+		})
+		tracers[i].Inspect = func(payload []byte, trace Trace) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			inspected[trace.Hops[len(trace.Hops)-1].Node]++
+		}
+		if err := conns[i].Listen(0); err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Disconnect()
+		port := (<-conns[i].Events()).(*transport.OpenEvent).LocalAddr.(*net.UDPAddr).Port
+		mutex.Lock()
+		addrs[i] = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		mutex.Unlock()
+	}
+
+	start := time.Now()
+	paths, err := tracers[0].TraceBroadcast(addrs[0], 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != nodes-1 {
+		t.Fatalf("TestTraceBroadcast expected %d paths got %d.", nodes-1, len(paths))
+	}
+	for i := 1; i < nodes; i++ {
+		node := fmt.Sprintf("node-%d", i)
+		trace, ok := paths[node]
+		if !ok {
+			t.Fatalf("TestTraceBroadcast expected a path from %s.", node)
+		}
+		// each path ends with the reporting node after its predecessors
+		kept := len(trace.Hops)
+		if kept != i+1-trace.Truncated || kept > 4 {
+			t.Fatalf("TestTraceBroadcast expected %d hops to %s with at most four kept got %+v.", i+1, node, trace)
+		}
+		for j, hop := range trace.Hops {
+			if expected := fmt.Sprintf("node-%d", i+1-kept+j); hop.Node != expected {
+				t.Fatalf("TestTraceBroadcast expected hop %d to %s at %s got %s.", j, node, expected, hop.Node)
+			}
+			if hop.At.Before(start.Add(-time.Second)) || (j > 0 && hop.At.Before(trace.Hops[j-1].At)) {
+				t.Fatalf("TestTraceBroadcast expected increasing hop times to %s got %+v.", node, trace.Hops)
+			}
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i := 1; i < nodes; i++ {
+		if n := inspected[fmt.Sprintf("node-%d", i)]; n != 1 {
+			t.Fatalf("TestTraceBroadcast expected node-%d to inspect the trace once got %d.", i, n)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package gossip
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/ahorn/gossip/internal/wire"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Envelope header of a message carrying a trace id
+const TraceIDOverhead = wire.TraceContextSize
+
+// Correlates the messages caused by one request or broadcast across the
+// nodes they pass; zero means untraced.
+type TraceID uint64
+
+func (id TraceID) String() string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// Random trace id, never zero.
+func NewTraceID(rnd *rand.Rand) TraceID {
+	var id TraceID
+	for id == 0 {
+		id = TraceID(rnd.Uint64())
+	}
+	return id
+}
+
+// Wrap msg into an envelope carrying the trace id unless it is zero.
+func WithTraceID(msg []byte, id TraceID) (transport.Message, error) {
+	return withTraceID(msg, id, wire.Current)
+}
+
+func withTraceID(msg []byte, id TraceID, v wire.Version) (transport.Message, error) {
+	if id == 0 {
+		return msg, nil
+	}
+	return wire.TraceContext{ID: uint64(id), Payload: msg}.Encode(v)
+}
+
+// Message inside the envelope and its trace id, or msg itself and zero if
+// it carries none. The message aliases msg.
+func SplitTraceID(msg []byte) ([]byte, TraceID) {
+	c, _, err := wire.DecodeTraceContext(msg)
+	if err != nil {
+		return msg, 0
+	}
+	return c.Payload, TraceID(c.ID)
+}
+
+// Step of a traced exchange reported to a TraceHook
+type TraceEvent int
+
+const (
+	TraceRequestSent TraceEvent = iota
+	TraceRequestReceived
+	TraceResponseSent
+	TraceResponseReceived
+)
+
+func (e TraceEvent) String() string {
+	switch e {
+	case TraceRequestSent:
+		return "request sent"
+	case TraceRequestReceived:
+		return "request received"
+	case TraceResponseSent:
+		return "response sent"
+	case TraceResponseReceived:
+		return "response received"
+	}
+	return "unknown"
+}
+
+// Called with the id of every traced message a component sends or
+// receives and the peer it is exchanged with, e.g. to log the id.
+type TraceHook func(id TraceID, event TraceEvent, peer *net.UDPAddr)
@@ -0,0 +1,120 @@
+package gossip
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+// Trace ids seen by the hooks of every node, by node and event
+type traceLog struct {
+	mutex sync.Mutex
+	ids   map[string][]TraceID
+}
+
+func (l *traceLog) hook(node string) TraceHook {
+	return func(id TraceID, event TraceEvent, peer *net.UDPAddr) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.ids[node+": "+event.String()] = append(l.ids[node+": "+event.String()], id)
+	}
+}
+
+func TestTraceIDRelay(t *testing.T) {
+	g := gossiptest.NewGroup(t, 3)
+	log := &traceLog{ids: make(map[string][]TraceID)}
+
+	// the client's request is relayed to the backend by the relay's handler
+	backend := NewTracedRequester(g.Conns[2], func(req []byte, from *net.UDPAddr, id TraceID) []byte {
+		return append([]byte(id.String()+" "), req...)
+	})
+	backend.OnTrace = log.hook("backend")
+	relayClient := NewRequester(g.Conns[1], nil)
+	relay := NewTracedRequester(g.Conns[1], func(req []byte, from *net.UDPAddr, id TraceID) []byte {
+		response, err := relayClient.RequestWithRetry(req, g.Addrs[2], RetryOptions{Trace: id, Deadline: time.Now().Add(time.Second)})
+		if err != nil {
+			return nil
+		}
+		return response
+	})
+	relay.OnTrace = log.hook("relay")
+	relayClient.OnTrace = log.hook("relay")
+	client := NewRequester(g.Conns[0], nil)
+	client.OnTrace = log.hook("client")
+	client.SetTracing(true)
+
+	response, err := client.RequestWithRetry([]byte("lookup"), g.Addrs[1], RetryOptions{Deadline: time.Now().Add(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the relay reports its response once it is sent, which may be after
+	// the client received it
+	for i := 0; ; i++ {
+		log.mutex.Lock()
+		n := len(log.ids["relay: response sent"])
+		log.mutex.Unlock()
+		if n > 0 || i == 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	sent := log.ids["client: request sent"]
+	if len(sent) != 1 || sent[0] == 0 {
+		t.Fatalf("TestTraceIDRelay expected one traced request got %v.", sent)
+	}
+	id := sent[0]
+	if string(response) != id.String()+" lookup" {
+		t.Fatalf("TestTraceIDRelay expected the backend to see %s got %q.", id, response)
+	}
+	for _, hop := range []string{
+		"relay: request received",
+		"relay: request sent",
+		"backend: request received",
+		"backend: response sent",
+		"relay: response received",
+		"client: response received",
+	} {
+		if ids := log.ids[hop]; len(ids) != 1 || ids[0] != id {
+			t.Fatalf("TestTraceIDRelay expected %s with %s got %v.", hop, id, ids)
+		}
+	}
+	if ids := log.ids["relay: response sent"]; len(ids) != 1 || ids[0] != id {
+		t.Fatalf("TestTraceIDRelay expected the relay to answer with %s got %v.", id, ids)
+	}
+}
+
+func TestTraceIDUntraced(t *testing.T) {
+	g := gossiptest.NewGroup(t, 2)
+	traced := 0
+	server := NewTracedRequester(g.Conns[1], func(req []byte, from *net.UDPAddr, id TraceID) []byte {
+		if id != 0 {
+			traced++
+		}
+		return req
+	})
+	server.OnTrace = func(TraceID, TraceEvent, *net.UDPAddr) { traced++ }
+	client := NewRequester(g.Conns[0], nil)
+	if _, err := client.Request([]byte("plain"), g.Addrs[1], time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if traced != 0 {
+		t.Fatalf("TestTraceIDUntraced expected no trace id got %d.", traced)
+	}
+
+	msg, id := SplitTraceID(transport.Message("plain"))
+	if id != 0 || string(msg) != "plain" {
+		t.Fatalf("TestTraceIDUntraced expected an untraced message to pass got %q and %s.", msg, id)
+	}
+	wrapped, _ := WithTraceID([]byte("payload"), 7)
+	if msg, id := SplitTraceID(wrapped); id != 7 || string(msg) != "payload" {
+		t.Fatalf("TestTraceIDUntraced expected the envelope to round trip got %q and %s.", msg, id)
+	}
+}
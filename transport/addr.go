@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+var (
+	ErrMissingPort           = errors.New("Address has no port")
+	ErrInvalidPort           = errors.New("Port is out of range or unknown")
+	ErrAddressFamilyMismatch = errors.New("Address is not IPv4")
+	ErrUnresolvable          = errors.New("Host cannot be resolved to an IPv4 address")
+	ErrMalformedAddr         = errors.New("Address is malformed")
+)
+
+// Failure to turn a string into a UDP end-point. Err is one of the errors
+// above and Cause, if not nil, the underlying error of the resolver.
+type AddrError struct {
+	Addr  string
+	Err   error
+	Cause error
+}
+
+func (e *AddrError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("address %q: %s: %s", e.Addr, e.Err, e.Cause)
+	}
+	return fmt.Sprintf("address %q: %s", e.Addr, e.Err)
+}
+
+func (e *AddrError) Unwrap() error {
+	return e.Err
+}
+
+// Resolver used by ParseAddr for hostnames; replaced by tests.
+var lookupIP = net.DefaultResolver.LookupIP
+
+// Resolve a host:port string to an IPv4 end-point, the only family a
+// Conn speaks. The errors, all of type *AddrError, distinguish a missing
+// or invalid port, an IPv6 address and a hostname without IPv4 address.
+// An empty host yields a nil IP as in ResolveUDPAddr.
+func ParseAddr(addr string) (*net.UDPAddr, error) {
+	return parseAddr(addr, func(host string) ([]net.IP, error) {
+		ips, err := lookupIP(context.Background(), "ip4", host)
+		if err != nil || len(ips) == 0 {
+			return nil, &AddrError{Addr: host, Err: ErrUnresolvable, Cause: err}
+		}
+		return ips, nil
+	})
+}
+
+// Parse the address like ParseAddr, resolving hostnames by lookup, whose
+// errors are returned as they are.
+func parseAddr(addr string, lookup func(host string) ([]net.IP, error)) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// a bare IPv6 literal has "too many colons" rather than no port
+		if e, ok := err.(*net.AddrError); ok && e.Err == "missing port in address" || net.ParseIP(addr) != nil {
+			return nil, &AddrError{Addr: addr, Err: ErrMissingPort}
+		}
+		return nil, &AddrError{Addr: addr, Err: ErrMalformedAddr, Cause: err}
+	}
+	if port == "" {
+		return nil, &AddrError{Addr: addr, Err: ErrMissingPort}
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		// service names such as "domain"
+		n, lookupErr := net.LookupPort("udp", port)
+		if lookupErr != nil {
+			return nil, &AddrError{Addr: addr, Err: ErrInvalidPort}
+		}
+		portNum = uint64(n)
+	}
+	udpAddr := &net.UDPAddr{Port: int(portNum)}
+
+	if host == "" {
+		return udpAddr, nil
+	}
+	if ip, zone, ok := parseIP(host); ok {
+		if ip.To4() == nil || zone != "" {
+			return nil, &AddrError{Addr: addr, Err: ErrAddressFamilyMismatch}
+		}
+		udpAddr.IP = ip.To4()
+		return udpAddr, nil
+	}
+
+	ips, err := lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr.IP = ips[0].To4()
+	return udpAddr, nil
+}
+
+// Parse an IP literal with an optional IPv6 zone.
+func parseIP(host string) (net.IP, string, bool) {
+	ip, zone := host, ""
+	for i := 0; i < len(host); i++ {
+		if host[i] == '%' {
+			ip, zone = host[:i], host[i+1:]
+			break
+		}
+	}
+	parsed := net.ParseIP(ip)
+	return parsed, zone, parsed != nil
+}
+
+// Reject destinations the udp4 socket cannot reach.
+func checkAddr(addr *net.UDPAddr) error {
+	if addr == nil || addr.IP == nil || addr.IP.To4() != nil && addr.Zone == "" {
+		return nil
+	}
+	return &AddrError{Addr: addr.String(), Err: ErrAddressFamilyMismatch}
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseAddr(t *testing.T) {
+	lookup := lookupIP
+	defer func() { lookupIP = lookup }()
+	lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		switch host {
+		case "node1.example":
+			return []net.IP{net.IPv4(10, 0, 0, 1)}, nil
+		case "v6only.example":
+			return nil, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	tests := []struct {
+		addr string
+		ip   net.IP
+		port int
+		err  error
+	}{
+		{"127.0.0.1:8080", net.IPv4(127, 0, 0, 1), 8080, nil},
+		{"0.0.0.0:1", net.IPv4zero, 1, nil},
+		{"255.255.255.255:9", net.IPv4bcast, 9, nil},
+		{":9999", nil, 9999, nil},
+		{"node1.example:53", net.IPv4(10, 0, 0, 1), 53, nil},
+		{"127.0.0.1:domain", net.IPv4(127, 0, 0, 1), 53, nil},
+		{"[::ffff:10.0.0.2]:80", net.IPv4(10, 0, 0, 2), 80, nil},
+		{"127.0.0.1:0", net.IPv4(127, 0, 0, 1), 0, nil},
+		{"127.0.0.1", nil, 0, ErrMissingPort},
+		{"127.0.0.1:", nil, 0, ErrMissingPort},
+		{"node1.example", nil, 0, ErrMissingPort},
+		{"::1", nil, 0, ErrMissingPort},
+		{"fe80::1", nil, 0, ErrMissingPort},
+		{"", nil, 0, ErrMissingPort},
+		{"[::1]:80", nil, 0, ErrAddressFamilyMismatch},
+		{"[2001:db8::1]:9999", nil, 0, ErrAddressFamilyMismatch},
+		{"[fe80::1%eth0]:80", nil, 0, ErrAddressFamilyMismatch},
+		{"127.0.0.1:65536", nil, 0, ErrInvalidPort},
+		{"127.0.0.1:-1", nil, 0, ErrInvalidPort},
+		{"127.0.0.1:nosuchservice", nil, 0, ErrInvalidPort},
+		{"unknown.example:80", nil, 0, ErrUnresolvable},
+		{"v6only.example:80", nil, 0, ErrUnresolvable},
+		{"[127.0.0.1:80", nil, 0, ErrMalformedAddr},
+		{"127.0.0.1:80:90", nil, 0, ErrMalformedAddr},
+	}
+
+	for _, test := range tests {
+		addr, err := ParseAddr(test.addr)
+		if test.err != nil {
+			var addrErr *AddrError
+			if !errors.Is(err, test.err) || !errors.As(err, &addrErr) {
+				t.Errorf("TestParseAddr %q expected %q got %v.", test.addr, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("TestParseAddr %q unexpected error %v", test.addr, err)
+			continue
+		}
+		if !addr.IP.Equal(test.ip) || addr.Port != test.port {
+			t.Errorf("TestParseAddr %q expected %s:%d got %s.", test.addr, test.ip, test.port, addr)
+		}
+	}
+
+	if _, err := ParseAddr("unknown.example:80"); err == nil || err.(*AddrError).Addr != "unknown.example" {
+		t.Errorf("TestParseAddr expected the hostname in the error got %v.", err)
+	}
+}
+
+func TestAddressFamilyChecks(t *testing.T) {
+	if _, err := NewPacket("192.168.1.1", nil); !errors.Is(err, ErrMissingPort) {
+		t.Fatalf("TestAddressFamilyChecks expected %q got %v.", ErrMissingPort, err)
+	}
+	if p, err := NewPacket("192.168.1.1:9", nil); err != nil || p.Addr.Port != 9 {
+		t.Fatalf("TestAddressFamilyChecks unexpected packet %v %v", p, err)
+	}
+
+	conn := NewConn()
+	if err := conn.Dial("[::1]:9915", time.Time{}); !errors.Is(err, ErrAddressFamilyMismatch) {
+		t.Fatalf("TestAddressFamilyChecks expected %q got %v.", ErrAddressFamilyMismatch, err)
+	}
+	if conn.State() != Idle {
+		t.Fatalf("TestAddressFamilyChecks expected %s got %s.", Idle, conn.State())
+	}
+
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	v6 := &net.UDPAddr{IP: net.IPv6loopback, Port: 9915}
+	if err := conn.SendTo([]byte(expectedRequest), v6); !errors.Is(err, ErrAddressFamilyMismatch) {
+		t.Fatalf("TestAddressFamilyChecks expected %q got %v.", ErrAddressFamilyMismatch, err)
+	}
+	if err := conn.UnicastTo([]byte(expectedRequest), v6); !errors.Is(err, ErrAddressFamilyMismatch) {
+		t.Fatalf("TestAddressFamilyChecks expected %q got %v.", ErrAddressFamilyMismatch, err)
+	}
+}
@@ -1,13 +1,31 @@
-package gossip
+package transport
 
 import (
-	"testing"
-	"os"
 	"net"
 	"strconv"
+	"testing"
 )
 
 func TestBroadcast(t *testing.T) {
+	requester := openSocket(t, 8100, nil)
+	responder := openSocket(t, 8200, nil)
+
+	c := make(chan bool)
+	go respond(t, responder)
+	go receive(t, requester, c)
+	addr := &net.UDPAddr{IP: net.IPv4bcast, Port: 8200}
+	request := []byte("Some request")
+	_, err := requester.WriteTo(request, addr)
+	if err != nil {
+		t.Fatalf("Unable to respond to request: %s", err)
+	}
+
+	<-c
+	requester.Close()
+	responder.Close()
+}
+
+func TestBroadcastDialed(t *testing.T) {
 	responder := openSocket(t, 8200, nil)
 	responderAddr, ok := responder.LocalAddr().(*net.UDPAddr)
 	if !ok {
@@ -21,47 +39,52 @@ func TestBroadcast(t *testing.T) {
 	go receive(t, requester, c)
 
 	request := []byte("Some request")
-	_, err := requester.WriteTo(request, &net.UDPAddr{IP:net.IPv4bcast, Port:8200})
+	_, err := requester.Write(request)
 	if err != nil {
 		t.Fatalf("Unable to respond to request: %s", err)
 	}
 
 	<-c
+	requester.Close()
+	responder.Close()
 }
 
 // Strobes the channel when the connection encounters an incoming packet.
+// Since testing.T is thread-safe, this function can be run as a goroutine.
 func receive(t *testing.T, conn *net.UDPConn, c chan bool) {
 	buff := make([]byte, 16)
 	_, _, err := conn.ReadFromUDP(buff)
 	if err != nil {
-		t.Fatalf("Cannot serve request: %s", err)
+		t.Errorf("Cannot serve request: %s", err)
 	}
-	
+
 	c <- true
 }
 
-
 // Listens for an incoming packet and replies to it.
+// Since testing.T is thread-safe, this function can be run as a goroutine.
 func respond(t *testing.T, conn *net.UDPConn) {
 	buff := make([]byte, 16)
 	_, addr, err := conn.ReadFromUDP(buff)
 	if err != nil {
-		t.Fatalf("Cannot serve request: %s", err)
+		t.Errorf("Cannot serve request: %s", err)
+		return
 	}
-	
+
 	response := []byte("Some reply")
 	if _, err = conn.WriteTo(response, addr); err != nil {
-		t.Fatalf("Cannot write response: %s", err)
+		t.Errorf("Cannot write response: %s", err)
 	}
 }
 
 // Open socket which can listen to any local address on the specified port.
+// If remoteAddr is not nil, the socket is connected to it.
 func openSocket(t *testing.T, port uint, remoteAddr *net.UDPAddr) *net.UDPConn {
-	var err os.Error
+	var err error
 	var addr *net.UDPAddr
 	var conn *net.UDPConn
 
-	addr, err = net.ResolveUDPAddr(":" + strconv.Uitoa(port))
+	addr, err = net.ResolveUDPAddr("udp", ":"+strconv.FormatUint(uint64(port), 10))
 	if err != nil {
 		t.Fatalf("Cannot resolve address: %s", err)
 	}
@@ -77,4 +100,3 @@ func openSocket(t *testing.T, port uint, remoteAddr *net.UDPAddr) *net.UDPConn {
 
 	return conn
 }
-
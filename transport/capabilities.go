@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Set of optional encodings a connection decodes, see UseEncoding
+type Capability uint32
+
+const (
+	// Compressed payloads
+	CapCompression Capability = 1 << iota
+)
+
+// Optional encoding of outgoing messages, such as compression, which must
+// only be applied towards peers able to decode it.
+type Encoding interface {
+	// Encode an outgoing packet like a Layer.
+	Layer
+
+	// Decode an incoming packet like a Middleware; packets which do not
+	// carry the encoding must pass unchanged.
+	Ingress(p *Packet) (*Packet, error)
+}
+
+// Layer which applies an Encoding only towards peers which advertised
+// its capability
+type gatedEncoding struct {
+	conn       *Conn
+	capability Capability
+	encoding   Encoding
+}
+
+func (g *gatedEncoding) Overhead() int {
+	return g.encoding.Overhead()
+}
+
+// Hints stay plain so that a peer which lost the encoding, e.g. by a
+// downgrade, still reads them.
+func (g *gatedEncoding) Egress(p *Packet) (*Packet, error) {
+	if isHint(p.Msg) {
+		return p, nil
+	}
+	dst := p.Addr
+	if dst == nil {
+		dst = g.conn.remoteAddr()
+	}
+	if g.conn.PeerCapabilities(dst)&g.capability == 0 {
+		return p, nil
+	}
+	return g.encoding.Egress(p)
+}
+
+// Decode incoming packets with the encoding, apply it to outgoing packets
+// towards peers which advertised the capability (see
+// AdvertiseCapabilities) and advertise the capability from now on.
+// Unknown and legacy peers, which never answer a hint, receive plain
+// messages. The overhead of the encoding reduces MaxPayload like that of
+// any Layer. Must be called before the socket is opened.
+func (conn *Conn) UseEncoding(capability Capability, e Encoding) {
+	conn.UseLayer(&gatedEncoding{conn, capability, e})
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.addStage(fmt.Sprintf("encoding-%#x", uint32(capability)), StageDecode, e.Ingress)
+	conn.capabilities |= capability
+}
+
+// Capabilities this connection advertises.
+func (conn *Conn) Capabilities() Capability {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.capabilities
+}
+
+// Replace the advertised capabilities, e.g. to withdraw one before an
+// encoding is taken out of service, and send them to every peer whose own
+// are known, with a higher incarnation so that they supersede the hints
+// sent before. A connection which is not open advertises them once it is.
+func (conn *Conn) SetCapabilities(c Capability) {
+	conn.mutex.Lock()
+	conn.capabilities = c
+	conn.capIncarnation = conn.capabilityIncarnation() + 1
+	open := conn.state.isOpen()
+	conn.mutex.Unlock()
+
+	if !open {
+		return
+	}
+	for _, peer := range conn.peers.snapshot(conn.clock.Now()) {
+		if peer.CapabilityIncarnation > 0 {
+			conn.sendCapabilities(peer.Addr, false)
+		}
+	}
+}
+
+// Tell the peer which encodings this connection decodes and ask for its
+// own, like AdvertiseDatagramSize; until it has answered, messages to it
+// use no optional encoding. The hints are consumed by the connection after
+// the ingress middleware and never reach the handlers.
+func (conn *Conn) AdvertiseCapabilities(addr *net.UDPAddr) error {
+	return conn.sendCapabilities(addr, false)
+}
+
+func (conn *Conn) sendCapabilities(addr *net.UDPAddr, reply bool) error {
+	conn.mutex.Lock()
+	c := wire.Capabilities{Bits: uint32(conn.capabilities), Incarnation: conn.capabilityIncarnation(), Reply: reply}
+	v := conn.encodeVersion
+	conn.mutex.Unlock()
+	msg, err := c.Encode(wire.Version(v))
+	if err != nil {
+		return err
+	}
+	if remote := conn.remoteAddr(); remote != nil && peerKey(remote) == peerKey(addr) {
+		// a dialed socket only writes to its remote end-point
+		addr = nil
+	}
+	return conn.SendTo(msg, addr)
+}
+
+// Incarnation of the advertised capabilities, which starts at the time of
+// the first hint so that a restarted process supersedes what it advertised
+// before. Assumes the caller holds the mutex.
+func (conn *Conn) capabilityIncarnation() uint64 {
+	if conn.capIncarnation == 0 {
+		conn.capIncarnation = uint64(conn.clock.Now().UnixNano())
+	}
+	return conn.capIncarnation
+}
+
+// Capabilities the peer advertised last, none if unknown.
+func (conn *Conn) PeerCapabilities(addr *net.UDPAddr) Capability {
+	if addr == nil {
+		return 0
+	}
+	peer, _ := conn.peers.lookup(addr)
+	return peer.Capabilities
+}
+
+// Record the capabilities carried by the packet unless older ones arrive
+// late, and answer a request for ours; returns false for packets of
+// other kinds.
+func (conn *Conn) capabilityHint(p *Packet) bool {
+	hint, _, err := wire.DecodeCapabilities(p.Msg)
+	if err != nil {
+		return false
+	}
+	conn.peers.update(p.Addr, conn.clock.Now(), func(peer *PeerStats) {
+		if hint.Incarnation > peer.CapabilityIncarnation {
+			peer.Capabilities, peer.CapabilityIncarnation = Capability(hint.Bits), hint.Incarnation
+		}
+	})
+	conn.capProbing.answered(p.Addr)
+	if !hint.Reply {
+		conn.sendCapabilities(p.Addr, true)
+	}
+	return true
+}
+
+// Whether the message is a capability or size hint.
+func isHint(msg Message) bool {
+	if _, _, err := wire.DecodeCapabilities(msg); err == nil {
+		return true
+	}
+	_, _, err := wire.DecodeSizeHint(msg)
+	return err == nil
+}
+
+// Remote end-point of a dialed socket, nil otherwise.
+func (conn *Conn) remoteAddr() *net.UDPAddr {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.sock == nil {
+		return nil
+	}
+	addr, _ := conn.sock.RemoteAddr().(*net.UDPAddr)
+	return addr
+}
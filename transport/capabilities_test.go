@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+var compressedMagic = []byte{0xcc, 0x01}
+
+// Compression behind a magic prefix
+type flateEncoding struct{}
+
+func (flateEncoding) Overhead() int {
+	return len(compressedMagic) + 16
+}
+
+func (flateEncoding) Egress(p *Packet) (*Packet, error) {
+	var b bytes.Buffer
+	b.Write(compressedMagic)
+	w, _ := flate.NewWriter(&b, flate.BestSpeed)
+	w.Write(p.Msg)
+	w.Close()
+	return &Packet{Addr: p.Addr, Msg: b.Bytes()}, nil
+}
+
+func (flateEncoding) Ingress(p *Packet) (*Packet, error) {
+	if !bytes.HasPrefix(p.Msg, compressedMagic) {
+		return p, nil
+	}
+	msg, err := io.ReadAll(flate.NewReader(bytes.NewReader(p.Msg[len(compressedMagic):])))
+	if err != nil {
+		return nil, err
+	}
+	q := *p
+	q.Msg = msg
+	return &q, nil
+}
+
+// Node which counts the compressed datagrams it receives before decoding
+// and delivers the messages.
+type encodingNode struct {
+	conn       *Conn
+	addr       *net.UDPAddr
+	compressed atomic.Int32
+	received   chan string
+}
+
+func startEncoding(t *testing.T, port int, capable bool) *encodingNode {
+	n := &encodingNode{conn: NewConn(), received: make(chan string, 8)}
+	go monitor(n.conn.Err, t)
+	n.conn.Use(func(p *Packet) (*Packet, error) {
+		if bytes.HasPrefix(p.Msg, compressedMagic) {
+			n.compressed.Add(1)
+		}
+		return p, nil
+	})
+	if capable {
+		n.conn.UseEncoding(CapCompression, flateEncoding{})
+	}
+	n.conn.AddHandler(func(conn *Conn, p *Packet) {
+		n.received <- string(p.Msg)
+	})
+	if err := n.conn.Listen(uint(port)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.conn.Disconnect)
+	<-n.conn.Events()
+	n.addr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: n.conn.LocalAddr().Port}
+	return n
+}
+
+func waitCapabilities(t *testing.T, conn *Conn, addr *net.UDPAddr, expected Capability) {
+	for i := 0; ; i++ {
+		peer, _ := conn.peers.lookup(addr)
+		if peer.CapabilityIncarnation > 0 && peer.Capabilities == expected {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("TestCapabilities expected %s to advertise %b got %b.", addr, expected, peer.Capabilities)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectDelivery(t *testing.T, n *encodingNode, expected string) {
+	select {
+	case msg := <-n.received:
+		if msg != expected {
+			t.Fatalf("TestCapabilities expected %q got %q.", expected, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestCapabilities expected %q at %s.", expected, n.addr)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	msg := strings.Repeat("alive ", 50)
+	sender := startEncoding(t, 0, true)
+	capable := startEncoding(t, 0, true)
+	legacy := startEncoding(t, 0, false)
+
+	// a peer which predates the hints ignores them and never answers
+	raw, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	rawAddr := raw.LocalAddr().(*net.UDPAddr)
+
+	for _, addr := range []*net.UDPAddr{capable.addr, legacy.addr, rawAddr} {
+		if err := sender.conn.AdvertiseCapabilities(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitCapabilities(t, sender.conn, capable.addr, CapCompression)
+	waitCapabilities(t, sender.conn, legacy.addr, 0)
+	for _, n := range []*encodingNode{capable, legacy} {
+		sender.conn.SendTo(Message(msg), n.addr)
+		expectDelivery(t, n, msg)
+	}
+	sender.conn.SendTo(Message(msg), rawAddr)
+	buf := make([]byte, MessageSize)
+	raw.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := raw.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("TestCapabilities expected the message at the legacy peer: %s", err)
+		}
+		if _, _, err := wire.DecodeCapabilities(buf[:n]); err == nil {
+			continue
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("TestCapabilities expected a plain message at the legacy peer got %q.", buf[:n])
+		}
+		break
+	}
+	if capable.compressed.Load() != 1 || legacy.compressed.Load() != 0 {
+		t.Fatalf("TestCapabilities expected only the capable peer to receive compression got %d and %d.", capable.compressed.Load(), legacy.compressed.Load())
+	}
+
+	// the legacy node is upgraded and restarts on its port
+	port := legacy.addr.Port
+	legacy.conn.Disconnect()
+	upgraded := startEncoding(t, port, true)
+	if err := upgraded.conn.AdvertiseCapabilities(sender.addr); err != nil {
+		t.Fatal(err)
+	}
+	waitCapabilities(t, sender.conn, upgraded.addr, CapCompression)
+	sender.conn.SendTo(Message(msg), upgraded.addr)
+	expectDelivery(t, upgraded, msg)
+
+	// while the capable node withdraws compression again
+	capable.conn.SetCapabilities(0)
+	waitCapabilities(t, sender.conn, capable.addr, 0)
+	sender.conn.SendTo(Message(msg), capable.addr)
+	expectDelivery(t, capable, msg)
+	if capable.compressed.Load() != 1 || upgraded.compressed.Load() != 1 {
+		t.Fatalf("TestCapabilities expected compression to follow the advertisements got %d and %d.", capable.compressed.Load(), upgraded.compressed.Load())
+	}
+}
+
+func TestCapabilitiesStale(t *testing.T) {
+	conn := NewConn()
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7946}
+	hint := func(bits uint32, incarnation uint64) {
+		msg, _ := wire.Capabilities{Bits: bits, Incarnation: incarnation, Reply: true}.Encode(wire.Current)
+		if !conn.capabilityHint(&Packet{Addr: addr, Msg: msg}) {
+			t.Fatalf("TestCapabilitiesStale expected the hint to be consumed.")
+		}
+	}
+	hint(uint32(CapCompression), 10)
+	hint(0, 9)
+	if c := conn.PeerCapabilities(addr); c != CapCompression {
+		t.Fatalf("TestCapabilitiesStale expected a late hint to be ignored got %b.", c)
+	}
+	hint(0, 11)
+	if c := conn.PeerCapabilities(addr); c != 0 {
+		t.Fatalf("TestCapabilitiesStale expected the newer hint to win got %b.", c)
+	}
+}
@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// Time a capability probe waits for its answer before it is repeated
+	DefaultCapabilityProbeTimeout = 500 * time.Millisecond
+
+	// Probes sent before a peer is taken to be of a release without
+	// capability hints
+	DefaultCapabilityProbeAttempts = 3
+
+	// Period for which the outcome of a probe is kept
+	DefaultCapabilityTTL = 10 * time.Minute
+)
+
+var ErrNoCapabilities = errors.New("Peer did not answer the capability probe")
+
+// Probing of the capabilities of a peer, see SetCapabilityProbe; zero
+// fields select the defaults above.
+type CapabilityProbe struct {
+	Timeout  time.Duration
+	Attempts int
+	TTL      time.Duration
+}
+
+func (p CapabilityProbe) withDefaults() CapabilityProbe {
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultCapabilityProbeTimeout
+	}
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultCapabilityProbeAttempts
+	}
+	if p.TTL <= 0 {
+		p.TTL = DefaultCapabilityTTL
+	}
+	return p
+}
+
+// Probe the remote end-point of a dialed socket for its capabilities as
+// soon as it is opened and again whenever the outcome expires, so that the
+// encodings of UseEncoding are switched on towards a peer which decodes
+// them and stay off towards one which never answers. Until the first probe
+// is answered, messages are sent plain. The probe also configures
+// ProbeCapabilities; nil disables both. Must be called before the socket
+// is opened.
+func (conn *Conn) SetCapabilityProbe(p *CapabilityProbe) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if p == nil {
+		conn.capProbe = nil
+		return
+	}
+	probe := p.withDefaults()
+	conn.capProbe = &probe
+}
+
+// Capabilities of the peer, probed with capability hints unless a probe
+// within the TTL answered or gave up. Each hint is repeated after the
+// timeout until the attempts are exhausted; a peer which answers none,
+// e.g. of a release before capability hints, is recorded without
+// capabilities and ErrNoCapabilities returned. Blocks until the probe has
+// finished. Without SetCapabilityProbe, the defaults apply.
+func (conn *Conn) ProbeCapabilities(addr *net.UDPAddr) (Capability, error) {
+	if err := checkAddr(addr); err != nil {
+		return 0, err
+	}
+	conn.mutex.Lock()
+	probe := CapabilityProbe{}.withDefaults()
+	if conn.capProbe != nil {
+		probe = *conn.capProbe
+	}
+	done := conn.done
+	conn.mutex.Unlock()
+
+	peer, _ := conn.peers.lookup(addr)
+	if !peer.CapabilitiesProbed.IsZero() && conn.clock.Now().Before(peer.CapabilitiesProbed.Add(probe.TTL)) {
+		if peer.CapabilityIncarnation == 0 {
+			return 0, ErrNoCapabilities
+		}
+		return peer.Capabilities, nil
+	}
+
+	for attempt := 0; attempt < probe.Attempts; attempt++ {
+		answer := conn.capProbing.wait(addr)
+		if err := conn.AdvertiseCapabilities(addr); err != nil {
+			conn.capProbing.cancel(addr, answer)
+			return 0, err
+		}
+		expired, stop := conn.after("capability probe", probe.Timeout)
+		select {
+		case <-answer:
+			stop()
+			var c Capability
+			now := conn.clock.Now()
+			conn.peers.update(addr, now, func(peer *PeerStats) {
+				peer.CapabilitiesProbed = now
+				c = peer.Capabilities
+			})
+			return c, nil
+		case <-expired:
+			stop()
+			conn.capProbing.cancel(addr, answer)
+		case <-done:
+			stop()
+			conn.capProbing.cancel(addr, answer)
+			return 0, ErrClosedConn
+		}
+	}
+
+	now := conn.clock.Now()
+	conn.peers.update(addr, now, func(peer *PeerStats) {
+		peer.Capabilities, peer.CapabilityIncarnation = 0, 0
+		peer.CapabilitiesProbed = now
+	})
+	return 0, ErrNoCapabilities
+}
+
+// Probe the remote end-point whenever the outcome of the last probe has
+// expired, until done is closed.
+func (conn *Conn) probingCapabilities(probe CapabilityProbe, done <-chan bool) {
+	for {
+		if addr := conn.remoteAddr(); addr != nil {
+			conn.ProbeCapabilities(addr)
+		}
+		expired, stop := conn.after("capability reprobe", probe.TTL)
+		select {
+		case <-expired:
+			stop()
+		case <-done:
+			stop()
+			return
+		}
+	}
+}
+
+// Probes waiting for a capability hint, by peer
+type capabilityWaiters struct {
+	mutex   sync.Mutex
+	waiters map[netip.AddrPort][]chan bool
+}
+
+// Channel which is closed once a hint from the peer arrives.
+func (w *capabilityWaiters) wait(addr *net.UDPAddr) chan bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.waiters == nil {
+		w.waiters = make(map[netip.AddrPort][]chan bool)
+	}
+	c := make(chan bool)
+	key := peerKey(addr)
+	w.waiters[key] = append(w.waiters[key], c)
+	return c
+}
+
+func (w *capabilityWaiters) cancel(addr *net.UDPAddr, c chan bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	key := peerKey(addr)
+	waiting := w.waiters[key]
+	for i := range waiting {
+		if waiting[i] == c {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) == 0 {
+		delete(w.waiters, key)
+	} else {
+		w.waiters[key] = waiting
+	}
+}
+
+func (w *capabilityWaiters) len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	n := 0
+	for _, waiting := range w.waiters {
+		n += len(waiting)
+	}
+	return n
+}
+
+func (w *capabilityWaiters) answered(addr *net.UDPAddr) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	key := peerKey(addr)
+	for _, c := range w.waiters[key] {
+		close(c)
+	}
+	delete(w.waiters, key)
+}
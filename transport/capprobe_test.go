@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Connection listening on loopback which probes with the options
+func startProbing(t *testing.T, probe *CapabilityProbe) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.UseEncoding(CapCompression, flateEncoding{})
+	conn.SetCapabilityProbe(probe)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	<-conn.Events()
+	return conn
+}
+
+// Read the capability hints arriving at the socket until it is quiet.
+func readHints(t *testing.T, sock *net.UDPConn, quiet time.Duration) []wire.Capabilities {
+	var hints []wire.Capabilities
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		sock.SetReadDeadline(time.Now().Add(quiet))
+		n, _, err := sock.ReadFromUDP(buf)
+		if err != nil {
+			return hints
+		}
+		hint, _, err := wire.DecodeCapabilities(buf[:n])
+		if err != nil {
+			t.Fatalf("TestCapabilityProbe expected a capability hint got %q.", buf[:n])
+		}
+		hints = append(hints, hint)
+	}
+}
+
+func TestCapabilityProbeModern(t *testing.T) {
+	capable := startEncoding(t, 0, true)
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.UseEncoding(CapCompression, flateEncoding{})
+	conn.SetCapabilityProbe(&CapabilityProbe{Timeout: 100 * time.Millisecond})
+	if err := conn.Dial(capable.addr.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+
+	// the dialed connection finds out on its own
+	for i := 0; conn.PeerCapabilities(capable.addr) != CapCompression; i++ {
+		if i == 100 {
+			t.Fatalf("TestCapabilityProbeModern expected the probe to be answered.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c, err := conn.ProbeCapabilities(capable.addr); c != CapCompression || err != nil {
+		t.Fatalf("TestCapabilityProbeModern expected the cached capabilities got %b %v.", c, err)
+	}
+	if err := conn.Send(Message("compressed")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-capable.received:
+		if msg != "compressed" || capable.compressed.Load() != 1 {
+			t.Fatalf("TestCapabilityProbeModern expected one compressed message got %q after %d.", msg, capable.compressed.Load())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestCapabilityProbeModern expected the message to arrive.")
+	}
+}
+
+func TestCapabilityProbeVanilla(t *testing.T) {
+	conn := startProbing(t, &CapabilityProbe{Timeout: 20 * time.Millisecond, Attempts: 2, TTL: 300 * time.Millisecond})
+	sock, addr := rawPeer(t)
+
+	if c, err := conn.ProbeCapabilities(addr); c != 0 || err != ErrNoCapabilities {
+		t.Fatalf("TestCapabilityProbeVanilla expected %q got %b %v.", ErrNoCapabilities, c, err)
+	}
+	if hints := readHints(t, sock, 50*time.Millisecond); len(hints) != 2 {
+		t.Fatalf("TestCapabilityProbeVanilla expected 2 probes got %d.", len(hints))
+	}
+
+	// the outcome is cached, and messages stay plain
+	if _, err := conn.ProbeCapabilities(addr); err != ErrNoCapabilities {
+		t.Fatalf("TestCapabilityProbeVanilla expected the cached outcome got %v.", err)
+	}
+	if err := conn.SendTo(Message("plain"), addr); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxDatagramSize)
+	sock.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := sock.ReadFromUDP(buf); err != nil || string(buf[:n]) != "plain" {
+		t.Fatalf("TestCapabilityProbeVanilla expected a plain message got %q %v.", buf[:n], err)
+	}
+
+	// until it expires
+	time.Sleep(300 * time.Millisecond)
+	conn.ProbeCapabilities(addr)
+	if hints := readHints(t, sock, 50*time.Millisecond); len(hints) != 2 {
+		t.Fatalf("TestCapabilityProbeVanilla expected 2 probes after the TTL got %d.", len(hints))
+	}
+}
+
+func TestCapabilityProbeLost(t *testing.T) {
+	conn := startProbing(t, &CapabilityProbe{Timeout: 50 * time.Millisecond})
+	sock, addr := rawPeer(t)
+
+	// the first probe is lost, the second answered
+	probes := make(chan int, 1)
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for i := 1; ; i++ {
+			_, from, err := sock.ReadFromUDP(buf)
+			if err != nil {
+				probes <- i - 1
+				return
+			}
+			if i == 2 {
+				msg, _ := wire.Capabilities{Bits: uint32(CapCompression), Incarnation: 1, Reply: true}.Encode(wire.Current)
+				sock.WriteToUDP(msg, from)
+				probes <- i
+				return
+			}
+		}
+	}()
+
+	if c, err := conn.ProbeCapabilities(addr); c != CapCompression || err != nil {
+		t.Fatalf("TestCapabilityProbeLost expected the retry to be answered got %b %v.", c, err)
+	}
+	if n := <-probes; n != 2 {
+		t.Fatalf("TestCapabilityProbeLost expected 2 probes got %d.", n)
+	}
+	if peer, _ := conn.peers.lookup(addr); peer.CapabilitiesProbed.IsZero() {
+		t.Fatalf("TestCapabilityProbeLost expected the probe to be recorded got %+v.", peer)
+	}
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Source of time for every timer owned by a Conn.
+type Clock interface {
+	// Current time
+	Now() time.Time
+
+	// Returns a channel which receives the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// Returns a ticker which delivers the current time every d.
+	NewTicker(d time.Duration) Ticker
+
+	// Returns a timer which delivers the current time once d has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Periodic timer created by a Clock.
+type Ticker interface {
+	// Channel on which the ticks are delivered
+	C() <-chan time.Time
+
+	// Turn off the ticker. No more ticks will be sent afterwards.
+	Stop()
+}
+
+// One-shot timer created by a Clock which can be reused, unlike the
+// channel of After.
+type Timer interface {
+	// Channel on which the time is delivered
+	C() <-chan time.Time
+
+	// Turn off the timer. Nothing will be sent afterwards.
+	Stop()
+
+	// Discard a time not received yet and start over to fire once d has
+	// elapsed.
+	Reset(d time.Duration)
+}
+
+// Clock backed by the operating system; this is the default of every Conn.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() {
+	t.timer.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) {
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.timer.Reset(d)
+}
+
+// Clock which only moves forward when Advance is called. Timers fire
+// synchronously from within Advance, in the order of their deadlines,
+// so tests can simulate many protocol periods without sleeping.
+type ManualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers manualTimers
+}
+
+// Pending deadline of a ManualClock; period is zero for one-shot timers.
+type manualTimer struct {
+	clock  *ManualClock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+type manualTimers []*manualTimer
+
+func (t manualTimers) Len() int           { return len(t) }
+func (t manualTimers) Less(i, j int) bool { return t[i].when.Before(t[j].when) }
+func (t manualTimers) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Create a manual clock which starts at the specified time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now, timers: make(manualTimers, 0, 8)}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *ManualClock) After(d time.Duration) <-chan time.Time {
+	return clock.schedule(d, 0).c
+}
+
+func (clock *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("transport: non-positive interval for NewTicker")
+	}
+	return clock.schedule(d, d)
+}
+
+func (clock *ManualClock) NewTimer(d time.Duration) Timer {
+	return clock.schedule(d, 0)
+}
+
+// Register a timer which is due once d has elapsed.
+func (clock *ManualClock) schedule(d, period time.Duration) *manualTimer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	t := &manualTimer{clock, clock.now.Add(d), period, make(chan time.Time, 1)}
+	clock.timers = append(clock.timers, t)
+	return t
+}
+
+// Move the clock forward by d and fire every timer which
+// becomes due on the way. Like their real counterparts, ticks are dropped
+// for tickers whose channel has not been drained.
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	target := clock.now.Add(d)
+	for len(clock.timers) > 0 {
+		sort.Sort(clock.timers)
+		t := clock.timers[0]
+		if t.when.After(target) {
+			break
+		}
+
+		clock.now = t.when
+		select {
+		case t.c <- t.when:
+		default:
+		}
+
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			clock.remove(t)
+		}
+	}
+	clock.now = target
+}
+
+// Number of timers which have not fired or been stopped yet.
+func (clock *ManualClock) Pending() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return len(clock.timers)
+}
+
+// Assumes the caller holds the clock's mutex.
+func (clock *ManualClock) remove(t *manualTimer) {
+	for i, other := range clock.timers {
+		if other == t {
+			clock.timers = append(clock.timers[:i], clock.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.clock.remove(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.clock.remove(t)
+	select {
+	case <-t.c:
+	default:
+	}
+	t.when = t.clock.now.Add(d)
+	t.clock.timers = append(t.clock.timers, t)
+}
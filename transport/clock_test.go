@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+// Arbitrary starting point for manual clocks in tests
+var epoch = time.Date(2011, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+func TestManualClockAfter(t *testing.T) {
+	clock := NewManualClock(epoch)
+	c := clock.After(50 * time.Millisecond)
+
+	clock.Advance(49 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatalf("TestManualClockAfter timer fired early at %s", clock.Now())
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case when := <-c:
+		if expected := epoch.Add(50 * time.Millisecond); !when.Equal(expected) {
+			t.Fatalf("TestManualClockAfter expected %s got %s.", expected, when)
+		}
+	default:
+		t.Fatalf("TestManualClockAfter timer did not fire")
+	}
+
+	if clock.Pending() != 0 {
+		t.Fatalf("TestManualClockAfter expected no pending timers got %d.", clock.Pending())
+	}
+}
+
+func TestManualClockTicker(t *testing.T) {
+	clock := NewManualClock(epoch)
+	ticker := clock.NewTicker(time.Second)
+
+	ticks := 0
+	for i := 1; i <= 100; i++ {
+		clock.Advance(time.Second)
+		when := <-ticker.C()
+		if expected := epoch.Add(time.Duration(i) * time.Second); !when.Equal(expected) {
+			t.Fatalf("TestManualClockTicker expected tick at %s got %s.", expected, when)
+		}
+		ticks++
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatalf("TestManualClockTicker tick after Stop")
+	default:
+	}
+
+	if ticks != 100 {
+		t.Fatalf("TestManualClockTicker expected %d ticks got %d.", 100, ticks)
+	}
+}
+
+func TestManualClockTimer(t *testing.T) {
+	clock := NewManualClock(epoch)
+	timer := clock.NewTimer(time.Second)
+
+	// the time of the first deadline is discarded by Reset
+	clock.Advance(time.Second)
+	timer.Reset(time.Second)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case when := <-timer.C():
+		t.Fatalf("TestManualClockTimer stale time %s after Reset", when)
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case when := <-timer.C():
+		if expected := epoch.Add(2 * time.Second); !when.Equal(expected) {
+			t.Fatalf("TestManualClockTimer expected %s got %s.", expected, when)
+		}
+	default:
+		t.Fatalf("TestManualClockTimer timer did not fire after Reset")
+	}
+
+	timer.Reset(time.Second)
+	timer.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Fatalf("TestManualClockTimer timer fired after Stop")
+	default:
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("TestManualClockTimer expected no pending timers got %d.", clock.Pending())
+	}
+}
+
+func TestManualClockOrder(t *testing.T) {
+	clock := NewManualClock(epoch)
+	late := clock.After(30 * time.Second)
+	early := clock.After(10 * time.Second)
+
+	clock.Advance(time.Minute)
+	if when := <-early; !when.Equal(epoch.Add(10 * time.Second)) {
+		t.Fatalf("TestManualClockOrder expected early timer first got %s.", when)
+	}
+	if when := <-late; !when.Equal(epoch.Add(30 * time.Second)) {
+		t.Fatalf("TestManualClockOrder expected late timer second got %s.", when)
+	}
+	if now := clock.Now(); !now.Equal(epoch.Add(time.Minute)) {
+		t.Fatalf("TestManualClockOrder expected now %s got %s.", epoch.Add(time.Minute), now)
+	}
+}
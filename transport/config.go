@@ -0,0 +1,259 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Every option of a Conn in one value, so that many connections can be
+// created from a shared template. The zero value describes a Conn as
+// returned by NewConn, except for PeerTable limits which default to
+// DefaultMaxPeers and DefaultPeerIdle when zero.
+type Config struct {
+	// Source of time, RealClock if nil
+	Clock Clock
+
+	// See SetHandlerLimit and SetDispatchQueue
+	HandlerLimit int
+	Saturation   SaturationPolicy
+	QueueDepth   int
+	QueuePolicy  SaturationPolicy
+
+	// See SetDispatchShards; zero runs every handler in its own goroutine
+	DispatchShards int
+
+	// See SetPacketInfo, SetKernelTimestamps and SetICMPErrors
+	PacketInfo       bool
+	KernelTimestamps bool
+	ICMPErrors       bool
+
+	// See SetPeerTableLimits; a negative PeerIdle disables idle eviction
+	MaxPeers int
+	PeerIdle time.Duration
+
+	// See SetLimits; Limits.Handlers replaces HandlerLimit and Saturation
+	Limits Limits
+
+	// See SetResolver and SetProbe; nil selects the defaults
+	Resolver Resolver
+	Probe    Probe
+
+	// See SetDialer; nil opens direct sockets
+	Dialer Dialer
+
+	// See SetScheduler; nil selects NewFIFOScheduler
+	Scheduler func() Scheduler
+
+	// See SetSeed; zero picks a seed from the current time
+	Seed int64
+
+	// See SetPortOrder
+	PortOrder PortOrder
+
+	// See SetRelistenGrace; zero selects DefaultRelistenGrace
+	RelistenGrace time.Duration
+
+	// See SetEncodeVersion; zero selects CurrentWireVersion
+	EncodeVersion WireVersion
+
+	// See SetSlowHandler; a zero SlowHandler selects DefaultSlowHandler
+	// and DefaultSlowHandlerInterval, a negative one turns the events off
+	SlowHandler         time.Duration
+	SlowHandlerInterval time.Duration
+
+	// See SetHandlerErrorEvents
+	HandlerErrorEvents bool
+
+	// See SetDatagramSize; zero selects MessageSize
+	DatagramSize int
+
+	// See SetPathMTUDiscovery and SetPathProbing; zero durations select
+	// DefaultPathProbeTimeout and DefaultPathReprobe
+	PathMTUDiscovery bool
+	PathProbeTimeout time.Duration
+	PathReprobe      time.Duration
+
+	// See SetCapabilityProbe; nil disables probing
+	CapabilityProbe *CapabilityProbe
+
+	// Registered in order with Use, UseLayer and UseEgress
+	Ingress []Middleware
+	Layers  []Layer
+	Egress  []Middleware
+
+	// See SetBroadcast and SetBroadcastOptions. A zero BroadcastRate
+	// selects DefaultBroadcastRate and DefaultBroadcastBurst, a negative
+	// one lifts the cap.
+	Broadcast           bool
+	AllowBroadcastLoops bool
+	BroadcastRate       float64
+	BroadcastBurst      int
+}
+
+// Option of a Config which cannot be applied
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config: %s %s", e.Field, e.Reason)
+}
+
+// Returns a copy which shares no slices or CapabilityProbe with the
+// original, so that changes to one do not leak into the other. Clocks,
+// resolvers, probes, middleware and layers themselves are shared.
+func (cfg *Config) Clone() *Config {
+	c := *cfg
+	c.Ingress = append([]Middleware(nil), cfg.Ingress...)
+	c.Layers = append([]Layer(nil), cfg.Layers...)
+	c.Egress = append([]Middleware(nil), cfg.Egress...)
+	if cfg.CapabilityProbe != nil {
+		probe := *cfg.CapabilityProbe
+		c.CapabilityProbe = &probe
+	}
+	return &c
+}
+
+// Check every option; the error is a *ConfigError naming the first
+// offending field.
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.HandlerLimit < 0:
+		return &ConfigError{"HandlerLimit", "must not be negative"}
+	case !cfg.Saturation.valid():
+		return &ConfigError{"Saturation", "is not a SaturationPolicy"}
+	case cfg.QueueDepth < 0:
+		return &ConfigError{"QueueDepth", "must not be negative"}
+	case !cfg.QueuePolicy.valid():
+		return &ConfigError{"QueuePolicy", "is not a SaturationPolicy"}
+	case cfg.DispatchShards < 0:
+		return &ConfigError{"DispatchShards", "must not be negative"}
+	case cfg.PortOrder != PortsAscending && cfg.PortOrder != PortsRandom:
+		return &ConfigError{"PortOrder", "is not a PortOrder"}
+	case cfg.RelistenGrace < 0:
+		return &ConfigError{"RelistenGrace", "must not be negative"}
+	case cfg.EncodeVersion != 0 && !wire.Version(cfg.EncodeVersion).Supported():
+		return &ConfigError{"EncodeVersion", "is not a supported wire version"}
+	case cfg.MaxPeers < 0:
+		return &ConfigError{"MaxPeers", "must not be negative"}
+	case cfg.Limits.Handlers > 0 && cfg.HandlerLimit > 0:
+		return &ConfigError{"HandlerLimit", "must not be set along with Limits.Handlers"}
+	case cfg.DatagramSize < 0 || cfg.DatagramSize > MaxDatagramSize:
+		return &ConfigError{"DatagramSize", fmt.Sprintf("must be between 0 and %d", MaxDatagramSize)}
+	case !cfg.PathMTUDiscovery && (cfg.PathProbeTimeout != 0 || cfg.PathReprobe != 0):
+		return &ConfigError{"PathMTUDiscovery", "must be set for path probing options"}
+	case cfg.PathProbeTimeout < 0:
+		return &ConfigError{"PathProbeTimeout", "must not be negative"}
+	case cfg.PathReprobe < 0:
+		return &ConfigError{"PathReprobe", "must not be negative"}
+	case !cfg.Broadcast && (cfg.AllowBroadcastLoops || cfg.BroadcastRate != 0 || cfg.BroadcastBurst != 0):
+		return &ConfigError{"Broadcast", "must be set for broadcast options"}
+	case cfg.BroadcastBurst < 0:
+		return &ConfigError{"BroadcastBurst", "must not be negative"}
+	}
+	if err := cfg.Limits.validate(); err != nil {
+		return err
+	}
+	for i, m := range cfg.Ingress {
+		if m == nil {
+			return &ConfigError{fmt.Sprintf("Ingress[%d]", i), "is nil"}
+		}
+	}
+	for i, l := range cfg.Layers {
+		if l == nil {
+			return &ConfigError{fmt.Sprintf("Layers[%d]", i), "is nil"}
+		}
+	}
+	for i, m := range cfg.Egress {
+		if m == nil {
+			return &ConfigError{fmt.Sprintf("Egress[%d]", i), "is nil"}
+		}
+	}
+	return nil
+}
+
+func (p SaturationPolicy) valid() bool {
+	return p == WaitWhenSaturated || p == DropWhenSaturated
+}
+
+// Create a connection with the options of the validated config, without
+// opening the socket yet. The connection keeps no reference to cfg.
+func NewConnFromConfig(cfg *Config) (*Conn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	conn := NewConn()
+	if cfg.Clock != nil {
+		conn.SetClock(cfg.Clock)
+	}
+	conn.SetHandlerLimit(cfg.HandlerLimit, cfg.Saturation)
+	conn.SetDispatchQueue(cfg.QueueDepth, cfg.QueuePolicy)
+	conn.SetDispatchShards(cfg.DispatchShards)
+	conn.SetPacketInfo(cfg.PacketInfo)
+	conn.SetKernelTimestamps(cfg.KernelTimestamps)
+	conn.SetICMPErrors(cfg.ICMPErrors)
+
+	maxPeers, idle := cfg.MaxPeers, cfg.PeerIdle
+	if maxPeers == 0 {
+		maxPeers = DefaultMaxPeers
+	}
+	switch {
+	case idle == 0:
+		idle = DefaultPeerIdle
+	case idle < 0:
+		idle = 0
+	}
+	conn.SetPeerTableLimits(maxPeers, idle)
+	conn.SetLimits(cfg.Limits)
+
+	if cfg.Resolver != nil {
+		conn.SetResolver(cfg.Resolver)
+	}
+	conn.SetProbe(cfg.Probe)
+	conn.SetDialer(cfg.Dialer)
+	conn.SetScheduler(cfg.Scheduler)
+	if cfg.Seed != 0 {
+		conn.SetSeed(cfg.Seed)
+	}
+	conn.SetPortOrder(cfg.PortOrder)
+	conn.SetRelistenGrace(cfg.RelistenGrace)
+	if cfg.EncodeVersion != 0 {
+		conn.SetEncodeVersion(cfg.EncodeVersion)
+	}
+	if cfg.SlowHandler != 0 {
+		conn.SetSlowHandler(cfg.SlowHandler, cfg.SlowHandlerInterval)
+	}
+	conn.SetHandlerErrorEvents(cfg.HandlerErrorEvents)
+	if cfg.DatagramSize > 0 {
+		conn.SetDatagramSize(cfg.DatagramSize)
+	}
+	conn.SetPathMTUDiscovery(cfg.PathMTUDiscovery)
+	conn.SetPathProbing(cfg.PathProbeTimeout, cfg.PathReprobe)
+	conn.SetCapabilityProbe(cfg.CapabilityProbe)
+
+	for _, m := range cfg.Ingress {
+		conn.Use(m)
+	}
+	for _, l := range cfg.Layers {
+		conn.UseLayer(l)
+	}
+	for _, m := range cfg.Egress {
+		conn.UseEgress(m)
+	}
+
+	if cfg.Broadcast {
+		conn.SetBroadcast(true)
+		if cfg.AllowBroadcastLoops || cfg.BroadcastRate != 0 {
+			rate, burst := cfg.BroadcastRate, cfg.BroadcastBurst
+			if rate == 0 {
+				rate, burst = DefaultBroadcastRate, DefaultBroadcastBurst
+			}
+			conn.SetBroadcastOptions(!cfg.AllowBroadcastLoops, rate, burst)
+		}
+	}
+	return conn, nil
+}
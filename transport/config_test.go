@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfigClone(t *testing.T) {
+	template := &Config{
+		HandlerLimit: 4,
+		QueueDepth:   16,
+		DatagramSize: 400,
+		Layers:       []Layer{headerLayer(12)},
+		MaxPeers:     64,
+		PeerIdle:     time.Minute,
+	}
+
+	first, err := NewConnFromConfig(template)
+	if err != nil {
+		t.Fatalf("TestConfigClone unexpected error %v", err)
+	}
+
+	tweaked := template.Clone()
+	tweaked.Layers[0] = headerLayer(40)
+	tweaked.Layers = append(tweaked.Layers, headerLayer(8))
+	tweaked.QueueDepth = 64
+	second, err := NewConnFromConfig(tweaked)
+	if err != nil {
+		t.Fatalf("TestConfigClone unexpected error %v", err)
+	}
+
+	if l := template.Layers; len(l) != 1 || l[0] != headerLayer(12) || template.QueueDepth != 16 {
+		t.Fatalf("TestConfigClone template was modified: %+v", template)
+	}
+	if n := first.MaxPayload(); n != 388 {
+		t.Fatalf("TestConfigClone expected first payload 388 got %d.", n)
+	}
+	if n := second.MaxPayload(); n != 352 {
+		t.Fatalf("TestConfigClone expected second payload 352 got %d.", n)
+	}
+	if cap(first.in) != 16 || cap(second.in) != 64 || cap(first.handlerSlots) != 4 {
+		t.Fatalf("TestConfigClone expected queue depths 16 and 64 got %d and %d.", cap(first.in), cap(second.in))
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg   Config
+		field string
+	}{
+		{Config{}, ""},
+		{Config{Broadcast: true, BroadcastRate: -1}, ""},
+		{Config{HandlerLimit: -1}, "HandlerLimit"},
+		{Config{Saturation: 7}, "Saturation"},
+		{Config{QueueDepth: -4}, "QueueDepth"},
+		{Config{QueuePolicy: -1}, "QueuePolicy"},
+		{Config{EncodeVersion: CurrentWireVersion + 1}, "EncodeVersion"},
+		{Config{MaxPeers: -1}, "MaxPeers"},
+		{Config{Limits: Limits{Peers: -1}}, "Limits.Peers"},
+		{Config{Limits: Limits{Handlers: 2}, HandlerLimit: 2}, "HandlerLimit"},
+		{Config{RelistenGrace: -time.Second}, "RelistenGrace"},
+		{Config{DatagramSize: MaxDatagramSize + 1}, "DatagramSize"},
+		{Config{PathReprobe: time.Minute}, "PathMTUDiscovery"},
+		{Config{PathMTUDiscovery: true, PathProbeTimeout: -1}, "PathProbeTimeout"},
+		{Config{BroadcastRate: 10}, "Broadcast"},
+		{Config{Broadcast: true, BroadcastBurst: -1}, "BroadcastBurst"},
+		{Config{Layers: []Layer{headerLayer(1), nil}}, "Layers[1]"},
+		{Config{Egress: []Middleware{nil}}, "Egress[0]"},
+	}
+	for _, test := range tests {
+		err := test.cfg.Validate()
+		var cfgErr *ConfigError
+		switch {
+		case test.field == "" && err != nil:
+			t.Errorf("TestConfigValidate unexpected error %v", err)
+		case test.field != "" && (!errors.As(err, &cfgErr) || cfgErr.Field != test.field):
+			t.Errorf("TestConfigValidate expected error for %s got %v.", test.field, err)
+		}
+	}
+
+	if _, err := NewConnFromConfig(&Config{QueueDepth: -1}); err == nil {
+		t.Fatalf("TestConfigValidate expected NewConnFromConfig to validate")
+	}
+}
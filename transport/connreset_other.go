@@ -0,0 +1,16 @@
+//go:build !windows
+
+package transport
+
+import (
+	"net"
+)
+
+// Only Windows reports ICMP errors on reads from unconnected sockets.
+func disableConnReset(sock *net.UDPConn) error {
+	return nil
+}
+
+func isConnReset(err error) bool {
+	return false
+}
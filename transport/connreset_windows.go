@@ -0,0 +1,37 @@
+//go:build windows
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Stop Windows from failing the next read with WSAECONNRESET after an ICMP
+// port unreachable for an earlier datagram, which would otherwise look like
+// a broken socket to the receiving loop.
+func disableConnReset(sock *net.UDPConn) error {
+	raw, err := sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ioctlErr error
+	if err = raw.Control(func(fd uintptr) {
+		enabled := uint32(0)
+		var returned uint32
+		ioctlErr = syscall.WSAIoctl(syscall.Handle(fd), syscall.SIO_UDP_CONNRESET,
+			(*byte)(unsafe.Pointer(&enabled)), uint32(unsafe.Sizeof(enabled)), nil, 0, &returned, nil, 0)
+	}); err != nil {
+		return err
+	}
+	return ioctlErr
+}
+
+// Determine if a read failed only because of an earlier ICMP error; the
+// socket remains usable.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.WSAECONNRESET)
+}
@@ -0,0 +1,52 @@
+//go:build windows
+
+package transport
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConnResetClassification(t *testing.T) {
+	err := &net.OpError{Op: "read", Net: "udp", Err: syscall.WSAECONNRESET}
+	if !isConnReset(err) {
+		t.Fatalf("TestConnResetClassification expected %v to be transient", err)
+	}
+	if isConnReset(net.ErrClosed) {
+		t.Fatalf("TestConnResetClassification expected %v to be fatal", net.ErrClosed)
+	}
+}
+
+// Manual repro without the fix: comment out disableConnReset in Conn.open
+// and the listener dies with WSAECONNRESET after the first send.
+func TestConnResetKeepsListener(t *testing.T) {
+	closed, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := closed.LocalAddr().(*net.UDPAddr)
+	closed.Close()
+
+	server := startServer(t, 0)
+	defer server.Disconnect()
+	for i := 0; i < 3; i++ {
+		if err := server.SendTo([]byte(expectedRequest), dst); err != nil {
+			t.Fatalf("TestConnResetKeepsListener cannot send: %s", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	reply = make(chan Message, 1)
+	client := startClient(t, portOf(server))
+	defer client.Disconnect()
+	select {
+	case msg := <-reply:
+		if string(msg) != expectedReply {
+			t.Fatalf("TestConnResetKeepsListener expected %q got %q.", expectedReply, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestConnResetKeepsListener no reply from %s", server.sock.LocalAddr())
+	}
+}
@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a Conn believes it owns right now, see DebugDump
+type DebugReport struct {
+	State     State
+	LocalAddr net.Addr
+
+	// Background goroutines by role and the timers they wait for, in the
+	// order they fire
+	Goroutines []DebugGoroutine
+	Timers     []DebugTimer
+
+	// Items waiting in each queue, along with its capacity if bounded
+	Queues []DebugQueue
+
+	// Live objects per subsystem, such as peers tracked or mirrors
+	Objects []DebugObjects
+}
+
+type DebugGoroutine struct {
+	Role  string
+	Count int
+}
+
+type DebugTimer struct {
+	Purpose string
+	Fires   time.Time
+}
+
+type DebugQueue struct {
+	Name            string
+	Depth, Capacity int
+}
+
+type DebugObjects struct {
+	Subsystem string
+	Count     int
+}
+
+// Text for a bug report, one line per entry.
+func (r *DebugReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "state: %s", r.State)
+	if r.LocalAddr != nil {
+		fmt.Fprintf(&b, " %s", r.LocalAddr)
+	}
+	b.WriteString("\ngoroutines:\n")
+	for _, g := range r.Goroutines {
+		fmt.Fprintf(&b, "  %s: %d\n", g.Role, g.Count)
+	}
+	b.WriteString("timers:\n")
+	for _, t := range r.Timers {
+		fmt.Fprintf(&b, "  %s: %s\n", t.Purpose, t.Fires.Format(time.RFC3339Nano))
+	}
+	b.WriteString("queues:\n")
+	for _, q := range r.Queues {
+		if q.Capacity > 0 {
+			fmt.Fprintf(&b, "  %s: %d/%d\n", q.Name, q.Depth, q.Capacity)
+		} else {
+			fmt.Fprintf(&b, "  %s: %d\n", q.Name, q.Depth)
+		}
+	}
+	b.WriteString("objects:\n")
+	for _, o := range r.Objects {
+		fmt.Fprintf(&b, "  %s: %d\n", o.Subsystem, o.Count)
+	}
+	return b.String()
+}
+
+// Report the goroutines and timers of the connection, as registered where
+// they are started rather than found by parsing stacks, its queues and
+// its objects. After Disconnect only what outlives the socket remains,
+// e.g. handlers which have not returned yet or mirrors not detached.
+func (conn *Conn) DebugDump() *DebugReport {
+	conn.mutex.Lock()
+	r := &DebugReport{State: conn.state}
+	if conn.sock != nil {
+		r.LocalAddr = conn.sock.LocalAddr()
+	}
+	in, events, shards := conn.in, conn.events, conn.shards
+	handlers, raw := len(conn.handlers), len(conn.rawHandlers)
+	middleware := len(conn.ingress) + len(conn.layers) + len(conn.egress)
+	mirrors, retiring := len(conn.mirrors), len(conn.retiring)
+	conn.mutex.Unlock()
+
+	r.Goroutines, r.Timers = conn.debug.snapshot()
+	r.Queues = []DebugQueue{
+		{"dispatch", len(in), cap(in)},
+		{"unsent", conn.unsent.Count(), conn.Limits().Unsent},
+		{"events", len(events), cap(events)},
+	}
+	for i, jobs := range shards {
+		r.Queues = append(r.Queues, DebugQueue{fmt.Sprintf("shard %d", i), len(jobs), cap(jobs)})
+	}
+	r.Objects = []DebugObjects{
+		{"peers", int(conn.peers.size.Load())},
+		{"handlers", handlers},
+		{"raw handlers", raw},
+		{"middleware", middleware},
+		{"mirrors", mirrors},
+		{"retiring sockets", retiring},
+		{"path probes", conn.paths.len()},
+		{"capability probes", conn.capProbing.len()},
+	}
+	return r
+}
+
+// Goroutines and timers registered by the spawning code
+type debugRegistry struct {
+	mutex      sync.Mutex
+	goroutines map[string]int
+	timers     map[*debugTimer]bool
+}
+
+// Timer listed by DebugDump until stopped
+type debugTimer struct {
+	registry *debugRegistry
+	purpose  string
+	fires    time.Time
+}
+
+// Run f in a goroutine listed under the role while it runs.
+func (r *debugRegistry) spawn(role string, f func()) {
+	r.started(role)
+	go func() {
+		defer r.exited(role)
+		f()
+	}()
+}
+
+// List a goroutine under the role until exited is called; for goroutines
+// which are started without spawn, e.g. pooled handler jobs.
+func (r *debugRegistry) started(role string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.goroutines == nil {
+		r.goroutines = make(map[string]int)
+	}
+	r.goroutines[role]++
+}
+
+func (r *debugRegistry) exited(role string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.goroutines[role]--; r.goroutines[role] == 0 {
+		delete(r.goroutines, role)
+	}
+}
+
+func (r *debugRegistry) timer(purpose string, fires time.Time) *debugTimer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.timers == nil {
+		r.timers = make(map[*debugTimer]bool)
+	}
+	t := &debugTimer{r, purpose, fires}
+	r.timers[t] = true
+	return t
+}
+
+// Move the next fire time, e.g. of a ticker.
+func (t *debugTimer) reset(fires time.Time) {
+	t.registry.mutex.Lock()
+	defer t.registry.mutex.Unlock()
+	t.fires = fires
+}
+
+func (t *debugTimer) stop() {
+	t.registry.mutex.Lock()
+	defer t.registry.mutex.Unlock()
+	delete(t.registry.timers, t)
+}
+
+func (r *debugRegistry) snapshot() ([]DebugGoroutine, []DebugTimer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	goroutines := make([]DebugGoroutine, 0, len(r.goroutines))
+	for role, n := range r.goroutines {
+		goroutines = append(goroutines, DebugGoroutine{role, n})
+	}
+	sort.Slice(goroutines, func(i, j int) bool { return goroutines[i].Role < goroutines[j].Role })
+	timers := make([]DebugTimer, 0, len(r.timers))
+	for t := range r.timers {
+		timers = append(timers, DebugTimer{t.purpose, t.fires})
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		if !timers[i].Fires.Equal(timers[j].Fires) {
+			return timers[i].Fires.Before(timers[j].Fires)
+		}
+		return timers[i].Purpose < timers[j].Purpose
+	})
+	return goroutines, timers
+}
+
+// Start the background process under the role, see DebugDump.
+func (conn *Conn) spawnRole(role string, f func()) {
+	conn.debug.spawn(role, f)
+}
+
+// Channel like Clock.After which DebugDump lists for its purpose until
+// stop is called.
+func (conn *Conn) after(purpose string, d time.Duration) (<-chan time.Time, func()) {
+	t := conn.debug.timer(purpose, conn.clock.Now().Add(d))
+	return conn.clock.After(d), t.stop
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func goroutines(r *DebugReport) map[string]int {
+	roles := make(map[string]int)
+	for _, g := range r.Goroutines {
+		roles[g.Role] = g.Count
+	}
+	return roles
+}
+
+func TestDebugDump(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0).UTC())
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetClock(clock)
+	conn.SetDispatchShards(2)
+	conn.SetRelistenGrace(time.Minute)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.Events()
+
+	r := conn.DebugDump()
+	if roles := goroutines(r); len(roles) != 4 || roles["sending"] != 1 || roles["dispatching"] != 1 || roles["receiving"] != 1 || roles["shard"] != 2 {
+		t.Fatalf("TestDebugDump unexpected goroutines %v.", r.Goroutines)
+	}
+	if len(r.Timers) != 0 || r.State != Listening || r.LocalAddr == nil {
+		t.Fatalf("TestDebugDump unexpected report\n%s", r)
+	}
+
+	// a replaced socket and a read with a deadline each wait for a timer
+	if err := conn.Relisten(0); err != nil {
+		t.Fatal(err)
+	}
+	pc := conn.PacketConn(DeliverAll)
+	pc.SetReadDeadline(clock.Now().Add(30 * time.Second))
+	go pc.ReadFrom(make([]byte, 16))
+	for i := 0; len(conn.DebugDump().Timers) < 2; i++ {
+		if i == 100 {
+			t.Fatalf("TestDebugDump expected 2 timers got\n%s", conn.DebugDump())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r = conn.DebugDump()
+	expected := []DebugTimer{
+		{"packet conn read deadline", time.Unix(30, 0).UTC()},
+		{"relisten grace", time.Unix(60, 0).UTC()},
+	}
+	for i, timer := range expected {
+		if r.Timers[i].Purpose != timer.Purpose || !r.Timers[i].Fires.Equal(timer.Fires) {
+			t.Fatalf("TestDebugDump expected %v got %v.", expected, r.Timers)
+		}
+	}
+	if roles := goroutines(r); roles["receiving"] != 2 || roles["retire"] != 1 {
+		t.Fatalf("TestDebugDump expected the retiring socket to be listed got %v.", r.Goroutines)
+	}
+	text := r.String()
+	for _, line := range []string{"state: Listening", "  relisten grace: 1970-01-01T00:01:00Z", "  shard: 2", "  retiring sockets: 1"} {
+		if !strings.Contains(text, line) {
+			t.Fatalf("TestDebugDump expected %q in\n%s", line, text)
+		}
+	}
+
+	// nothing is left once the connection is gone
+	pc.Close()
+	conn.Disconnect()
+	for i := 0; ; i++ {
+		r = conn.DebugDump()
+		if len(r.Goroutines) == 0 && len(r.Timers) == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("TestDebugDump expected nothing after Disconnect got\n%s", r)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, o := range r.Objects {
+		if o.Count != 0 {
+			t.Fatalf("TestDebugDump expected no %s after Disconnect got %d.", o.Subsystem, o.Count)
+		}
+	}
+	if r.State != Closed || r.LocalAddr != nil {
+		t.Fatalf("TestDebugDump unexpected report after Disconnect\n%s", r)
+	}
+}
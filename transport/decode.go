@@ -0,0 +1,13 @@
+package transport
+
+import "github.com/ahorn/gossip/internal/wire"
+
+var (
+	ErrTruncated      = wire.ErrTruncated
+	ErrLengthExceeded = wire.ErrLengthExceeded
+)
+
+// Describes why a datagram could not be decoded. Decoders never panic on
+// malformed input; they return a *DecodeError and only the offending
+// packet is discarded.
+type DecodeError = wire.DecodeError
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// Every decoder of this package is registered here so that it is covered
+// by TestFuzzDecoders; those of the wire messages are fuzzed in
+// internal/wire.
+var fuzzDecoders = map[string]func(b []byte) error{
+	"socks5": func(b []byte) error {
+		_, _, err := socksUnwrap(b)
+		return err
+	},
+}
+
+// Valid inputs which are mutated to reach deeper into the decoders
+var fuzzCorpus = [][]byte{
+	[]byte{},
+	socksWrap(Message("ping"), &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 7946}),
+	[]byte{0, 0, 0, socksIPv6, 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1f, 0x0a, 'h', 'i'},
+}
+
+func TestFuzzDecoders(t *testing.T) {
+	rnd := rand.New(rand.NewSource(382))
+	for name, decode := range fuzzDecoders {
+		for i := 0; i < 10000; i++ {
+			var b []byte
+			if i%2 == 0 {
+				b = make([]byte, rnd.Intn(MessageSize))
+				for j := range b {
+					b[j] = byte(rnd.Intn(256))
+				}
+			} else {
+				b = mutate(rnd, fuzzCorpus[rnd.Intn(len(fuzzCorpus))])
+			}
+			err, msg := decodeSafely(decode, b)
+			if msg != "" {
+				t.Fatalf("TestFuzzDecoders %s panicked on %x: %s", name, b, msg)
+			}
+			if _, ok := err.(*DecodeError); err != nil && !ok {
+				t.Fatalf("TestFuzzDecoders expected %s to fail with a *DecodeError on %x, got %v", name, b, err)
+			}
+		}
+	}
+}
+
+// Returns the error of decode, or the panic message if it panicked.
+func decodeSafely(decode func([]byte) error, b []byte) (err error, msg string) {
+	defer func() {
+		if x := recover(); x != nil {
+			msg = fmt.Sprint(x)
+		}
+	}()
+	return decode(b), ""
+}
+
+// Copy b with a few random bytes flipped, inserted or removed.
+func mutate(rnd *rand.Rand, b []byte) []byte {
+	m := make([]byte, len(b), len(b)+4)
+	copy(m, b)
+	for n := rnd.Intn(4); n >= 0; n-- {
+		switch {
+		case len(m) > 0 && rnd.Intn(3) == 0:
+			i := rnd.Intn(len(m))
+			m[i] ^= byte(1 << uint(rnd.Intn(8)))
+		case len(m) > 0 && rnd.Intn(2) == 0:
+			m = m[:rnd.Intn(len(m))]
+		default:
+			m = append(m, byte(rnd.Intn(256)))
+		}
+	}
+	return m
+}
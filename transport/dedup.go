@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Longest horizon SetOutboundDedup accepts; it stays below the shortest
+// retry interval the gossip package uses by default, so that a layer
+// which resends without marking PacketMeta.Repeat is delayed rather than
+// silenced.
+const MaxDedupHorizon = 40 * time.Millisecond
+
+// Recent messages remembered per peer
+const DedupEntries = 8
+
+// Drop a message queued for a peer which was handed the same message within
+// the horizon, e.g. a rumor several fanout rounds picked the same member
+// for. Suppressed messages count as sent: SendTo returns nil and the
+// callback of SendToAsync gets a nil error. Stats.DedupSuppressed counts
+// them.
+//
+// The id function names the messages which may be suppressed; those for
+// which it returns false are always sent. A nil function compares the
+// messages byte for byte (by a hash of them). Replies and packets sent
+// with PacketMeta.Repeat are never suppressed, so acknowledgements and
+// deliberate retransmissions go out whatever the horizon. A zero horizon
+// turns the check off; a ConfigError reports one outside
+// [0, MaxDedupHorizon].
+func (conn *Conn) SetOutboundDedup(horizon time.Duration, id func(Message) (uint64, bool)) error {
+	if horizon < 0 || horizon > MaxDedupHorizon {
+		return &ConfigError{"horizon", "must be between zero and MaxDedupHorizon"}
+	}
+	var d *outboundDedup
+	if horizon > 0 {
+		d = &outboundDedup{horizon: horizon, id: id, seed: maphash.MakeSeed(), peers: make(map[netip.AddrPort]*dedupRing)}
+	}
+	conn.mutex.Lock()
+	conn.dedup = d
+	conn.mutex.Unlock()
+	return nil
+}
+
+type outboundDedup struct {
+	horizon time.Duration
+	id      func(Message) (uint64, bool)
+	seed    maphash.Seed
+
+	mutex sync.Mutex
+	peers map[netip.AddrPort]*dedupRing
+	swept time.Time
+}
+
+// Last messages handed to a peer and when
+type dedupRing struct {
+	ids  [DedupEntries]uint64
+	sent [DedupEntries]time.Time
+	next int
+	last time.Time
+}
+
+// Returns true if the message is a duplicate within the horizon; otherwise
+// it is remembered as sent now.
+func (d *outboundDedup) suppress(addr *net.UDPAddr, msg Message, now time.Time) bool {
+	var id uint64
+	if d.id == nil {
+		id = maphash.Bytes(d.seed, msg)
+	} else if i, ok := d.id(msg); ok {
+		id = i
+	} else {
+		return false
+	}
+	key := peerKey(addr)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Sub(d.swept) >= d.horizon {
+		for k, r := range d.peers {
+			if now.Sub(r.last) >= d.horizon {
+				delete(d.peers, k)
+			}
+		}
+		d.swept = now
+	}
+	r := d.peers[key]
+	if r == nil {
+		r = new(dedupRing)
+		d.peers[key] = r
+	}
+	for i := range r.ids {
+		if r.ids[i] == id && !r.sent[i].IsZero() && now.Sub(r.sent[i]) < d.horizon {
+			return true
+		}
+	}
+	r.ids[r.next], r.sent[r.next] = id, now
+	r.next = (r.next + 1) % DedupEntries
+	r.last = now
+	return false
+}
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Messages a raw socket received by the deadline
+func readAll(t *testing.T, sock *net.UDPConn, deadline time.Duration) []string {
+	var msgs []string
+	buff := make([]byte, MessageSize)
+	sock.SetReadDeadline(time.Now().Add(deadline))
+	for {
+		n, _, err := sock.ReadFromUDP(buff)
+		if err != nil {
+			return msgs
+		}
+		msgs = append(msgs, string(buff[:n]))
+	}
+}
+
+func TestOutboundDedup(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := listenLimited(t, Limits{}, func(conn *Conn) {
+		conn.SetClock(clock)
+		if err := conn.SetOutboundDedup(MaxDedupHorizon, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	sock, addr := rawPeer(t)
+	other, otherAddr := rawPeer(t)
+
+	conn.SendTo(Message("rumor"), addr)
+	conn.SendTo(Message("rumor"), addr)
+	conn.Multisend(Message("rumor"), []*net.UDPAddr{addr, otherAddr})
+	clock.Advance(MaxDedupHorizon)
+	conn.SendTo(Message("rumor"), addr)
+
+	if msgs := readAll(t, sock, 100*time.Millisecond); len(msgs) != 2 {
+		t.Fatalf("TestOutboundDedup expected 2 copies within and after the horizon got %q.", msgs)
+	}
+	if msgs := readAll(t, other, 10*time.Millisecond); len(msgs) != 1 {
+		t.Fatalf("TestOutboundDedup expected 1 copy to the other peer got %q.", msgs)
+	}
+	if n := conn.Stats().DedupSuppressed; n != 2 {
+		t.Fatalf("TestOutboundDedup expected 2 suppressed got %d.", n)
+	}
+}
+
+func TestOutboundDedupRepeat(t *testing.T) {
+	conn := listenLimited(t, Limits{}, func(conn *Conn) {
+		conn.SetClock(NewManualClock(time.Unix(0, 0)))
+		id := func(msg Message) (uint64, bool) { return uint64(msg[0]), msg[0] != 'x' }
+		if err := conn.SetOutboundDedup(MaxDedupHorizon, id); err != nil {
+			t.Fatal(err)
+		}
+	})
+	sock, addr := rawPeer(t)
+
+	conn.SendTo(Message("a1"), addr)
+	conn.SendTo(Message("a2"), addr)
+	conn.SendScheduled(Message("a1"), addr, PacketMeta{Repeat: true})
+	conn.SendTo(Message("x1"), addr)
+	conn.SendTo(Message("x1"), addr)
+	done := make(chan error, 1)
+	conn.SendToAsync(Message("a3"), addr, func(err error) { done <- err })
+
+	if msgs := readAll(t, sock, 100*time.Millisecond); len(msgs) != 4 {
+		t.Fatalf("TestOutboundDedupRepeat expected a1, a1 and x1 twice got %q.", msgs)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("TestOutboundDedupRepeat expected a suppressed message to complete got %v.", err)
+	}
+	if err := conn.SetOutboundDedup(time.Second, nil); err == nil {
+		t.Fatalf("TestOutboundDedupRepeat expected a horizon above MaxDedupHorizon to be rejected.")
+	}
+}
@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Time Diagnose waits for the replies after the last echo was sent
+const DefaultDiagnoseTimeout = time.Second
+
+var ErrDiagnoseCount = errors.New("Diagnose needs at least one echo")
+
+// Outcome of Diagnose
+type Diagnosis struct {
+	Sent, Received int
+
+	// Fraction of the echoes which went unanswered
+	Loss float64
+
+	// Round-trip times of the answered echoes
+	MinRTT, MeanRTT, MedianRTT, P90RTT, MaxRTT time.Duration
+
+	// Source address the responder saw and the local address of the
+	// socket; Translated is set if they differ, i.e. a NAT rewrote the
+	// address on the way. Observed is invalid if no echo was answered.
+	Observed   netip.AddrPort
+	Local      netip.AddrPort
+	Translated bool
+}
+
+// Answer diagnostic echoes, which Diagnose sends, with at most rate per
+// second and bursts of burst, so that the responder cannot be used to
+// amplify traffic; echoes above the rate are dropped and counted in
+// Stats.EchoesLimited. A zero rate turns the responder off, which is the
+// default. Echoes are consumed after the ingress middleware, so an
+// authenticating middleware keeps the responder to its members, and never
+// reach the handlers.
+func (conn *Conn) SetEchoResponder(rate float64, burst int) {
+	var limit *tokenBucket
+	if rate > 0 {
+		limit = newTokenBucket(rate, burst)
+	}
+	conn.echoes.mutex.Lock()
+	conn.echoes.limit = limit
+	conn.echoes.mutex.Unlock()
+}
+
+// Send count echoes to the responder at addr, one per interval, and
+// report loss, round-trip times and whether a NAT translated the source
+// address. Waits DefaultDiagnoseTimeout after the last echo for the
+// replies. Echoes bypass the egress middleware like SendRaw.
+func (conn *Conn) Diagnose(addr *net.UDPAddr, count int, interval time.Duration) (Diagnosis, error) {
+	if count < 1 {
+		return Diagnosis{}, ErrDiagnoseCount
+	}
+	local := conn.LocalAddr()
+	if local == nil {
+		return Diagnosis{}, ErrNotConnected
+	}
+	conn.mutex.Lock()
+	done := conn.done
+	conn.mutex.Unlock()
+
+	base := conn.Rand().Uint64()
+	replies := conn.echoes.start(base, count)
+	defer conn.echoes.finish(base)
+	v := wire.Version(conn.EncodeVersion())
+	sent := make([]time.Time, count)
+	rtts := make([]time.Duration, 0, count)
+	var observed netip.AddrPort
+
+	// replies arriving while the burst is sent are collected in between
+	collect := func(r echoReply) {
+		if i := int(r.id - base); i >= 0 && i < count && !sent[i].IsZero() {
+			rtts = append(rtts, r.at.Sub(sent[i]))
+			sent[i] = time.Time{}
+			observed = r.observed
+		}
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			wait, stop := conn.after("diagnose interval", interval)
+		waiting:
+			for {
+				select {
+				case r := <-replies:
+					collect(r)
+				case <-wait:
+					stop()
+					break waiting
+				case <-done:
+					stop()
+					return Diagnosis{}, ErrClosedConn
+				}
+			}
+		}
+		msg, err := wire.Echo{ID: base + uint64(i), Payload: []byte("gossip diagnose")}.Encode(v)
+		if err != nil {
+			return Diagnosis{}, err
+		}
+		sent[i] = conn.clock.Now()
+		if err := conn.SendRaw(msg, addr); err != nil {
+			return Diagnosis{}, err
+		}
+	}
+	expired, stop := conn.after("diagnose", DefaultDiagnoseTimeout)
+	defer stop()
+wait:
+	for len(rtts) < count {
+		select {
+		case r := <-replies:
+			collect(r)
+		case <-expired:
+			break wait
+		case <-done:
+			return Diagnosis{}, ErrClosedConn
+		}
+	}
+
+	d := Diagnosis{Sent: count, Received: len(rtts), Observed: observed, Local: peerKey(local)}
+	d.Loss = float64(count-len(rtts)) / float64(count)
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		var total time.Duration
+		for _, rtt := range rtts {
+			total += rtt
+		}
+		d.MinRTT, d.MaxRTT = rtts[0], rtts[len(rtts)-1]
+		d.MeanRTT = total / time.Duration(len(rtts))
+		d.MedianRTT = rtts[len(rtts)/2]
+		d.P90RTT = rtts[len(rtts)*9/10]
+		d.Translated = translated(observed, d.Local)
+	}
+	return d, nil
+}
+
+// Whether the address a responder saw is not the local one. A socket bound
+// to the wildcard address owns every local interface address.
+func translated(observed, local netip.AddrPort) bool {
+	if observed.Port() != local.Port() {
+		return true
+	}
+	if local.Addr().IsUnspecified() {
+		return InterfaceOf(net.IP(observed.Addr().AsSlice())) == nil
+	}
+	return observed.Addr() != local.Addr()
+}
+
+// Answer of an echo as received by Diagnose
+type echoReply struct {
+	id       uint64
+	at       time.Time
+	observed netip.AddrPort
+}
+
+// Rate limit of the responder and the bursts of Diagnose waiting for their
+// replies, by the id of their first echo
+type echoState struct {
+	mutex   sync.Mutex
+	limit   *tokenBucket
+	pending map[uint64]echoBurst
+}
+
+type echoBurst struct {
+	count   int
+	replies chan echoReply
+}
+
+func (e *echoState) start(base uint64, count int) chan echoReply {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pending == nil {
+		e.pending = make(map[uint64]echoBurst)
+	}
+	replies := make(chan echoReply, count)
+	e.pending[base] = echoBurst{count, replies}
+	return replies
+}
+
+func (e *echoState) finish(base uint64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.pending, base)
+}
+
+func (e *echoState) resolve(r echoReply) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for base, b := range e.pending {
+		if r.id-base < uint64(b.count) {
+			select {
+			case b.replies <- r:
+			default:
+				// a duplicate of an answered echo
+			}
+			return
+		}
+	}
+}
+
+// Consume an echo, answering a request if the responder is on and within
+// its rate, and handing a reply to Diagnose. Returns false for packets of
+// other kinds.
+func (conn *Conn) echo(p *Packet) bool {
+	m, v, err := wire.DecodeEcho(p.Msg)
+	if err != nil {
+		return false
+	}
+	now := conn.clock.Now()
+	if m.Reply {
+		observed := netip.AddrPortFrom(netip.AddrFrom16(m.IP).Unmap(), m.Port)
+		conn.echoes.resolve(echoReply{m.ID, now, observed})
+		return true
+	}
+
+	conn.echoes.mutex.Lock()
+	limit := conn.echoes.limit
+	conn.echoes.mutex.Unlock()
+	if limit == nil {
+		return true
+	}
+	if !limit.take(now) {
+		conn.stats.echoLimited()
+		return true
+	}
+	from := peerKey(p.Addr)
+	m.Reply, m.Received, m.IP, m.Port = true, now.UnixNano(), from.Addr().As16(), from.Port()
+	msg, err := m.Encode(v)
+	if err != nil {
+		return true
+	}
+	o := newOutgoing(msg, p.Addr)
+	o.raw, o.meta.Repeat = true, true
+	if p.Dst != nil {
+		o.src, o.ifIndex = p.Dst.IP, p.IfIndex
+	}
+	if conn.enqueue(o) == nil {
+		conn.stats.echoAnswered()
+	}
+	return true
+}
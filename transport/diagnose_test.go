@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// Responder listening on loopback, configured before it is opened
+func startResponder(t *testing.T, configure func(*Conn)) (*Conn, *net.UDPAddr) {
+	conn := listenLimited(t, Limits{}, configure)
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().Port}
+}
+
+func TestDiagnose(t *testing.T) {
+	_, addr := startResponder(t, func(conn *Conn) { conn.SetEchoResponder(1000, 100) })
+	client := listenLimited(t, Limits{}, nil)
+
+	d, err := client.Diagnose(addr, 20, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Sent != 20 || d.Received != 20 || d.Loss != 0 {
+		t.Fatalf("TestDiagnose expected every echo to be answered got %+v.", d)
+	}
+	if d.MinRTT <= 0 || d.MinRTT > d.MedianRTT || d.MedianRTT > d.P90RTT || d.P90RTT > d.MaxRTT || d.MeanRTT > d.MaxRTT {
+		t.Fatalf("TestDiagnose expected ordered round-trip times got %+v.", d)
+	}
+	expected := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(client.LocalAddr().Port))
+	if d.Observed != expected || d.Translated {
+		t.Fatalf("TestDiagnose expected %v to be observed untranslated got %+v.", expected, d)
+	}
+	if !translated(netip.MustParseAddrPort("127.0.0.1:1"), expected) {
+		t.Fatalf("TestDiagnose expected a rewritten port to be reported as translated.")
+	}
+}
+
+func TestDiagnoseLossy(t *testing.T) {
+	responder, addr := startResponder(t, func(conn *Conn) {
+		conn.SetSeed(1)
+		conn.UseShaper(NewShaper(Shaping{Loss: 0.3}))
+		conn.SetEchoResponder(1000, 100)
+	})
+	client := listenLimited(t, Limits{}, nil)
+
+	d, err := client.Diagnose(addr, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := int(responder.Stats().EchoesAnswered)
+	if d.Received != answered || d.Received == 50 || d.Loss != float64(50-answered)/50 {
+		t.Fatalf("TestDiagnoseLossy expected %d of 50 echoes answered got %+v.", answered, d)
+	}
+}
+
+func TestEchoResponderLimit(t *testing.T) {
+	off, offAddr := startResponder(t, nil)
+	responder, addr := startResponder(t, func(conn *Conn) { conn.SetEchoResponder(0.001, 5) })
+	client := listenLimited(t, Limits{}, nil)
+
+	d, err := client.Diagnose(addr, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := responder.Stats(); d.Received != 5 || s.EchoesAnswered != 5 || s.EchoesLimited != 15 {
+		t.Fatalf("TestEchoResponderLimit expected a burst of 5 answered and 15 limited got %+v and %d, %d.", d, s.EchoesAnswered, s.EchoesLimited)
+	}
+	if d, _ := client.Diagnose(offAddr, 3, 0); d.Received != 0 || d.Loss != 1 || off.Stats().EchoesAnswered != 0 {
+		t.Fatalf("TestEchoResponderLimit expected no answers from a node without responder got %+v.", d)
+	}
+}
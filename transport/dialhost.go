@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Consecutive failed writes to the remote end-point of DialHost after
+// which the connection re-evaluates the host's addresses.
+const DefaultRedialThreshold = 3
+
+// Maps hostnames to addresses; *net.Resolver implements it.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// Checks whether the remote end-point of a freshly dialed connection
+// answers, e.g. by sending a ping and waiting for the reply. The
+// connection's handlers are running while it is invoked.
+type Probe func(conn *Conn) error
+
+// Hostname and port given to DialHost
+type hostTarget struct {
+	host string
+	port uint
+}
+
+// Replace the resolver used by Dial and DialHost, net.DefaultResolver by
+// default, along with the HostCache in front of it. Must be called before
+// the socket is opened.
+func (conn *Conn) SetResolver(r Resolver) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.resolver = r
+	conn.hosts = NewHostCache(r)
+}
+
+// Cache through which Dial resolves hostnames, e.g. to Prewarm it with
+// the peer set at startup or to change its timeout.
+func (conn *Conn) Hosts() *HostCache {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.hosts
+}
+
+// Set the reachability check DialHost runs on every candidate address.
+// Without a probe, an address is accepted as soon as it can be dialed.
+// Must be called before the socket is opened.
+func (conn *Conn) SetProbe(probe Probe) {
+	conn.probe = probe
+}
+
+// Dial the host like Dial, but consider all of its addresses: IPv6 and
+// IPv4 addresses are tried alternately, IPv6 first, and the first one
+// which can be dialed and passes the probe (see SetProbe) is kept. After
+// DefaultRedialThreshold failures to send to it without hearing back in
+// between, the connection dials the host again in the background, keeping its
+// handlers and Err channel, so that a broken address family is left
+// behind; see SetRedial for the retries. Returns an *AddrError wrapping ErrUnresolvable if the host has
+// no address, and otherwise the error of the last candidate.
+func (conn *Conn) DialHost(host string, port uint) error {
+	err := conn.dialHost(host, port)
+	if err != nil {
+		// packets kept by a rejected candidate have nowhere to go
+		conn.failRetained(ErrNotConnected)
+	}
+	return err
+}
+
+// DialHost without failing the packets kept across a redial, so that
+// they survive every attempt of reconnect.
+func (conn *Conn) dialHost(host string, port uint) error {
+	ips, err := conn.resolver.LookupIP(context.Background(), "ip", host)
+	if err != nil || len(ips) == 0 {
+		return &AddrError{Addr: host, Err: ErrUnresolvable, Cause: err}
+	}
+
+	target := &hostTarget{host, port}
+	for _, ip := range interleave(ips) {
+		raddr := &net.UDPAddr{IP: ip, Port: int(port)}
+		network := "udp6"
+		if ip.To4() != nil {
+			network = "udp4"
+		}
+		err = conn.open(Dialed, func() (*net.UDPConn, error) {
+			conn.host = target
+			return conn.dialUDP(network, raddr)
+		})
+		switch err {
+		case ErrAlreadyConnected, ErrClosedConn:
+			return err
+		case nil:
+			if conn.probe == nil {
+				return nil
+			}
+			if err = conn.probe(conn); err == nil {
+				return nil
+			}
+			if !conn.reset() {
+				return ErrClosedConn
+			}
+		}
+	}
+	return err
+}
+
+// Failures to reach the remote end-point since the last packet from it.
+// Writes to a connected socket succeed even if nobody listens, so only
+// incoming packets prove that the address works.
+type hostHealth struct {
+	mutex    sync.Mutex
+	failures int
+}
+
+// Count a failure; returns true once the threshold is reached.
+func (h *hostHealth) failed() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.failures++
+	return h.failures == DefaultRedialThreshold
+}
+
+func (h *hostHealth) alive() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.failures = 0
+}
+
+func (conn *Conn) hostFailed(target *hostTarget, health *hostHealth) {
+	if health.failed() {
+		conn.spawnRole("redial", func() { conn.redial(target) })
+	}
+}
+
+// Dial the host of DialHost again after persistent send failures.
+func (conn *Conn) redial(target *hostTarget) {
+	if !conn.reset() {
+		return
+	}
+	conn.reconnect(func() error { return conn.dialHost(target.host, target.port) })
+}
+
+// Order the addresses IPv6, IPv4, IPv6, ... keeping the resolver's order
+// within each family.
+func interleave(ips []net.IP) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
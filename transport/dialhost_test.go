@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Resolver which returns the next answer on every lookup and repeats the last
+type fakeResolver struct {
+	mutex   sync.Mutex
+	answers [][]net.IP
+	lookups int
+}
+
+func (r *fakeResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	i := r.lookups
+	if i >= len(r.answers) {
+		i = len(r.answers) - 1
+	}
+	r.lookups++
+	if r.answers[i] == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return r.answers[i], nil
+}
+
+// Listen on 127.0.0.1 only and echo every datagram
+func startEcho(t *testing.T) (*net.UDPConn, uint) {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buff := make([]byte, MessageSize)
+		for {
+			n, addr, err := sock.ReadFromUDP(buff)
+			if err != nil {
+				return
+			}
+			sock.WriteToUDP(buff[:n], addr)
+		}
+	}()
+	return sock, uint(sock.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestInterleave(t *testing.T) {
+	v4a, v4b := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	v6a, v6b, v6c := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3")
+	ordered := interleave([]net.IP{v4a, v6a, v4b, v6b, v6c})
+	expected := []net.IP{v6a, v4a, v6b, v4b, v6c}
+	for i := range expected {
+		if !ordered[i].Equal(expected[i]) {
+			t.Fatalf("TestInterleave expected %v got %v.", expected, ordered)
+		}
+	}
+}
+
+func TestDialHostFallback(t *testing.T) {
+	echo, port := startEcho(t)
+	defer echo.Close()
+
+	replies := make(chan Message, 4)
+	conn := NewConn()
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		replies <- p.Msg
+	})
+	conn.SetResolver(&fakeResolver{answers: [][]net.IP{{net.IPv4(127, 0, 0, 1), net.ParseIP("2001:db8::dead")}}})
+
+	var probed []string
+	conn.SetProbe(func(conn *Conn) error {
+		probed = append(probed, conn.sock.RemoteAddr().String())
+		conn.Send([]byte(expectedRequest))
+		select {
+		case <-replies:
+			return nil
+		case <-time.After(200 * time.Millisecond):
+			return errors.New("no reply")
+		}
+	})
+
+	if err := conn.DialHost("dual.example", port); err != nil {
+		t.Fatalf("TestDialHostFallback cannot dial: %s", err)
+	}
+	defer conn.Disconnect()
+	go monitor(conn.Err, t)
+
+	remote := conn.sock.RemoteAddr().(*net.UDPAddr)
+	if !remote.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("TestDialHostFallback expected fallback to IPv4 got %s.", remote)
+	}
+	// the dead IPv6 address either cannot be dialed or fails the probe
+	if len(probed) > 2 || probed[len(probed)-1] != remote.String() {
+		t.Fatalf("TestDialHostFallback unexpected probes %v", probed)
+	}
+
+	// handlers survive the discarded attempt
+	conn.Send([]byte(expectedRequest))
+	select {
+	case msg := <-replies:
+		if string(msg) != expectedRequest {
+			t.Fatalf("TestDialHostFallback expected %q got %q.", expectedRequest, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestDialHostFallback no reply")
+	}
+}
+
+func TestDialHostUnresolvable(t *testing.T) {
+	conn := NewConn()
+	conn.SetResolver(&fakeResolver{answers: [][]net.IP{nil}})
+	if err := conn.DialHost("missing.example", 9); !errors.Is(err, ErrUnresolvable) {
+		t.Fatalf("TestDialHostUnresolvable expected %q got %v.", ErrUnresolvable, err)
+	}
+	if conn.State() != Idle {
+		t.Fatalf("TestDialHostUnresolvable expected %s got %s.", Idle, conn.State())
+	}
+}
+
+func TestDialHostRedial(t *testing.T) {
+	echo, port := startEcho(t)
+	defer echo.Close()
+
+	// the host first points at an address where nobody listens
+	resolver := &fakeResolver{answers: [][]net.IP{{net.IPv4(127, 0, 0, 2)}, {net.IPv4(127, 0, 0, 1)}}}
+	replies := make(chan Message, 16)
+	conn := NewConn()
+	conn.SetResolver(resolver)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		replies <- p.Msg
+	})
+	if err := conn.DialHost("moved.example", port); err != nil {
+		t.Fatalf("TestDialHostRedial cannot dial: %s", err)
+	}
+	defer conn.Disconnect()
+
+	failures := make(chan error, 16)
+	go func(errs chan error) {
+		for err := range errs {
+			failures <- err
+		}
+	}(conn.Err)
+
+	deadline := time.After(2 * time.Second)
+	for {
+		conn.Send([]byte(expectedRequest))
+		select {
+		case <-replies:
+			if len(failures) < DefaultRedialThreshold {
+				t.Fatalf("TestDialHostRedial expected %d failures before redial got %d.", DefaultRedialThreshold, len(failures))
+			}
+			if remote := conn.sock.RemoteAddr().(*net.UDPAddr); !remote.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Fatalf("TestDialHostRedial expected redial to 127.0.0.1 got %s.", remote)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("TestDialHostRedial no reply after %d failures", len(failures))
+		}
+	}
+}
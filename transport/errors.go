@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var ErrNotSupported = errors.New("Option is not supported on this platform")
+
+// Failure to write a single packet. The connection remains usable and
+// the caller may retry the packet, possibly with a different address.
+type SendError struct {
+	Packet *Packet
+	Err    error
+}
+
+func (e *SendError) Error() string {
+	if e.Packet.Addr == nil {
+		return fmt.Sprintf("conn.sending(): %s", e.Err)
+	}
+	return fmt.Sprintf("conn.sending() [%s]: %s", e.Packet.Addr, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// Determine if the error means the socket itself is unusable, as opposed
+// to a failure which only affects the packet at hand (e.g. an unreachable
+// or invalid destination).
+func isFatal(err error) bool {
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		return true
+	case errors.Is(err, syscall.EBADF), errors.Is(err, syscall.ENOTSOCK):
+		return true
+	}
+	return false
+}
+
+// Determine if a read from a connected socket failed because the remote
+// end-point rejected one of the earlier datagrams.
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
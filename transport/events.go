@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+)
+
+// Capacity of the channel returned by Conn.Events
+const EventBufferSize = 64
+
+// Machine-readable notification about a state transition inside a Conn.
+// The concrete types are the *Event structs below.
+type Event interface {
+	String() string
+}
+
+// Socket has been opened by Listen or Dial and the background processes run.
+type OpenEvent struct {
+	State     State
+	LocalAddr net.Addr
+
+	// Seed of Conn.Rand, to replay the run with SetSeed
+	Seed int64
+}
+
+func (e *OpenEvent) String() string {
+	return fmt.Sprintf("open: %s on %s with seed %d", e.State, e.LocalAddr, e.Seed)
+}
+
+// Background processes of the socket opened by Listen or Dial all run,
+// so incoming packets reach the handlers; see Conn.Ready.
+type ReadyEvent struct {
+	LocalAddr net.Addr
+}
+
+func (e *ReadyEvent) String() string {
+	return fmt.Sprintf("ready: %s", e.LocalAddr)
+}
+
+// Datagram was larger than the datagram size of the receiving connection
+// when its socket was opened (see SetDatagramSize) and has been cut off.
+type TruncatedEvent struct {
+	From *net.UDPAddr
+	Size int
+}
+
+func (e *TruncatedEvent) String() string {
+	return fmt.Sprintf("truncated: datagram from %s cut to %d bytes", e.From, e.Size)
+}
+
+// Incoming packet was discarded before it reached the handlers.
+type DropEvent struct {
+	From   *net.UDPAddr
+	Reason error
+}
+
+func (e *DropEvent) String() string {
+	return fmt.Sprintf("drop: packet from %s: %s", e.From, e.Reason)
+}
+
+// ICMP error about a datagram sent to Addr, e.g. port unreachable, reported
+// by the kernel as soon as it arrives (see SetICMPErrors). Msg is the
+// payload of the offending datagram as far as the kernel kept it.
+type UnreachableEvent struct {
+	Addr       *net.UDPAddr
+	Msg        Message
+	Err        error
+	Type, Code uint8
+
+	// Next-hop MTU of a fragmentation needed error, zero otherwise
+	MTU int
+}
+
+func (e *UnreachableEvent) String() string {
+	return fmt.Sprintf("unreachable: %s: %s (icmp type %d code %d)", e.Addr, e.Err, e.Type, e.Code)
+}
+
+// Disconnect has completed; Err is the fatal socket error which initiated
+// the shutdown, or nil if it was requested by the caller.
+type ShutdownEvent struct {
+	Err error
+}
+
+func (e *ShutdownEvent) String() string {
+	if e.Err == nil {
+		return "shutdown"
+	}
+	return fmt.Sprintf("shutdown: %s", e.Err)
+}
+
+// Returns the channel on which the connection publishes its events. The
+// channel is never closed and survives Disconnect. It holds up to
+// EventBufferSize events; when it is full, new events are discarded and
+// counted in Stats.EventsDropped rather than blocking the connection.
+func (conn *Conn) Events() <-chan Event {
+	return conn.events
+}
+
+// Publish the event without blocking.
+func (conn *Conn) emit(e Event) {
+	select {
+	case conn.events <- e:
+	default:
+		conn.stats.eventDropped()
+	}
+}
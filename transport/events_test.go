@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEventSequence(t *testing.T) {
+	received := make(chan bool, 2)
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		received <- true
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestEventSequence cannot listen: %s", err)
+	}
+	port := portOf(conn)
+
+	client := startClient(t, port)
+	defer client.Disconnect()
+
+	// Send refuses oversized messages, so bypass the Conn
+	raw, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	raw.Write(make([]byte, MessageSize+100))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("TestEventSequence only received %d packets", i)
+		}
+	}
+	conn.Disconnect()
+
+	events := conn.Events()
+	if e, ok := (<-events).(*OpenEvent); !ok || e.State != Listening || e.LocalAddr.(*net.UDPAddr).Port != int(port) {
+		t.Fatalf("TestEventSequence expected open event on port %d got %v.", port, e)
+	}
+	if e, ok := (<-events).(*ReadyEvent); !ok || e.LocalAddr.(*net.UDPAddr).Port != int(port) {
+		t.Fatalf("TestEventSequence expected ready event on port %d got %v.", port, e)
+	}
+	if e, ok := (<-events).(*TruncatedEvent); !ok || e.Size != MessageSize {
+		t.Fatalf("TestEventSequence expected truncated event got %v.", e)
+	}
+	if e, ok := (<-events).(*ShutdownEvent); !ok || e.Err != nil {
+		t.Fatalf("TestEventSequence expected clean shutdown event got %v.", e)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("TestEventSequence unexpected event %s", e)
+	default:
+	}
+	if n := conn.Stats().Truncated; n != 1 {
+		t.Fatalf("TestEventSequence expected 1 truncated datagram got %d.", n)
+	}
+}
+
+func TestEventOverflow(t *testing.T) {
+	conn := NewConn()
+	for i := 0; i < EventBufferSize+10; i++ {
+		conn.emit(&ShutdownEvent{})
+	}
+
+	if n := conn.Stats().EventsDropped; n != 10 {
+		t.Fatalf("TestEventOverflow expected 10 dropped events got %d.", n)
+	}
+}
@@ -0,0 +1,182 @@
+// Tests which need only the exported API, in an external package so that
+// they can use the helpers of gossiptest, which imports transport.
+package transport_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/gossiptest"
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestEgressMiddleware(t *testing.T) {
+	const stamped = "ping (stamped)"
+	server, addr := gossiptest.Listen(t, nil)
+	received := gossiptest.NewPacketRecorder(nil)
+	server.AddHandler(received.Handle)
+
+	client := transport.NewConn()
+	client.UseEgress(func(p *transport.Packet) (*transport.Packet, error) {
+		msg := append(transport.Message(nil), p.Msg...)
+		return &transport.Packet{Addr: p.Addr, Msg: append(msg, " (stamped)"...)}, nil
+	})
+	if err := client.Dial(addr.String(), time.Time{}); err != nil {
+		t.Fatalf("TestEgressMiddleware cannot dial: %s", err)
+	}
+	defer client.Disconnect()
+	client.Send([]byte("ping"))
+
+	if _, err := received.WaitFor(gossiptest.MessageIs(stamped), time.Second); err != nil {
+		t.Fatalf("TestEgressMiddleware expected %q got %d packets (%v).", stamped, received.Len(), err)
+	}
+}
+
+// Connection with the given datagram size which records the messages it
+// receives
+func listenSized(t *testing.T, size int) (*transport.Conn, *net.UDPAddr, *gossiptest.PacketRecorder) {
+	received := gossiptest.NewPacketRecorder(nil)
+	conn, addr := gossiptest.ListenWith(t, nil, func(conn *transport.Conn) {
+		conn.SetDatagramSize(size)
+		conn.AddHandler(received.Handle)
+	})
+	return conn, addr, received
+}
+
+func TestDatagramSizeNegotiation(t *testing.T) {
+	big, bigAddr, bigReceived := listenSized(t, 1400)
+	small, smallAddr, smallReceived := listenSized(t, transport.MessageSize)
+	other, otherAddr, otherReceived := listenSized(t, 1400)
+
+	if n := big.MaxPayloadTo(otherAddr); n != transport.DefaultPeerDatagramSize {
+		t.Fatalf("TestDatagramSizeNegotiation expected %d for an unknown peer got %d.", transport.DefaultPeerDatagramSize, n)
+	}
+	if err := big.AdvertiseDatagramSize(smallAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := big.AdvertiseDatagramSize(otherAddr); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		conn *transport.Conn
+		addr *net.UDPAddr
+		size int
+	}{
+		{big, otherAddr, 1400},
+		{other, bigAddr, 1400},
+		{big, smallAddr, transport.MessageSize},
+		{small, bigAddr, transport.MessageSize},
+	} {
+		err := gossiptest.Eventually(func() bool {
+			return test.conn.PeerDatagramSize(test.addr) == test.size
+		}, time.Second)
+		if err != nil {
+			t.Fatalf("TestDatagramSizeNegotiation expected a datagram size of %d for %s got %d.", test.size, test.addr, test.conn.PeerDatagramSize(test.addr))
+		}
+	}
+
+	tests := []struct {
+		from     *transport.Conn
+		to       *net.UDPAddr
+		received *gossiptest.PacketRecorder
+		size     int
+	}{
+		{big, otherAddr, otherReceived, 1400},
+		{other, bigAddr, bigReceived, 1400},
+		{big, smallAddr, smallReceived, transport.MessageSize},
+		{small, bigAddr, bigReceived, transport.MessageSize},
+	}
+	for _, test := range tests {
+		if n := test.from.MaxPayloadTo(test.to); n != test.size {
+			t.Fatalf("TestDatagramSizeNegotiation expected a payload of %d to %s got %d.", test.size, test.to, n)
+		}
+		err := test.from.SendTo(make(transport.Message, test.size+1), test.to)
+		if !errors.Is(err, transport.ErrMessageTooLarge) {
+			t.Fatalf("TestDatagramSizeNegotiation expected an oversized message to %s to be rejected got %v.", test.to, err)
+		}
+		if err := test.from.SendTo(make(transport.Message, test.size), test.to); err != nil {
+			t.Fatal(err)
+		}
+		sized := func(p *transport.Packet) bool { return len(p.Msg) == test.size }
+		if _, err := test.received.WaitFor(sized, time.Second); err != nil {
+			t.Fatalf("TestDatagramSizeNegotiation expected %d bytes at %s (%v).", test.size, test.to, err)
+		}
+	}
+
+	for _, conn := range []*transport.Conn{big, small, other} {
+		if n := conn.Stats().Truncated; n != 0 {
+			t.Fatalf("TestDatagramSizeNegotiation expected no truncation got %d.", n)
+		}
+	}
+}
+
+func TestSlowHandler(t *testing.T) {
+	clock := transport.NewManualClock(gossiptest.Epoch)
+
+	// the slow handler takes its time only once the fast one is done
+	var calls uint64
+	conn, addr := gossiptest.ListenWith(t, clock, func(conn *transport.Conn) {
+		conn.AddNamedHandler("slow", func(conn *transport.Conn, p *transport.Packet) {
+			calls++
+			for conn.Stats().Handlers[1].Calls < calls {
+				time.Sleep(time.Millisecond)
+			}
+			clock.Advance(2 * time.Second)
+		})
+		conn.AddHandler(func(conn *transport.Conn, p *transport.Packet) {})
+	})
+	events := gossiptest.CollectEvents(t, conn)
+
+	raw, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	// one packet at a time, so that the invocations do not overlap
+	send := func(calls uint64) transport.Stats {
+		raw.Write([]byte("ping"))
+		var stats transport.Stats
+		err := gossiptest.Eventually(func() bool {
+			stats = conn.Stats()
+			return len(stats.Handlers) == 2 && stats.Handlers[0].Calls == calls && stats.Handlers[1].Calls == calls
+		}, time.Second)
+		if err != nil {
+			t.Fatalf("TestSlowHandler expected %d calls got %+v.", calls, stats.Handlers)
+		}
+		return stats
+	}
+	slowEvent := func(suppressed uint64) *transport.SlowHandlerEvent {
+		e, _ := events.WaitFor(func(e transport.Event) bool {
+			slow, ok := e.(*transport.SlowHandlerEvent)
+			return ok && slow.Suppressed == suppressed
+		}, time.Second)
+		slow, _ := e.(*transport.SlowHandlerEvent)
+		return slow
+	}
+
+	send(1)
+	e := slowEvent(0)
+	if e == nil || e.Name != "slow" || e.Elapsed != 2*time.Second {
+		t.Fatalf("TestSlowHandler expected an event for the slow handler got %v.", e)
+	}
+
+	// further slow invocations within the interval are only counted
+	send(2)
+	stats := send(3)
+	slow, fast := stats.Handlers[0], stats.Handlers[1]
+	if slow.Name != "slow" || slow.Max != 2*time.Second || slow.Total != 6*time.Second || slow.Slow != 3 || slow.Mean() != 2*time.Second {
+		t.Fatalf("TestSlowHandler unexpected metrics of the slow handler %+v.", slow)
+	}
+	if fast.Name != "handler-2" || fast.Slow != 0 {
+		t.Fatalf("TestSlowHandler unexpected metrics of the fast handler %+v.", fast)
+	}
+
+	clock.Advance(transport.DefaultSlowHandlerInterval)
+	send(4)
+	if e := slowEvent(2); e == nil {
+		t.Fatalf("TestSlowHandler expected an event with 2 suppressed got %v.", events.Events())
+	}
+}
@@ -0,0 +1,146 @@
+package transport
+
+import "fmt"
+
+// Handlers which are enabled, disabled and removed together, e.g. the set
+// an application runs in one of its modes. Flipping a group replaces the
+// handler list of the connection in one step, so a packet is dispatched
+// either to every handler of the group or to none of them. The handlers
+// see the packets after the ingress middleware like any others, and
+// their predicates and prefixes apply as for the methods of Conn.
+type HandlerGroup struct {
+	conn *Conn
+	name string
+
+	// guarded by the mutex of conn; resets of conn when last enabled
+	handlers []*registeredHandler
+	enabled  bool
+	removed  bool
+	resets   uint64
+}
+
+// Create an empty group whose handlers are named after it in
+// Stats.Handlers. The group starts out disabled, so that packets never see
+// it half built; Enable it once its handlers have been added. Disconnect
+// drops the handlers of an enabled group like any others and disables the
+// group, which keeps its handlers for Enable on the next socket.
+func (conn *Conn) NewHandlerGroup(name string) *HandlerGroup {
+	return &HandlerGroup{conn: conn, name: name}
+}
+
+func (g *HandlerGroup) Name() string {
+	return g.name
+}
+
+// Registers an event handler with the group.
+func (g *HandlerGroup) AddHandler(f EventHandler) {
+	g.add("", &registeredHandler{f: Checked(f)})
+}
+
+// Registers a checked handler with the group; see Conn.AddCheckedHandler.
+func (g *HandlerGroup) AddCheckedHandler(name string, f CheckedHandler) {
+	g.add(name, &registeredHandler{f: f})
+}
+
+// Registers an event handler with the group which is only invoked on
+// packets for which pred returns true; see Conn.AddHandlerIf.
+func (g *HandlerGroup) AddHandlerIf(pred func(*Packet) bool, f EventHandler) {
+	g.add("", &registeredHandler{f: Checked(f), pred: pred})
+}
+
+// Registers an event handler with the group for the messages which start
+// with the prefix; see Conn.HandlePrefix.
+func (g *HandlerGroup) Handle(prefix []byte, f EventHandler) {
+	g.add("", &registeredHandler{f: Checked(f), prefix: append([]byte{}, prefix...), scoped: true})
+}
+
+// Add the handler, registering it right away if the group is enabled;
+// handlers added to a removed group are ignored.
+func (g *HandlerGroup) add(name string, h *registeredHandler) {
+	conn := g.conn
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if g.removed {
+		return
+	}
+	g.sync()
+	conn.registered++
+	if name == "" {
+		name = fmt.Sprintf("handler-%d", conn.registered)
+	}
+	h.stats.Name = g.name + "/" + name
+	g.handlers = append(g.handlers, h)
+	if g.enabled {
+		conn.handlers = append(conn.handlers, h)
+	}
+}
+
+// Register every handler of the group at once; packets dispatched from
+// now on reach all of them.
+func (g *HandlerGroup) Enable() {
+	conn := g.conn
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if g.sync(); g.enabled || g.removed {
+		return
+	}
+	g.enabled, g.resets = true, conn.resets
+
+	// dispatchEvent holds on to the old slice, so never modify it in place
+	handlers := make([]*registeredHandler, 0, len(conn.handlers)+len(g.handlers))
+	handlers = append(handlers, conn.handlers...)
+	conn.handlers = append(handlers, g.handlers...)
+}
+
+// Unregister every handler of the group at once; packets dispatched before
+// still reach all of them.
+func (g *HandlerGroup) Disable() {
+	conn := g.conn
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	g.disable()
+}
+
+// Notice that Disconnect dropped the handlers of the group since it was
+// enabled. Must hold the mutex of conn.
+func (g *HandlerGroup) sync() {
+	if g.enabled && g.resets != g.conn.resets {
+		g.enabled = false
+	}
+}
+
+// Must hold the mutex of conn.
+func (g *HandlerGroup) disable() {
+	if g.sync(); !g.enabled {
+		return
+	}
+	g.enabled = false
+	member := make(map[*registeredHandler]bool, len(g.handlers))
+	for _, h := range g.handlers {
+		member[h] = true
+	}
+	conn := g.conn
+	handlers := make([]*registeredHandler, 0, len(conn.handlers))
+	for _, h := range conn.handlers {
+		if !member[h] {
+			handlers = append(handlers, h)
+		}
+	}
+	conn.handlers = handlers
+}
+
+// Disable the group for good and drop its handlers.
+func (g *HandlerGroup) Remove() {
+	conn := g.conn
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	g.disable()
+	g.removed, g.handlers = true, nil
+}
+
+func (g *HandlerGroup) Enabled() bool {
+	g.conn.mutex.Lock()
+	defer g.conn.mutex.Unlock()
+	g.sync()
+	return g.enabled
+}
@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandlerGroupAtomic(t *testing.T) {
+	const packets = 2000
+	var base, a, b [packets]int32
+	index := func(p *Packet) int { return int(binary.BigEndian.Uint16(p.Msg)) }
+
+	conn := listenLimited(t, Limits{}, func(conn *Conn) {
+		conn.AddHandler(func(conn *Conn, p *Packet) { atomic.AddInt32(&base[index(p)], 1) })
+	})
+	g := conn.NewHandlerGroup("steady")
+	g.AddHandler(func(conn *Conn, p *Packet) { atomic.AddInt32(&a[index(p)], 1) })
+	g.AddHandlerIf(func(p *Packet) bool { return len(p.Msg) == 2 }, func(conn *Conn, p *Packet) { atomic.AddInt32(&b[index(p)], 1) })
+
+	sock, _ := rawPeer(t)
+	local := conn.LocalAddr()
+	local.IP = []byte{127, 0, 0, 1}
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			g.Enable()
+			time.Sleep(50 * time.Microsecond)
+			g.Disable()
+			time.Sleep(50 * time.Microsecond)
+		}
+	}()
+	for i := 0; i < packets; i++ {
+		sock.WriteToUDP(binary.BigEndian.AppendUint16(nil, uint16(i)), local)
+		if i%50 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(done)
+
+	// the kernel may drop a datagram under load, so wait until the
+	// handlers are done with everything which arrived
+	deadline := time.Now().Add(5 * time.Second)
+	for received, last := 0, -1; received != last || conn.Stats().HandlersRunning > 0; {
+		if received == packets || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+		last, received = received, 0
+		for i := range base {
+			received += int(atomic.LoadInt32(&base[i]))
+		}
+	}
+	for conn.Stats().HandlersRunning > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	enabled := 0
+	for i := 0; i < packets; i++ {
+		if base[i] > 1 || a[i] != b[i] || a[i] > base[i] {
+			t.Fatalf("TestHandlerGroupAtomic expected packet %d once to the group's handlers together got %d, %d and %d.", i, base[i], a[i], b[i])
+		}
+		enabled += int(a[i])
+	}
+	if enabled == 0 || enabled == packets {
+		t.Fatalf("TestHandlerGroupAtomic expected the group to see some of the packets got %d of %d.", enabled, packets)
+	}
+}
+
+func TestHandlerGroupRemove(t *testing.T) {
+	conn := NewConn()
+	conn.AddHandler(func(conn *Conn, p *Packet) {})
+	g := conn.NewHandlerGroup("bootstrap")
+	g.AddHandler(func(conn *Conn, p *Packet) {})
+	g.Handle([]byte("cfg/"), func(conn *Conn, p *Packet) {})
+	if n := len(conn.Stats().Handlers); n != 1 || g.Enabled() {
+		t.Fatalf("TestHandlerGroupRemove expected a new group to be disabled got %d handlers.", n)
+	}
+	g.Enable()
+	if h := conn.Stats().Handlers; len(h) != 3 || h[1].Name != "bootstrap/handler-2" {
+		t.Fatalf("TestHandlerGroupRemove expected the group's handlers to be named after it got %+v.", h)
+	}
+	g.Remove()
+	g.Enable()
+	g.AddHandler(func(conn *Conn, p *Packet) {})
+	if n := len(conn.Stats().Handlers); n != 1 || g.Enabled() {
+		t.Fatalf("TestHandlerGroupRemove expected a removed group to stay out got %d handlers.", n)
+	}
+}
+
+func TestHandlerGroupDisconnect(t *testing.T) {
+	received := make(chan bool, 1)
+	conn := NewConn()
+	g := conn.NewHandlerGroup("steady")
+	g.AddHandler(func(conn *Conn, p *Packet) { received <- true })
+	g.Enable()
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	conn.Disconnect()
+	if g.Enabled() || len(conn.Stats().Handlers) != 0 {
+		t.Fatalf("TestHandlerGroupDisconnect expected Disconnect to disable the group.")
+	}
+
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	g.Enable()
+	sock, _ := rawPeer(t)
+	local := conn.LocalAddr()
+	local.IP = []byte{127, 0, 0, 1}
+	sock.WriteToUDP([]byte("again"), local)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("TestHandlerGroupDisconnect expected the group to be enabled on the next socket got %d handlers.", len(conn.Stats().Handlers))
+	}
+}
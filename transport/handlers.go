@@ -0,0 +1,313 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Execution time above which a handler invocation is reported by a
+	// SlowHandlerEvent, unless changed by SetSlowHandler
+	DefaultSlowHandler = time.Second
+
+	// Least time between two SlowHandlerEvents of the same handler
+	DefaultSlowHandlerInterval = 10 * time.Second
+)
+
+// Execution metrics of one registered handler.
+type HandlerStats struct {
+	// As given to AddNamedHandler, or handler-N for the Nth handler
+	// registered by AddHandler
+	Name string
+
+	// Completed invocations and their cumulative and largest duration
+	Calls uint64
+	Total time.Duration
+	Max   time.Duration
+
+	// Invocations which took longer than the slow handler threshold
+	Slow uint64
+
+	// Invocations which rejected the packet; see AddCheckedHandler
+	Errors uint64
+}
+
+// Mean duration of an invocation, zero if there was none.
+func (s HandlerStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// A handler invocation took longer than the threshold of SetSlowHandler.
+// Events of the same handler are at least the interval apart, and
+// Suppressed counts the slow invocations in between which were not
+// reported.
+type SlowHandlerEvent struct {
+	Name       string
+	Elapsed    time.Duration
+	From       *net.UDPAddr
+	Suppressed uint64
+}
+
+func (e *SlowHandlerEvent) String() string {
+	return fmt.Sprintf("slow handler: %s took %s on a packet from %s (%d suppressed)", e.Name, e.Elapsed, e.From, e.Suppressed)
+}
+
+// A checked handler rejected a packet; see SetHandlerErrorEvents.
+type HandlerErrorEvent struct {
+	Name string
+	From *net.UDPAddr
+	Err  error
+}
+
+func (e *HandlerErrorEvent) String() string {
+	return fmt.Sprintf("handler error: %s rejected a packet from %s: %s", e.Name, e.From, e.Err)
+}
+
+// Event handler which reports the outcome: nil if the packet was
+// processed, otherwise why it was rejected, e.g. because it failed
+// validation. Reliable delivery layers may turn the error into a negative
+// acknowledgement so that the sender can tell a rejection from a loss.
+type CheckedHandler func(*Conn, *Packet) error
+
+// Adapt an EventHandler to a CheckedHandler which processes every packet.
+func Checked(f EventHandler) CheckedHandler {
+	return func(conn *Conn, p *Packet) error {
+		f(conn, p)
+		return nil
+	}
+}
+
+// Adapt a CheckedHandler to an EventHandler which discards the outcome.
+func Unchecked(f CheckedHandler) EventHandler {
+	return func(conn *Conn, p *Packet) {
+		f(conn, p)
+	}
+}
+
+// Event handler with the metrics of its invocations
+type registeredHandler struct {
+	f CheckedHandler
+
+	// Packets the handler is invoked on, all if nil
+	pred func(*Packet) bool
+
+	// Leading bytes of the messages a handler of HandlePrefix receives,
+	// set if scoped
+	prefix []byte
+	scoped bool
+
+	// Set for handlers which remove themselves after the first match.
+	// The lowest bit of armed is set by the invocation which wins, the
+	// others count the registrations; it is only changed under the mutex
+	// of the connection.
+	once  bool
+	armed uint64
+
+	mutex      sync.Mutex
+	stats      HandlerStats
+	reported   time.Time
+	suppressed uint64
+}
+
+// Account for an invocation; returns the event to publish, if any.
+func (h *registeredHandler) record(elapsed time.Duration, now time.Time, threshold, interval time.Duration, p *Packet, err error) *SlowHandlerEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.stats.Calls++
+	if err != nil {
+		h.stats.Errors++
+	}
+	h.stats.Total += elapsed
+	if elapsed > h.stats.Max {
+		h.stats.Max = elapsed
+	}
+	if threshold <= 0 || elapsed <= threshold {
+		return nil
+	}
+
+	h.stats.Slow++
+	if !h.reported.IsZero() && now.Sub(h.reported) < interval {
+		h.suppressed++
+		return nil
+	}
+	e := &SlowHandlerEvent{h.stats.Name, elapsed, p.Addr, h.suppressed}
+	h.reported, h.suppressed = now, 0
+	return e
+}
+
+func (h *registeredHandler) snapshot() HandlerStats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.stats
+}
+
+// Report handler invocations which take longer than threshold with a
+// SlowHandlerEvent, at most one per handler every interval. A threshold
+// of zero turns the events off; the metrics in Stats.Handlers are kept
+// regardless.
+func (conn *Conn) SetSlowHandler(threshold, interval time.Duration) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.slowHandler, conn.slowInterval = threshold, interval
+}
+
+// Registers an event handler like AddHandler under a name which
+// identifies it in Stats.Handlers and SlowHandlerEvents.
+func (conn *Conn) AddNamedHandler(name string, f EventHandler) {
+	conn.addHandler(name, &registeredHandler{f: Checked(f)})
+}
+
+// Registers a handler like AddNamedHandler, an empty name selecting
+// handler-N, whose errors are counted in HandlerStats.Errors and
+// published as HandlerErrorEvents if enabled by SetHandlerErrorEvents.
+func (conn *Conn) AddCheckedHandler(name string, f CheckedHandler) {
+	conn.addHandler(name, &registeredHandler{f: f})
+}
+
+// Publish a HandlerErrorEvent for every packet a checked handler rejects.
+// Off by default since a handler rejecting a flood of packets would crowd
+// out the other events.
+func (conn *Conn) SetHandlerErrorEvents(enabled bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.handlerErrors = enabled
+}
+
+// Registers an event handler which is only invoked on packets for which
+// pred returns true. The predicate runs in the handler's goroutine.
+func (conn *Conn) AddHandlerIf(pred func(*Packet) bool, f EventHandler) {
+	conn.addHandler("", &registeredHandler{f: Checked(f), pred: pred})
+}
+
+// Registers an event handler which is invoked on the first packet for
+// which pred returns true, or on the first packet at all if pred is nil,
+// and then removed. It runs exactly once even if several matching packets
+// are dispatched concurrently. The returned function removes the handler
+// if it has not fired yet, e.g. once a request timed out.
+func (conn *Conn) AddOnceHandler(pred func(*Packet) bool, f EventHandler) (remove func()) {
+	o := conn.NewOnceHandler(pred, f)
+	o.Arm()
+	return o.Disarm
+}
+
+// Once handler which can be registered again after it fired or was
+// removed, for code which awaits one packet after another, such as the
+// response to each request, without allocating for every registration.
+type OnceHandler struct {
+	conn *Conn
+	h    *registeredHandler
+}
+
+// Create a once handler like AddOnceHandler which is registered by Arm.
+// Packets dispatched while it was registered before may still be offered
+// to it, so pred must tell them apart from the ones it awaits now.
+func (conn *Conn) NewOnceHandler(pred func(*Packet) bool, f EventHandler) *OnceHandler {
+	h := &registeredHandler{f: Checked(f), pred: pred, once: true, armed: 1}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.name("", h)
+	return &OnceHandler{conn, h}
+}
+
+// Register the handler unless it is already waiting for a packet.
+func (o *OnceHandler) Arm() {
+	conn, h := o.conn, o.h
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if h.armed&1 == 0 {
+		return
+	}
+	atomic.StoreUint64(&h.armed, h.armed+1)
+	conn.handlers = append(conn.handlers, h)
+}
+
+// Remove the handler if it has not fired yet, e.g. once a request timed
+// out.
+func (o *OnceHandler) Disarm() {
+	conn, h := o.conn, o.h
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if h.armed&1 != 0 {
+		return
+	}
+	atomic.StoreUint64(&h.armed, h.armed|1)
+	conn.unregister(h)
+}
+
+func (conn *Conn) addHandler(name string, h *registeredHandler) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.name(name, h)
+	conn.handlers = append(conn.handlers, h)
+}
+
+// Name the handler as given or else handler-N; assumes the caller holds
+// the connection's mutex.
+func (conn *Conn) name(name string, h *registeredHandler) {
+	conn.registered++
+	if name == "" {
+		name = fmt.Sprintf("handler-%d", conn.registered)
+	}
+	h.stats.Name = name
+}
+
+// Unregister the handler; packets dispatched before still reach it.
+func (conn *Conn) removeHandler(h *registeredHandler) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.unregister(h)
+}
+
+// Assumes the caller holds the connection's mutex.
+func (conn *Conn) unregister(h *registeredHandler) {
+	// dispatchEvent holds on to the old slice, so never modify it in place
+	handlers := make([]*registeredHandler, 0, len(conn.handlers))
+	for _, other := range conn.handlers {
+		if other != h {
+			handlers = append(handlers, other)
+		}
+	}
+	conn.handlers = handlers
+}
+
+// Whether the handler is to be invoked on the packet; a once handler is
+// claimed and removed by the first matching packet, unless it was
+// registered again while the predicate ran.
+func (conn *Conn) claim(h *registeredHandler, p *Packet) bool {
+	armed := atomic.LoadUint64(&h.armed)
+	if h.once && armed&1 != 0 {
+		return false
+	}
+	if h.pred != nil && !h.pred(p) {
+		return false
+	}
+	if !h.once {
+		return true
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if !atomic.CompareAndSwapUint64(&h.armed, armed, armed|1) {
+		return false
+	}
+	conn.unregister(h)
+	return true
+}
+
+// Metrics of the registered handlers in the order of registration
+func (conn *Conn) handlerStats() []HandlerStats {
+	conn.mutex.Lock()
+	handlers := conn.handlers
+	conn.mutex.Unlock()
+
+	stats := make([]HandlerStats, len(handlers))
+	for i, h := range handlers {
+		stats[i] = h.snapshot()
+	}
+	return stats
+}
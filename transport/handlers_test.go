@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnceHandler(t *testing.T) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	port := (<-conn.Events()).(*OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	socks := make([]*net.UDPConn, 2)
+	for i := range socks {
+		var err error
+		if socks[i], err = net.DialUDP("udp4", nil, addr); err != nil {
+			t.Fatal(err)
+		}
+		defer socks[i].Close()
+	}
+
+	// a persistent filtered handler to tell when every packet was dispatched
+	seen := make(chan string, 64)
+	conn.AddHandlerIf(func(p *Packet) bool {
+		return string(p.Msg) != "ignored"
+	}, func(conn *Conn, p *Packet) {
+		seen <- string(p.Msg)
+	})
+	// a slow predicate keeps the handler registered while the second
+	// match is dispatched, so that both invocations race for it
+	isMatch := func(p *Packet) bool {
+		time.Sleep(2 * time.Millisecond)
+		return string(p.Msg) == "match"
+	}
+
+	for round := 0; round < 20; round++ {
+		var calls int32
+		conn.AddOnceHandler(isMatch, func(conn *Conn, p *Packet) {
+			atomic.AddInt32(&calls, 1)
+		})
+
+		// two matching packets from different peers at nearly the same time
+		socks[0].Write([]byte("ignored"))
+		socks[0].Write([]byte("other"))
+		socks[0].Write([]byte("match"))
+		socks[1].Write([]byte("match"))
+		for i := 0; i < 3; i++ {
+			select {
+			case <-seen:
+			case <-time.After(time.Second):
+				t.Fatalf("TestOnceHandler expected the packets of round %d to be dispatched.", round)
+			}
+		}
+		for i := 0; atomic.LoadInt32(&calls) == 0 && i < 100; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Fatalf("TestOnceHandler expected a single invocation in round %d got %d.", round, n)
+		}
+		if n := len(conn.Stats().Handlers); n != 1 {
+			t.Fatalf("TestOnceHandler expected the once handler to be removed, %d handlers left.", n)
+		}
+	}
+
+	// removing a once handler before it fired keeps it from firing
+	fired := make(chan bool, 1)
+	remove := conn.AddOnceHandler(nil, func(conn *Conn, p *Packet) {
+		fired <- true
+	})
+	remove()
+	socks[0].Write([]byte("match"))
+	<-seen
+	select {
+	case <-fired:
+		t.Fatalf("TestOnceHandler expected a removed handler not to fire.")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestOnceHandlerRearm(t *testing.T) {
+	conn, raw := startMirrored(t)
+	// a round is awaited by the packet carrying its number
+	var round int32
+	fired := make(chan string, 4)
+	once := conn.NewOnceHandler(func(p *Packet) bool {
+		return string(p.Msg) == string(rune('0'+atomic.LoadInt32(&round)))
+	}, func(conn *Conn, p *Packet) {
+		fired <- string(p.Msg)
+	})
+
+	for ; round < 3; atomic.AddInt32(&round, 1) {
+		once.Arm()
+		// arming twice registers the handler once
+		once.Arm()
+		if n := len(conn.Stats().Handlers); n != 1 {
+			t.Fatalf("TestOnceHandlerRearm expected the handler to be registered in round %d got %d handlers.", round, n)
+		}
+		expected := string(rune('0' + round))
+		raw.Write([]byte("x"))
+		raw.Write([]byte(expected))
+		select {
+		case msg := <-fired:
+			if msg != expected {
+				t.Fatalf("TestOnceHandlerRearm expected %q got %q.", expected, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestOnceHandlerRearm expected the handler to fire in round %d.", round)
+		}
+		if n := len(conn.Stats().Handlers); n != 0 {
+			t.Fatalf("TestOnceHandlerRearm expected the handler to be removed in round %d got %d handlers.", round, n)
+		}
+	}
+
+	once.Arm()
+	once.Disarm()
+	raw.Write([]byte("3"))
+	select {
+	case msg := <-fired:
+		t.Fatalf("TestOnceHandlerRearm expected a disarmed handler not to fire got %q.", msg)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCheckedHandler(t *testing.T) {
+	conn, raw := startMirrored(t)
+	conn.SetHandlerErrorEvents(true)
+	rejected := errors.New("not a request")
+	conn.AddCheckedHandler("validate", func(conn *Conn, p *Packet) error {
+		if string(p.Msg) != expectedRequest {
+			return rejected
+		}
+		return nil
+	})
+	plain := make(chan bool, 4)
+	conn.AddHandler(Unchecked(Checked(func(conn *Conn, p *Packet) {
+		plain <- true
+	})))
+
+	raw.Write([]byte(expectedRequest))
+	raw.Write([]byte("bogus"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-plain:
+		case <-time.After(time.Second):
+			t.Fatalf("TestCheckedHandler expected both packets to reach the plain handler.")
+		}
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-conn.Events():
+			herr, ok := e.(*HandlerErrorEvent)
+			if !ok {
+				continue
+			}
+			if herr.Name != "validate" || herr.Err != rejected || herr.From.String() != raw.LocalAddr().String() {
+				t.Fatalf("TestCheckedHandler unexpected event %s.", herr)
+			}
+		case <-timeout:
+			t.Fatalf("TestCheckedHandler expected a HandlerErrorEvent.")
+		}
+		break
+	}
+	for i := 0; ; i++ {
+		stats := conn.Stats().Handlers
+		if stats[0].Calls == 2 {
+			if stats[0].Errors != 1 || stats[1].Errors != 0 {
+				t.Fatalf("TestCheckedHandler expected one error of the checked handler got %+v.", stats)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("TestCheckedHandler expected two calls got %+v.", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,209 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Time a single resolution may take unless changed by SetTimeout
+	DefaultResolveTimeout = 5 * time.Second
+
+	// Time a resolved host is kept unless changed by SetTTL
+	DefaultHostTTL = time.Minute
+)
+
+var ErrResolutionPending = errors.New("Host is being resolved")
+
+// Resolution of a host, complete once done is closed
+type hostEntry struct {
+	done    chan bool
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// Cache of IPv4 addresses of hostnames which are resolved in the
+// background, one lookup at a time per host, so that callers on a latency
+// sensitive path never wait for a slow resolver. Addresses are kept for a
+// TTL; a failure is reported to the waiters and to the next caller, and
+// the host is resolved again after that.
+type HostCache struct {
+	resolver Resolver
+	clock    Clock
+
+	mutex   sync.Mutex
+	timeout time.Duration
+	ttl     time.Duration
+	hosts   map[string]*hostEntry
+}
+
+// Create a cache in front of the resolver.
+func NewHostCache(r Resolver) *HostCache {
+	return &HostCache{
+		resolver: r,
+		clock:    RealClock,
+		timeout:  DefaultResolveTimeout,
+		ttl:      DefaultHostTTL,
+		hosts:    make(map[string]*hostEntry),
+	}
+}
+
+// Replace the source of time used for the TTL.
+func (c *HostCache) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+// Give up on a resolution after the timeout.
+func (c *HostCache) SetTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.timeout = timeout
+}
+
+// Keep resolved addresses for ttl.
+func (c *HostCache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+}
+
+// Addresses of the host if they are cached, without waiting; otherwise
+// its resolution is started and ErrResolutionPending returned.
+func (c *HostCache) Lookup(host string) ([]net.IP, error) {
+	e := c.entry(host)
+	select {
+	case <-e.done:
+		return e.ips, e.err
+	default:
+		return nil, ErrResolutionPending
+	}
+}
+
+// Addresses of the host, waiting for a resolution under way or started
+// now until the context is done.
+func (c *HostCache) Resolve(ctx context.Context, host string) ([]net.IP, error) {
+	e := c.entry(host)
+	select {
+	case <-e.done:
+		return e.ips, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Resolve the host like Resolve without a deadline of its own and invoke
+// the callback with the outcome, from another goroutine unless cached.
+func (c *HostCache) ResolveAsync(host string, callback func([]net.IP, error)) {
+	e := c.entry(host)
+	select {
+	case <-e.done:
+		callback(e.ips, e.err)
+	default:
+		go func() {
+			<-e.done
+			callback(e.ips, e.err)
+		}()
+	}
+}
+
+// Resolve the hosts at once, e.g. the peer set at startup, and wait until
+// all are resolved or the context is done; returns the failures joined.
+func (c *HostCache) Prewarm(ctx context.Context, hosts ...string) error {
+	errs := make(chan error, len(hosts))
+	for _, host := range hosts {
+		go func(host string) {
+			_, err := c.Resolve(ctx, host)
+			errs <- err
+		}(host)
+	}
+	var failed []error
+	for range hosts {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// Entry of the host, starting a resolution unless one is cached or under
+// way. An expired entry is replaced, a failed one is returned a last time.
+func (c *HostCache) entry(host string) *hostEntry {
+	now := c.clock.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.hosts[host]; ok {
+		select {
+		case <-e.done:
+			if e.err == nil && now.Before(e.expires) {
+				return e
+			}
+			if e.err != nil {
+				// report the failure once, then try again
+				delete(c.hosts, host)
+				return e
+			}
+		default:
+			return e
+		}
+	}
+	e := &hostEntry{done: make(chan bool)}
+	c.hosts[host] = e
+	go c.resolve(host, e, c.timeout, c.ttl)
+	return e
+}
+
+func (c *HostCache) resolve(host string, e *hostEntry, timeout, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// a resolver which ignores the context must not hold back the waiters
+	// beyond the timeout
+	type answer struct {
+		ips []net.IP
+		err error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		ips, err := c.resolver.LookupIP(ctx, "ip4", host)
+		answers <- answer{ips, err}
+	}()
+	var a answer
+	select {
+	case a = <-answers:
+	case <-ctx.Done():
+		a.err = ctx.Err()
+	}
+
+	var ips []net.IP
+	for _, ip := range a.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		}
+	}
+	c.mutex.Lock()
+	e.ips = ips
+	switch {
+	case a.err != nil:
+		e.err = &AddrError{Addr: host, Err: ErrUnresolvable, Cause: a.err}
+	case len(ips) == 0:
+		e.err = &AddrError{Addr: host, Err: ErrUnresolvable}
+	}
+	e.expires = c.clock.Now().Add(ttl)
+	c.mutex.Unlock()
+	close(e.done)
+}
+
+// Resolver which defers to lookupIP at the time of the lookup
+type packageResolver struct{}
+
+func (packageResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return lookupIP(ctx, network, host)
+}
+
+// Cache through which NewPacket resolves hostnames; Prewarm it with the
+// peers of the application at startup.
+var DefaultHostCache = NewHostCache(packageResolver{})
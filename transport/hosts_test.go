@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Resolver which answers with loopback after the delay, or never if
+// the delay is negative, whatever the context
+type slowResolver struct {
+	delay   time.Duration
+	lookups atomic.Int32
+	stop    chan bool
+}
+
+func newSlowResolver(t *testing.T, delay time.Duration) *slowResolver {
+	r := &slowResolver{delay: delay, stop: make(chan bool)}
+	t.Cleanup(func() { close(r.stop) })
+	return r
+}
+
+func (r *slowResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.lookups.Add(1)
+	if r.delay < 0 {
+		<-r.stop
+		return nil, errors.New("stopped")
+	}
+	time.Sleep(r.delay)
+	return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+}
+
+func TestDialDeadline(t *testing.T) {
+	conn := NewConn()
+	conn.SetResolver(newSlowResolver(t, -1))
+
+	start := time.Now()
+	err := conn.Dial("peer.invalid:9", start.Add(50*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestDialDeadline expected %q got %v.", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("TestDialDeadline expected Dial to give up after 50ms got %s.", elapsed)
+	}
+	if conn.State() != Idle {
+		t.Fatalf("TestDialDeadline expected %s got %s.", Idle, conn.State())
+	}
+}
+
+func TestDialPrewarmed(t *testing.T) {
+	resolver := newSlowResolver(t, 20*time.Millisecond)
+	conn := NewConn()
+	conn.SetResolver(resolver)
+	if err := conn.Hosts().Prewarm(context.Background(), "a.invalid", "b.invalid"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the names are cached, so no lookup is left to wait for
+	if err := conn.Dial("b.invalid:9", time.Now().Add(time.Millisecond)); err != nil {
+		t.Fatalf("TestDialPrewarmed expected the cached address to be dialed got %v.", err)
+	}
+	defer conn.Disconnect()
+	if n := resolver.lookups.Load(); n != 2 {
+		t.Fatalf("TestDialPrewarmed expected 2 lookups got %d.", n)
+	}
+}
+
+func TestHostCache(t *testing.T) {
+	resolver := newSlowResolver(t, 50*time.Millisecond)
+	hosts := NewHostCache(resolver)
+
+	if _, err := hosts.Lookup("peer.invalid"); err != ErrResolutionPending {
+		t.Fatalf("TestHostCache expected %q got %v.", ErrResolutionPending, err)
+	}
+	done := make(chan []net.IP, 1)
+	hosts.ResolveAsync("peer.invalid", func(ips []net.IP, err error) {
+		done <- ips
+	})
+	ips, err := hosts.Resolve(context.Background(), "peer.invalid")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("TestHostCache unexpected addresses %v %v.", ips, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("TestHostCache expected the callback to be invoked.")
+	}
+	if ips, err := hosts.Lookup("peer.invalid"); err != nil || len(ips) != 1 {
+		t.Fatalf("TestHostCache expected the cached address got %v %v.", ips, err)
+	}
+	if n := resolver.lookups.Load(); n != 1 {
+		t.Fatalf("TestHostCache expected one lookup for every caller got %d.", n)
+	}
+
+	// expired addresses are resolved again
+	clock := NewManualClock(time.Now())
+	hosts.SetClock(clock)
+	clock.Advance(2 * DefaultHostTTL)
+	if _, err := hosts.Lookup("peer.invalid"); err != ErrResolutionPending {
+		t.Fatalf("TestHostCache expected %q after the TTL got %v.", ErrResolutionPending, err)
+	}
+}
+
+func TestHostCacheTimeout(t *testing.T) {
+	hosts := NewHostCache(newSlowResolver(t, -1))
+	hosts.SetTimeout(20 * time.Millisecond)
+
+	_, err := hosts.Resolve(context.Background(), "peer.invalid")
+	var addrErr *AddrError
+	if !errors.Is(err, ErrUnresolvable) || !errors.As(err, &addrErr) || addrErr.Cause != context.DeadlineExceeded {
+		t.Fatalf("TestHostCacheTimeout expected %q after the timeout got %v.", ErrUnresolvable, err)
+	}
+
+	// the failure is reported once more, then the host is looked up again
+	if _, err := hosts.Lookup("peer.invalid"); !errors.Is(err, ErrUnresolvable) {
+		t.Fatalf("TestHostCacheTimeout expected %q got %v.", ErrUnresolvable, err)
+	}
+	if _, err := hosts.Lookup("peer.invalid"); err != ErrResolutionPending {
+		t.Fatalf("TestHostCacheTimeout expected a new resolution got %v.", err)
+	}
+}
+
+func TestNewPacketPending(t *testing.T) {
+	lookup, cache := lookupIP, DefaultHostCache
+	defer func() { lookupIP, DefaultHostCache = lookup, cache }()
+	lookupIP = newSlowResolver(t, 20*time.Millisecond).LookupIP
+	DefaultHostCache = NewHostCache(packageResolver{})
+
+	if _, err := NewPacket("cold.invalid:9", nil); !errors.Is(err, ErrResolutionPending) {
+		t.Fatalf("TestNewPacketPending expected %q got %v.", ErrResolutionPending, err)
+	}
+	packets := make(chan *Packet, 1)
+	NewPacketAsync("cold.invalid:9", Message("hello"), func(p *Packet, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		packets <- p
+	})
+	select {
+	case p := <-packets:
+		if p == nil || p.Addr.Port != 9 || !p.Addr.IP.Equal(net.IPv4(127, 0, 0, 1)) || string(p.Msg) != "hello" {
+			t.Fatalf("TestNewPacketPending unexpected packet %v.", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestNewPacketPending expected the callback to be invoked.")
+	}
+	if _, err := NewPacket("cold.invalid:9", nil); err != nil {
+		t.Fatalf("TestNewPacketPending expected the cached address got %v.", err)
+	}
+}
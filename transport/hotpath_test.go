@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+// Allocations per packet on the hot paths, asserted by TestHotPathAllocs:
+// a SendTo to an address reuses the pooled envelope of a packet written
+// before, and a received packet costs the packet with its address and,
+// unless it is small enough to be kept inline, the copy of the message,
+// whether it is dispatched to a goroutine per handler or on a shard.
+const (
+	sendAllocs    = 0
+	receiveAllocs = 2
+)
+
+// Connection and a plain socket which discards what it receives
+func startSink(tb testing.TB) (*Conn, *net.UDPAddr) {
+	conn := NewConn()
+	if err := conn.Listen(0); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(conn.Disconnect)
+	sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { sink.Close() })
+	go func() {
+		buff := make([]byte, MessageSize)
+		for {
+			if _, _, err := sink.ReadFromUDPAddrPort(buff); err != nil {
+				return
+			}
+		}
+	}()
+	return conn, sink.LocalAddr().(*net.UDPAddr)
+}
+
+// Queue a message and wait for the sending loop to write it.
+func sendWritten(conn *Conn, msg Message, addr *net.UDPAddr) {
+	conn.SendTo(msg, addr)
+	for conn.unsent.Count() > 0 {
+		runtime.Gosched()
+	}
+}
+
+// Connection dispatching to a handler which signals every packet, on as
+// many shards unless zero, and a plain socket connected to it
+func startDispatch(tb testing.TB, shards int) (*net.UDPConn, chan struct{}) {
+	conn := NewConn()
+	if shards > 0 {
+		conn.SetDispatchShards(shards)
+	}
+	handled := make(chan struct{}, 1)
+	conn.AddHandler(func(conn *Conn, p *Packet) { handled <- struct{}{} })
+	if err := conn.Listen(0); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(conn.Disconnect)
+	<-conn.Events()
+	raw, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().Port})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { raw.Close() })
+	return raw, handled
+}
+
+func TestHotPathAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("TestHotPathAllocs does not count allocations under the race detector")
+	}
+	conn, addr := startSink(t)
+	msg := Message(expectedRequest)
+	if n := testing.AllocsPerRun(1000, func() { sendWritten(conn, msg, addr) }); n > sendAllocs {
+		t.Fatalf("TestHotPathAllocs expected at most %d allocations per sent packet got %.1f.", sendAllocs, n)
+	}
+
+	for _, shards := range []int{0, 1} {
+		raw, handled := startDispatch(t, shards)
+		if n := testing.AllocsPerRun(1000, func() {
+			raw.Write(msg)
+			<-handled
+		}); n > receiveAllocs {
+			t.Fatalf("TestHotPathAllocs expected at most %d allocations per received packet on %d shards got %.1f.", receiveAllocs, shards, n)
+		}
+	}
+}
+
+func BenchmarkSendUnicast(b *testing.B) {
+	conn, addr := startSink(b)
+	msg := Message(expectedRequest)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sendWritten(conn, msg, addr)
+	}
+}
+
+func BenchmarkReceiveDispatch(b *testing.B) {
+	raw, handled := startDispatch(b, 0)
+	msg := []byte(expectedRequest)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw.Write(msg)
+		<-handled
+	}
+}
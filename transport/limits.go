@@ -0,0 +1,77 @@
+package transport
+
+import "errors"
+
+var (
+	ErrTooManyPeers  = errors.New("Peer limit reached")
+	ErrTooManyUnsent = errors.New("Limit of unsent packets reached")
+)
+
+// Hard caps on what a connection allocates, for hosts which embed several
+// of them; zero means unlimited, which is the default. Stats reports the
+// usage of each.
+type Limits struct {
+	// Distinct peers tracked at once. A new peer beyond the cap is refused
+	// unless an entry has been idle for the idle period of the peer table:
+	// sends to it fail with ErrTooManyPeers and its packets are dropped
+	// with a DropEvent carrying that error, while known peers keep their
+	// entries. The cap bounds the peer table in place of its size limit.
+	Peers int
+
+	// Packets queued or held by the sending loop at once; beyond the cap
+	// sends fail with ErrTooManyUnsent rather than wait.
+	Unsent int
+
+	// Handler goroutines running at once, like SetHandlerLimit with
+	// DropWhenSaturated, so that packets beyond the cap are dropped with
+	// ErrSaturated.
+	Handlers int
+}
+
+func (l Limits) validate() error {
+	switch {
+	case l.Peers < 0:
+		return &ConfigError{"Limits.Peers", "must not be negative"}
+	case l.Unsent < 0:
+		return &ConfigError{"Limits.Unsent", "must not be negative"}
+	case l.Handlers < 0:
+		return &ConfigError{"Limits.Handlers", "must not be negative"}
+	}
+	return nil
+}
+
+// Enforce the limits; a ConfigError reports a negative one. Fails with
+// ErrAlreadyConnected unless called before the socket is opened.
+func (conn *Conn) SetLimits(l Limits) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if err := conn.checkClosed(); err != nil {
+		return err
+	}
+	conn.limits = l
+	conn.peers.hardMax = l.Peers
+	if l.Handlers > 0 {
+		conn.setHandlerLimit(l.Handlers, DropWhenSaturated)
+	}
+	return nil
+}
+
+func (conn *Conn) Limits() Limits {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.limits
+}
+
+// Count n more unsent packets unless that exceeds the limit.
+func (conn *Conn) admitUnsent(n int) error {
+	conn.mutex.Lock()
+	limit := conn.limits.Unsent
+	conn.mutex.Unlock()
+	if !conn.unsent.addUpTo(n, limit) {
+		return ErrTooManyUnsent
+	}
+	return nil
+}
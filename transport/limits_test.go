@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLimited(t *testing.T, limits Limits, configure func(*Conn)) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	if configure != nil {
+		configure(conn)
+	}
+	if err := conn.SetLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	<-conn.Events()
+	return conn
+}
+
+func rawPeer(t *testing.T) (*net.UDPConn, *net.UDPAddr) {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	return sock, sock.LocalAddr().(*net.UDPAddr)
+}
+
+func TestLimitPeers(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	received := make(chan *net.UDPAddr, 8)
+	conn := listenLimited(t, Limits{Peers: 2}, func(conn *Conn) {
+		conn.SetClock(clock)
+		conn.SetPeerTableLimits(DefaultMaxPeers, time.Minute)
+		conn.AddHandler(func(conn *Conn, p *Packet) {
+			received <- p.Addr
+		})
+	})
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().Port}
+	first, firstAddr := rawPeer(t)
+	_, secondAddr := rawPeer(t)
+	third, thirdAddr := rawPeer(t)
+
+	for _, to := range []*net.UDPAddr{firstAddr, secondAddr} {
+		if err := conn.SendTo(Message("hello"), to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-conn.Quiesced()
+	if err := conn.SendTo(Message("hello"), thirdAddr); err != ErrTooManyPeers {
+		t.Fatalf("TestLimitPeers expected ErrTooManyPeers for a third peer got %v.", err)
+	}
+	if err := conn.Multisend(Message("hello"), []*net.UDPAddr{firstAddr, thirdAddr}); !errors.Is(err, ErrTooManyPeers) {
+		t.Fatalf("TestLimitPeers expected Multisend to refuse the third peer got %v.", err)
+	}
+	<-conn.Quiesced()
+
+	// the third peer is dropped while the known ones are still heard
+	third.WriteToUDP([]byte("knock"), addr)
+	first.WriteToUDP([]byte("ping"), addr)
+	select {
+	case from := <-received:
+		if from.Port != firstAddr.Port {
+			t.Fatalf("TestLimitPeers expected only the known peer to be delivered got %s.", from)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestLimitPeers expected the known peer to be delivered.")
+	}
+	timeout := time.After(time.Second)
+	for dropped := false; !dropped; {
+		select {
+		case e := <-conn.Events():
+			if e, ok := e.(*DropEvent); ok {
+				dropped = e.Reason == ErrTooManyPeers && e.From.Port == thirdAddr.Port
+			}
+		case <-timeout:
+			t.Fatalf("TestLimitPeers expected a DropEvent for the third peer.")
+		}
+	}
+	stats := conn.Stats()
+	if stats.Peers != 2 || stats.DroppedPeerLimit != 1 {
+		t.Fatalf("TestLimitPeers expected 2 peers and 1 drop got %d and %d.", stats.Peers, stats.DroppedPeerLimit)
+	}
+	if peer, ok := conn.peers.lookup(firstAddr); !ok || peer.PacketsOut != 2 || peer.PacketsIn != 1 {
+		t.Fatalf("TestLimitPeers expected the known peers to keep their entries got %+v.", peer)
+	}
+
+	// idle entries make room
+	clock.Advance(2 * time.Minute)
+	if err := conn.SendTo(Message("hello"), thirdAddr); err != nil {
+		t.Fatalf("TestLimitPeers expected room once the peers were idle got %v.", err)
+	}
+}
+
+func TestLimitUnsent(t *testing.T) {
+	conn := listenLimited(t, Limits{Unsent: 3}, func(conn *Conn) {
+		conn.UseShaper(NewShaper(Shaping{Delay: time.Hour}))
+	})
+	_, addr := rawPeer(t)
+	for i := 0; i < 3; i++ {
+		if err := conn.SendTo(Message{byte(i)}, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.SendTo(Message("more"), addr); err != ErrTooManyUnsent {
+		t.Fatalf("TestLimitUnsent expected ErrTooManyUnsent got %v.", err)
+	}
+	if err := conn.Multisend(Message("more"), []*net.UDPAddr{addr}); err != ErrTooManyUnsent {
+		t.Fatalf("TestLimitUnsent expected Multisend to be refused got %v.", err)
+	}
+	if s := conn.Stats(); s.Unsent != 3 {
+		t.Fatalf("TestLimitUnsent expected the queued packets to stay got %d.", s.Unsent)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	conn := NewConn()
+	var cfgErr *ConfigError
+	if err := conn.SetLimits(Limits{Unsent: -1}); !errors.As(err, &cfgErr) || cfgErr.Field != "Limits.Unsent" {
+		t.Fatalf("TestSetLimits expected a ConfigError for Limits.Unsent got %v.", err)
+	}
+	limits := Limits{Peers: 8, Handlers: 2}
+	if err := conn.SetLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Limits() != limits || cap(conn.handlerSlots) != 2 || conn.saturation != DropWhenSaturated {
+		t.Fatalf("TestSetLimits expected the handler cap to drop beyond 2 got %d, %v.", cap(conn.handlerSlots), conn.saturation)
+	}
+}
+
+func TestLimitsWhileOpen(t *testing.T) {
+	conn := listenLimited(t, Limits{Handlers: 2}, nil)
+	if err := conn.SetLimits(Limits{Handlers: 4}); err != ErrAlreadyConnected {
+		t.Fatalf("TestLimitsWhileOpen expected SetLimits to fail with %s got %v.", ErrAlreadyConnected, err)
+	}
+	if err := conn.SetHandlerLimit(4, WaitWhenSaturated); err != ErrAlreadyConnected {
+		t.Fatalf("TestLimitsWhileOpen expected SetHandlerLimit to fail with %s got %v.", ErrAlreadyConnected, err)
+	}
+	if err := conn.SetDispatchQueue(4, WaitWhenSaturated); err != ErrAlreadyConnected {
+		t.Fatalf("TestLimitsWhileOpen expected SetDispatchQueue to fail with %s got %v.", ErrAlreadyConnected, err)
+	}
+	if cap(conn.handlerSlots) != 2 || conn.saturation != DropWhenSaturated {
+		t.Fatalf("TestLimitsWhileOpen expected the handler cap of 2 to stay got %d, %v.", cap(conn.handlerSlots), conn.saturation)
+	}
+
+	conn.Disconnect()
+	if err := conn.SetHandlerLimit(4, WaitWhenSaturated); err != nil {
+		t.Fatalf("TestLimitsWhileOpen expected a closed connection to accept a handler limit got %s.", err)
+	}
+}
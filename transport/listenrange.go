@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ErrPortRange = errors.New("Port range is empty or exceeds 65535")
+
+// Order in which ListenRange tries the ports of its range
+type PortOrder int
+
+const (
+	// From the lowest to the highest port; this is the default
+	PortsAscending PortOrder = iota
+
+	// In an order drawn from Conn.Rand, so that nodes started together
+	// on one host do not race for the same ports
+	PortsRandom
+)
+
+// Failure to bind any port of a ListenRange, with the error of each port
+// in the order they were tried
+type ListenRangeError struct {
+	Attempts []PortAttempt
+}
+
+type PortAttempt struct {
+	Port uint
+	Err  error
+}
+
+func (e *ListenRangeError) Error() string {
+	attempts := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		attempts[i] = fmt.Sprintf("%d: %s", a.Port, a.Err)
+	}
+	return "no port available: " + strings.Join(attempts, "; ")
+}
+
+// Select the order of the ports tried by ListenRange. Must be called
+// before the socket is opened.
+func (conn *Conn) SetPortOrder(order PortOrder) {
+	conn.portOrder = order
+}
+
+// Listen like Listen on the first port of [lo, hi] which can be bound,
+// trying them in the order set by SetPortOrder, and return the local
+// address. Advertise this address rather than the preferred port. If no
+// port can be bound, the error is a *ListenRangeError with the failure
+// of each one.
+func (conn *Conn) ListenRange(lo, hi uint) (*net.UDPAddr, error) {
+	if lo > hi || hi > 65535 {
+		return nil, ErrPortRange
+	}
+	ports := make([]uint, 0, hi-lo+1)
+	for port := lo; port <= hi; port++ {
+		ports = append(ports, port)
+	}
+	if conn.portOrder == PortsRandom {
+		conn.rnd.Shuffle(len(ports), func(i, j int) { ports[i], ports[j] = ports[j], ports[i] })
+	}
+
+	var attempts []PortAttempt
+	for _, port := range ports {
+		err := conn.Listen(port)
+		switch {
+		case err == nil:
+			return conn.LocalAddr(), nil
+		case errors.Is(err, ErrAlreadyConnected), errors.Is(err, ErrClosedConn):
+			// the connection, not the port, is the problem
+			return nil, err
+		}
+		attempts = append(attempts, PortAttempt{port, err})
+	}
+	return nil, &ListenRangeError{attempts}
+}
+
+// Local end-point of the socket, nil unless it is open.
+func (conn *Conn) LocalAddr() *net.UDPAddr {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.sock == nil {
+		return nil
+	}
+	addr, _ := conn.sock.LocalAddr().(*net.UDPAddr)
+	return addr
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// Find a run of n free ports by binding and releasing them.
+func freePorts(t *testing.T, n int) uint {
+	for attempt := 0; attempt < 20; attempt++ {
+		sock, err := net.ListenUDP("udp4", &net.UDPAddr{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lo := sock.LocalAddr().(*net.UDPAddr).Port
+		sock.Close()
+		if lo+n > 65535 {
+			continue
+		}
+		free := true
+		for port := lo; port < lo+n && free; port++ {
+			sock, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+			if err != nil {
+				free = false
+				continue
+			}
+			sock.Close()
+		}
+		if free {
+			return uint(lo)
+		}
+	}
+	t.Fatalf("no run of %d free ports", n)
+	return 0
+}
+
+func TestListenRange(t *testing.T) {
+	lo := freePorts(t, 3)
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(lo)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	conn := NewConn()
+	addr, err := conn.ListenRange(lo, lo+2)
+	if err != nil {
+		t.Fatalf("TestListenRange expected to bind a port got %v.", err)
+	}
+	defer conn.Disconnect()
+	if addr.Port != int(lo+1) || conn.LocalAddr().Port != addr.Port {
+		t.Fatalf("TestListenRange expected port %d got %v.", lo+1, addr)
+	}
+	e := (<-conn.Events()).(*OpenEvent)
+	if e.LocalAddr.(*net.UDPAddr).Port != addr.Port {
+		t.Fatalf("TestListenRange expected the OpenEvent to report %v got %v.", addr, e.LocalAddr)
+	}
+	if _, err := conn.ListenRange(lo, lo+2); err != ErrAlreadyConnected {
+		t.Fatalf("TestListenRange expected %v got %v.", ErrAlreadyConnected, err)
+	}
+}
+
+func TestListenRangeExhausted(t *testing.T) {
+	lo := freePorts(t, 2)
+	for port := lo; port < lo+2; port++ {
+		sock, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(port)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sock.Close()
+	}
+
+	conn, err := NewConnFromConfig(&Config{PortOrder: PortsRandom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ListenRange(lo, lo+1)
+	var rangeErr *ListenRangeError
+	if !errors.As(err, &rangeErr) || len(rangeErr.Attempts) != 2 || conn.IsConnected() {
+		t.Fatalf("TestListenRangeExhausted expected both ports to fail got %v.", err)
+	}
+	tried := map[uint]bool{rangeErr.Attempts[0].Port: true, rangeErr.Attempts[1].Port: true}
+	if !tried[lo] || !tried[lo+1] || rangeErr.Attempts[0].Err == nil {
+		t.Fatalf("TestListenRangeExhausted expected an error per port got %v.", err)
+	}
+
+	if _, err := conn.ListenRange(lo+1, lo); err != ErrPortRange {
+		t.Fatalf("TestListenRangeExhausted expected %v got %v.", ErrPortRange, err)
+	}
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"io"
+
+	"github.com/ahorn/gossip/internal/promtext"
+)
+
+// Render Stats in the Prometheus text exposition format, e.g. from the
+// HTTP handler an application serves its metrics with. The names are
+// stable:
+//
+//	gossip_transport_handlers_running          gauge
+//	gossip_transport_handlers_high_water       gauge
+//	gossip_transport_dispatch_queue_depth      gauge
+//	gossip_transport_dispatch_queue_high_water gauge
+//	gossip_transport_peers                     gauge
+//	gossip_transport_unsent_packets            gauge
+//	gossip_transport_dropped_packets_total     counter, reason: saturated, queue_full, peer_limit
+//	gossip_transport_truncated_total           counter
+//	gossip_transport_dedup_suppressed_total    counter
+//	gossip_transport_retained_expired_total    counter
+//	gossip_transport_echoes_total              counter, result: answered, limited
+//	gossip_transport_events_dropped_total      counter
+//	gossip_transport_broadcast_loops_total     counter
+//	gossip_transport_broadcasts_limited_total  counter
+//	gossip_transport_datagram_size_bytes       histogram, direction: in, out
+//	gossip_transport_peer_bytes                gauge, peer; the decayed TopTalkers
+//	gossip_transport_handler_calls_total       counter, handler
+//	gossip_transport_handler_seconds_total     counter, handler
+//	gossip_transport_handler_max_seconds       gauge, handler
+//	gossip_transport_handler_slow_total        counter, handler
+//	gossip_transport_handler_errors_total      counter, handler
+func (conn *Conn) WriteMetrics(w io.Writer) error {
+	s := conn.Stats()
+	p := promtext.NewWriter(w)
+
+	p.Gauge("gossip_transport_handlers_running", "Handler goroutines currently running.", float64(s.HandlersRunning))
+	p.Gauge("gossip_transport_handlers_high_water", "Largest number of handler goroutines running at once.", float64(s.HandlersHighWater))
+	p.Gauge("gossip_transport_dispatch_queue_depth", "Packets waiting in the dispatch queue.", float64(s.QueueDepth))
+	p.Gauge("gossip_transport_dispatch_queue_high_water", "Largest depth of the dispatch queue.", float64(s.QueueHighWater))
+	p.Gauge("gossip_transport_peers", "Remote end-points tracked.", float64(s.Peers))
+	p.Gauge("gossip_transport_unsent_packets", "Packets queued but not yet written.", float64(s.Unsent))
+
+	p.Family("gossip_transport_dropped_packets_total", promtext.Counter, "Incoming packets discarded before dispatch.")
+	p.Sample("gossip_transport_dropped_packets_total", float64(s.DroppedSaturated), "reason", "saturated")
+	p.Sample("gossip_transport_dropped_packets_total", float64(s.DroppedQueueFull), "reason", "queue_full")
+	p.Sample("gossip_transport_dropped_packets_total", float64(s.DroppedPeerLimit), "reason", "peer_limit")
+	p.Counter("gossip_transport_truncated_total", "Datagrams larger than MessageSize.", float64(s.Truncated))
+	p.Counter("gossip_transport_dedup_suppressed_total", "Messages suppressed as repeats within the dedup horizon.", float64(s.DedupSuppressed))
+	p.Counter("gossip_transport_retained_expired_total", "Packets kept across a redial which were too old to be sent.", float64(s.RetainedExpired))
+	p.Family("gossip_transport_echoes_total", promtext.Counter, "Diagnostic echoes received by the responder.")
+	p.Sample("gossip_transport_echoes_total", float64(s.EchoesAnswered), "result", "answered")
+	p.Sample("gossip_transport_echoes_total", float64(s.EchoesLimited), "result", "limited")
+	p.Counter("gossip_transport_events_dropped_total", "Events discarded because nobody drained them.", float64(s.EventsDropped))
+	p.Counter("gossip_transport_broadcast_loops_total", "Own broadcasts which came back.", float64(s.BroadcastLoops))
+	p.Counter("gossip_transport_broadcasts_limited_total", "Broadcasts from others above the inbound rate cap.", float64(s.BroadcastsLimited))
+
+	bounds := make([]float64, len(SizeBuckets))
+	for i, b := range SizeBuckets {
+		bounds[i] = float64(b)
+	}
+	p.Family("gossip_transport_datagram_size_bytes", promtext.Histogram, "Size of the datagrams received and sent.")
+	p.Histogram("gossip_transport_datagram_size_bytes", bounds, s.SizesIn[:], float64(s.BytesIn), "direction", "in")
+	p.Histogram("gossip_transport_datagram_size_bytes", bounds, s.SizesOut[:], float64(s.BytesOut), "direction", "out")
+
+	p.Family("gossip_transport_peer_bytes", promtext.Gauge, "Recent bytes received from the top talkers, decayed over the talker window.")
+	for _, t := range s.TopTalkers {
+		p.Sample("gossip_transport_peer_bytes", float64(t.Bytes), "peer", t.Addr.String())
+	}
+
+	handlers := []struct {
+		name, typ, help string
+		value           func(HandlerStats) float64
+	}{
+		{"gossip_transport_handler_calls_total", promtext.Counter, "Completed invocations of the handler.", func(h HandlerStats) float64 { return float64(h.Calls) }},
+		{"gossip_transport_handler_seconds_total", promtext.Counter, "Time spent in the handler.", func(h HandlerStats) float64 { return h.Total.Seconds() }},
+		{"gossip_transport_handler_max_seconds", promtext.Gauge, "Longest invocation of the handler.", func(h HandlerStats) float64 { return h.Max.Seconds() }},
+		{"gossip_transport_handler_slow_total", promtext.Counter, "Invocations above the slow handler threshold.", func(h HandlerStats) float64 { return float64(h.Slow) }},
+		{"gossip_transport_handler_errors_total", promtext.Counter, "Invocations which rejected the packet.", func(h HandlerStats) float64 { return float64(h.Errors) }},
+	}
+	for _, f := range handlers {
+		p.Family(f.name, f.typ, f.help)
+		for _, h := range s.Handlers {
+			p.Sample(f.name, f.value(h), "handler", h.Name)
+		}
+	}
+	return p.Err()
+}
@@ -0,0 +1,55 @@
+package transport
+
+import "strconv"
+
+// Transforms a packet on its way to or from the socket. Returning a
+// different packet substitutes it for the rest of the chain; returning
+// an error (or a nil packet) drops it.
+type Middleware func(p *Packet) (*Packet, error)
+
+// Register a middleware which is applied to every incoming packet before
+// it is dispatched to the event handlers, after all previously registered
+// ingress middleware. Dropped packets are announced by a DropEvent. The
+// middleware is a stage of kind StageAny named "middleware-<n>"; see
+// UseStage.
+func (conn *Conn) Use(m Middleware) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.unnamed++
+	conn.addStage("middleware-"+strconv.Itoa(conn.unnamed), StageAny, m)
+}
+
+// Register a middleware which is applied to every outgoing packet in the
+// sending loop, regardless of which method queued it, after all previously
+// registered egress middleware. A dropped packet is reported as *SendError,
+// either on Err or to the completion callback given to SendToAsync.
+func (conn *Conn) UseEgress(m Middleware) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.egress = appendMiddleware(conn.egress, m)
+}
+
+// Copy on write so that running chains can be read without holding the lock.
+func appendMiddleware(chain []Middleware, m Middleware) []Middleware {
+	c := make([]Middleware, len(chain), len(chain)+1)
+	copy(c, chain)
+	return append(c, m)
+}
+
+// Run the packet through the chain; returns nil if it has been dropped
+// without an error.
+func applyMiddleware(chain []Middleware, p *Packet) (*Packet, error) {
+	for _, m := range chain {
+		var err error
+		if p, err = m(p); err != nil || p == nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (conn *Conn) middleware() (ingress, egress []Middleware) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.ingress, conn.egress
+}
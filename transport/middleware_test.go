@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEgressMiddlewareDrop(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	conn := NewConn()
+	conn.UseEgress(func(p *Packet) (*Packet, error) {
+		return nil, errRejected
+	})
+	if err := conn.Dial("127.0.0.1:9911", time.Time{}); err != nil {
+		t.Fatalf("TestEgressMiddlewareDrop cannot dial: %s", err)
+	}
+	defer conn.Disconnect()
+	conn.Send([]byte(expectedRequest))
+
+	select {
+	case err := <-conn.Err:
+		if sendErr, ok := err.(*SendError); !ok || sendErr.Err != errRejected {
+			t.Fatalf("TestEgressMiddlewareDrop expected %q got %v.", errRejected, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestEgressMiddlewareDrop no error reported")
+	}
+}
+
+func TestIngressMiddlewareDrop(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	conn := NewConn()
+	conn.Use(func(p *Packet) (*Packet, error) {
+		if string(p.Msg) == "drop" {
+			return nil, errRejected
+		}
+		return p, nil
+	})
+	dispatched := make(chan Message, 2)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		dispatched <- p.Msg
+	})
+
+	conn.dispatchEvent(&Packet{Msg: Message("drop")})
+	conn.dispatchEvent(&Packet{Msg: Message("keep")})
+
+	if msg := <-dispatched; string(msg) != "keep" {
+		t.Fatalf("TestIngressMiddlewareDrop expected %q got %q.", "keep", msg)
+	}
+	if e, ok := (<-conn.Events()).(*DropEvent); !ok || e.Reason != errRejected {
+		t.Fatalf("TestIngressMiddlewareDrop expected drop event got %v.", e)
+	}
+}
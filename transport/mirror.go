@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"sync"
+)
+
+// Packets held for a mirror whose channel is not drained, unless set by
+// MirrorOptions.Buffer
+const DefaultMirrorBuffer = 256
+
+// Options of Conn.Mirror
+type MirrorOptions struct {
+	// Packets held while the channel of the mirror is full before further
+	// ones are dropped; zero selects DefaultMirrorBuffer
+	Buffer int
+
+	// Mirror packets as read from the socket rather than as the handlers
+	// get them, i.e. before the ingress middleware
+	Raw bool
+}
+
+// Counters of a mirror
+type MirrorStats struct {
+	Mirrored uint64
+	// Packets which found the buffer full
+	Dropped uint64
+}
+
+// Copy of the inbound traffic attached by Conn.Mirror
+type Mirror struct {
+	conn *Conn
+	raw  bool
+	buff chan *Packet
+
+	mutex sync.Mutex
+	stats MirrorStats
+
+	detached chan bool
+	once     sync.Once
+}
+
+// Tee every inbound packet to ch, e.g. for a UI or an analysis process,
+// independent of the handlers: mirrors neither count against the handler
+// limit nor see fewer packets because of it. Each mirror receives its own
+// copy of every packet. Packets are buffered while ch is full and dropped
+// and counted once the buffer is full as well, so a stuck consumer never
+// stalls dispatch. Mirrors may be attached and detached at any time; ch
+// is not closed on Detach.
+func (conn *Conn) Mirror(ch chan<- *Packet, opts MirrorOptions) *Mirror {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultMirrorBuffer
+	}
+	m := &Mirror{
+		conn:     conn,
+		raw:      opts.Raw,
+		buff:     make(chan *Packet, opts.Buffer),
+		detached: make(chan bool),
+	}
+	conn.spawnRole("mirror", func() { m.forward(ch) })
+
+	conn.mutex.Lock()
+	conn.mirrors = append(conn.mirrors, m)
+	conn.mutex.Unlock()
+	return m
+}
+
+// Hand the buffered packets to the consumer until detached.
+func (m *Mirror) forward(ch chan<- *Packet) {
+	for {
+		select {
+		case p := <-m.buff:
+			select {
+			case ch <- p:
+			case <-m.detached:
+				return
+			}
+		case <-m.detached:
+			return
+		}
+	}
+}
+
+// Copy the packet into the buffers of the mirrors which want packets at
+// this stage.
+func mirror(mirrors []*Mirror, p *Packet, raw bool) {
+	for _, m := range mirrors {
+		if m.raw != raw {
+			continue
+		}
+		c := *p
+		c.Msg = copyMessage(p.Msg)
+		select {
+		case m.buff <- &c:
+			m.count(&m.stats.Mirrored)
+		default:
+			m.count(&m.stats.Dropped)
+		}
+	}
+}
+
+func (m *Mirror) count(counter *uint64) {
+	m.mutex.Lock()
+	*counter++
+	m.mutex.Unlock()
+}
+
+func (m *Mirror) Stats() MirrorStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stats
+}
+
+// Stop mirroring; packets still buffered are discarded.
+func (m *Mirror) Detach() {
+	m.once.Do(func() {
+		close(m.detached)
+		conn := m.conn
+		conn.mutex.Lock()
+		defer conn.mutex.Unlock()
+		mirrors := make([]*Mirror, 0, len(conn.mirrors))
+		for _, other := range conn.mirrors {
+			if other != m {
+				mirrors = append(mirrors, other)
+			}
+		}
+		conn.mirrors = mirrors
+	})
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Connection on loopback with a raw socket sending to it
+func startMirrored(t *testing.T) (*Conn, *net.UDPConn) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	port := (<-conn.Events()).(*OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	raw, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	return conn, raw
+}
+
+func TestMirror(t *testing.T) {
+	conn, raw := startMirrored(t)
+	conn.Use(func(p *Packet) (*Packet, error) {
+		return &Packet{Addr: p.Addr, Msg: bytes.ToUpper(p.Msg)}, nil
+	})
+
+	const sent = 20
+	var mutex sync.Mutex
+	var handled []string
+	done := make(chan bool)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		mutex.Lock()
+		handled = append(handled, string(p.Msg))
+		n := len(handled)
+		mutex.Unlock()
+		// must not reach the mirrored copies
+		p.Msg[0] = '!'
+		if n == sent {
+			close(done)
+		}
+	})
+	mirrored := make(chan *Packet, sent)
+	rawMirrored := make(chan *Packet, sent)
+	m := conn.Mirror(mirrored, MirrorOptions{})
+	rm := conn.Mirror(rawMirrored, MirrorOptions{Raw: true})
+
+	for i := 0; i < sent; i++ {
+		raw.Write([]byte(fmt.Sprintf("packet %d", i)))
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("TestMirror expected %d packets to be handled.", sent)
+	}
+
+	seen := make(map[string]bool)
+	mutex.Lock()
+	for _, msg := range handled {
+		seen[msg] = true
+	}
+	mutex.Unlock()
+	for i := 0; i < sent; i++ {
+		p := <-mirrored
+		if !seen[string(p.Msg)] {
+			t.Fatalf("TestMirror expected the dispatched content got %q.", p.Msg)
+		}
+		if r := <-rawMirrored; string(r.Msg) != fmt.Sprintf("packet %d", i) || r.Addr.String() != raw.LocalAddr().String() {
+			t.Fatalf("TestMirror expected raw packet %d got %q from %s.", i, r.Msg, r.Addr)
+		}
+	}
+	if s := m.Stats(); s.Mirrored != sent || s.Dropped != 0 || rm.Stats() != s {
+		t.Fatalf("TestMirror unexpected stats %+v and %+v.", s, rm.Stats())
+	}
+
+	m.Detach()
+	rm.Detach()
+	raw.Write([]byte("after"))
+	time.Sleep(50 * time.Millisecond)
+	if len(mirrored) != 0 || m.Stats().Mirrored != sent {
+		t.Fatalf("TestMirror expected nothing to be mirrored after Detach.")
+	}
+}
+
+func TestMirrorStuck(t *testing.T) {
+	conn, raw := startMirrored(t)
+	const sent = 20
+	handled := make(chan bool, sent)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		handled <- true
+	})
+	// never drained
+	m := conn.Mirror(make(chan *Packet), MirrorOptions{Buffer: 2})
+	defer m.Detach()
+
+	for i := 0; i < sent; i++ {
+		raw.Write([]byte(expectedRequest))
+	}
+	for i := 0; i < sent; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatalf("TestMirrorStuck expected dispatch to continue, got %d of %d.", i, sent)
+		}
+	}
+	if s := m.Stats(); s.Mirrored+s.Dropped != sent || s.Mirrored > 3 {
+		t.Fatalf("TestMirrorStuck expected the overflow to be dropped got %+v.", s)
+	}
+}
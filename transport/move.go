@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+// Request to the sending loop to redirect the packets queued for a peer
+type peerMove struct {
+	from, to *net.UDPAddr
+	done     chan bool
+}
+
+// Follow a peer which moved from one address to another, e.g. after a NAT
+// rebinding or a restart on a new port. Packets queued for the old address,
+// including those held by a scheduler or shaper, are sent to the new one
+// instead, and the peer's entry moves along with what it advertised, while
+// the path properties are left to be discovered anew. Packets written
+// already are lost like any other. Returns once the queued packets have
+// been redirected.
+func (conn *Conn) MovePeer(from, to *net.UDPAddr) {
+	if from == nil || to == nil || peerKey(from) == peerKey(to) {
+		return
+	}
+	conn.peers.move(from, to, conn.clock.Now())
+
+	conn.mutex.Lock()
+	state, moves, done := conn.state, conn.moves, conn.done
+	conn.mutex.Unlock()
+	if !state.isOpen() {
+		return
+	}
+	m := peerMove{from, to, make(chan bool)}
+	select {
+	case moves <- m:
+	case <-done:
+		return
+	}
+	select {
+	case <-m.done:
+	case <-done:
+	}
+}
+
+// Redirect the pending packets of the sending loop according to the move.
+func (m peerMove) apply(pending map[*Packet]*outgoing) {
+	key := peerKey(m.from)
+	for p, o := range pending {
+		if p.Addr != nil && peerKey(p.Addr) == key {
+			p.Addr = m.to
+			o.meta.Peer = m.to
+		}
+	}
+	close(m.done)
+}
+
+// Replace the entry of from by one for to which keeps what the peer
+// advertised about itself but none of the traffic counters or path
+// properties.
+func (t *peerTable) move(from, to *net.UDPAddr, now time.Time) {
+	key := peerKey(from)
+	s := t.shard(key)
+	s.mutex.Lock()
+	old, ok := s.peers[key]
+	if ok {
+		delete(s.peers, key)
+		t.size.Add(-1)
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+	t.update(to, now, func(peer *PeerStats) {
+		peer.DatagramSize = old.DatagramSize
+		if old.CapabilityIncarnation > peer.CapabilityIncarnation {
+			peer.Capabilities, peer.CapabilityIncarnation = old.Capabilities, old.CapabilityIncarnation
+			peer.CapabilitiesProbed = old.CapabilitiesProbed
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMovePeer(t *testing.T) {
+	conn, _, sink := startShaped(t, Shaping{Delay: 100 * time.Millisecond})
+	defer sink.Close()
+	defer conn.Disconnect()
+	<-conn.Events()
+	_, void := rawPeer(t)
+	to := sink.LocalAddr().(*net.UDPAddr)
+	conn.peers.update(void, time.Now(), func(peer *PeerStats) {
+		peer.DatagramSize, peer.PathDatagramSize = 900, 800
+		peer.Capabilities, peer.CapabilityIncarnation = CapCompression, 7
+	})
+
+	// the shaper still holds the packets when the peer moves
+	const n = 3
+	for i := 0; i < n; i++ {
+		if err := conn.SendTo(Message{byte(i)}, void); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.MovePeer(void, to)
+	buf := make([]byte, MessageSize)
+	sink.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := sink.Read(buf); err != nil {
+			t.Fatalf("TestMovePeer expected %d packets at the new address got %d: %s", n, i, err)
+		}
+	}
+
+	if _, ok := conn.peers.lookup(void); ok {
+		t.Fatalf("TestMovePeer expected the old entry to be gone.")
+	}
+	peer, _ := conn.peers.lookup(to)
+	if peer.DatagramSize != 900 || peer.PathDatagramSize != 0 || peer.Capabilities != CapCompression || peer.PacketsOut != n {
+		t.Fatalf("TestMovePeer expected the advertised state to move without the path got %+v.", peer)
+	}
+}
+
+func TestMovePeerClosed(t *testing.T) {
+	conn := NewConn()
+	_, from := rawPeer(t)
+	_, to := rawPeer(t)
+	conn.peers.update(from, time.Now(), func(peer *PeerStats) { peer.DatagramSize = 600 })
+	conn.MovePeer(from, to)
+	if peer, _ := conn.peers.lookup(to); peer.DatagramSize != 600 {
+		t.Fatalf("TestMovePeerClosed expected the entry to move on a closed connection got %+v.", peer)
+	}
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+)
+
+// Destinations of a Multisend which could not be queued. Errors has an
+// entry for every address given, nil for those which were queued.
+type MultisendError struct {
+	Addrs  []*net.UDPAddr
+	Errors []error
+}
+
+func (e *MultisendError) Error() string {
+	failed, first := 0, -1
+	for i, err := range e.Errors {
+		if err != nil {
+			failed++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	return fmt.Sprintf("multisend: %d of %d destinations failed, first %s: %v", failed, len(e.Addrs), e.Addrs[first], e.Errors[first])
+}
+
+func (e *MultisendError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Queue the message for every address, which may be nil for the dialed
+// remote end-point, like a loop of SendTo. All packets share msg, which
+// must not be modified until they have been written; egress middleware
+// gets a private copy per destination since it may rewrite the message in
+// place. The packets reach the scheduler as one batch. Returns a
+// *MultisendError for the destinations which were rejected, e.g. for the
+// wrong address family or a message longer than their MaxPayloadTo,
+// while the others are still sent; failed writes are reported to Err as
+// for SendTo.
+func (conn *Conn) Multisend(msg Message, addrs []*net.UDPAddr) error {
+	conn.mutex.Lock()
+	state, out, done, dedup := conn.state, conn.out, conn.done, conn.dedup
+	conn.mutex.Unlock()
+	now := conn.clock.Now()
+
+	switch {
+	case state == Idle:
+		return ErrNotConnected
+	case !state.isOpen():
+		return ErrClosedConn
+	}
+
+	// one allocation per batch rather than per destination
+	packets := make([]Packet, len(addrs))
+	group := make([]outgoing, len(addrs))
+	batch := make([]*outgoing, 0, len(addrs))
+	var errs []error
+	for i, addr := range addrs {
+		err := checkAddr(addr)
+		if addr == nil && state != Dialed {
+			err = ErrNotDialed
+		}
+		if limit := conn.MaxPayloadTo(addr); err == nil && len(msg) > limit {
+			err = &SizeError{len(msg), limit}
+		}
+		if err == nil && !conn.peers.admit(addr, now) {
+			err = ErrTooManyPeers
+		}
+		if err == nil && dedup != nil && addr != nil && dedup.suppress(addr, msg, now) {
+			conn.stats.dedupSuppressed()
+			continue
+		}
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(addrs))
+			}
+			errs[i] = err
+			continue
+		}
+		packets[i] = Packet{Addr: addr, Msg: msg}
+		group[i] = outgoing{Packet: &packets[i], shared: true}
+		batch = append(batch, &group[i])
+	}
+
+	if len(batch) > 0 {
+		if err := conn.admitUnsent(len(batch)); err != nil {
+			return err
+		}
+		select {
+		case out <- &outgoing{batch: batch}:
+		case <-done:
+			conn.unsent.Add(-len(batch))
+			return ErrClosedConn
+		}
+	}
+	if errs != nil {
+		return &MultisendError{addrs, errs}
+	}
+	return nil
+}
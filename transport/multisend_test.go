@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Sockets bound to loopback which nothing reads from
+func listeners(t testing.TB, n int) ([]*net.UDPConn, []*net.UDPAddr) {
+	socks := make([]*net.UDPConn, n)
+	addrs := make([]*net.UDPAddr, n)
+	for i := range socks {
+		sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		socks[i], addrs[i] = sock, sock.LocalAddr().(*net.UDPAddr)
+	}
+	return socks, addrs
+}
+
+func TestMultisend(t *testing.T) {
+	socks, addrs := listeners(t, 3)
+	for _, sock := range socks {
+		defer sock.Close()
+	}
+
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	// rewrites the message in place for every destination
+	conn.UseEgress(func(p *Packet) (*Packet, error) {
+		p.Msg[0] = byte(p.Addr.Port)
+		return p, nil
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	msg := Message(expectedRequest)
+	v6 := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 9000}
+	err := conn.Multisend(msg, append(addrs, v6))
+	var multiErr *MultisendError
+	if !errors.As(err, &multiErr) || len(multiErr.Errors) != 4 || !errors.Is(err, ErrAddressFamilyMismatch) {
+		t.Fatalf("TestMultisend expected the IPv6 destination to be rejected got %v.", err)
+	}
+	for i, err := range multiErr.Errors[:3] {
+		if err != nil {
+			t.Fatalf("TestMultisend expected destination %d to be queued got %v.", i, err)
+		}
+	}
+
+	buff := make([]byte, MessageSize)
+	for i, sock := range socks {
+		sock.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := sock.ReadFromUDP(buff)
+		if err != nil {
+			t.Fatalf("TestMultisend expected destination %d to receive the message got %v.", i, err)
+		}
+		if buff[0] != byte(addrs[i].Port) || string(buff[1:n]) != expectedRequest[1:] {
+			t.Fatalf("TestMultisend expected the copy of destination %d got %q.", i, buff[:n])
+		}
+	}
+	if string(msg) != expectedRequest {
+		t.Fatalf("TestMultisend expected the shared message to stay intact got %q.", msg)
+	}
+
+	if err := conn.Multisend(msg, addrs); err != nil {
+		t.Fatalf("TestMultisend expected no error got %v.", err)
+	}
+}
+
+// Queue one message for 100 destinations, one SendTo at a time and as a
+// single Multisend.
+func BenchmarkMultisend(b *testing.B) {
+	socks, addrs := listeners(b, 100)
+	for _, sock := range socks {
+		defer sock.Close()
+	}
+	conn := NewConn()
+	if err := conn.Listen(0); err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Disconnect()
+	msg := Message(expectedRequest)
+
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, addr := range addrs {
+				conn.SendTo(msg, addr)
+			}
+		}
+	})
+	b.Run("multisend", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			conn.Multisend(msg, addrs)
+		}
+	})
+}
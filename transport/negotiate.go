@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"net"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+const (
+	// Largest payload of a UDP datagram over IPv4
+	MaxDatagramSize = 65507
+
+	// Datagram size assumed for peers which have not advertised theirs
+	DefaultPeerDatagramSize = MessageSize
+)
+
+// Tell the peer the largest datagram this connection accepts and ask for
+// its own in return, so that both sides size their datagrams to the
+// smaller of the two. Until a peer has answered, datagrams to it are
+// limited to DefaultPeerDatagramSize. The hints are consumed by the
+// connection after the ingress middleware and never reach the handlers.
+func (conn *Conn) AdvertiseDatagramSize(addr *net.UDPAddr) error {
+	return conn.sendSizeHint(addr, false)
+}
+
+func (conn *Conn) sendSizeHint(addr *net.UDPAddr, reply bool) error {
+	conn.mutex.Lock()
+	size, v := conn.datagramSize, conn.encodeVersion
+	conn.mutex.Unlock()
+	msg, err := wire.SizeHint{Size: uint16(size), Reply: reply}.Encode(wire.Version(v))
+	if err != nil {
+		return err
+	}
+	return conn.SendTo(msg, addr)
+}
+
+// Largest datagram exchanged with the peer: the smaller of the datagram
+// size of this connection and the one the peer advertised, or
+// DefaultPeerDatagramSize if it did not advertise any, capped by the path
+// size found by DiscoverPathMTU.
+func (conn *Conn) PeerDatagramSize(addr *net.UDPAddr) int {
+	peerSize := conn.peers.datagramSize(addr)
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.peerSize(peerSize)
+}
+
+// Largest message the send methods accept for the peer, like MaxPayload
+// but based on PeerDatagramSize.
+func (conn *Conn) MaxPayloadTo(addr *net.UDPAddr) int {
+	peerSize := conn.peers.datagramSize(addr)
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.maxPayloadTo(peerSize)
+}
+
+// Record the size hint carried by the packet and answer a request for
+// ours; returns false for packets of other kinds.
+func (conn *Conn) sizeHint(p *Packet) bool {
+	hint, _, err := wire.DecodeSizeHint(p.Msg)
+	if err != nil {
+		return false
+	}
+	if hint.Size > 0 {
+		conn.peers.update(p.Addr, conn.clock.Now(), func(peer *PeerStats) {
+			peer.DatagramSize = int(hint.Size)
+		})
+	}
+	if !hint.Reply {
+		conn.sendSizeHint(p.Addr, true)
+	}
+	return true
+}
+
+// Size of the receive buffer: datagrams from peers which do not know our
+// size are at most DefaultPeerDatagramSize.
+func (conn *Conn) receiveSize() int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.datagramSize < DefaultPeerDatagramSize {
+		return DefaultPeerDatagramSize
+	}
+	return conn.datagramSize
+}
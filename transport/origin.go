@@ -0,0 +1,76 @@
+package transport
+
+import "net"
+
+// Address peers see as the source of what the connection sends, nil
+// unless it is open. A listening connection sends from the port it
+// listens on, so replies to its traffic reach its handlers; advertise
+// this port rather than that of a connection dialed on the side.
+func (conn *Conn) Origin() *net.UDPAddr {
+	return conn.LocalAddr()
+}
+
+// Source address of datagrams from the connection to addr: the port of
+// Origin with the local IP address the kernel routes through, e.g. to
+// tell a peer where this node can be reached. No packet is sent.
+func (conn *Conn) OriginTo(addr *net.UDPAddr) (*net.UDPAddr, error) {
+	origin := conn.Origin()
+	if origin == nil {
+		return nil, ErrNotConnected
+	}
+	if !origin.IP.IsUnspecified() {
+		return origin, nil
+	}
+
+	// connecting a UDP socket only picks the route
+	route, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer route.Close()
+	local := route.LocalAddr().(*net.UDPAddr)
+	return &net.UDPAddr{IP: local.IP, Port: origin.Port}, nil
+}
+
+// Bind the sockets which Dial and DialHost open, unless a Dialer opens
+// them, to the port of the listening origin with SO_REUSEADDR, so that
+// peers see the same source port as for traffic sent by the origin. The
+// dialed socket receives the datagrams its peer sends to the shared port,
+// the origin all others. Dial fails with ErrNotListening unless the origin
+// listens and with ErrNotSupported on platforms other than Linux. Must be
+// called before the socket is opened.
+func (conn *Conn) SetDialOrigin(origin *Conn) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.origin = origin
+}
+
+// Open a direct dialed socket, from the port of the origin if there is one.
+func (conn *Conn) dialDirect(network string, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	if conn.origin == nil {
+		return net.DialUDP(network, nil, raddr)
+	}
+	port, err := conn.origin.shareOrigin()
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{LocalAddr: &net.UDPAddr{Port: port}, Control: reuseAddrControl}
+	sock, err := dialer.Dial(network, raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return sock.(*net.UDPConn), nil
+}
+
+// Allow a dialed socket to bind the listening port and return it.
+func (conn *Conn) shareOrigin() (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.state != Listening {
+		return 0, ErrNotListening
+	}
+	if err := enableReuseAddr(conn.sock); err != nil {
+		return 0, err
+	}
+	return conn.sock.LocalAddr().(*net.UDPAddr).Port, nil
+}
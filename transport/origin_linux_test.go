@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A raw socket which sees every UDP datagram of the host with its IP
+// header, so that source ports are read off the wire rather than from
+// the receiving socket.
+type rawCapture int
+
+func openRawCapture(t *testing.T) rawCapture {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_UDP)
+	if err == syscall.EPERM || err == syscall.EACCES {
+		t.Skip("TestOriginOnTheWire needs the privilege to open raw sockets")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		t.Fatal(err)
+	}
+	return rawCapture(fd)
+}
+
+func (c rawCapture) Close() {
+	syscall.Close(int(c))
+}
+
+// Return the source port of the captured datagram which carries the
+// payload to the port.
+func (c rawCapture) sourcePort(t *testing.T, port int, payload string) int {
+	buf := make([]byte, 1<<16)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		n, _, err := syscall.Recvfrom(int(c), buf, 0)
+		if err != nil || n < 20 {
+			continue
+		}
+		udp := buf[int(buf[0]&0x0f)*4 : n]
+		if len(udp) < 8 || int(binary.BigEndian.Uint16(udp[2:])) != port || string(udp[8:]) != payload {
+			continue
+		}
+		return int(binary.BigEndian.Uint16(udp))
+	}
+	t.Fatalf("TestOriginOnTheWire expected a datagram %q to port %d on the wire.", payload, port)
+	return 0
+}
+
+func TestOriginOnTheWire(t *testing.T) {
+	capture := openRawCapture(t)
+	defer capture.Close()
+
+	// the datagrams need a destination which accepts them
+	sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sinkAddr := sink.LocalAddr().(*net.UDPAddr)
+
+	listener := NewConn()
+	go monitor(listener.Err, t)
+	if err := listener.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Disconnect()
+	<-listener.Events()
+	port := listener.Origin().Port
+
+	listener.SendTo(Message("listener"), sinkAddr)
+	if p := capture.sourcePort(t, sinkAddr.Port, "listener"); p != port {
+		t.Fatalf("TestOriginOnTheWire expected the listening port %d got %d.", port, p)
+	}
+
+	plain := NewConn()
+	if err := plain.Dial(sinkAddr.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	plain.Send(Message("plain"))
+	if p := capture.sourcePort(t, sinkAddr.Port, "plain"); p == port || p != plain.Origin().Port {
+		t.Fatalf("TestOriginOnTheWire expected the ephemeral port %d got %d.", plain.Origin().Port, p)
+	}
+	plain.Disconnect()
+
+	dialed := NewConn()
+	dialed.SetDialOrigin(listener)
+	if err := dialed.Dial(sinkAddr.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	defer dialed.Disconnect()
+	dialed.Send(Message("dialed"))
+	if p := capture.sourcePort(t, sinkAddr.Port, "dialed"); p != port {
+		t.Fatalf("TestOriginOnTheWire expected the shared port %d got %d.", port, p)
+	}
+}
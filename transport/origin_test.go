@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Read a datagram from the capture socket and return its source port.
+func capturedPort(t *testing.T, capture *net.UDPConn, expected string) int {
+	buf := make([]byte, MessageSize)
+	capture.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := capture.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != expected {
+		t.Fatalf("TestDialOrigin expected %q got %q (%v).", expected, buf[:n], err)
+	}
+	return from.Port
+}
+
+func TestDialOrigin(t *testing.T) {
+	capture, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	captureAddr := capture.LocalAddr().(*net.UDPAddr)
+
+	listener := NewConn()
+	go monitor(listener.Err, t)
+	if err := listener.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Disconnect()
+	<-listener.Events()
+	port := listener.Origin().Port
+
+	// a connection dialed on the side has a port of its own
+	plain := NewConn()
+	if err := plain.Dial(captureAddr.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	plain.Send(Message("plain"))
+	if p := capturedPort(t, capture, "plain"); p == port || p != plain.Origin().Port {
+		t.Fatalf("TestDialOrigin expected an ephemeral port other than %d got %d.", port, p)
+	}
+	plain.Disconnect()
+
+	dialed := NewConn()
+	dialed.SetDialOrigin(listener)
+	replies := make(chan string, 1)
+	dialed.AddHandler(func(conn *Conn, p *Packet) {
+		replies <- string(p.Msg)
+	})
+	if err := dialed.Dial(captureAddr.String(), time.Time{}); err == ErrNotSupported {
+		t.Skip("TestDialOrigin needs SO_REUSEADDR sharing of UDP ports")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer dialed.Disconnect()
+
+	// both connections send from the listening port
+	listener.SendTo(Message("listener"), captureAddr)
+	if p := capturedPort(t, capture, "listener"); p != port {
+		t.Fatalf("TestDialOrigin expected the listening port %d got %d.", port, p)
+	}
+	dialed.Send(Message("dialed"))
+	if p := capturedPort(t, capture, "dialed"); p != port || dialed.Origin().Port != port {
+		t.Fatalf("TestDialOrigin expected the shared port %d got %d.", port, p)
+	}
+
+	// and the reply of the dialed peer reaches the dialed connection
+	capture.WriteToUDP([]byte("reply"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	select {
+	case msg := <-replies:
+		if msg != "reply" {
+			t.Fatalf("TestDialOrigin expected %q got %q.", "reply", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestDialOrigin expected the reply at the dialed connection.")
+	}
+
+	if origin, err := listener.OriginTo(captureAddr); err != nil || !origin.IP.Equal(captureAddr.IP) || origin.Port != port {
+		t.Fatalf("TestDialOrigin expected 127.0.0.1:%d towards loopback got %v (%v).", port, origin, err)
+	}
+}
+
+func TestDialOriginNotListening(t *testing.T) {
+	conn := NewConn()
+	conn.SetDialOrigin(NewConn())
+	if err := conn.Dial("127.0.0.1:9", time.Time{}); err != ErrNotListening {
+		t.Fatalf("TestDialOriginNotListening expected ErrNotListening got %v.", err)
+	}
+	if _, err := conn.OriginTo(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}); err != ErrNotConnected {
+		t.Fatalf("TestDialOriginNotListening expected ErrNotConnected got %v.", err)
+	}
+}
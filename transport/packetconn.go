@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Incoming packets held for ReadFrom before further ones are dropped
+const PacketConnBuffer = 64
+
+// Which incoming packets a PacketConn adapter receives
+type Delivery int
+
+const (
+	// Only packets which found no registered handler
+	DeliverUnhandled Delivery = iota
+
+	// Every packet, in addition to the handlers
+	DeliverAll
+)
+
+// Adapter of a Conn to net.PacketConn for libraries which expect one; see
+// Conn.PacketConn. It may be used concurrently with the handlers of the
+// Conn and by several goroutines at once.
+type PacketConn struct {
+	conn     *Conn
+	delivery Delivery
+	in       chan *Packet
+
+	mutex         sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// Closed and replaced whenever the read deadline changes, to wake
+	// blocked readers
+	deadlineChanged chan bool
+
+	closed chan bool
+	once   sync.Once
+}
+
+// Create an adapter which receives the incoming packets selected by
+// delivery and sends through the same path as SendTo. Closing the adapter
+// detaches it without closing the connection. Packets which arrive while
+// PacketConnBuffer packets are waiting for ReadFrom are dropped with a
+// DropEvent for ErrQueueFull.
+func (conn *Conn) PacketConn(delivery Delivery) *PacketConn {
+	pc := &PacketConn{
+		conn:            conn,
+		delivery:        delivery,
+		in:              make(chan *Packet, PacketConnBuffer),
+		deadlineChanged: make(chan bool),
+		closed:          make(chan bool),
+	}
+	conn.mutex.Lock()
+	conn.adapters = append(conn.adapters, pc)
+	conn.mutex.Unlock()
+	return pc
+}
+
+// Hand copies of the packet to the adapters which want it; handlers is the
+// number of registered handlers.
+func (conn *Conn) deliverAdapters(adapters []*PacketConn, p *Packet, handlers int) {
+	for _, pc := range adapters {
+		if pc.delivery == DeliverUnhandled && handlers > 0 {
+			continue
+		}
+		select {
+		case pc.in <- &Packet{Addr: p.Addr, Msg: copyMessage(p.Msg), Received: p.Received}:
+		default:
+			conn.emit(&DropEvent{p.Addr, ErrQueueFull})
+		}
+	}
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		pc.mutex.Lock()
+		deadline, changed := pc.readDeadline, pc.deadlineChanged
+		pc.mutex.Unlock()
+
+		var expired <-chan time.Time
+		stop := func() {}
+		if !deadline.IsZero() {
+			wait := deadline.Sub(pc.conn.clock.Now())
+			if wait <= 0 {
+				return 0, nil, pc.opError("read", os.ErrDeadlineExceeded)
+			}
+			expired, stop = pc.conn.after("packet conn read deadline", wait)
+		}
+
+		select {
+		case p := <-pc.in:
+			stop()
+			return copy(b, p.Msg), p.Addr, nil
+		case <-expired:
+			stop()
+			return 0, nil, pc.opError("read", os.ErrDeadlineExceeded)
+		case <-changed:
+			stop()
+		case <-pc.closed:
+			stop()
+			return 0, nil, pc.opError("read", net.ErrClosed)
+		}
+	}
+}
+
+// Queue a copy of b for addr, which must be a *net.UDPAddr. A write
+// deadline is carried as PacketMeta.Deadline, so a packet still waiting in
+// the scheduler when it passes is dropped.
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, pc.opError("write", net.ErrClosed)
+	default:
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, pc.opError("write", &AddrError{Addr: addr.String(), Err: ErrMalformedAddr})
+	}
+
+	pc.mutex.Lock()
+	deadline := pc.writeDeadline
+	pc.mutex.Unlock()
+	if !deadline.IsZero() && !pc.conn.clock.Now().Before(deadline) {
+		return 0, pc.opError("write", os.ErrDeadlineExceeded)
+	}
+	if err := pc.conn.SendScheduled(copyMessage(b), udpAddr, PacketMeta{Deadline: deadline}); err != nil {
+		return 0, pc.opError("write", err)
+	}
+	return len(b), nil
+}
+
+// Detach the adapter from the connection, which stays open, and release
+// blocked readers.
+func (pc *PacketConn) Close() error {
+	pc.once.Do(func() {
+		close(pc.closed)
+		conn := pc.conn
+		conn.mutex.Lock()
+		defer conn.mutex.Unlock()
+		adapters := make([]*PacketConn, 0, len(conn.adapters))
+		for _, other := range conn.adapters {
+			if other != pc {
+				adapters = append(adapters, other)
+			}
+		}
+		conn.adapters = adapters
+	})
+	return nil
+}
+
+// Local end-point of the socket, nil unless it is open.
+func (pc *PacketConn) LocalAddr() net.Addr {
+	if addr := pc.conn.LocalAddr(); addr != nil {
+		return addr
+	}
+	return nil
+}
+
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	pc.SetReadDeadline(t)
+	return pc.SetWriteDeadline(t)
+}
+
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.readDeadline = t
+	close(pc.deadlineChanged)
+	pc.deadlineChanged = make(chan bool)
+	return nil
+}
+
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.writeDeadline = t
+	return nil
+}
+
+func (pc *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: pc.LocalAddr(), Err: err}
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
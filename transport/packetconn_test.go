@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Generic echo server written only against net.PacketConn
+func echo(pc net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if _, err := pc.WriteTo(buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
+
+// Generic client written only against net.PacketConn
+func roundTrip(pc net.PacketConn, addr net.Addr, msg string) (string, error) {
+	if _, err := pc.WriteTo([]byte(msg), addr); err != nil {
+		return "", err
+	}
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := pc.ReadFrom(buf)
+	return string(buf[:n]), err
+}
+
+func startAdapter(t *testing.T, delivery Delivery) (*Conn, *PacketConn, *net.UDPAddr) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	pc := conn.PacketConn(delivery)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	return conn, pc, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+func TestPacketConnEcho(t *testing.T) {
+	server, spc, addr := startAdapter(t, DeliverUnhandled)
+	defer server.Disconnect()
+	go echo(spc)
+	defer spc.Close()
+
+	client, cpc, _ := startAdapter(t, DeliverUnhandled)
+	defer client.Disconnect()
+	for _, msg := range []string{expectedRequest, "second"} {
+		if reply, err := roundTrip(cpc, addr, msg); err != nil || reply != msg {
+			t.Fatalf("TestPacketConnEcho expected %q got %q: %v", msg, reply, err)
+		}
+	}
+
+	// a handler consumes the packets, so the echo no longer sees them
+	handled := make(chan string, 1)
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		handled <- string(p.Msg)
+	})
+	if _, err := roundTrip(cpc, addr, "handled"); !isTimeout(err) {
+		t.Fatalf("TestPacketConnEcho expected a timeout got %v.", err)
+	}
+	if msg := <-handled; msg != "handled" {
+		t.Fatalf("TestPacketConnEcho expected the handler to see %q got %q.", "handled", msg)
+	}
+}
+
+func TestPacketConnDeliverAll(t *testing.T) {
+	server, spc, addr := startAdapter(t, DeliverAll)
+	defer server.Disconnect()
+	handled := make(chan string, 1)
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		// the adapter has its own copy
+		handled <- string(p.Msg)
+		p.Msg[0] = 'X'
+	})
+	go echo(spc)
+	defer spc.Close()
+
+	client, cpc, _ := startAdapter(t, DeliverUnhandled)
+	defer client.Disconnect()
+	if reply, err := roundTrip(cpc, addr, expectedRequest); err != nil || reply != expectedRequest {
+		t.Fatalf("TestPacketConnDeliverAll expected %q got %q: %v", expectedRequest, reply, err)
+	}
+	if msg := <-handled; msg != expectedRequest {
+		t.Fatalf("TestPacketConnDeliverAll expected the handler to see %q got %q.", expectedRequest, msg)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestPacketConnDeadlineAndClose(t *testing.T) {
+	conn, pc, addr := startAdapter(t, DeliverUnhandled)
+	defer conn.Disconnect()
+	buf := make([]byte, MessageSize)
+
+	pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	if _, _, err := pc.ReadFrom(buf); !isTimeout(err) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected a timeout got %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected to wait for the deadline, returned after %s.", elapsed)
+	}
+	pc.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := pc.WriteTo(buf[:1], addr); !isTimeout(err) {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected a write timeout got %v.", err)
+	}
+
+	// lifting the deadline lets a blocked reader wait until Close
+	pc.SetDeadline(time.Time{})
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(buf)
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pc.Close()
+	if err := <-result; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected net.ErrClosed got %v.", err)
+	}
+	if _, err := pc.WriteTo(buf[:1], addr); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected net.ErrClosed on write got %v.", err)
+	}
+	if !conn.IsConnected() {
+		t.Fatalf("TestPacketConnDeadlineAndClose expected the connection to stay open.")
+	}
+}
@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrMessageTooLarge = errors.New("Message exceeds the maximum payload")
+
+// Message passed to a send method is longer than MaxPayload allowed.
+type SizeError struct {
+	Size, Limit int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the maximum payload of %d bytes", e.Size, e.Limit)
+}
+
+func (e *SizeError) Unwrap() error {
+	return ErrMessageTooLarge
+}
+
+// Egress middleware which adds a bounded number of bytes to every packet,
+// such as a header, an authentication tag or fragmentation metadata.
+type Layer interface {
+	// Upper bound of the bytes Egress adds to a packet; it may change
+	// when the layer is reconfigured.
+	Overhead() int
+
+	// Transform an outgoing packet like a Middleware.
+	Egress(p *Packet) (*Packet, error)
+}
+
+// Register a layer as egress middleware (see UseEgress) whose overhead
+// reduces MaxPayload.
+func (conn *Conn) UseLayer(l Layer) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.egress = appendMiddleware(conn.egress, l.Egress)
+	conn.layers = append(conn.layers[:len(conn.layers):len(conn.layers)], l)
+}
+
+// Limit the datagrams written to the socket, e.g. to fit into the path MTU
+// without IP fragmentation, and the datagrams accepted from it. The size
+// is capped at MaxDatagramSize and defaults to MessageSize. Datagrams to a
+// peer never exceed the size it advertised (see AdvertiseDatagramSize), or
+// MessageSize if it advertised none, since larger ones would be truncated
+// by the receiver. The receive buffer is sized when the socket is opened.
+func (conn *Conn) SetDatagramSize(n int) {
+	if n > MaxDatagramSize {
+		n = MaxDatagramSize
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.datagramSize = n
+}
+
+// Bytes added to every message by the registered layers
+func (conn *Conn) Overhead() int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.overhead()
+}
+
+// Largest message the send methods accept for a peer which has not
+// advertised its datagram size: the datagram size, at most MessageSize,
+// less the current overhead of every layer. Longer messages are rejected
+// with a *SizeError wrapping ErrMessageTooLarge. See MaxPayloadTo for the
+// limit towards a given peer.
+func (conn *Conn) MaxPayload() int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.maxPayload()
+}
+
+func (conn *Conn) overhead() int {
+	n := 0
+	for _, l := range conn.layers {
+		n += l.Overhead()
+	}
+	if conn.tunnel != nil {
+		n += conn.tunnel.Overhead
+	}
+	return n
+}
+
+func (conn *Conn) maxPayload() int {
+	return conn.maxPayloadTo(0)
+}
+
+// Payload limit towards a peer which advertised the datagram size, zero
+// if unknown.
+func (conn *Conn) maxPayloadTo(peerSize int) int {
+	if n := conn.peerSize(peerSize) - conn.overhead(); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Datagram size towards a peer which advertised peerSize, zero if
+// unknown. Assumes the caller holds the mutex.
+func (conn *Conn) peerSize(peerSize int) int {
+	if peerSize <= 0 || conn.pathFallback && peerSize > DefaultPeerDatagramSize {
+		// without control over fragmentation only the default is safe
+		peerSize = DefaultPeerDatagramSize
+	}
+	return min(conn.datagramSize, peerSize)
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Layer which prepends a fixed header
+type headerLayer int
+
+func (l headerLayer) Overhead() int {
+	return int(l)
+}
+
+func (l headerLayer) Egress(p *Packet) (*Packet, error) {
+	msg := make(Message, int(l)+len(p.Msg))
+	copy(msg[l:], p.Msg)
+	return &Packet{Addr: p.Addr, Msg: msg}, nil
+}
+
+// Layer whose overhead depends on its configuration
+type tagLayer struct {
+	headerLayer
+	enabled *bool
+}
+
+func (l tagLayer) Overhead() int {
+	if *l.enabled {
+		return l.headerLayer.Overhead()
+	}
+	return 0
+}
+
+func TestMaxPayload(t *testing.T) {
+	enabled := false
+	tests := []struct {
+		name     string
+		datagram int
+		layers   []Layer
+		before   func()
+		expected int
+	}{
+		{"none", 0, nil, nil, MessageSize},
+		{"envelope", 0, []Layer{headerLayer(12)}, nil, MessageSize - 12},
+		{"stacked", 0, []Layer{headerLayer(12), headerLayer(28), headerLayer(4)}, nil, MessageSize - 44},
+		{"mtu", 400, []Layer{headerLayer(12), headerLayer(28)}, nil, 360},
+		{"capped", 9000, []Layer{headerLayer(12)}, nil, MessageSize - 12},
+		{"exhausted", 32, []Layer{headerLayer(20), headerLayer(20)}, nil, 0},
+		{"disabled", 0, []Layer{headerLayer(12), tagLayer{headerLayer(16), &enabled}}, nil, MessageSize - 12},
+		{"enabled", 0, []Layer{headerLayer(12), tagLayer{headerLayer(16), &enabled}}, func() { enabled = true }, MessageSize - 28},
+	}
+
+	for _, test := range tests {
+		conn := NewConn()
+		if test.datagram > 0 {
+			conn.SetDatagramSize(test.datagram)
+		}
+		for _, l := range test.layers {
+			conn.UseLayer(l)
+		}
+		if test.before != nil {
+			test.before()
+		}
+		if n := conn.MaxPayload(); n != test.expected {
+			t.Errorf("TestMaxPayload %s expected %d got %d.", test.name, test.expected, n)
+		}
+	}
+}
+
+func TestMaxPayloadSend(t *testing.T) {
+	packets := make(chan *Packet, 1)
+	server := startPeer(t, 0)
+	defer server.Disconnect()
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		packets <- p
+	})
+
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.UseLayer(headerLayer(12))
+	conn.UseLayer(headerLayer(20))
+	if err := conn.Dial(loopbackOf(server), time.Time{}); err != nil {
+		t.Fatalf("TestMaxPayloadSend cannot dial: %s", err)
+	}
+	defer conn.Disconnect()
+
+	limit := conn.MaxPayload()
+	err := conn.Send(make(Message, limit+1))
+	var sizeErr *SizeError
+	if !errors.Is(err, ErrMessageTooLarge) || !errors.As(err, &sizeErr) || sizeErr.Limit != limit {
+		t.Fatalf("TestMaxPayloadSend expected limit %d in error got %v.", limit, err)
+	}
+
+	if err := conn.Send(make(Message, limit)); err != nil {
+		t.Fatalf("TestMaxPayloadSend cannot send %d bytes: %s", limit, err)
+	}
+	select {
+	case p := <-packets:
+		if len(p.Msg) != MessageSize {
+			t.Fatalf("TestMaxPayloadSend expected a full datagram got %d bytes.", len(p.Msg))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestMaxPayloadSend no packet received")
+	}
+	if n := server.Stats().Truncated; n != 0 {
+		t.Fatalf("TestMaxPayloadSend expected no truncation got %d.", n)
+	}
+}
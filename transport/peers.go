@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the per-peer statistics table
+const (
+	DefaultMaxPeers = 1024
+	DefaultPeerIdle = 5 * time.Minute
+	peerTableShards = 16
+)
+
+// Traffic counters for a single remote end-point.
+type PeerStats struct {
+	Addr *net.UDPAddr
+
+	PacketsIn, BytesIn   uint64
+	PacketsOut, BytesOut uint64
+
+	// Packets to this peer which could not be written
+	Errors uint64
+
+	// Time of the last packet received from this peer, zero if none
+	LastSeen time.Time
+
+	// Time of the last packet in either direction
+	LastActive time.Time
+
+	// Largest datagram the peer advertised to accept, zero if unknown;
+	// see AdvertiseDatagramSize
+	DatagramSize int
+
+	// Largest datagram which reached the peer unfragmented and the time
+	// it was discovered, zero if never probed; see DiscoverPathMTU
+	PathDatagramSize int
+	PathProbed       time.Time
+
+	// Optional encodings the peer advertised and the incarnation of its
+	// advertisement, zero if unknown; see AdvertiseCapabilities
+	Capabilities          Capability
+	CapabilityIncarnation uint64
+
+	// Time the capabilities were last probed, whether the peer answered
+	// or not, zero if never; see ProbeCapabilities
+	CapabilitiesProbed time.Time
+}
+
+// Bounded table of PeerStats, sharded by address so that the receiving and
+// sending loops rarely contend for the same lock.
+type peerTable struct {
+	shards [peerTableShards]peerShard
+
+	// Entries per shard and the idle period after which entries are evicted
+	maxPerShard int
+	idle        time.Duration
+
+	// Hard cap on the entries, zero if none, and the entries right now;
+	// see Limits.Peers
+	hardMax int
+	size    atomic.Int64
+}
+
+type peerShard struct {
+	mutex sync.Mutex
+	peers map[netip.AddrPort]*PeerStats
+}
+
+func newPeerTable(max int, idle time.Duration) *peerTable {
+	t := new(peerTable)
+	t.setLimits(max, idle)
+	for i := range t.shards {
+		t.shards[i].peers = make(map[netip.AddrPort]*PeerStats)
+	}
+	return t
+}
+
+func (t *peerTable) setLimits(max int, idle time.Duration) {
+	t.maxPerShard = (max + peerTableShards - 1) / peerTableShards
+	if t.maxPerShard < 1 {
+		t.maxPerShard = 1
+	}
+	t.idle = idle
+}
+
+// Compact, comparable form of the address; IPv4-mapped addresses are
+// unmapped so that both spellings of the same peer share an entry.
+func peerKey(addr *net.UDPAddr) netip.AddrPort {
+	key := addr.AddrPort()
+	return netip.AddrPortFrom(key.Addr().Unmap(), key.Port())
+}
+
+// Spreads the keys of peers evenly across shards.
+func peerHash(key netip.AddrPort) uint {
+	b := key.Addr().As16()
+	h := uint(key.Port())
+	for _, x := range b {
+		h = h*31 + uint(x)
+	}
+	return h
+}
+
+func (t *peerTable) shard(key netip.AddrPort) *peerShard {
+	return &t.shards[peerHash(key)%peerTableShards]
+}
+
+// Apply f to the entry of addr, creating it if necessary.
+func (t *peerTable) update(addr *net.UDPAddr, now time.Time, f func(*PeerStats)) {
+	if addr == nil {
+		return
+	}
+
+	key := peerKey(addr)
+	s := t.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	peer, ok := s.peers[key]
+	if !ok {
+		switch {
+		case t.hardMax > 0 && int(t.size.Load()) >= t.hardMax:
+			// the cap bounds the table instead of the shard size
+			t.size.Add(-int64(s.evict(now, t.idle, false)))
+			if int(t.size.Load()) >= t.hardMax {
+				return
+			}
+		case t.hardMax == 0 && len(s.peers) >= t.maxPerShard:
+			t.size.Add(-int64(s.evict(now, t.idle, true)))
+		}
+		t.size.Add(1)
+		peer = &PeerStats{Addr: addr}
+		s.peers[key] = peer
+	}
+	peer.LastActive = now
+	f(peer)
+}
+
+func (t *peerTable) received(addr *net.UDPAddr, n int, now time.Time) {
+	t.update(addr, now, func(peer *PeerStats) {
+		peer.PacketsIn++
+		peer.BytesIn += uint64(n)
+		peer.LastSeen = now
+	})
+}
+
+func (t *peerTable) sent(addr *net.UDPAddr, n int, now time.Time) {
+	t.update(addr, now, func(peer *PeerStats) {
+		peer.PacketsOut++
+		peer.BytesOut += uint64(n)
+	})
+}
+
+func (t *peerTable) failed(addr *net.UDPAddr, now time.Time) {
+	t.update(addr, now, func(peer *PeerStats) {
+		peer.Errors++
+	})
+}
+
+// Whether packets may be exchanged with addr under the hard cap: it is
+// tracked already, or there is room for it once the idle entries have
+// been evicted.
+func (t *peerTable) admit(addr *net.UDPAddr, now time.Time) bool {
+	if t.hardMax == 0 || addr == nil || int(t.size.Load()) < t.hardMax {
+		return true
+	}
+	if _, ok := t.lookup(addr); ok {
+		return true
+	}
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mutex.Lock()
+		t.size.Add(-int64(s.evict(now, t.idle, false)))
+		s.mutex.Unlock()
+	}
+	return int(t.size.Load()) < t.hardMax
+}
+
+// Copy of the entry of addr, if any.
+func (t *peerTable) lookup(addr *net.UDPAddr) (PeerStats, bool) {
+	if addr == nil {
+		return PeerStats{}, false
+	}
+	key := peerKey(addr)
+	s := t.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if peer, ok := s.peers[key]; ok {
+		return *peer, true
+	}
+	return PeerStats{}, false
+}
+
+// Advertised datagram size of the peer, capped by the discovered path
+// size, zero if unknown.
+func (t *peerTable) datagramSize(addr *net.UDPAddr) int {
+	peer, _ := t.lookup(addr)
+	if peer.PathDatagramSize > 0 && peer.PathDatagramSize < peer.DatagramSize {
+		return peer.PathDatagramSize
+	}
+	return peer.DatagramSize
+}
+
+// Remove entries which have been idle for longer than the idle period.
+// If force is set and nothing was idle, the least recently active entry
+// is removed instead. Returns the number of entries removed. Assumes the
+// caller holds the shard's mutex.
+func (s *peerShard) evict(now time.Time, idle time.Duration, force bool) int {
+	var oldest netip.AddrPort
+	var oldestPeer *PeerStats
+	evicted := 0
+	for key, peer := range s.peers {
+		if idle > 0 && now.Sub(peer.LastActive) > idle {
+			delete(s.peers, key)
+			evicted++
+		} else if oldestPeer == nil || peer.LastActive.Before(oldestPeer.LastActive) {
+			oldest, oldestPeer = key, peer
+		}
+	}
+	if force && evicted == 0 && oldestPeer != nil {
+		delete(s.peers, oldest)
+		evicted++
+	}
+	return evicted
+}
+
+// Copy every entry which has not been idle for too long.
+func (t *peerTable) snapshot(now time.Time) []PeerStats {
+	peers := make([]PeerStats, 0, 16)
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mutex.Lock()
+		t.size.Add(-int64(s.evict(now, t.idle, false)))
+		for _, peer := range s.peers {
+			peers = append(peers, *peer)
+		}
+		s.mutex.Unlock()
+	}
+	return peers
+}
+
+// Returns the traffic counters of every peer which exchanged packets with
+// this connection within the idle period, in no particular order.
+func (conn *Conn) Peers() []PeerStats {
+	return conn.peers.snapshot(conn.clock.Now())
+}
+
+// Bound the per-peer statistics table to roughly max entries and evict
+// entries which have been idle for longer than idle; zero disables the
+// idle eviction. Must be called before the socket is opened.
+func (conn *Conn) SetPeerTableLimits(max int, idle time.Duration) {
+	conn.peers.setLimits(max, idle)
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeers(t *testing.T) {
+	clock := NewManualClock(epoch)
+	received := make(chan bool, 16)
+
+	server := NewConn()
+	server.SetClock(clock)
+	go monitor(server.Err, t)
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		received <- true
+	})
+	if err := server.Listen(0); err != nil {
+		t.Fatalf("TestPeers cannot listen: %s", err)
+	}
+	defer server.Disconnect()
+
+	counts := []int{3, 5}
+	clients := make([]*Conn, len(counts))
+	for i, n := range counts {
+		clients[i] = startClient(t, portOf(server))
+		defer clients[i].Disconnect()
+		for j := 1; j < n; j++ {
+			clients[i].Send([]byte(expectedRequest))
+		}
+	}
+	for i := 0; i < counts[0]+counts[1]; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("TestPeers only received %d packets", i)
+		}
+	}
+
+	peers := server.Peers()
+	if len(peers) != len(clients) {
+		t.Fatalf("TestPeers expected %d peers got %d.", len(clients), len(peers))
+	}
+	for i, client := range clients {
+		addr := client.sock.LocalAddr().(*net.UDPAddr)
+		var peer *PeerStats
+		for j := range peers {
+			if peers[j].Addr.Port == addr.Port {
+				peer = &peers[j]
+			}
+		}
+		if peer == nil {
+			t.Fatalf("TestPeers no entry for client %s", addr)
+		}
+
+		bytes := uint64(counts[i] * len(expectedRequest))
+		if peer.PacketsIn != uint64(counts[i]) || peer.BytesIn != bytes {
+			t.Fatalf("TestPeers expected %d packets and %d bytes from %s got %+v.", counts[i], bytes, addr, *peer)
+		}
+		if !peer.LastSeen.Equal(epoch) {
+			t.Fatalf("TestPeers expected last seen %s got %s.", epoch, peer.LastSeen)
+		}
+	}
+
+	clock.Advance(DefaultPeerIdle + time.Second)
+	if peers = server.Peers(); len(peers) != 0 {
+		t.Fatalf("TestPeers expected idle peers to be evicted got %d.", len(peers))
+	}
+}
+
+func TestPeerTableBounded(t *testing.T) {
+	table := newPeerTable(32, 0)
+	for i := 0; i < 1000; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 7946}
+		table.received(addr, 1, epoch.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	if n := len(table.snapshot(epoch)); n > 32 {
+		t.Fatalf("TestPeerTableBounded expected at most %d entries got %d.", 32, n)
+	}
+}
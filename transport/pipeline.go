@@ -0,0 +1,201 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrStageExists  = errors.New("Ingress stage is already registered")
+	ErrUnknownStage = errors.New("No ingress stage of that name")
+	ErrStageOrder   = errors.New("Ingress stage would run before a stage it depends on")
+)
+
+// Kind of an ingress stage. Along the pipeline the kinds of the stages
+// never decrease, so e.g. a replay guard keyed by an id inside the
+// ciphertext cannot run before the decryption.
+type StageKind int
+
+const (
+	// Runs where it was registered and is not checked
+	StageAny StageKind = iota
+	// Sees the datagram as it was read, e.g. loop and rate guards
+	StageAdmit
+	// Decrypts or decodes the payload
+	StageDecode
+	// Reads the decoded payload, e.g. dedup and replay guards
+	StageInspect
+)
+
+func (k StageKind) String() string {
+	switch k {
+	case StageAny:
+		return "any"
+	case StageAdmit:
+		return "admit"
+	case StageDecode:
+		return "decode"
+	case StageInspect:
+		return "inspect"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Where an ingress stage runs. Before and After name registered stages.
+// Without either a stage of kind StageAny runs after all registered
+// before it, and any other kind after them too unless a stage of a later
+// kind is registered, in which case it runs before the first of those.
+type StageOptions struct {
+	Kind          StageKind
+	Before, After string
+}
+
+// Rejected registration of an ingress stage; Conflict is the stage which
+// the placement refers to or would run out of order with.
+type StageError struct {
+	Name     string
+	Conflict string
+	Err      error
+}
+
+func (e *StageError) Error() string {
+	if e.Conflict == "" {
+		return fmt.Sprintf("ingress stage %s: %s", e.Name, e.Err)
+	}
+	return fmt.Sprintf("ingress stage %s: %s (%s)", e.Name, e.Err, e.Conflict)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+type stage struct {
+	name string
+	kind StageKind
+	m    Middleware
+}
+
+// Register a middleware as a named ingress stage; see StageOptions for
+// where it runs. Returns a *StageError if the name is taken, a stage it
+// is placed against does not exist or the placement breaks the order of
+// the kinds. The built-in stages are "broadcast" (SetBroadcast),
+// "encoding-<capability>" (UseEncoding) and "shaper" (UseShaper); those
+// registered by Use are named "middleware-<n>".
+func (conn *Conn) UseStage(name string, m Middleware, opts StageOptions) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for _, s := range conn.stages {
+		if s.name == name {
+			return &StageError{Name: name, Err: ErrStageExists}
+		}
+	}
+
+	i := -1
+	if opts.After != "" {
+		if i = conn.stageIndex(opts.After); i < 0 {
+			return &StageError{name, opts.After, ErrUnknownStage}
+		}
+		i++
+	}
+	if opts.Before != "" {
+		j := conn.stageIndex(opts.Before)
+		switch {
+		case j < 0:
+			return &StageError{name, opts.Before, ErrUnknownStage}
+		case i > j:
+			return &StageError{name, opts.Before, ErrStageOrder}
+		case i < 0:
+			i = j
+		}
+	}
+	if i < 0 {
+		i = conn.stagePosition(opts.Kind)
+	}
+
+	stages := insertStage(conn.stages, i, stage{name, opts.Kind, m})
+	if conflict := checkStages(stages, i); conflict != "" {
+		return &StageError{name, conflict, ErrStageOrder}
+	}
+	conn.setStages(stages)
+	return nil
+}
+
+// Names of the ingress stages in the order packets pass them, including
+// the fixed ones around the middleware: the raw mirrors and handlers
+// before and the built-in hints, the mirrors and the handlers after.
+func (conn *Conn) PipelineDescription() []string {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	names := []string{"raw-mirrors", "raw-handlers"}
+	for _, s := range conn.stages {
+		names = append(names, s.name)
+	}
+	return append(names, "hints", "mirrors", "handlers")
+}
+
+// Register a built-in stage where its kind belongs, numbering the name if
+// it is taken. The caller holds the lock.
+func (conn *Conn) addStage(name string, kind StageKind, m Middleware) {
+	unique := name
+	for n := 2; conn.stageIndex(unique) >= 0; n++ {
+		unique = name + "-" + strconv.Itoa(n)
+	}
+	conn.setStages(insertStage(conn.stages, conn.stagePosition(kind), stage{unique, kind, m}))
+}
+
+func (conn *Conn) stageIndex(name string) int {
+	for i, s := range conn.stages {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Position of a new stage of the given kind when none was asked for
+func (conn *Conn) stagePosition(kind StageKind) int {
+	if kind != StageAny {
+		for i, s := range conn.stages {
+			if s.kind != StageAny && s.kind > kind {
+				return i
+			}
+		}
+	}
+	return len(conn.stages)
+}
+
+// Replace the stages and the chain run by the dispatcher, copying on write
+// like appendMiddleware.
+func (conn *Conn) setStages(stages []stage) {
+	chain := make([]Middleware, len(stages))
+	for i, s := range stages {
+		chain[i] = s.m
+	}
+	conn.stages, conn.ingress = stages, chain
+}
+
+func insertStage(stages []stage, i int, s stage) []stage {
+	c := make([]stage, 0, len(stages)+1)
+	c = append(c, stages[:i]...)
+	c = append(c, s)
+	return append(c, stages[i:]...)
+}
+
+// Name of a stage which runs out of the order of the kinds with the one
+// at i, or the empty string.
+func checkStages(stages []stage, i int) string {
+	kind := stages[i].kind
+	if kind == StageAny {
+		return ""
+	}
+	for j, s := range stages {
+		if s.kind == StageAny {
+			continue
+		}
+		if j < i && s.kind > kind || j > i && s.kind < kind {
+			return s.name
+		}
+	}
+	return ""
+}
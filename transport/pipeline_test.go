@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func pass(p *Packet) (*Packet, error) {
+	return p, nil
+}
+
+func TestPipelineDescription(t *testing.T) {
+	conn := NewConn()
+	conn.Use(pass)
+	if err := conn.UseStage("dedup", pass, StageOptions{Kind: StageInspect}); err != nil {
+		t.Fatal(err)
+	}
+	// registered later, the decoding and the broadcast guard still run first
+	conn.UseEncoding(1, flateEncoding{})
+	conn.SetBroadcast(true)
+	conn.UseShaper(NewShaper(Shaping{}))
+	if err := conn.UseStage("audit", pass, StageOptions{After: "encoding-0x1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"raw-mirrors", "raw-handlers", "broadcast", "middleware-1", "encoding-0x1", "audit", "dedup", "shaper", "hints", "mirrors", "handlers"}
+	if stages := conn.PipelineDescription(); !reflect.DeepEqual(stages, expected) {
+		t.Fatalf("TestPipelineDescription expected %v got %v.", expected, stages)
+	}
+	if stages := NewConn().PipelineDescription(); len(stages) != 5 {
+		t.Fatalf("TestPipelineDescription expected only the fixed stages got %v.", stages)
+	}
+}
+
+func TestPipelineRejects(t *testing.T) {
+	conn := NewConn()
+	conn.UseEncoding(1, flateEncoding{})
+	conn.Use(pass)
+
+	for _, c := range []struct {
+		name     string
+		opts     StageOptions
+		err      error
+		conflict string
+	}{
+		{"dedup", StageOptions{Kind: StageInspect, Before: "encoding-0x1"}, ErrStageOrder, "encoding-0x1"},
+		{"guard", StageOptions{Kind: StageAdmit, After: "middleware-1"}, ErrStageOrder, "encoding-0x1"},
+		{"dedup", StageOptions{Before: "decrypt"}, ErrUnknownStage, "decrypt"},
+		{"dedup", StageOptions{Before: "encoding-0x1", After: "middleware-1"}, ErrStageOrder, "encoding-0x1"},
+		{"middleware-1", StageOptions{}, ErrStageExists, ""},
+	} {
+		err := conn.UseStage(c.name, pass, c.opts)
+		var stageErr *StageError
+		if !errors.As(err, &stageErr) || stageErr.Err != c.err || stageErr.Conflict != c.conflict {
+			t.Fatalf("TestPipelineRejects expected %v with %q for %s %+v got %v.", c.err, c.conflict, c.name, c.opts, err)
+		}
+	}
+	if stages := conn.PipelineDescription(); len(stages) != 7 {
+		t.Fatalf("TestPipelineRejects expected the rejected stages to be left out got %v.", stages)
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	server := NewConn()
+	go monitor(server.Err, t)
+	seen := make(chan string, 2)
+	server.UseStage("dedup", func(p *Packet) (*Packet, error) {
+		seen <- string(p.Msg)
+		return p, nil
+	}, StageOptions{Kind: StageInspect})
+	server.UseEncoding(1, flateEncoding{})
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Disconnect()
+
+	client := NewConn()
+	go monitor(client.Err, t)
+	client.UseLayer(flateEncoding{})
+	if err := client.Dial(server.LocalAddr().String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	client.Send([]byte(expectedRequest))
+
+	select {
+	case msg := <-seen:
+		if msg != expectedRequest {
+			t.Fatalf("TestPipelineOrder expected the stage to see %q got %q.", expectedRequest, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestPipelineOrder no packet received")
+	}
+}
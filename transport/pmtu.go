@@ -0,0 +1,295 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+const (
+	// Time a path probe waits for its reply before it is repeated or
+	// considered lost
+	DefaultPathProbeTimeout = 500 * time.Millisecond
+
+	// Period after which discovered path sizes are probed again
+	DefaultPathReprobe = 10 * time.Minute
+
+	// Lost probes of one size before the size is taken to exceed the path
+	pathProbeAttempts = 2
+
+	// IPv4 and UDP headers, which the MTU of an ICMP error includes
+	udpHeaderSize = 28
+)
+
+var ErrPathMTUDisabled = errors.New("Path MTU discovery is not enabled")
+
+// Discover the largest datagram which reaches each peer without IP
+// fragmentation (see DiscoverPathMTU) and never let the kernel fragment
+// ours. Only Linux can set the DF bit; elsewhere every peer is limited to
+// DefaultPeerDatagramSize instead. ICMP fragmentation needed errors end a
+// probe early if SetICMPErrors is enabled as well, otherwise oversized
+// probes time out. Must be called before the socket is opened.
+func (conn *Conn) SetPathMTUDiscovery(enabled bool) {
+	conn.pathMTU = enabled
+}
+
+// Set the time a path probe waits for its reply and the period after
+// which discovered path sizes are probed again; zero selects
+// DefaultPathProbeTimeout and DefaultPathReprobe respectively. Must be
+// called before the socket is opened.
+func (conn *Conn) SetPathProbing(timeout, reprobe time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultPathProbeTimeout
+	}
+	if reprobe <= 0 {
+		reprobe = DefaultPathReprobe
+	}
+	conn.pathTimeout, conn.pathReprobe = timeout, reprobe
+}
+
+// Binary search between DefaultPeerDatagramSize, which is assumed to fit
+// every path, and PeerDatagramSize for the largest datagram the peer
+// acknowledges, and cache the result in its PeerStats, from where it
+// caps the datagrams sent to it. Each size is probed up to twice. Blocks
+// until the search has finished, which takes many probe timeouts if the
+// path drops large datagrams silently. Returns DefaultPeerDatagramSize
+// and ErrNotSupported if the platform cannot forbid fragmentation.
+// Discovered sizes are probed again periodically (see SetPathProbing).
+func (conn *Conn) DiscoverPathMTU(addr *net.UDPAddr) (int, error) {
+	conn.mutex.Lock()
+	enabled, fallback, size := conn.pathMTU, conn.pathFallback, conn.datagramSize
+	conn.mutex.Unlock()
+	switch {
+	case !enabled:
+		return 0, ErrPathMTUDisabled
+	case fallback:
+		return DefaultPeerDatagramSize, ErrNotSupported
+	}
+	if err := checkAddr(addr); err != nil {
+		return 0, err
+	}
+
+	peer, _ := conn.peers.lookup(addr)
+	hi := DefaultPeerDatagramSize
+	if peer.DatagramSize > 0 {
+		hi = peer.DatagramSize
+	}
+	hi = min(hi, size)
+	lo := min(DefaultPeerDatagramSize, hi)
+	for lo < hi {
+		probe := lo + (hi-lo+1)/2
+		fits, limit, err := conn.probePath(addr, probe)
+		switch {
+		case err != nil:
+			return 0, err
+		case fits:
+			lo = probe
+		case limit > 0 && limit < probe:
+			// trust the router over the default
+			hi = limit
+			lo = min(lo, limit)
+		default:
+			hi = probe - 1
+		}
+	}
+
+	now := conn.clock.Now()
+	conn.peers.update(addr, now, func(peer *PeerStats) {
+		peer.PathDatagramSize = lo
+		peer.PathProbed = now
+	})
+	return lo, nil
+}
+
+// Send probes of the given datagram size until one is acknowledged in
+// full or the attempts are exhausted. The limit is the datagram size
+// reported by an ICMP error, zero if none arrived.
+func (conn *Conn) probePath(addr *net.UDPAddr, size int) (fits bool, limit int, err error) {
+	conn.mutex.Lock()
+	payload := size - conn.overhead()
+	v, timeout, done := conn.encodeVersion, conn.pathTimeout, conn.done
+	conn.mutex.Unlock()
+	if payload < wire.PathProbeSize {
+		// too small to carry a probe, and far below any real MTU
+		return true, 0, nil
+	}
+
+	for attempt := 0; attempt < pathProbeAttempts; attempt++ {
+		id, results := conn.paths.start(addr)
+		msg, err := wire.PathProbe{ID: id, Size: uint16(payload)}.Encode(wire.Version(v))
+		if err != nil {
+			conn.paths.finish(id)
+			return false, 0, err
+		}
+		o := &outgoing{Packet: &Packet{Addr: addr, Msg: msg}, probe: true, done: func(err error) {
+			if err != nil {
+				// e.g. EMSGSIZE for a probe larger than the interface
+				conn.paths.resolve(id, addr, pathResult{lost: true})
+			}
+		}}
+		if err := conn.enqueue(o); err != nil {
+			conn.paths.finish(id)
+			return false, 0, err
+		}
+
+		var r pathResult
+		expired, stop := conn.after("path probe", timeout)
+		select {
+		case r = <-results:
+		case <-expired:
+			r.lost = true
+		case <-done:
+			stop()
+			conn.paths.finish(id)
+			return false, 0, ErrClosedConn
+		}
+		stop()
+		conn.paths.finish(id)
+		switch {
+		case r.limit > 0:
+			return false, r.limit, nil
+		case !r.lost:
+			return r.received == payload, 0, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// Consume a path probe: record the reply to one of ours or acknowledge
+// the peer's with the number of bytes which arrived. Returns false for
+// packets of other kinds.
+func (conn *Conn) pathProbe(p *Packet) bool {
+	probe, _, err := wire.DecodePathProbe(p.Msg)
+	if err != nil {
+		return false
+	}
+	if probe.Reply {
+		conn.paths.resolve(probe.ID, p.Addr, pathResult{received: int(probe.Size)})
+		return true
+	}
+	conn.mutex.Lock()
+	v := conn.encodeVersion
+	conn.mutex.Unlock()
+	reply, err := wire.PathProbe{ID: probe.ID, Size: uint16(len(p.Msg)), Reply: true}.Encode(wire.Version(v))
+	if err == nil {
+		conn.SendTo(reply, p.Addr)
+	}
+	return true
+}
+
+// Interpret a fragmentation needed error: it ends the probe it quotes, or
+// caps the path size of the peer if a datagram of ours was too large.
+// Returns true if the error was caused by a probe.
+func (conn *Conn) pathTooBig(e *UnreachableEvent) bool {
+	if e.MTU <= udpHeaderSize {
+		return false
+	}
+	limit := e.MTU - udpHeaderSize
+	if probe, _, err := wire.DecodePathProbe(e.Msg); err == nil && !probe.Reply {
+		conn.paths.resolve(probe.ID, e.Addr, pathResult{limit: limit})
+		return true
+	}
+	if conn.pathMTU {
+		now := conn.clock.Now()
+		conn.peers.update(e.Addr, now, func(peer *PeerStats) {
+			if peer.PathDatagramSize == 0 || limit < peer.PathDatagramSize {
+				peer.PathDatagramSize = limit
+				peer.PathProbed = now
+			}
+		})
+	}
+	return false
+}
+
+// Probe the peers with a discovered path size again every period, since
+// routes change, until done is closed.
+func (conn *Conn) reprobing(period time.Duration, done chan bool) {
+	ticker := conn.clock.NewTicker(period)
+	defer ticker.Stop()
+	timer := conn.debug.timer("path reprobe", conn.clock.Now().Add(period))
+	defer timer.stop()
+	for {
+		select {
+		case <-ticker.C():
+			timer.reset(conn.clock.Now().Add(period))
+		case <-done:
+			return
+		}
+		for _, peer := range conn.Peers() {
+			if peer.PathProbed.IsZero() {
+				continue
+			}
+			if _, err := conn.DiscoverPathMTU(peer.Addr); errors.Is(err, ErrClosedConn) {
+				return
+			}
+		}
+	}
+}
+
+// Outcome of a single path probe
+type pathResult struct {
+	// Bytes the peer acknowledged
+	received int
+
+	// Datagram size reported by an ICMP error
+	limit int
+
+	// No reply, or the probe could not be written
+	lost bool
+}
+
+// Probes awaiting their outcome, by id
+type pathProber struct {
+	mutex   sync.Mutex
+	next    uint32
+	pending map[uint32]pendingProbe
+}
+
+// Probes waiting for their reply
+func (p *pathProber) len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.pending)
+}
+
+type pendingProbe struct {
+	addr    *net.UDPAddr
+	results chan pathResult
+}
+
+func newPathProber() *pathProber {
+	return &pathProber{pending: make(map[uint32]pendingProbe)}
+}
+
+func (pp *pathProber) start(addr *net.UDPAddr) (uint32, chan pathResult) {
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	pp.next++
+	results := make(chan pathResult, 1)
+	pp.pending[pp.next] = pendingProbe{addr, results}
+	return pp.next, results
+}
+
+func (pp *pathProber) finish(id uint32) {
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	delete(pp.pending, id)
+}
+
+// Hand the outcome to the probe unless it has one already, ignoring
+// outcomes of finished probes and those from other addresses.
+func (pp *pathProber) resolve(id uint32, addr *net.UDPAddr, r pathResult) {
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	probe, ok := pp.pending[id]
+	if !ok || peerKey(probe.addr) != peerKey(addr) {
+		return
+	}
+	select {
+	case probe.results <- r:
+	default:
+	}
+}
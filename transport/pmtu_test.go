@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Connection with the given datagram size which delivers received
+// messages to the returned channel
+func startSized(t *testing.T, size int) (*Conn, *net.UDPAddr, chan Message) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetDatagramSize(size)
+	received := make(chan Message, 4)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		received <- p.Msg
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	port := (<-conn.Events()).(*OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, received
+}
+
+func waitDatagramSize(t *testing.T, conn *Conn, addr *net.UDPAddr, expected int) {
+	for i := 0; conn.PeerDatagramSize(addr) != expected; i++ {
+		if i == 100 {
+			t.Fatalf("TestPathMTUDiscovery expected a datagram size of %d for %s got %d.", expected, addr, conn.PeerDatagramSize(addr))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Drop inbound datagrams which would not fit into a link with the given
+// MTU, like a router which must not fragment them.
+func limitLink(conn *Conn, mtu *atomic.Int64) {
+	conn.Use(func(p *Packet) (*Packet, error) {
+		if len(p.Msg)+udpHeaderSize > int(mtu.Load()) {
+			return nil, nil
+		}
+		return p, nil
+	})
+}
+
+func TestPathMTUDiscovery(t *testing.T) {
+	prober := NewConn()
+	go monitor(prober.Err, t)
+	prober.SetDatagramSize(9000)
+	prober.SetPathMTUDiscovery(true)
+	prober.SetPathProbing(20*time.Millisecond, 100*time.Millisecond)
+	if err := prober.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(prober.Disconnect)
+	<-prober.Events()
+
+	_, wideAddr, _ := startSized(t, 9000)
+	narrow, narrowAddr, _ := startSized(t, 9000)
+	var mtu atomic.Int64
+	mtu.Store(1400)
+	limitLink(narrow, &mtu)
+
+	for _, addr := range []*net.UDPAddr{wideAddr, narrowAddr} {
+		if err := prober.AdvertiseDatagramSize(addr); err != nil {
+			t.Fatal(err)
+		}
+		waitDatagramSize(t, prober, addr, 9000)
+	}
+
+	tests := []struct {
+		addr *net.UDPAddr
+		size int
+	}{
+		{wideAddr, 9000},
+		{narrowAddr, 1400 - udpHeaderSize},
+	}
+	for _, test := range tests {
+		size, err := prober.DiscoverPathMTU(test.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != test.size || prober.PeerDatagramSize(test.addr) != test.size || prober.MaxPayloadTo(test.addr) != test.size {
+			t.Fatalf("TestPathMTUDiscovery expected a path size of %d to %s got %d.", test.size, test.addr, size)
+		}
+	}
+
+	// the route changes and the next probe round notices
+	mtu.Store(1280)
+	for i := 0; prober.PeerDatagramSize(narrowAddr) != 1280-udpHeaderSize; i++ {
+		if i == 100 {
+			t.Fatalf("TestPathMTUDiscovery expected a path size of %d after re-probing got %d.", 1280-udpHeaderSize, prober.PeerDatagramSize(narrowAddr))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := prober.PeerDatagramSize(wideAddr); n != 9000 {
+		t.Fatalf("TestPathMTUDiscovery expected the wide path to keep 9000 got %d.", n)
+	}
+}
+
+func TestPathMTUDisabled(t *testing.T) {
+	conn, _, _ := startSized(t, 9000)
+	if _, err := conn.DiscoverPathMTU(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}); err != ErrPathMTUDisabled {
+		t.Fatalf("TestPathMTUDisabled expected ErrPathMTUDisabled got %v.", err)
+	}
+}
+
+func TestPathTooBig(t *testing.T) {
+	conn := NewConn()
+	conn.SetPathMTUDiscovery(true)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7946}
+
+	// the ICMP error quotes the beginning of the probe
+	id, results := conn.paths.start(addr)
+	probe, _ := wire.PathProbe{ID: id, Size: 1400}.Encode(wire.Current)
+	if !conn.pathTooBig(&UnreachableEvent{Addr: addr, Msg: probe[:64], MTU: 1280}) {
+		t.Fatalf("TestPathTooBig expected the error to end the probe.")
+	}
+	if r := <-results; r.limit != 1280-udpHeaderSize {
+		t.Fatalf("TestPathTooBig expected a limit of %d got %+v.", 1280-udpHeaderSize, r)
+	}
+	conn.paths.finish(id)
+
+	// ordinary traffic caps the path right away
+	if conn.pathTooBig(&UnreachableEvent{Addr: addr, Msg: Message("payload"), MTU: 1000}) {
+		t.Fatalf("TestPathTooBig expected a lost message rather than a probe.")
+	}
+	if peer, _ := conn.peers.lookup(addr); peer.PathDatagramSize != 1000-udpHeaderSize {
+		t.Fatalf("TestPathTooBig expected a path size of %d got %d.", 1000-udpHeaderSize, peer.PathDatagramSize)
+	}
+	if conn.pathTooBig(&UnreachableEvent{Addr: addr, Msg: probe, MTU: 0}) {
+		t.Fatalf("TestPathTooBig expected other errors to be ignored.")
+	}
+}
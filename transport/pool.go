@@ -0,0 +1,226 @@
+package transport
+
+import (
+	"container/list"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Defaults of a DialPool
+const (
+	DefaultPoolSize = 256
+	DefaultPoolIdle = time.Minute
+)
+
+// Invoked for every packet received from a dialed peer.
+type PoolHandler func(*PoolPeer, *Packet)
+
+// Counters of a DialPool.
+type PoolStats struct {
+	// Peers currently in the pool
+	Peers int
+
+	// Peers created by Dial, including recreated ones
+	Dialed uint64
+
+	// Peers closed to make room for others
+	Evicted uint64
+
+	// Peers closed because they were idle for too long
+	Expired uint64
+
+	// Packets from sources which are not in the pool
+	Unmatched uint64
+}
+
+// Logical connections to many remote end-points over the single
+// unconnected socket of a listening Conn. Each PoolPeer behaves like a
+// dialed Conn: it sends to a fixed destination and receives only the
+// packets coming from there. The pool holds at most a fixed number of
+// peers, evicting the least recently used one, and closes those idle for
+// longer than the configured period the next time it is used.
+type DialPool struct {
+	conn *Conn
+
+	mutex    sync.Mutex
+	max      int
+	idle     time.Duration
+	peers    map[netip.AddrPort]*list.Element
+	lru      *list.List
+	handlers []PoolHandler
+	stats    PoolStats
+}
+
+// Remote end-point in a DialPool.
+type PoolPeer struct {
+	pool     *DialPool
+	addr     *net.UDPAddr
+	key      netip.AddrPort
+	lastUsed time.Time
+	closed   bool
+}
+
+// Create a pool on top of the connection, which must be opened by Listen
+// to receive replies. Non-positive limits select DefaultPoolSize and
+// DefaultPoolIdle respectively.
+func NewDialPool(conn *Conn, max int, idle time.Duration) *DialPool {
+	if max <= 0 {
+		max = DefaultPoolSize
+	}
+	if idle <= 0 {
+		idle = DefaultPoolIdle
+	}
+	pool := &DialPool{
+		conn:  conn,
+		max:   max,
+		idle:  idle,
+		peers: make(map[netip.AddrPort]*list.Element),
+		lru:   list.New(),
+	}
+	conn.AddHandler(pool.dispatch)
+	return pool
+}
+
+// Returns the peer for the address, creating it if necessary. The
+// address is parsed by ParseAddr.
+func (pool *DialPool) Dial(addr string) (*PoolPeer, error) {
+	udpAddr, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pool.DialUDP(udpAddr), nil
+}
+
+// Same as Dial for a resolved address.
+func (pool *DialPool) DialUDP(addr *net.UDPAddr) *PoolPeer {
+	key := peerKey(addr)
+	now := pool.conn.clock.Now()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.expire(now)
+	if e, ok := pool.peers[key]; ok {
+		peer := e.Value.(*PoolPeer)
+		pool.touch(e, now)
+		return peer
+	}
+
+	for pool.lru.Len() >= pool.max {
+		pool.remove(pool.lru.Back())
+		pool.stats.Evicted++
+	}
+	peer := &PoolPeer{pool: pool, addr: addr, key: key, lastUsed: now}
+	pool.peers[key] = pool.lru.PushFront(peer)
+	pool.stats.Dialed++
+	return peer
+}
+
+// Register a handler for packets from peers in the pool; packets from
+// other sources are only counted.
+func (pool *DialPool) AddHandler(f PoolHandler) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.handlers = append(pool.handlers, f)
+}
+
+// Close all peers. The underlying Conn is left open.
+func (pool *DialPool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for pool.lru.Len() > 0 {
+		pool.remove(pool.lru.Back())
+	}
+}
+
+func (pool *DialPool) Stats() PoolStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := pool.stats
+	stats.Peers = pool.lru.Len()
+	return stats
+}
+
+// EventHandler of the underlying Conn
+func (pool *DialPool) dispatch(conn *Conn, p *Packet) {
+	if p.Addr == nil {
+		return
+	}
+	key := peerKey(p.Addr)
+	now := conn.clock.Now()
+
+	pool.mutex.Lock()
+	pool.expire(now)
+	e, ok := pool.peers[key]
+	if !ok {
+		pool.stats.Unmatched++
+		pool.mutex.Unlock()
+		return
+	}
+	peer := e.Value.(*PoolPeer)
+	pool.touch(e, now)
+	handlers := pool.handlers
+	pool.mutex.Unlock()
+
+	for _, f := range handlers {
+		f(peer, p)
+	}
+}
+
+// Close the peers at the back of the LRU list which have been idle for
+// too long; the mutex must be held.
+func (pool *DialPool) expire(now time.Time) {
+	for e := pool.lru.Back(); e != nil; e = pool.lru.Back() {
+		if now.Sub(e.Value.(*PoolPeer).lastUsed) < pool.idle {
+			return
+		}
+		pool.remove(e)
+		pool.stats.Expired++
+	}
+}
+
+func (pool *DialPool) touch(e *list.Element, now time.Time) {
+	e.Value.(*PoolPeer).lastUsed = now
+	pool.lru.MoveToFront(e)
+}
+
+func (pool *DialPool) remove(e *list.Element) {
+	peer := pool.lru.Remove(e).(*PoolPeer)
+	peer.closed = true
+	delete(pool.peers, peer.key)
+}
+
+// Remote end-point of the peer
+func (peer *PoolPeer) Addr() *net.UDPAddr {
+	return peer.addr
+}
+
+// Send the message to the peer. Returns ErrClosedConn once the peer has
+// been closed, evicted or expired; call Dial again to recreate it.
+func (peer *PoolPeer) Send(msg Message) error {
+	pool := peer.pool
+	now := pool.conn.clock.Now()
+
+	pool.mutex.Lock()
+	pool.expire(now)
+	if peer.closed {
+		pool.mutex.Unlock()
+		return ErrClosedConn
+	}
+	pool.touch(pool.peers[peer.key], now)
+	pool.mutex.Unlock()
+
+	return pool.conn.SendTo(msg, peer.addr)
+}
+
+// Remove the peer from its pool.
+func (peer *PoolPeer) Close() {
+	pool := peer.pool
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if !peer.closed {
+		pool.remove(pool.peers[peer.key])
+	}
+}
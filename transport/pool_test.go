@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialPoolEviction(t *testing.T) {
+	servers := make([]*Conn, 3)
+	addrs := make([]string, len(servers))
+	for i := range servers {
+		servers[i] = startServer(t, 0)
+		defer servers[i].Disconnect()
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", servers[i].sock.LocalAddr().(*net.UDPAddr).Port)
+	}
+
+	conn := startPeer(t, 0)
+	defer conn.Disconnect()
+	pool := NewDialPool(conn, 2, time.Hour)
+	replies := make(chan string, 8)
+	pool.AddHandler(func(peer *PoolPeer, p *Packet) {
+		replies <- peer.Addr().String() + " " + string(p.Msg)
+	})
+
+	roundTrip := func(peer *PoolPeer) {
+		if err := peer.Send([]byte(expectedRequest)); err != nil {
+			t.Fatalf("TestDialPoolEviction cannot send: %s", err)
+		}
+		select {
+		case r := <-replies:
+			if expected := peer.Addr().String() + " " + expectedReply; r != expected {
+				t.Fatalf("TestDialPoolEviction expected %q got %q.", expected, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestDialPoolEviction no reply from %s", peer.Addr())
+		}
+	}
+
+	first, err := pool.Dial(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(first)
+	second, _ := pool.Dial(addrs[1])
+	roundTrip(second)
+
+	// the least recently used peer makes room for the third
+	third, _ := pool.Dial(addrs[2])
+	roundTrip(third)
+	if err := first.Send([]byte(expectedRequest)); err != ErrClosedConn {
+		t.Fatalf("TestDialPoolEviction expected %q got %v.", ErrClosedConn, err)
+	}
+
+	first, _ = pool.Dial(addrs[0])
+	roundTrip(first)
+
+	stats := pool.Stats()
+	if stats.Peers != 2 || stats.Dialed != 4 || stats.Evicted != 2 {
+		t.Fatalf("TestDialPoolEviction unexpected stats %+v", stats)
+	}
+}
+
+func TestDialPoolExpiry(t *testing.T) {
+	clock := NewManualClock(epoch)
+	conn := NewConn()
+	conn.SetClock(clock)
+	pool := NewDialPool(conn, 0, time.Minute)
+
+	a, _ := pool.Dial("127.0.0.1:9001")
+	pool.Dial("127.0.0.1:9002")
+	clock.Advance(40 * time.Second)
+	if again, _ := pool.Dial("127.0.0.1:9001"); again != a {
+		t.Fatalf("TestDialPoolExpiry expected the same peer")
+	}
+
+	clock.Advance(40 * time.Second)
+	pool.Dial("127.0.0.1:9003")
+	stats := pool.Stats()
+	if stats.Peers != 2 || stats.Expired != 1 {
+		t.Fatalf("TestDialPoolExpiry expected one expired peer got %+v.", stats)
+	}
+
+	// packets from unknown sources are counted, not dispatched
+	pool.AddHandler(func(*PoolPeer, *Packet) {
+		t.Errorf("TestDialPoolExpiry unexpected dispatch")
+	})
+	addr, _ := ParseAddr("127.0.0.1:9002")
+	pool.dispatch(conn, &Packet{Addr: addr})
+	if stats := pool.Stats(); stats.Unmatched != 1 {
+		t.Fatalf("TestDialPoolExpiry expected one unmatched packet got %+v.", stats)
+	}
+
+	a.Close()
+	if err := a.Send(nil); err != ErrClosedConn {
+		t.Fatalf("TestDialPoolExpiry expected %q got %v.", ErrClosedConn, err)
+	}
+}
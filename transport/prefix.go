@@ -0,0 +1,53 @@
+package transport
+
+import "bytes"
+
+// Registers an event handler for the messages which start with the
+// prefix, e.g. "cfg/" for one of several protocols sharing the
+// connection. It receives them after the ingress middleware with the
+// prefix stripped. Of overlapping prefixes the longest one a message
+// starts with wins, and the handlers registered by AddHandler and its
+// variants only receive the messages no prefix matches. The returned
+// function removes the handler.
+func (conn *Conn) HandlePrefix(prefix []byte, f EventHandler) (remove func()) {
+	h := &registeredHandler{f: Checked(f), prefix: append([]byte{}, prefix...), scoped: true}
+	conn.addHandler("", h)
+	return func() {
+		conn.removeHandler(h)
+	}
+}
+
+// Handlers to invoke on the packet and the packet they get: the handlers of
+// the longest prefix it starts with, along with a copy without the prefix,
+// or else the generic handlers and the packet itself.
+func route(handlers []*registeredHandler, p *Packet) ([]*registeredHandler, *Packet) {
+	scoped, longest := false, -1
+	for _, h := range handlers {
+		if !h.scoped {
+			continue
+		}
+		scoped = true
+		if len(h.prefix) > longest && bytes.HasPrefix(p.Msg, h.prefix) {
+			longest = len(h.prefix)
+		}
+	}
+	if !scoped {
+		return handlers, p
+	}
+
+	routed := make([]*registeredHandler, 0, len(handlers))
+	for _, h := range handlers {
+		switch {
+		case longest < 0 && !h.scoped:
+			routed = append(routed, h)
+		case longest >= 0 && h.scoped && len(h.prefix) == longest && bytes.HasPrefix(p.Msg, h.prefix):
+			routed = append(routed, h)
+		}
+	}
+	if longest < 0 {
+		return routed, p
+	}
+	q := *p
+	q.Msg = p.Msg[longest:]
+	return routed, &q
+}
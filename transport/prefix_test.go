@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Delivery to one of the handlers of TestHandlePrefix
+type routedMsg struct {
+	handler, msg string
+}
+
+func TestHandlePrefix(t *testing.T) {
+	conn := NewConn()
+	// messages arrive in an envelope which the prefixes must not see
+	conn.Use(func(p *Packet) (*Packet, error) {
+		q := *p
+		q.Msg = bytes.TrimPrefix(p.Msg, []byte("env:"))
+		return &q, nil
+	})
+	routed := make(chan routedMsg, 8)
+	handle := func(name string) EventHandler {
+		return func(conn *Conn, p *Packet) {
+			routed <- routedMsg{name, string(p.Msg)}
+		}
+	}
+	removeCfg := conn.HandlePrefix([]byte("cfg/"), handle("cfg"))
+	removeNet := conn.HandlePrefix([]byte("cfg/net/"), handle("cfg/net"))
+	conn.HandlePrefix([]byte("job/"), handle("job"))
+	conn.AddHandler(handle("generic"))
+
+	expect := func(msg string, expected routedMsg) {
+		t.Helper()
+		conn.dispatchEvent(&Packet{Msg: Message("env:" + msg)})
+		select {
+		case r := <-routed:
+			if r != expected {
+				t.Fatalf("TestHandlePrefix expected %q to reach %v got %v.", msg, expected, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestHandlePrefix expected %q to be delivered.", msg)
+		}
+	}
+	expect("cfg/timeout", routedMsg{"cfg", "timeout"})
+	expect("cfg/net/mtu", routedMsg{"cfg/net", "mtu"})
+	expect("job/42", routedMsg{"job", "42"})
+	expect("log/started", routedMsg{"generic", "log/started"})
+	expect("cfg", routedMsg{"generic", "cfg"})
+	expect("cfg/", routedMsg{"cfg", ""})
+
+	// once the longer prefix is gone, the shorter one takes over
+	removeNet()
+	expect("cfg/net/mtu", routedMsg{"cfg", "net/mtu"})
+	removeCfg()
+	expect("cfg/net/mtu", routedMsg{"generic", "cfg/net/mtu"})
+
+	select {
+	case r := <-routed:
+		t.Fatalf("TestHandlePrefix unexpected delivery %v.", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if n := len(conn.Stats().Handlers); n != 2 {
+		t.Fatalf("TestHandlePrefix expected 2 handlers left got %d.", n)
+	}
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDispatchQueueDrop(t *testing.T) {
+	release := make(chan bool)
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetHandlerLimit(1, WaitWhenSaturated)
+	conn.SetDispatchQueue(2, DropWhenSaturated)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		<-release
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestDispatchQueueDrop cannot listen: %s", err)
+	}
+	defer conn.Disconnect()
+
+	raw, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	// one packet is handled, one waits for the handler slot, two are queued
+	const sent = 10
+	const expected = sent - 4
+	for i := 0; i < sent; i++ {
+		raw.Write([]byte(expectedRequest))
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; conn.Stats().DroppedQueueFull < expected; i++ {
+		if i == 100 {
+			t.Fatalf("TestDispatchQueueDrop expected %d drops got %d.", expected, conn.Stats().DroppedQueueFull)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := conn.Stats()
+	if stats.DroppedQueueFull != expected || stats.QueueDepth != 2 || stats.QueueHighWater != 2 {
+		t.Fatalf("TestDispatchQueueDrop unexpected stats %+v", stats)
+	}
+	close(release)
+}
+
+// Burst of datagrams into a small kernel buffer while a single handler
+// keeps up only slowly; compare the delivered fraction across depths.
+func BenchmarkDispatchBurst(b *testing.B) {
+	for _, depth := range []int{0, 64, 1024} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			delivered := make(chan bool, 1<<16)
+			conn := NewConn()
+			conn.SetHandlerLimit(1, WaitWhenSaturated)
+			conn.SetDispatchQueue(depth, WaitWhenSaturated)
+			conn.AddHandler(func(conn *Conn, p *Packet) {
+				time.Sleep(200 * time.Microsecond)
+				delivered <- true
+			})
+			if err := conn.Listen(0); err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Disconnect()
+			conn.sock.SetReadBuffer(16 << 10)
+			port := conn.sock.LocalAddr().(*net.UDPAddr).Port
+
+			raw, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer raw.Close()
+
+			const burst = 256
+			msg := make([]byte, 256)
+			total, received := 0, 0
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < burst; j++ {
+					raw.Write(msg)
+					// faster than the handler, slow enough for the reader
+					if j%16 == 15 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				total += burst
+				for idle := false; !idle; {
+					select {
+					case <-delivered:
+						received++
+					case <-time.After(20 * time.Millisecond):
+						idle = true
+					}
+				}
+			}
+			b.ReportMetric(100*float64(received)/float64(total), "delivered%")
+		})
+	}
+}
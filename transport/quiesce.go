@@ -0,0 +1,85 @@
+package transport
+
+import "sync"
+
+// Closed channel handed out while no work is outstanding
+var quiesced = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Count of outstanding work, such as queued packets or pending requests,
+// which tells when it drops to zero without polling. The zero value has
+// nothing outstanding.
+type Outstanding struct {
+	mutex sync.Mutex
+	n     int
+	idle  chan struct{}
+}
+
+// Account for n more units of work, or fewer if n is negative.
+func (o *Outstanding) Add(n int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.n += n
+	if o.n < 0 {
+		panic("transport: negative outstanding work")
+	}
+	if o.n == 0 && o.idle != nil {
+		close(o.idle)
+		o.idle = nil
+	}
+}
+
+// Account for n more units of work unless the count would exceed max,
+// where zero means unlimited; returns false if it would.
+func (o *Outstanding) addUpTo(n, max int) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if max > 0 && o.n+n > max {
+		return false
+	}
+	o.n += n
+	return true
+}
+
+// Complete one unit of work.
+func (o *Outstanding) Done() {
+	o.Add(-1)
+}
+
+// Units of work outstanding right now
+func (o *Outstanding) Count() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.n
+}
+
+// Channel which is closed once no work is outstanding, right away if
+// none is now. Work added afterwards does not reopen it, so callers which
+// must know that nothing is in flight check again after waking up.
+func (o *Outstanding) Quiesced() <-chan struct{} {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.n == 0 {
+		return quiesced
+	}
+	if o.idle == nil {
+		o.idle = make(chan struct{})
+	}
+	return o.idle
+}
+
+// Channel which is closed once every packet queued so far has been
+// written or has failed, including those held back by a scheduler or
+// shaper. It fires right away on an idle connection, and after a
+// shutdown since the packets left behind fail with ErrClosedConn.
+func (conn *Conn) Quiesced() <-chan struct{} {
+	return conn.unsent.Quiesced()
+}
+
+// Packets queued or held by the sending loop right now
+func (conn *Conn) Unsent() int {
+	return conn.unsent.Count()
+}
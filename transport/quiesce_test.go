@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQuiesced(t *testing.T) {
+	conn, _, sink := startShaped(t, Shaping{Delay: 50 * time.Millisecond})
+	defer sink.Close()
+	defer conn.Disconnect()
+	select {
+	case <-conn.Quiesced():
+	default:
+		t.Fatalf("TestQuiesced expected an idle connection to be quiesced.")
+	}
+
+	// the shaper holds every packet back for the delay
+	const n = 10
+	addr := sink.LocalAddr().(*net.UDPAddr)
+	for i := 0; i < n-1; i++ {
+		if err := conn.SendTo(Message{byte(i)}, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Multisend(Message{n - 1}, []*net.UDPAddr{addr}); err != nil {
+		t.Fatal(err)
+	}
+	quiesced := conn.Quiesced()
+	select {
+	case <-quiesced:
+		t.Fatalf("TestQuiesced expected %d packets in flight got %d.", n, conn.Unsent())
+	default:
+	}
+
+	select {
+	case <-quiesced:
+	case <-time.After(time.Second):
+		t.Fatalf("TestQuiesced expected the packets to be written got %d left.", conn.Unsent())
+	}
+	buf := make([]byte, MessageSize)
+	sink.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := sink.Read(buf); err != nil {
+			t.Fatalf("TestQuiesced expected every packet to be written by then got %d: %s", i, err)
+		}
+	}
+}
+
+func TestQuiescedShutdown(t *testing.T) {
+	conn, _, sink := startShaped(t, Shaping{Delay: time.Hour})
+	defer sink.Close()
+	if err := conn.SendTo(Message("held"), sink.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	quiesced := conn.Quiesced()
+	conn.Disconnect()
+	select {
+	case <-quiesced:
+	case <-time.After(time.Second):
+		t.Fatalf("TestQuiescedShutdown expected the packets left behind to be released got %d.", conn.Unsent())
+	}
+}
+
+func TestOutstanding(t *testing.T) {
+	var o Outstanding
+	o.Add(2)
+	first := o.Quiesced()
+	o.Done()
+	select {
+	case <-first:
+		t.Fatalf("TestOutstanding expected one unit of work to be outstanding.")
+	default:
+	}
+	o.Done()
+	select {
+	case <-first:
+	default:
+		t.Fatalf("TestOutstanding expected the channel to be closed at zero.")
+	}
+	o.Add(1)
+	if second := o.Quiesced(); second == first {
+		t.Fatalf("TestOutstanding expected new work to need a new channel.")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package transport
+
+// The race detector allocates on its own and sync.Pool drops items at
+// random under it, so allocation targets are not asserted.
+const raceEnabled = true
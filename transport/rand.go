@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Create a generator which may be shared by goroutines and yields the same
+// sequence for the same seed, so that every random choice drawn from it in
+// a given order can be replayed.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+type lockedSource struct {
+	mutex sync.Mutex
+	src   rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.src.Seed(seed)
+}
+
+// Seed derived from the current time, for connections which were not
+// given one.
+func randomSeed() int64 {
+	if seed := time.Now().UnixNano(); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+// Replace the seed of the generator returned by Rand, e.g. with the one
+// reported by the OpenEvent of a run which is to be replayed. Must be
+// called before the socket is opened.
+func (conn *Conn) SetSeed(seed int64) {
+	conn.seed = seed
+	conn.rnd.Seed(seed)
+}
+
+// Seed of the generator returned by Rand
+func (conn *Conn) Seed() int64 {
+	return conn.seed
+}
+
+// Source of every random choice of this connection and of the protocols
+// built on top of it. Drawing all of them from here, together with a
+// ManualClock, makes a run reproducible from its seed.
+func (conn *Conn) Rand() *rand.Rand {
+	return conn.rnd
+}
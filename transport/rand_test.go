@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnSeed(t *testing.T) {
+	conn, err := NewConnFromConfig(&Config{Seed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	e, ok := (<-conn.Events()).(*OpenEvent)
+	if !ok || e.Seed != 42 || conn.Seed() != 42 {
+		t.Fatalf("TestConnSeed expected the seed 42 to be reported got %v.", e)
+	}
+	replay := NewRand(e.Seed)
+	for i := 0; i < 10; i++ {
+		if a, b := conn.Rand().Int63(), replay.Int63(); a != b {
+			t.Fatalf("TestConnSeed expected draw %d to be replayed, got %d and %d.", i, a, b)
+		}
+	}
+
+	if NewConn().Seed() == 0 {
+		t.Fatalf("TestConnSeed expected a seed to be picked.")
+	}
+	if _, ok := e.LocalAddr.(*net.UDPAddr); !ok {
+		t.Fatalf("TestConnSeed expected a UDP address got %v.", e.LocalAddr)
+	}
+}
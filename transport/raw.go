@@ -0,0 +1,43 @@
+package transport
+
+import "net"
+
+// Sees an incoming datagram as read from the socket and returns true to
+// consume it; see AddRawHandler.
+type RawHandler func(conn *Conn, p *Packet) bool
+
+// Register a handler for frames below the codec, e.g. to interoperate with
+// the extra message types of another implementation. Raw handlers run in
+// registration order in the dispatching goroutine, after raw mirrors and
+// before the ingress middleware, the built-in hints, the other mirrors and
+// the handlers; a datagram which one of them consumes goes no further and
+// does not count against the handler limit. They must not block, and must
+// not modify a datagram they pass on. This API is unstable and may change
+// between releases.
+func (conn *Conn) AddRawHandler(h RawHandler) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.rawHandlers = append(conn.rawHandlers[:len(conn.rawHandlers):len(conn.rawHandlers)], h)
+}
+
+// Queue a pre-encoded frame like SendTo, bypassing the egress middleware
+// and layers so that it reaches the wire as given; the counterpart of
+// AddRawHandler and just as unstable. The frame is still bound by the
+// datagram size towards the peer, the scheduler or shaper and the limits
+// of the connection, and wrapped by the tunnel of a socket opened through
+// a dialer.
+func (conn *Conn) SendRaw(frame Message, addr *net.UDPAddr) error {
+	o := newOutgoing(frame, addr)
+	o.raw = true
+	return conn.enqueue(o)
+}
+
+// Pass the datagram to the raw handlers; returns true if one consumed it.
+func (conn *Conn) rawDispatch(handlers []RawHandler, p *Packet) bool {
+	for _, h := range handlers {
+		if h(conn, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var errUntyped = errors.New("untyped frame")
+
+func TestRawHandler(t *testing.T) {
+	frame := Message{0xee, 0x01, 'x'}
+	raw := make(chan Message, 4)
+	typed := make(chan Message, 4)
+	receiver := listenLimited(t, Limits{}, func(conn *Conn) {
+		// the decoder of the built-in pipeline rejects what it does not know
+		conn.Use(func(p *Packet) (*Packet, error) {
+			if p.Msg[0] != 't' {
+				return nil, errUntyped
+			}
+			return p, nil
+		})
+		conn.AddRawHandler(func(conn *Conn, p *Packet) bool {
+			if p.Msg[0] != 0xee {
+				return false
+			}
+			raw <- p.Msg
+			return true
+		})
+		conn.AddHandler(func(conn *Conn, p *Packet) {
+			typed <- p.Msg
+		})
+	})
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: receiver.LocalAddr().Port}
+
+	// the sender's layer would prepend a header to anything but raw frames
+	sender := listenLimited(t, Limits{}, func(conn *Conn) {
+		conn.UseLayer(headerLayer(4))
+		conn.UseEgress(func(p *Packet) (*Packet, error) {
+			p.Msg[0] = 't'
+			return p, nil
+		})
+	})
+	if err := sender.SendRaw(frame, addr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-raw:
+		if !bytes.Equal(msg, frame) {
+			t.Fatalf("TestRawHandler expected the frame as sent got %x.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestRawHandler expected the raw handler to receive the frame.")
+	}
+
+	// frames the raw handler passes on take the normal path
+	if err := sender.SendTo(Message("typed"), addr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-typed:
+		if !bytes.HasSuffix(msg, []byte("typed")) {
+			t.Fatalf("TestRawHandler expected the typed message got %q.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestRawHandler expected the typed handler to receive the message.")
+	}
+	select {
+	case msg := <-typed:
+		t.Fatalf("TestRawHandler expected the raw frame to stay away from the handlers got %x.", msg)
+	default:
+	}
+}
+
+func TestSendRawLimit(t *testing.T) {
+	conn := listenLimited(t, Limits{}, func(conn *Conn) {
+		conn.UseLayer(headerLayer(8))
+	})
+	_, addr := rawPeer(t)
+
+	// the layers do not apply, the datagram size does
+	size := DefaultPeerDatagramSize
+	if err := conn.SendRaw(make(Message, size), addr); err != nil {
+		t.Fatalf("TestSendRawLimit expected a frame of the datagram size to be sent got %v.", err)
+	}
+	var sizeErr *SizeError
+	if err := conn.SendRaw(make(Message, size+1), addr); !errors.As(err, &sizeErr) || sizeErr.Limit != size {
+		t.Fatalf("TestSendRawLimit expected a SizeError with limit %d got %v.", size, err)
+	}
+}
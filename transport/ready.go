@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNotReady = errors.New("Socket did not become ready in time")
+
+// Tracks the start of the background processes of one socket.
+type readiness struct {
+	mutex   sync.Mutex
+	pending int
+	event   Event
+	ch      chan bool
+}
+
+func newReadiness() *readiness {
+	return &readiness{ch: make(chan bool)}
+}
+
+// Expect n processes to start and then publish the event.
+func (r *readiness) expect(n int, e Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending, r.event = n, e
+}
+
+// Called by every background process when it starts running.
+func (r *readiness) started(conn *Conn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending--
+	if r.pending == 0 {
+		close(r.ch)
+		conn.emit(r.event)
+	}
+}
+
+// Determine if the socket is bound and its background processes are
+// running, i.e. incoming packets reach the handlers. This does not block;
+// see WaitReady.
+func (conn *Conn) Ready() bool {
+	select {
+	case <-conn.readyChan():
+		return true
+	default:
+	}
+	return false
+}
+
+// Block until the connection is ready or the timeout expires, in which case
+// it returns ErrNotReady. The connection may be opened by another goroutine
+// in the meantime, but a Disconnect before it became ready is not noticed.
+func (conn *Conn) WaitReady(timeout time.Duration) error {
+	expired, stop := conn.after("wait ready", timeout)
+	defer stop()
+	select {
+	case <-conn.readyChan():
+		return nil
+	case <-expired:
+	}
+	return ErrNotReady
+}
+
+func (conn *Conn) readyChan() chan bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.ready.ch
+}
@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	if conn.Ready() {
+		t.Fatalf("TestReady expected idle connection not to be ready")
+	}
+	if err := conn.WaitReady(10 * time.Millisecond); err != ErrNotReady {
+		t.Fatalf("TestReady expected %q got %v.", ErrNotReady, err)
+	}
+
+	waited := make(chan error)
+	go func() {
+		waited <- conn.WaitReady(time.Second)
+	}()
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestReady cannot listen: %s", err)
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("TestReady expected to become ready got %v.", err)
+	}
+	if !conn.Ready() {
+		t.Fatalf("TestReady expected listening connection to be ready")
+	}
+
+	conn.Disconnect()
+	if conn.Ready() {
+		t.Fatalf("TestReady expected closed connection not to be ready")
+	}
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of SetRedial
+const (
+	// Dial attempts after the socket of DialHost or a tunnel broke
+	DefaultRedialAttempts = 5
+
+	// Wait before the second attempt, doubled for every further one up to
+	// MaxRedialBackoff
+	DefaultRedialBackoff = 100 * time.Millisecond
+)
+
+const MaxRedialBackoff = 10 * time.Second
+
+// Stage of a redial reported by a ReconnectEvent
+type ReconnectPhase int
+
+const (
+	// Dial attempt is about to be made
+	ReconnectAttempt ReconnectPhase = iota
+
+	// Attempt opened a socket, which carries the traffic from now on
+	ReconnectSucceeded
+
+	// Every attempt failed and the connection stays Idle
+	ReconnectFailed
+)
+
+var reconnectPhases = []string{"attempt", "succeeded", "failed"}
+
+func (p ReconnectPhase) String() string {
+	if p < 0 || int(p) >= len(reconnectPhases) {
+		return "unknown"
+	}
+	return reconnectPhases[p]
+}
+
+// The connection dials again after DialHost gave up on an address or a
+// tunnel broke. Err is the failure of the previous attempt, if any.
+type ReconnectEvent struct {
+	Phase   ReconnectPhase
+	Attempt int
+	Err     error
+}
+
+func (e *ReconnectEvent) String() string {
+	if e.Err == nil {
+		return fmt.Sprintf("reconnect: %s %d", e.Phase, e.Attempt)
+	}
+	return fmt.Sprintf("reconnect: %s %d: %s", e.Phase, e.Attempt, e.Err)
+}
+
+// Make up to attempts dial attempts when the connection redials, waiting
+// backoff before the second one and twice as long before every further
+// one, up to MaxRedialBackoff. Zero values select the defaults; a
+// ConfigError reports negative ones. Must be called before the socket is
+// opened.
+func (conn *Conn) SetRedial(attempts int, backoff time.Duration) error {
+	switch {
+	case attempts < 0:
+		return &ConfigError{"attempts", "must not be negative"}
+	case backoff < 0:
+		return &ConfigError{"backoff", "must not be negative"}
+	}
+	if attempts == 0 {
+		attempts = DefaultRedialAttempts
+	}
+	if backoff == 0 {
+		backoff = DefaultRedialBackoff
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.redialAttempts, conn.redialBackoff = attempts, backoff
+	return nil
+}
+
+// Dial again after reset until an attempt succeeds, the attempts are used
+// up or the connection is disconnected or opened by someone else. Every
+// attempt is published as a ReconnectEvent; only the failure of the last
+// one is passed to Err, and the packets kept by SetQueueRetention fail
+// with ErrNotConnected then.
+func (conn *Conn) reconnect(dial func() error) {
+	conn.mutex.Lock()
+	attempts, backoff, done := conn.redialAttempts, conn.redialBackoff, conn.done
+	conn.mutex.Unlock()
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			wait, stop := conn.after("redial backoff", backoff)
+			select {
+			case <-wait:
+			case <-done:
+				stop()
+				return
+			}
+			stop()
+			if backoff *= 2; backoff > MaxRedialBackoff {
+				backoff = MaxRedialBackoff
+			}
+		}
+		if conn.State() != Idle {
+			return
+		}
+		conn.emit(&ReconnectEvent{ReconnectAttempt, attempt, err})
+		switch err = dial(); err {
+		case nil:
+			conn.emit(&ReconnectEvent{ReconnectSucceeded, attempt, nil})
+			return
+		case ErrAlreadyConnected, ErrClosedConn:
+			return
+		}
+	}
+	conn.emit(&ReconnectEvent{ReconnectFailed, attempts, err})
+	conn.failRetained(ErrNotConnected)
+	conn.report(err)
+}
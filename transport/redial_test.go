@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Reconnect events published until the redial settles
+func reconnectEvents(t *testing.T, conn *Conn) []ReconnectEvent {
+	var events []ReconnectEvent
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-conn.Events():
+			if r, ok := e.(*ReconnectEvent); ok {
+				events = append(events, *r)
+				if r.Phase != ReconnectAttempt {
+					return events
+				}
+			}
+		case <-timeout:
+			t.Fatalf("reconnectEvents expected the redial to settle got %v.", events)
+		}
+	}
+}
+
+func TestRedialRetries(t *testing.T) {
+	echo, port := startEcho(t)
+	defer echo.Close()
+
+	// the first two lookups of the redial fail
+	resolver := &fakeResolver{answers: [][]net.IP{{net.IPv4(127, 0, 0, 1)}, nil, nil, {net.IPv4(127, 0, 0, 1)}}}
+	conn := NewConn()
+	conn.SetResolver(resolver)
+	if err := conn.SetRedial(3, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.DialHost("flaky.example", port); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	conn.redial(&hostTarget{"flaky.example", port})
+	events := reconnectEvents(t, conn)
+	if len(events) != 4 || events[1].Err == nil || events[3].Phase != ReconnectSucceeded || events[3].Attempt != 3 {
+		t.Fatalf("TestRedialRetries expected success on the third attempt got %v.", events)
+	}
+	if conn.State() != Dialed {
+		t.Fatalf("TestRedialRetries expected %s got %s.", Dialed, conn.State())
+	}
+}
+
+func TestRedialGivesUp(t *testing.T) {
+	echo, port := startEcho(t)
+	defer echo.Close()
+
+	resolver := &fakeResolver{answers: [][]net.IP{{net.IPv4(127, 0, 0, 1)}, nil}}
+	conn := NewConn()
+	conn.SetResolver(resolver)
+	conn.SetRedial(2, time.Millisecond)
+	if err := conn.DialHost("gone.example", port); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	go conn.redial(&hostTarget{"gone.example", port})
+	events := reconnectEvents(t, conn)
+	last := events[len(events)-1]
+	if len(events) != 3 || last.Phase != ReconnectFailed || !errors.Is(last.Err, ErrUnresolvable) {
+		t.Fatalf("TestRedialGivesUp expected a failure after 2 attempts got %v.", events)
+	}
+	if err := <-conn.Err; !errors.Is(err, ErrUnresolvable) || conn.State() != Idle {
+		t.Fatalf("TestRedialGivesUp expected the last failure on Err and an idle connection got %v in %s.", err, conn.State())
+	}
+	if err := conn.SetRedial(-1, 0); err == nil {
+		t.Fatalf("TestRedialGivesUp expected negative attempts to be refused.")
+	}
+}
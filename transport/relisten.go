@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Time Relisten keeps reading the replaced socket unless changed by
+// SetRelistenGrace
+const DefaultRelistenGrace = time.Second
+
+var ErrNotListening = errors.New("Socket has not been opened by Listen")
+
+// The connection moved to a new socket; datagrams are sent from To from
+// now on while the socket at From is still read until it is retired.
+type RelistenEvent struct {
+	From, To net.Addr
+}
+
+func (e *RelistenEvent) String() string {
+	return fmt.Sprintf("relisten: %s -> %s", e.From, e.To)
+}
+
+// A socket replaced by Relisten was closed after its grace period.
+type RetiredEvent struct {
+	LocalAddr net.Addr
+}
+
+func (e *RetiredEvent) String() string {
+	return fmt.Sprintf("retired: %s", e.LocalAddr)
+}
+
+// Time the socket replaced by Relisten is still read, so that datagrams
+// already on their way to the old port are not lost; zero selects
+// DefaultRelistenGrace.
+func (conn *Conn) SetRelistenGrace(grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultRelistenGrace
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.relistenGrace = grace
+}
+
+// Register a callback which Relisten invokes with the old and the new
+// local address once the connection has switched, e.g. to advertise the
+// new address to the cluster. Callbacks are kept across Disconnect.
+func (conn *Conn) NotifyRelisten(f func(from, to *net.UDPAddr)) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.relistenHooks = append(conn.relistenHooks[:len(conn.relistenHooks):len(conn.relistenHooks)], f)
+}
+
+// Move a listening connection to a new port, keeping its handlers,
+// middleware and all other state: the new socket is opened with the same
+// options and read right away, then every packet still to be sent leaves
+// from it. The old socket is read for the grace period of
+// SetRelistenGrace before it is closed. Publishes a RelistenEvent after
+// the switch and a RetiredEvent once the old socket is closed. Fails with
+// ErrNotListening for dialed connections.
+func (conn *Conn) Relisten(port uint) error {
+	laddr, err := net.ResolveUDPAddr("udp", ":"+strconv.FormatUint(uint64(port), 10))
+	if err != nil {
+		return err
+	}
+
+	conn.mutex.Lock()
+	switch {
+	case conn.state == Idle:
+		conn.mutex.Unlock()
+		return ErrNotConnected
+	case conn.disconnecting || !conn.state.isOpen():
+		conn.mutex.Unlock()
+		return ErrClosedConn
+	case conn.state != Listening:
+		conn.mutex.Unlock()
+		return ErrNotListening
+	}
+	sock, err := net.ListenUDP("udp4", laddr)
+	if err == nil {
+		if err = conn.configure(sock); err != nil {
+			sock.Close()
+		}
+	}
+	if err != nil {
+		conn.mutex.Unlock()
+		return err
+	}
+
+	old := conn.sock
+	conn.sock = sock
+	conn.retiring[old] = true
+	// the old socket is retired in the background as well
+	conn.running.Add(2)
+	conn.spawnRole("receiving", func() { conn.receiving(sock, nil) })
+	conn.sendSock.Store(sock)
+
+	from, to := old.LocalAddr().(*net.UDPAddr), sock.LocalAddr().(*net.UDPAddr)
+	hooks, grace, done := conn.relistenHooks, conn.relistenGrace, conn.done
+	conn.emit(&RelistenEvent{from, to})
+	conn.mutex.Unlock()
+
+	conn.spawnRole("retire", func() { conn.retire(old, grace, done) })
+	for _, f := range hooks {
+		f(from, to)
+	}
+	return nil
+}
+
+// Close the replaced socket once the grace period has passed or the
+// connection shuts down, whichever comes first.
+func (conn *Conn) retire(sock *net.UDPConn, grace time.Duration, done chan bool) {
+	defer conn.running.Done()
+	expired, stop := conn.after("relisten grace", grace)
+	select {
+	case <-expired:
+	case <-done:
+	}
+	stop()
+	addr := sock.LocalAddr()
+	sock.Close()
+	conn.emit(&RetiredEvent{addr})
+}
+
+// Whether the socket has been replaced by Relisten; the entry is removed
+// since only its receiving loop asks, once it failed.
+func (conn *Conn) retired(sock *net.UDPConn) bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if !conn.retiring[sock] {
+		return false
+	}
+	delete(conn.retiring, sock)
+	return true
+}
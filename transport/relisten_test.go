@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelisten(t *testing.T) {
+	const sent = 400
+
+	node := NewConn()
+	go monitor(node.Err, t)
+	node.SetRelistenGrace(200 * time.Millisecond)
+	var mutex sync.Mutex
+	received := make(map[uint32]bool)
+	node.AddHandler(func(conn *Conn, p *Packet) {
+		mutex.Lock()
+		received[binary.BigEndian.Uint32(p.Msg)] = true
+		mutex.Unlock()
+	})
+	if err := node.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Disconnect)
+	<-node.Events()
+
+	// the peer learns the new address as soon as the node switched, like
+	// from a re-advertisement, while its earlier datagrams are in flight
+	var dst atomic.Pointer[net.UDPAddr]
+	dst.Store(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: node.LocalAddr().Port})
+	moved := make(chan [2]*net.UDPAddr, 1)
+	node.NotifyRelisten(func(from, to *net.UDPAddr) {
+		dst.Store(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: to.Port})
+		moved <- [2]*net.UDPAddr{from, to}
+	})
+
+	peer := NewConn()
+	go monitor(peer.Err, t)
+	var sources []int
+	replies := make(chan bool, sent)
+	peer.AddHandler(func(conn *Conn, p *Packet) {
+		mutex.Lock()
+		sources = append(sources, p.Addr.Port)
+		mutex.Unlock()
+		replies <- true
+	})
+	if err := peer.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(peer.Disconnect)
+	<-peer.Events()
+	peerAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: peer.LocalAddr().Port}
+
+	// steady traffic in both directions, one packet per millisecond
+	for i := uint32(0); i < sent; i++ {
+		if i == sent/2 {
+			go func() {
+				if err := node.Relisten(0); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		msg := binary.BigEndian.AppendUint32(nil, i)
+		if err := peer.SendTo(msg, dst.Load()); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.SendTo(msg, peerAddr); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var addrs [2]*net.UDPAddr
+	select {
+	case addrs = <-moved:
+	case <-time.After(time.Second):
+		t.Fatalf("TestRelisten expected the switch to be reported.")
+	}
+	if addrs[0].Port == addrs[1].Port || node.LocalAddr().Port != addrs[1].Port {
+		t.Fatalf("TestRelisten expected a new port got %s and %s.", addrs[0], addrs[1])
+	}
+	for i := 0; i < sent; i++ {
+		select {
+		case <-replies:
+		case <-time.After(time.Second):
+			t.Fatalf("TestRelisten expected %d packets from the node got %d.", sent, i)
+		}
+	}
+	for i := 0; ; i++ {
+		mutex.Lock()
+		n := len(received)
+		mutex.Unlock()
+		if n == sent {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("TestRelisten expected %d packets at the node got %d.", sent, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the node's packets leave from the old port, then from the new one
+	mutex.Lock()
+	switches := 0
+	for i := range sources {
+		if i > 0 && sources[i] != sources[i-1] {
+			switches++
+		}
+	}
+	first, last := sources[0], sources[len(sources)-1]
+	mutex.Unlock()
+	if switches != 1 || first != addrs[0].Port || last != addrs[1].Port {
+		t.Fatalf("TestRelisten expected one switch from %d to %d got %d, from %d to %d.", addrs[0].Port, addrs[1].Port, switches, first, last)
+	}
+
+	var relistened, retired bool
+	timeout := time.After(time.Second)
+	for !retired {
+		select {
+		case e := <-node.Events():
+			switch e := e.(type) {
+			case *RelistenEvent:
+				relistened = e.To.String() == addrs[1].String()
+			case *RetiredEvent:
+				retired = relistened && e.LocalAddr.String() == addrs[0].String()
+			}
+		case <-timeout:
+			t.Fatalf("TestRelisten expected a RelistenEvent and a RetiredEvent.")
+		}
+	}
+	if s := node.State(); s != Listening {
+		t.Fatalf("TestRelisten expected the node to keep listening got %s.", s)
+	}
+}
+
+// Disconnect cuts the grace period short and waits for the old socket to
+// be retired.
+func TestRelistenDisconnect(t *testing.T) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetRelistenGrace(time.Hour)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Relisten(0); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan bool)
+	go func() {
+		conn.Disconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("TestRelistenDisconnect expected Disconnect to cut the grace period short.")
+	}
+	for _, g := range conn.DebugDump().Goroutines {
+		if g.Role == "retire" {
+			t.Fatalf("TestRelistenDisconnect expected the old socket to be retired got %d retiring goroutines.", g.Count)
+		}
+	}
+
+	retired := false
+	for e := range conn.Events() {
+		switch e.(type) {
+		case *RetiredEvent:
+			retired = true
+		case *ShutdownEvent:
+			if !retired {
+				t.Fatalf("TestRelistenDisconnect expected a RetiredEvent before the ShutdownEvent.")
+			}
+			return
+		}
+	}
+}
+
+func TestRelistenDialed(t *testing.T) {
+	conn := NewConn()
+	if err := conn.Relisten(0); err != ErrNotConnected {
+		t.Fatalf("TestRelistenDialed expected ErrNotConnected got %v.", err)
+	}
+	if err := conn.Dial("127.0.0.1:9", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	if err := conn.Relisten(0); err != ErrNotListening {
+		t.Fatalf("TestRelistenDialed expected ErrNotListening got %v.", err)
+	}
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"sort"
+	"time"
+)
+
+// Keep the packets still queued when the connection redials, i.e. when
+// DialHost moves on from a failing address or a broken tunnel is dialed
+// again, and send them from the new socket once it is open rather than
+// failing them with ErrClosedConn. Packets queued for longer than maxAge
+// by then are dropped with ErrExpired and counted in
+// Stats.RetainedExpired. Kept packets count against Limits.Unsent and
+// Quiesced waits for them. They are held for as long as the redial keeps
+// trying (see SetRedial): once it gives up they fail with
+// ErrNotConnected, and Disconnect fails them with ErrClosedConn.
+//
+// Plain sends are still written at most once: only the packets the old
+// socket did not write are kept, and those it wrote are not repeated even
+// if they were lost. The handlers survive the redial, so the retries of
+// the reliable layers of package gossip carry on as before. Zero turns
+// retention off, which is the default; a ConfigError reports a negative
+// age. Must be called before the socket is opened.
+func (conn *Conn) SetQueueRetention(maxAge time.Duration) error {
+	if maxAge < 0 {
+		return &ConfigError{"maxAge", "must not be negative"}
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.retainAge = maxAge
+	return nil
+}
+
+// Keep the packets the sending loop is left with if the connection
+// redials with retention; returns false if they must fail instead.
+func (conn *Conn) retain(pending map[*Packet]*outgoing) bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if !conn.retaining {
+		return false
+	}
+	kept := make([]*outgoing, 0, len(pending))
+	for _, o := range pending {
+		if o.Addr == nil {
+			// the dialed end-point of the new socket may differ
+			o.meta.Peer = nil
+		}
+		kept = append(kept, o)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].seq < kept[j].seq })
+	conn.retained = append(conn.retained, kept...)
+	return true
+}
+
+// Packets kept across the last redial, in the order they were queued
+func (conn *Conn) takeRetained() []*outgoing {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	retained := conn.retained
+	conn.retained = nil
+	return retained
+}
+
+// Fail the packets kept for a socket which will not be opened.
+func (conn *Conn) failRetained(err error) {
+	for _, o := range conn.takeRetained() {
+		if o.done != nil {
+			o.done(err)
+		}
+		conn.unsent.Done()
+	}
+}
@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Connection dialed to a plain socket which holds what it sends until the
+// manual clock has passed a second.
+func startRetaining(t *testing.T, maxAge time.Duration) (*Conn, *ManualClock, *net.UDPConn) {
+	sink, addr := rawPeer(t)
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := NewConn()
+	conn.SetClock(clock)
+	conn.UseShaper(NewShaper(Shaping{Delay: time.Second}))
+	if err := conn.SetQueueRetention(maxAge); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Dial(addr.String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	return conn, clock, sink
+}
+
+func TestQueueRetention(t *testing.T) {
+	conn, clock, sink := startRetaining(t, time.Minute)
+	var expected []string
+	for i := 0; i < 5; i++ {
+		expected = append(expected, fmt.Sprintf("queued-%d", i))
+		conn.Send(Message(expected[i]))
+	}
+
+	// the socket dies with the packets still queued and is dialed again
+	if !conn.reset() {
+		t.Fatalf("TestQueueRetention cannot reset the connection.")
+	}
+	if n := conn.Unsent(); n != 5 {
+		t.Fatalf("TestQueueRetention expected 5 packets kept during the redial got %d.", n)
+	}
+	if err := conn.Dial(sink.LocalAddr().String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	for i := 0; i < 50 && len(received) < 5; i++ {
+		clock.Advance(time.Second)
+		received = append(received, readAll(t, sink, 10*time.Millisecond)...)
+	}
+	received = append(received, readAll(t, sink, 50*time.Millisecond)...)
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("TestQueueRetention expected %v once each in order got %v.", expected, received)
+	}
+	if s := conn.Stats(); s.RetainedExpired != 0 || s.Unsent != 0 {
+		t.Fatalf("TestQueueRetention expected nothing expired or left got %d and %d.", s.RetainedExpired, s.Unsent)
+	}
+}
+
+func TestQueueRetentionExpired(t *testing.T) {
+	conn, clock, sink := startRetaining(t, time.Minute)
+	failures := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		conn.SendToAsync(Message("stale"), sink.LocalAddr().(*net.UDPAddr), func(err error) { failures <- err })
+	}
+	conn.reset()
+	clock.Advance(2 * time.Minute)
+	if err := conn.Dial(sink.LocalAddr().String(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-failures:
+			if se, ok := err.(*SendError); !ok || se.Err != ErrExpired {
+				t.Fatalf("TestQueueRetentionExpired expected ErrExpired got %v.", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestQueueRetentionExpired expected 3 stale packets to fail got %d.", i)
+		}
+	}
+	if s := conn.Stats(); s.RetainedExpired != 3 || s.Unsent != 0 {
+		t.Fatalf("TestQueueRetentionExpired expected 3 expired packets got %d and %d unsent.", s.RetainedExpired, s.Unsent)
+	}
+
+	// without retention the queue fails with the socket
+	conn.SetQueueRetention(0)
+	conn.Send(Message("lost"))
+	conn.reset()
+	if n := conn.Unsent(); n != 0 {
+		t.Fatalf("TestQueueRetentionExpired expected nothing kept without retention got %d.", n)
+	}
+	if err := conn.SetQueueRetention(-time.Second); err == nil {
+		t.Fatalf("TestQueueRetentionExpired expected a negative age to be refused.")
+	}
+}
+
+func TestQueueRetentionDisconnect(t *testing.T) {
+	conn, _, sink := startRetaining(t, time.Minute)
+	failures := make(chan error, 1)
+	conn.SendToAsync(Message("kept"), sink.LocalAddr().(*net.UDPAddr), func(err error) { failures <- err })
+	conn.reset()
+	conn.Disconnect()
+	if err := <-failures; err != ErrClosedConn || conn.Unsent() != 0 {
+		t.Fatalf("TestQueueRetentionDisconnect expected the kept packet to fail with ErrClosedConn got %v.", err)
+	}
+}
+
+func TestQueueRetentionRedialFails(t *testing.T) {
+	sink, addr := rawPeer(t)
+	resolver := &fakeResolver{answers: [][]net.IP{{net.IPv4(127, 0, 0, 1)}, nil}}
+	conn := NewConn()
+	conn.SetResolver(resolver)
+	conn.UseShaper(NewShaper(Shaping{Delay: time.Minute}))
+	conn.SetQueueRetention(time.Minute)
+	conn.SetRedial(2, time.Millisecond)
+	if err := conn.DialHost("gone.example", uint(addr.Port)); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	go func(errs chan error) {
+		for range errs {
+		}
+	}(conn.Err)
+
+	failures := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		conn.SendToAsync(Message("kept"), sink.LocalAddr().(*net.UDPAddr), func(err error) { failures <- err })
+	}
+	conn.redial(&hostTarget{"gone.example", uint(addr.Port)})
+	for i := 0; i < 3; i++ {
+		if err := <-failures; err != ErrNotConnected {
+			t.Fatalf("TestQueueRetentionRedialFails expected %q once the redial gave up got %v.", ErrNotConnected, err)
+		}
+	}
+	select {
+	case <-conn.Quiesced():
+	case <-time.After(time.Second):
+		t.Fatalf("TestQueueRetentionRedialFails expected nothing left unsent got %d.", conn.Unsent())
+	}
+}
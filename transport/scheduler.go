@@ -0,0 +1,220 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Packets the sending goroutine takes into its scheduler before senders
+// block; this bounds the memory held by a slow socket.
+const schedulerDepth = 64
+
+// Packet dropped by the sending goroutine because its PacketMeta.Deadline
+// passed while it was waiting in the scheduler
+var ErrExpired = errors.New("Packet missed its deadline")
+
+// What a Scheduler knows about a queued packet besides its contents.
+type PacketMeta struct {
+	// Larger values are sent first by NewPriorityScheduler
+	Priority int
+
+	// Time after which the packet is dropped with ErrExpired rather than
+	// sent; the zero value never expires
+	Deadline time.Time
+
+	// Destination of the packet, the dialed end-point if Packet.Addr is nil
+	Peer *net.UDPAddr
+
+	// Deliberate retransmission of an earlier message, which
+	// SetOutboundDedup never suppresses
+	Repeat bool
+}
+
+// Order in which the sending goroutine writes queued packets. Both methods
+// are only called from the sending goroutine of a single socket, so
+// implementations need no locking; SetScheduler creates a fresh one for
+// every socket.
+type Scheduler interface {
+	// Take a packet which has been queued for sending.
+	Enqueue(p *Packet, meta PacketMeta)
+
+	// Returns the next packet to write at the given time. A nil packet
+	// with a positive wait asks to be polled again after wait, or as soon
+	// as another packet is enqueued; a nil packet with no wait means the
+	// scheduler is empty.
+	Next(now time.Time) (p *Packet, wait time.Duration)
+}
+
+// Replace the constructor of the scheduler which orders outgoing packets;
+// nil restores NewFIFOScheduler. Must be called before the socket is opened.
+func (conn *Conn) SetScheduler(newScheduler func() Scheduler) {
+	if newScheduler == nil {
+		newScheduler = NewFIFOScheduler
+	}
+	conn.newScheduler = newScheduler
+}
+
+// Queue the message like SendTo along with what the scheduler needs to
+// order it. A nil meta.Peer is filled in with the destination.
+func (conn *Conn) SendScheduled(msg Message, addr *net.UDPAddr, meta PacketMeta) error {
+	o := newOutgoing(msg, addr)
+	o.meta = meta
+	return conn.enqueue(o)
+}
+
+// Packets in the order they were queued; this is the default.
+func NewFIFOScheduler() Scheduler {
+	return new(fifoScheduler)
+}
+
+// The queue is reused from its start once it runs empty or full, so a
+// steady flow of packets allocates nothing.
+type fifoScheduler struct {
+	queue []*Packet
+	head  int
+}
+
+func (s *fifoScheduler) Enqueue(p *Packet, meta PacketMeta) {
+	if s.head > 0 && len(s.queue) == cap(s.queue) {
+		n := copy(s.queue, s.queue[s.head:])
+		clear(s.queue[n:])
+		s.queue, s.head = s.queue[:n], 0
+	}
+	s.queue = append(s.queue, p)
+}
+
+func (s *fifoScheduler) Next(now time.Time) (*Packet, time.Duration) {
+	if s.head == len(s.queue) {
+		return nil, 0
+	}
+	p := s.queue[s.head]
+	s.queue[s.head] = nil
+	if s.head++; s.head == len(s.queue) {
+		s.queue, s.head = s.queue[:0], 0
+	}
+	return p, 0
+}
+
+// Packets of higher PacketMeta.Priority first; among equal priorities the
+// earliest deadline, then the order they were queued.
+func NewPriorityScheduler() Scheduler {
+	return new(priorityScheduler)
+}
+
+type prioritized struct {
+	p    *Packet
+	meta PacketMeta
+	seq  uint64
+}
+
+type priorityScheduler struct {
+	queue priorityQueue
+	seq   uint64
+}
+
+func (s *priorityScheduler) Enqueue(p *Packet, meta PacketMeta) {
+	s.seq++
+	s.queue = append(s.queue, prioritized{p, meta, s.seq})
+	s.queue.up(len(s.queue) - 1)
+}
+
+func (s *priorityScheduler) Next(now time.Time) (*Packet, time.Duration) {
+	if len(s.queue) == 0 {
+		return nil, 0
+	}
+	return s.queue.pop().p, 0
+}
+
+// Binary heap of the queued packets. It is maintained by hand rather than
+// through container/heap, which would box every packet in an interface.
+type priorityQueue []prioritized
+
+func (q priorityQueue) less(i, j int) bool {
+	a, b := q[i].meta, q[j].meta
+	switch {
+	case a.Priority != b.Priority:
+		return a.Priority > b.Priority
+	case a.Deadline.IsZero() != b.Deadline.IsZero():
+		return !a.Deadline.IsZero()
+	case !a.Deadline.Equal(b.Deadline):
+		return a.Deadline.Before(b.Deadline)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !q.less(i, parent) {
+			return
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
+
+func (q priorityQueue) down(i int) {
+	for {
+		first := 2*i + 1
+		if first >= len(q) {
+			return
+		}
+		if second := first + 1; second < len(q) && q.less(second, first) {
+			first = second
+		}
+		if !q.less(first, i) {
+			return
+		}
+		q[i], q[first] = q[first], q[i]
+		i = first
+	}
+}
+
+func (q *priorityQueue) pop() prioritized {
+	old := *q
+	top, n := old[0], len(old)-1
+	old[0], old[n] = old[n], prioritized{}
+	*q = old[:n]
+	q.down(0)
+	return top
+}
+
+// One packet per peer in turn, so that a burst to one destination does not
+// delay the others; the packets of each peer keep their order.
+func NewFairScheduler() Scheduler {
+	return &fairScheduler{queues: make(map[netip.AddrPort][]*Packet)}
+}
+
+type fairScheduler struct {
+	queues map[netip.AddrPort][]*Packet
+
+	// Peers with queued packets in the order they are served
+	ring []netip.AddrPort
+}
+
+func (s *fairScheduler) Enqueue(p *Packet, meta PacketMeta) {
+	key := peerKey(meta.Peer)
+	if _, ok := s.queues[key]; !ok {
+		s.ring = append(s.ring, key)
+	}
+	s.queues[key] = append(s.queues[key], p)
+}
+
+func (s *fairScheduler) Next(now time.Time) (*Packet, time.Duration) {
+	if len(s.ring) == 0 {
+		return nil, 0
+	}
+	key := s.ring[0]
+	s.ring = s.ring[1:]
+	queue := s.queues[key]
+	p := queue[0]
+	if len(queue) == 1 {
+		delete(s.queues, key)
+	} else {
+		s.queues[key] = queue[1:]
+		s.ring = append(s.ring, key)
+	}
+	return p, 0
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type scheduled struct {
+	msg  string
+	meta PacketMeta
+}
+
+func drainScheduler(s Scheduler, packets []scheduled) []string {
+	for _, p := range packets {
+		s.Enqueue(&Packet{Addr: p.meta.Peer, Msg: Message(p.msg)}, p.meta)
+	}
+	var order []string
+	for {
+		p, _ := s.Next(time.Now())
+		if p == nil {
+			return order
+		}
+		order = append(order, string(p.Msg))
+	}
+}
+
+var (
+	schedPeerA = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7946}
+	schedPeerB = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 7946}
+)
+
+func TestFIFOScheduler(t *testing.T) {
+	order := drainScheduler(NewFIFOScheduler(), []scheduled{
+		{"a", PacketMeta{Priority: 1}},
+		{"b", PacketMeta{Priority: 3}},
+		{"c", PacketMeta{Priority: 2}},
+	})
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("TestFIFOScheduler expected %v got %v.", expected, order)
+	}
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	now := time.Now()
+	order := drainScheduler(NewPriorityScheduler(), []scheduled{
+		{"low", PacketMeta{Priority: -1}},
+		{"first", PacketMeta{}},
+		{"late", PacketMeta{Deadline: now.Add(time.Minute)}},
+		{"urgent", PacketMeta{Priority: 5}},
+		{"soon", PacketMeta{Deadline: now.Add(time.Second)}},
+		{"second", PacketMeta{}},
+	})
+	expected := []string{"urgent", "soon", "late", "first", "second", "low"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("TestPriorityScheduler expected %v got %v.", expected, order)
+	}
+}
+
+func TestFairScheduler(t *testing.T) {
+	order := drainScheduler(NewFairScheduler(), []scheduled{
+		{"a1", PacketMeta{Peer: schedPeerA}},
+		{"a2", PacketMeta{Peer: schedPeerA}},
+		{"a3", PacketMeta{Peer: schedPeerA}},
+		{"b1", PacketMeta{Peer: schedPeerB}},
+		{"b2", PacketMeta{Peer: schedPeerB}},
+	})
+	expected := []string{"a1", "b1", "a2", "b2", "a3"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("TestFairScheduler expected %v got %v.", expected, order)
+	}
+}
+
+// Holds every packet back until released, so that a test can queue
+// several before the inner scheduler picks the order.
+type heldScheduler struct {
+	Scheduler
+	held *int32
+}
+
+func (s heldScheduler) Next(now time.Time) (*Packet, time.Duration) {
+	if atomic.LoadInt32(s.held) != 0 {
+		return nil, time.Millisecond
+	}
+	return s.Scheduler.Next(now)
+}
+
+// Open a connection whose scheduler holds every packet back until the
+// returned release is called, and which records the order of writes.
+func startHeldConn(t *testing.T, inner func() Scheduler) (conn *Conn, release func(), written chan string) {
+	held := int32(1)
+	written = make(chan string, 16)
+	cfg := &Config{
+		Scheduler: func() Scheduler {
+			return heldScheduler{inner(), &held}
+		},
+		Egress: []Middleware{func(p *Packet) (*Packet, error) {
+			written <- string(p.Msg)
+			return p, nil
+		}},
+	}
+	conn, err := NewConnFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() { atomic.StoreInt32(&held, 0) }, written
+}
+
+func TestConnScheduler(t *testing.T) {
+	var sinks [2]*net.UDPAddr
+	for i := range sinks {
+		sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		sinks[i] = sink.LocalAddr().(*net.UDPAddr)
+	}
+	addrA, addrB := sinks[0], sinks[1]
+
+	cases := []struct {
+		name     string
+		new      func() Scheduler
+		expected []string
+	}{
+		{"fifo", NewFIFOScheduler, []string{"a1", "a2", "b1", "bulk", "urgent"}},
+		{"priority", NewPriorityScheduler, []string{"urgent", "a1", "a2", "b1", "bulk"}},
+		{"fair", NewFairScheduler, []string{"a1", "b1", "a2", "urgent", "bulk"}},
+	}
+	for _, c := range cases {
+		conn, release, written := startHeldConn(t, c.new)
+		go monitor(conn.Err, t)
+		for _, s := range []struct {
+			msg      string
+			addr     *net.UDPAddr
+			priority int
+		}{
+			{"a1", addrA, 0},
+			{"a2", addrA, 0},
+			{"b1", addrB, 0},
+			{"bulk", addrA, -1},
+			{"urgent", addrB, 1},
+		} {
+			if err := conn.SendScheduled(Message(s.msg), s.addr, PacketMeta{Priority: s.priority}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		release()
+
+		var order []string
+		for range c.expected {
+			select {
+			case msg := <-written:
+				order = append(order, msg)
+			case <-time.After(time.Second):
+				t.Fatalf("TestConnScheduler expected %s order %v got %v.", c.name, c.expected, order)
+			}
+		}
+		if !reflect.DeepEqual(order, c.expected) {
+			t.Fatalf("TestConnScheduler expected %s order %v got %v.", c.name, c.expected, order)
+		}
+		conn.Disconnect()
+	}
+}
+
+func TestConnSchedulerDeadline(t *testing.T) {
+	conn, release, written := startHeldConn(t, NewFIFOScheduler)
+	defer conn.Disconnect()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9911}
+
+	if err := conn.SendScheduled(Message("stale"), addr, PacketMeta{Deadline: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendScheduled(Message("fresh"), addr, PacketMeta{Deadline: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	select {
+	case err := <-conn.Err:
+		if !errors.Is(err, ErrExpired) {
+			t.Fatalf("TestConnSchedulerDeadline expected ErrExpired got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestConnSchedulerDeadline expected ErrExpired.")
+	}
+	if msg := <-written; msg != "fresh" {
+		t.Fatalf("TestConnSchedulerDeadline expected only %q to be written got %q.", "fresh", msg)
+	}
+}
+
+func TestConnSchedulerClose(t *testing.T) {
+	conn, _, _ := startHeldConn(t, NewFIFOScheduler)
+	result := make(chan error, 1)
+	conn.SendToAsync(Message("held"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9911}, func(err error) {
+		result <- err
+	})
+	conn.Disconnect()
+	if err := <-result; err != ErrClosedConn {
+		t.Fatalf("TestConnSchedulerClose expected ErrClosedConn got %v.", err)
+	}
+}
@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+var ErrBroadcastUnavailable = errors.New("Broadcast probe did not come back")
+
+// Outcome of a successful broadcast or multicast self-test
+type BroadcastProbe struct {
+	// Where the probe was sent
+	Dst *net.UDPAddr
+
+	// Source address of the probe as it came back, i.e. the local
+	// address the kernel sent it from
+	From *net.UDPAddr
+
+	// Local interface owning that address; nil if none does
+	Interface *net.Interface
+
+	RTT time.Duration
+}
+
+// Send a self-test probe to the broadcast or multicast address dst and
+// wait up to timeout for it to come back to this connection, e.g. before
+// relying on broadcast discovery on a host whose firewall or network may
+// filter it. A nil dst probes the limited broadcast address at the local
+// port. The probe bypasses the egress middleware and the broadcast
+// protection of SetBroadcast; every connection consumes self-tests before
+// its handlers, so real peers never see them. Returns
+// ErrBroadcastUnavailable if the probe did not come back in time.
+func (conn *Conn) ProbeBroadcast(dst *net.UDPAddr, timeout time.Duration) (BroadcastProbe, error) {
+	local := conn.LocalAddr()
+	if local == nil {
+		return BroadcastProbe{}, ErrNotConnected
+	}
+	if dst == nil {
+		dst = &net.UDPAddr{IP: net.IPv4bcast, Port: local.Port}
+	}
+	nonce, back := conn.selfTests.start()
+	defer conn.selfTests.finish(nonce)
+	msg, err := wire.SelfTest{Nonce: nonce}.Encode(wire.Version(conn.EncodeVersion()))
+	if err != nil {
+		return BroadcastProbe{}, err
+	}
+
+	conn.mutex.Lock()
+	done := conn.done
+	conn.mutex.Unlock()
+	sent := conn.clock.Now()
+	if err := conn.SendRaw(msg, dst); err != nil {
+		return BroadcastProbe{}, err
+	}
+	expired, stop := conn.after("broadcast self-test", timeout)
+	defer stop()
+	select {
+	case from := <-back:
+		return BroadcastProbe{Dst: dst, From: from, Interface: InterfaceOf(from.IP), RTT: conn.clock.Now().Sub(sent)}, nil
+	case <-expired:
+		return BroadcastProbe{}, ErrBroadcastUnavailable
+	case <-done:
+		return BroadcastProbe{}, ErrClosedConn
+	}
+}
+
+// Consume a self-test probe, recording it if it is one of ours. Returns
+// false for packets of other kinds.
+func (conn *Conn) selfTest(p *Packet) bool {
+	probe, _, err := wire.DecodeSelfTest(p.Msg)
+	if err != nil {
+		return false
+	}
+	conn.selfTests.resolve(probe.Nonce, p.Addr)
+	return true
+}
+
+// Local interface which owns the address, nil if none does.
+func InterfaceOf(ip net.IP) *net.Interface {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range interfaces {
+		addrs, err := interfaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &interfaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Self-tests waiting for their probe, by nonce. Nonces are random so that
+// the probes of several connections on a host cannot be mistaken for each
+// other.
+type selfTester struct {
+	mutex   sync.Mutex
+	pending map[uint64]chan *net.UDPAddr
+}
+
+func (st *selfTester) start() (uint64, chan *net.UDPAddr) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.pending == nil {
+		st.pending = make(map[uint64]chan *net.UDPAddr)
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	nonce := binary.BigEndian.Uint64(b[:])
+	back := make(chan *net.UDPAddr, 1)
+	st.pending[nonce] = back
+	return nonce, back
+}
+
+func (st *selfTester) finish(nonce uint64) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	delete(st.pending, nonce)
+}
+
+func (st *selfTester) resolve(nonce uint64, from *net.UDPAddr) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	select {
+	case st.pending[nonce] <- from:
+	default:
+	}
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Connection listening on every interface with broadcast protection, so
+// that directed broadcasts to loopback reach it
+func startSelfTesting(t *testing.T) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetBroadcast(true)
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Disconnect)
+	<-conn.Events()
+	return conn
+}
+
+func TestProbeBroadcast(t *testing.T) {
+	conn := startSelfTesting(t)
+	dst := &net.UDPAddr{IP: net.IPv4(127, 255, 255, 255), Port: conn.LocalAddr().Port}
+	probe, err := conn.ProbeBroadcast(dst, time.Second)
+	if err != nil {
+		t.Fatalf("TestProbeBroadcast expected the probe to come back got %v.", err)
+	}
+	if probe.Dst != dst || !probe.From.IP.IsLoopback() || probe.Interface == nil || probe.Interface.Flags&net.FlagLoopback == 0 {
+		t.Fatalf("TestProbeBroadcast expected the probe to come back over loopback got %+v.", probe)
+	}
+}
+
+func TestProbeBroadcastTimeout(t *testing.T) {
+	conn := startSelfTesting(t)
+	peer := startSelfTesting(t)
+	received := make(chan *Packet, 1)
+	peer.AddHandler(func(conn *Conn, p *Packet) { received <- p })
+
+	// the probe reaches the peer instead, which must not pass it on
+	dst := &net.UDPAddr{IP: net.IPv4(127, 255, 255, 255), Port: peer.LocalAddr().Port}
+	if _, err := conn.ProbeBroadcast(dst, 100*time.Millisecond); err != ErrBroadcastUnavailable {
+		t.Fatalf("TestProbeBroadcastTimeout expected %v got %v.", ErrBroadcastUnavailable, err)
+	}
+
+	// a multicast group nobody joined
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 1), Port: conn.LocalAddr().Port}
+	if _, err := conn.ProbeBroadcast(group, 100*time.Millisecond); err != ErrBroadcastUnavailable {
+		t.Fatalf("TestProbeBroadcastTimeout expected %v for the unjoined group got %v.", ErrBroadcastUnavailable, err)
+	}
+	select {
+	case p := <-received:
+		t.Fatalf("TestProbeBroadcastTimeout expected the peer to consume the probe got %q.", p.Msg)
+	default:
+	}
+	if _, err := NewConn().ProbeBroadcast(nil, time.Second); err != ErrNotConnected {
+		t.Fatalf("TestProbeBroadcastTimeout expected %v without a socket got %v.", ErrNotConnected, err)
+	}
+}
@@ -0,0 +1,337 @@
+package transport
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Reason of the DropEvent for incoming packets discarded by a Shaper
+var ErrShaped = errors.New("Packet dropped by the shaper")
+
+// Degradation a Shaper applies to a real socket, e.g. to rehearse WAN
+// behaviour on a LAN. The zero value leaves traffic untouched.
+type Shaping struct {
+	// Added to every outgoing packet, plus a uniformly distributed
+	// jitter in [0, Jitter) which may reorder packets
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Cap of outgoing bytes per second; zero is unlimited
+	Bandwidth int
+
+	// Caps for destination subnets which apply instead of Bandwidth; the
+	// class with the longest prefix matching the destination wins.
+	// Packets of a dialed connection have no destination of their own and
+	// are capped by Bandwidth.
+	Classes []RateClass
+
+	// Probability to drop an incoming packet before dispatch
+	Loss float64
+}
+
+// Bandwidth cap of the destinations within a subnet
+type RateClass struct {
+	Prefix netip.Prefix
+
+	// Cap of outgoing bytes per second; zero is unlimited
+	Bandwidth int
+}
+
+// Outgoing traffic of a rate class which left the shaper
+type RateClassStats struct {
+	Packets uint64
+	Bytes   uint64
+
+	// Packets which had to wait for the cap of their class
+	Throttled uint64
+}
+
+// Applies an adjustable Shaping to the send path and the dispatch of a
+// Conn; see UseShaper.
+type Shaper struct {
+	mutex   sync.Mutex
+	shaping Shaping
+	rnd     *rand.Rand
+	stats   map[netip.Prefix]*RateClassStats
+}
+
+// Create a shaper with the initial settings. Jitter and loss are drawn
+// from the generator of the Conn it is used by, see UseShaper.
+func NewShaper(shaping Shaping) *Shaper {
+	s := &Shaper{
+		stats: make(map[netip.Prefix]*RateClassStats),
+	}
+	s.Set(shaping)
+	return s
+}
+
+// Replace the settings; delay and loss apply to packets queued from now
+// on, the caps to every packet which has yet to leave.
+func (s *Shaper) Set(shaping Shaping) {
+	classes := make([]RateClass, len(shaping.Classes))
+	for i, c := range shaping.Classes {
+		classes[i] = RateClass{c.Prefix.Masked(), c.Bandwidth}
+	}
+	shaping.Classes = classes
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shaping = shaping
+}
+
+// Current settings
+func (s *Shaper) Shaping() Shaping {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	shaping := s.shaping
+	shaping.Classes = append([]RateClass(nil), shaping.Classes...)
+	return shaping
+}
+
+// Traffic per rate class since the shaper was created, including classes
+// which have been removed since. The zero Prefix stands for destinations
+// outside every class.
+func (s *Shaper) ClassStats() map[netip.Prefix]RateClassStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make(map[netip.Prefix]RateClassStats, len(s.stats))
+	for prefix, c := range s.stats {
+		stats[prefix] = *c
+	}
+	return stats
+}
+
+// Replace the source of randomness for jitter and loss, e.g. to make
+// tests reproducible.
+func (s *Shaper) SetRand(rnd *rand.Rand) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rnd = rnd
+}
+
+// Delay of the next outgoing packet including its jitter
+func (s *Shaper) delay() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.shaping.Delay
+	if s.shaping.Jitter > 0 {
+		d += time.Duration(s.rnd.Int63n(int64(s.shaping.Jitter)))
+	}
+	return d
+}
+
+// Prefix of the rate class with the longest match for the destination,
+// the zero Prefix if there is none.
+func (s *Shaper) class(addr *net.UDPAddr) netip.Prefix {
+	if addr == nil {
+		return netip.Prefix{}
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return netip.Prefix{}
+	}
+	ip = ip.Unmap()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var best netip.Prefix
+	for _, c := range s.shaping.Classes {
+		if c.Prefix.Contains(ip) && (!best.IsValid() || c.Prefix.Bits() > best.Bits()) {
+			best = c.Prefix
+		}
+	}
+	return best
+}
+
+// Cap of the rate class; Bandwidth if it is the zero Prefix or has been
+// removed.
+func (s *Shaper) bandwidth(class netip.Prefix) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.shaping.Classes {
+		if c.Prefix == class {
+			return c.Bandwidth
+		}
+	}
+	return s.shaping.Bandwidth
+}
+
+// Account for a packet of the class which leaves the shaper.
+func (s *Shaper) sent(class netip.Prefix, size int, throttled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := s.stats[class]
+	if c == nil {
+		c = &RateClassStats{}
+		s.stats[class] = c
+	}
+	c.Packets++
+	c.Bytes += uint64(size)
+	if throttled {
+		c.Throttled++
+	}
+}
+
+// Ingress middleware which drops packets with probability Shaping.Loss.
+func (s *Shaper) Ingress(p *Packet) (*Packet, error) {
+	s.mutex.Lock()
+	drop := s.shaping.Loss > 0 && s.rnd.Float64() < s.shaping.Loss
+	s.mutex.Unlock()
+	if drop {
+		return nil, ErrShaped
+	}
+	return p, nil
+}
+
+// Wrap the schedulers created by newScheduler so that packets leave them
+// only after the delay of the shaper and within its bandwidth.
+func (s *Shaper) Scheduler(newScheduler func() Scheduler) func() Scheduler {
+	return func() Scheduler {
+		return &shapedScheduler{inner: newScheduler(), shaper: s, classes: make(map[netip.Prefix]*classQueue)}
+	}
+}
+
+// Shape the traffic of the connection: the scheduler set so far is wrapped
+// by the delay and bandwidth cap, and the loss is applied after all ingress
+// middleware registered before. Jitter and loss are drawn from Conn.Rand.
+// Must be called before the socket is opened; the settings themselves can
+// be changed at any time through the shaper.
+func (conn *Conn) UseShaper(s *Shaper) {
+	s.SetRand(conn.rnd)
+	conn.SetScheduler(s.Scheduler(conn.newScheduler))
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.addStage("shaper", StageAny, s.Ingress)
+}
+
+type delayed struct {
+	p       *Packet
+	release time.Time
+	seq     uint64
+}
+
+type delayQueue []delayed
+
+func (q delayQueue) Len() int { return len(q) }
+
+func (q delayQueue) Less(i, j int) bool {
+	if !q[i].release.Equal(q[j].release) {
+		return q[i].release.Before(q[j].release)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q delayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(delayed)) }
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	old[len(old)-1] = delayed{}
+	*q = old[:len(old)-1]
+	return x
+}
+
+// Packets of a rate class released by the delay line, in order
+type classQueue struct {
+	class   netip.Prefix
+	packets []*Packet
+
+	// Whether a packet had to wait for the pacer
+	throttled []bool
+
+	// Earliest time the pacer lets the next packet go
+	free time.Time
+}
+
+// Delay line behind the inner scheduler followed by a pacer per rate class
+// which spaces packets by their transmission time at the cap of the class
+type shapedScheduler struct {
+	inner  Scheduler
+	shaper *Shaper
+	line   delayQueue
+	seq    uint64
+
+	// Queues in the order their classes were first seen
+	classes map[netip.Prefix]*classQueue
+	order   []*classQueue
+}
+
+func (s *shapedScheduler) Enqueue(p *Packet, meta PacketMeta) {
+	s.inner.Enqueue(p, meta)
+}
+
+func (s *shapedScheduler) Next(now time.Time) (*Packet, time.Duration) {
+	// packets enter the delay line in the order of the inner scheduler
+	var wait time.Duration
+	for {
+		p, innerWait := s.inner.Next(now)
+		if p == nil {
+			wait = innerWait
+			break
+		}
+		s.seq++
+		heap.Push(&s.line, delayed{p, now.Add(s.shaper.delay()), s.seq})
+	}
+	sooner := func(d time.Duration) {
+		if wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	// and leave it into the queue of their class
+	for len(s.line) > 0 && !s.line[0].release.After(now) {
+		p := heap.Pop(&s.line).(delayed).p
+		class := s.shaper.class(p.Addr)
+		q := s.queue(class)
+		q.packets = append(q.packets, p)
+		q.throttled = append(q.throttled, s.shaper.bandwidth(class) > 0 && (len(q.packets) > 1 || q.free.After(now)))
+	}
+	if len(s.line) > 0 {
+		sooner(s.line[0].release.Sub(now))
+	}
+
+	// the class whose pacer has been free the longest goes first, so
+	// that a throttled class never holds back another
+	var next *classQueue
+	for _, q := range s.order {
+		switch {
+		case len(q.packets) == 0:
+		case q.free.After(now):
+			sooner(q.free.Sub(now))
+		case next == nil || q.free.Before(next.free):
+			next = q
+		}
+	}
+	if next == nil {
+		return nil, wait
+	}
+
+	p, throttled := next.packets[0], next.throttled[0]
+	next.packets[0] = nil
+	next.packets, next.throttled = next.packets[1:], next.throttled[1:]
+	s.shaper.sent(next.class, len(p.Msg), throttled)
+	if bandwidth := s.shaper.bandwidth(next.class); bandwidth > 0 {
+		if next.free.Before(now) {
+			next.free = now
+		}
+		next.free = next.free.Add(time.Duration(len(p.Msg)) * time.Second / time.Duration(bandwidth))
+	}
+	return p, 0
+}
+
+func (s *shapedScheduler) queue(class netip.Prefix) *classQueue {
+	q := s.classes[class]
+	if q == nil {
+		q = &classQueue{class: class}
+		s.classes[class] = q
+		s.order = append(s.order, q)
+	}
+	return q
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Open a shaped connection and a plain socket receiving what it sends.
+func startShaped(t *testing.T, shaping Shaping) (*Conn, *Shaper, *net.UDPConn) {
+	sink, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaper := NewShaper(shaping)
+	conn := NewConn()
+	conn.SetSeed(1)
+	conn.UseShaper(shaper)
+	go monitor(conn.Err, t)
+	if err := conn.Listen(0); err != nil {
+		sink.Close()
+		t.Fatal(err)
+	}
+	return conn, shaper, sink
+}
+
+func TestShaperDelay(t *testing.T) {
+	const delay, jitter = 40 * time.Millisecond, 10 * time.Millisecond
+	conn, _, sink := startShaped(t, Shaping{Delay: delay, Jitter: jitter})
+	defer sink.Close()
+	defer conn.Disconnect()
+
+	const n = 10
+	var mutex sync.Mutex
+	sent := make([]time.Time, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			mutex.Lock()
+			sent[i] = time.Now()
+			mutex.Unlock()
+			conn.SendTo(Message{byte(i)}, sink.LocalAddr().(*net.UDPAddr))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var total time.Duration
+	buf := make([]byte, MessageSize)
+	sink.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := sink.Read(buf); err != nil {
+			t.Fatalf("TestShaperDelay expected %d packets got %d: %s", n, i, err)
+		}
+		mutex.Lock()
+		latency := time.Since(sent[buf[0]])
+		mutex.Unlock()
+		if latency < delay {
+			t.Fatalf("TestShaperDelay expected a latency of at least %s got %s.", delay, latency)
+		}
+		total += latency
+	}
+	if mean := total / n; mean > delay+jitter+20*time.Millisecond {
+		t.Fatalf("TestShaperDelay expected a mean latency near %s got %s.", delay+jitter/2, mean)
+	}
+}
+
+func TestShaperBandwidth(t *testing.T) {
+	const bandwidth, size, n = 100000, 500, 60
+	conn, shaper, sink := startShaped(t, Shaping{Bandwidth: bandwidth})
+	defer sink.Close()
+	defer conn.Disconnect()
+
+	go func() {
+		for i := 0; i < n; i++ {
+			conn.SendTo(make(Message, size), sink.LocalAddr().(*net.UDPAddr))
+		}
+	}()
+
+	var first time.Time
+	buf := make([]byte, MessageSize)
+	sink.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := sink.Read(buf); err != nil {
+			t.Fatalf("TestShaperBandwidth expected %d packets got %d: %s", n, i, err)
+		}
+		if i == 0 {
+			first = time.Now()
+		}
+	}
+
+	// the first packet leaves right away, each further one after its
+	// transmission time at the cap
+	elapsed := time.Since(first)
+	rate := float64((n-1)*size) / elapsed.Seconds()
+	if rate > 1.1*bandwidth || rate < 0.5*bandwidth {
+		t.Fatalf("TestShaperBandwidth expected about %d bytes per second got %.0f.", bandwidth, rate)
+	}
+	if shaper.Shaping().Bandwidth != bandwidth {
+		t.Fatalf("TestShaperBandwidth expected the settings to be kept.")
+	}
+}
+
+func TestShaperLoss(t *testing.T) {
+	var handled int32
+	conn, shaper, sink := startShaped(t, Shaping{Loss: 0.5})
+	defer sink.Close()
+	defer conn.Disconnect()
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		atomic.AddInt32(&handled, 1)
+	})
+	port := (<-conn.Events()).(*OpenEvent).LocalAddr.(*net.UDPAddr).Port
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+	send := func(n int) int32 {
+		before := atomic.LoadInt32(&handled)
+		for i := 0; i < n; i++ {
+			sink.WriteTo([]byte(expectedRequest), addr)
+			time.Sleep(100 * time.Microsecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		return atomic.LoadInt32(&handled) - before
+	}
+
+	const n = 200
+	if got := send(n); got < n*35/100 || got > n*65/100 {
+		t.Fatalf("TestShaperLoss expected about half of %d packets got %d.", n, got)
+	}
+
+	// the loss can be lifted while the socket is open
+	shaper.Set(Shaping{})
+	if got := send(50); got != 50 {
+		t.Fatalf("TestShaperLoss expected all 50 packets got %d.", got)
+	}
+}
+
+func TestShaperClasses(t *testing.T) {
+	const size, n = 500, 30
+	const classless, classed = 50000, 25000
+	near, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer near.Close()
+	far, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skipf("TestShaperClasses needs a second loopback address: %s", err)
+	}
+	defer far.Close()
+
+	farClass := netip.MustParsePrefix("127.0.0.2/32")
+	conn, shaper, sink := startShaped(t, Shaping{
+		Bandwidth: classless,
+		Classes:   []RateClass{{farClass, classed}, {netip.MustParsePrefix("10.0.0.0/8"), 0}},
+	})
+	sink.Close()
+	defer conn.Disconnect()
+
+	// both destinations receive concurrently, each at the rate of its class
+	measure := func(sock *net.UDPConn, rates chan<- float64) {
+		var first time.Time
+		buf := make([]byte, MessageSize)
+		sock.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for i := 0; i < n; i++ {
+			if _, err := sock.Read(buf); err != nil {
+				t.Errorf("TestShaperClasses expected %d packets got %d: %s", n, i, err)
+				rates <- 0
+				return
+			}
+			if i == 0 {
+				first = time.Now()
+			}
+		}
+		rates <- float64((n-1)*size) / time.Since(first).Seconds()
+	}
+	nearRates, farRates := make(chan float64, 1), make(chan float64, 1)
+	go measure(near, nearRates)
+	go measure(far, farRates)
+	go func() {
+		for i := 0; i < n; i++ {
+			conn.SendTo(make(Message, size), near.LocalAddr().(*net.UDPAddr))
+			conn.SendTo(make(Message, size), far.LocalAddr().(*net.UDPAddr))
+		}
+	}()
+
+	nearRate, farRate := <-nearRates, <-farRates
+	if nearRate > 1.1*classless || nearRate < 0.5*classless {
+		t.Fatalf("TestShaperClasses expected about %d bytes per second outside the classes got %.0f.", classless, nearRate)
+	}
+	if farRate > 1.1*classed || farRate < 0.5*classed {
+		t.Fatalf("TestShaperClasses expected about %d bytes per second in %s got %.0f.", classed, farClass, farRate)
+	}
+
+	stats := shaper.ClassStats()
+	for _, class := range []netip.Prefix{{}, farClass} {
+		if s := stats[class]; s.Packets != n || s.Bytes != n*size || s.Throttled == 0 {
+			t.Fatalf("TestShaperClasses expected %d throttled packets in %s got %+v.", n, class, s)
+		}
+	}
+}
+
+func TestShaperClassUpdate(t *testing.T) {
+	wide, narrow := netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("10.1.0.0/16")
+	shaper := NewShaper(Shaping{Bandwidth: 1000, Classes: []RateClass{{wide, 0}, {narrow, 500}}})
+	tests := []struct {
+		ip    net.IP
+		class netip.Prefix
+	}{
+		{net.IPv4(10, 1, 2, 3), narrow},
+		{net.IPv4(10, 2, 0, 1), wide},
+		{net.IPv4(192, 168, 0, 1), netip.Prefix{}},
+	}
+	for _, test := range tests {
+		if class := shaper.class(&net.UDPAddr{IP: test.ip}); class != test.class {
+			t.Fatalf("TestShaperClassUpdate expected %s in %s got %s.", test.ip, test.class, class)
+		}
+	}
+
+	// a removed class falls back to the classless cap
+	shaper.Set(Shaping{Bandwidth: 1000, Classes: []RateClass{{netip.MustParsePrefix("10.1.9.9/16"), 200}}})
+	if class := shaper.class(&net.UDPAddr{IP: net.IPv4(10, 1, 2, 3)}); class != narrow || shaper.bandwidth(narrow) != 200 {
+		t.Fatalf("TestShaperClassUpdate expected the updated class %s got %s.", narrow, class)
+	}
+	if class := shaper.class(&net.UDPAddr{IP: net.IPv4(10, 2, 0, 1)}); class.IsValid() || shaper.bandwidth(wide) != 1000 {
+		t.Fatalf("TestShaperClassUpdate expected %s to be removed got %s.", wide, class)
+	}
+}
@@ -0,0 +1,74 @@
+package transport
+
+import "time"
+
+// Packets waiting for each dispatch shard before the dispatcher blocks
+const shardQueueDepth = 16
+
+// Packet and the handlers to run on it, in the queue of a dispatch shard
+type shardJob struct {
+	handlers            []*registeredHandler
+	p                   *Packet
+	slots               chan bool
+	threshold, interval time.Duration
+}
+
+// Hand incoming packets to n dispatcher goroutines by the hash of their
+// source address instead of running every handler in its own goroutine.
+// Packets from the same peer are then handled one after the other in the
+// order they arrived, with the handlers in registration order, while
+// different peers proceed in parallel. Zero, the default, restores one
+// goroutine per handler invocation. Must be called before the socket is
+// opened.
+func (conn *Conn) SetDispatchShards(n int) {
+	if n < 0 {
+		n = 0
+	}
+	conn.dispatchShards = n
+}
+
+// Start the shard goroutines of the current socket, if any.
+func (conn *Conn) spawnShards() {
+	if conn.dispatchShards == 0 {
+		conn.shards = nil
+		return
+	}
+	conn.shards = make([]chan shardJob, conn.dispatchShards)
+	conn.running.Add(len(conn.shards))
+	for i := range conn.shards {
+		conn.shards[i] = make(chan shardJob, shardQueueDepth)
+		jobs, done := conn.shards[i], conn.done
+		conn.spawnRole("shard", func() { conn.runShard(jobs, done) })
+	}
+}
+
+// Run the queued jobs in order until the socket shuts down.
+func (conn *Conn) runShard(jobs chan shardJob, done chan bool) {
+	defer conn.running.Done()
+	for {
+		select {
+		case job := <-jobs:
+			for _, h := range job.handlers {
+				conn.runHandler(h, job.p, job.slots, job.threshold, job.interval)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Queue a job on the shard of its source address; the handler slots
+// reserved for it are released if the socket shuts down first.
+func (conn *Conn) dispatchShard(shards []chan shardJob, job shardJob) {
+	key := 0
+	if job.p.Addr != nil {
+		key = int(peerHash(peerKey(job.p.Addr)) % uint(len(shards)))
+	}
+	select {
+	case shards[key] <- job:
+	case <-conn.done:
+		for range job.handlers {
+			conn.releaseSlot(job.slots)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchShards(t *testing.T) {
+	const peers, sequence = 6, 50
+	conn, err := NewConnFromConfig(&Config{DispatchShards: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go monitor(conn.Err, t)
+
+	var mutex sync.Mutex
+	last := make(map[string]uint32)
+	var handled, running, overlap int
+	var disorder []string
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		mutex.Lock()
+		running++
+		if running > overlap {
+			overlap = running
+		}
+		mutex.Unlock()
+
+		// long enough for packets of other peers to be handled meanwhile
+		time.Sleep(time.Millisecond)
+
+		n := binary.BigEndian.Uint32(p.Msg)
+		mutex.Lock()
+		defer mutex.Unlock()
+		running--
+		handled++
+		if prev, ok := last[p.Addr.String()]; ok && n != prev+1 {
+			disorder = append(disorder, p.Addr.String())
+		}
+		last[p.Addr.String()] = n
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+	port := (<-conn.Events()).(*OpenEvent).LocalAddr.(*net.UDPAddr).Port
+
+	socks := make([]*net.UDPConn, peers)
+	for i := range socks {
+		if socks[i], err = net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+			t.Fatal(err)
+		}
+		defer socks[i].Close()
+	}
+
+	// interleave the numbered sequences of all peers
+	msg := make([]byte, 4)
+	for n := uint32(0); n < sequence; n++ {
+		for _, sock := range socks {
+			binary.BigEndian.PutUint32(msg, n)
+			sock.Write(msg)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	for i := 0; ; i++ {
+		mutex.Lock()
+		done := handled == peers*sequence
+		mutex.Unlock()
+		if done {
+			break
+		}
+		if i == 200 {
+			t.Fatalf("TestDispatchShards expected %d packets to be handled got %d.", peers*sequence, handled)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(disorder) > 0 {
+		t.Fatalf("TestDispatchShards expected every peer to be handled in order, out of order: %v", disorder)
+	}
+	if overlap < 2 {
+		t.Fatalf("TestDispatchShards expected peers to be handled concurrently, at most %d were.", overlap)
+	}
+	if overlap > 8 {
+		t.Fatalf("TestDispatchShards expected at most one handler per shard got %d.", overlap)
+	}
+}
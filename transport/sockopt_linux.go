@@ -0,0 +1,188 @@
+//go:build linux
+
+package transport
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Capacity needed for the ancillary data of a received datagram
+var controlSpace = syscall.CmsgSpace(syscall.SizeofInet4Pktinfo) +
+	syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{})))
+
+// Ask the kernel to report the local address and interface of every datagram.
+func enablePacketInfo(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+}
+
+// Ask the kernel to report the time at which every datagram was received.
+func enableKernelTimestamps(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+}
+
+// Queue ICMP errors caused by our datagrams so that they can be read back
+// with their destination, even on unconnected sockets.
+func enableICMPErrors(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+}
+
+// Set the DF bit on every datagram and never fragment locally, without
+// consulting the kernel's own path MTU estimate, so that probes larger
+// than the path are dropped rather than fragmented.
+func enableDontFragment(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+}
+
+// Let another socket bind the port of this one as long as it sets the
+// option too; UDP sockets check it whenever a new one binds.
+func enableReuseAddr(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+// Control function of a net.Dialer which sets SO_REUSEADDR before the
+// socket is bound.
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// struct sock_extended_err from linux/errqueue.h
+type sockExtendedErr struct {
+	Errno  uint32
+	Origin uint8
+	Type   uint8
+	Code   uint8
+	Pad    uint8
+	Info   uint32
+	Data   uint32
+}
+
+const soEEOriginICMP = 2
+
+// Drain the socket's error queue. Every entry carries the destination and
+// payload of the datagram which triggered the ICMP error.
+func readErrorQueue(sock *net.UDPConn) []*UnreachableEvent {
+	raw, err := sock.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var events []*UnreachableEvent
+	buff := make([]byte, MessageSize)
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(sockExtendedErr{}))+syscall.SizeofSockaddrInet4))
+	raw.Read(func(fd uintptr) bool {
+		for {
+			n, oobn, _, from, err := syscall.Recvmsg(int(fd), buff, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				return true
+			}
+			if e := parseErrorQueue(buff[:n], oob[:oobn], from); e != nil {
+				events = append(events, e)
+			}
+		}
+	})
+	return events
+}
+
+func parseErrorQueue(msg, oob []byte, from syscall.Sockaddr) *UnreachableEvent {
+	sa, ok := from.(*syscall.SockaddrInet4)
+	if !ok {
+		return nil
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if m.Header.Level != syscall.IPPROTO_IP || m.Header.Type != syscall.IP_RECVERR ||
+			len(m.Data) < int(unsafe.Sizeof(sockExtendedErr{})) {
+			continue
+		}
+		ee := (*sockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		if ee.Origin != soEEOriginICMP {
+			continue
+		}
+		e := &UnreachableEvent{
+			Addr: &net.UDPAddr{IP: net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]), Port: sa.Port},
+			Msg:  copyMessage(msg),
+			Err:  syscall.Errno(ee.Errno),
+			Type: ee.Type,
+			Code: ee.Code,
+		}
+		if e.Err == syscall.EMSGSIZE {
+			// fragmentation needed: the info is the next-hop MTU
+			e.MTU = int(ee.Info)
+		}
+		return e
+	}
+	return nil
+}
+
+// Keep our own multicast datagrams from being delivered back to us.
+func disableMulticastLoop(sock *net.UDPConn) error {
+	return setsockopt(sock, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 0)
+}
+
+func setsockopt(sock *net.UDPConn, level, name, value int) error {
+	raw, err := sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, name, value)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// Fill in the fields of p which are carried by the ancillary data of the
+// datagram. Malformed control messages are ignored.
+func parseControl(oob []byte, local *net.UDPAddr, p *Packet) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO &&
+			len(m.Data) >= syscall.SizeofInet4Pktinfo:
+			info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&m.Data[0]))
+			ip := net.IPv4(info.Spec_dst[0], info.Spec_dst[1], info.Spec_dst[2], info.Spec_dst[3])
+			p.Dst = &net.UDPAddr{IP: ip, Port: local.Port}
+			p.IfIndex = int(info.Ifindex)
+
+		case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS &&
+			len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})):
+			ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			p.Received = time.Unix(ts.Unix())
+			p.KernelTimestamp = true
+		}
+	}
+}
+
+// Ancillary data which makes the kernel send a datagram from the specified
+// local address and interface.
+func sourceControl(src net.IP, ifIndex int) []byte {
+	b := make([]byte, syscall.CmsgSpace(syscall.SizeofInet4Pktinfo))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = syscall.IPPROTO_IP
+	h.Type = syscall.IP_PKTINFO
+	h.SetLen(syscall.CmsgLen(syscall.SizeofInet4Pktinfo))
+
+	info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&b[syscall.CmsgLen(0)]))
+	info.Ifindex = int32(ifIndex)
+	copy(info.Spec_dst[:], src.To4())
+	return b
+}
@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPacketInfo(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		packets := make(chan *Packet, 1)
+		server := NewConn()
+		go monitor(server.Err, t)
+		server.SetPacketInfo(enabled)
+		server.AddHandler(func(conn *Conn, p *Packet) {
+			conn.Reply(p, []byte(expectedReply))
+			packets <- p
+		})
+		if err := server.Listen(0); err != nil {
+			t.Fatalf("TestPacketInfo cannot listen: %s", err)
+		}
+
+		port := portOf(server)
+		reply = make(chan Message, 1)
+		client := startClient(t, port)
+
+		var p *Packet
+		select {
+		case p = <-packets:
+		case <-time.After(time.Second):
+			t.Fatalf("TestPacketInfo no packet received")
+		}
+		select {
+		case msg := <-reply:
+			if string(msg) != expectedReply {
+				t.Fatalf("TestPacketInfo expected reply %q got %q.", expectedReply, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestPacketInfo no reply received")
+		}
+		client.Disconnect()
+		server.Disconnect()
+
+		switch {
+		case !enabled && p.Dst != nil:
+			t.Fatalf("TestPacketInfo expected no local end-point got %s.", p.Dst)
+		case enabled && (p.Dst == nil || !p.Dst.IP.IsLoopback() || p.Dst.Port != int(port)):
+			t.Fatalf("TestPacketInfo expected loopback end-point on port %d got %v.", port, p.Dst)
+		case enabled && p.IfIndex == 0:
+			t.Fatalf("TestPacketInfo expected interface index")
+		}
+	}
+}
+
+func TestKernelTimestamps(t *testing.T) {
+	packets := make(chan *Packet, 1)
+	server := NewConn()
+	go monitor(server.Err, t)
+	server.SetKernelTimestamps(true)
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		packets <- p
+	})
+	if err := server.Listen(0); err != nil {
+		t.Fatalf("TestKernelTimestamps cannot listen: %s", err)
+	}
+	defer server.Disconnect()
+
+	client := startClient(t, portOf(server))
+	defer client.Disconnect()
+
+	select {
+	case p := <-packets:
+		if !p.KernelTimestamp {
+			t.Fatalf("TestKernelTimestamps expected a kernel timestamp")
+		}
+		if d := time.Since(p.Received); d < 0 || d > time.Second {
+			t.Fatalf("TestKernelTimestamps timestamp %s is %s away from now", p.Received, d)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestKernelTimestamps no packet received")
+	}
+}
+
+func TestICMPErrors(t *testing.T) {
+	// find a local port which nobody listens on
+	closed, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := closed.LocalAddr().(*net.UDPAddr)
+	closed.Close()
+
+	conn := NewConn()
+	conn.SetICMPErrors(true)
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestICMPErrors cannot listen: %s", err)
+	}
+	defer conn.Disconnect()
+	<-conn.Events()
+	<-conn.Events()
+
+	start := time.Now()
+	if err := conn.SendTo([]byte(expectedRequest), dst); err != nil {
+		t.Fatalf("TestICMPErrors cannot send: %s", err)
+	}
+
+	const probeTimeout = time.Second
+	select {
+	case e := <-conn.Events():
+		u, ok := e.(*UnreachableEvent)
+		if !ok {
+			t.Fatalf("TestICMPErrors expected unreachable event got %s.", e)
+		}
+		if u.Addr.Port != dst.Port || u.Err != syscall.ECONNREFUSED || string(u.Msg) != expectedRequest {
+			t.Fatalf("TestICMPErrors unexpected event %s for %q", u, u.Msg)
+		}
+	case <-time.After(probeTimeout):
+		t.Fatalf("TestICMPErrors no unreachable event within %s", probeTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > probeTimeout/2 {
+		t.Fatalf("TestICMPErrors took %s to observe the error", elapsed)
+	}
+
+	err = <-conn.Err
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Packet.Addr.Port != dst.Port {
+		t.Fatalf("TestICMPErrors expected send error for %s got %v.", dst, err)
+	}
+	if !conn.IsConnected() {
+		t.Fatalf("TestICMPErrors expected the connection to survive")
+	}
+	for _, peer := range conn.Peers() {
+		if peer.Addr.Port == dst.Port && peer.Errors != 1 {
+			t.Fatalf("TestICMPErrors expected one error for %s got %d.", dst, peer.Errors)
+		}
+	}
+}
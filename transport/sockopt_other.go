@@ -0,0 +1,54 @@
+//go:build !linux
+
+package transport
+
+import (
+	"net"
+	"syscall"
+)
+
+var controlSpace = 0
+
+func enablePacketInfo(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+func enableKernelTimestamps(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+// ICMP errors are not reported on this platform.
+func enableICMPErrors(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+// Fragmentation cannot be forbidden on this platform.
+func enableDontFragment(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+// Ports are not shared this way on this platform: SO_REUSEADDR lets any
+// socket take over a port on Windows and needs SO_REUSEPORT elsewhere.
+func enableReuseAddr(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	return ErrNotSupported
+}
+
+func readErrorQueue(sock *net.UDPConn) []*UnreachableEvent {
+	return nil
+}
+
+func disableMulticastLoop(sock *net.UDPConn) error {
+	return ErrNotSupported
+}
+
+func parseControl(oob []byte, local *net.UDPAddr, p *Packet) {
+}
+
+// The source address cannot be chosen; the kernel picks it.
+func sourceControl(src net.IP, ifIndex int) []byte {
+	return nil
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Time allowed for the SOCKS5 handshake unless SOCKS5.Timeout is set
+const DefaultProxyTimeout = 5 * time.Second
+
+// Reserved bytes, fragment number, address type, IPv4 address and port
+// preceding every datagram relayed by a SOCKS5 proxy (RFC 1928)
+const socksHeaderSize = 10
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUDPAssociate = 3
+	socksIPv4         = 1
+	socksIPv6         = 4
+)
+
+var (
+	ErrProxyAuth      = errors.New("Proxy requires an unsupported authentication method")
+	ErrProxyRefused   = errors.New("Proxy refused the UDP association")
+	ErrProxyMalformed = errors.New("Malformed reply from proxy")
+	ErrProxyFragment  = errors.New("Proxy relayed a fragmented datagram")
+)
+
+// Dialer which relays datagrams through a SOCKS5 proxy with UDP ASSOCIATE
+// (RFC 1928). Only proxies which accept clients without authentication
+// are supported. The TCP control connection stays open as long as the
+// socket; if the proxy closes it, the connection dials again.
+type SOCKS5 struct {
+	// Address of the proxy's TCP control port
+	Proxy string
+
+	// Limit of the handshake, DefaultProxyTimeout if zero
+	Timeout time.Duration
+}
+
+func (s *SOCKS5) DialUDP(raddr *net.UDPAddr) (*Tunnel, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultProxyTimeout
+	}
+	control, err := net.DialTimeout("tcp4", s.Proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := socksAssociate(control, timeout)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() {
+		// the relay listens on the proxy's own address
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP
+	}
+	sock, err := net.DialUDP("udp4", nil, relay)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	// the proxy closes the association with the control connection
+	broken := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, control)
+		if err == nil {
+			err = io.EOF
+		}
+		broken <- err
+		close(broken)
+	}()
+
+	return &Tunnel{
+		Sock:     sock,
+		Overhead: socksHeaderSize,
+		Wrap:     socksWrap,
+		Unwrap:   socksUnwrap,
+		Broken:   broken,
+		Close:    control.Close,
+	}, nil
+}
+
+// Negotiate the method and request an association; returns the relay.
+func socksAssociate(control net.Conn, timeout time.Duration) (*net.UDPAddr, error) {
+	control.SetDeadline(time.Now().Add(timeout))
+	defer control.SetDeadline(time.Time{})
+
+	if _, err := control.Write([]byte{socksVersion, 1, socksNoAuth}); err != nil {
+		return nil, err
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(control, method); err != nil {
+		return nil, err
+	}
+	if method[0] != socksVersion {
+		return nil, ErrProxyMalformed
+	}
+	if method[1] != socksNoAuth {
+		return nil, ErrProxyAuth
+	}
+
+	// the client address is unknown before the socket is opened
+	request := []byte{socksVersion, socksUDPAssociate, 0, socksIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := control.Write(request); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(control, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != socksVersion {
+		return nil, ErrProxyMalformed
+	}
+	if reply[1] != 0 {
+		return nil, fmt.Errorf("%w (reply %d)", ErrProxyRefused, reply[1])
+	}
+	if reply[3] != socksIPv4 {
+		return nil, ErrProxyMalformed
+	}
+	bound := make([]byte, 6)
+	if _, err := io.ReadFull(control, bound); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: net.IP(bound[:4]), Port: int(binary.BigEndian.Uint16(bound[4:]))}, nil
+}
+
+func socksWrap(msg Message, dst *net.UDPAddr) Message {
+	b := make(Message, socksHeaderSize, socksHeaderSize+len(msg))
+	b[3] = socksIPv4
+	copy(b[4:8], dst.IP.To4())
+	binary.BigEndian.PutUint16(b[8:], uint16(dst.Port))
+	return append(b, msg...)
+}
+
+func socksUnwrap(b []byte) ([]byte, *net.UDPAddr, error) {
+	r := wire.NewReader("socks5", b)
+	r.Next(2)
+	if r.Uint8() != 0 {
+		r.Fail(ErrProxyFragment)
+	}
+	var ip []byte
+	switch r.Uint8() {
+	case socksIPv4:
+		ip = r.Next(4)
+	case socksIPv6:
+		ip = r.Next(16)
+	default:
+		r.Fail(ErrProxyMalformed)
+	}
+	port := r.Uint16()
+	if r.Err() != nil {
+		return nil, nil, r.Err()
+	}
+	return r.Rest(), &net.UDPAddr{IP: net.IP(append([]byte(nil), ip...)), Port: int(port)}, nil
+}
@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Minimal SOCKS5 proxy which supports UDP ASSOCIATE without
+// authentication, one association per control connection.
+type socksProxy struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	controls []net.Conn
+	relayed  int
+}
+
+func startSocksProxy(t *testing.T) *socksProxy {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &socksProxy{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			control, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(t, control)
+		}
+	}()
+	return proxy
+}
+
+func (proxy *socksProxy) serve(t *testing.T, control net.Conn) {
+	defer control.Close()
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(control, greeting); err != nil {
+		return
+	}
+	control.Write([]byte{socksVersion, socksNoAuth})
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(control, request); err != nil || request[1] != socksUDPAssociate {
+		return
+	}
+
+	relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer relay.Close()
+	bound := relay.LocalAddr().(*net.UDPAddr)
+	reply := []byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0}
+	// announce the unspecified address like many proxies do
+	reply[8], reply[9] = byte(bound.Port>>8), byte(bound.Port)
+	control.Write(reply)
+
+	proxy.mutex.Lock()
+	proxy.controls = append(proxy.controls, control)
+	proxy.mutex.Unlock()
+
+	go func() {
+		var client *net.UDPAddr
+		buff := make([]byte, 2048)
+		for {
+			n, from, err := relay.ReadFromUDP(buff)
+			if err != nil {
+				return
+			}
+			if client == nil || (from.IP.Equal(client.IP) && from.Port == client.Port) {
+				// from the client: strip the header and pass it on
+				client = from
+				payload, dst, err := socksUnwrap(buff[:n])
+				if err != nil {
+					continue
+				}
+				relay.WriteToUDP(payload, dst)
+				proxy.mutex.Lock()
+				proxy.relayed++
+				proxy.mutex.Unlock()
+				continue
+			}
+			relay.WriteToUDP(socksWrap(Message(buff[:n]), from), client)
+		}
+	}()
+
+	// the association ends with the control connection
+	io.Copy(io.Discard, control)
+}
+
+// Close the control connections as if the proxy restarted.
+func (proxy *socksProxy) drop() {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	for _, c := range proxy.controls {
+		c.Close()
+	}
+	proxy.controls = nil
+}
+
+func (proxy *socksProxy) associations() int {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	return len(proxy.controls)
+}
+
+func TestSocksFraming(t *testing.T) {
+	dst := &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 7946}
+	b := socksWrap(Message("ping"), dst)
+	if len(b) != socksHeaderSize+4 {
+		t.Fatalf("TestSocksFraming expected %d bytes got %d.", socksHeaderSize+4, len(b))
+	}
+	payload, from, err := socksUnwrap(b)
+	if err != nil || string(payload) != "ping" || from.String() != dst.String() {
+		t.Fatalf("TestSocksFraming expected ping from %s got %q from %s (%v).", dst, payload, from, err)
+	}
+
+	fragment := append([]byte(nil), b...)
+	fragment[2] = 1
+	for _, test := range []struct {
+		b   []byte
+		err error
+	}{
+		{b[:3], ErrTruncated},
+		{b[:8], ErrTruncated},
+		{fragment, ErrProxyFragment},
+		{[]byte{0, 0, 0, 9, 0, 0}, ErrProxyMalformed},
+	} {
+		if _, _, err := socksUnwrap(test.b); !errors.Is(err, test.err) {
+			t.Errorf("TestSocksFraming expected %q for %v got %v.", test.err, test.b, err)
+		}
+	}
+}
+
+func TestSocksDial(t *testing.T) {
+	proxy := startSocksProxy(t)
+	server := startServer(t, 0)
+	defer server.Disconnect()
+
+	conn := NewConn()
+	conn.SetDialer(&SOCKS5{Proxy: proxy.listener.Addr().String()})
+	replies := make(chan *Packet, 4)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		replies <- p
+	})
+	if err := conn.Dial(loopbackOf(server), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Disconnect()
+
+	if n := conn.MaxPayload(); n != MessageSize-socksHeaderSize {
+		t.Fatalf("TestSocksDial expected a payload limit of %d got %d.", MessageSize-socksHeaderSize, n)
+	}
+	if err := conn.Send(Message(expectedRequest)); err != nil {
+		t.Fatal(err)
+	}
+	p := <-replies
+	if !bytes.Equal(p.Msg, []byte(expectedReply)) || p.Addr.Port != server.LocalAddr().Port {
+		t.Fatalf("TestSocksDial expected %q from port %d got %q from %s.", expectedReply, portOf(server), p.Msg, p.Addr)
+	}
+
+	// the proxy goes away; the connection reports it and dials again
+	proxy.drop()
+	select {
+	case err := <-conn.Err:
+		var tunnelErr *TunnelError
+		if !errors.As(err, &tunnelErr) || !tunnelErr.Temporary() {
+			t.Fatalf("TestSocksDial expected a *TunnelError got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestSocksDial expected the broken tunnel to be reported.")
+	}
+	for i := 0; proxy.associations() == 0 || conn.State() != Dialed; i++ {
+		if i == 1000 {
+			t.Fatalf("TestSocksDial expected the connection to dial the proxy again.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := conn.Send(Message(expectedRequest)); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-replies; !bytes.Equal(p.Msg, []byte(expectedReply)) {
+		t.Fatalf("TestSocksDial expected %q after redialing got %q.", expectedReply, p.Msg)
+	}
+}
+
+func TestSocksRefused(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		control, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer control.Close()
+		io.ReadFull(control, make([]byte, 3))
+		// username and password only
+		control.Write([]byte{socksVersion, 2})
+	}()
+
+	conn := NewConn()
+	conn.SetDialer(&SOCKS5{Proxy: listener.Addr().String()})
+	err = conn.Dial("127.0.0.1:9933", time.Time{})
+	var tunnelErr *TunnelError
+	if !errors.As(err, &tunnelErr) || !errors.Is(err, ErrProxyAuth) {
+		t.Fatalf("TestSocksRefused expected %q got %v.", ErrProxyAuth, err)
+	}
+	if conn.State() == Dialed {
+		t.Fatalf("TestSocksRefused expected the connection not to be dialed.")
+	}
+}
@@ -0,0 +1,37 @@
+package transport
+
+// Lifecycle of a Conn. Transitions are Idle -> Listening or Dialed ->
+// Closing -> Closed, and from Closed back to Listening or Dialed when
+// the connection is reused.
+type State int
+
+const (
+	// Socket has not been opened yet
+	Idle State = iota
+
+	// Socket is bound to a local port by Listen
+	Listening
+
+	// Socket is connected to a remote end-point by Dial
+	Dialed
+
+	// Shutdown has been initiated by Disconnect or a fatal socket error
+	Closing
+
+	// Disconnect has released all resources
+	Closed
+)
+
+var stateNames = []string{"Idle", "Listening", "Dialed", "Closing", "Closed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "Unknown"
+	}
+	return stateNames[s]
+}
+
+// Determine if packets can be sent and received in this state.
+func (s State) isOpen() bool {
+	return s == Listening || s == Dialed
+}
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"sync"
+)
+
+// Snapshot of the counters maintained by a Conn.
+type Stats struct {
+	// Handler goroutines which are currently running
+	HandlersRunning int
+
+	// Largest value HandlersRunning has reached
+	HandlersHighWater int
+
+	// Packets discarded because the handler limit was reached
+	DroppedSaturated uint64
+
+	// Packets waiting in the dispatch queue and the largest number seen,
+	// and packets discarded because it was full; see SetDispatchQueue
+	QueueDepth       int
+	QueueHighWater   int
+	DroppedQueueFull uint64
+
+	// Datagrams which were larger than MessageSize
+	Truncated uint64
+
+	// Peers tracked and packets not yet written, and the packets from new
+	// peers dropped at the cap; see SetLimits
+	Peers            int
+	Unsent           int
+	DroppedPeerLimit uint64
+
+	// Messages not sent because the peer was handed them within the
+	// horizon of SetOutboundDedup
+	DedupSuppressed uint64
+
+	// Packets kept across a redial which were too old for the new socket;
+	// see SetQueueRetention
+	RetainedExpired uint64
+
+	// Diagnostic echoes answered and those dropped above the rate of
+	// the responder; see SetEchoResponder
+	EchoesAnswered uint64
+	EchoesLimited  uint64
+
+	// Events discarded because nobody drained Conn.Events
+	EventsDropped uint64
+
+	// Own broadcasts which came back, and broadcasts from others above the
+	// inbound rate cap; see SetBroadcast
+	BroadcastLoops    uint64
+	BroadcastsLimited uint64
+
+	// Datagrams received and sent per size bucket; see SizeBuckets
+	SizesIn, SizesOut [len(SizeBuckets)]uint64
+
+	// Bytes of the datagrams counted in SizesIn and SizesOut
+	BytesIn, BytesOut uint64
+
+	// Remote end-points which sent the most bytes recently, largest first
+	TopTalkers []Talker
+
+	// Execution metrics of every registered handler in registration order
+	Handlers []HandlerStats
+}
+
+// Counters shared between the goroutines of a Conn.
+type statsCounter struct {
+	mutex sync.Mutex
+	Stats
+}
+
+// Account for n handler goroutines which are about to start.
+func (s *statsCounter) handlersStarted(n int) {
+	s.mutex.Lock()
+	s.HandlersRunning += n
+	if s.HandlersRunning > s.HandlersHighWater {
+		s.HandlersHighWater = s.HandlersRunning
+	}
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) handlerDone() {
+	s.mutex.Lock()
+	s.HandlersRunning--
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) droppedSaturated() {
+	s.mutex.Lock()
+	s.DroppedSaturated++
+	s.mutex.Unlock()
+}
+
+// Account for a queued packet; n is the depth including it.
+func (s *statsCounter) queued(n int) {
+	s.mutex.Lock()
+	if n > s.QueueHighWater {
+		s.QueueHighWater = n
+	}
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) droppedQueueFull() {
+	s.mutex.Lock()
+	s.DroppedQueueFull++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) droppedPeerLimit() {
+	s.mutex.Lock()
+	s.DroppedPeerLimit++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) dedupSuppressed() {
+	s.mutex.Lock()
+	s.DedupSuppressed++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) retainedExpired() {
+	s.mutex.Lock()
+	s.RetainedExpired++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) echoAnswered() {
+	s.mutex.Lock()
+	s.EchoesAnswered++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) echoLimited() {
+	s.mutex.Lock()
+	s.EchoesLimited++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) truncated() {
+	s.mutex.Lock()
+	s.Truncated++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) eventDropped() {
+	s.mutex.Lock()
+	s.EventsDropped++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) broadcastLoop() {
+	s.mutex.Lock()
+	s.BroadcastLoops++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) broadcastLimited() {
+	s.mutex.Lock()
+	s.BroadcastsLimited++
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) sizeIn(n int) {
+	s.mutex.Lock()
+	s.SizesIn[sizeBucket(n)]++
+	s.BytesIn += uint64(n)
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) sizeOut(n int) {
+	s.mutex.Lock()
+	s.SizesOut[sizeBucket(n)]++
+	s.BytesOut += uint64(n)
+	s.mutex.Unlock()
+}
+
+func (s *statsCounter) snapshot() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Stats
+}
+
+// Returns a consistent copy of the connection's counters.
+func (conn *Conn) Stats() Stats {
+	conn.mutex.Lock()
+	in := conn.in
+	conn.mutex.Unlock()
+
+	stats := conn.stats.snapshot()
+	stats.QueueDepth = len(in)
+	stats.Peers, stats.Unsent = int(conn.peers.size.Load()), conn.unsent.Count()
+	stats.TopTalkers = conn.talkers.top(DefaultTopTalkers, conn.clock.Now())
+	stats.Handlers = conn.handlerStats()
+	return stats
+}
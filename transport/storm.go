@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+var (
+	ErrOwnBroadcast    = errors.New("Broadcast originated from this connection")
+	ErrBroadcastRate   = errors.New("Inbound broadcast rate exceeded")
+	ErrBroadcastHeader = errors.New("Broadcast header is malformed")
+)
+
+// Length of the header which SetBroadcast prepends to broadcast and
+// multicast datagrams: two magic bytes and the sender's instance id.
+const broadcastHeaderSize = wire.BroadcastHeaderSize
+
+// Default inbound cap on broadcasts, in datagrams per second and burst
+const (
+	DefaultBroadcastRate  = 100
+	DefaultBroadcastBurst = 200
+)
+
+// Loop and rate protection for broadcast and multicast traffic
+type broadcastGuard struct {
+	conn     *Conn
+	id       uint64
+	suppress bool
+	limit    *tokenBucket
+
+	// Directed broadcast addresses of the local interfaces
+	mutex      sync.Mutex
+	broadcasts []net.IP
+}
+
+// Tag outgoing broadcast and multicast datagrams with a random instance id
+// of this connection, drop incoming ones carrying it before they reach the
+// ingress middleware, and cap the rate of incoming ones at
+// DefaultBroadcastRate; see SetBroadcastOptions. This protects against
+// handlers which re-broadcast what they receive. Every member of the group
+// must enable it since the header is stripped from tagged datagrams only,
+// and MaxPayload shrinks by its size. Multicast loopback is disabled on
+// the socket where possible. Must be called before the socket is opened.
+func (conn *Conn) SetBroadcast(enabled bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if !enabled || conn.broadcast != nil {
+		return
+	}
+
+	var id [8]byte
+	rand.Read(id[:])
+	g := &broadcastGuard{
+		conn:     conn,
+		id:       binary.BigEndian.Uint64(id[:]),
+		suppress: true,
+		limit:    newTokenBucket(DefaultBroadcastRate, DefaultBroadcastBurst),
+	}
+	conn.broadcast = g
+	conn.setStages(insertStage(conn.stages, 0, stage{"broadcast", StageAdmit, g.ingress}))
+	conn.egress = appendMiddleware(conn.egress, g.Egress)
+	conn.layers = append(conn.layers[:len(conn.layers):len(conn.layers)], g)
+}
+
+// Tune the protection enabled by SetBroadcast: whether own broadcasts are
+// dropped, and the inbound cap in datagrams per second with the burst it
+// tolerates; a non-positive rate lifts the cap. Must be called after
+// SetBroadcast and before the socket is opened.
+func (conn *Conn) SetBroadcastOptions(suppressLoops bool, rate float64, burst int) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if g := conn.broadcast; g != nil {
+		g.suppress = suppressLoops
+		g.limit = nil
+		if rate > 0 {
+			g.limit = newTokenBucket(rate, burst)
+		}
+	}
+}
+
+// Instance id with which SetBroadcast tags outgoing broadcasts; zero if
+// broadcast protection is disabled.
+func (conn *Conn) InstanceID() uint64 {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.broadcast == nil {
+		return 0
+	}
+	return conn.broadcast.id
+}
+
+// Learn the directed broadcast addresses of the host once the socket is open.
+func (g *broadcastGuard) open(sock *net.UDPConn) {
+	disableMulticastLoop(sock)
+
+	var broadcasts []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil || len(ipnet.Mask) != net.IPv4len {
+			continue
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i := range ip {
+			ip[i] = ipnet.IP.To4()[i] | ^ipnet.Mask[i]
+		}
+		broadcasts = append(broadcasts, ip)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.broadcasts = broadcasts
+}
+
+func (g *broadcastGuard) isBroadcast(addr *net.UDPAddr) bool {
+	if addr == nil {
+		return false
+	}
+	if addr.IP.Equal(net.IPv4bcast) || addr.IP.IsMulticast() {
+		return true
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, ip := range g.broadcasts {
+		if ip.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *broadcastGuard) Overhead() int {
+	return broadcastHeaderSize
+}
+
+// Prepend the header to broadcast and multicast datagrams.
+func (g *broadcastGuard) Egress(p *Packet) (*Packet, error) {
+	if !g.isBroadcast(p.Addr) {
+		return p, nil
+	}
+	msg, err := wire.Broadcast{Sender: g.id, Payload: p.Msg}.Encode(wire.Version(g.conn.EncodeVersion()))
+	if err != nil {
+		return nil, err
+	}
+
+	q := *p
+	q.Msg = msg
+	return &q, nil
+}
+
+// Strip the header from tagged datagrams, dropping our own and those
+// above the rate cap.
+func (g *broadcastGuard) ingress(p *Packet) (*Packet, error) {
+	b, _, err := wire.DecodeBroadcast(p.Msg)
+	switch err {
+	case nil:
+	case wire.ErrKind:
+		return p, nil
+	default:
+		return nil, ErrBroadcastHeader
+	}
+
+	g.conn.mutex.Lock()
+	suppress, limit := g.suppress, g.limit
+	g.conn.mutex.Unlock()
+
+	if suppress && b.Sender == g.id {
+		g.conn.stats.broadcastLoop()
+		return nil, ErrOwnBroadcast
+	}
+	if limit != nil && !limit.take(g.conn.clock.Now()) {
+		g.conn.stats.broadcastLimited()
+		return nil, ErrBroadcastRate
+	}
+
+	q := *p
+	q.Msg = b.Payload
+	return &q, nil
+}
+
+// Token bucket refilled continuously at rate tokens per second
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// The bucket starts out full.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Consume a token if one is available.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.last.IsZero() {
+		b.last = now
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+func TestBroadcastLoopSuppression(t *testing.T) {
+	var received int64
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.SetBroadcast(true)
+
+	// a handler which relays every broadcast would flood the network
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		atomic.AddInt64(&received, 1)
+		conn.SendTo(p.Msg, &net.UDPAddr{IP: net.IPv4bcast, Port: conn.LocalAddr().Port})
+	})
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestBroadcastLoopSuppression cannot listen: %s", err)
+	}
+	defer conn.Disconnect()
+	bcast := &net.UDPAddr{IP: net.IPv4bcast, Port: conn.LocalAddr().Port}
+
+	if err := conn.SendTo([]byte(expectedRequest), bcast); err != nil {
+		t.Fatalf("TestBroadcastLoopSuppression cannot broadcast: %s", err)
+	}
+	for i := 0; conn.Stats().BroadcastLoops == 0; i++ {
+		if i == 100 {
+			t.Fatalf("TestBroadcastLoopSuppression own broadcast did not come back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&received); n != 0 {
+		t.Fatalf("TestBroadcastLoopSuppression expected no dispatch got %d.", n)
+	}
+	if n := conn.Stats().BroadcastLoops; n != 1 {
+		t.Fatalf("TestBroadcastLoopSuppression expected 1 suppressed loop got %d.", n)
+	}
+	if n := conn.MaxPayload(); n != MessageSize-broadcastHeaderSize {
+		t.Fatalf("TestBroadcastLoopSuppression expected payload %d got %d.", MessageSize-broadcastHeaderSize, n)
+	}
+}
+
+func TestBroadcastGuard(t *testing.T) {
+	clock := NewManualClock(epoch)
+	conn := NewConn()
+	conn.SetClock(clock)
+	conn.SetBroadcast(true)
+	conn.SetBroadcastOptions(true, 10, 2)
+	g := conn.broadcast
+	bcast := &net.UDPAddr{IP: net.IPv4bcast, Port: 9936}
+	unicast := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9936}
+
+	if p, _ := g.Egress(&Packet{Addr: unicast, Msg: []byte("x")}); len(p.Msg) != 1 {
+		t.Fatalf("TestBroadcastGuard expected unicast to stay untagged got %d bytes.", len(p.Msg))
+	}
+	own, _ := g.Egress(&Packet{Addr: bcast, Msg: []byte("x")})
+	if _, err := g.ingress(own); err != ErrOwnBroadcast {
+		t.Fatalf("TestBroadcastGuard expected %q got %v.", ErrOwnBroadcast, err)
+	}
+
+	// broadcasts of another instance pass up to the cap
+	other, _ := wire.Broadcast{Sender: g.id + 1, Payload: []byte("y")}.Encode(wire.V1)
+	for i := 0; i < 2; i++ {
+		p, err := g.ingress(&Packet{Addr: unicast, Msg: other})
+		if err != nil || string(p.Msg) != "y" {
+			t.Fatalf("TestBroadcastGuard expected stripped broadcast got %v.", err)
+		}
+	}
+	if _, err := g.ingress(&Packet{Addr: unicast, Msg: other}); err != ErrBroadcastRate {
+		t.Fatalf("TestBroadcastGuard expected %q got %v.", ErrBroadcastRate, err)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if _, err := g.ingress(&Packet{Addr: unicast, Msg: other}); err != nil {
+		t.Fatalf("TestBroadcastGuard expected a refilled token got %v.", err)
+	}
+
+	if _, err := g.ingress(&Packet{Addr: unicast, Msg: other[:4]}); err != ErrBroadcastHeader {
+		t.Fatalf("TestBroadcastGuard expected %q got %v.", ErrBroadcastHeader, err)
+	}
+	if p, err := g.ingress(&Packet{Addr: unicast, Msg: []byte("plain")}); err != nil || string(p.Msg) != "plain" {
+		t.Fatalf("TestBroadcastGuard expected untagged packet to pass got %v.", err)
+	}
+
+	stats := conn.Stats()
+	if stats.BroadcastLoops != 1 || stats.BroadcastsLimited != 1 {
+		t.Fatalf("TestBroadcastGuard unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upper bounds (inclusive) of the datagram size buckets in Stats.SizesIn
+// and Stats.SizesOut
+var SizeBuckets = [...]int{32, 64, 128, 256, 384, MessageSize}
+
+// Bucket of SizeBuckets a datagram of n bytes falls into
+func sizeBucket(n int) int {
+	for i, limit := range SizeBuckets {
+		if n <= limit {
+			return i
+		}
+	}
+	return len(SizeBuckets) - 1
+}
+
+const (
+	// Length of Stats.TopTalkers
+	DefaultTopTalkers = 10
+
+	// Time over which a talker's bytes decay to 1/e
+	DefaultTalkerWindow = time.Minute
+
+	// Remote end-points tracked at most, however many send
+	talkerSlots = 64
+)
+
+// Remote end-point with the bytes received from it, decayed over
+// DefaultTalkerWindow.
+type Talker struct {
+	Addr  *net.UDPAddr
+	Bytes uint64
+}
+
+// Bounded set of exponentially decaying byte counters. When all slots are
+// taken, a new sender replaces the smallest counter and inherits its
+// count (as in the Space-Saving algorithm), so a heavy hitter is never
+// missed although light senders may be overestimated.
+type talkerTable struct {
+	mutex  sync.Mutex
+	window time.Duration
+	slots  map[netip.AddrPort]*talkerSlot
+}
+
+type talkerSlot struct {
+	addr  *net.UDPAddr
+	bytes float64
+	at    time.Time
+}
+
+func newTalkerTable(window time.Duration) *talkerTable {
+	return &talkerTable{window: window, slots: make(map[netip.AddrPort]*talkerSlot)}
+}
+
+func (s *talkerSlot) decay(now time.Time, window time.Duration) float64 {
+	if d := now.Sub(s.at); d > 0 {
+		s.bytes *= math.Exp(-float64(d) / float64(window))
+		s.at = now
+	}
+	return s.bytes
+}
+
+func (t *talkerTable) received(addr *net.UDPAddr, n int, now time.Time) {
+	key := peerKey(addr)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	s, ok := t.slots[key]
+	if !ok {
+		s = &talkerSlot{addr: addr, at: now}
+		if len(t.slots) >= talkerSlots {
+			var minKey netip.AddrPort
+			var min *talkerSlot
+			for k, c := range t.slots {
+				if c.decay(now, t.window); min == nil || c.bytes < min.bytes {
+					minKey, min = k, c
+				}
+			}
+			delete(t.slots, minKey)
+			s.bytes = min.bytes
+		}
+		t.slots[key] = s
+	}
+	s.decay(now, t.window)
+	s.bytes += float64(n)
+}
+
+// The n senders with the most decayed bytes, largest first.
+func (t *talkerTable) top(n int, now time.Time) []Talker {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	talkers := make([]Talker, 0, len(t.slots))
+	for _, s := range t.slots {
+		if b := s.decay(now, t.window); b >= 1 {
+			talkers = append(talkers, Talker{s.addr, uint64(b)})
+		}
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Bytes != talkers[j].Bytes {
+			return talkers[i].Bytes > talkers[j].Bytes
+		}
+		return talkers[i].Addr.String() < talkers[j].Addr.String()
+	})
+	if len(talkers) > n {
+		talkers = talkers[:n]
+	}
+	return talkers
+}
+
+// Current Stats published on request; see Conn.EmitStats.
+type StatsEvent struct {
+	Stats Stats
+}
+
+func (e *StatsEvent) String() string {
+	return fmt.Sprintf("stats: %d top talkers", len(e.Stats.TopTalkers))
+}
+
+// Publish a snapshot of Stats on the Events channel.
+func (conn *Conn) EmitStats() {
+	conn.emit(&StatsEvent{conn.Stats()})
+}
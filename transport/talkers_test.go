@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSizeBucket(t *testing.T) {
+	tests := []struct{ size, bucket int }{
+		{0, 0}, {32, 0}, {33, 1}, {100, 2}, {256, 3}, {300, 4}, {MessageSize, 5}, {MessageSize + 1, 5},
+	}
+	for _, test := range tests {
+		if b := sizeBucket(test.size); b != test.bucket {
+			t.Errorf("TestSizeBucket expected bucket %d for %d bytes got %d.", test.bucket, test.size, b)
+		}
+	}
+}
+
+func TestTalkerTable(t *testing.T) {
+	table := newTalkerTable(time.Minute)
+	addr := func(i int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 7946}
+	}
+
+	// a heavy hitter among more light senders than there are slots
+	for i := 0; i < 4*talkerSlots; i++ {
+		table.received(addr(0), 500, epoch)
+		table.received(addr(i+1), 10, epoch)
+	}
+	if len(table.slots) > talkerSlots {
+		t.Fatalf("TestTalkerTable expected at most %d slots got %d.", talkerSlots, len(table.slots))
+	}
+	top := table.top(3, epoch)
+	if len(top) != 3 || top[0].Addr.String() != addr(0).String() || top[0].Bytes < 4*talkerSlots*500 {
+		t.Fatalf("TestTalkerTable expected %s on top got %v.", addr(0), top)
+	}
+
+	// a minute later the counters have decayed to 1/e
+	decayed := table.top(1, epoch.Add(time.Minute))
+	if expected := top[0].Bytes * 368 / 1000; decayed[0].Bytes < expected-top[0].Bytes/100 || decayed[0].Bytes > expected+top[0].Bytes/100 {
+		t.Fatalf("TestTalkerTable expected about %d bytes after the window got %d.", expected, decayed[0].Bytes)
+	}
+}
+
+func TestTrafficMix(t *testing.T) {
+	server := startPeer(t, 0)
+	defer server.Disconnect()
+	received := make(chan bool, 64)
+	server.AddHandler(func(conn *Conn, p *Packet) {
+		received <- true
+	})
+
+	// three clients sending 1, 2 and 3 packets of 40, 200 and 500 bytes
+	sizes := []int{40, 200, 500}
+	for i, size := range sizes {
+		client := NewConn()
+		go monitor(client.Err, t)
+		if err := client.Dial(loopbackOf(server), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Disconnect()
+		for n := 0; n <= i; n++ {
+			if err := client.Send(make(Message, size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 6; i++ {
+		<-received
+	}
+
+	stats := server.Stats()
+	expected := [len(SizeBuckets)]uint64{0, 1, 0, 2, 0, 3}
+	if stats.SizesIn != expected {
+		t.Fatalf("TestTrafficMix expected sizes %v got %v.", expected, stats.SizesIn)
+	}
+	if len(stats.TopTalkers) != 3 || stats.TopTalkers[0].Bytes < 1490 || stats.TopTalkers[0].Bytes > 1500 {
+		t.Fatalf("TestTrafficMix expected the 500 byte sender on top got %v.", stats.TopTalkers)
+	}
+
+	server.EmitStats()
+	for e := range server.Events() {
+		if e, ok := e.(*StatsEvent); ok {
+			if e.Stats.SizesIn != expected {
+				t.Fatalf("TestTrafficMix expected sizes %v in the event got %v.", expected, e.Stats.SizesIn)
+			}
+			if s := e.String(); s != fmt.Sprintf("stats: %d top talkers", 3) {
+				t.Fatalf("TestTrafficMix expected %q got %q.", "stats: 3 top talkers", s)
+			}
+			break
+		}
+	}
+}
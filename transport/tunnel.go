@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Opens the socket of a dialed connection along a path other than a
+// direct one, e.g. through a proxy; see SetDialer.
+type Dialer interface {
+	DialUDP(raddr *net.UDPAddr) (*Tunnel, error)
+}
+
+// Socket opened by a Dialer together with the framing it requires. Every
+// outgoing message passes through Wrap with its destination, and every
+// incoming datagram through Unwrap, which returns the payload and its
+// original sender. A failure of the path is delivered on Broken, after
+// which the connection dials again. Close releases whatever the Dialer
+// holds besides Sock, which the connection closes itself.
+type Tunnel struct {
+	Sock     *net.UDPConn
+	Overhead int
+	Wrap     func(msg Message, dst *net.UDPAddr) Message
+	Unwrap   func(b []byte) ([]byte, *net.UDPAddr, error)
+	Broken   <-chan error
+	Close    func() error
+
+	// Remote end-point given to Dial
+	remote *net.UDPAddr
+}
+
+// Failure of the path opened by a Dialer. It is transient: the connection
+// dials the remote end-point again, keeping its handlers and Err channel.
+type TunnelError struct {
+	Err error
+}
+
+func (e *TunnelError) Error() string {
+	return fmt.Sprintf("conn.tunnel(): %s", e.Err)
+}
+
+func (e *TunnelError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TunnelError) Temporary() bool {
+	return true
+}
+
+// Open the sockets of Dial and DialHost through d instead of directly;
+// nil restores direct sockets. Must be called before the socket is opened.
+func (conn *Conn) SetDialer(d Dialer) {
+	conn.dialer = d
+}
+
+// Open a socket connected to raddr, through the dialer if there is one.
+// Assumes the caller holds the mutex.
+func (conn *Conn) dialUDP(network string, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	conn.tunnel = nil
+	if conn.dialer == nil {
+		return conn.dialDirect(network, raddr)
+	}
+	tunnel, err := conn.dialer.DialUDP(raddr)
+	if err != nil {
+		return nil, &TunnelError{err}
+	}
+	tunnel.remote = raddr
+	conn.tunnel = tunnel
+	return tunnel.Sock, nil
+}
+
+// Wait for the tunnel of the current socket to break and dial again.
+func (conn *Conn) watchTunnel(tunnel *Tunnel, host *hostTarget, done chan bool) {
+	select {
+	case err, ok := <-tunnel.Broken:
+		select {
+		case <-done:
+			// closing the tunnel on shutdown is not an error
+			return
+		default:
+		}
+		if !ok {
+			err = net.ErrClosed
+		}
+		conn.report(&TunnelError{err})
+	case <-done:
+		return
+	}
+
+	if !conn.reset() {
+		return
+	}
+	conn.reconnect(func() error {
+		if host != nil {
+			return conn.dialHost(host.host, host.port)
+		}
+		return conn.Dial(tunnel.remote.String(), time.Time{})
+	})
+}
@@ -0,0 +1,1438 @@
+// Connectionless transport library
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Payload carried by UDP
+type Message []byte
+
+// See RFC 1035 Section 4.2.1
+const MessageSize = 512
+
+// Message with a source or destination address of the form host:port.
+type Packet struct {
+	Addr *net.UDPAddr
+	Msg  Message
+
+	// Local end-point an incoming packet was addressed to and the index of
+	// the interface it arrived on; only set if SetPacketInfo is enabled.
+	Dst     *net.UDPAddr
+	IfIndex int
+
+	// When an incoming packet was received: taken by the kernel if
+	// KernelTimestamp is set (see SetKernelTimestamps), otherwise by the
+	// receiving loop right after the read returned.
+	Received        time.Time
+	KernelTimestamp bool
+}
+
+// Closure interface to handle incoming packets
+type EventHandler func(*Conn, *Packet)
+
+// Decides what happens to an incoming packet when the handler limit or the
+// dispatch queue is exhausted.
+type SaturationPolicy int
+
+const (
+	// Hold the packet in the dispatch queue until enough handlers finished
+	WaitWhenSaturated SaturationPolicy = iota
+
+	// Discard the packet and count it in Stats.DroppedSaturated or
+	// Stats.DroppedQueueFull respectively
+	DropWhenSaturated
+)
+
+var ErrQueueFull = errors.New("Dispatch queue is full")
+
+// Once connected, any errors encountered are piped
+// down Conn.Err; this channel is closed on disconnect.
+type Conn struct {
+	// Error channel to transmit any failure back to the caller
+	Err chan error
+
+	// Handle incoming packets read from the socket, the number of
+	// handlers registered since the last reset and the number of resets
+	handlers   []*registeredHandler
+	registered int
+	resets     uint64
+
+	// Dispatcher goroutines by source address; see SetDispatchShards
+	dispatchShards int
+
+	// See AddRawHandler
+	rawHandlers []RawHandler
+
+	// Adapters receiving incoming packets besides the handlers
+	adapters []*PacketConn
+
+	// Consumers of copies of the inbound packets; see Mirror
+	mirrors []*Mirror
+
+	// Threshold and rate limit of SlowHandlerEvents; see SetSlowHandler
+	slowHandler, slowInterval time.Duration
+
+	// Publish HandlerErrorEvents; see SetHandlerErrorEvents
+	handlerErrors bool
+
+	// Transform packets between the socket and the handlers or senders;
+	// ingress is the chain of the named stages, see UseStage
+	ingress, egress []Middleware
+	stages          []stage
+	unnamed         int
+
+	// Egress middleware with declared overhead and the datagram size
+	// from which it is subtracted to obtain MaxPayload
+	layers       []Layer
+	datagramSize int
+
+	// Loop and rate protection of SetBroadcast, nil if disabled
+	broadcast *broadcastGuard
+
+	// Report the local end-point and kernel timestamp of incoming packets
+	packetInfo bool
+	kernelTime bool
+
+	// Read ICMP errors back from the socket's error queue
+	icmpErrors bool
+
+	// Path MTU discovery and its probe timeout and re-probe period; the
+	// fallback is set if the socket cannot forbid fragmentation
+	pathMTU                  bool
+	pathFallback             bool
+	pathTimeout, pathReprobe time.Duration
+	paths                    *pathProber
+
+	// Broadcast self-tests waiting for their probe; see ProbeBroadcast
+	selfTests selfTester
+
+	// Diagnostic echo responder and clients; see Diagnose
+	echoes echoState
+
+	// Source of time for all timers, RealClock unless replaced by SetClock
+	clock Clock
+
+	// Address lookup and reachability check of DialHost
+	resolver Resolver
+	hosts    *HostCache
+	probe    Probe
+
+	// Opens dialed sockets unless they are direct; see SetDialer
+	dialer Dialer
+
+	// Dial attempts and initial backoff when redialing; see SetRedial
+	redialAttempts int
+	redialBackoff  time.Duration
+
+	// Listening connection whose port direct dialed sockets share; see
+	// SetDialOrigin
+	origin *Conn
+
+	// Optional encodings advertised to peers and the incarnation of the
+	// advertisement; see UseEncoding
+	capabilities   Capability
+	capIncarnation uint64
+
+	// Probing of the remote end-point of a dialed socket, nil if disabled,
+	// and the probes waiting for an answer; see SetCapabilityProbe
+	capProbe   *CapabilityProbe
+	capProbing capabilityWaiters
+
+	// Goroutines and timers listed by DebugDump
+	debug debugRegistry
+
+	// Creates the scheduler of outgoing packets for every socket
+	newScheduler func() Scheduler
+
+	// Order of the ports tried by ListenRange
+	portOrder PortOrder
+
+	// Time Relisten keeps reading the old socket and the callbacks it
+	// invokes after the switch
+	relistenGrace time.Duration
+	relistenHooks []func(from, to *net.UDPAddr)
+
+	// Encoding of the messages this connection emits; see SetEncodeVersion
+	encodeVersion WireVersion
+
+	// Generator of all random choices and its seed; see SetSeed
+	seed int64
+	rnd  *rand.Rand
+
+	// One token per running handler goroutine; nil if unlimited
+	handlerSlots chan bool
+	saturation   SaturationPolicy
+
+	// Capacity of the queue between receiving and dispatching, and what
+	// the receiving loop does when it is full
+	queueDepth  int
+	queuePolicy SaturationPolicy
+
+	stats   statsCounter
+	peers   *peerTable
+	talkers *talkerTable
+
+	// Packets from enqueue until they are written or fail; see Quiesced
+	unsent Outstanding
+
+	// Hard caps, see SetLimits
+	limits Limits
+
+	// Suppression of repeated messages per peer, see SetOutboundDedup
+	dedup *outboundDedup
+
+	// Age up to which packets queued at a redial are kept for the next
+	// socket, whether a redial is under way, and the packets kept; see
+	// SetQueueRetention
+	retainAge time.Duration
+	retaining bool
+	retained  []*outgoing
+
+	// Guards state, handlers and every field below which initialize replaces
+	mutex          sync.Mutex
+	state          State
+	disconnecting  bool
+	closeRequested bool
+
+	// Target of DialHost, nil if the socket was opened otherwise, and
+	// the failures to reach it from the current socket
+	host   *hostTarget
+	health *hostHealth
+
+	// Framing of the current socket if it was opened by the dialer
+	tunnel *Tunnel
+
+	sock  *net.UDPConn
+	in    chan *Packet
+	out   chan *outgoing
+	moves chan peerMove
+
+	// Socket the sending loop writes to, swapped by Relisten, and the
+	// sockets it replaced which are still being drained
+	sendSock atomic.Pointer[net.UDPConn]
+	retiring map[*net.UDPConn]bool
+
+	// Queues of the dispatch shards, nil unless SetDispatchShards is used
+	shards []chan shardJob
+
+	// Closed by shutdown to stop the background processes and release
+	// any goroutine blocked on the channels above
+	done     chan bool
+	stopping *sync.Once
+
+	// Tracks the background processes started by spawn
+	running *sync.WaitGroup
+
+	// Closed once the background processes of the current socket run
+	ready *readiness
+
+	// Fatal socket error which initiated the shutdown, if any
+	cause error
+
+	events chan Event
+}
+
+// Create a packet for the destination; the error, if any, is an
+// *AddrError like that of ParseAddr. Hostnames are looked up in
+// DefaultHostCache without waiting, so a name which is not cached yet
+// fails with ErrResolutionPending while it is resolved in the background;
+// retry later, use NewPacketAsync or Prewarm the cache.
+func NewPacket(addr string, msg Message) (*Packet, error) {
+	udpAddr, err := parseAddr(addr, func(host string) ([]net.IP, error) {
+		ips, err := DefaultHostCache.Lookup(host)
+		if err == ErrResolutionPending {
+			return nil, &AddrError{Addr: host, Err: err}
+		}
+		return ips, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{Addr: udpAddr, Msg: msg}, nil
+}
+
+// Create a packet like NewPacket, waiting for the resolution of a cold
+// hostname, and pass it to the callback; from another goroutine if the
+// hostname had to be resolved.
+func NewPacketAsync(addr string, msg Message, callback func(*Packet, error)) {
+	p, err := NewPacket(addr, msg)
+	if !errors.Is(err, ErrResolutionPending) {
+		callback(p, err)
+		return
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	DefaultHostCache.ResolveAsync(host, func([]net.IP, error) {
+		callback(NewPacket(addr, msg))
+	})
+}
+
+// Allocate memory without opening the socket yet.
+func NewConn() *Conn {
+	conn := new(Conn)
+	conn.clock = RealClock
+	conn.datagramSize = MessageSize
+	conn.resolver = net.DefaultResolver
+	conn.hosts = NewHostCache(conn.resolver)
+	conn.newScheduler = NewFIFOScheduler
+	conn.encodeVersion = CurrentWireVersion
+	conn.slowHandler, conn.slowInterval = DefaultSlowHandler, DefaultSlowHandlerInterval
+	conn.seed = randomSeed()
+	conn.rnd = NewRand(conn.seed)
+	conn.peers = newPeerTable(DefaultMaxPeers, DefaultPeerIdle)
+	conn.talkers = newTalkerTable(DefaultTalkerWindow)
+	conn.pathTimeout, conn.pathReprobe = DefaultPathProbeTimeout, DefaultPathReprobe
+	conn.relistenGrace = DefaultRelistenGrace
+	conn.redialAttempts, conn.redialBackoff = DefaultRedialAttempts, DefaultRedialBackoff
+	conn.paths = newPathProber()
+	conn.events = make(chan Event, EventBufferSize)
+	conn.resetHandlers()
+	conn.initialize()
+	return conn
+}
+
+// Replace the clock which drives the timers of this connection.
+// Must be called before the socket is opened.
+func (conn *Conn) SetClock(clock Clock) {
+	conn.clock = clock
+}
+
+// Clock which drives the timers of this connection.
+func (conn *Conn) Clock() Clock {
+	return conn.clock
+}
+
+// Populate Packet.Dst and Packet.IfIndex of incoming packets, so that
+// replies on multi-homed hosts can leave from the address a packet arrived
+// on. Must be called before the socket is opened; opening fails with
+// ErrNotSupported on platforms which cannot report it.
+func (conn *Conn) SetPacketInfo(enabled bool) {
+	conn.packetInfo = enabled
+}
+
+// Timestamp incoming packets in the kernel to exclude scheduling and channel
+// latencies from Packet.Received. Must be called before the socket is opened;
+// on platforms without support packets keep userspace timestamps.
+func (conn *Conn) SetKernelTimestamps(enabled bool) {
+	conn.kernelTime = enabled
+}
+
+// Report ICMP errors such as port unreachable for the destinations of our
+// datagrams right away, as an UnreachableEvent and a *SendError on Err,
+// instead of leaving the silence to timeouts. Only Linux supports this;
+// elsewhere the option has no effect. Must be called before the socket is
+// opened.
+func (conn *Conn) SetICMPErrors(enabled bool) {
+	conn.icmpErrors = enabled
+}
+
+// Fresh Err channel and no handlers, as after Disconnect.
+func (conn *Conn) resetHandlers() {
+	conn.Err = make(chan error, 4)
+	conn.handlers = make([]*registeredHandler, 0, 4)
+	conn.registered = 0
+	conn.resets++
+	conn.rawHandlers = nil
+}
+
+// Allocate memory for the internal data structures of a socket.
+func (conn *Conn) initialize() {
+	conn.in = make(chan *Packet, conn.queueDepth)
+	conn.out = make(chan *outgoing)
+	conn.moves = make(chan peerMove)
+	conn.done = make(chan bool)
+	conn.stopping = new(sync.Once)
+	conn.running = new(sync.WaitGroup)
+	conn.cause = nil
+	conn.sock = nil
+	conn.retiring = make(map[*net.UDPConn]bool)
+	conn.tunnel = nil
+	conn.health = new(hostHealth)
+	conn.ready = newReadiness()
+}
+
+var (
+	ErrAlreadyConnected = errors.New("Socket is already open")
+	ErrClosedConn       = errors.New("Socked has been closed")
+	ErrNilPacket        = errors.New("Encountered nil packet")
+	ErrNotConnected     = errors.New("Socket has not been opened")
+	ErrNotDialed        = errors.New("Socket has no remote end-point")
+	ErrSaturated        = errors.New("Too many handlers are running")
+)
+
+// Listen for incoming packets on the specified localhost port.
+// Call Disconnect to release the underlying resources.
+func (conn *Conn) Listen(port uint) (err error) {
+	// bind to all IP addresses on the system with the specified port
+	var laddr *net.UDPAddr
+	if laddr, err = net.ResolveUDPAddr("udp", ":"+strconv.FormatUint(uint64(port), 10)); err != nil {
+		return err
+	}
+
+	return conn.open(Listening, func() (*net.UDPConn, error) {
+		conn.host, conn.tunnel = nil, nil
+		return net.ListenUDP("udp4", laddr)
+	})
+}
+
+// Establish an unreliable, packet-based connection with the remote end-point.
+// The address is parsed like by ParseAddr, but a hostname is resolved
+// through the connection's HostCache (see Hosts) and Dial gives up with
+// context.DeadlineExceeded once the deadline has passed; the zero time
+// only bounds the resolution by the cache's timeout. Call Disconnect to
+// release the underlying resources.
+//
+// The dialed socket has a port of its own, so peers which only accept
+// traffic from the port a node listens on, e.g. behind a NAT or an ACL,
+// drop what it sends. Send from the listening connection instead with
+// SendTo, or share its port with SetDialOrigin.
+func (conn *Conn) Dial(remoteAddr string, deadline time.Time) (err error) {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	hosts := conn.Hosts()
+	var raddr *net.UDPAddr
+	if raddr, err = parseAddr(remoteAddr, func(host string) ([]net.IP, error) {
+		return hosts.Resolve(ctx, host)
+	}); err != nil {
+		return err
+	}
+
+	return conn.open(Dialed, func() (*net.UDPConn, error) {
+		conn.host = nil
+		return conn.dialUDP("udp4", raddr)
+	})
+}
+
+// Open the socket and start the background processes unless the
+// connection is already in use.
+func (conn *Conn) open(state State, openSocket func() (*net.UDPConn, error)) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if err := conn.checkClosed(); err != nil {
+		return err
+	}
+	sock, err := openSocket()
+	if err != nil {
+		return err
+	}
+	if err = conn.configure(sock); err != nil {
+		sock.Close()
+		if conn.tunnel != nil {
+			conn.tunnel.Close()
+		}
+		return err
+	}
+	conn.sock = sock
+	conn.sendSock.Store(sock)
+	conn.state = state
+	conn.emit(&OpenEvent{state, sock.LocalAddr(), conn.seed})
+	conn.spawn(sock)
+	return nil
+}
+
+// Fails with ErrAlreadyConnected while the socket is open and with
+// ErrClosedConn while it is closing, so that the options read by the
+// background processes stay fixed while they run. Assumes the caller
+// holds the mutex.
+func (conn *Conn) checkClosed() error {
+	switch conn.state {
+	case Listening, Dialed:
+		return ErrAlreadyConnected
+	case Closing:
+		return ErrClosedConn
+	}
+	return nil
+}
+
+// Apply the socket options of the connection to a fresh socket. Assumes
+// the caller holds the mutex.
+func (conn *Conn) configure(sock *net.UDPConn) error {
+	if conn.packetInfo {
+		if err := enablePacketInfo(sock); err != nil {
+			return err
+		}
+	}
+	if conn.kernelTime {
+		// fall back to userspace timestamps where unsupported
+		enableKernelTimestamps(sock)
+	}
+	if conn.icmpErrors {
+		enableICMPErrors(sock)
+	}
+	// without the DF bit a probe proves nothing, so stick to the default
+	conn.pathFallback = conn.pathMTU && enableDontFragment(sock) != nil
+	// isConnReset in the receiving loop covers failures of the ioctl
+	disableConnReset(sock)
+	if conn.broadcast != nil {
+		conn.broadcast.open(sock)
+	}
+	return nil
+}
+
+// Current lifecycle state of the connection.
+func (conn *Conn) State() State {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.state
+}
+
+// Determine if socket has been opened.
+func (conn *Conn) IsConnected() bool {
+	return conn.State().isOpen()
+}
+
+// Release socket and channel resources.
+// Returns once all background processes have terminated. If another
+// goroutine is already disconnecting, this returns immediately.
+func (conn *Conn) Disconnect() {
+	conn.mutex.Lock()
+	if conn.disconnecting {
+		// let a concurrent reset finish the job
+		conn.closeRequested = true
+		conn.mutex.Unlock()
+		return
+	}
+	conn.disconnecting = true
+	conn.state = Closing
+	running := conn.running
+	conn.mutex.Unlock()
+
+	conn.shutdown(nil)
+	running.Wait()
+	conn.failRetained(ErrClosedConn)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.finishDisconnect()
+}
+
+// Same as Disconnect; it implements io.Closer so that a Conn can take
+// part in an ordered shutdown of stacked components.
+func (conn *Conn) Close() error {
+	conn.Disconnect()
+	return nil
+}
+
+// Complete a disconnect once the background processes have terminated.
+// Assumes the caller holds the mutex.
+func (conn *Conn) finishDisconnect() {
+	close(conn.Err)
+	cause := conn.cause
+
+	// be ready for the next connection
+	conn.resetHandlers()
+	conn.initialize()
+	conn.state = Closed
+	conn.disconnecting = false
+	conn.closeRequested = false
+	conn.host = nil
+	conn.emit(&ShutdownEvent{cause})
+}
+
+// Stop the background processes and close the socket like Disconnect,
+// but keep the handlers and Err for the next socket, and return to Idle.
+// Returns false if the connection was not open or Disconnect was called
+// in the meantime, in which case the connection ends up Closed.
+func (conn *Conn) reset() bool {
+	conn.mutex.Lock()
+	if conn.disconnecting || !conn.state.isOpen() {
+		conn.mutex.Unlock()
+		return false
+	}
+	conn.disconnecting = true
+	conn.retaining = conn.retainAge > 0
+	conn.state = Closing
+	running := conn.running
+	conn.mutex.Unlock()
+
+	conn.shutdown(nil)
+	running.Wait()
+
+	conn.mutex.Lock()
+	conn.retaining = false
+	if conn.closeRequested {
+		conn.finishDisconnect()
+		conn.mutex.Unlock()
+		conn.failRetained(ErrClosedConn)
+		return false
+	}
+	defer conn.mutex.Unlock()
+	conn.initialize()
+	conn.state = Idle
+	conn.disconnecting = false
+	return true
+}
+
+// Signal the background processes to terminate and close the socket, without
+// waiting for them. This is the single termination path for user disconnects
+// as well as fatal socket errors, whose cause is recorded for the ShutdownEvent;
+// it may be called more than once.
+func (conn *Conn) shutdown(cause error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.state.isOpen() {
+		conn.state = Closing
+	}
+	conn.stopping.Do(func() {
+		conn.cause = cause
+		close(conn.done)
+		if conn.sock != nil {
+			conn.sock.Close()
+			conn.sock = nil
+		}
+		for sock := range conn.retiring {
+			sock.Close()
+		}
+		if conn.tunnel != nil {
+			conn.tunnel.Close()
+		}
+	})
+}
+
+// Determine if shutdown has been initiated.
+func (conn *Conn) isStopping() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+	}
+	return false
+}
+
+// Send the specified message to the earlier dialed remote end-point.
+// Returns ErrClosedConn if the connection is or gets disconnected
+// before the message could be queued, and ErrNotDialed unless
+// the connection has been established by Dial.
+func (conn *Conn) Send(msg Message) error {
+	return conn.send(msg, nil)
+}
+
+// Send the message to the remote end-point over an unreliable connection.
+// Returns ErrClosedConn if the connection is or gets disconnected
+// before the message could be queued, and an *AddrError wrapping
+// ErrAddressFamilyMismatch for IPv6 destinations.
+func (conn *Conn) SendTo(msg Message, addr *net.UDPAddr) error {
+	return conn.send(msg, addr)
+}
+
+// Queue the message like SendTo without waiting for the outcome. The
+// callback, if not nil, is invoked exactly once: by the sending goroutine
+// after the message has been written (with a nil error) or has failed
+// (with a *SendError), or right away with the error which prevented the
+// message from being queued, e.g. ErrClosedConn during shutdown. The
+// callback must not block since it delays all subsequent packets.
+func (conn *Conn) SendToAsync(msg Message, addr *net.UDPAddr, callback func(error)) {
+	o := newOutgoing(msg, addr)
+	o.done = callback
+	if err := conn.enqueue(o); err != nil && callback != nil {
+		callback(err)
+	}
+}
+
+// Send the message back to the source of an incoming packet. If the packet
+// carries its local end-point (see SetPacketInfo), the reply leaves from
+// that address and interface rather than whichever the kernel would pick.
+// Replies are never suppressed by SetOutboundDedup.
+func (conn *Conn) Reply(p *Packet, msg Message) error {
+	o := newOutgoing(msg, p.Addr)
+	o.meta.Repeat = true
+	if p.Dst != nil {
+		o.src, o.ifIndex = p.Dst.IP, p.IfIndex
+	}
+	return conn.enqueue(o)
+}
+
+// Same as Send; retained for code written against earlier releases.
+func (conn *Conn) Unicast(msg Message) error {
+	return conn.Send(msg)
+}
+
+// Same as SendTo; retained for code written against earlier releases.
+func (conn *Conn) UnicastTo(msg Message, addr *net.UDPAddr) error {
+	return conn.SendTo(msg, addr)
+}
+
+// Packet queued for sending with its optional completion callback
+type outgoing struct {
+	*Packet
+	done func(error)
+
+	// Local address and interface to send from, if not chosen by the kernel
+	src     net.IP
+	ifIndex int
+
+	// Ordering hints for the scheduler; see SendScheduled
+	meta PacketMeta
+
+	// Set if the message is shared with other packets of a Multisend,
+	// which are all carried by batch of an otherwise empty outgoing
+	shared bool
+	batch  []*outgoing
+
+	// Path probes exceed the payload limit on purpose
+	probe bool
+
+	// Raw frames bypass the egress middleware; see SendRaw
+	raw bool
+
+	// Order and time in which the sending loop took the packet, kept
+	// across a redial; only set with SetQueueRetention
+	seq    uint64
+	queued time.Time
+
+	// Pooled envelope the packet was queued in, if any
+	envelope *envelope
+}
+
+// Outgoing packet allocated in one piece with its Packet
+type envelope struct {
+	outgoing
+	packet Packet
+}
+
+var envelopes = sync.Pool{New: func() interface{} { return new(envelope) }}
+
+func newOutgoing(msg Message, addr *net.UDPAddr) *outgoing {
+	e := envelopes.Get().(*envelope)
+	e.packet = Packet{Addr: addr, Msg: msg}
+	e.Packet, e.envelope = &e.packet, e
+	return &e.outgoing
+}
+
+// Return the envelope of a packet which has been written to the pool,
+// unless something else may still refer to the packet.
+func (o *outgoing) recycle() {
+	if e := o.envelope; e != nil {
+		*e = envelope{}
+		envelopes.Put(e)
+	}
+}
+
+// Write message to internal channel which is read by sending().
+// The addr argument may be nil if Dial() has been used to establish the socket.
+// Blocks until the message is queued or the connection shuts down.
+func (conn *Conn) send(msg Message, addr *net.UDPAddr) error {
+	return conn.enqueue(newOutgoing(msg, addr))
+}
+
+func (conn *Conn) enqueue(o *outgoing) error {
+	peerSize := conn.peers.datagramSize(o.Addr)
+	conn.mutex.Lock()
+	state, out, done, dedup := conn.state, conn.out, conn.done, conn.dedup
+	limit := conn.maxPayloadTo(peerSize)
+	if o.raw {
+		limit = conn.peerSize(peerSize)
+		if conn.tunnel != nil {
+			limit -= conn.tunnel.Overhead
+		}
+	}
+	conn.mutex.Unlock()
+	if o.probe {
+		limit = MaxDatagramSize
+	}
+
+	switch {
+	case state == Idle:
+		return ErrNotConnected
+	case !state.isOpen():
+		return ErrClosedConn
+	case o.Addr == nil && state != Dialed:
+		return ErrNotDialed
+	}
+	if err := checkAddr(o.Addr); err != nil {
+		return err
+	}
+	if len(o.Msg) > limit {
+		return &SizeError{len(o.Msg), limit}
+	}
+	now := conn.clock.Now()
+	if !conn.peers.admit(o.Addr, now) {
+		return ErrTooManyPeers
+	}
+	if dedup != nil && !o.meta.Repeat && o.Addr != nil && dedup.suppress(o.Addr, o.Msg, now) {
+		conn.stats.dedupSuppressed()
+		if o.done != nil {
+			o.done(nil)
+		}
+		return nil
+	}
+
+	// counted before the sending loop can complete it
+	if err := conn.admitUnsent(1); err != nil {
+		return err
+	}
+	select {
+	case out <- o:
+		return nil
+	case <-done:
+	}
+	conn.unsent.Done()
+	return ErrClosedConn
+}
+
+// Publish the ICMP errors queued on the socket.
+func (conn *Conn) unreachable(sock *net.UDPConn) {
+	for _, e := range readErrorQueue(sock) {
+		conn.emit(e)
+		if conn.pathTooBig(e) {
+			// a probe found the limit of the path
+			continue
+		}
+		conn.peers.failed(e.Addr, conn.clock.Now())
+		conn.report(&SendError{&Packet{Addr: e.Addr, Msg: e.Msg}, e.Err})
+	}
+}
+
+// Start background processes
+func (conn *Conn) spawn(sock *net.UDPConn) {
+	ready := conn.ready
+	ready.expect(3, &ReadyEvent{sock.LocalAddr()})
+	conn.running.Add(3)
+	conn.spawnShards()
+	conn.spawnRole("sending", func() { conn.sending(sock, ready) })
+	conn.spawnRole("dispatching", func() { conn.dispatching(ready) })
+	conn.spawnRole("receiving", func() { conn.receiving(sock, ready) })
+	if tunnel, host, done := conn.tunnel, conn.host, conn.done; tunnel != nil {
+		conn.spawnRole("tunnel watch", func() { conn.watchTunnel(tunnel, host, done) })
+	}
+	if period, done := conn.pathReprobe, conn.done; conn.pathMTU && !conn.pathFallback {
+		conn.spawnRole("path reprobe", func() { conn.reprobing(period, done) })
+	}
+	if probe, done := conn.capProbe, conn.done; probe != nil && conn.state == Dialed {
+		probe := *probe
+		conn.spawnRole("capability probe", func() { conn.probingCapabilities(probe, done) })
+	}
+}
+
+// Keep on writing outgoing messages to the socket
+func (conn *Conn) sending(sock *net.UDPConn, ready *readiness) {
+	defer conn.running.Done()
+	ready.started(conn)
+
+	// packets without address go to the dialed remote end-point
+	remote, _ := sock.RemoteAddr().(*net.UDPAddr)
+
+	conn.mutex.Lock()
+	host, health, tunnel, maxAge := conn.host, conn.health, conn.tunnel, conn.retainAge
+	conn.mutex.Unlock()
+	if tunnel != nil {
+		remote = tunnel.remote
+	}
+
+	out, moves, done := conn.out, conn.moves, conn.done
+	sched := conn.newScheduler()
+	pending := make(map[*Packet]*outgoing)
+	var seq uint64
+	var admit func(o *outgoing)
+	admit = func(o *outgoing) {
+		if o != nil && o.batch != nil {
+			// Multisend counted the packets of the batch
+			for _, b := range o.batch {
+				admit(b)
+			}
+			return
+		}
+		if o == nil || o.Packet == nil {
+			if o != nil {
+				conn.unsent.Done()
+			}
+			conn.report(ErrNilPacket)
+			return
+		}
+		if o.meta.Peer == nil {
+			o.meta.Peer = o.Addr
+			if o.meta.Peer == nil {
+				o.meta.Peer = remote
+			}
+		}
+		if maxAge > 0 {
+			seq++
+			o.seq = seq
+			if o.queued.IsZero() {
+				o.queued = conn.clock.Now()
+			}
+		}
+		pending[o.Packet] = o
+		sched.Enqueue(o.Packet, o.meta)
+	}
+	// packets left in the scheduler never reach this socket, but may be
+	// kept for the next one
+	defer func() {
+		if conn.retain(pending) {
+			return
+		}
+		for _, o := range pending {
+			if o.done != nil {
+				o.done(ErrClosedConn)
+			}
+			conn.unsent.Done()
+		}
+	}()
+	for _, o := range conn.takeRetained() {
+		if conn.clock.Now().Sub(o.queued) > maxAge {
+			conn.stats.retainedExpired()
+			conn.failed(o, &SendError{o.Packet, ErrExpired})
+			conn.unsent.Done()
+			continue
+		}
+		admit(o)
+	}
+
+	for {
+		select {
+		case m := <-moves:
+			m.apply(pending)
+		default:
+		}
+
+		// take whatever is queued right now so the scheduler can order it
+	drain:
+		for len(pending) < schedulerDepth {
+			select {
+			case o := <-out:
+				admit(o)
+			default:
+				break drain
+			}
+		}
+
+		now := conn.clock.Now()
+		p, wait := sched.Next(now)
+		if p == nil {
+			accept := out
+			if len(pending) >= schedulerDepth {
+				accept = nil
+			}
+			var wake <-chan time.Time
+			stop := func() {}
+			if wait > 0 {
+				wake, stop = conn.after("scheduler wake-up", wait)
+			}
+			select {
+			case o := <-accept:
+				admit(o)
+			case m := <-moves:
+				m.apply(pending)
+			case <-wake:
+			case <-done:
+				stop()
+				return
+			}
+			stop()
+			continue
+		}
+
+		o, ok := pending[p]
+		if !ok {
+			// not ours, or returned twice by the scheduler
+			continue
+		}
+		delete(pending, p)
+		if !o.meta.Deadline.IsZero() && now.After(o.meta.Deadline) {
+			conn.failed(o, &SendError{o.Packet, ErrExpired})
+			conn.unsent.Done()
+			continue
+		}
+
+		// Relisten may have replaced the socket
+		err := conn.writeThrough(conn.sendSock.Load(), remote, tunnel, o)
+		if err != nil && host != nil && o.Addr == nil && !isFatal(err.Err) {
+			conn.hostFailed(host, health)
+		}
+		if err == nil {
+			if o.done != nil {
+				o.done(nil)
+			}
+			conn.unsent.Done()
+			o.recycle()
+			continue
+		}
+		fatal := conn.failed(o, err)
+		conn.unsent.Done()
+		if fatal {
+			return
+		}
+	}
+}
+
+// Hand the failure to the callback of the packet or to Err. Returns true
+// if the socket is dead, after initiating the shutdown unless it is already
+// under way.
+func (conn *Conn) failed(o *outgoing, err *SendError) bool {
+	// only a dead socket terminates the connection; anything else
+	// is reported along with the packet so that it can be retried
+	fatal := isFatal(err.Err)
+	stopping := fatal && conn.isStopping()
+	switch {
+	case o.done != nil && stopping:
+		// the socket has been closed by shutdown underneath us
+		o.done(ErrClosedConn)
+	case o.done != nil:
+		o.done(err)
+	case !stopping:
+		conn.report(err)
+	}
+	if fatal && !stopping {
+		conn.shutdown(err.Err)
+	}
+	return fatal
+}
+
+// Apply the egress middleware and write the resulting packet, if any.
+// Through a tunnel, every packet is wrapped for its destination and
+// written to the connected socket.
+func (conn *Conn) writeThrough(sock *net.UDPConn, remote *net.UDPAddr, tunnel *Tunnel, o *outgoing) *SendError {
+	_, egress := conn.middleware()
+	if o.raw {
+		egress = nil
+	}
+	p := o.Packet
+	if o.shared && len(egress) > 0 {
+		p = &Packet{Addr: p.Addr, Msg: copyMessage(p.Msg)}
+	}
+	if len(egress) > 0 {
+		// middleware may keep the packet
+		o.envelope = nil
+	}
+	p, err := applyMiddleware(egress, p)
+	if err != nil {
+		return &SendError{o.Packet, err}
+	}
+	if p == nil {
+		return nil
+	}
+	if tunnel != nil {
+		dst := p.Addr
+		if dst == nil {
+			dst = remote
+		}
+		return conn.write(sock, dst, &Packet{Msg: tunnel.Wrap(p.Msg, dst)}, o)
+	}
+	return conn.write(sock, remote, p, o)
+}
+
+// Write a single packet to the socket and account for it.
+func (conn *Conn) write(sock *net.UDPConn, remote *net.UDPAddr, p *Packet, o *outgoing) *SendError {
+	var err error
+	dst := p.Addr
+	switch {
+	case dst == nil:
+		dst = remote
+		_, err = sock.Write(p.Msg)
+	case o.src != nil:
+		_, _, err = sock.WriteMsgUDP(p.Msg, sourceControl(o.src, o.ifIndex), p.Addr)
+	default:
+		_, err = sock.WriteTo(p.Msg, p.Addr)
+	}
+
+	if err != nil {
+		conn.peers.failed(dst, conn.clock.Now())
+		return &SendError{p, err}
+	}
+	conn.peers.sent(dst, len(p.Msg), conn.clock.Now())
+	conn.stats.sizeOut(len(p.Msg))
+	return nil
+}
+
+// Keep on reading incoming packets from the socket.
+// The read buffer is owned by this loop and overwritten by every datagram;
+// each Packet handed to dispatching receives its own copy of exactly the
+// bytes which were read, so handlers may keep or modify p.Msg freely.
+func (conn *Conn) receiving(sock *net.UDPConn, ready *readiness) {
+	defer conn.running.Done()
+	if ready != nil {
+		ready.started(conn)
+	}
+
+	in, done := conn.in, conn.done
+	local, _ := sock.LocalAddr().(*net.UDPAddr)
+	remote, _ := sock.RemoteAddr().(*net.UDPAddr)
+
+	conn.mutex.Lock()
+	host, health, tunnel, policy := conn.host, conn.health, conn.tunnel, conn.queuePolicy
+	conn.mutex.Unlock()
+
+	// one spare byte reveals datagrams which do not fit into the buffer
+	size := conn.receiveSize()
+	buff := make([]byte, size+1)
+	if tunnel != nil {
+		remote = tunnel.remote
+		buff = make([]byte, size+1+tunnel.Overhead)
+	}
+	var oob []byte
+	if (conn.packetInfo || conn.kernelTime) && controlSpace > 0 {
+		oob = make([]byte, controlSpace)
+	}
+	for {
+		var msgSize, oobSize int
+		var from netip.AddrPort
+		var err error
+		if oob == nil {
+			msgSize, from, err = sock.ReadFromUDPAddrPort(buff)
+		} else {
+			msgSize, oobSize, _, from, err = sock.ReadMsgUDPAddrPort(buff, oob)
+		}
+		if err != nil && conn.icmpErrors && !isFatal(err) && !conn.isStopping() {
+			// the pending error only signals entries in the error queue
+			conn.unreachable(sock)
+			continue
+		}
+		if err != nil && isConnReset(err) && !conn.isStopping() {
+			// a previous datagram was rejected by its destination
+			continue
+		}
+		if err != nil && remote != nil && isRefused(err) && !conn.isStopping() {
+			// the dialed end-point rejected an earlier datagram
+			conn.peers.failed(remote, conn.clock.Now())
+			conn.report(&SendError{&Packet{Addr: remote}, err})
+			if host != nil {
+				conn.hostFailed(host, health)
+			}
+			continue
+		}
+		if err != nil && conn.retired(sock) {
+			// replaced by Relisten and closed after the grace period
+			return
+		}
+		if err != nil {
+			// closing the socket on shutdown is not an error
+			if !conn.isStopping() {
+				conn.error("conn.receiving(): %s", err.Error())
+				conn.shutdown(err)
+			}
+			return
+		}
+
+		env := new(inbound)
+		addr := env.setAddr(from)
+		data := buff[:msgSize]
+		if tunnel != nil {
+			if data, addr, err = tunnel.Unwrap(data); err != nil {
+				conn.emit(&DropEvent{remote, err})
+				continue
+			}
+			msgSize = len(data)
+		}
+		if msgSize > size {
+			msgSize = size
+			data = data[:msgSize]
+			conn.stats.truncated()
+			conn.emit(&TruncatedEvent{addr, msgSize})
+		}
+
+		now := conn.clock.Now()
+		env.Packet = Packet{Addr: addr, Msg: env.copyMessage(data), Received: now}
+		p := &env.Packet
+		if oobSize > 0 {
+			parseControl(oob[:oobSize], local, p)
+		}
+
+		if !conn.peers.admit(addr, now) {
+			conn.stats.droppedPeerLimit()
+			conn.emit(&DropEvent{addr, ErrTooManyPeers})
+			continue
+		}
+		conn.peers.received(addr, msgSize, now)
+		conn.talkers.received(addr, msgSize, now)
+		conn.stats.sizeIn(msgSize)
+		if host != nil {
+			health.alive()
+		}
+		if policy == DropWhenSaturated {
+			select {
+			case in <- p:
+				conn.stats.queued(len(in))
+			default:
+				conn.stats.droppedQueueFull()
+				conn.emit(&DropEvent{addr, ErrQueueFull})
+			}
+			continue
+		}
+		select {
+		case in <- p:
+			conn.stats.queued(len(in))
+		case <-done:
+			return
+		}
+	}
+}
+
+// Messages up to this size are kept in the envelope of their packet
+const inlineMessage = 64
+
+// Packet read from the socket, allocated in one piece with its source
+// address and, if it is small, its message
+type inbound struct {
+	Packet
+	addr  net.UDPAddr
+	ip    [net.IPv6len]byte
+	small [inlineMessage]byte
+}
+
+// Copy of the message which is owned by the packet.
+func (env *inbound) copyMessage(b []byte) Message {
+	if len(b) > inlineMessage {
+		return copyMessage(b)
+	}
+	n := copy(env.small[:], b)
+	return env.small[:n:n]
+}
+
+// Fill in the source address as ReadFromUDP would and return it.
+func (env *inbound) setAddr(from netip.AddrPort) *net.UDPAddr {
+	ip := from.Addr()
+	if ip.Is4() {
+		*(*[net.IPv4len]byte)(env.ip[:]) = ip.As4()
+		env.addr.IP = env.ip[:net.IPv4len]
+	} else {
+		env.ip = ip.As16()
+		env.addr.IP, env.addr.Zone = env.ip[:], ip.Zone()
+	}
+	env.addr.Port = int(from.Port())
+	return &env.addr
+}
+
+// Keep on dispatching incoming packets to event handlers
+func (conn *Conn) dispatching(ready *readiness) {
+	defer conn.running.Done()
+	ready.started(conn)
+
+	in, done := conn.in, conn.done
+	for {
+		select {
+		case p := <-in:
+			conn.dispatchEvent(p)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Loops through all event handlers and dispatches an incoming packet to them.
+// Each event handler are run in its own goroutine.
+func (conn *Conn) dispatchEvent(p *Packet) {
+	conn.mutex.Lock()
+	handlers, ingress, adapters := conn.handlers, conn.ingress, conn.adapters
+	threshold, interval := conn.slowHandler, conn.slowInterval
+	shards, mirrors, raw := conn.shards, conn.mirrors, conn.rawHandlers
+	slots := slotLimit{conn.handlerSlots, conn.saturation}
+	conn.mutex.Unlock()
+
+	mirror(mirrors, p, true)
+	if conn.rawDispatch(raw, p) {
+		return
+	}
+	q, err := applyMiddleware(ingress, p)
+	if q == nil {
+		if err != nil {
+			conn.emit(&DropEvent{p.Addr, err})
+		}
+		return
+	}
+	p = q
+	if conn.sizeHint(p) || conn.pathProbe(p) || conn.capabilityHint(p) || conn.selfTest(p) || conn.echo(p) {
+		return
+	}
+	mirror(mirrors, p, false)
+	handlers, routed := route(handlers, p)
+	conn.deliverAdapters(adapters, p, len(handlers))
+	if !conn.acquireSlots(slots, len(handlers)) {
+		if !conn.isStopping() {
+			conn.stats.droppedSaturated()
+			conn.emit(&DropEvent{p.Addr, ErrSaturated})
+		}
+		return
+	}
+
+	conn.stats.handlersStarted(len(handlers))
+	if shards != nil {
+		conn.dispatchShard(shards, shardJob{handlers, routed, slots.slots, threshold, interval})
+		return
+	}
+	for _, h := range handlers {
+		j := handlerJobs.Get().(*handlerJob)
+		if j.run == nil {
+			j.run = j.execute
+		}
+		j.conn, j.h, j.p, j.slots, j.threshold, j.interval = conn, h, routed, slots.slots, threshold, interval
+		conn.debug.started("handler")
+		go j.run()
+	}
+}
+
+// Handler invocation on the default dispatch path. Jobs are pooled along
+// with their bound run method, so that starting the goroutine of a
+// handler allocates nothing.
+type handlerJob struct {
+	conn                *Conn
+	h                   *registeredHandler
+	p                   *Packet
+	slots               chan bool
+	threshold, interval time.Duration
+	run                 func()
+}
+
+var handlerJobs = sync.Pool{New: func() interface{} { return new(handlerJob) }}
+
+func (j *handlerJob) execute() {
+	conn := j.conn
+	defer conn.debug.exited("handler")
+	conn.runHandler(j.h, j.p, j.slots, j.threshold, j.interval)
+	*j = handlerJob{run: j.run}
+	handlerJobs.Put(j)
+}
+
+// Invoke the event handler, account for its execution time and release
+// its slot once it returns.
+func (conn *Conn) runHandler(h *registeredHandler, p *Packet, slots chan bool, threshold, interval time.Duration) {
+	defer conn.releaseSlot(slots)
+	if !conn.claim(h, p) {
+		return
+	}
+	start := conn.clock.Now()
+	err := h.f(conn, p)
+	now := conn.clock.Now()
+	if e := h.record(now.Sub(start), now, threshold, interval, p, err); e != nil {
+		conn.emit(e)
+	}
+	if err != nil {
+		conn.mutex.Lock()
+		report := conn.handlerErrors
+		conn.mutex.Unlock()
+		if report {
+			conn.emit(&HandlerErrorEvent{h.snapshot().Name, p.Addr, err})
+		}
+	}
+}
+
+// Handler slots and saturation policy as they were when a packet was
+// dispatched, so that its handlers release the slots they took even if
+// the limit is replaced while they run
+type slotLimit struct {
+	slots  chan bool
+	policy SaturationPolicy
+}
+
+// Reserve n handler slots, all or nothing. Returns false if the packet
+// must be dropped according to the saturation policy.
+func (conn *Conn) acquireSlots(h slotLimit, n int) bool {
+	slots := h.slots
+	if slots == nil {
+		return true
+	}
+
+	for i := 0; i < n; i++ {
+		if h.policy == WaitWhenSaturated {
+			select {
+			case slots <- true:
+				continue
+			case <-conn.done:
+			}
+		} else {
+			select {
+			case slots <- true:
+				continue
+			default:
+			}
+		}
+
+		for ; i > 0; i-- {
+			<-slots
+		}
+		return false
+	}
+	return true
+}
+
+func (conn *Conn) releaseSlot(slots chan bool) {
+	conn.stats.handlerDone()
+	if slots != nil {
+		<-slots
+	}
+}
+
+// Buffer up to depth packets between the receiving loop and dispatching,
+// where zero means the reader hands every packet over directly (the
+// default). A deeper queue absorbs bursts which would otherwise overflow
+// the kernel's receive buffer while the dispatcher waits for handlers.
+// The policy determines whether the reader waits for room or discards
+// packets when the queue is full. Fails with ErrAlreadyConnected unless
+// called before the socket is opened.
+func (conn *Conn) SetDispatchQueue(depth int, policy SaturationPolicy) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if err := conn.checkClosed(); err != nil {
+		return err
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	conn.queueDepth, conn.queuePolicy = depth, policy
+	conn.in = make(chan *Packet, depth)
+	return nil
+}
+
+// Limit the number of concurrently running handler goroutines to max,
+// where zero means unlimited (the default). The policy determines what
+// happens to packets which arrive while the limit is reached.
+// Fails with ErrAlreadyConnected unless called before the socket is opened.
+func (conn *Conn) SetHandlerLimit(max int, policy SaturationPolicy) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if err := conn.checkClosed(); err != nil {
+		return err
+	}
+	conn.setHandlerLimit(max, policy)
+	return nil
+}
+
+// Assumes the caller holds the mutex.
+func (conn *Conn) setHandlerLimit(max int, policy SaturationPolicy) {
+	conn.saturation = policy
+	if max <= 0 {
+		conn.handlerSlots = nil
+	} else {
+		conn.handlerSlots = make(chan bool, max)
+	}
+}
+
+// Registers an event handler which is invoked on incoming packets.
+func (conn *Conn) AddHandler(f EventHandler) {
+	conn.AddNamedHandler("", f)
+}
+
+// Returns a Message which does not share memory with b.
+func copyMessage(b []byte) Message {
+	msg := make(Message, len(b))
+	copy(msg, b)
+	return msg
+}
+
+// Dispatch a human-readable error to the error channel.
+func (conn *Conn) error(s string, a ...interface{}) {
+	conn.report(fmt.Errorf(s, a...))
+}
+
+// Write the error to the error channel unless the connection is shutting
+// down, in which case nobody may be reading it anymore.
+func (conn *Conn) report(err error) {
+	select {
+	case conn.Err <- err:
+	case <-conn.done:
+	}
+}
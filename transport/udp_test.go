@@ -0,0 +1,484 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const expectedRequest = "Hi, I am client!"
+const expectedReply = "Nice to meet you. I am server!"
+
+var reply chan Message
+
+func TestClientServer(t *testing.T) {
+	reply = make(chan Message, 1)
+	defer close(reply)
+
+	const port uint = 9999
+
+	server := startServer(t, port)
+	defer server.Disconnect()
+
+	client := startClient(t, port)
+	defer client.Disconnect()
+
+	msg := <-reply
+	actualReply := string([]byte(msg))
+	if actualReply != expectedReply {
+		t.Fatalf("TestClientServer expected reply %q got %q.", expectedReply, actualReply)
+	}
+}
+
+func TestPeer(t *testing.T) {
+	reply = make(chan Message, 1)
+	defer close(reply)
+
+	peer0 := startPeer(t, 9999)
+	defer peer0.Disconnect()
+	peer0.AddHandler(receiveReply)
+
+	peer1 := startPeer(t, 9988)
+	defer peer1.Disconnect()
+	peer1.AddHandler(sendReply)
+
+	msg := []byte(expectedRequest)
+	peer1Addr, err := net.ResolveUDPAddr("udp", peer1.sock.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("TestPeer could not resolve peer address: %s.", err)
+	}
+	peer0.SendTo(msg, peer1Addr)
+
+	msg = <-reply
+	actualReply := string([]byte(msg))
+	if actualReply != expectedReply {
+		t.Fatalf("TestPeer expected reply %q got %q.", expectedReply, actualReply)
+	}
+}
+
+func TestHandlerLimit(t *testing.T) {
+	const limit = 4
+	const flood = 64
+
+	conn := NewConn()
+	conn.SetHandlerLimit(limit, WaitWhenSaturated)
+
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	done := make(chan bool, flood)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		mutex.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		done <- true
+	})
+
+	for i := 0; i < flood; i++ {
+		conn.dispatchEvent(&Packet{Msg: Message("flood")})
+	}
+	for i := 0; i < flood; i++ {
+		<-done
+	}
+
+	if peak > limit {
+		t.Fatalf("TestHandlerLimit expected at most %d handlers got %d.", limit, peak)
+	}
+	if stats := conn.Stats(); stats.HandlersHighWater != peak || stats.DroppedSaturated != 0 {
+		t.Fatalf("TestHandlerLimit expected high-water mark %d and no drops got %+v.", peak, stats)
+	}
+}
+
+func TestHandlerLimitDrop(t *testing.T) {
+	conn := NewConn()
+	conn.SetHandlerLimit(1, DropWhenSaturated)
+
+	release := make(chan bool)
+	conn.AddHandler(func(conn *Conn, p *Packet) {
+		<-release
+	})
+
+	for i := 0; i < 10; i++ {
+		conn.dispatchEvent(&Packet{Msg: Message("flood")})
+	}
+	stats := conn.Stats()
+	close(release)
+
+	if stats.DroppedSaturated != 9 || stats.HandlersHighWater != 1 {
+		t.Fatalf("TestHandlerLimitDrop expected 9 drops and high-water mark 1 got %+v.", stats)
+	}
+}
+
+func TestSendCancel(t *testing.T) {
+	const senders = 50
+
+	// open the socket without starting the background processes so that
+	// nothing ever drains the outgoing channel
+	conn := NewConn()
+	laddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestSendCancel could not resolve address: %s", err)
+	}
+	if conn.sock, err = net.ListenUDP("udp4", laddr); err != nil {
+		t.Fatalf("TestSendCancel could not open socket: %s", err)
+	}
+	conn.state = Dialed
+
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			errs <- conn.Send([]byte(expectedRequest))
+		}()
+	}
+
+	// give the senders a chance to block
+	time.Sleep(10 * time.Millisecond)
+	conn.Disconnect()
+
+	timeout := time.After(time.Second)
+	for i := 0; i < senders; i++ {
+		select {
+		case err = <-errs:
+			if err != ErrClosedConn {
+				t.Fatalf("TestSendCancel expected %q got %q.", ErrClosedConn, err)
+			}
+		case <-timeout:
+			t.Fatalf("TestSendCancel only %d of %d senders returned.", i, senders)
+		}
+	}
+
+	if err = conn.Send([]byte(expectedRequest)); err != ErrClosedConn {
+		t.Fatalf("TestSendCancel expected %q after disconnect got %q.", ErrClosedConn, err)
+	}
+}
+
+func TestListenDisconnectCycles(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	conn := NewConn()
+	for i := 0; i < 1000; i++ {
+		if err := conn.Listen(0); err != nil {
+			t.Fatalf("TestListenDisconnectCycles cannot listen in cycle %d: %s", i, err)
+		}
+		conn.Disconnect()
+	}
+
+	// terminated goroutines may take a moment to be accounted for
+	for i := 0; i < 100 && runtime.NumGoroutine() > baseline; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("TestListenDisconnectCycles expected %d goroutines got %d.", baseline, n)
+	}
+}
+
+func TestSendDisconnectRace(t *testing.T) {
+	peer := startPeer(t, 0)
+	defer peer.Disconnect()
+	addr := peer.sock.LocalAddr().(*net.UDPAddr)
+
+	for i := 0; i < 20; i++ {
+		conn := NewConn()
+		if err := conn.Listen(0); err != nil {
+			t.Fatalf("TestSendDisconnectRace cannot listen: %s", err)
+		}
+
+		done := make(chan bool)
+		for j := 0; j < 8; j++ {
+			go func() {
+				for {
+					if err := conn.SendTo([]byte(expectedRequest), addr); err != nil {
+						if err != ErrClosedConn {
+							t.Errorf("TestSendDisconnectRace expected %q got %q.", ErrClosedConn, err)
+						}
+						done <- true
+						return
+					}
+				}
+			}()
+		}
+
+		go monitor(conn.Err, t)
+		conn.Disconnect()
+		for j := 0; j < 8; j++ {
+			<-done
+		}
+		if state := conn.State(); state != Closed {
+			t.Fatalf("TestSendDisconnectRace expected state %s got %s.", Closed, state)
+		}
+	}
+}
+
+func TestStateErrors(t *testing.T) {
+	conn := NewConn()
+	if err := conn.Send([]byte(expectedRequest)); err != ErrNotConnected {
+		t.Fatalf("TestStateErrors expected %q got %q.", ErrNotConnected, err)
+	}
+
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestStateErrors cannot listen: %s", err)
+	}
+	if err := conn.Listen(0); err != ErrAlreadyConnected {
+		t.Fatalf("TestStateErrors expected %q got %q.", ErrAlreadyConnected, err)
+	}
+	if err := conn.Send([]byte(expectedRequest)); err != ErrNotDialed {
+		t.Fatalf("TestStateErrors expected %q got %q.", ErrNotDialed, err)
+	}
+
+	conn.Disconnect()
+	if err := conn.Send([]byte(expectedRequest)); err != ErrClosedConn {
+		t.Fatalf("TestStateErrors expected %q got %q.", ErrClosedConn, err)
+	}
+}
+
+func TestSendErrorKeepsConn(t *testing.T) {
+	reply = make(chan Message, 16)
+	defer close(reply)
+
+	good := startPeer(t, 0)
+	defer good.Disconnect()
+	good.AddHandler(receiveReply)
+	goodAddr := good.sock.LocalAddr().(*net.UDPAddr)
+
+	// writing to port zero fails with EINVAL for that packet only
+	badAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+
+	conn := NewConn()
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestSendErrorKeepsConn cannot listen: %s", err)
+	}
+	defer conn.Disconnect()
+
+	const rounds = 5
+	for i := 0; i < rounds; i++ {
+		if err := conn.SendTo([]byte(expectedRequest), badAddr); err != nil {
+			t.Fatalf("TestSendErrorKeepsConn cannot queue packet: %s", err)
+		}
+		if err := conn.SendTo([]byte(expectedRequest), goodAddr); err != nil {
+			t.Fatalf("TestSendErrorKeepsConn cannot queue packet: %s", err)
+		}
+
+		select {
+		case err := <-conn.Err:
+			sendErr, ok := err.(*SendError)
+			if !ok || sendErr.Packet.Addr != badAddr {
+				t.Fatalf("TestSendErrorKeepsConn expected *SendError for %s got %v.", badAddr, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestSendErrorKeepsConn no error reported for %s", badAddr)
+		}
+
+		select {
+		case msg := <-reply:
+			if string(msg) != expectedRequest {
+				t.Fatalf("TestSendErrorKeepsConn expected %q got %q.", expectedRequest, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("TestSendErrorKeepsConn good peer stopped receiving in round %d", i)
+		}
+	}
+
+	if !conn.IsConnected() {
+		t.Fatalf("TestSendErrorKeepsConn expected connection to stay open")
+	}
+}
+
+func TestReceiveNoResidue(t *testing.T) {
+	packets := make(chan *Packet, 2)
+	peer := startPeer(t, 0)
+	defer peer.Disconnect()
+	peer.AddHandler(func(conn *Conn, p *Packet) {
+		packets <- p
+	})
+
+	conn := startClient(t, portOf(peer))
+	defer conn.Disconnect()
+	<-packets
+
+	large := make(Message, MessageSize)
+	for i := range large {
+		large[i] = 'x'
+	}
+	conn.Send(large)
+	if p := <-packets; len(p.Msg) != MessageSize {
+		t.Fatalf("TestReceiveNoResidue expected %d bytes got %d.", MessageSize, len(p.Msg))
+	}
+
+	conn.Send([]byte("hi"))
+	p := <-packets
+	if string(p.Msg) != "hi" {
+		t.Fatalf("TestReceiveNoResidue expected %q got %q.", "hi", p.Msg)
+	}
+	if residue := p.Msg[:cap(p.Msg)]; len(residue) != 2 {
+		t.Fatalf("TestReceiveNoResidue small packet shares the read buffer: %q", residue)
+	}
+}
+
+func TestSendAsyncCompletions(t *testing.T) {
+	const senders = 8
+	const perSender = 200
+
+	peer := startPeer(t, 0)
+	defer peer.Disconnect()
+	addr := peer.sock.LocalAddr().(*net.UDPAddr)
+
+	conn := NewConn()
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestSendAsyncCompletions cannot listen: %s", err)
+	}
+
+	var written, closed, other int64
+	callback := func(err error) {
+		switch err {
+		case nil:
+			atomic.AddInt64(&written, 1)
+		case ErrClosedConn:
+			atomic.AddInt64(&closed, 1)
+		default:
+			atomic.AddInt64(&other, 1)
+		}
+	}
+
+	// keep sending until at least one message was queued after Disconnect
+	var sent int64
+	var wg sync.WaitGroup
+	started := make(chan bool, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, closing := 0, false; ; j++ {
+				if j == perSender/4 {
+					started <- true
+				}
+				conn.SendToAsync([]byte(expectedRequest), addr, callback)
+				atomic.AddInt64(&sent, 1)
+				if closing {
+					return
+				}
+				closing = j >= perSender && conn.State() == Closed
+			}
+		}()
+	}
+	for i := 0; i < senders; i++ {
+		<-started
+	}
+	conn.Disconnect()
+	wg.Wait()
+
+	total := atomic.LoadInt64(&written) + atomic.LoadInt64(&closed) + atomic.LoadInt64(&other)
+	if total != sent {
+		t.Fatalf("TestSendAsyncCompletions expected %d completions got %d.", sent, total)
+	}
+	if written == 0 || closed == 0 || other != 0 {
+		t.Fatalf("TestSendAsyncCompletions expected writes and closed errors only got %d, %d and %d.", written, closed, other)
+	}
+}
+
+func TestSendAsyncFailure(t *testing.T) {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	if err := conn.Listen(0); err != nil {
+		t.Fatalf("TestSendAsyncFailure cannot listen: %s", err)
+	}
+	defer conn.Disconnect()
+
+	errs := make(chan error, 1)
+	badAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	conn.SendToAsync([]byte(expectedRequest), badAddr, func(err error) {
+		errs <- err
+	})
+
+	sendErr, ok := (<-errs).(*SendError)
+	if !ok || sendErr.Packet.Addr != badAddr {
+		t.Fatalf("TestSendAsyncFailure expected *SendError for %s got %v.", badAddr, sendErr)
+	}
+}
+
+// Starts and returns a connector which listens to the specified port on localhost
+func startPeer(t *testing.T, port uint) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	err := conn.Listen(port)
+	if err != nil {
+		t.Fatalf("Cannot start peer: %q", err)
+		return nil
+	}
+	return conn
+}
+
+// Start a server which listens to the specified port on localhost
+func startServer(t *testing.T, port uint) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.AddHandler(sendReply)
+	err := conn.Listen(port)
+	if err != nil {
+		t.Fatalf("Cannot start server: %q", err)
+		return nil
+	}
+	return conn
+}
+
+// Port of a connection listening on an ephemeral port
+func portOf(conn *Conn) uint {
+	return uint(conn.LocalAddr().Port)
+}
+
+// Loopback address of a connection listening on an ephemeral port, for Dial
+func loopbackOf(conn *Conn) string {
+	return fmt.Sprintf("127.0.0.1:%d", portOf(conn))
+}
+
+// Start a client which sends packets to the specified port on localhost
+func startClient(t *testing.T, port uint) *Conn {
+	conn := NewConn()
+	go monitor(conn.Err, t)
+	conn.AddHandler(receiveReply)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	if err := conn.Dial(addr, time.Time{}); err != nil {
+		t.Fatalf("Cannot connect to server: %q", err)
+		return nil
+	}
+
+	msg := []byte(expectedRequest)
+	conn.Send(msg)
+
+	return conn
+}
+
+// Fail all tests if connector encounters error.
+// Since testing.T is thread-safe, this function can be run as a goroutine.
+func monitor(errors <-chan error, t *testing.T) {
+	for err := range errors {
+		t.Errorf("Encountered unexpected error %q", err)
+	}
+}
+
+// Event handler which responds to the sender of an incoming packet with the expected message.
+func sendReply(conn *Conn, p *Packet) {
+	actualRequest := string([]byte(p.Msg))
+	if actualRequest == expectedRequest {
+		conn.SendTo([]byte(expectedReply), p.Addr)
+	} else {
+		conn.SendTo([]byte("Go away!"), p.Addr)
+	}
+}
+
+// Event handler to print the source address of an incoming packet.
+func receiveReply(conn *Conn, p *Packet) {
+	reply <- p.Msg
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"errors"
+
+	"github.com/ahorn/gossip/internal/wire"
+)
+
+// Revision of the encodings of the messages sent by this module
+type WireVersion uint8
+
+const (
+	WireV1 WireVersion = WireVersion(wire.V1)
+
+	// Version emitted unless pinned by SetEncodeVersion
+	CurrentWireVersion = WireVersion(wire.Current)
+)
+
+var ErrWireVersion = errors.New("Wire version is not supported")
+
+// Emit messages in the encoding of an older version, e.g. while a rolling
+// upgrade still has nodes which only decode that one; decoding accepts
+// every supported version regardless. Applies to the headers added by
+// this connection and to the protocols built on top of it which ask
+// EncodeVersion. May be called at any time.
+func (conn *Conn) SetEncodeVersion(v WireVersion) error {
+	if !wire.Version(v).Supported() {
+		return ErrWireVersion
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.encodeVersion = v
+	return nil
+}
+
+// Version in which messages are emitted
+func (conn *Conn) EncodeVersion() WireVersion {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.encodeVersion
+}
@@ -0,0 +1,23 @@
+package transport
+
+import (
+	"testing"
+)
+
+func TestEncodeVersion(t *testing.T) {
+	conn := NewConn()
+	if v := conn.EncodeVersion(); v != CurrentWireVersion {
+		t.Fatalf("TestEncodeVersion expected version %d by default got %d.", CurrentWireVersion, v)
+	}
+	if err := conn.SetEncodeVersion(CurrentWireVersion + 1); err != ErrWireVersion {
+		t.Fatalf("TestEncodeVersion expected %v got %v.", ErrWireVersion, err)
+	}
+	if err := conn.SetEncodeVersion(WireV1); err != nil || conn.EncodeVersion() != WireV1 {
+		t.Fatalf("TestEncodeVersion expected to be pinned to version %d got %d (%v).", WireV1, conn.EncodeVersion(), err)
+	}
+
+	conn, err := NewConnFromConfig(&Config{EncodeVersion: WireV1})
+	if err != nil || conn.EncodeVersion() != WireV1 {
+		t.Fatalf("TestEncodeVersion expected the configured version got %v.", err)
+	}
+}
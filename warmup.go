@@ -0,0 +1,155 @@
+package gossip
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+// Warm-up of a node which joined within an anti-entropy interval
+const DefaultWarmup = 30 * time.Second
+
+// Why a warm-up ended
+type WarmupEnd int
+
+const (
+	// The period elapsed before a full sync completed
+	WarmupExpired WarmupEnd = iota
+	// The first full anti-entropy round completed
+	WarmupSynced
+)
+
+func (e WarmupEnd) String() string {
+	switch e {
+	case WarmupExpired:
+		return "expired"
+	case WarmupSynced:
+		return "synced"
+	}
+	return "unknown"
+}
+
+type WarmupStats struct {
+	Active bool
+	// Suspicions the node did not originate while warming up
+	Suppressed uint64
+}
+
+// Keeps a freshly joined node from declaring members suspect before its
+// member list has settled, which otherwise starts churn storms: the node
+// probes as usual, and merges gossip from others including their
+// suspicions, but originates none of its own until the warm-up period
+// has elapsed or the first full anti-entropy round has completed,
+// whichever comes first.
+type Warmup struct {
+	period time.Duration
+	clock  transport.Clock
+
+	// Called once when the warm-up ends, outside the lock, with the reason
+	// and the time it took
+	OnEnd func(reason WarmupEnd, after time.Duration)
+
+	mutex   sync.Mutex
+	started time.Time
+	ended   bool
+	// closed when the warm-up ends
+	over  chan bool
+	stats WarmupStats
+}
+
+// Create a warm-up of the period, DefaultWarmup if zero; it starts with
+// Start.
+func NewWarmup(period time.Duration) *Warmup {
+	if period == 0 {
+		period = DefaultWarmup
+	}
+	return &Warmup{period: period, clock: transport.RealClock, over: make(chan bool)}
+}
+
+// Replace the source of time used by Start, Active and Run.
+func (w *Warmup) SetClock(clock transport.Clock) {
+	w.clock = clock
+}
+
+// Begin the warm-up, e.g. right after joining.
+func (w *Warmup) Start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.started = w.clock.Now()
+	w.stats.Active = true
+}
+
+// Whether the node is still warming up; it ends here if the period has
+// elapsed.
+func (w *Warmup) Active() bool {
+	w.mutex.Lock()
+	active := w.stats.Active && w.clock.Now().Before(w.started.Add(w.period))
+	w.mutex.Unlock()
+	if !active {
+		w.end(WarmupExpired)
+	}
+	return active
+}
+
+// Report the completion of a full anti-entropy round, which ends the
+// warm-up.
+func (w *Warmup) SyncCompleted() {
+	w.end(WarmupSynced)
+}
+
+// Mark the member suspect in the table at its current incarnation unless
+// the node is warming up; returns whether it did. Failure detectors call
+// this in place of MemberTable.Update for the suspicions they originate.
+func (w *Warmup) Suspect(table *MemberTable, name string) bool {
+	if w.Active() {
+		w.mutex.Lock()
+		w.stats.Suppressed++
+		w.mutex.Unlock()
+		return false
+	}
+	m, ok := table.Get(name)
+	if !ok || m.State != MemberAlive {
+		return false
+	}
+	m.State = MemberSuspect
+	return table.Update(m)
+}
+
+func (w *Warmup) Stats() WarmupStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.stats
+}
+
+// End the warm-up started before once the period elapsed, so that OnEnd
+// is called on time, unless a sync or done comes first.
+func (w *Warmup) Run(done <-chan bool) {
+	w.mutex.Lock()
+	remaining := w.started.Add(w.period).Sub(w.clock.Now())
+	w.mutex.Unlock()
+
+	select {
+	case <-w.clock.After(remaining):
+		w.end(WarmupExpired)
+	case <-w.over:
+	case <-done:
+	}
+}
+
+func (w *Warmup) end(reason WarmupEnd) {
+	w.mutex.Lock()
+	if w.ended || !w.stats.Active {
+		w.mutex.Unlock()
+		return
+	}
+	w.ended, w.stats.Active = true, false
+	after := w.clock.Now().Sub(w.started)
+	close(w.over)
+	onEnd := w.OnEnd
+	w.mutex.Unlock()
+
+	if onEnd != nil {
+		onEnd(reason, after)
+	}
+}
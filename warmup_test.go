@@ -0,0 +1,99 @@
+package gossip
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ahorn/gossip/transport"
+)
+
+func TestWarmupSuppressesSuspicion(t *testing.T) {
+	const members, loss = 30, 0.1
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	table, _ := NewMemberTable(ReapPolicy{})
+	table.SetClock(clock)
+	for i := 0; i < members; i++ {
+		table.Update(Member{Name: fmt.Sprintf("node%d", i), Incarnation: 1})
+	}
+	w := NewWarmup(time.Minute)
+	w.SetClock(clock)
+	var ends []WarmupEnd
+	w.OnEnd = func(reason WarmupEnd, after time.Duration) { ends = append(ends, reason) }
+	w.Start()
+
+	// the newcomer probes every member once a second and loses a tenth
+	rnd := rand.New(rand.NewSource(1))
+	probe := func() (suspected int) {
+		for i := 0; i < members; i++ {
+			if rnd.Float64() < loss && w.Suspect(table, fmt.Sprintf("node%d", i)) {
+				suspected++
+			}
+		}
+		return suspected
+	}
+	for round := 0; round < 20; round++ {
+		if n := probe(); n != 0 {
+			t.Fatalf("TestWarmupSuppressesSuspicion expected no suspicion during warm-up got %d in round %d.", n, round)
+		}
+		clock.Advance(time.Second)
+	}
+	if s := w.Stats(); !s.Active || s.Suppressed == 0 {
+		t.Fatalf("TestWarmupSuppressesSuspicion expected suppressed suspicions got %+v.", s)
+	}
+
+	// gossip from the others is merged as usual
+	if !table.Update(Member{Name: "node0", State: MemberSuspect, Incarnation: 1}) {
+		t.Fatalf("TestWarmupSuppressesSuspicion expected inbound suspicion to be merged.")
+	}
+	table.Update(Member{Name: "node0", Incarnation: 2})
+
+	w.SyncCompleted()
+	w.SyncCompleted()
+	if len(ends) != 1 || ends[0] != WarmupSynced || w.Active() {
+		t.Fatalf("TestWarmupSuppressesSuspicion expected one end after the sync got %v.", ends)
+	}
+	suspected := 0
+	for round := 0; round < 5; round++ {
+		suspected += probe()
+	}
+	if suspected == 0 {
+		t.Fatalf("TestWarmupSuppressesSuspicion expected suspicions after the warm-up.")
+	}
+}
+
+func TestWarmupExpires(t *testing.T) {
+	clock := transport.NewManualClock(time.Unix(0, 0))
+	w := NewWarmup(10 * time.Second)
+	w.SetClock(clock)
+	ended := make(chan time.Duration, 1)
+	w.OnEnd = func(reason WarmupEnd, after time.Duration) {
+		if reason == WarmupExpired {
+			ended <- after
+		}
+	}
+	w.Start()
+	done := make(chan bool)
+	defer close(done)
+	go w.Run(done)
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(9 * time.Second)
+	if !w.Active() {
+		t.Fatalf("TestWarmupExpires expected the warm-up to last the period.")
+	}
+	clock.Advance(time.Second)
+	select {
+	case after := <-ended:
+		if after != 10*time.Second {
+			t.Fatalf("TestWarmupExpires expected the warm-up to end after 10s got %s.", after)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestWarmupExpires expected an end event.")
+	}
+	if w.Active() {
+		t.Fatalf("TestWarmupExpires expected the warm-up to be over.")
+	}
+}